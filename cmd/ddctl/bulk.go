@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ddd/internal/api/client"
+	"ddd/internal/blocker"
+	"ddd/sdk"
+)
+
+// bulkBatch is the most entries ddctl sends in one bulk request, the
+// server's limit
+const bulkBatch = 10000
+
+// cmdBulk blocks, unblocks or allowlists the addresses and CIDRs of a file
+// with one per line, in as few requests as the server allows, and prints
+// the entries that failed and the exemptions created
+func cmdBulk(ctx context.Context, c *client.Client, args []string) error {
+	const usage = "usage: bulk block <file> [reason...] | bulk unblock <file> | bulk allowlist <file> <duration> <requester> [note...]"
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+
+	var (
+		req  sdk.BulkRequest
+		send func(context.Context, sdk.BulkRequest) (*sdk.BulkResponse, error)
+	)
+	switch args[0] {
+	case "block":
+		req.Reason = strings.Join(args[2:], " ")
+		send = c.BulkBlock
+	case "unblock":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		send = c.BulkUnblock
+	case "allowlist":
+		if len(args) < 4 {
+			return errors.New(usage)
+		}
+		if _, err := time.ParseDuration(args[2]); err != nil {
+			return fmt.Errorf("invalid duration %q", args[2])
+		}
+		req.Duration, req.RequestedBy, req.Reason = args[2], args[3], strings.Join(args[4:], " ")
+		send = c.BulkAllowlist
+	default:
+		return errors.New(usage)
+	}
+
+	entries, err := blocker.LoadBlocklist(args[1])
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s: no entries", args[1])
+	}
+
+	var succeeded, failed int
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tRESULT")
+	for start := 0; start < len(entries); start += bulkBatch {
+		req.Entries = req.Entries[:0]
+		for _, e := range entries[start:min(start+bulkBatch, len(entries))] {
+			req.Entries = append(req.Entries, sdk.BulkEntry{IP: e})
+		}
+		resp, err := send(ctx, req)
+		if err != nil {
+			tw.Flush()
+			return fmt.Errorf("after %d entries: %w", start, err)
+		}
+		succeeded += resp.Succeeded
+		failed += resp.Failed
+		for _, r := range resp.Results {
+			switch {
+			case !r.OK:
+				fmt.Fprintf(tw, "%s\t%s\n", r.IP, r.Error)
+			case r.ExemptionID != 0:
+				fmt.Fprintf(tw, "%s\texemption %d %s\n", r.IP, r.ExemptionID, r.ExemptionState)
+			}
+		}
+	}
+	tw.Flush()
+	fmt.Printf("%d succeeded, %d failed\n", succeeded, failed)
+	return nil
+}
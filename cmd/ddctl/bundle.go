@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"ddd/internal/api/client"
+)
+
+// bundleFile is one file of a support bundle and how to fetch it
+type bundleFile struct {
+	name  string
+	fetch func(ctx context.Context, c *client.Client) ([]byte, error)
+}
+
+// cmdExportSupportBundle gathers what a bug report needs into one
+// tarball. Each file is fetched on its own: whatever fails is listed in
+// errors.txt rather than failing the bundle, since a struggling server is
+// when one is wanted most.
+func cmdExportSupportBundle(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("export-support-bundle", flag.ContinueOnError)
+	out := fs.String("o", "", "Output file (default ddd-support-<time>.tar.gz)")
+	lines := fs.Int("lines", 0, "Log lines to include (default the server's, 1000)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	now := time.Now()
+	if *out == "" {
+		*out = "ddd-support-" + now.UTC().Format("20060102T150405Z") + ".tar.gz"
+	}
+
+	files := []bundleFile{
+		{"version.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetVersion(ctx))
+		}},
+		{"config.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetConfig(ctx))
+		}},
+		{"stats.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetStats(ctx))
+		}},
+		{"upstreams.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetUpstreams(ctx))
+		}},
+		{"panic.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetPanic(ctx))
+		}},
+		{"ratelimits.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetRateLimits(ctx))
+		}},
+		{"blocks.json", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return jsonOf(c.GetBlockFeed(ctx, 0, 0))
+		}},
+		{"metrics.txt", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			text, err := c.GetMetrics(ctx)
+			return []byte(text), err
+		}},
+		{"logs/ddd.log", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			text, err := c.GetLogs(ctx, *lines)
+			return []byte(text), err
+		}},
+		{"profiles/goroutine.txt", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return c.GetProfile(ctx, "goroutine", 2)
+		}},
+		{"profiles/heap.pb.gz", func(ctx context.Context, c *client.Client) ([]byte, error) {
+			return c.GetProfile(ctx, "heap", 0)
+		}},
+	}
+
+	// Logs and stats name clients, so keep the bundle private
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var failures []string
+	for _, bf := range files {
+		data, err := bf.fetch(ctx, c)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", bf.name, err))
+			continue
+		}
+		if err := writeTarFile(tw, bf.name, data, now); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if len(failures) > 0 {
+		if err := writeTarFile(tw, "errors.txt", []byte(strings.Join(failures, "\n")+"\n"), now); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s (%d of %d files)\n", *out, len(files)-len(failures), len(files))
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "  missing %s\n", failure)
+	}
+	return nil
+}
+
+// jsonOf encodes a client getter's result as indented JSON
+func jsonOf(v interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeTarFile adds a regular file to tw under the bundle's directory
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    "ddd-support/" + name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
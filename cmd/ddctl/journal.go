@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"ddd/internal/api/client"
+	"ddd/internal/config"
+	"ddd/internal/journal"
+)
+
+// cmdJournal runs decision journal tooling. It works on local files and
+// does not contact the server.
+func cmdJournal(ctx context.Context, c *client.Client, args []string) error {
+	const usage = "usage: ddctl journal verify [-key secret] <file>"
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New(usage)
+	}
+
+	fs := flag.NewFlagSet("journal verify", flag.ContinueOnError)
+	keyRef := fs.String("key", "", "journal.key of the server: the key, or a file:// or env:// reference")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+
+	key, err := config.ResolveSecret(*keyRef)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := journal.Verify(f, []byte(key))
+	if err != nil {
+		return fmt.Errorf("%s: %w (%d entries verified before it)", fs.Arg(0), err, n)
+	}
+	fmt.Printf("%s: %d entries verified\n", fs.Arg(0), n)
+	return nil
+}
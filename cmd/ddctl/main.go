@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ddd/internal/api/client"
+	"ddd/internal/blocker"
+	"ddd/internal/dns"
+	"ddd/internal/eventfeed"
+	"ddd/internal/events"
+)
+
+// commands maps each subcommand to its implementation
+var commands = map[string]func(ctx context.Context, c *client.Client, args []string) error{
+	"allclear":  cmdAllClear,
+	"bulk":      cmdBulk,
+	"cluster":   cmdCluster,
+	"config":    cmdConfig,
+	"events":    cmdEvents,
+	"exempt":    cmdExempt,
+	"geo":       cmdGeo,
+	"grant":     cmdGrant,
+	"history":   cmdHistory,
+	"journal":   cmdJournal,
+	"listeners": cmdListeners,
+	"metrics":   cmdMetrics,
+	"panic":     cmdPanic,
+	"ratelimit": cmdRateLimit,
+	"replay":    cmdReplay,
+	"rules":     cmdRules,
+	"stats":     cmdStats,
+	"top":       cmdTop,
+	"trace":     cmdTrace,
+	"upstreams": cmdUpstreams,
+	"watch":     cmdWatch,
+
+	"export-support-bundle": cmdExportSupportBundle,
+}
+
+func main() {
+	addr := flag.String("addr", envOr("DDD_API_ADDR", "http://127.0.0.1:8080"), "Admin API base URL")
+	token := flag.String("token", os.Getenv("DDD_API_TOKEN"), "Admin API bearer token")
+	timeout := flag.Duration("timeout", 10*time.Second, "Request timeout")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ddctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := cmd(ctx, client.New(*addr, *token), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ddctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the command line help
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: ddctl [options] <command>
+
+Commands:
+  allclear   Clear panic mode
+  bulk block <file> [reason...]
+  bulk unblock <file>
+  bulk allowlist <file> <duration> <requester> [note...]
+             Block, unblock or exempt from rate limiting the addresses and
+             CIDRs of a file, one per line, and show the ones that failed
+  cluster    Show stats merged across the server and its federation peers
+  config     Show the server's effective configuration
+  events [since] [type]
+             Show the events the server holds, across the cluster on a
+             read replica (default 1h)
+  events summary [since]
+             Show event counts by type and node with the most flagged
+             clients and attacked domains (default 1h)
+  exempt [list]
+             Show the pending and active rate limit exemptions
+  exempt <cidr> <duration> <requester> [note...]
+             Exempt an address or network from rate limiting and
+             detection for duration; pending approval when required
+  exempt approve <id> <approver>
+             Approve another operator's pending exemption
+  exempt revoke <id>
+             End an exemption early
+  export-support-bundle [-o file] [-lines n]
+             Write a tarball for bug reports: version, redacted config,
+             stats, upstreams, blocks, rate limits, panic status,
+             metrics, recent logs and goroutine and heap profiles
+  geo [since]
+             Show query and attack counts by country and ASN (default 1h)
+  grant [list]
+             Show the scheduled and running budget grants
+  grant <cidr> <factor> <start|now> <duration> <job> <requester> [note...]
+             Multiply a network's rate and new client budgets by factor
+             for a batch job's window (start in RFC 3339) and print the
+             grant's token, which is not shown again
+  grant revoke <id>
+             End a budget grant early
+  grant release
+             Give back the grant whose token is passed as -token, for
+             jobs that finish early
+  history <ip> [since]
+             Show a client's requests, new domains and failures per minute
+             from the history archive (default 24h)
+  journal verify [-key secret] <file>
+             Check a decision journal's hash chain for edited, removed or
+             reordered entries
+  listeners [start|stop <protocol@address>]
+             Show whether each DNS listener is serving, or start or stop
+             one while the others keep serving
+  metrics    Show the server's Prometheus metrics
+  panic [reason...]
+             Engage panic mode: a global query cap, known clients only and
+             answers from cache only until allclear
+  panic status
+             Show whether panic mode is engaged
+  ratelimit [list]
+             Show the manual rate limits in force
+  ratelimit <ip> <qps> [window] [reason...]
+             Refuse queries from ip beyond qps per second for window
+             (default 1h), without blocking it
+  ratelimit lift <ip>
+             Lift ip's manual rate limit
+  replay <trace-file> [time]
+             Re-run detection on a saved trace as the client's traffic
+             stood at time (RFC 3339, or a duration before the trace was
+             dumped; default when it was dumped) and show why it fired
+  rules test [-geoip file] <rules> <query-log>
+             Evaluate firewall rules (a config file or one rule per line)
+             against a server log and report what each rule matches
+  stats      Show the server's QPS, blocks and top talkers
+  top [n]    Show the n most queried domains (default 100)
+  trace <ip> Print the event stream the server holds for a client as
+             JSON, for replay
+  upstreams  Show each upstream's health, QPS, error rate, latency
+             percentiles and circuit breaker state
+  watch      Show each watched domain's resolution success rate, latency
+             and whether it is degraded
+
+Options:
+`)
+	flag.PrintDefaults()
+}
+
+// cmdConfig prints the effective configuration as indented JSON
+func cmdConfig(ctx context.Context, c *client.Client, args []string) error {
+	cfg, err := c.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(cfg)
+}
+
+// cmdEvents prints recent events as a table, or their summary as
+// indented JSON
+func cmdEvents(ctx context.Context, c *client.Client, args []string) error {
+	summary := len(args) > 0 && args[0] == "summary"
+	if summary {
+		args = args[1:]
+	}
+	var since time.Duration
+	if len(args) > 0 {
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		since = d
+	}
+
+	if summary {
+		s, err := c.GetEventSummary(ctx, since)
+		if err != nil {
+			return err
+		}
+		return printJSON(s)
+	}
+
+	f := eventfeed.Filter{}
+	if since > 0 {
+		f.Since = time.Now().Add(-since)
+	}
+	if len(args) > 1 {
+		f.Type = events.Type(args[1])
+	}
+	records, err := c.GetEvents(ctx, f)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tNODE\tTYPE\tSUBJECT\tREASON")
+	for _, r := range records {
+		subject := r.IP
+		if subject == "" {
+			subject = r.Domain
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Time.Local().Format("2006-01-02 15:04:05"), r.Instance, r.Type, subject, r.Reason)
+	}
+	return tw.Flush()
+}
+
+// cmdGeo prints the query geography heat map as indented JSON
+func cmdGeo(ctx context.Context, c *client.Client, args []string) error {
+	var since time.Duration
+	if len(args) > 0 {
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		since = d
+	}
+
+	snap, err := c.GetGeo(ctx, since)
+	if err != nil {
+		return err
+	}
+	return printJSON(snap)
+}
+
+// cmdHistory prints a client's per-minute traffic as a table
+func cmdHistory(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: history <ip> [since]")
+	}
+	var since time.Duration
+	if len(args) > 1 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+		since = d
+	}
+
+	h, err := c.GetHistory(ctx, args[0], since)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MINUTE\tREQUESTS\tNEW DOMAINS\tFAILURES")
+	for _, m := range h.Minutes {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", m.Minute.Local().Format("2006-01-02 15:04"), m.Requests, m.NewDomains, m.Failures)
+	}
+	return tw.Flush()
+}
+
+// cmdTop prints the most queried domains with their decayed counts
+func cmdTop(ctx context.Context, c *client.Client, args []string) error {
+	var limit int
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		limit = n
+	}
+
+	domains, err := c.GetTopDomains(ctx, limit)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tSCORE")
+	for _, d := range domains {
+		fmt.Fprintf(tw, "%s\t%.1f\n", d.Name, d.Score)
+	}
+	return tw.Flush()
+}
+
+// cmdPanic engages panic mode, or with "status" shows it
+func cmdPanic(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 1 && args[0] == "status" {
+		status, err := c.GetPanic(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(status)
+	}
+
+	status, err := c.EngagePanic(ctx, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	return printJSON(status)
+}
+
+// cmdAllClear clears panic mode
+func cmdAllClear(ctx context.Context, c *client.Client, args []string) error {
+	status, err := c.ClearPanic(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(status)
+}
+
+// cmdRateLimit lists, applies or lifts manual rate limits
+func cmdRateLimit(ctx context.Context, c *client.Client, args []string) error {
+	var (
+		limits []blocker.ManualLimit
+		err    error
+	)
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+		limits, err = c.GetRateLimits(ctx)
+	case args[0] == "lift":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: ratelimit lift <ip>")
+		}
+		limits, err = c.LiftRateLimit(ctx, args[1])
+	default:
+		if len(args) < 2 {
+			return fmt.Errorf("usage: ratelimit <ip> <qps> [window] [reason...]")
+		}
+		qps, perr := strconv.ParseFloat(args[1], 64)
+		if perr != nil {
+			return fmt.Errorf("invalid qps %q", args[1])
+		}
+		var window time.Duration
+		rest := args[2:]
+		if len(rest) > 0 {
+			if d, derr := time.ParseDuration(rest[0]); derr == nil {
+				window, rest = d, rest[1:]
+			}
+		}
+		var limit *blocker.ManualLimit
+		if limit, err = c.ApplyRateLimit(ctx, args[0], qps, window, strings.Join(rest, " ")); err == nil {
+			limits = []blocker.ManualLimit{*limit}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tQPS\tUNTIL\tREASON")
+	for _, l := range limits {
+		fmt.Fprintf(tw, "%s\t%g\t%s\t%s\n", l.IP, l.QPS, l.Until.Local().Format(time.DateTime), l.Reason)
+	}
+	return tw.Flush()
+}
+
+// cmdExempt lists, requests, approves or revokes rate limit exemptions
+// and prints the result as a table
+func cmdExempt(ctx context.Context, c *client.Client, args []string) error {
+	var (
+		exemptions []blocker.Exemption
+		e          *blocker.Exemption
+		err        error
+	)
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+		exemptions, err = c.GetExemptions(ctx)
+	case args[0] == "approve":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: exempt approve <id> <approver>")
+		}
+		id, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid exemption id %q", args[1])
+		}
+		e, err = c.ApproveExemption(ctx, id, args[2])
+	case args[0] == "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: exempt revoke <id>")
+		}
+		id, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid exemption id %q", args[1])
+		}
+		exemptions, err = c.RevokeExemption(ctx, id)
+	default:
+		if len(args) < 3 {
+			return fmt.Errorf("usage: exempt <cidr> <duration> <requester> [note...]")
+		}
+		d, perr := time.ParseDuration(args[1])
+		if perr != nil {
+			return fmt.Errorf("invalid duration %q", args[1])
+		}
+		e, err = c.RequestExemption(ctx, args[0], d, args[2], strings.Join(args[3:], " "))
+	}
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		exemptions = []blocker.Exemption{*e}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCIDR\tSTATE\tUNTIL\tREQUESTED BY\tAPPROVED BY\tNOTE")
+	for _, x := range exemptions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", x.ID, x.CIDR, x.State,
+			x.Until.Local().Format(time.DateTime), x.RequestedBy, x.ApprovedBy, x.Note)
+	}
+	return tw.Flush()
+}
+
+// cmdGrant lists, issues, revokes or releases budget grants and prints
+// the result as a table
+func cmdGrant(ctx context.Context, c *client.Client, args []string) error {
+	var (
+		grants []blocker.Grant
+		g      *blocker.Grant
+		token  string
+		err    error
+	)
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+		grants, err = c.GetGrants(ctx)
+	case args[0] == "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: grant revoke <id>")
+		}
+		id, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid grant id %q", args[1])
+		}
+		grants, err = c.RevokeGrant(ctx, id)
+	case args[0] == "release":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: ddctl -token <grant-token> grant release")
+		}
+		g, err = c.ReleaseGrant(ctx)
+	default:
+		if len(args) < 6 {
+			return fmt.Errorf("usage: grant <cidr> <factor> <start|now> <duration> <job> <requester> [note...]")
+		}
+		factor, perr := strconv.ParseFloat(args[1], 64)
+		if perr != nil {
+			return fmt.Errorf("invalid factor %q", args[1])
+		}
+		var start time.Time
+		if args[2] != "now" {
+			if start, perr = time.Parse(time.RFC3339, args[2]); perr != nil {
+				return fmt.Errorf("invalid start %q", args[2])
+			}
+		}
+		d, perr := time.ParseDuration(args[3])
+		if perr != nil {
+			return fmt.Errorf("invalid duration %q", args[3])
+		}
+		var issued *blocker.IssuedGrant
+		issued, err = c.IssueGrant(ctx, args[0], factor, start, d, args[4], args[5], strings.Join(args[6:], " "))
+		if issued != nil {
+			g, token = &issued.Grant, issued.Token
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if g != nil {
+		grants = []blocker.Grant{*g}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCIDR\tFACTOR\tSTART\tUNTIL\tJOB\tREQUESTED BY\tNOTE")
+	for _, x := range grants {
+		fmt.Fprintf(tw, "%d\t%s\t%g\t%s\t%s\t%s\t%s\t%s\n", x.ID, x.CIDR, x.Factor,
+			x.Start.Local().Format(time.DateTime), x.Until.Local().Format(time.DateTime), x.Job, x.RequestedBy, x.Note)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if token != "" {
+		fmt.Printf("\nToken (shown once): %s\n", token)
+	}
+	return nil
+}
+
+// cmdStats prints the server's own stats snapshot as indented JSON
+func cmdStats(ctx context.Context, c *client.Client, args []string) error {
+	snap, err := c.GetStats(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(snap)
+}
+
+// cmdUpstreams prints the health of each upstream resolver as a table
+func cmdUpstreams(ctx context.Context, c *client.Client, args []string) error {
+	stats, err := c.GetUpstreams(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UPSTREAM\tHEALTHY\tBREAKER\tQPS\tERRORS\tSERVFAIL\tP50\tP90\tP99\tLAST ERROR")
+	for _, u := range stats {
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%.1f\t%.1f%%\t%.1f%%\t%.1fms\t%.1fms\t%.1fms\t%s\n",
+			u.Address, u.Healthy, u.Breaker, u.QPS, u.ErrorRate*100, u.ServfailRate*100,
+			u.LatencyP50, u.LatencyP90, u.LatencyP99, u.LastError)
+	}
+	return tw.Flush()
+}
+
+// cmdListeners prints the state of each DNS listener as a table, after
+// starting or stopping one
+func cmdListeners(ctx context.Context, c *client.Client, args []string) error {
+	var (
+		listeners []dns.ListenerStatus
+		err       error
+	)
+	switch {
+	case len(args) == 0:
+		listeners, err = c.GetListeners(ctx)
+	case len(args) == 2 && args[0] == "start":
+		listeners, err = c.StartListener(ctx, args[1])
+	case len(args) == 2 && args[0] == "stop":
+		listeners, err = c.StopListener(ctx, args[1])
+	default:
+		return fmt.Errorf("usage: listeners [start|stop <protocol@address>]")
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LISTENER\tPROTOCOL\tRUNNING\tERROR")
+	for _, l := range listeners {
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", l.Name, l.Protocol, l.Running, l.Error)
+	}
+	return tw.Flush()
+}
+
+// cmdWatch prints the health of the watched domains as a table
+func cmdWatch(ctx context.Context, c *client.Client, args []string) error {
+	status, err := c.GetWatch(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tSTATE\tRESOLUTIONS\tSUCCESS\tP50\tP95\tREASON")
+	for _, d := range status {
+		state := "ok"
+		if d.Degraded {
+			state = "degraded since " + d.Since.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f%%\t%.1fms\t%.1fms\t%s\n",
+			d.Domain, state, d.Resolutions, d.SuccessRate*100, d.LatencyP50, d.LatencyP95, d.Reason)
+	}
+	return tw.Flush()
+}
+
+// cmdCluster prints the cluster view as tables of nodes, blocks and top
+// talkers
+func cmdCluster(ctx context.Context, c *client.Client, args []string) error {
+	view, err := c.GetClusterStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tQPS\tQUERIES\tBLOCKS\tERROR")
+	for _, n := range view.Nodes {
+		fmt.Fprintf(tw, "%s\t%.1f\t%d\t%d\t%s\n", n.Node, n.QPS, n.QueriesTotal, n.Blocks, n.Error)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%.1f\t%d\t%d\t\n", view.QPS, view.QueriesTotal, len(view.Blocks))
+
+	fmt.Fprintln(tw, "\nBLOCKED\tREASON\tUNTIL\tNODES")
+	for _, b := range view.Blocks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", b.IP, b.Reason, b.Until.Format(time.RFC3339), strings.Join(b.Nodes, ","))
+	}
+
+	fmt.Fprintln(tw, "\nTALKER\tREQUESTS")
+	for _, t := range view.TopTalkers {
+		fmt.Fprintf(tw, "%s\t%d\n", t.IP, t.Requests)
+	}
+	return tw.Flush()
+}
+
+// cmdMetrics prints the raw metrics text
+func cmdMetrics(ctx context.Context, c *client.Client, args []string) error {
+	text, err := c.GetMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// envOr returns the environment variable name, or def when it is unset
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"ddd/internal/api/client"
+	"ddd/internal/config"
+	"ddd/internal/firewall"
+	"ddd/internal/geoip"
+)
+
+// cmdRules runs firewall rule tooling. It works on local files and does
+// not contact the server.
+func cmdRules(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return errors.New("usage: ddctl rules test [-geoip file] <rules> <query-log>")
+	}
+
+	fs := flag.NewFlagSet("rules test", flag.ContinueOnError)
+	geoFile := fs.String("geoip", "", "GeoIP CSV database for the country and asn fields")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: ddctl rules test [-geoip file] <rules> <query-log>")
+	}
+
+	rules, err := loadRules(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var db *geoip.DB
+	if *geoFile != "" {
+		if db, err = geoip.Load(*geoFile); err != nil {
+			return err
+		}
+	}
+
+	engine, err := firewall.NewEngine(rules, db)
+	if err != nil {
+		return err
+	}
+
+	queryLog, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer queryLog.Close()
+
+	tally := engine.NewTally()
+	if err := firewall.ReadQueryLog(queryLog, tally.Add); err != nil {
+		return err
+	}
+	printTally(tally)
+	return nil
+}
+
+// loadRules reads the firewall section of a YAML config file, or a plain
+// file with one rule per line
+func loadRules(path string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		cfg, _, err := config.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.Firewall, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return firewall.ReadRules(f)
+}
+
+// printTally writes a table of matches per rule
+func printTally(t *firewall.Tally) {
+	share := func(n int) string {
+		if t.Total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(t.Total))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tACTION\tMATCHES\tSHARE\tCLIENTS\tSOURCE")
+	for i, r := range t.Rules {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%d\t%s\n", i, r.Rule.Action, r.Matches, share(r.Matches), r.Clients, r.Rule.Source)
+		if len(r.Examples) > 0 {
+			fmt.Fprintf(w, "\t\t\t\t\te.g. %s\n", strings.Join(r.Examples, ", "))
+		}
+	}
+	fmt.Fprintf(w, "-\tunmatched\t%d\t%s\t\t\n", t.Unmatched, share(t.Unmatched))
+	w.Flush()
+	fmt.Printf("%d queries evaluated\n", t.Total)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ddd/internal/api/client"
+	"ddd/internal/monitor"
+)
+
+// cmdTrace prints the event stream the monitor holds for a client as
+// JSON, for replay later
+func cmdTrace(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: trace <ip>")
+	}
+	trace, err := c.GetTrace(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(trace)
+}
+
+// cmdReplay re-runs detection on a saved trace at a past time, given as
+// RFC 3339 or as a duration before the trace was dumped, and prints the
+// decision with the inputs it was made on
+func cmdReplay(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: replay <trace-file> [time]")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var trace monitor.Trace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	var at time.Time
+	if len(args) > 1 {
+		if at, err = time.Parse(time.RFC3339Nano, args[1]); err != nil {
+			d, derr := time.ParseDuration(args[1])
+			if derr != nil || d < 0 {
+				return fmt.Errorf("time must be RFC 3339 or a duration before the dump: %q", args[1])
+			}
+			at = trace.Dumped.Add(-d)
+		}
+	}
+
+	r, err := c.ReplayDetection(ctx, trace, at)
+	if err != nil {
+		return err
+	}
+	decision := "no detection"
+	if r.Result.IsAttack {
+		decision = fmt.Sprintf("%s (%s), %s", r.Result.AttackType, r.Result.Severity, r.Result.Description)
+		if r.Result.ShouldBlock {
+			decision += ", block"
+		}
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Client\t%s\n", r.IP)
+	fmt.Fprintf(tw, "At\t%s\n", r.At.Local().Format(time.RFC3339Nano))
+	fmt.Fprintf(tw, "Decision\t%s\n", decision)
+	if r.Result.Advice != nil {
+		fmt.Fprintf(tw, "Advice\t%s\n", r.Result.Advice.Description)
+	}
+	fmt.Fprintf(tw, "Requests in window\t%d of %d allowed\n", r.Requests, r.RateLimit)
+	fmt.Fprintf(tw, "Upstream failures\t%d\n", r.Failures)
+	fmt.Fprintf(tw, "New domains\t%d\n", r.NewDomains)
+	fmt.Fprintf(tw, "Queries in history\t%d\n", r.Queries)
+	fmt.Fprintf(tw, "First seen\t%s (%d requests since)\n", r.FirstSeen.Local().Format(time.RFC3339), r.ClientRequests)
+	if r.SearchStorm {
+		fmt.Fprintf(tw, "Search-list storm\texpansions left out\n")
+	}
+	return tw.Flush()
+}
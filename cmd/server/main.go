@@ -4,81 +4,990 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"ddd/internal/adaptive"
+	"ddd/internal/api"
+	"ddd/internal/archive"
 	"ddd/internal/blocker"
+	"ddd/internal/blockfeed"
+	"ddd/internal/buildinfo"
+	"ddd/internal/cache"
+	"ddd/internal/capture"
+	"ddd/internal/config"
+	"ddd/internal/datafile"
+	"ddd/internal/dataset"
 	"ddd/internal/detector"
 	"ddd/internal/dns"
+	"ddd/internal/dualstack"
+	"ddd/internal/eventfeed"
+	"ddd/internal/events"
+	"ddd/internal/federation"
+	"ddd/internal/firewall"
+	"ddd/internal/geoip"
+	"ddd/internal/groups"
+	"ddd/internal/integrity"
+	"ddd/internal/journal"
+	"ddd/internal/killswitch"
 	"ddd/internal/logger"
+	"ddd/internal/metrics"
+	"ddd/internal/mirror"
+	"ddd/internal/mobility"
 	"ddd/internal/monitor"
+	"ddd/internal/notify"
+	"ddd/internal/policy"
+	"ddd/internal/popularity"
+	"ddd/internal/ptr"
+	"ddd/internal/recursor"
+	"ddd/internal/rewrite"
+	"ddd/internal/sandbox"
+	"ddd/internal/script"
+	"ddd/internal/severity"
+	"ddd/internal/slo"
+	"ddd/internal/storage"
+	"ddd/internal/update"
+	"ddd/internal/upgrade"
+	"ddd/internal/watch"
+	"ddd/internal/zones"
 )
 
+// upgradeTimeout bounds each step of handing over to a new binary
+const upgradeTimeout = 30 * time.Second
+
+// storageTimeout bounds each save and restore of stored state
+const storageTimeout = 30 * time.Second
+
 func main() {
-	// Command line flags
+	defaults := config.Default()
+
+	// Command line flags. Flags that are set explicitly override the config file.
 	var (
-		port         = flag.Int("port", 8053, "DNS server port")
-		upstreamDNS  = flag.String("upstream", "8.8.8.8:53", "Upstream DNS server")
-		logFile      = flag.String("log", "logs/dns-defense.log", "Log file path")
-		rateLimit    = flag.Int("rate-limit", 100, "Max requests per IP per minute")
-		blockTime    = flag.Int("block-time", 300, "Block duration in seconds")
+		configFile  = flag.String("config", "", "Path to YAML config file")
+		port        = flag.Int("port", defaults.Server.Port, "DNS server port")
+		upstreamDNS = flag.String("upstream", defaults.Server.Upstream, "Upstream DNS server")
+		logFile     = flag.String("log", defaults.Log.File, "Log file path")
+		rateLimit   = flag.Int("rate-limit", defaults.Detection.RateLimit, "Max requests per IP per minute")
+		blockTime   = flag.Int("block-time", int(defaults.Blocking.BlockDuration/time.Second), "Block duration in seconds")
+		readBuffer  = flag.Int("rcvbuf", defaults.Server.ReadBuffer, "UDP socket receive buffer in bytes (0 = OS default)")
+		maxInFlight = flag.Int("max-inflight", defaults.Server.MaxInFlight, "Max concurrently handled requests before dropping (0 = unlimited)")
+		batchSize   = flag.Int("udp-batch", defaults.Server.UDPBatch, "Datagrams per recvmmsg/sendmmsg call (<2 disables batching)")
+		statsEvery  = flag.Duration("stats-interval", defaults.Server.StatsInterval, "Interval for logging socket drop statistics")
+		bootstrap   = flag.String("bootstrap-blocklist", defaults.Blocking.Bootstrap, "File of IPs/CIDRs to block at startup")
+		permanent   = flag.Bool("bootstrap-permanent", defaults.Blocking.BootstrapPermanent, "Never expire bootstrap blocks")
+		showVersion = flag.Bool("version", false, "Print the version, build and enabled features, then exit")
 	)
 	flag.Parse()
 
+	cfg := defaults
+	var configWarnings []string
+	if *configFile != "" {
+		loaded, warnings, err := config.Load(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		cfg = loaded
+		configWarnings = warnings
+	}
+
+	if err := cfg.ApplyEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid environment override: %v\n", err)
+		os.Exit(1)
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = *port
+		case "upstream":
+			cfg.Server.Upstream = *upstreamDNS
+		case "log":
+			cfg.Log.File = *logFile
+		case "rate-limit":
+			cfg.Detection.RateLimit = *rateLimit
+		case "block-time":
+			cfg.Blocking.BlockDuration = time.Duration(*blockTime) * time.Second
+		case "rcvbuf":
+			cfg.Server.ReadBuffer = *readBuffer
+		case "max-inflight":
+			cfg.Server.MaxInFlight = *maxInFlight
+		case "udp-batch":
+			cfg.Server.UDPBatch = *batchSize
+		case "stats-interval":
+			cfg.Server.StatsInterval = *statsEvery
+		case "bootstrap-blocklist":
+			cfg.Blocking.Bootstrap = *bootstrap
+		case "bootstrap-permanent":
+			cfg.Blocking.BootstrapPermanent = *permanent
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if *showVersion {
+		printVersion(cfg)
+		return
+	}
+
+	// Tag logs, metrics and events with the serving node
+	instanceID := cfg.Instance()
+	metrics.SetConstLabels(map[string]string{"instance": instanceID})
+	metrics.SetLabelLimit(cfg.API.MetricsLabelLimit)
+
 	// Initialize logger
-	log, err := logger.NewLogger(*logFile)
+	baseLog, err := logger.NewLogger(cfg.Log.File)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
 	}
-	defer log.Sync()
+	defer baseLog.Sync()
+	log := baseLog.WithInstance(instanceID)
+	if cfg.Log.ResolveHostnames {
+		log.SetHostnameLookup(ptr.New(cfg.Server.Upstream, cfg.Log.HostnameTTL, cfg.Log.HostnameLookupRate, 10000).Hostname)
+	}
 
-	log.Info("Starting DNS DDoS Defense System",
-		"port", *port,
-		"upstream", *upstreamDNS,
-		"rate_limit", *rateLimit,
-		"block_time", *blockTime,
+	for _, warning := range configWarnings {
+		log.Warnw("Config migrated", "detail", warning)
+	}
+
+	log.Infow("Starting DNS DDoS Defense System",
+		"config", *configFile,
+		"port", cfg.Server.Port,
+		"upstream", cfg.Server.Upstream,
+		"rate_limit", cfg.Detection.RateLimit,
+		"block_duration", cfg.Blocking.BlockDuration,
 	)
+	build := buildinfo.Read()
+	log.Infow("Optional subsystems", "builtin", build.Builtin, "omitted", build.Omitted)
+
+	if effective, err := cfg.Effective(); err == nil {
+		log.Infow("Effective configuration", "config", effective)
+	}
+
+	if cfg.Chaos.Enabled {
+		log.Warnw("Chaos mode enabled: upstream faults will be injected",
+			"timeout_rate", cfg.Chaos.TimeoutRate,
+			"servfail_rate", cfg.Chaos.ServfailRate,
+			"latency_rate", cfg.Chaos.LatencyRate,
+			"latency", cfg.Chaos.Latency,
+		)
+	}
+
+	if len(cfg.Privacy.Upstreams) > 0 {
+		log.Infow("Spreading queries across upstreams",
+			"upstreams", cfg.Privacy.Upstreams,
+			"hash_labels", cfg.Privacy.HashLabels,
+			"failover", cfg.Privacy.Failover,
+		)
+	}
 
 	// Initialize components
-	trafficMonitor := monitor.NewTrafficMonitor()
-	ddosDetector := detector.NewDDoSDetector(*rateLimit, log)
-	ipBlocker := blocker.NewIPBlocker(*blockTime, log)
+	trafficMonitor := monitor.NewTrafficMonitorWithRetention(monitor.Retention{
+		HistorySize: cfg.Monitor.HistorySize,
+		MaxAge:      cfg.Monitor.Retention,
+		RateWindow:  cfg.Detection.Window,
+		SeenDomains: cfg.Monitor.SeenDomains,
+	})
+	var historyArchive *archive.Store
+	if cfg.Archive.Dir != "" {
+		historyArchive, err = archive.Open(cfg.Archive.Dir, cfg.Archive.Retention, log)
+		if err != nil {
+			log.Errorw("Failed to open history archive", "dir", cfg.Archive.Dir, "error", err)
+			os.Exit(1)
+		}
+		trafficMonitor.WithArchive(historyArchive)
+		log.Infow("Archiving query history", "dir", cfg.Archive.Dir, "retention", cfg.Archive.Retention.String())
+	}
+	eventBus := events.NewBus()
+	eventBus.SetInstance(instanceID)
+	var loadGovernor *adaptive.Governor
+	var budgetLoad func() float64
+	if cfg.Adaptive.Enabled {
+		loadGovernor = adaptive.New(cfg.Adaptive, eventBus)
+		budgetLoad = loadGovernor.Factor
+	}
+	ddosDetector, _ := newDetector(cfg.Detection, budgetLoad, log) // checked by Validate
+	dataFiles := datafile.New(cfg.DataFiles, log)
+	for _, g := range cfg.Groups {
+		if g.CIDRFile == "" {
+			continue
+		}
+		if err := dataFiles.Check("blocklist", g.CIDRFile); err != nil {
+			log.Errorw("Refusing to load client group", "group", g.Name, "error", err)
+			os.Exit(1)
+		}
+	}
+	clientGroups, err := groups.New(cfg, func(d config.DetectionConfig) (*detector.DDoSDetector, error) {
+		return newDetector(d, budgetLoad, log)
+	})
+	if err != nil {
+		log.Errorw("Failed to load client groups", "error", err)
+		os.Exit(1)
+	}
+	for _, g := range clientGroups.Groups() {
+		log.Infow("Client group loaded", "group", g.Name, "tenant", g.Tenant, "mitigation", g.Mitigation)
+	}
+	severityDurations, _ := cfg.Blocking.Durations() // checked by Validate
+	ipBlocker := blocker.NewIPBlockerWithLimits(int(cfg.Blocking.BlockDuration/time.Second), eventBus, blocker.Limits{
+		MaxEntries:         cfg.Blocking.MaxEntries,
+		WarnRatio:          cfg.Blocking.CapacityWarning,
+		AggregateThreshold: cfg.Blocking.AggregateThreshold,
+		SeverityDurations:  severityDurations,
+	})
+
+	var bootstrapEntries []string
+	if cfg.Blocking.Bootstrap != "" {
+		if err := dataFiles.Check("blocklist", cfg.Blocking.Bootstrap); err != nil {
+			log.Errorw("Refusing to load bootstrap block list", "error", err)
+			os.Exit(1)
+		}
+		entries, err := blocker.LoadBlocklist(cfg.Blocking.Bootstrap)
+		if err != nil {
+			log.Errorw("Failed to load bootstrap block list", "file", cfg.Blocking.Bootstrap, "error", err)
+			os.Exit(1)
+		}
+		ipBlocker.SyncBlocklist(nil, entries, "bootstrap block list", severity.High, cfg.Blocking.BootstrapPermanent)
+		log.Infow("Loaded bootstrap block list", "file", cfg.Blocking.Bootstrap, "entries", len(entries), "permanent", cfg.Blocking.BootstrapPermanent)
+		bootstrapEntries = entries
+	}
+
+	rewriter, err := rewrite.NewEngine(cfg.Rewrite)
+	if err != nil {
+		log.Errorw("Invalid rewrite rules", "error", err)
+		os.Exit(1)
+	}
+
+	var domainRanking *popularity.Tracker
+	if cfg.Popularity.MaxDomains > 0 {
+		domainRanking = popularity.New(cfg.Popularity.MaxDomains, cfg.Popularity.TopN, cfg.Popularity.HalfLife)
+	}
+
+	var responseCache *cache.Cache
+	if cfg.Cache.MaxEntries > 0 {
+		responseCache = cache.NewSharded(cfg.Cache.MaxEntries, cfg.Cache.MaxBytes, cfg.Cache.Shards, cfg.Cache.MaxTTL).
+			WithPopularity(domainRanking.Popular)
+		if cfg.Cache.SnapshotFile != "" {
+			loaded, err := responseCache.Load(cfg.Cache.SnapshotFile)
+			if err != nil {
+				log.Warnw("Failed to load cache snapshot", "file", cfg.Cache.SnapshotFile, "error", err)
+			} else {
+				log.Infow("Loaded cache snapshot", "file", cfg.Cache.SnapshotFile, "entries", loaded)
+			}
+		}
+	}
+
+	var policyHook *policy.Hook
+	if cfg.Policy.URL.IsSet() {
+		policyHook = policy.New(cfg.Policy.URL.Value(), cfg.Policy.Timeout, cfg.Policy.CacheTTL, cfg.Policy.MaxInFlight)
+		log.Infow("Delegating borderline decisions", "timeout", cfg.Policy.Timeout)
+	}
+
+	var geoDB *geoip.DB
+	var geoHeatmap *geoip.Heatmap
+	if cfg.GeoIP.Database != "" {
+		if err := dataFiles.Check("geoip", cfg.GeoIP.Database); err != nil {
+			log.Errorw("Refusing to load GeoIP database", "error", err)
+			os.Exit(1)
+		}
+		geoDB, err = geoip.Load(cfg.GeoIP.Database)
+		if err != nil {
+			log.Errorw("Failed to load GeoIP database", "file", cfg.GeoIP.Database, "error", err)
+			os.Exit(1)
+		}
+		geoHeatmap = geoip.NewHeatmap(geoDB, cfg.GeoIP.Bucket, cfg.GeoIP.Retention, cfg.GeoIP.MaxASNs)
+		log.Infow("Loaded GeoIP database", "file", cfg.GeoIP.Database, "networks", geoDB.Len())
+	}
+
+	// Answers are grouped by AS or country when GeoIP data is loaded, so
+	// that CDN address rotation is not mistaken for a hijack
+	var answerWatcher *integrity.Watcher
+	if cfg.Integrity.Enabled {
+		var classifier integrity.Classifier
+		if geoDB != nil {
+			classifier = integrity.GeoClassifier{DB: geoDB}
+		}
+		answerWatcher = integrity.NewWatcher(classifier, cfg.Integrity.MinObservations, cfg.Integrity.MaxNames, eventBus)
+		if cfg.Integrity.CheckpointFile != "" {
+			loaded, err := answerWatcher.Load(cfg.Integrity.CheckpointFile, cfg.Integrity.BaselineMaxAge)
+			if err != nil {
+				log.Warnw("Failed to load integrity baselines", "file", cfg.Integrity.CheckpointFile, "error", err)
+			} else {
+				log.Infow("Loaded integrity baselines", "file", cfg.Integrity.CheckpointFile, "names", loaded)
+			}
+		}
+	}
+
+	firewallEngine, err := firewall.NewEngine(cfg.Firewall, geoDB)
+	if err != nil {
+		log.Errorw("Invalid firewall rules", "error", err)
+		os.Exit(1)
+	}
+
+	var policyScript *script.Program
+	if cfg.Script.File != "" {
+		limits := script.Limits{MaxSteps: cfg.Script.MaxSteps, Timeout: cfg.Script.Timeout}
+		policyScript, err = script.LoadFile(cfg.Script.File, limits, func(msg string) {
+			log.Infow("Policy script", "file", cfg.Script.File, "message", msg)
+		})
+		if err != nil {
+			log.Errorw("Failed to load policy script", "error", err)
+			os.Exit(1)
+		}
+		policyScript.WithGeo(geoDB)
+		log.Infow("Loaded policy script", "file", cfg.Script.File,
+			"on_request", policyScript.Has(script.OnRequest), "on_verdict", policyScript.Has(script.OnVerdict))
+	}
+
+	var buckets *mobility.Buckets
+	if cfg.Mobility.Enabled {
+		buckets, err = mobility.New(cfg.Mobility)
+		if err != nil {
+			log.Errorw("Invalid mobility settings", "error", err)
+			os.Exit(1)
+		}
+		log.Infow("Keying soft penalties to client fingerprints", "dynamic_ranges", len(cfg.Mobility.DynamicRanges))
+	}
+
+	zoneGuard := zones.New(cfg.Authoritative)
+	if zoneGuard != nil {
+		log.Infow("Guarding authoritative zones", "zones", zoneGuard.Zones(),
+			"zone_qps", cfg.Authoritative.ZoneQPS, "client_qps", cfg.Authoritative.ClientQPS,
+			"transfer_peers", len(cfg.Authoritative.TransferPeers))
+	}
+
+	var correlator *dualstack.Correlator
+	if cfg.DualStack.Enabled {
+		correlator = dualstack.New(cfg.DualStack, ipBlocker, log)
+		log.Infow("Linking dual-stack client addresses", "link_ttl", cfg.DualStack.LinkTTL.String())
+	}
+
+	var exporter *dataset.Exporter
+	if cfg.Dataset.File != "" {
+		exporter, err = dataset.New(cfg.Dataset, log)
+		if err != nil {
+			log.Errorw("Failed to open dataset export", "file", cfg.Dataset.File, "error", err)
+			os.Exit(1)
+		}
+		log.Infow("Exporting training dataset", "file", cfg.Dataset.File,
+			"sample_rate", cfg.Dataset.SampleRate, "client", cfg.Dataset.Client)
+	}
+
+	queryMirror := mirror.New(cfg.Mirror, log)
+
+	var decisionJournal *journal.Journal
+	if cfg.Journal.File != "" {
+		decisionJournal, err = journal.Open(cfg.Journal.File, []byte(cfg.Journal.Key.Value()), log)
+		if err != nil {
+			log.Errorw("Failed to open decision journal", "file", cfg.Journal.File, "error", err)
+			os.Exit(1)
+		}
+		log.Infow("Journaling mitigation decisions", "file", cfg.Journal.File, "keyed", cfg.Journal.Key.IsSet())
+	}
+
+	panicSwitch := killswitch.New(cfg.Panic.MaxQPS, eventBus)
+
+	var emergencyResolver *recursor.Resolver
+	if cfg.Emergency.Enabled {
+		if recursor.Available {
+			emergencyResolver = recursor.New(cfg.Emergency.Roots, cfg.Emergency.Timeout, cfg.Emergency.MaxQueries)
+			log.Infow("Emergency recursion armed for critical domains", "after", cfg.Emergency.After.String(), "domains", len(cfg.Critical))
+		} else {
+			log.Warnw("Emergency recursion is enabled but this binary was built without the recursion tag")
+		}
+	}
+
+	var recorder *capture.Recorder
+	if cfg.Capture.Dir != "" {
+		if capture.Available {
+			recorder = capture.New(cfg.Capture, eventBus, log)
+		} else {
+			log.Warnw("Packet capture is configured but this binary was built without the pcap tag")
+		}
+	}
+
+	// State handed over by a previous process during an in-place upgrade
+	stateSections := []upgrade.Section{{
+		Name:   "blocks",
+		Export: ipBlocker.Export,
+		Import: ipBlocker.Import,
+	}}
+	if answerWatcher != nil {
+		stateSections = append(stateSections, upgrade.Section{
+			Name:   "baselines",
+			Export: answerWatcher.Export,
+			Import: func(r io.Reader) (int, error) {
+				return answerWatcher.Import(r, cfg.Integrity.BaselineMaxAge)
+			},
+		})
+	}
+	if received, err := upgrade.Receive(stateSections, upgradeTimeout); err != nil {
+		log.Errorw("Failed to receive state from previous process", "error", err)
+	} else if received != nil {
+		log.Infow("Received state from previous process", "entries", received)
+	}
+
+	// Per-client summaries instead of an entry per query
+	var querySummary *logger.Summary
+	if cfg.Log.Mode == config.LogModeSummary {
+		querySummary = logger.NewSummary(log)
+		log.Infow("Logging per-client query summaries", "interval", cfg.Log.SummaryInterval.String())
+	}
+
+	// Critical domains whose resolution health is tracked on its own
+	watchList := watch.New(cfg.Watch, eventBus)
 
 	// Initialize DNS server
 	dnsServer := dns.NewServer(
-		*port,
-		*upstreamDNS,
+		cfg.Server.Port,
+		cfg.Server.Upstream,
 		trafficMonitor,
 		ddosDetector,
 		ipBlocker,
 		log,
+		dns.Options{
+			ReadBufferSize:   cfg.Server.ReadBuffer,
+			MaxInFlight:      cfg.Server.MaxInFlight,
+			BatchSize:        cfg.Server.UDPBatch,
+			FlattenCNAMEs:    cfg.Server.CNAMEFlatten,
+			MaxCNAMEChain:    cfg.Server.MaxCNAMEChain,
+			Critical:         cfg.Critical,
+			Rewriter:         rewriter,
+			Cache:            responseCache,
+			Integrity:        answerWatcher,
+			Chaos:            cfg.Chaos,
+			Privacy:          cfg.Privacy,
+			Popularity:       domainRanking,
+			PrefetchBefore:   cfg.Popularity.PrefetchBefore,
+			Summary:          querySummary,
+			NXDomainPatterns: cfg.Cache.NXDomainPatterns,
+			MaxEDNSOptions:   cfg.Server.MaxEDNSOptions,
+			MaxRRSet:         cfg.Server.MaxRRSet,
+			Policy:           policyHook,
+			Events:           eventBus,
+			Transparent:      cfg.Server.Transparent,
+			VerdictTTL:       cfg.Blocking.VerdictTTL,
+			VerdictDropAfter: cfg.Blocking.VerdictDropAfter,
+			FlaggedMinTTL:    cfg.Blocking.FlaggedMinTTL,
+			DuplicateWindow:  cfg.Server.DuplicateWindow,
+			PairQTypes:       cfg.Server.PairQTypes,
+			TCP:              cfg.Server.TCP,
+			TrustedProxies:   cfg.Server.TrustedProxies,
+			TCPAbuse:         cfg.Server.TCPAbuse,
+			Groups:           clientGroups,
+			Shed:             cfg.Server.Shed,
+			LocalZone:        cfg.Server.LocalZone,
+			Cost:             cfg.Detection.Cost,
+			Listeners:        cfg.Server.Listeners,
+			PaddingBlockSize: cfg.Server.PaddingBlockSize,
+			Geo:              geoHeatmap,
+			Firewall:         firewallEngine,
+			Script:           policyScript,
+			Capture:          recorder,
+			Dataset:          exporter,
+			Mirror:           queryMirror,
+			Journal:          decisionJournal,
+			Mobility:         buckets,
+			DualStack:        correlator,
+			Zones:            zoneGuard,
+			TransferPeers:    cfg.Authoritative.TransferPrefixes(),
+			MinimalResponses: cfg.Authoritative.MinimalResponses,
+			Panic:            panicSwitch,
+			Recursor:         emergencyResolver,
+			EmergencyAfter:   cfg.Emergency.After,
+			Watch:            watchList,
+		},
 	)
 
 	// Start background cleanup routines
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go trafficMonitor.StartCleanup(ctx)
-	go ipBlocker.StartCleanup(ctx)
+	go events.Consume(ctx, eventBus.Subscribe("logger", 4096), log.LogEvent)
+	eventLog := eventfeed.NewLog(instanceID, cfg.Federation.EventBuffer)
+	go events.Consume(ctx, eventBus.Subscribe("eventfeed", 1024), eventLog.Handle)
+	if querySummary != nil {
+		go querySummary.Run(ctx, cfg.Log.SummaryInterval)
+	}
+	if len(cfg.Notify.Zones) > 0 {
+		go events.Consume(ctx, eventBus.Subscribe("notify", 256), notify.New(cfg.Notify, log).Handle)
+	}
+	if recorder != nil {
+		go events.Consume(ctx, eventBus.Subscribe("capture", 256), recorder.Handle)
+	}
+	if correlator != nil {
+		go events.Consume(ctx, eventBus.Subscribe("dualstack", 1024), correlator.Handle)
+	}
+	if decisionJournal != nil {
+		go events.Consume(ctx, eventBus.Subscribe("journal", 256), decisionJournal.Handle)
+	}
+	if exporter != nil {
+		go events.Consume(ctx, eventBus.Subscribe("dataset", 256), exporter.Handle)
+		go exporter.Run(ctx)
+	}
+	if queryMirror != nil {
+		go queryMirror.Run(ctx)
+	}
+	if cfg.DataFiles.Watch {
+		watcher, err := dataFiles.Watch(listReloaders(cfg, ipBlocker, bootstrapEntries, clientGroups, log))
+		if err != nil {
+			log.Errorw("Failed to watch data files", "error", err)
+			os.Exit(1)
+		}
+		go watcher.Run(ctx)
+	}
+	if cfg.SLO.Enabled {
+		go slo.NewTracker(cfg.SLO, eventBus, dns.AllowedQueryDurations()...).Run(ctx, cfg.SLO.Interval)
+	}
+	if loadGovernor != nil {
+		go loadGovernor.Run(ctx, adaptive.Signals{
+			Drops: func() (uint64, error) {
+				stats, err := dnsServer.GetSocketStats()
+				return stats.KernelDrops + stats.UserDrops, err
+			},
+			Upstreams: dnsServer.UpstreamStats,
+		})
+		log.Infow("Rate budgets follow server load", "min_factor", cfg.Adaptive.MinFactor)
+	}
+	if watchList != nil && !cfg.Federation.Replica {
+		go watchList.Run(ctx, dnsServer.ProbeWatched)
+		log.Infow("Watching critical domains", "domains", len(cfg.Watch.Domains))
+	}
+	go trafficMonitor.StartCleanup(ctx, cfg.Cleanup.MonitorInterval, cfg.Cleanup.Jitter)
+	go ipBlocker.StartCleanup(ctx, cfg.Cleanup.BlockerInterval, cfg.Cleanup.Jitter)
+	if !cfg.Federation.Replica {
+		go dnsServer.StartStatsReporter(ctx, cfg.Server.StatsInterval)
+	}
+	if historyArchive != nil {
+		go trafficMonitor.StartSpill(ctx, cfg.Archive.SpillInterval)
+		go runArchive(ctx, cfg.Archive, historyArchive, ddosDetector, ipBlocker, decisionJournal, log)
+	}
+	if answerWatcher != nil && cfg.Integrity.CheckpointFile != "" {
+		go runCheckpoints(ctx, cfg.Integrity.CheckpointInterval, cfg.Integrity.CheckpointFile, answerWatcher.Save, log)
+	}
 
-	// Start DNS server
-	go func() {
-		if err := dnsServer.Start(); err != nil {
-			log.Error("DNS server error", "error", err)
+	// Start admin API
+	peers, err := api.NewPeers(cfg.Federation)
+	if err != nil {
+		log.Errorw("Failed to set up federation peers", "error", err)
+		os.Exit(1)
+	}
+	localStats := federation.NewLocal(cfg.Federation.Node, dnsServer.QueryCount, ipBlocker, trafficMonitor, cfg.Federation.TopTalkers)
+	blockFeed := blockfeed.NewPublisher(localStats.Node(), ipBlocker)
+	go events.Consume(ctx, eventBus.Subscribe("blockfeed", 1024), blockFeed.Handle)
+	subscribers, err := api.NewSubscribers(cfg.Federation, ipBlocker, eventBus, log)
+	if err != nil {
+		log.Errorw("Failed to set up block feed subscriptions", "error", err)
+		os.Exit(1)
+	}
+	for _, sub := range subscribers {
+		go sub.Run(ctx)
+	}
+	if len(subscribers) > 0 {
+		log.Infow("Subscribing to peer block feeds", "peers", len(subscribers))
+	}
+	if cfg.Federation.Replica {
+		followers, err := api.NewFollowers(cfg.Federation, eventBus, log)
+		if err != nil {
+			log.Errorw("Failed to set up event feed followers", "error", err)
 			os.Exit(1)
 		}
+		for _, f := range followers {
+			go f.Run(ctx)
+		}
+	}
+	var updates *update.Checker
+	if cfg.Update.Check {
+		updates = update.New(cfg.Update, buildinfo.Read().Version, log)
+		go updates.Run(ctx)
+	}
+	apiServer := api.NewServer(cfg, log).
+		WithGeo(geoHeatmap).
+		WithPopularity(domainRanking).
+		WithFederation(localStats, peers).
+		WithKillSwitch(panicSwitch).
+		WithHistory(historyArchive, trafficMonitor).
+		WithDetector(ddosDetector).
+		WithUpstreams(dnsServer.UpstreamStats).
+		WithBlockFeed(blockFeed).
+		WithEventLog(eventLog).
+		WithBlocker(ipBlocker).
+		WithJournal(decisionJournal).
+		WithWatch(watchList).
+		WithDualStack(correlator).
+		WithUpdates(updates)
+	if !cfg.Federation.Replica {
+		apiServer.WithListeners(dnsServer)
+	}
+	if len(peers) > 0 {
+		log.Infow("Federating stats", "node", localStats.Node(), "peers", len(peers))
+	}
+
+	// State kept between restarts in the configured storage backends
+	storedState := []storage.Component{
+		{Name: "blocks", Export: ipBlocker.Export, Import: ipBlocker.Import},
+		{Name: "reputation", Export: apiServer.ExportReports, Import: apiServer.ImportReports},
+	}
+	if answerWatcher != nil {
+		storedState = append(storedState, storage.Component{
+			Name:   "baselines",
+			Export: answerWatcher.Export,
+			Import: func(r io.Reader) (int, error) {
+				return answerWatcher.Import(r, cfg.Integrity.BaselineMaxAge)
+			},
+		})
+	}
+	stores, err := openStorage(cfg.Storage, storedState)
+	if err != nil {
+		log.Errorw("Failed to open storage", "error", err)
+		os.Exit(1)
+	}
+	for _, c := range storedState {
+		restoreCtx, restoreCancel := context.WithTimeout(ctx, storageTimeout)
+		restored, err := c.Restore(restoreCtx)
+		restoreCancel()
+		if err != nil {
+			log.Errorw("Failed to restore state", "component", c.Name, "backend", cfg.Storage.Backends()[c.Name], "error", err)
+		} else if restored > 0 {
+			log.Infow("Restored state", "component", c.Name, "backend", cfg.Storage.Backends()[c.Name], "entries", restored)
+		}
+	}
+	go storage.Run(ctx, cfg.Storage.Interval, storageTimeout, storedState, log)
+
+	go func() {
+		if err := apiServer.Start(); err != nil {
+			log.Errorw("Admin API error", "error", err)
+		}
+	}()
+
+	// Start public stats endpoint
+	publicServer := api.NewPublicServer(cfg.Public, api.StatsSource{
+		Queries: dnsServer.QueryCount,
+		Blocked: ipBlocker.BlockedCount,
+	}, log)
+	go func() {
+		if err := publicServer.Start(); err != nil {
+			log.Errorw("Public stats endpoint error", "error", err)
+		}
 	}()
 
-	log.Info("DNS server started successfully")
+	// Start DNS server. A read replica serves the API only.
+	if responseCache != nil && !cfg.Federation.Replica {
+		warmCache(ctx, cfg.Cache.Warm, dnsServer, log)
+	}
+	if cfg.Federation.Replica {
+		log.Infow("Running as a read replica", "peers", len(cfg.Federation.Peers))
+	} else {
+		go func() {
+			if err := dnsServer.Start(); err != nil {
+				log.Error("DNS server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+		<-dnsServer.Listening()
 
-	// Wait for interrupt signal
+		log.Info("DNS server started successfully")
+	}
+
+	// Give up what serving does not need. Listeners and files read at
+	// startup are already open.
+	if cfg.Sandbox.Landlock || cfg.Sandbox.Seccomp {
+		if err := sandbox.Apply(sandbox.PolicyFor(cfg, *configFile), cfg.Sandbox.Landlock, cfg.Sandbox.Seccomp); err != nil {
+			log.Errorw("Failed to sandbox process", "error", err)
+			os.Exit(1)
+		}
+		log.Infow("Process sandboxed", "landlock", cfg.Sandbox.Landlock, "seccomp", cfg.Sandbox.Seccomp, "audit", cfg.Sandbox.Audit)
+	}
+
+	// Only now that this process is serving may a previous one stop
+	if err := upgrade.Ready(); err != nil {
+		log.Errorw("Failed to tell the previous process to stop", "error", err)
+	}
+
+	// Wait for interrupt signal, or for an upgrade to hand over to the new
+	// binary. Failed upgrades leave this process serving.
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, append(upgradeSignals, syscall.SIGINT, syscall.SIGTERM)...)
+	for sig := range sigChan {
+		if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+			break
+		}
+		log.Info("Upgrading binary")
+		if err := upgrade.Upgrade(stateSections, upgradeTimeout); err != nil {
+			log.Errorw("Upgrade failed", "error", err)
+			continue
+		}
+		log.Info("New process took over")
+		break
+	}
 
 	log.Info("Shutting down DNS server...")
 	dnsServer.Stop()
+	recorder.Close()
+	exporter.Close()
+	decisionJournal.Close()
+	trafficMonitor.Flush()
+	historyArchive.Close()
+
+	if responseCache != nil && cfg.Cache.SnapshotFile != "" {
+		saved, err := responseCache.Save(cfg.Cache.SnapshotFile)
+		if err != nil {
+			log.Errorw("Failed to save cache snapshot", "file", cfg.Cache.SnapshotFile, "error", err)
+		} else {
+			log.Infow("Saved cache snapshot", "file", cfg.Cache.SnapshotFile, "entries", saved)
+		}
+	}
+
+	if cfg.Cache.Warm.Save {
+		saved, err := domainRanking.Save(cfg.Cache.Warm.File, cfg.Popularity.TopN)
+		if err != nil {
+			log.Errorw("Failed to save popular names", "file", cfg.Cache.Warm.File, "error", err)
+		} else {
+			log.Infow("Saved popular names", "file", cfg.Cache.Warm.File, "names", saved)
+		}
+	}
+
+	if answerWatcher != nil && cfg.Integrity.CheckpointFile != "" {
+		saveCheckpoint(cfg.Integrity.CheckpointFile, answerWatcher.Save, log)
+	}
+	storage.SaveAll(storageTimeout, storedState, log)
+	for _, store := range stores {
+		store.Close()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	apiServer.Stop(shutdownCtx)
+	publicServer.Stop(shutdownCtx)
 	log.Info("Server stopped gracefully")
 }
+
+// printVersion prints the build information, the optional subsystems
+// built in and the features cfg enables
+func printVersion(cfg *config.Config) {
+	info := buildinfo.Read()
+	revision, built := "unknown", "unknown"
+	if info.Revision != "" {
+		revision = info.Revision
+	}
+	if info.Modified {
+		revision += " (modified)"
+	}
+	if info.BuildTime != "" {
+		built = info.BuildTime
+	}
+	fmt.Printf("ddd %s\n", info.Version)
+	fmt.Printf("  commit:   %s\n", revision)
+	fmt.Printf("  built:    %s\n", built)
+	fmt.Printf("  go:       %s %s/%s\n", info.GoVersion, info.OS, info.Arch)
+	fmt.Printf("  built in: %s\n", strings.Join(info.Builtin, ", "))
+	if len(info.Omitted) > 0 {
+		fmt.Printf("  omitted:  %s\n", strings.Join(info.Omitted, ", "))
+	}
+	fmt.Printf("  features: %s\n", strings.Join(cfg.Features(), ", "))
+}
+
+// newDetector builds a detector from detection settings. Its budgets
+// follow load when it is not nil.
+func newDetector(d config.DetectionConfig, load func() float64, log *logger.Logger) (*detector.DDoSDetector, error) {
+	sensitivity, err := detector.ParseSensitivity(d.Sensitivity)
+	if err != nil {
+		return nil, fmt.Errorf("detection.sensitivity: %w", err)
+	}
+	return detector.NewDDoSDetectorWithThresholds(detector.Thresholds{
+		RateLimit:       d.RateLimit,
+		Window:          d.Window,
+		NewClientWindow: d.NewClientWindow,
+		NewClientLimit:  d.NewClientLimit,
+
+		TimingMinSamples:         d.TimingMinSamples,
+		TimingMaxCV:              d.TimingMaxCV,
+		TimingMinAutocorrelation: d.TimingMinAutocorrelation,
+
+		NewDomainRate:       d.NewDomainRate,
+		GlobalNewDomainRate: d.GlobalNewDomainRate,
+		ProtocolAbuseLimit:  d.ProtocolAbuseLimit,
+		HomographLimit:      d.HomographLimit,
+
+		FailurePenalty: d.FailurePenalty,
+		FailureFloor:   d.FailureFloor,
+		CostBudgets:    d.Cost.Enabled,
+
+		Noise: detector.Noise{
+			Names:           d.Noise.Names,
+			SearchSuffixes:  d.Noise.SearchSuffixes,
+			ProbeMinSamples: d.Noise.ProbeMinSamples,
+			ProbeMinPeriod:  d.Noise.ProbeMinPeriod,
+			ProbeMaxCV:      d.Noise.ProbeMaxCV,
+		},
+		CheckBudget: d.CheckBudget,
+	}.Scaled(sensitivity), log).WithLoad(load), nil
+}
+
+// openStorage opens the store of each backend components use, once per
+// backend, and sets each component's store. It returns the stores opened.
+func openStorage(cfg config.StorageConfig, components []storage.Component) ([]storage.Store, error) {
+	byBackend := make(map[string]storage.Store)
+	var stores []storage.Store
+	for i := range components {
+		backend := cfg.Backends()[components[i].Name]
+		store, ok := byBackend[backend]
+		if !ok {
+			var err error
+			if store, err = storage.Open(backend, cfg); err != nil {
+				return nil, fmt.Errorf("storage.%s: %w", components[i].Name, err)
+			}
+			byBackend[backend] = store
+			stores = append(stores, store)
+		}
+		components[i].Store = store
+	}
+	return stores, nil
+}
+
+// runCheckpoints saves learned state to path every interval until ctx is
+// cancelled, so a crash loses at most one interval of learning
+func runCheckpoints(ctx context.Context, interval time.Duration, path string, save func(string) (int, error), log *logger.Logger) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saveCheckpoint(path, save, log)
+		}
+	}
+}
+
+// runArchive prunes the history archive and, with a sustained rate set,
+// rate limits clients exceeding it over the long window, every scan
+// interval until ctx is cancelled
+func runArchive(ctx context.Context, cfg config.ArchiveConfig, store *archive.Store, d *detector.DDoSDetector, b *blocker.IPBlocker, j *journal.Journal, log *logger.Logger) {
+	ticker := time.NewTicker(cfg.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if removed := store.Prune(now); removed > 0 {
+				log.Debugw("Pruned history archive", "segments", removed)
+			}
+			if cfg.SustainedRate <= 0 {
+				continue
+			}
+			totals, err := store.Totals(now.Add(-cfg.SustainedWindow))
+			if err != nil {
+				log.Errorw("Failed to read history archive", "error", err)
+				continue
+			}
+			for ip, result := range d.AnalyzeSustained(totals, cfg.SustainedWindow, cfg.SustainedRate) {
+				if b.IsBlocked(ip) || b.IsRateLimited(ip) {
+					continue
+				}
+				b.RateLimitIP(ip)
+				j.Record(journal.Entry{
+					Client:      ip,
+					Source:      journal.SourceSustained,
+					AttackType:  result.AttackType,
+					Severity:    result.Severity,
+					Description: result.Description,
+					Inputs: map[string]float64{
+						"requests":    float64(totals[ip].Requests),
+						"failures":    float64(totals[ip].Failures),
+						"new_domains": float64(totals[ip].NewDomains),
+					},
+					Thresholds: map[string]float64{
+						"sustained_rate":           float64(cfg.SustainedRate),
+						"sustained_window_seconds": cfg.SustainedWindow.Seconds(),
+					},
+					Decision:  "rate_limit",
+					DecidedBy: "detector",
+				})
+			}
+		}
+	}
+}
+
+// warmCache resolves the configured names into the cache, waiting at most
+// cfg.Timeout
+func warmCache(ctx context.Context, cfg config.WarmConfig, server *dns.Server, log *logger.Logger) {
+	names := cfg.Names
+	if cfg.File != "" {
+		listed, err := popularity.ReadNames(cfg.File)
+		if err != nil {
+			log.Warnw("Failed to read names to warm the cache with", "file", cfg.File, "error", err)
+		}
+		names = append(names, listed...)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	start := time.Now()
+	warmCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	warmed, err := server.WarmCache(warmCtx, names, cfg.QTypes, cfg.Concurrency)
+	if err != nil {
+		log.Errorw("Failed to warm the cache", "error", err)
+		os.Exit(1)
+	}
+	log.Infow("Warmed the cache", "names", len(names), "resolved", warmed,
+		"elapsed", time.Since(start).Round(time.Millisecond).String(), "timed_out", warmCtx.Err() != nil)
+}
+
+// saveCheckpoint saves learned state to path and logs the outcome
+func saveCheckpoint(path string, save func(string) (int, error), log *logger.Logger) {
+	saved, err := save(path)
+	if err != nil {
+		log.Errorw("Failed to save checkpoint", "file", path, "error", err)
+		return
+	}
+	log.Debugw("Saved checkpoint", "file", path, "entries", saved)
+}
+
+// listReloaders returns reloaders that reapply the bootstrap block list
+// and group CIDR files when they change, starting from the entries loaded
+// at startup
+func listReloaders(cfg *config.Config, b *blocker.IPBlocker, bootstrap []string, g *groups.Set, log *logger.Logger) []datafile.Reloader {
+	var reloaders []datafile.Reloader
+	if cfg.Blocking.Bootstrap != "" {
+		reloaders = append(reloaders, datafile.Reloader{Kind: "blocklist", Path: cfg.Blocking.Bootstrap, Load: func(path string) error {
+			entries, err := blocker.LoadBlocklist(path)
+			if err != nil {
+				return err
+			}
+			added, removed := b.SyncBlocklist(bootstrap, entries, "bootstrap block list", severity.High, cfg.Blocking.BootstrapPermanent)
+			bootstrap = entries
+			log.Infow("Bootstrap block list changed", "file", path, "entries", len(entries), "added", added, "removed", removed)
+			return nil
+		}})
+	}
+	for _, gc := range cfg.Groups {
+		if gc.CIDRFile == "" {
+			continue
+		}
+		name := gc.Name
+		reloaders = append(reloaders, datafile.Reloader{Kind: "blocklist", Path: gc.CIDRFile, Load: func(path string) error {
+			cidrs, err := blocker.LoadBlocklist(path)
+			if err != nil {
+				return err
+			}
+			return g.SetListed(name, cidrs)
+		}})
+	}
+	return reloaders
+}
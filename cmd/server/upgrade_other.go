@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignals is empty: descriptor inheritance needs a Unix platform
+var upgradeSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals trigger an in-place binary upgrade
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -3,14 +3,17 @@ module ddd
 go 1.21
 
 require (
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/miekg/dns v1.1.57
+	go.starlark.net v0.0.0-20240725214946-42030a7cedce
 	go.uber.org/zap v1.26.0
+	golang.org/x/net v0.19.0
+	golang.org/x/sys v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/mod v0.14.0 // indirect
-	golang.org/x/net v0.19.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/tools v0.16.0 // indirect
 )
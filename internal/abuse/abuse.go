@@ -0,0 +1,101 @@
+// Package abuse is a reusable engine for detecting request-rate abuse. It
+// knows nothing about DNS: requests are a client (an IP, an API key)
+// accessing a resource (a domain, a URL path), so the same detectors can
+// protect any service. The DNS detector is one configuration of it.
+package abuse
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/severity"
+)
+
+// Request is one access by a client to a resource
+type Request struct {
+	Resource string
+	Time     time.Time
+}
+
+// Window is a client's activity over an analysis span
+type Window struct {
+	Client     string
+	Start, End time.Time
+	// Count is the number of requests in the span. It may exceed
+	// len(Requests) when the source caps the requests it retains.
+	Count int
+	// Requests are the retained requests in the span, oldest first
+	Requests []Request
+}
+
+// Source supplies client activity to the engine
+type Source interface {
+	Window(client string, span time.Duration, now time.Time) *Window
+}
+
+// Finding describes detected abuse
+type Finding struct {
+	Kind        string
+	Severity    severity.Level
+	Description string
+	Block       bool // severe enough to block rather than rate limit
+	// Resource is the targeted resource when the abuse concentrates on
+	// one, e.g. the parent of random subresources
+	Resource string
+	// Count is the number of requests that triggered the finding
+	Count int
+}
+
+// Detector examines one client's window
+type Detector interface {
+	Detect(w *Window) (Finding, bool)
+}
+
+// Engine runs detectors in order and reports the first finding
+type Engine struct {
+	span      time.Duration
+	detectors []Detector
+
+	budget    time.Duration
+	onOverrun func(Overrun)
+	stalls    atomic.Int32 // abandoned runs still going
+
+	mu      sync.Mutex
+	stalled map[stallKey]int // abandoned runs still going, per detector and client
+}
+
+// NewEngine creates an engine analysing windows of the given span
+func NewEngine(span time.Duration, detectors ...Detector) *Engine {
+	return &Engine{span: span, detectors: detectors}
+}
+
+// Analyze runs the detectors over w
+func (e *Engine) Analyze(w *Window) (Finding, bool) {
+	if e.budget > 0 {
+		return e.analyzeWithin(w)
+	}
+	for _, d := range e.detectors {
+		if f, ok := d.Detect(w); ok {
+			return f, true
+		}
+	}
+	return Finding{}, false
+}
+
+// Check fetches a client's current window from source and analyzes it
+func (e *Engine) Check(source Source, client string, now time.Time) (Finding, bool) {
+	return e.Analyze(source.Window(client, e.span, now))
+}
+
+// SeverityFor grades a count against the limit it exceeded
+func SeverityFor(count, limit int) severity.Level {
+	ratio := float64(count) / float64(limit)
+
+	if ratio > 5 {
+		return severity.High
+	} else if ratio > 2 {
+		return severity.Medium
+	}
+	return severity.Low
+}
@@ -0,0 +1,85 @@
+package abuse
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"ddd/internal/severity"
+)
+
+// httpEngine protects a hypothetical HTTP API keyed by API token and path
+func httpEngine() *Engine {
+	return NewEngine(time.Minute,
+		Rate{Limit: 100},
+		Random{MinRequests: 20, MaxUnique: 15, MaxRandom: 10},
+		Burst{MinRequests: 10, Span: 10 * time.Second, Limit: 50},
+	)
+}
+
+func TestRateOverMemoryStore(t *testing.T) {
+	store := NewMemoryStore(50, time.Minute)
+	now := time.Now()
+	for i := 0; i < 700; i++ {
+		store.Record("token-a", "/api/status", now.Add(-time.Duration(i)*50*time.Millisecond))
+	}
+
+	f, ok := httpEngine().Check(store, "token-a", now)
+	if !ok || f.Kind != "high_request_rate" {
+		t.Fatalf("Expected high_request_rate, got %+v", f)
+	}
+	if f.Count != 700 || f.Severity != severity.High || !f.Block {
+		t.Errorf("Expected an exact count beyond the retained requests and a high severity block, got %+v", f)
+	}
+
+	if _, ok := httpEngine().Check(store, "token-b", now); ok {
+		t.Error("Expected an unseen client to be clean")
+	}
+}
+
+func TestRandomResources(t *testing.T) {
+	store := NewMemoryStore(100, time.Minute)
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		store.Record("token-a", fmt.Sprintf("/users/%d", 100000+i*7919), now.Add(-time.Duration(i)*time.Second))
+	}
+
+	f, ok := httpEngine().Check(store, "token-a", now)
+	if !ok || f.Kind != "random_resources" || f.Resource != "/users" {
+		t.Fatalf("Expected random_resources under /users, got %+v", f)
+	}
+}
+
+func TestBurstIsNotBlocked(t *testing.T) {
+	store := NewMemoryStore(100, time.Minute)
+	now := time.Now()
+	for i := 0; i < 60; i++ {
+		store.Record("token-a", "/search", now.Add(-time.Duration(i)*100*time.Millisecond))
+	}
+
+	f, ok := httpEngine().Check(store, "token-a", now)
+	if !ok || f.Kind != "request_burst" || f.Block {
+		t.Fatalf("Expected a rate-limited request_burst, got %+v", f)
+	}
+}
+
+func TestSuffixParent(t *testing.T) {
+	split := SuffixParent(".", 2)
+	if parent, child := split("a1b2.cdn.example.com"); parent != "example.com" || child != "a1b2.cdn" {
+		t.Errorf("Got %q, %q", parent, child)
+	}
+	if _, child := split("com"); child != "" {
+		t.Errorf("Expected no child for a single label, got %q", child)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store := NewMemoryStore(10, time.Minute)
+	now := time.Now()
+	store.Record("old", "/", now.Add(-time.Hour))
+	store.Record("new", "/", now)
+
+	if removed := store.Prune(30*time.Minute, now); removed != 1 {
+		t.Errorf("Expected 1 idle client pruned, got %d", removed)
+	}
+}
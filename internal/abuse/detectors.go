@@ -0,0 +1,308 @@
+package abuse
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ddd/internal/severity"
+)
+
+// Rate flags clients making more than Limit requests per window. Clients
+// over twice the limit are blocked.
+type Rate struct {
+	Name        string // finding kind; default "high_request_rate"
+	Description string
+	Limit       int
+}
+
+func (d Rate) kind() string { return or(d.Name, "high_request_rate") }
+
+// Detect implements Detector
+func (d Rate) Detect(w *Window) (Finding, bool) {
+	if d.Limit <= 0 || w.Count <= d.Limit {
+		return Finding{}, false
+	}
+	return Finding{
+		Kind:        d.kind(),
+		Severity:    SeverityFor(w.Count, d.Limit),
+		Description: or(d.Description, "Excessive request rate detected"),
+		Block:       w.Count > d.Limit*2,
+		Count:       w.Count,
+	}, true
+}
+
+// Benign reports whether a resource's requests, given by their times in
+// arrival order, are known benign noise such as a monitoring probe
+type Benign func(resource string, times []time.Time) bool
+
+// discount returns requests without those for resources benign classifies
+// as noise. A nil benign keeps every request.
+func discount(requests []Request, benign Benign) []Request {
+	if benign == nil {
+		return requests
+	}
+	times := make(map[string][]time.Time)
+	for _, r := range requests {
+		times[r.Resource] = append(times[r.Resource], r.Time)
+	}
+	noise := make(map[string]bool)
+	for resource, ts := range times {
+		if benign(resource, ts) {
+			noise[resource] = true
+		}
+	}
+	if len(noise) == 0 {
+		return requests
+	}
+
+	kept := make([]Request, 0, len(requests))
+	for _, r := range requests {
+		if !noise[r.Resource] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// Repeat flags clients that, with at least MinRequests retained, spend
+// more than half of them on one resource requested more than MinCount
+// times. Requests Benign classifies as noise are left out of both counts.
+type Repeat struct {
+	Name        string // default "repeated_requests"
+	Description string
+	MinRequests int
+	MinCount    int
+	Benign      Benign
+}
+
+func (d Repeat) kind() string { return or(d.Name, "repeated_requests") }
+
+// Detect implements Detector
+func (d Repeat) Detect(w *Window) (Finding, bool) {
+	requests := discount(w.Requests, d.Benign)
+	if len(requests) < d.MinRequests {
+		return Finding{}, false
+	}
+
+	counts := make(map[string]int)
+	for _, r := range requests {
+		counts[r.Resource]++
+	}
+
+	for resource, count := range counts {
+		if float64(count)/float64(len(requests)) > 0.5 && count > d.MinCount {
+			return Finding{
+				Kind:        d.kind(),
+				Severity:    severity.Medium,
+				Description: or(d.Description, "Repeated requests for the same resource detected"),
+				Block:       true,
+				Resource:    resource,
+				Count:       len(requests),
+			}, true
+		}
+	}
+	return Finding{}, false
+}
+
+// Random flags clients that, with at least MinRequests retained, spread
+// requests over more than MaxUnique distinct children of one parent, or
+// more than MaxRandom random-looking ones: cache-busting and enumeration
+// floods
+type Random struct {
+	Name        string // default "random_resources"
+	Description string
+	MinRequests int
+	MaxUnique   int
+	MaxRandom   int
+	// Parent splits a resource into parent and child; default
+	// PrefixParent("/"), so /users/123 is child 123 of /users. Resources
+	// with an empty child are ignored.
+	Parent func(resource string) (parent, child string)
+	// Random reports whether a child looks generated; default LooksRandom
+	Random func(child string) bool
+}
+
+func (d Random) kind() string { return or(d.Name, "random_resources") }
+
+// Detect implements Detector
+func (d Random) Detect(w *Window) (Finding, bool) {
+	if len(w.Requests) < d.MinRequests {
+		return Finding{}, false
+	}
+	split := d.Parent
+	if split == nil {
+		split = PrefixParent("/")
+	}
+	random := d.Random
+	if random == nil {
+		random = LooksRandom
+	}
+
+	children := make(map[string]map[string]bool)
+	for _, r := range w.Requests {
+		parent, child := split(r.Resource)
+		if child == "" {
+			continue
+		}
+		if children[parent] == nil {
+			children[parent] = make(map[string]bool)
+		}
+		children[parent][child] = true
+	}
+
+	for parent, unique := range children {
+		flagged := len(unique) > d.MaxUnique
+		if !flagged {
+			randomCount := 0
+			for child := range unique {
+				if random(child) {
+					randomCount++
+				}
+			}
+			flagged = randomCount > d.MaxRandom
+		}
+		if flagged {
+			return Finding{
+				Kind:        d.kind(),
+				Severity:    severity.High,
+				Description: or(d.Description, "Random subresource flood detected"),
+				Block:       true,
+				Resource:    parent,
+				Count:       len(w.Requests),
+			}, true
+		}
+	}
+	return Finding{}, false
+}
+
+// Burst flags clients that, with at least MinRequests retained, made more
+// than Limit of them within the last Span. Bursts are rate limited, not
+// blocked.
+type Burst struct {
+	Name        string // default "request_burst"
+	Description string
+	MinRequests int
+	Span        time.Duration
+	Limit       int
+}
+
+func (d Burst) kind() string { return or(d.Name, "request_burst") }
+
+// Detect implements Detector
+func (d Burst) Detect(w *Window) (Finding, bool) {
+	if len(w.Requests) < d.MinRequests {
+		return Finding{}, false
+	}
+
+	cutoff := w.End.Add(-d.Span)
+	recent := 0
+	for _, r := range w.Requests {
+		if r.Time.After(cutoff) {
+			recent++
+		}
+	}
+	if recent <= d.Limit {
+		return Finding{}, false
+	}
+	return Finding{
+		Kind:        d.kind(),
+		Severity:    severity.Medium,
+		Description: or(d.Description, "Request burst detected"),
+		Count:       len(w.Requests),
+	}, true
+}
+
+// Timing flags machine-gun timing: with more than MinSamples requests
+// retained, intervals that are nearly constant (coefficient of variation
+// at or below MaxCV) or repeat with a fixed period (autocorrelation at or
+// above MinAutocorrelation) are a bot signature. A zero MinSamples
+// disables the check.
+type Timing struct {
+	Name               string // default "regular_timing"
+	Description        string // interval statistics are appended
+	MinSamples         int
+	MaxCV              float64
+	MinAutocorrelation float64
+	Benign             Benign // requests classified as noise are left out
+}
+
+func (d Timing) kind() string { return or(d.Name, "regular_timing") }
+
+// Detect implements Detector
+func (d Timing) Detect(w *Window) (Finding, bool) {
+	requests := discount(w.Requests, d.Benign)
+	if d.MinSamples <= 0 || len(requests) <= d.MinSamples {
+		return Finding{}, false
+	}
+
+	stats := IntervalStats(requests)
+	if stats.Mean <= 0 {
+		return Finding{}, false
+	}
+	if stats.CV > d.MaxCV && stats.Periodicity < d.MinAutocorrelation {
+		return Finding{}, false
+	}
+	return Finding{
+		Kind:     d.kind(),
+		Severity: severity.Low,
+		Description: fmt.Sprintf("%s (mean %.3fs, cv %.3f, periodicity %.2f)",
+			or(d.Description, "Regular request timing detected"), stats.Mean, stats.CV, stats.Periodicity),
+		Count: len(requests),
+	}, true
+}
+
+// PrefixParent splits a resource at its last sep: the parent is everything
+// before it
+func PrefixParent(sep string) func(string) (string, string) {
+	return func(resource string) (string, string) {
+		i := strings.LastIndex(resource, sep)
+		if i < 0 {
+			return resource, ""
+		}
+		return resource[:i], resource[i+len(sep):]
+	}
+}
+
+// SuffixParent splits a resource so that the parent is its last n
+// sep-separated parts, as a base domain is the last labels of a name
+func SuffixParent(sep string, n int) func(string) (string, string) {
+	return func(resource string) (string, string) {
+		parts := strings.Split(resource, sep)
+		if len(parts) < n {
+			return resource, ""
+		}
+		return strings.Join(parts[len(parts)-n:], sep), strings.Join(parts[:len(parts)-n], sep)
+	}
+}
+
+// LooksRandom reports whether s looks machine generated: long, with a high
+// share of digits and of distinct characters
+func LooksRandom(s string) bool {
+	if len(s) < 8 {
+		return false
+	}
+
+	digitCount := 0
+	uniqueChars := make(map[rune]bool)
+	for _, c := range s {
+		uniqueChars[c] = true
+		if c >= '0' && c <= '9' {
+			digitCount++
+		}
+	}
+
+	// Require both: high digits AND high entropy
+	highDigitRatio := float64(digitCount)/float64(len(s)) > 0.4
+	highEntropy := float64(len(uniqueChars))/float64(len(s)) > 0.6
+
+	return highDigitRatio && highEntropy
+}
+
+// or returns s, or def when s is empty
+func or(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
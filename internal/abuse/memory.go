@@ -0,0 +1,112 @@
+package abuse
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Source for services embedding the engine. It keeps
+// the most recent requests per client, plus exact per-second counts over
+// a fixed span so rate checks see the true volume.
+type MemoryStore struct {
+	mu      sync.Mutex
+	clients map[string]*clientLog
+	keep    int
+	slots   int
+}
+
+// clientLog is one client's recent activity
+type clientLog struct {
+	requests []Request
+	counts   []int
+	stamps   []int64
+	last     time.Time
+}
+
+// NewMemoryStore creates a store keeping up to keep requests per client
+// and exact counts over span (at least one second)
+func NewMemoryStore(keep int, span time.Duration) *MemoryStore {
+	slots := int(span / time.Second)
+	if slots < 1 {
+		slots = 1
+	}
+	return &MemoryStore{
+		clients: make(map[string]*clientLog),
+		keep:    keep,
+		slots:   slots,
+	}
+}
+
+// Record notes a request by client for resource
+func (s *MemoryStore) Record(client, resource string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.clients[client]
+	if c == nil {
+		c = &clientLog{counts: make([]int, s.slots), stamps: make([]int64, s.slots)}
+		s.clients[client] = c
+	}
+	c.last = now
+
+	sec := now.Unix()
+	slot := sec % int64(s.slots)
+	if c.stamps[slot] != sec {
+		c.stamps[slot] = sec
+		c.counts[slot] = 0
+	}
+	c.counts[slot]++
+
+	if s.keep > 0 && len(c.requests) >= s.keep {
+		copy(c.requests, c.requests[1:])
+		c.requests = c.requests[:len(c.requests)-1]
+	}
+	c.requests = append(c.requests, Request{Resource: resource, Time: now})
+}
+
+// Window implements Source. Spans longer than the store's count from the
+// retained requests only.
+func (s *MemoryStore) Window(client string, span time.Duration, now time.Time) *Window {
+	w := &Window{Client: client, Start: now.Add(-span), End: now}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.clients[client]
+	if c == nil {
+		return w
+	}
+
+	for _, r := range c.requests {
+		if r.Time.After(w.Start) {
+			w.Requests = append(w.Requests, r)
+		}
+	}
+
+	if span > time.Duration(s.slots)*time.Second {
+		w.Count = len(w.Requests)
+		return w
+	}
+	oldest := now.Unix() - int64(span/time.Second)
+	for i, stamp := range c.stamps {
+		if stamp > oldest && stamp <= now.Unix() {
+			w.Count += c.counts[i]
+		}
+	}
+	return w
+}
+
+// Prune forgets clients idle for longer than idle and returns how many
+func (s *MemoryStore) Prune(idle time.Duration, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for client, c := range s.clients {
+		if now.Sub(c.last) > idle {
+			delete(s.clients, client)
+			removed++
+		}
+	}
+	return removed
+}
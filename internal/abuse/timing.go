@@ -0,0 +1,71 @@
+package abuse
+
+import "math"
+
+// maxAutocorrelationLag is the longest period (in requests) looked for
+const maxAutocorrelationLag = 8
+
+// TimingStats summarises the gaps between a client's consecutive requests
+type TimingStats struct {
+	Samples     int     // number of intervals
+	Mean        float64 // mean interval in seconds
+	Variance    float64 // interval variance in seconds^2
+	CV          float64 // coefficient of variation (stddev / mean)
+	Periodicity float64 // strongest autocorrelation over lags 1..8
+	Period      int     // lag with the strongest autocorrelation
+}
+
+// IntervalStats computes inter-request interval statistics for requests,
+// which must be in arrival order
+func IntervalStats(requests []Request) TimingStats {
+	if len(requests) < 3 {
+		return TimingStats{}
+	}
+
+	intervals := make([]float64, 0, len(requests)-1)
+	for i := 1; i < len(requests); i++ {
+		intervals = append(intervals, requests[i].Time.Sub(requests[i-1].Time).Seconds())
+	}
+
+	stats := TimingStats{Samples: len(intervals)}
+
+	for _, v := range intervals {
+		stats.Mean += v
+	}
+	stats.Mean /= float64(len(intervals))
+
+	for _, v := range intervals {
+		stats.Variance += (v - stats.Mean) * (v - stats.Mean)
+	}
+	stats.Variance /= float64(len(intervals))
+
+	if stats.Mean > 0 {
+		stats.CV = math.Sqrt(stats.Variance) / stats.Mean
+	}
+
+	stats.Periodicity, stats.Period = autocorrelation(intervals, stats.Mean, stats.Variance)
+	return stats
+}
+
+// autocorrelation returns the highest normalised autocorrelation of xs
+// over lags 1..maxAutocorrelationLag and the lag it occurs at
+func autocorrelation(xs []float64, mean, variance float64) (float64, int) {
+	if variance == 0 {
+		return 0, 0
+	}
+
+	best, bestLag := 0.0, 0
+	denom := variance * float64(len(xs))
+
+	for lag := 1; lag <= maxAutocorrelationLag && lag < len(xs)/2; lag++ {
+		sum := 0.0
+		for i := 0; i+lag < len(xs); i++ {
+			sum += (xs[i] - mean) * (xs[i+lag] - mean)
+		}
+		if r := sum / denom; r > best {
+			best, bestLag = r, lag
+		}
+	}
+
+	return best, bestLag
+}
@@ -0,0 +1,172 @@
+package abuse
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Overrun describes a detector that exceeded its time budget
+type Overrun struct {
+	Detector string // the finding kind the detector reports
+	Client   string
+	Requests int           // retained requests in the window
+	Elapsed  time.Duration // how long it ran before it was abandoned
+	// Skipped is set when the detector was not run at all because it is
+	// still busy with a window it was abandoned on
+	Skipped bool
+}
+
+// WithBudget gives each detector budget to analyze a window. A detector
+// that exceeds it is abandoned, reported to onOverrun, and the remaining
+// detectors run without it. Until the abandoned run finishes in the
+// background the detector is skipped for that client, so one client's slow
+// windows cannot pile up while the detector keeps running for the rest.
+// Detectors must not modify the window. A zero budget runs detectors
+// inline with no limit.
+func (e *Engine) WithBudget(budget time.Duration, onOverrun func(Overrun)) *Engine {
+	e.budget = budget
+	e.onOverrun = onOverrun
+	e.stalled = make(map[stallKey]int)
+	return e
+}
+
+// stallKey identifies a detector's abandoned runs for one client
+type stallKey struct {
+	detector int
+	client   string
+}
+
+// isStalled reports whether detector i is still busy with a window of
+// client it was abandoned on
+func (e *Engine) isStalled(i int, client string) bool {
+	if e.stalls.Load() == 0 {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stalled[stallKey{i, client}] > 0
+}
+
+// stall counts an abandoned run of detector i for client starting, by
+// delta 1, or finishing, by delta -1
+func (e *Engine) stall(i int, client string, delta int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := stallKey{i, client}
+	if e.stalled[k] += delta; e.stalled[k] <= 0 {
+		delete(e.stalled, k)
+	}
+	e.stalls.Add(int32(delta))
+}
+
+// Check states shared by the worker running a detector and the engine
+// waiting on it: whichever leaves checkRunning first decides whether the
+// result is used or the run abandoned
+const (
+	checkRunning int32 = iota
+	checkFinished
+	checkAbandoned
+)
+
+// progress is reported by the worker running detectors for the engine
+type progress struct {
+	index   int
+	state   *atomic.Int32 // set when the detector starts
+	done    bool
+	skipped bool
+	finding Finding
+	ok      bool
+}
+
+// analyzeWithin runs the detectors over w in a worker, abandoning any that
+// runs longer than the budget
+func (e *Engine) analyzeWithin(w *Window) (Finding, bool) {
+	timer := time.NewTimer(e.budget)
+	defer timer.Stop()
+
+	for from := 0; from < len(e.detectors); {
+		updates := make(chan progress, 2*len(e.detectors))
+		go e.work(w, from, updates)
+
+		var current progress
+		started := time.Now()
+		from = len(e.detectors)
+	wait:
+		for {
+			select {
+			case p := <-updates:
+				switch {
+				case p.skipped:
+					e.overrun(Overrun{Detector: kindOf(e.detectors[p.index]), Client: w.Client, Requests: len(w.Requests), Skipped: true})
+				case !p.done:
+					current, started = p, time.Now()
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(e.budget)
+				case p.ok:
+					return p.finding, true
+				}
+				if p.index == len(e.detectors)-1 && (p.done || p.skipped) {
+					return Finding{}, false
+				}
+			case <-timer.C:
+				if current.state == nil || !current.state.CompareAndSwap(checkRunning, checkAbandoned) {
+					// Finished just in time; its result is on the way
+					continue
+				}
+				e.stall(current.index, w.Client, 1)
+				e.overrun(Overrun{
+					Detector: kindOf(e.detectors[current.index]),
+					Client:   w.Client,
+					Requests: len(w.Requests),
+					Elapsed:  time.Since(started),
+				})
+				from = current.index + 1
+				break wait
+			}
+		}
+	}
+	return Finding{}, false
+}
+
+// work runs the detectors from index from on over w, reporting progress,
+// until one finds abuse or is abandoned
+func (e *Engine) work(w *Window, from int, updates chan<- progress) {
+	for i := from; i < len(e.detectors); i++ {
+		if e.isStalled(i, w.Client) {
+			updates <- progress{index: i, skipped: true}
+			continue
+		}
+		state := new(atomic.Int32)
+		updates <- progress{index: i, state: state}
+		f, ok := e.detectors[i].Detect(w)
+		if !state.CompareAndSwap(checkRunning, checkFinished) {
+			e.stall(i, w.Client, -1)
+			return
+		}
+		updates <- progress{index: i, done: true, finding: f, ok: ok}
+		if ok {
+			return
+		}
+	}
+}
+
+// overrun reports o when a callback is set
+func (e *Engine) overrun(o Overrun) {
+	if e.onOverrun != nil {
+		e.onOverrun(o)
+	}
+}
+
+// kindOf names a detector by the finding kind it reports
+func kindOf(d Detector) string {
+	if k, ok := d.(interface{ kind() string }); ok {
+		return k.kind()
+	}
+	return fmt.Sprintf("%T", d)
+}
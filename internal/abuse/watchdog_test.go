@@ -0,0 +1,80 @@
+package abuse
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// stuck is a detector that does not return until released
+type stuck struct {
+	release chan struct{}
+}
+
+func (d stuck) Detect(w *Window) (Finding, bool) {
+	<-d.release
+	return Finding{Kind: "stuck"}, true
+}
+
+func TestBudgetAbandonsSlowDetector(t *testing.T) {
+	var mu sync.Mutex
+	var overruns []Overrun
+	release := make(chan struct{})
+	e := NewEngine(time.Minute, stuck{release}, Rate{Limit: 10}).
+		WithBudget(20*time.Millisecond, func(o Overrun) {
+			mu.Lock()
+			overruns = append(overruns, o)
+			mu.Unlock()
+		})
+
+	w := &Window{Client: "token-a", Count: 50, Requests: make([]Request, 50)}
+	f, ok := e.Analyze(w)
+	if !ok || f.Kind != "high_request_rate" {
+		t.Fatalf("Expected the remaining detectors to run after the overrun, got %+v", f)
+	}
+
+	// Still stuck on the first window, so skipped rather than started again
+	if f, ok := e.Analyze(w); !ok || f.Kind != "high_request_rate" {
+		t.Fatalf("Expected the stuck detector to be skipped, got %+v", f)
+	}
+
+	// Other clients still get the detector, and abandon it in turn
+	other := &Window{Client: "token-b", Count: 50, Requests: make([]Request, 50)}
+	if f, ok := e.Analyze(other); !ok || f.Kind != "high_request_rate" {
+		t.Fatalf("Expected the remaining detectors to run for another client, got %+v", f)
+	}
+
+	mu.Lock()
+	if len(overruns) != 3 || overruns[0].Detector != "abuse.stuck" || overruns[0].Skipped || overruns[0].Requests != 50 || overruns[0].Elapsed < 20*time.Millisecond || !overruns[1].Skipped ||
+		overruns[2].Client != "token-b" || overruns[2].Skipped {
+		t.Errorf("Expected an abandoned run, a skip, then another client's abandoned run, got %+v", overruns)
+	}
+	mu.Unlock()
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for e.stalls.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if f, ok := e.Analyze(w); !ok || f.Kind != "stuck" {
+		t.Errorf("Expected the detector back once its abandoned run finished, got %+v", f)
+	}
+}
+
+func TestBudgetKeepsFastDetectors(t *testing.T) {
+	e := httpEngine().WithBudget(time.Second, func(o Overrun) {
+		t.Errorf("Unexpected overrun %+v", o)
+	})
+	now := time.Now()
+	w := &Window{Client: "token-a", End: now, Count: 60}
+	for i := 0; i < 60; i++ {
+		w.Requests = append(w.Requests, Request{Resource: "/api/status", Time: now.Add(-time.Duration(i) * 100 * time.Millisecond)})
+	}
+	if f, ok := e.Analyze(w); !ok || f.Kind != "request_burst" {
+		t.Errorf("Expected the burst found within the budget, got %+v", f)
+	}
+	w.Requests = w.Requests[:5]
+	if _, ok := e.Analyze(w); ok {
+		t.Error("Expected a quiet window to be clean")
+	}
+}
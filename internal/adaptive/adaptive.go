@@ -0,0 +1,210 @@
+// Package adaptive ties clients' rate budgets to the server's load. It
+// samples process CPU use, dropped datagrams and upstream latency, shrinks
+// the share of the configured budgets clients get while any of them is
+// too high, and grows it back once all have stayed low for a while, so
+// that load hovering around a threshold does not flap the budgets.
+package adaptive
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+	"ddd/internal/upstream"
+)
+
+var (
+	factorGauge = metrics.NewGauge("ddd_adaptive_budget_factor",
+		"Share of the configured rate budgets clients get under the current load")
+	loadGauge = metrics.NewGaugeVec("ddd_adaptive_load",
+		"Load signals rate budgets follow: CPU share, drops per second, upstream p90 seconds; -1 if unread", "signal")
+)
+
+// Signals reads the server's load. Either may be nil.
+type Signals struct {
+	// Drops returns how many datagrams have been dropped since start
+	Drops func() (uint64, error)
+	// Upstreams returns the health of each upstream
+	Upstreams func() []upstream.Stats
+}
+
+// Load is one sample of the signals. A signal that could not be read is
+// negative.
+type Load struct {
+	CPU             float64 // share of all cores
+	Drops           float64 // per second
+	UpstreamLatency time.Duration
+}
+
+// Governor adjusts the budget factor to the load
+type Governor struct {
+	cfg config.AdaptiveConfig
+	bus *events.Bus
+	cpu func() (time.Duration, bool)
+
+	factor atomic.Uint64 // float64 bits
+
+	// Run's state
+	last      time.Time
+	lastCPU   time.Duration
+	lastDrops uint64
+	calmSince time.Time // zero while load is not below the relax level
+}
+
+// New creates a governor with budgets at their configured size
+func New(cfg config.AdaptiveConfig, bus *events.Bus) *Governor {
+	g := &Governor{cfg: cfg, bus: bus, cpu: cpuTime}
+	g.setFactor(1)
+	return g
+}
+
+// Factor returns the share of the configured rate budgets clients get,
+// 1 without a governor
+func (g *Governor) Factor() float64 {
+	if g == nil {
+		return 1
+	}
+	return math.Float64frombits(g.factor.Load())
+}
+
+// setFactor sets the budget factor
+func (g *Governor) setFactor(f float64) {
+	g.factor.Store(math.Float64bits(f))
+	factorGauge.Set(f)
+}
+
+// Run samples the load from signals every interval and adjusts the budget
+// factor until ctx is cancelled
+func (g *Governor) Run(ctx context.Context, signals Signals) {
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+
+	g.sample(time.Now(), signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			g.adjust(now, g.sample(now, signals))
+		}
+	}
+}
+
+// sample reads the signals. Rates are over the time since the previous
+// sample; the first sample has none.
+func (g *Governor) sample(now time.Time, signals Signals) Load {
+	load := Load{CPU: -1, Drops: -1, UpstreamLatency: -1}
+	elapsed := now.Sub(g.last).Seconds()
+	first := g.last.IsZero()
+	g.last = now
+
+	if used, ok := g.cpu(); ok {
+		if !first && elapsed > 0 {
+			load.CPU = (used - g.lastCPU).Seconds() / elapsed / float64(runtime.GOMAXPROCS(0))
+		}
+		g.lastCPU = used
+	}
+	if signals.Drops != nil {
+		if drops, err := signals.Drops(); err == nil {
+			if !first && elapsed > 0 && drops >= g.lastDrops {
+				load.Drops = float64(drops-g.lastDrops) / elapsed
+			}
+			g.lastDrops = drops
+		}
+	}
+	if signals.Upstreams != nil {
+		load.UpstreamLatency = upstreamLatency(signals.Upstreams())
+	}
+
+	loadGauge.With("cpu").Set(load.CPU)
+	loadGauge.With("drops").Set(load.Drops)
+	if load.UpstreamLatency >= 0 {
+		loadGauge.With("upstream_latency").Set(load.UpstreamLatency.Seconds())
+	} else {
+		loadGauge.With("upstream_latency").Set(-1)
+	}
+	return load
+}
+
+// upstreamLatency returns the p90 exchange time of the upstreams weighted
+// by their traffic, or -1 if none has any
+func upstreamLatency(stats []upstream.Stats) time.Duration {
+	var sum, qps float64
+	for _, s := range stats {
+		sum += s.LatencyP90 * s.QPS
+		qps += s.QPS
+	}
+	if qps == 0 {
+		return -1
+	}
+	return time.Duration(sum / qps * float64(time.Millisecond))
+}
+
+// adjust tightens budgets while load is high and relaxes them, one step
+// per hold, once it has stayed below the relax level
+func (g *Governor) adjust(now time.Time, load Load) {
+	high, low := g.judge(load)
+	factor := g.Factor()
+	switch {
+	case len(high) > 0:
+		g.calmSince = time.Time{}
+		if factor <= g.cfg.MinFactor {
+			return
+		}
+		factor = math.Max(factor*g.cfg.Step, g.cfg.MinFactor)
+		g.setFactor(factor)
+		g.bus.Publish(events.Event{
+			Type:   events.BudgetsTightened,
+			Reason: fmt.Sprintf("%s; budgets at %.0f%%", strings.Join(high, ", "), factor*100),
+		})
+	case !low:
+		// Between the relax level and the thresholds: hold
+		g.calmSince = time.Time{}
+	case g.calmSince.IsZero():
+		g.calmSince = now
+	case factor < 1 && now.Sub(g.calmSince) >= g.cfg.Hold:
+		calm := now.Sub(g.calmSince)
+		g.calmSince = now
+		factor = math.Min(factor/g.cfg.Step, 1)
+		g.setFactor(factor)
+		g.bus.Publish(events.Event{
+			Type:     events.BudgetsRelaxed,
+			Reason:   fmt.Sprintf("load below %.0f%% of thresholds; budgets at %.0f%%", g.cfg.Relax*100, factor*100),
+			Duration: calm,
+		})
+	}
+}
+
+// judge returns the signals at or above their thresholds, and whether
+// all are below the relax level. Unread and disabled signals count as
+// low.
+func (g *Governor) judge(load Load) (high []string, low bool) {
+	low = true
+	check := func(name string, value, threshold float64, format string) {
+		if threshold <= 0 || value < 0 {
+			return
+		}
+		if value >= threshold {
+			high = append(high, fmt.Sprintf("%s "+format+" (limit "+format+")", name, value, threshold))
+		}
+		if value >= threshold*g.cfg.Relax {
+			low = false
+		}
+	}
+	check("cpu", load.CPU*100, g.cfg.CPU*100, "%.0f%%")
+	check("socket drops", load.Drops, g.cfg.SocketDrops, "%.0f/s")
+	check("upstream p90", millis(load.UpstreamLatency), millis(g.cfg.UpstreamLatency), "%.0fms")
+	return high, low
+}
+
+// millis returns d in milliseconds, keeping negative durations negative
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
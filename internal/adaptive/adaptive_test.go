@@ -0,0 +1,93 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/upstream"
+)
+
+func TestAdjustHysteresis(t *testing.T) {
+	cfg := config.Default().Adaptive
+	bus := events.NewBus()
+	published := bus.Subscribe("test", 16)
+	g := New(cfg, bus)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	step := func(load Load, want float64) {
+		t.Helper()
+		now = now.Add(cfg.Interval)
+		g.adjust(now, load)
+		if got := g.Factor(); got != want {
+			t.Fatalf("Expected budgets at %v, got %v", want, got)
+		}
+	}
+	high := Load{CPU: 0.9, Drops: -1, UpstreamLatency: -1}
+	between := Load{CPU: 0.6, Drops: 0, UpstreamLatency: 100 * time.Millisecond}
+	low := Load{CPU: 0.2, Drops: 0, UpstreamLatency: 100 * time.Millisecond}
+
+	// Tightened each interval while loaded, down to the floor
+	step(high, 0.5)
+	step(high, 0.25)
+	step(high, 0.25)
+
+	// Load between the relax level and the thresholds holds the budgets
+	for i := 0; i < 20; i++ {
+		step(between, 0.25)
+	}
+
+	// Relaxed one step per hold of low load, restarting after a spike
+	steps := int(cfg.Hold / cfg.Interval)
+	for i := 0; i < steps; i++ {
+		step(low, 0.25)
+	}
+	step(low, 0.5)
+	step(high, 0.25)
+	for i := 0; i < steps; i++ {
+		step(low, 0.25)
+	}
+	step(low, 0.5)
+	for i := 0; i < steps-1; i++ {
+		step(low, 0.5)
+	}
+	step(low, 1)
+	step(low, 1)
+
+	var tightened, relaxed int
+	for len(published) > 0 {
+		switch e := <-published; e.Type {
+		case events.BudgetsTightened:
+			tightened++
+		case events.BudgetsRelaxed:
+			relaxed++
+		}
+	}
+	if tightened != 3 || relaxed != 3 {
+		t.Errorf("Expected 3 tightenings and 3 relaxings published, got %d and %d", tightened, relaxed)
+	}
+}
+
+func TestSample(t *testing.T) {
+	g := New(config.Default().Adaptive, events.NewBus())
+	var used time.Duration
+	g.cpu = func() (time.Duration, bool) { return used, true }
+	var drops uint64
+	signals := Signals{
+		Drops: func() (uint64, error) { return drops, nil },
+		Upstreams: func() []upstream.Stats {
+			return []upstream.Stats{{QPS: 30, LatencyP90: 20}, {QPS: 10, LatencyP90: 100}, {}}
+		},
+	}
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if load := g.sample(now, signals); load.CPU != -1 || load.Drops != -1 {
+		t.Errorf("Expected no rates from the first sample, got %+v", load)
+	}
+	used, drops = 5*time.Second, 500
+	load := g.sample(now.Add(5*time.Second), signals)
+	if load.Drops != 100 || load.UpstreamLatency != 40*time.Millisecond || load.CPU <= 0 {
+		t.Errorf("Expected 100 drops/s, a 40ms weighted p90 and some CPU, got %+v", load)
+	}
+}
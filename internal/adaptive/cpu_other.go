@@ -0,0 +1,10 @@
+//go:build !unix
+
+package adaptive
+
+import "time"
+
+// cpuTime is only implemented on Unix, where getrusage reports it
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"ddd/internal/blockfeed"
+)
+
+// maxFeedWait bounds how long a block feed poll may be held open
+const maxFeedWait = 5 * time.Minute
+
+// WithBlockFeed serves this node's blocks from p to subscribing peers
+func (s *Server) WithBlockFeed(p *blockfeed.Publisher) *Server {
+	s.blockFeed = p
+	return s
+}
+
+// handleBlockFeed long-polls the block feed: it answers as soon as the
+// feed's version differs from the since parameter, or with the unchanged
+// feed once the wait parameter (default 30s) has passed
+func (s *Server) handleBlockFeed(w http.ResponseWriter, r *http.Request) {
+	if s.blockFeed == nil {
+		writeError(w, http.StatusNotFound, "block feed is not available")
+		return
+	}
+
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be a feed version")
+			return
+		}
+		since = n
+	}
+	wait := 30 * time.Second
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxFeedWait {
+			writeError(w, http.StatusBadRequest, "wait must be a duration of at most 5m")
+			return
+		}
+		wait = d
+	}
+
+	writeJSON(w, http.StatusOK, s.blockFeed.Wait(r.Context(), since, wait))
+}
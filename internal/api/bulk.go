@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ddd/internal/severity"
+	"ddd/sdk"
+)
+
+const (
+	// maxBulkEntries bounds the entries of one bulk request
+	maxBulkEntries = 10000
+	// maxBulkBody bounds the size of a bulk request body
+	maxBulkBody = 4 << 20
+)
+
+// errNotBlocked fails a bulk unblock entry with no block to lift
+var errNotBlocked = errors.New("not blocked")
+
+// decodeBulk reads a bulk request, writing an error response and
+// returning false if it is invalid
+func (s *Server) decodeBulk(w http.ResponseWriter, r *http.Request) (sdk.BulkRequest, bool) {
+	var req sdk.BulkRequest
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return req, false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBulkBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+	switch {
+	case len(req.Entries) == 0:
+		writeError(w, http.StatusBadRequest, "entries must not be empty")
+		return req, false
+	case len(req.Entries) > maxBulkEntries:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("entries must number at most %d", maxBulkEntries))
+		return req, false
+	}
+	return req, true
+}
+
+// bulk applies apply to every entry of req, one failing entry not
+// stopping the others, and writes the per-entry results
+func (s *Server) bulk(w http.ResponseWriter, r *http.Request, action string, req sdk.BulkRequest, apply func(sdk.BulkEntry, *sdk.BulkResult) error) {
+	resp := sdk.BulkResponse{Results: make([]sdk.BulkResult, len(req.Entries))}
+	for i, entry := range req.Entries {
+		result := &resp.Results[i]
+		result.IP = entry.IP
+		if err := apply(entry, result); err != nil {
+			result.Error = err.Error()
+			resp.Failed++
+			continue
+		}
+		result.OK = true
+		resp.Succeeded++
+	}
+	s.log.Warnw("Bulk "+action+" through the admin API",
+		"remote", r.RemoteAddr, "entries", len(req.Entries), "succeeded", resp.Succeeded, "failed", resp.Failed)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleBulkBlock blocks many addresses and CIDRs in one request
+func (s *Server) handleBulkBlock(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeBulk(w, r)
+	if !ok {
+		return
+	}
+	s.bulk(w, r, "block", req, func(e sdk.BulkEntry, _ *sdk.BulkResult) error {
+		level := severity.None
+		if sev := firstOf(e.Severity, req.Severity); sev != "" {
+			var err error
+			if level, err = severity.Parse(sev); err != nil {
+				return err
+			}
+		}
+		reason := firstOf(e.Reason, req.Reason, "blocked through the admin API")
+		return s.blocker.BlockRange(e.IP, reason, level, req.Permanent)
+	})
+}
+
+// handleBulkUnblock lifts the blocks of many addresses and CIDRs in one
+// request. An entry that was not blocked fails.
+func (s *Server) handleBulkUnblock(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeBulk(w, r)
+	if !ok {
+		return
+	}
+	s.bulk(w, r, "unblock", req, func(e sdk.BulkEntry, _ *sdk.BulkResult) error {
+		unblocked, err := s.blocker.UnblockRange(e.IP)
+		if err == nil && !unblocked {
+			err = errNotBlocked
+		}
+		return err
+	})
+}
+
+// handleBulkAllowlist exempts many addresses and CIDRs from rate limiting
+// for a while in one request, needing approval like single exemptions
+func (s *Server) handleBulkAllowlist(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeBulk(w, r)
+	if !ok {
+		return
+	}
+	s.bulk(w, r, "allowlist", req, func(e sdk.BulkEntry, result *sdk.BulkResult) error {
+		d, err := time.ParseDuration(firstOf(e.Duration, req.Duration))
+		if err != nil {
+			return errors.New("duration must be a duration")
+		}
+		if d > s.cfg.Exemptions.MaxDuration {
+			return errors.New("duration must be at most " + s.cfg.Exemptions.MaxDuration.String())
+		}
+		x, err := s.blocker.Exempt(e.IP, d, req.RequestedBy, firstOf(e.Reason, req.Reason), s.cfg.Exemptions.RequireApproval)
+		if err != nil {
+			return err
+		}
+		result.ExemptionID, result.ExemptionState = x.ID, x.State
+		return nil
+	})
+}
+
+// firstOf returns the first of values that is not empty
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
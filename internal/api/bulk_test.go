@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/severity"
+	"ddd/sdk"
+)
+
+func TestBulkOperations(t *testing.T) {
+	b := blocker.NewIPBlocker(300, events.NewBus())
+	s := NewServer(config.Default(), logger.NewNop()).WithBlocker(b)
+
+	call := func(target, body string) (int, sdk.BulkResponse) {
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		var resp sdk.BulkResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := call("/api/v1/bulk/block", `{"reason":"botnet","severity":"high","entries":[
+		{"ip":"192.0.2.10"},{"ip":"198.51.100.0/24","reason":"scanner"},{"ip":"not-an-ip"},{"ip":"192.0.2.11","severity":"bogus"}]}`)
+	if code != http.StatusOK || resp.Succeeded != 2 || resp.Failed != 2 || len(resp.Results) != 4 {
+		t.Fatalf("Expected two of four entries blocked, got %d %+v", code, resp)
+	}
+	if !resp.Results[0].OK || resp.Results[2].OK || resp.Results[2].Error == "" || resp.Results[3].OK {
+		t.Errorf("Expected per-entry results in request order, got %+v", resp.Results)
+	}
+	if blocked := b.GetBlockedIP("192.0.2.10"); blocked == nil || blocked.Reason != "botnet" || blocked.Severity != severity.High {
+		t.Errorf("Expected the request's reason and severity, got %+v", blocked)
+	}
+	if !b.IsBlocked("198.51.100.7") {
+		t.Error("Expected the CIDR blocked")
+	}
+
+	code, resp = call("/api/v1/bulk/unblock", `{"entries":[{"ip":"192.0.2.10"},{"ip":"198.51.100.0/24"},{"ip":"203.0.113.1"}]}`)
+	if code != http.StatusOK || resp.Succeeded != 2 || resp.Results[2].Error != "not blocked" {
+		t.Fatalf("Expected the blocks lifted and the unknown entry failed, got %d %+v", code, resp)
+	}
+	if b.IsBlocked("192.0.2.10") || b.IsBlocked("198.51.100.7") {
+		t.Error("Expected the blocks lifted")
+	}
+
+	code, resp = call("/api/v1/bulk/allowlist", `{"duration":"4h","requested_by":"alice","entries":[{"ip":"203.0.113.0/24"},{"ip":"203.0.114.1","duration":"720h"}]}`)
+	if code != http.StatusOK || resp.Succeeded != 1 || resp.Results[0].ExemptionState != blocker.ExemptionPending || resp.Results[1].OK {
+		t.Fatalf("Expected one pending exemption and an overlong one refused, got %d %+v", code, resp)
+	}
+	if len(b.Exemptions()) != 1 {
+		t.Errorf("Expected one exemption, got %+v", b.Exemptions())
+	}
+
+	if code, _ := call("/api/v1/bulk/block", `{"entries":[]}`); code != http.StatusBadRequest {
+		t.Errorf("Expected an empty request rejected, got %d", code)
+	}
+	many := strings.Repeat(`{"ip":"192.0.2.1"},`, maxBulkEntries)
+	if code, _ := call("/api/v1/bulk/block", `{"entries":[`+many+`{"ip":"192.0.2.1"}]}`); code != http.StatusBadRequest {
+		t.Errorf("Expected too many entries rejected, got %d", code)
+	}
+}
@@ -0,0 +1,678 @@
+// Package client is the typed Go client for the admin API described in
+// api/openapi.yaml. Automation and ddctl use it instead of hand-rolled
+// HTTP calls.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/blocker"
+	"ddd/internal/blockfeed"
+	"ddd/internal/buildinfo"
+	"ddd/internal/detector"
+	"ddd/internal/dns"
+	"ddd/internal/eventfeed"
+	"ddd/internal/federation"
+	"ddd/internal/geoip"
+	"ddd/internal/killswitch"
+	"ddd/internal/monitor"
+	"ddd/internal/popularity"
+	"ddd/internal/upstream"
+	"ddd/internal/watch"
+	"ddd/sdk"
+)
+
+// operations maps each OpenAPI operationId to its method and path. The
+// package tests check it against the spec.
+var operations = map[string]operation{
+	"applyRateLimit":   {http.MethodPost, "/api/v1/ratelimits/apply"},
+	"approveExemption": {http.MethodPost, "/api/v1/exemptions/approve"},
+	"bulkAllowlist":    {http.MethodPost, "/api/v1/bulk/allowlist"},
+	"bulkBlock":        {http.MethodPost, "/api/v1/bulk/block"},
+	"bulkUnblock":      {http.MethodPost, "/api/v1/bulk/unblock"},
+	"clearPanic":       {http.MethodPost, "/api/v1/panic/clear"},
+	"engagePanic":      {http.MethodPost, "/api/v1/panic/engage"},
+	"getBlockFeed":     {http.MethodGet, "/api/v1/blocks/feed"},
+	"getClusterStats":  {http.MethodGet, "/api/v1/cluster/stats"},
+	"getConfig":        {http.MethodGet, "/api/v1/config"},
+	"getEventFeed":     {http.MethodGet, "/api/v1/events/feed"},
+	"getEventSummary":  {http.MethodGet, "/api/v1/events/summary"},
+	"getEvents":        {http.MethodGet, "/api/v1/events"},
+	"getExemptions":    {http.MethodGet, "/api/v1/exemptions"},
+	"getGeo":           {http.MethodGet, "/api/v1/geo"},
+	"getGrants":        {http.MethodGet, "/api/v1/grants"},
+	"getHistory":       {http.MethodGet, "/api/v1/history"},
+	"getListeners":     {http.MethodGet, "/api/v1/listeners"},
+	"getLogs":          {http.MethodGet, "/api/v1/logs"},
+	"getMetrics":       {http.MethodGet, "/metrics"},
+	"getPanic":         {http.MethodGet, "/api/v1/panic"},
+	"getProfile":       {http.MethodGet, "/api/v1/debug/profile"},
+	"getRateLimits":    {http.MethodGet, "/api/v1/ratelimits"},
+	"getReputation":    {http.MethodGet, "/api/v1/reputation"},
+	"getStats":         {http.MethodGet, "/api/v1/stats"},
+	"getTopDomains":    {http.MethodGet, "/api/v1/domains/top"},
+	"getTrace":         {http.MethodGet, "/api/v1/debug/trace"},
+	"getUpstreams":     {http.MethodGet, "/api/v1/upstreams"},
+	"getVersion":       {http.MethodGet, "/api/v1/version"},
+	"getWatch":         {http.MethodGet, "/api/v1/watch"},
+	"issueGrant":       {http.MethodPost, "/api/v1/grants/issue"},
+	"liftRateLimit":    {http.MethodPost, "/api/v1/ratelimits/lift"},
+	"replayDetection":  {http.MethodPost, "/api/v1/debug/replay"},
+	"releaseGrant":     {http.MethodPost, "/api/v1/grants/release"},
+	"reportAbuse":      {http.MethodPost, "/api/v1/reports"},
+	"requestExemption": {http.MethodPost, "/api/v1/exemptions/request"},
+	"revokeExemption":  {http.MethodPost, "/api/v1/exemptions/revoke"},
+	"revokeGrant":      {http.MethodPost, "/api/v1/grants/revoke"},
+	"startListener":    {http.MethodPost, "/api/v1/listeners/start"},
+	"stopListener":     {http.MethodPost, "/api/v1/listeners/stop"},
+}
+
+// operation is an API method and path
+type operation struct {
+	method string
+	path   string
+}
+
+// Error is a non-2xx response from the API
+type Error struct {
+	Status  int
+	Message string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.Status, e.Message)
+}
+
+// Client calls the admin API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// New creates a client for the API at baseURL (e.g. https://127.0.0.1:8080)
+// authenticating with token (may be empty)
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client, e.g. to trust a
+// private CA
+func (c *Client) WithHTTPClient(hc *http.Client) *Client {
+	c.http = hc
+	return c
+}
+
+// GetConfig returns the server's effective configuration
+func (c *Client) GetConfig(ctx context.Context) (map[string]interface{}, error) {
+	var cfg map[string]interface{}
+	if err := c.doJSON(ctx, "getConfig", nil, nil, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// GetGeo returns the query geography heat map covering the last since;
+// zero uses the server's default of one hour
+func (c *Client) GetGeo(ctx context.Context, since time.Duration) (*geoip.Snapshot, error) {
+	var query url.Values
+	if since > 0 {
+		query = url.Values{"since": {since.String()}}
+	}
+	var snap geoip.Snapshot
+	if err := c.doJSON(ctx, "getGeo", query, nil, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// GetTopDomains returns the limit most queried domains, most popular
+// first; zero uses the server's default of 100
+func (c *Client) GetTopDomains(ctx context.Context, limit int) ([]popularity.Domain, error) {
+	var query url.Values
+	if limit > 0 {
+		query = url.Values{"limit": {strconv.Itoa(limit)}}
+	}
+	var domains []popularity.Domain
+	if err := c.doJSON(ctx, "getTopDomains", query, nil, &domains); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// GetStats returns the server's own stats snapshot
+func (c *Client) GetStats(ctx context.Context) (*federation.Snapshot, error) {
+	var snap federation.Snapshot
+	if err := c.doJSON(ctx, "getStats", nil, nil, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// GetClusterStats returns the server's stats merged with those of its
+// federation peers
+func (c *Client) GetClusterStats(ctx context.Context) (*federation.View, error) {
+	var view federation.View
+	if err := c.doJSON(ctx, "getClusterStats", nil, nil, &view); err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// GetBlockFeed long-polls the server's block feed, returning once its
+// version differs from since or after wait (the server default of 30
+// seconds when 0)
+func (c *Client) GetBlockFeed(ctx context.Context, since uint64, wait time.Duration) (*blockfeed.Feed, error) {
+	query := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	var feed blockfeed.Feed
+	if err := c.doJSON(ctx, "getBlockFeed", query, nil, &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// GetEventFeed long-polls the events the server published from since on,
+// waiting up to wait (the server default of 30s when 0) for one
+func (c *Client) GetEventFeed(ctx context.Context, since uint64, wait time.Duration) (*eventfeed.Page, error) {
+	query := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	var page eventfeed.Page
+	if err := c.doJSON(ctx, "getEventFeed", query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetEvents returns the recent events the server holds that match f, the
+// last taken in first. A zero Since uses the server default of one hour
+// back and a zero Limit its default of 100.
+func (c *Client) GetEvents(ctx context.Context, f eventfeed.Filter) ([]eventfeed.Record, error) {
+	query := url.Values{}
+	if f.Type != "" {
+		query.Set("type", string(f.Type))
+	}
+	if f.IP != "" {
+		query.Set("ip", f.IP)
+	}
+	if f.Instance != "" {
+		query.Set("instance", f.Instance)
+	}
+	if !f.Since.IsZero() {
+		query.Set("since", max(time.Since(f.Since).Round(time.Second), time.Second).String())
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	var records []eventfeed.Record
+	if err := c.doJSON(ctx, "getEvents", query, nil, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// GetEventSummary aggregates the events of the last since (the server
+// default of one hour when 0)
+func (c *Client) GetEventSummary(ctx context.Context, since time.Duration) (*eventfeed.Summary, error) {
+	query := url.Values{}
+	if since > 0 {
+		query.Set("since", since.String())
+	}
+	var s eventfeed.Summary
+	if err := c.doJSON(ctx, "getEventSummary", query, nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetHistory returns the per-minute traffic of ip over since (the server
+// default of 24 hours when 0)
+func (c *Client) GetHistory(ctx context.Context, ip string, since time.Duration) (*archive.History, error) {
+	query := url.Values{"ip": {ip}}
+	if since > 0 {
+		query.Set("since", since.String())
+	}
+	var h archive.History
+	if err := c.doJSON(ctx, "getHistory", query, nil, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// GetUpstreams returns the health of each upstream resolver in
+// configuration order
+func (c *Client) GetUpstreams(ctx context.Context) ([]upstream.Stats, error) {
+	var stats []upstream.Stats
+	if err := c.doJSON(ctx, "getUpstreams", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetListeners returns the state of each DNS listener
+func (c *Client) GetListeners(ctx context.Context) ([]dns.ListenerStatus, error) {
+	var listeners []dns.ListenerStatus
+	if err := c.doJSON(ctx, "getListeners", nil, nil, &listeners); err != nil {
+		return nil, err
+	}
+	return listeners, nil
+}
+
+// StartListener starts the named DNS listener (protocol@address) and
+// returns the state of every listener
+func (c *Client) StartListener(ctx context.Context, name string) ([]dns.ListenerStatus, error) {
+	return c.controlListener(ctx, "startListener", name)
+}
+
+// StopListener stops the named DNS listener, leaving the others serving,
+// and returns the state of every listener
+func (c *Client) StopListener(ctx context.Context, name string) ([]dns.ListenerStatus, error) {
+	return c.controlListener(ctx, "stopListener", name)
+}
+
+// controlListener calls a listener start or stop operation
+func (c *Client) controlListener(ctx context.Context, op, name string) ([]dns.ListenerStatus, error) {
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	var listeners []dns.ListenerStatus
+	if err := c.doJSON(ctx, op, nil, bytes.NewReader(body), &listeners); err != nil {
+		return nil, err
+	}
+	return listeners, nil
+}
+
+// GetWatch returns the resolution health of each watched domain in
+// configuration order
+func (c *Client) GetWatch(ctx context.Context) ([]watch.Status, error) {
+	var status []watch.Status
+	if err := c.doJSON(ctx, "getWatch", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetPanic returns the panic mode status
+func (c *Client) GetPanic(ctx context.Context) (*killswitch.Status, error) {
+	var status killswitch.Status
+	if err := c.doJSON(ctx, "getPanic", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// EngagePanic engages panic mode, recording reason
+func (c *Client) EngagePanic(ctx context.Context, reason string) (*killswitch.Status, error) {
+	body, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return nil, err
+	}
+	var status killswitch.Status
+	if err := c.doJSON(ctx, "engagePanic", nil, bytes.NewReader(body), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ClearPanic clears panic mode
+func (c *Client) ClearPanic(ctx context.Context) (*killswitch.Status, error) {
+	var status killswitch.Status
+	if err := c.doJSON(ctx, "clearPanic", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// GetRateLimits returns the manual rate limits in force
+func (c *Client) GetRateLimits(ctx context.Context) ([]blocker.ManualLimit, error) {
+	var limits []blocker.ManualLimit
+	if err := c.doJSON(ctx, "getRateLimits", nil, nil, &limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// ApplyRateLimit caps ip at qps queries per second for window (0 uses the
+// server's default), recording reason
+func (c *Client) ApplyRateLimit(ctx context.Context, ip string, qps float64, window time.Duration, reason string) (*blocker.ManualLimit, error) {
+	req := map[string]interface{}{"ip": ip, "qps": qps, "reason": reason}
+	if window > 0 {
+		req["window"] = window.String()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var limit blocker.ManualLimit
+	if err := c.doJSON(ctx, "applyRateLimit", nil, bytes.NewReader(body), &limit); err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+// LiftRateLimit removes ip's manual rate limit and returns those still in
+// force
+func (c *Client) LiftRateLimit(ctx context.Context, ip string) ([]blocker.ManualLimit, error) {
+	body, err := json.Marshal(map[string]string{"ip": ip})
+	if err != nil {
+		return nil, err
+	}
+	var limits []blocker.ManualLimit
+	if err := c.doJSON(ctx, "liftRateLimit", nil, bytes.NewReader(body), &limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// GetExemptions returns the pending and active rate limit exemptions
+func (c *Client) GetExemptions(ctx context.Context) ([]blocker.Exemption, error) {
+	var exemptions []blocker.Exemption
+	if err := c.doJSON(ctx, "getExemptions", nil, nil, &exemptions); err != nil {
+		return nil, err
+	}
+	return exemptions, nil
+}
+
+// RequestExemption asks to exempt cidr (an address or CIDR) from rate
+// limiting for d on behalf of requestedBy
+func (c *Client) RequestExemption(ctx context.Context, cidr string, d time.Duration, requestedBy, note string) (*blocker.Exemption, error) {
+	body, err := json.Marshal(map[string]string{
+		"cidr":         cidr,
+		"duration":     d.String(),
+		"requested_by": requestedBy,
+		"note":         note,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var e blocker.Exemption
+	if err := c.doJSON(ctx, "requestExemption", nil, bytes.NewReader(body), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ApproveExemption approves the pending exemption id on behalf of
+// approvedBy
+func (c *Client) ApproveExemption(ctx context.Context, id uint64, approvedBy string) (*blocker.Exemption, error) {
+	body, err := json.Marshal(map[string]interface{}{"id": id, "approved_by": approvedBy})
+	if err != nil {
+		return nil, err
+	}
+	var e blocker.Exemption
+	if err := c.doJSON(ctx, "approveExemption", nil, bytes.NewReader(body), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// RevokeExemption ends the exemption id and returns those left
+func (c *Client) RevokeExemption(ctx context.Context, id uint64) ([]blocker.Exemption, error) {
+	body, err := json.Marshal(map[string]uint64{"id": id})
+	if err != nil {
+		return nil, err
+	}
+	var exemptions []blocker.Exemption
+	if err := c.doJSON(ctx, "revokeExemption", nil, bytes.NewReader(body), &exemptions); err != nil {
+		return nil, err
+	}
+	return exemptions, nil
+}
+
+// GetGrants returns the scheduled and running budget grants
+func (c *Client) GetGrants(ctx context.Context) ([]blocker.Grant, error) {
+	var grants []blocker.Grant
+	if err := c.doJSON(ctx, "getGrants", nil, nil, &grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// IssueGrant mints a grant multiplying the budgets of cidr by factor for
+// the window of d from start (now when zero), for job on behalf of
+// requestedBy. The grant's token is in the result and is not shown again.
+func (c *Client) IssueGrant(ctx context.Context, cidr string, factor float64, start time.Time, d time.Duration, job, requestedBy, note string) (*blocker.IssuedGrant, error) {
+	req := map[string]interface{}{
+		"cidr":         cidr,
+		"factor":       factor,
+		"duration":     d.String(),
+		"job":          job,
+		"requested_by": requestedBy,
+		"note":         note,
+	}
+	if !start.IsZero() {
+		req["start"] = start.Format(time.RFC3339)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var g blocker.IssuedGrant
+	if err := c.doJSON(ctx, "issueGrant", nil, bytes.NewReader(body), &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// RevokeGrant ends the budget grant id and returns those left
+func (c *Client) RevokeGrant(ctx context.Context, id uint64) ([]blocker.Grant, error) {
+	body, err := json.Marshal(map[string]uint64{"id": id})
+	if err != nil {
+		return nil, err
+	}
+	var grants []blocker.Grant
+	if err := c.doJSON(ctx, "revokeGrant", nil, bytes.NewReader(body), &grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// ReleaseGrant ends the budget grant the client's token belongs to. Batch
+// jobs call it with a client created with their grant token rather than
+// an API token.
+func (c *Client) ReleaseGrant(ctx context.Context) (*blocker.Grant, error) {
+	var g blocker.Grant
+	if err := c.doJSON(ctx, "releaseGrant", nil, nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// BulkBlock blocks the entries of req, reporting the outcome of each
+func (c *Client) BulkBlock(ctx context.Context, req sdk.BulkRequest) (*sdk.BulkResponse, error) {
+	return c.bulk(ctx, "bulkBlock", req)
+}
+
+// BulkUnblock lifts the blocks of the entries of req, reporting the
+// outcome of each
+func (c *Client) BulkUnblock(ctx context.Context, req sdk.BulkRequest) (*sdk.BulkResponse, error) {
+	return c.bulk(ctx, "bulkUnblock", req)
+}
+
+// BulkAllowlist exempts the entries of req from rate limiting, reporting
+// the outcome of each
+func (c *Client) BulkAllowlist(ctx context.Context, req sdk.BulkRequest) (*sdk.BulkResponse, error) {
+	return c.bulk(ctx, "bulkAllowlist", req)
+}
+
+// bulk sends a bulk request to op
+func (c *Client) bulk(ctx context.Context, op string, req sdk.BulkRequest) (*sdk.BulkResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp sdk.BulkResponse
+	if err := c.doJSON(ctx, op, nil, bytes.NewReader(body), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetReputation returns what the server knows about ip
+func (c *Client) GetReputation(ctx context.Context, ip string) (*sdk.Reputation, error) {
+	var rep sdk.Reputation
+	if err := c.doJSON(ctx, "getReputation", url.Values{"ip": {ip}}, nil, &rep); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// ReportAbuse reports abuse by a client seen by another service and
+// returns the client's reputation afterwards
+func (c *Client) ReportAbuse(ctx context.Context, report sdk.Report) (*sdk.Reputation, error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	var rep sdk.Reputation
+	if err := c.doJSON(ctx, "reportAbuse", nil, bytes.NewReader(body), &rep); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// GetMetrics returns the server's metrics in the Prometheus text format
+func (c *Client) GetMetrics(ctx context.Context) (string, error) {
+	body, err := c.do(ctx, "getMetrics", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// GetVersion returns the server's build information
+func (c *Client) GetVersion(ctx context.Context) (*buildinfo.Info, error) {
+	var info buildinfo.Info
+	if err := c.doJSON(ctx, "getVersion", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetLogs returns the last lines of the server's log file (0 uses the
+// server default)
+func (c *Client) GetLogs(ctx context.Context, lines int) (string, error) {
+	query := url.Values{}
+	if lines > 0 {
+		query.Set("lines", strconv.Itoa(lines))
+	}
+	body, err := c.do(ctx, "getLogs", query, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// GetProfile returns the named runtime profile in the pprof format, or as
+// text with debug 1 or 2
+func (c *Client) GetProfile(ctx context.Context, name string, debug int) ([]byte, error) {
+	query := url.Values{"name": {name}}
+	if debug > 0 {
+		query.Set("debug", strconv.Itoa(debug))
+	}
+	return c.do(ctx, "getProfile", query, nil)
+}
+
+// GetTrace returns the event stream the monitor holds for ip
+func (c *Client) GetTrace(ctx context.Context, ip string) (*monitor.Trace, error) {
+	var trace monitor.Trace
+	if err := c.doJSON(ctx, "getTrace", url.Values{"ip": {ip}}, nil, &trace); err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
+
+// ReplayDetection re-runs detection on trace as it stood at at (zero uses
+// the trace's dump time)
+func (c *Client) ReplayDetection(ctx context.Context, trace monitor.Trace, at time.Time) (*detector.Replay, error) {
+	req := map[string]interface{}{"trace": trace}
+	if !at.IsZero() {
+		req["at"] = at
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var replay detector.Replay
+	if err := c.doJSON(ctx, "replayDetection", nil, bytes.NewReader(body), &replay); err != nil {
+		return nil, err
+	}
+	return &replay, nil
+}
+
+// doJSON performs an operation and decodes its JSON response into out
+func (c *Client) doJSON(ctx context.Context, op string, query url.Values, in io.Reader, out interface{}) error {
+	body, err := c.do(ctx, op, query, in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// do performs an operation with optional query parameters and returns the
+// response body
+func (c *Client) do(ctx context.Context, op string, query url.Values, in io.Reader) ([]byte, error) {
+	o, ok := operations[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %s", op)
+	}
+
+	target := c.baseURL + o.path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, o.method, target, in)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+		var decoded struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &decoded) == nil && decoded.Error != "" {
+			apiErr.Message = decoded.Error
+		}
+		return nil, apiErr
+	}
+
+	return body, nil
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestOperationsMatchSpec keeps the client in sync with api/openapi.yaml
+func TestOperationsMatchSpec(t *testing.T) {
+	data, err := os.ReadFile("../../../api/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `yaml:"operationId"`
+		} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			got, ok := operations[op.OperationID]
+			if !ok {
+				t.Errorf("Spec operation %s (%s %s) has no client method", op.OperationID, method, path)
+				continue
+			}
+			if got.method != strings.ToUpper(method) || got.path != path {
+				t.Errorf("Operation %s is %s %s in the client but %s %s in the spec",
+					op.OperationID, got.method, got.path, strings.ToUpper(method), path)
+			}
+			seen[op.OperationID] = true
+		}
+	}
+
+	for id := range operations {
+		if !seen[id] {
+			t.Errorf("Client operation %s is missing from the spec", id)
+		}
+	}
+}
+
+func TestClientSendsTokenAndDecodesErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`{"server":{"port":53}}`))
+	}))
+	defer srv.Close()
+
+	cfg, err := New(srv.URL, "secret").GetConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg["server"]; !ok {
+		t.Errorf("Expected server section, got %v", cfg)
+	}
+
+	_, err = New(srv.URL, "wrong").GetConfig(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || apiErr.Message != "unauthorized" {
+		t.Errorf("Expected unauthorized API error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"ddd/internal/eventfeed"
+	"ddd/internal/events"
+)
+
+// summaryTop is how many clients and domains an event summary ranks
+const summaryTop = 20
+
+// WithEventLog serves the recent events in l: this node's own as a feed
+// for replicas, and all of them, followed ones included, for queries
+func (s *Server) WithEventLog(l *eventfeed.Log) *Server {
+	s.events = l
+	return s
+}
+
+// handleEventFeed long-polls the events this node published from the
+// since parameter on, answering as soon as there is one or once the wait
+// parameter (default 30s) has passed
+func (s *Server) handleEventFeed(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeError(w, http.StatusNotFound, "event feed is not available")
+		return
+	}
+
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be a sequence number")
+			return
+		}
+		since = n
+	}
+	wait := 30 * time.Second
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxFeedWait {
+			writeError(w, http.StatusBadRequest, "wait must be a duration of at most 5m")
+			return
+		}
+		wait = d
+	}
+
+	writeJSON(w, http.StatusOK, s.events.Wait(r.Context(), since, wait))
+}
+
+// handleEvents returns the recent events held, the last taken in first,
+// filtered by the type, ip and instance parameters and limited to the
+// since (default 1h) and limit (default 100) parameters
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeError(w, http.StatusNotFound, "events are not available")
+		return
+	}
+
+	q := r.URL.Query()
+	since, ok := eventsSince(w, r)
+	if !ok {
+		return
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, s.events.Query(eventfeed.Filter{
+		Type:     events.Type(q.Get("type")),
+		IP:       q.Get("ip"),
+		Instance: q.Get("instance"),
+		Since:    time.Now().Add(-since),
+		Limit:    limit,
+	}))
+}
+
+// handleEventSummary aggregates the events of the last since (default 1h)
+// by type and instance, with the clients and domains they name most
+func (s *Server) handleEventSummary(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeError(w, http.StatusNotFound, "events are not available")
+		return
+	}
+
+	since, ok := eventsSince(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	writeJSON(w, http.StatusOK, s.events.Summarize(now.Add(-since), now, summaryTop))
+}
+
+// eventsSince parses the since parameter of an event query, answering
+// the request itself when it is invalid
+func eventsSince(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	since := time.Hour
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "since must be a positive duration")
+			return 0, false
+		}
+		since = d
+	}
+	return since, true
+}
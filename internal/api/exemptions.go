@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ExemptionRequest is the body of a request to exempt an address or
+// network from rate limiting
+type ExemptionRequest struct {
+	CIDR        string `json:"cidr"`
+	Duration    string `json:"duration"` // how long the exemption lasts, e.g. "4h"
+	RequestedBy string `json:"requested_by"`
+	Note        string `json:"note,omitempty"`
+}
+
+// ApproveExemptionRequest is the body of a request to approve a pending
+// exemption
+type ApproveExemptionRequest struct {
+	ID         uint64 `json:"id"`
+	ApprovedBy string `json:"approved_by"`
+}
+
+// RevokeExemptionRequest is the body of a request to end an exemption
+type RevokeExemptionRequest struct {
+	ID uint64 `json:"id"`
+}
+
+// handleExemptions returns the pending and active exemptions
+func (s *Server) handleExemptions(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.blocker.Exemptions())
+}
+
+// handleRequestExemption exempts an address or network from rate limiting
+// and detection for a while, at once or, with exemptions.require_approval,
+// once another operator approves
+func (s *Server) handleRequestExemption(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req ExemptionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "duration must be a duration")
+		return
+	}
+	if d > s.cfg.Exemptions.MaxDuration {
+		writeError(w, http.StatusBadRequest, "duration must be at most "+s.cfg.Exemptions.MaxDuration.String())
+		return
+	}
+
+	e, err := s.blocker.Exempt(req.CIDR, d, req.RequestedBy, req.Note, s.cfg.Exemptions.RequireApproval)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.log.Warnw("Rate limit exemption requested through the admin API",
+		"remote", r.RemoteAddr, "id", e.ID, "cidr", e.CIDR, "duration", d.String(),
+		"requested_by", e.RequestedBy, "note", e.Note, "state", e.State)
+	writeJSON(w, http.StatusOK, e)
+}
+
+// handleApproveExemption activates a pending exemption
+func (s *Server) handleApproveExemption(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req ApproveExemptionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	e, err := s.blocker.ApproveExemption(req.ID, req.ApprovedBy)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.log.Warnw("Rate limit exemption approved through the admin API",
+		"remote", r.RemoteAddr, "id", e.ID, "cidr", e.CIDR, "approved_by", e.ApprovedBy)
+	writeJSON(w, http.StatusOK, e)
+}
+
+// handleRevokeExemption ends an exemption before it expires and returns
+// those left
+func (s *Server) handleRevokeExemption(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req RevokeExemptionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	e, err := s.blocker.RevokeExemption(req.ID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.log.Warnw("Rate limit exemption revoked through the admin API",
+		"remote", r.RemoteAddr, "id", e.ID, "cidr", e.CIDR)
+	writeJSON(w, http.StatusOK, s.blocker.Exemptions())
+}
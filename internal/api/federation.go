@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+
+	"ddd/internal/api/client"
+	"ddd/internal/blocker"
+	"ddd/internal/blockfeed"
+	"ddd/internal/config"
+	"ddd/internal/eventfeed"
+	"ddd/internal/events"
+	"ddd/internal/federation"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+var peerFetchErrors = metrics.NewCounterVec("ddd_federation_fetch_errors_total",
+	"Failed fetches of a peer's stats snapshot for the cluster view", "peer")
+
+// Peer is another instance whose stats the cluster view merges
+type Peer struct {
+	Name   string
+	Client *client.Client
+}
+
+// NewPeers creates clients for the configured federation peers, trusting
+// the CAs in cfg.CA when it is set
+func NewPeers(cfg config.FederationConfig) ([]Peer, error) {
+	hc, err := peerHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]Peer, 0, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		peers = append(peers, Peer{
+			Name:   p.Name,
+			Client: client.New(p.URL, p.Token.Value()).WithHTTPClient(hc),
+		})
+	}
+	return peers, nil
+}
+
+// NewSubscribers creates a block feed subscriber, adopting blocks into b,
+// for each configured subscription. Slow propagation is reported on bus.
+func NewSubscribers(cfg config.FederationConfig, b *blocker.IPBlocker, bus *events.Bus, log *logger.Logger) ([]*blockfeed.Subscriber, error) {
+	hc, err := peerHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]*blockfeed.Subscriber, 0, len(cfg.Subscribe))
+	for _, sc := range cfg.Subscribe {
+		trust := blockfeed.Trust{MaxTTL: sc.MaxTTL, MinBits4: sc.MinPrefixV4, MinBits6: sc.MinPrefixV6}
+		if sc.MinSeverity != "" {
+			if trust.MinSeverity, err = severity.Parse(sc.MinSeverity); err != nil {
+				return nil, err
+			}
+		}
+		for _, n := range sc.Networks {
+			prefix, err := netip.ParsePrefix(n)
+			if err != nil {
+				return nil, err
+			}
+			trust.Networks = append(trust.Networks, prefix.Masked())
+		}
+		c := client.New(sc.URL, sc.Token.Value()).WithHTTPClient(hc)
+		sub := blockfeed.NewSubscriber(sc.Name, c.GetBlockFeed, trust, b, cfg.Wait, cfg.Timeout, log)
+		subs = append(subs, sub.AlertAfter(cfg.PropagationAlert, bus))
+	}
+	return subs, nil
+}
+
+// NewFollowers creates an event feed follower, publishing on bus, for each
+// configured peer
+func NewFollowers(cfg config.FederationConfig, bus *events.Bus, log *logger.Logger) ([]*eventfeed.Follower, error) {
+	hc, err := peerHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	followers := make([]*eventfeed.Follower, 0, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		c := client.New(p.URL, p.Token.Value()).WithHTTPClient(hc)
+		followers = append(followers, eventfeed.NewFollower(p.Name, c.GetEventFeed, bus, cfg.Wait, cfg.Timeout, log))
+	}
+	return followers, nil
+}
+
+// peerHTTPClient returns the HTTP client for talking to other instances,
+// trusting the CAs in cfg.CA when it is set. It has no overall timeout;
+// callers bound each request with a context.
+func peerHTTPClient(cfg config.FederationConfig) (*http.Client, error) {
+	hc := &http.Client{}
+	if cfg.CA != "" {
+		pem, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CA)
+		}
+		hc.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}
+	}
+	return hc, nil
+}
+
+// WithFederation serves this node's stats snapshot from local, and a
+// cluster view merging it with the snapshots of peers
+func (s *Server) WithFederation(local *federation.Local, peers []Peer) *Server {
+	s.local = local
+	s.peers = peers
+	return s
+}
+
+// handleStats returns this node's stats snapshot
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if s.local == nil {
+		writeError(w, http.StatusNotFound, "stats are not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.local.Snapshot())
+}
+
+// handleClusterStats fetches every peer's snapshot concurrently and returns
+// them merged with this node's. Unreachable peers are reported in the
+// view rather than failing the request.
+func (s *Server) handleClusterStats(w http.ResponseWriter, r *http.Request) {
+	if s.local == nil {
+		writeError(w, http.StatusNotFound, "stats are not available")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Federation.Timeout)
+	defer cancel()
+
+	results := make([]federation.Result, len(s.peers)+1)
+	local := s.local.Snapshot()
+	results[0] = federation.Result{Node: s.local.Node(), Snapshot: &local}
+
+	var wg sync.WaitGroup
+	for i, p := range s.peers {
+		wg.Add(1)
+		go func(i int, p Peer) {
+			defer wg.Done()
+			snap, err := p.Client.GetStats(ctx)
+			if err != nil {
+				peerFetchErrors.With(p.Name).Inc()
+				s.log.Warnw("Failed to fetch peer stats", "peer", p.Name, "error", err)
+			}
+			results[i+1] = federation.Result{Node: p.Name, Snapshot: snap, Err: err}
+		}(i, p)
+	}
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, federation.Merge(results, s.cfg.Federation.TopTalkers))
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GrantRequest is the body of a request to mint a budget grant for a
+// batch job
+type GrantRequest struct {
+	CIDR   string  `json:"cidr"`
+	Factor float64 `json:"factor"` // budget multiplier, above 1
+	// Start is when the window opens, in RFC 3339; now when left out
+	Start       string `json:"start,omitempty"`
+	Duration    string `json:"duration"` // how long the window lasts, e.g. "3h"
+	Job         string `json:"job"`
+	RequestedBy string `json:"requested_by"`
+	Note        string `json:"note,omitempty"`
+}
+
+// RevokeGrantRequest is the body of a request to end a budget grant
+type RevokeGrantRequest struct {
+	ID uint64 `json:"id"`
+}
+
+// handleGrants returns the scheduled and running budget grants
+func (s *Server) handleGrants(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.blocker.Grants())
+}
+
+// handleIssueGrant mints a grant raising a network's budgets for a
+// declared window, within the grants limits of the configuration
+func (s *Server) handleIssueGrant(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req GrantRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	now := time.Now()
+	start := now
+	if req.Start != "" {
+		t, err := time.Parse(time.RFC3339, req.Start)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "start must be an RFC 3339 time")
+			return
+		}
+		start = t
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		writeError(w, http.StatusBadRequest, "duration must be a positive duration")
+		return
+	}
+	limits := s.cfg.Grants
+	switch {
+	case d > limits.MaxDuration:
+		writeError(w, http.StatusBadRequest, "duration must be at most "+limits.MaxDuration.String())
+		return
+	case start.Sub(now) > limits.MaxLead:
+		writeError(w, http.StatusBadRequest, "start must be at most "+limits.MaxLead.String()+" ahead")
+		return
+	case req.Factor > limits.MaxFactor:
+		writeError(w, http.StatusBadRequest, "factor must be at most "+strconv.FormatFloat(limits.MaxFactor, 'g', -1, 64))
+		return
+	}
+
+	g, err := s.blocker.IssueGrant(req.CIDR, req.Factor, start, start.Add(d), req.Job, req.RequestedBy, req.Note)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.log.Warnw("Budget grant issued through the admin API",
+		"remote", r.RemoteAddr, "id", g.ID, "cidr", g.CIDR, "factor", g.Factor, "job", g.Job,
+		"start", g.Start, "until", g.Until, "requested_by", g.RequestedBy, "note", g.Note)
+	writeJSON(w, http.StatusOK, g)
+}
+
+// handleRevokeGrant ends a budget grant before its window closes and
+// returns those left
+func (s *Server) handleRevokeGrant(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req RevokeGrantRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	g, err := s.blocker.RevokeGrant(req.ID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.log.Warnw("Budget grant revoked through the admin API",
+		"remote", r.RemoteAddr, "id", g.ID, "cidr", g.CIDR, "job", g.Job)
+	writeJSON(w, http.StatusOK, s.blocker.Grants())
+}
+
+// handleReleaseGrant ends the grant whose token the request bears. It is
+// authenticated by that token rather than the API token, so that batch
+// jobs need no operator access to give their budget back early.
+func (s *Server) handleReleaseGrant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	g, err := s.blocker.ReleaseGrant(token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	s.log.Warnw("Budget grant released by its job",
+		"remote", r.RemoteAddr, "id", g.ID, "cidr", g.CIDR, "job", g.Job)
+	writeJSON(w, http.StatusOK, g)
+}
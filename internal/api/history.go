@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/monitor"
+)
+
+// WithHistory serves per-client history from the archive, topped up with
+// the minute the monitor has not spilled yet
+func (s *Server) WithHistory(store *archive.Store, tm *monitor.TrafficMonitor) *Server {
+	s.archive = store
+	s.monitor = tm
+	return s
+}
+
+// handleHistory returns the per-minute traffic of the client given by the
+// ip parameter over the duration given by since (default 24 hours)
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.archive == nil {
+		writeError(w, http.StatusNotFound, "history archive is not enabled")
+		return
+	}
+
+	ip := net.ParseIP(r.URL.Query().Get("ip"))
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "ip must be an IP address")
+		return
+	}
+	since := 24 * time.Hour
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "since must be a positive duration")
+			return
+		}
+		since = d
+	}
+
+	h := archive.History{IP: ip.String(), Since: time.Now().Add(-since).Truncate(time.Minute)}
+	minutes, err := s.archive.History(h.IP, h.Since)
+	if err != nil {
+		s.log.Errorw("Failed to read history archive", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to read history archive")
+		return
+	}
+	if cur, ok := s.monitor.CurrentMinute(h.IP); ok {
+		if n := len(minutes); n > 0 && minutes[n-1].Minute.Equal(cur.Minute) {
+			minutes[n-1].Requests += cur.Requests
+			minutes[n-1].NewDomains += cur.NewDomains
+			minutes[n-1].Failures += cur.Failures
+		} else {
+			minutes = append(minutes, cur)
+		}
+	}
+	h.Minutes = minutes
+	writeJSON(w, http.StatusOK, h)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ddd/internal/dns"
+)
+
+// ListenerRequest names a DNS listener to start or stop
+type ListenerRequest struct {
+	Name string `json:"name"` // protocol@address, e.g. "doh@:443"
+}
+
+// ListenerControl starts and stops the DNS server's listeners one at a
+// time
+type ListenerControl interface {
+	Listeners() []dns.ListenerStatus
+	StartListener(name string) error
+	StopListener(name string) error
+}
+
+// WithListeners lets operators start and stop DNS listeners through c
+func (s *Server) WithListeners(c ListenerControl) *Server {
+	s.listeners = c
+	return s
+}
+
+// handleListeners returns the state of each DNS listener
+func (s *Server) handleListeners(w http.ResponseWriter, r *http.Request) {
+	if s.listeners == nil {
+		writeError(w, http.StatusNotFound, "listeners are not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.listeners.Listeners())
+}
+
+// handleStartListener opens and serves a stopped listener
+func (s *Server) handleStartListener(w http.ResponseWriter, r *http.Request) {
+	s.controlListener(w, r, "started", func(name string) error { return s.listeners.StartListener(name) })
+}
+
+// handleStopListener stops a listener, leaving the others serving
+func (s *Server) handleStopListener(w http.ResponseWriter, r *http.Request) {
+	s.controlListener(w, r, "stopped", func(name string) error { return s.listeners.StopListener(name) })
+}
+
+// controlListener applies op to the listener named in the request body and
+// returns the state of every listener
+func (s *Server) controlListener(w http.ResponseWriter, r *http.Request, done string, op func(name string) error) {
+	if s.listeners == nil {
+		writeError(w, http.StatusNotFound, "listeners are not available")
+		return
+	}
+	var req ListenerRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := op(req.Name); errors.Is(err, dns.ErrNoListener) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.log.Warnw("DNS listener "+done+" through the admin API", "remote", r.RemoteAddr, "listener", req.Name)
+	writeJSON(w, http.StatusOK, s.listeners.Listeners())
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ddd/internal/config"
+	"ddd/internal/dns"
+	"ddd/internal/logger"
+)
+
+// fakeListeners is a set of listeners that start and stop on request
+type fakeListeners map[string]bool
+
+func (f fakeListeners) Listeners() []dns.ListenerStatus {
+	var statuses []dns.ListenerStatus
+	for name, running := range f {
+		statuses = append(statuses, dns.ListenerStatus{Name: name, Running: running})
+	}
+	return statuses
+}
+
+func (f fakeListeners) set(name string, running bool) error {
+	if _, ok := f[name]; !ok {
+		return fmt.Errorf("%w %s", dns.ErrNoListener, name)
+	}
+	f[name] = running
+	return nil
+}
+
+func (f fakeListeners) StartListener(name string) error { return f.set(name, true) }
+func (f fakeListeners) StopListener(name string) error  { return f.set(name, false) }
+
+func TestListenerStartStop(t *testing.T) {
+	listeners := fakeListeners{"udp@:53": true, "doh@:443": true}
+	s := NewServer(config.Default(), logger.NewNop()).WithListeners(listeners)
+
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/listeners/stop", strings.NewReader(`{"name":"doh@:443"}`)))
+	var statuses []dns.ListenerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected the listeners' state, got %d: %v", rec.Code, err)
+	}
+	if listeners["doh@:443"] || !listeners["udp@:53"] {
+		t.Errorf("Expected only the DoH listener stopped, got %v", listeners)
+	}
+
+	rec = httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/listeners/start", strings.NewReader(`{"name":"dot@:853"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown listener to be not found, got %d", rec.Code)
+	}
+}
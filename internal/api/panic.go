@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"ddd/internal/killswitch"
+)
+
+// PanicRequest is the body of a request to engage panic mode
+type PanicRequest struct {
+	Reason string `json:"reason"`
+}
+
+// WithKillSwitch lets operators engage and clear panic mode through sw
+func (s *Server) WithKillSwitch(sw *killswitch.Switch) *Server {
+	s.killSwitch = sw
+	return s
+}
+
+// handlePanic returns the panic mode status
+func (s *Server) handlePanic(w http.ResponseWriter, r *http.Request) {
+	if s.killSwitch == nil {
+		writeError(w, http.StatusNotFound, "panic mode is not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.killSwitch.Status())
+}
+
+// handleEngagePanic engages panic mode with the reason in the body
+func (s *Server) handleEngagePanic(w http.ResponseWriter, r *http.Request) {
+	if s.killSwitch == nil {
+		writeError(w, http.StatusNotFound, "panic mode is not available")
+		return
+	}
+	var req PanicRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	status := s.killSwitch.Engage(req.Reason)
+	s.log.Warnw("Panic mode engaged through the admin API", "remote", r.RemoteAddr, "reason", req.Reason)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleClearPanic clears panic mode
+func (s *Server) handleClearPanic(w http.ResponseWriter, r *http.Request) {
+	if s.killSwitch == nil {
+		writeError(w, http.StatusNotFound, "panic mode is not available")
+		return
+	}
+	status := s.killSwitch.Clear()
+	s.log.Warnw("Panic mode cleared through the admin API", "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, status)
+}
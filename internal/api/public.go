@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+	"ddd/internal/upgrade"
+)
+
+// StatsSource supplies the aggregate counters the public endpoint exposes
+type StatsSource struct {
+	Queries func() uint64 // total queries received
+	Blocked func() int    // clients currently blocked
+}
+
+// PublicStats is the sanitized view served to status pages. It must never
+// carry client addresses, domains or configuration.
+type PublicStats struct {
+	QPS           float64 `json:"qps"`
+	QueriesTotal  uint64  `json:"queries_total"`
+	BlockedIPs    int     `json:"blocked_ips"`
+	UptimeSeconds int64   `json:"uptime_seconds"`
+}
+
+// PublicServer is an unauthenticated, read-only stats endpoint. It runs on
+// its own listener and mux so nothing from the operator API is reachable
+// through it.
+type PublicServer struct {
+	cfg        config.PublicConfig
+	source     StatsSource
+	log        *logger.Logger
+	started    time.Time
+	httpServer *http.Server
+
+	mu        sync.Mutex
+	snapshot  PublicStats
+	snapAt    time.Time
+	lastCount uint64
+	computed  bool
+	limiter   *windowLimiter
+}
+
+// publicSnapshotTTL is how long a computed snapshot is served before it is
+// recomputed; it also sets the QPS averaging period
+const publicSnapshotTTL = 5 * time.Second
+
+// NewPublicServer creates the public stats server
+func NewPublicServer(cfg config.PublicConfig, source StatsSource, log *logger.Logger) *PublicServer {
+	s := &PublicServer{
+		cfg:     cfg,
+		source:  source,
+		log:     log,
+		started: time.Now(),
+		limiter: newWindowLimiter(cfg.RateLimit, time.Minute),
+	}
+	s.snapAt = s.started
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", s.handleStats)
+
+	s.httpServer = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+	}
+
+	return s
+}
+
+// Start starts serving. It returns nil when the endpoint is disabled.
+func (s *PublicServer) Start() error {
+	if s.cfg.Listen == "" {
+		return nil
+	}
+
+	s.log.Infow("Public stats endpoint listening", "addr", s.cfg.Listen)
+
+	listener, err := upgrade.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	err = s.httpServer.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop gracefully shuts down the server
+func (s *PublicServer) Stop(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// handleStats serves the current snapshot, rate limited per client
+func (s *PublicServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.limiter.allow(host) {
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=5")
+	writeJSON(w, http.StatusOK, s.stats(time.Now()))
+}
+
+// stats returns the cached snapshot, recomputing it once it is stale
+func (s *PublicServer) stats(now time.Time) PublicStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := now.Sub(s.snapAt)
+	if s.computed && elapsed < publicSnapshotTTL {
+		return s.snapshot
+	}
+
+	count := s.source.Queries()
+	s.snapshot = PublicStats{
+		QueriesTotal:  count,
+		BlockedIPs:    s.source.Blocked(),
+		UptimeSeconds: int64(now.Sub(s.started) / time.Second),
+	}
+	if elapsed > 0 {
+		s.snapshot.QPS = float64(count-s.lastCount) / elapsed.Seconds()
+	}
+	s.snapAt, s.lastCount, s.computed = now, count, true
+
+	return s.snapshot
+}
+
+// windowLimiter allows up to limit requests per key in each fixed window
+type windowLimiter struct {
+	mu          sync.Mutex
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	counts      map[string]int
+}
+
+// newWindowLimiter creates a limiter; a non-positive limit allows everything
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{limit: limit, window: window, counts: make(map[string]int)}
+}
+
+// allow records a request for key and reports whether it is within limit
+func (l *windowLimiter) allow(key string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.windowStart) >= l.window {
+		l.windowStart = now
+		l.counts = make(map[string]int)
+	}
+
+	l.counts[key]++
+	return l.counts[key] <= l.limit
+}
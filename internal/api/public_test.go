@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+)
+
+func newTestPublicServer(t *testing.T, rateLimit int) *PublicServer {
+	t.Helper()
+	return NewPublicServer(config.PublicConfig{RateLimit: rateLimit}, StatsSource{
+		Queries: func() uint64 { return 1200 },
+		Blocked: func() int { return 3 },
+	}, logger.NewNop())
+}
+
+func TestPublicStats(t *testing.T) {
+	s := newTestPublicServer(t, 10)
+
+	rec := httptest.NewRecorder()
+	s.handleStats(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var stats PublicStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.QueriesTotal != 1200 || stats.BlockedIPs != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestPublicStatsRateLimited(t *testing.T) {
+	s := newTestPublicServer(t, 2)
+
+	codes := make([]int, 3)
+	for i := range codes {
+		rec := httptest.NewRecorder()
+		s.handleStats(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		codes[i] = rec.Code
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("Expected the third request to be rate limited, got %v", codes)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ddd/internal/blocker"
+)
+
+// defaultManualWindow is how long a manual rate limit lasts when the
+// request gives no window
+const defaultManualWindow = time.Hour
+
+// RateLimitRequest is the body of a request to rate limit an address
+type RateLimitRequest struct {
+	IP     string  `json:"ip"`
+	QPS    float64 `json:"qps"`
+	Window string  `json:"window,omitempty"` // how long the limit lasts, e.g. "30m"; default 1h
+	Reason string  `json:"reason,omitempty"`
+}
+
+// LiftRateLimitRequest is the body of a request to lift a manual rate limit
+type LiftRateLimitRequest struct {
+	IP string `json:"ip"`
+}
+
+// WithBlocker lets operators rate limit addresses by hand through b
+func (s *Server) WithBlocker(b *blocker.IPBlocker) *Server {
+	s.blocker = b
+	return s
+}
+
+// handleRateLimits returns the manual rate limits in force
+func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	limits := s.blocker.ManualLimits()
+	if limits == nil {
+		limits = []blocker.ManualLimit{}
+	}
+	writeJSON(w, http.StatusOK, limits)
+}
+
+// handleApplyRateLimit caps an address's query rate
+func (s *Server) handleApplyRateLimit(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req RateLimitRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	window := defaultManualWindow
+	if req.Window != "" {
+		d, err := time.ParseDuration(req.Window)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "window must be a duration")
+			return
+		}
+		window = d
+	}
+
+	limit, err := s.blocker.LimitManually(req.IP, req.QPS, window, req.Reason)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.log.Warnw("Manual rate limit applied through the admin API",
+		"remote", r.RemoteAddr, "ip", limit.IP, "qps", limit.QPS, "window", window.String(), "reason", req.Reason)
+	writeJSON(w, http.StatusOK, limit)
+}
+
+// handleLiftRateLimit removes an address's manual rate limit
+func (s *Server) handleLiftRateLimit(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "rate limiting is not available")
+		return
+	}
+	var req LiftRateLimitRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !s.blocker.LiftManualLimit(req.IP) {
+		writeError(w, http.StatusNotFound, "no manual rate limit for "+req.IP)
+		return
+	}
+	s.log.Warnw("Manual rate limit lifted through the admin API", "remote", r.RemoteAddr, "ip", req.IP)
+	writeJSON(w, http.StatusOK, s.blocker.ManualLimits())
+}
@@ -0,0 +1,374 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/dualstack"
+	"ddd/internal/journal"
+	"ddd/internal/severity"
+	"ddd/sdk"
+)
+
+const (
+	// maxReportedClients bounds the clients whose reports are remembered
+	maxReportedClients = 10000
+	// maxReportsPerClient bounds the reports remembered per client; the
+	// oldest go first
+	maxReportsPerClient = 64
+	// maxEvidence bounds the evidence attached to a report, in bytes
+	maxEvidence = 8 << 10
+)
+
+// severityPoints is the risk score a report of each severity adds, before
+// its reporter's trust weight
+var severityPoints = map[severity.Level]float64{
+	severity.Low:    10,
+	severity.Medium: 25,
+	severity.High:   50,
+}
+
+// trustWeight scales the risk score of a report by its reporter's trust
+var trustWeight = map[string]float64{
+	config.TrustHigh:   2,
+	config.TrustMedium: 1,
+	config.TrustLow:    0.5,
+}
+
+// reportLog keeps the abuse reports about each client over its window,
+// for their count and the client's risk score
+type reportLog struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string][]report
+}
+
+// report is one report's time and risk score
+type report struct {
+	at     time.Time
+	points float64
+}
+
+// newReportLog creates an empty report log counting reports for window
+func newReportLog(window time.Duration) *reportLog {
+	return &reportLog{window: window, clients: make(map[string][]report)}
+}
+
+// add records a report about ip at now and returns the client's risk
+// score with it. When the log is full of clients reported within the
+// window, reports about new clients only score themselves.
+func (l *reportLog) add(ip string, now time.Time, points float64) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reports, ok := l.clients[ip]
+	if !ok && len(l.clients) >= maxReportedClients {
+		l.pruneLocked(now)
+		if len(l.clients) >= maxReportedClients {
+			return points
+		}
+	}
+	reports = append(l.recentLocked(reports, now), report{at: now, points: points})
+	if len(reports) > maxReportsPerClient {
+		reports = reports[len(reports)-maxReportsPerClient:]
+	}
+	l.clients[ip] = reports
+	return score(reports)
+}
+
+// get returns the reports about ip within the window, the client's risk
+// score and the last report's time
+func (l *reportLog) get(ip string, now time.Time) (int, float64, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reports := l.recentLocked(l.clients[ip], now)
+	if len(reports) == 0 {
+		return 0, 0, time.Time{}
+	}
+	return len(reports), score(reports), reports[len(reports)-1].at
+}
+
+// recentLocked returns the reports made within the window, oldest first
+func (l *reportLog) recentLocked(reports []report, now time.Time) []report {
+	for len(reports) > 0 && now.Sub(reports[0].at) > l.window {
+		reports = reports[1:]
+	}
+	return reports
+}
+
+// pruneLocked forgets clients whose reports are all older than the window
+func (l *reportLog) pruneLocked(now time.Time) {
+	for ip, reports := range l.clients {
+		if len(l.recentLocked(reports, now)) == 0 {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// storedClient is the stored form of the reports about one client
+type storedClient struct {
+	IP      string         `json:"ip"`
+	Reports []storedReport `json:"reports"`
+}
+
+// storedReport is the stored form of one report
+type storedReport struct {
+	At     time.Time `json:"at"`
+	Points float64   `json:"points"`
+}
+
+// ExportReports writes the abuse reports within the window as JSON, for
+// storage or handing to another process
+func (s *Server) ExportReports(w io.Writer) (int, error) {
+	l := s.reports
+	now := time.Now()
+	l.mu.Lock()
+	clients := make([]storedClient, 0, len(l.clients))
+	for ip, reports := range l.clients {
+		reports = l.recentLocked(reports, now)
+		if len(reports) == 0 {
+			continue
+		}
+		c := storedClient{IP: ip, Reports: make([]storedReport, len(reports))}
+		for i, r := range reports {
+			c.Reports[i] = storedReport{At: r.at, Points: r.points}
+		}
+		clients = append(clients, c)
+	}
+	l.mu.Unlock()
+	return len(clients), json.NewEncoder(w).Encode(clients)
+}
+
+// ImportReports adds the reports written by ExportReports that are still
+// within the window, for clients without reports of their own
+func (s *Server) ImportReports(r io.Reader) (int, error) {
+	var clients []storedClient
+	if err := json.NewDecoder(r).Decode(&clients); err != nil {
+		return 0, err
+	}
+	l := s.reports
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	imported := 0
+	for _, c := range clients {
+		if _, ok := l.clients[c.IP]; ok || len(l.clients) >= maxReportedClients {
+			continue
+		}
+		var reports []report
+		for _, r := range c.Reports {
+			reports = append(reports, report{at: r.At, points: r.Points})
+		}
+		sort.Slice(reports, func(i, j int) bool { return reports[i].at.Before(reports[j].at) })
+		if reports = l.recentLocked(reports, now); len(reports) == 0 {
+			continue
+		}
+		if len(reports) > maxReportsPerClient {
+			reports = reports[len(reports)-maxReportsPerClient:]
+		}
+		l.clients[c.IP] = reports
+		imported++
+	}
+	return imported, nil
+}
+
+// score sums the risk score of reports
+func score(reports []report) float64 {
+	var total float64
+	for _, r := range reports {
+		total += r.points
+	}
+	return total
+}
+
+// WithJournal records decisions made on abuse reports in j
+func (s *Server) WithJournal(j *journal.Journal) *Server {
+	s.journal = j
+	return s
+}
+
+// WithDualStack extends reputations to the addresses c links to the one
+// asked about
+func (s *Server) WithDualStack(c *dualstack.Correlator) *Server {
+	s.dualStack = c
+	return s
+}
+
+// handleReputation returns what is known about the client given by the ip
+// parameter
+func (s *Server) handleReputation(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "reputation is not available")
+		return
+	}
+	ip := net.ParseIP(r.URL.Query().Get("ip"))
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "ip must be an IP address")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.reputation(ip.String()))
+}
+
+// handleReportAbuse takes a report of abuse by a client seen by another
+// service with its evidence. The reporter is identified by its token, not
+// by the source it names, and reports without one get the default trust.
+// The report raises the client's risk score, weighted by how far its
+// reporter is trusted; highly trusted reporters' reports are acted on as
+// asked, medium ones' at most by a rate limit, and a client whose score
+// reaches reports.block_score is blocked. It returns the client's
+// reputation afterwards.
+func (s *Server) handleReportAbuse(w http.ResponseWriter, r *http.Request) {
+	if s.blocker == nil {
+		writeError(w, http.StatusNotFound, "reputation is not available")
+		return
+	}
+	var req sdk.Report
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxEvidence)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	trust := s.cfg.Reports.DefaultTrust
+	if token := r.Header.Get(sdk.ReporterTokenHeader); token != "" {
+		reporter, ok := s.cfg.Reports.ReporterFor(token)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unknown reporter token")
+			return
+		}
+		req.Source, trust = reporter.Name, reporter.Trust
+	}
+	ip := net.ParseIP(req.IP)
+	switch {
+	case ip == nil:
+		writeError(w, http.StatusBadRequest, "ip must be an IP address")
+		return
+	case req.Source == "":
+		writeError(w, http.StatusBadRequest, "source must name the reporting service")
+		return
+	case len(req.Evidence) > maxEvidence:
+		writeError(w, http.StatusBadRequest, "evidence must be at most 8 KiB")
+		return
+	}
+	level := severity.Low
+	if req.Severity != "" {
+		var err error
+		if level, err = severity.Parse(req.Severity); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	switch req.Action {
+	case "":
+		req.Action = sdk.ActionRateLimit
+	case sdk.ActionBlock, sdk.ActionRateLimit, sdk.ActionNone:
+	default:
+		writeError(w, http.StatusBadRequest, "action must be block, rate_limit or none")
+		return
+	}
+
+	if trust == config.TrustNone {
+		writeError(w, http.StatusForbidden, "reports from "+req.Source+" are not accepted")
+		return
+	}
+
+	client := ip.String()
+	now := time.Now()
+	risk := s.reports.add(client, now, severityPoints[level]*trustWeight[trust])
+	for _, peer := range s.dualStack.Peers(client, now) {
+		_, peerRisk, _ := s.reports.get(peer, now)
+		risk += peerRisk
+	}
+	action := req.Action
+	switch {
+	case s.cfg.Reports.BlockScore > 0 && risk >= s.cfg.Reports.BlockScore:
+		action = sdk.ActionBlock
+	case trust == config.TrustLow:
+		action = sdk.ActionNone
+	case trust == config.TrustMedium && action == sdk.ActionBlock:
+		action = sdk.ActionRateLimit
+	}
+
+	reason := "reported by " + req.Source
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	switch action {
+	case sdk.ActionBlock:
+		s.blocker.BlockIPWithSeverity(client, reason, level)
+	case sdk.ActionRateLimit:
+		s.blocker.RateLimitIP(client)
+	}
+	if action != sdk.ActionNone {
+		s.journal.Record(journal.Entry{
+			Client:      client,
+			Source:      journal.SourceReport,
+			Severity:    level,
+			Description: reason,
+			Evidence:    req.Evidence,
+			Inputs:      map[string]float64{"risk_score": risk},
+			Thresholds:  map[string]float64{"block_score": s.cfg.Reports.BlockScore},
+			Decision:    action,
+			DecidedBy:   "report",
+		})
+	}
+
+	s.log.Warnw("Abuse reported through the admin API",
+		"remote", r.RemoteAddr, "ip", client, "source", req.Source, "trust", trust, "reason", req.Reason,
+		"severity", level.String(), "risk_score", risk, "requested", req.Action, "action", action)
+	writeJSON(w, http.StatusOK, s.reputation(client))
+}
+
+// reputation gathers what is known about ip
+func (s *Server) reputation(ip string) sdk.Reputation {
+	rep := sdk.Reputation{
+		IP:          ip,
+		Blocked:     s.blocker.IsBlocked(ip),
+		RateLimited: s.blocker.IsRateLimited(ip),
+	}
+	if rep.Blocked {
+		if b := s.blocker.GetBlockedIP(ip); b != nil {
+			until := b.BlockUntil
+			rep.BlockReason = b.Reason
+			rep.Severity = b.Severity.String()
+			rep.BlockedUntil = &until
+			rep.BlockCount = b.BlockCount
+		}
+	}
+	for _, limit := range s.blocker.ManualLimits() {
+		if limit.IP == ip {
+			rep.ManualLimit = &sdk.ManualLimit{
+				IP:      limit.IP,
+				QPS:     limit.QPS,
+				Reason:  limit.Reason,
+				Created: limit.Created,
+				Until:   limit.Until,
+			}
+			break
+		}
+	}
+	if s.monitor != nil {
+		window := s.monitor.RateWindow()
+		rep.Window = window.String()
+		rep.Requests = s.monitor.GetRecentRequestCount(ip, window)
+		rep.Failures = s.monitor.GetRecentFailureCount(ip, window)
+		rep.NewDomains = s.monitor.GetRecentNewDomainCount(ip, window)
+	}
+	now := time.Now()
+	rep.Linked = s.dualStack.Peers(ip, now)
+	for _, addr := range append([]string{ip}, rep.Linked...) {
+		n, risk, last := s.reports.get(addr, now)
+		rep.Reports += n
+		rep.RiskScore += risk
+		if n > 0 && (rep.LastReport == nil || last.After(*rep.LastReport)) {
+			rep.LastReport = &last
+		}
+	}
+	return rep
+}
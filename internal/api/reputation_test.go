@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/journal"
+	"ddd/internal/logger"
+	"ddd/sdk"
+)
+
+func TestReportAbuse(t *testing.T) {
+	log := logger.NewNop()
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal.jsonl"), nil, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	cfg := config.Default()
+	cfg.Reports.Reporters = []config.ReporterConfig{{Name: "login", Trust: config.TrustHigh, Token: config.InlineSecret("login-token")}}
+	b := blocker.NewIPBlocker(300, events.NewBus())
+	s := NewServer(cfg, log).WithBlocker(b).WithJournal(j)
+
+	call := func(method, target, body string) (int, sdk.Reputation) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(sdk.ReporterTokenHeader, "login-token")
+		s.mux.ServeHTTP(rec, req)
+		var rep sdk.Reputation
+		json.Unmarshal(rec.Body.Bytes(), &rep)
+		return rec.Code, rep
+	}
+
+	if code, rep := call(http.MethodGet, "/api/v1/reputation?ip=192.0.2.10", ""); code != http.StatusOK || rep.Blocked || rep.Reports != 0 {
+		t.Fatalf("Expected a clean reputation, got %d %+v", code, rep)
+	}
+
+	code, rep := call(http.MethodPost, "/api/v1/reports",
+		`{"ip":"192.0.2.10","source":"login","reason":"credential stuffing","severity":"high","action":"block"}`)
+	if code != http.StatusOK || !rep.Blocked || rep.Severity != "high" || rep.Reports != 1 ||
+		rep.BlockReason != "reported by login: credential stuffing" {
+		t.Fatalf("Expected a high severity block, got %d %+v", code, rep)
+	}
+	if !b.IsBlocked("192.0.2.10") {
+		t.Error("Expected the reported client to be blocked")
+	}
+
+	if code, rep := call(http.MethodPost, "/api/v1/reports", `{"ip":"192.0.2.11","source":"login"}`); code != http.StatusOK || !rep.RateLimited {
+		t.Errorf("Expected a rate limit by default, got %d %+v", code, rep)
+	}
+	if code, rep := call(http.MethodPost, "/api/v1/reports", `{"ip":"192.0.2.12","source":"login","action":"none"}`); code != http.StatusOK || rep.RateLimited || rep.Reports != 1 {
+		t.Errorf("Expected the report only counted, got %d %+v", code, rep)
+	}
+
+	if code, _ := call(http.MethodPost, "/api/v1/reports", `{"ip":"192.0.2.10","source":"login","evidence":"`+strings.Repeat("x", maxEvidence+1)+`"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected oversized evidence to be rejected, got %d", code)
+	}
+	for _, body := range []string{
+		`{"ip":"bogus","source":"login"}`,
+		`{"ip":"192.0.2.10","source":"login","severity":"extreme"}`,
+		`{"ip":"192.0.2.10","source":"login","action":"ban"}`,
+	} {
+		if code, _ := call(http.MethodPost, "/api/v1/reports", body); code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, code)
+		}
+	}
+}
+
+func TestReporterTrust(t *testing.T) {
+	cfg := config.Default()
+	cfg.Reports.Reporters = []config.ReporterConfig{
+		{Name: "waf", Trust: config.TrustHigh, Token: config.InlineSecret("waf-token")},
+		{Name: "mail", Trust: config.TrustMedium, Token: config.InlineSecret("mail-token")},
+		{Name: "ids", Trust: config.TrustLow, Token: config.InlineSecret("ids-token")},
+	}
+	b := blocker.NewIPBlocker(300, events.NewBus())
+	s := NewServer(cfg, logger.NewNop()).WithBlocker(b)
+
+	report := func(token, body string) (int, sdk.Reputation) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/reports", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(sdk.ReporterTokenHeader, token)
+		}
+		s.mux.ServeHTTP(rec, req)
+		var rep sdk.Reputation
+		json.Unmarshal(rec.Body.Bytes(), &rep)
+		return rec.Code, rep
+	}
+
+	// Naming a trusted source is not enough without its token
+	if code, _ := report("", `{"ip":"192.0.2.1","source":"waf","action":"block"}`); code != http.StatusForbidden {
+		t.Errorf("Expected a report without a reporter token to be refused, got %d", code)
+	}
+	if code, _ := report("guess", `{"ip":"192.0.2.1","source":"waf","action":"block"}`); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown reporter token to be refused, got %d", code)
+	}
+
+	// A low trust reporter only raises the score: 0.5 x 50 per high
+	// report, whatever source it names
+	for i := 1; i <= 3; i++ {
+		_, rep := report("ids-token", `{"ip":"192.0.2.2","source":"waf","severity":"high","action":"block"}`)
+		if rep.Blocked || rep.RateLimited || rep.RiskScore != float64(25*i) {
+			t.Fatalf("Report %d: expected only a score of %d, got %+v", i, 25*i, rep)
+		}
+	}
+	// ...until it reaches the block score
+	if _, rep := report("ids-token", `{"ip":"192.0.2.2","severity":"high"}`); !rep.Blocked || rep.RiskScore != 100 ||
+		rep.BlockReason != "reported by ids" {
+		t.Errorf("Expected a block at the block score, got %+v", rep)
+	}
+
+	// A medium trust reporter can rate limit but not block
+	if _, rep := report("mail-token", `{"ip":"192.0.2.3","severity":"medium","action":"block"}`); rep.Blocked || !rep.RateLimited {
+		t.Errorf("Expected a rate limit instead of a block, got %+v", rep)
+	}
+
+	// A high trust reporter's action is taken as asked
+	if _, rep := report("waf-token", `{"ip":"192.0.2.4","action":"block"}`); !rep.Blocked || rep.RiskScore != 20 {
+		t.Errorf("Expected an immediate block, got %+v", rep)
+	}
+}
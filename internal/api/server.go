@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/blocker"
+	"ddd/internal/blockfeed"
+	"ddd/internal/config"
+	"ddd/internal/detector"
+	"ddd/internal/dualstack"
+	"ddd/internal/eventfeed"
+	"ddd/internal/federation"
+	"ddd/internal/geoip"
+	"ddd/internal/journal"
+	"ddd/internal/killswitch"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+	"ddd/internal/monitor"
+	"ddd/internal/popularity"
+	"ddd/internal/update"
+	"ddd/internal/upgrade"
+	"ddd/internal/upstream"
+	"ddd/internal/watch"
+)
+
+// Server is the admin HTTP API
+type Server struct {
+	cfg        *config.Config
+	log        *logger.Logger
+	mux        *http.ServeMux
+	httpServer *http.Server
+	geo        *geoip.Heatmap
+	popularity *popularity.Tracker
+	local      *federation.Local
+	peers      []Peer
+	killSwitch *killswitch.Switch
+	archive    *archive.Store
+	monitor    *monitor.TrafficMonitor
+	detector   *detector.DDoSDetector
+	upstreams  func() []upstream.Stats
+	listeners  ListenerControl
+	blockFeed  *blockfeed.Publisher
+	blocker    *blocker.IPBlocker
+	updates    *update.Checker
+	journal    *journal.Journal
+	reports    *reportLog
+	watch      *watch.List
+	dualStack  *dualstack.Correlator
+	events     *eventfeed.Log
+}
+
+// NewServer creates a new admin API server
+func NewServer(cfg *config.Config, log *logger.Logger) *Server {
+	s := &Server{
+		cfg:     cfg,
+		log:     log,
+		mux:     http.NewServeMux(),
+		reports: newReportLog(cfg.Reports.Window),
+	}
+
+	s.Handle("/api/v1/config", http.MethodGet, s.handleConfig)
+	s.Handle("/api/v1/geo", http.MethodGet, s.handleGeo)
+	s.Handle("/api/v1/domains/top", http.MethodGet, s.handleTopDomains)
+	s.Handle("/api/v1/stats", http.MethodGet, s.handleStats)
+	s.Handle("/api/v1/cluster/stats", http.MethodGet, s.handleClusterStats)
+	s.Handle("/api/v1/blocks/feed", http.MethodGet, s.handleBlockFeed)
+	s.Handle("/api/v1/events", http.MethodGet, s.handleEvents)
+	s.Handle("/api/v1/events/feed", http.MethodGet, s.handleEventFeed)
+	s.Handle("/api/v1/events/summary", http.MethodGet, s.handleEventSummary)
+	s.Handle("/api/v1/history", http.MethodGet, s.handleHistory)
+	s.Handle("/api/v1/upstreams", http.MethodGet, s.handleUpstreams)
+	s.Handle("/api/v1/listeners", http.MethodGet, s.handleListeners)
+	s.Handle("/api/v1/listeners/start", http.MethodPost, s.handleStartListener)
+	s.Handle("/api/v1/listeners/stop", http.MethodPost, s.handleStopListener)
+	s.Handle("/api/v1/watch", http.MethodGet, s.handleWatch)
+	s.Handle("/api/v1/ratelimits", http.MethodGet, s.handleRateLimits)
+	s.Handle("/api/v1/ratelimits/apply", http.MethodPost, s.handleApplyRateLimit)
+	s.Handle("/api/v1/ratelimits/lift", http.MethodPost, s.handleLiftRateLimit)
+	s.Handle("/api/v1/exemptions", http.MethodGet, s.handleExemptions)
+	s.Handle("/api/v1/exemptions/request", http.MethodPost, s.handleRequestExemption)
+	s.Handle("/api/v1/exemptions/approve", http.MethodPost, s.handleApproveExemption)
+	s.Handle("/api/v1/exemptions/revoke", http.MethodPost, s.handleRevokeExemption)
+	s.Handle("/api/v1/grants", http.MethodGet, s.handleGrants)
+	s.Handle("/api/v1/grants/issue", http.MethodPost, s.handleIssueGrant)
+	s.Handle("/api/v1/grants/revoke", http.MethodPost, s.handleRevokeGrant)
+	s.mux.HandleFunc("/api/v1/grants/release", s.handleReleaseGrant)
+	s.Handle("/api/v1/bulk/block", http.MethodPost, s.handleBulkBlock)
+	s.Handle("/api/v1/bulk/unblock", http.MethodPost, s.handleBulkUnblock)
+	s.Handle("/api/v1/bulk/allowlist", http.MethodPost, s.handleBulkAllowlist)
+	s.Handle("/api/v1/reputation", http.MethodGet, s.handleReputation)
+	s.Handle("/api/v1/reports", http.MethodPost, s.handleReportAbuse)
+	s.Handle("/api/v1/panic", http.MethodGet, s.handlePanic)
+	s.Handle("/api/v1/panic/engage", http.MethodPost, s.handleEngagePanic)
+	s.Handle("/api/v1/panic/clear", http.MethodPost, s.handleClearPanic)
+	s.Handle("/api/v1/version", http.MethodGet, s.handleVersion)
+	s.Handle("/api/v1/logs", http.MethodGet, s.handleLogs)
+	s.Handle("/api/v1/debug/profile", http.MethodGet, s.handleProfile)
+	s.Handle("/api/v1/debug/trace", http.MethodGet, s.handleTrace)
+	s.Handle("/api/v1/debug/replay", http.MethodPost, s.handleReplay)
+	s.Handle("/metrics", http.MethodGet, metrics.Default.Handler())
+
+	s.httpServer = &http.Server{
+		Addr:              cfg.API.Listen,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return s
+}
+
+// WithGeo serves the query geography heat map from h
+func (s *Server) WithGeo(h *geoip.Heatmap) *Server {
+	s.geo = h
+	return s
+}
+
+// WithPopularity serves the domain popularity ranking from t
+func (s *Server) WithPopularity(t *popularity.Tracker) *Server {
+	s.popularity = t
+	return s
+}
+
+// Handle registers an authenticated handler for a single method
+func (s *Server) Handle(path, method string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// Start starts serving the API. It returns nil when the API is disabled.
+func (s *Server) Start() error {
+	if s.cfg.API.Listen == "" {
+		return nil
+	}
+	if s.cfg.API.Token.Value() == "" {
+		if !s.cfg.API.Insecure {
+			return errors.New("admin API has no token; set api.token, or api.insecure to serve it unauthenticated")
+		}
+		s.log.Warnw("Admin API serving without a token: anyone who can reach it can block clients and change the configuration",
+			"addr", s.cfg.API.Listen)
+	}
+
+	s.log.Infow("Admin API listening", "addr", s.cfg.API.Listen, "tls", s.cfg.API.TLSCert != "")
+
+	listener, err := upgrade.Listen("tcp", s.cfg.API.Listen)
+	if err != nil {
+		return err
+	}
+	if s.cfg.API.TLSCert != "" {
+		err = s.serveTLS(listener)
+	} else {
+		err = s.httpServer.Serve(listener)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop gracefully shuts down the API server
+func (s *Server) Stop(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// serveTLS serves HTTPS on listener using the certificate file and the
+// private key resolved from its secret reference
+func (s *Server) serveTLS(listener net.Listener) error {
+	certPEM, err := os.ReadFile(s.cfg.API.TLSCert)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, []byte(s.cfg.API.TLSKey.Value()))
+	if err != nil {
+		return err
+	}
+
+	s.httpServer.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return s.httpServer.ServeTLS(listener, "", "")
+}
+
+// authorized checks the bearer token when one is configured. Start only
+// serves without one when api.insecure is set.
+func (s *Server) authorized(r *http.Request) bool {
+	token := s.cfg.API.Token.Value()
+	if token == "" {
+		return true
+	}
+
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// handleConfig returns the effective configuration with secrets redacted
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	effective, err := s.cfg.Effective()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, effective)
+}
+
+// handleGeo returns per-country and per-ASN query and attack counts over
+// the duration given by the since parameter (default one hour)
+func (s *Server) handleGeo(w http.ResponseWriter, r *http.Request) {
+	if s.geo == nil {
+		writeError(w, http.StatusNotFound, "geoip is not enabled")
+		return
+	}
+
+	since := time.Hour
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "since must be a positive duration")
+			return
+		}
+		since = d
+	}
+	writeJSON(w, http.StatusOK, s.geo.Snapshot(time.Now().Add(-since)))
+}
+
+// handleTopDomains returns the most queried domains, most popular first,
+// limited by the limit parameter (default 100)
+func (s *Server) handleTopDomains(w http.ResponseWriter, r *http.Request) {
+	if s.popularity == nil {
+		writeError(w, http.StatusNotFound, "popularity tracking is not enabled")
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, s.popularity.Top(limit))
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
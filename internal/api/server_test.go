@@ -0,0 +1,16 @@
+package api
+
+import (
+	"testing"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+)
+
+func TestStartRequiresToken(t *testing.T) {
+	cfg := config.Default()
+	cfg.API.Listen = "127.0.0.1:0"
+	if err := NewServer(cfg, logger.NewNop()).Start(); err == nil {
+		t.Error("Expected the admin API to refuse to start without a token")
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"strconv"
+
+	"ddd/internal/buildinfo"
+	"ddd/internal/update"
+)
+
+const (
+	// defaultLogLines and maxLogLines bound the log tail served
+	defaultLogLines = 1000
+	maxLogLines     = 100000
+	// maxLogTail is the most bytes read from the end of the log file
+	maxLogTail = 64 << 20
+)
+
+// profiles are the runtime profiles served for support requests
+var profiles = map[string]bool{
+	"goroutine":    true,
+	"heap":         true,
+	"allocs":       true,
+	"threadcreate": true,
+	"block":        true,
+	"mutex":        true,
+}
+
+// WithUpdates reports newer releases found by c in version responses
+func (s *Server) WithUpdates(c *update.Checker) *Server {
+	s.updates = c
+	return s
+}
+
+// handleVersion returns the running binary's build information and the
+// features its configuration enables
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	info := buildinfo.Read()
+	info.Features = s.cfg.Features()
+	if latest := s.updates.Latest(); latest != nil {
+		info.LatestRelease, info.ReleaseURL = latest.Version, latest.URL
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleLogs returns the last lines of the log file, given by the lines
+// parameter (default 1000)
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	lines := defaultLogLines
+	if v := r.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLogLines {
+			writeError(w, http.StatusBadRequest, "lines must be between 1 and "+strconv.Itoa(maxLogLines))
+			return
+		}
+		lines = n
+	}
+
+	tail, err := tailFile(s.cfg.Log.File, lines, maxLogTail)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "log file does not exist")
+		return
+	}
+	if err != nil {
+		s.log.Errorw("Failed to read log file", "file", s.cfg.Log.File, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to read log file")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(tail)
+}
+
+// handleProfile writes the runtime profile given by the name parameter,
+// in the pprof format or, with debug=1 or 2, as text
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if !profiles[name] {
+		writeError(w, http.StatusBadRequest, "name must be one of goroutine, heap, allocs, threadcreate, block or mutex")
+		return
+	}
+	debug := 0
+	if v := r.URL.Query().Get("debug"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 2 {
+			writeError(w, http.StatusBadRequest, "debug must be 0, 1 or 2")
+			return
+		}
+		debug = n
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup(name).WriteTo(&buf, debug); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Write(buf.Bytes())
+}
+
+// tailFile returns the last n lines of the file at path, reading at most
+// limit bytes from its end
+func tailFile(path string, n int, limit int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	// Read backwards in chunks until n lines are covered: they start
+	// after the n+1th newline from the end, counting the one ending the
+	// last line
+	const chunk = 64 << 10
+	var chunks [][]byte
+	read, newlines := int64(0), 0
+	offset := size
+	for offset > 0 && read < limit && newlines <= n {
+		length := min(int64(chunk), offset)
+		offset -= length
+		buf := make([]byte, length)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunks = append(chunks, buf)
+		read += length
+		newlines += bytes.Count(buf, []byte("\n"))
+	}
+	tail := make([]byte, 0, read)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		tail = append(tail, chunks[i]...)
+	}
+
+	if offset > 0 && newlines <= n {
+		// Stopped at the byte limit: drop the partial first line
+		if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+
+	trimmed := bytes.TrimSuffix(tail, []byte("\n"))
+	for i := len(trimmed) - 1; i >= 0; i-- {
+		if trimmed[i] != '\n' {
+			continue
+		}
+		if n--; n == 0 {
+			return tail[i+1:], nil
+		}
+	}
+	return tail, nil
+}
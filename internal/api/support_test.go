@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTailFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ddd.log")
+	var b strings.Builder
+	for i := 1; i <= 50000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := tailFile(path, 3, maxLogTail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(tail); got != "line 49998\nline 49999\nline 50000\n" {
+		t.Errorf("Expected the last 3 lines, got %q", got)
+	}
+
+	// More lines than the file has returns all of it
+	tail, err = tailFile(path, maxLogLines, maxLogTail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tail) != b.Len() {
+		t.Errorf("Expected the whole file (%d bytes), got %d", b.Len(), len(tail))
+	}
+
+	// The byte limit caps how far back it reads, in whole chunks, without
+	// a partial first line
+	tail, err = tailFile(path, maxLogLines, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tail) > 64<<10 || !strings.HasPrefix(string(tail), "line ") || !strings.HasSuffix(string(tail), "line 50000\n") {
+		t.Errorf("Expected whole lines from one chunk ending with the last line, got %d bytes", len(tail))
+	}
+
+	if _, err := tailFile(filepath.Join(t.TempDir(), "missing.log"), 3, maxLogTail); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"ddd/internal/detector"
+	"ddd/internal/monitor"
+)
+
+// maxTraceBody bounds a replay request, which carries a whole trace
+const maxTraceBody = 8 << 20
+
+// ReplayRequest is the body of a detection replay
+type ReplayRequest struct {
+	Trace monitor.Trace `json:"trace"`
+	At    time.Time     `json:"at"` // the trace's dump time when zero
+}
+
+// WithDetector replays detection decisions with the detector's thresholds
+func (s *Server) WithDetector(d *detector.DDoSDetector) *Server {
+	s.detector = d
+	return s
+}
+
+// handleTrace returns the monitor's event stream for the client given by
+// the ip parameter
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if s.monitor == nil {
+		writeError(w, http.StatusNotFound, "traffic monitor is not available")
+		return
+	}
+	ip := net.ParseIP(r.URL.Query().Get("ip"))
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "ip must be an IP address")
+		return
+	}
+	trace, ok := s.monitor.Trace(ip.String())
+	if !ok {
+		writeError(w, http.StatusNotFound, "no traffic from "+ip.String())
+		return
+	}
+	writeJSON(w, http.StatusOK, trace)
+}
+
+// handleReplay runs detection on a trace as it stood at the requested time
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if s.monitor == nil || s.detector == nil {
+		writeError(w, http.StatusNotFound, "detection replay is not available")
+		return
+	}
+	var req ReplayRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTraceBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if net.ParseIP(req.Trace.IP) == nil {
+		writeError(w, http.StatusBadRequest, "trace.ip must be an IP address")
+		return
+	}
+	at := req.At
+	if at.IsZero() {
+		at = req.Trace.Dumped
+	}
+	if at.IsZero() {
+		writeError(w, http.StatusBadRequest, "at is required for a trace without a dump time")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.detector.Replay(req.Trace, s.monitor.Retention(), at))
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+
+	"ddd/internal/upstream"
+)
+
+// WithUpstreams serves the health of the upstream resolvers from stats
+func (s *Server) WithUpstreams(stats func() []upstream.Stats) *Server {
+	s.upstreams = stats
+	return s
+}
+
+// handleUpstreams returns the health, rates, latency and circuit breaker
+// state of each upstream resolver
+func (s *Server) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	if s.upstreams == nil {
+		writeError(w, http.StatusNotFound, "upstream statistics are not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.upstreams())
+}
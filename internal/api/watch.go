@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"ddd/internal/watch"
+)
+
+// WithWatch serves the health of the watched domains from l
+func (s *Server) WithWatch(l *watch.List) *Server {
+	s.watch = l
+	return s
+}
+
+// handleWatch returns the resolution success rate, latency and state of
+// each watched domain
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if s.watch == nil {
+		writeError(w, http.StatusNotFound, "watch list is not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.watch.Snapshot(time.Now()))
+}
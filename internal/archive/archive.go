@@ -0,0 +1,336 @@
+// Package archive is the warm tier of query history. The traffic monitor
+// keeps recent queries per IP in memory and spills per-minute aggregates
+// here, to append-only hourly segment files on disk, so long-window
+// detectors and the admin API can look back hours or days while memory
+// stays bounded by the hot tier.
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	aggregatesWritten = metrics.NewCounter("ddd_archive_aggregates_total",
+		"Per-minute client aggregates spilled to the warm history store")
+	segmentsGauge = metrics.NewGauge("ddd_archive_segments",
+		"Hourly segment files in the warm history store")
+	bytesGauge = metrics.NewGauge("ddd_archive_bytes",
+		"Size of the warm history store on disk")
+)
+
+// segmentSuffix ends the name of every segment file
+const segmentSuffix = ".hist"
+
+// Aggregate is one client's traffic during one minute
+type Aggregate struct {
+	IP         string    `json:"ip"`
+	Minute     time.Time `json:"minute"`
+	Requests   int       `json:"requests"`
+	NewDomains int       `json:"new_domains"`
+	Failures   int       `json:"failures"`
+}
+
+// History is a client's per-minute traffic over a long window
+type History struct {
+	IP      string      `json:"ip"`
+	Since   time.Time   `json:"since"`
+	Minutes []Aggregate `json:"minutes"`
+}
+
+// add sums another aggregate's counts into a
+func (a *Aggregate) add(b Aggregate) {
+	a.Requests += b.Requests
+	a.NewDomains += b.NewDomains
+	a.Failures += b.Failures
+}
+
+// Store holds aggregates in hourly segments under a directory
+type Store struct {
+	dir       string
+	retention time.Duration
+	log       *logger.Logger
+
+	mu   sync.Mutex
+	hour int64 // unix hour of the open segment
+	file *os.File
+	w    *bufio.Writer
+}
+
+// Open creates dir if needed and returns a store that keeps segments for
+// retention
+func Open(dir string, retention time.Duration, log *logger.Logger) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, retention: retention, log: log}
+	s.updateStats()
+	return s, nil
+}
+
+// Append writes aggregates to the segments of their hours. It is safe to
+// call on a nil store.
+func (s *Store) Append(aggs []Aggregate) {
+	if s == nil || len(aggs) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range aggs {
+		if err := s.segmentLocked(a.Minute.Unix() / 3600); err != nil {
+			s.log.Errorw("Failed to open history segment", "dir", s.dir, "error", err)
+			return
+		}
+		fmt.Fprintf(s.w, "%d %s %d %d %d\n", a.Minute.Unix(), a.IP, a.Requests, a.NewDomains, a.Failures)
+	}
+	if err := s.w.Flush(); err != nil {
+		s.log.Errorw("Failed to write history segment", "file", s.file.Name(), "error", err)
+	}
+	aggregatesWritten.Add(uint64(len(aggs)))
+}
+
+// segmentLocked makes the segment for hour the open one. s.mu must be
+// held.
+func (s *Store) segmentLocked(hour int64) error {
+	if s.file != nil && s.hour == hour {
+		return nil
+	}
+	if s.file != nil {
+		s.w.Flush()
+		s.file.Close()
+		s.file = nil
+	}
+	f, err := os.OpenFile(s.path(hour), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	s.hour, s.file, s.w = hour, f, bufio.NewWriter(f)
+
+	// Terminate a line torn by a crash so the next record stays intact
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			s.w.WriteByte('\n')
+		}
+	}
+	return nil
+}
+
+// path names the segment for a unix hour
+func (s *Store) path(hour int64) string {
+	return filepath.Join(s.dir, time.Unix(hour*3600, 0).UTC().Format("2006010215")+segmentSuffix)
+}
+
+// History returns ip's aggregates since the given time in minute order.
+// Aggregates of the same minute are merged.
+func (s *Store) History(ip string, since time.Time) ([]Aggregate, error) {
+	byMinute := make(map[int64]*Aggregate)
+	err := s.scan(since, func(a Aggregate) {
+		if a.IP != ip {
+			return
+		}
+		if m, ok := byMinute[a.Minute.Unix()]; ok {
+			m.add(a)
+			return
+		}
+		byMinute[a.Minute.Unix()] = &a
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Aggregate, 0, len(byMinute))
+	for _, a := range byMinute {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Minute.Before(out[j].Minute) })
+	return out, nil
+}
+
+// Totals sums each client's aggregates since the given time. Minute is
+// that of the client's latest aggregate.
+func (s *Store) Totals(since time.Time) (map[string]Aggregate, error) {
+	totals := make(map[string]Aggregate)
+	err := s.scan(since, func(a Aggregate) {
+		t, ok := totals[a.IP]
+		if !ok {
+			totals[a.IP] = a
+			return
+		}
+		t.add(a)
+		if a.Minute.After(t.Minute) {
+			t.Minute = a.Minute
+		}
+		totals[a.IP] = t
+	})
+	return totals, err
+}
+
+// scan calls fn for every aggregate since the given time
+func (s *Store) scan(since time.Time, fn func(Aggregate)) error {
+	if s == nil {
+		return nil
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	first := since.Unix() / 3600
+	for _, hour := range segments {
+		if hour < first {
+			continue
+		}
+		if err := s.scanSegment(hour, since, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanSegment reads one segment, skipping lines it cannot parse (a write
+// cut short by a crash)
+func (s *Store) scanSegment(hour int64, since time.Time, fn func(Aggregate)) error {
+	f, err := os.Open(s.path(hour))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		a, ok := parse(sc.Text())
+		if ok && !a.Minute.Before(since) {
+			fn(a)
+		}
+	}
+	return sc.Err()
+}
+
+// parse reads an aggregate from a segment line
+func parse(line string) (Aggregate, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		return Aggregate{}, false
+	}
+	var n [4]int64
+	for i, f := range []string{fields[0], fields[2], fields[3], fields[4]} {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return Aggregate{}, false
+		}
+		n[i] = v
+	}
+	return Aggregate{
+		IP:         fields[1],
+		Minute:     time.Unix(n[0], 0),
+		Requests:   int(n[1]),
+		NewDomains: int(n[2]),
+		Failures:   int(n[3]),
+	}, true
+}
+
+// segments lists the hours with a segment file, oldest first
+func (s *Store) segments() ([]int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var hours []int64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentSuffix)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse("2006010215", name)
+		if err != nil {
+			continue
+		}
+		hours = append(hours, t.Unix()/3600)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i] < hours[j] })
+	return hours, nil
+}
+
+// Prune deletes segments that ended more than the retention before now
+// and returns how many it deleted
+func (s *Store) Prune(now time.Time) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		s.log.Errorw("Failed to list history segments", "dir", s.dir, "error", err)
+		return 0
+	}
+	cutoff := now.Add(-s.retention).Unix() / 3600
+	removed := 0
+	for _, hour := range segments {
+		if hour >= cutoff {
+			break
+		}
+		if s.file != nil && hour == s.hour {
+			s.w.Flush()
+			s.file.Close()
+			s.file = nil
+		}
+		if err := os.Remove(s.path(hour)); err != nil {
+			s.log.Errorw("Failed to remove history segment", "file", s.path(hour), "error", err)
+			continue
+		}
+		removed++
+	}
+	s.updateStats()
+	return removed
+}
+
+// updateStats refreshes the segment count and size gauges
+func (s *Store) updateStats() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	var count int
+	var size int64
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), segmentSuffix) {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			count++
+			size += info.Size()
+		}
+	}
+	segmentsGauge.Set(float64(count))
+	bytesGauge.Set(float64(size))
+}
+
+// Close closes the open segment. It is safe to call on a nil store.
+func (s *Store) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		s.w.Flush()
+		s.file.Close()
+		s.file = nil
+	}
+}
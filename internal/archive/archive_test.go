@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ddd/internal/logger"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 24*time.Hour, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	hour := time.Now().Truncate(time.Hour)
+	at := func(h, m int) time.Time { return hour.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	s.Append([]Aggregate{
+		{IP: "192.0.2.1", Minute: at(-30, 0), Requests: 100}, // past retention
+		{IP: "192.0.2.1", Minute: at(-2, 5), Requests: 10, Failures: 1},
+		{IP: "192.0.2.2", Minute: at(-2, 5), Requests: 7},
+		{IP: "192.0.2.1", Minute: at(-1, 0), Requests: 3, NewDomains: 2},
+		{IP: "192.0.2.1", Minute: at(-1, 0), Requests: 4}, // same minute, spilled twice
+	})
+
+	h, err := s.History("192.0.2.1", at(-3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 2 || h[0].Requests != 10 || h[1].Requests != 7 || h[1].NewDomains != 2 {
+		t.Errorf("Unexpected history %+v", h)
+	}
+
+	totals, err := s.Totals(at(-2, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals["192.0.2.1"].Requests != 7 {
+		t.Errorf("Expected only the last hour counted, got %+v", totals)
+	}
+
+	// A torn line from a crash is skipped
+	f, err := os.OpenFile(s.path(at(-1, 0).Unix()/3600), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("1234 192.0.2")
+	f.Close()
+	s.Close()
+	s.Append([]Aggregate{{IP: "192.0.2.1", Minute: at(-1, 1), Requests: 1}})
+	if h, err := s.History("192.0.2.1", at(-1, 0)); err != nil || len(h) != 2 {
+		t.Errorf("Expected a torn line skipped, got %+v, %v", h, err)
+	}
+
+	if removed := s.Prune(time.Now()); removed != 1 {
+		t.Errorf("Expected one segment pruned, got %d", removed)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix)); len(files) != 2 {
+		t.Errorf("Expected two segments left, got %v", files)
+	}
+
+	var none *Store
+	if h, err := none.History("192.0.2.1", time.Time{}); err != nil || len(h) != 0 {
+		t.Errorf("Expected a nil store to be empty, got %v, %v", h, err)
+	}
+}
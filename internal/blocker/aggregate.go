@@ -0,0 +1,159 @@
+package blocker
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+var blockAggregations = metrics.NewCounter("ddd_blocklist_aggregations_total",
+	"Groups of individual blocks collapsed into a prefix block")
+
+// prefixOf returns the aggregation prefix containing ip (/24 for IPv4, /48
+// for IPv6) in CIDR notation, or "" if ip does not parse
+func prefixOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// isPrefix reports whether a block list key is a prefix block
+func isPrefix(key string) bool {
+	return strings.Contains(key, "/")
+}
+
+// addLocked stores a block list entry and its index. b.mu must be held.
+func (b *IPBlocker) addLocked(entry *BlockedIP) {
+	b.blockedIPs[entry.IP] = entry
+	if !isPrefix(entry.IP) {
+		// Before the index, so the hot path never skips a stored entry
+		b.filterAddLocked(entry.IP)
+	}
+	b.blockIndex.Store(entry.IP, entry.BlockUntil)
+
+	if isPrefix(entry.IP) {
+		b.prefixChangedLocked(entry.IP, true)
+		return
+	}
+	if p := prefixOf(entry.IP); p != "" {
+		members := b.prefixMembers[p]
+		if members == nil {
+			members = make(map[string]struct{})
+			b.prefixMembers[p] = members
+		}
+		members[entry.IP] = struct{}{}
+	}
+}
+
+// removeLocked deletes a block list entry and its index. b.mu must be held.
+func (b *IPBlocker) removeLocked(key string) {
+	if _, exists := b.blockedIPs[key]; !exists {
+		return
+	}
+	delete(b.blockedIPs, key)
+	b.blockIndex.Delete(key)
+
+	if isPrefix(key) {
+		b.prefixChangedLocked(key, false)
+		return
+	}
+	b.filterRemoveLocked()
+	if p := prefixOf(key); p != "" {
+		delete(b.prefixMembers[p], key)
+		if len(b.prefixMembers[p]) == 0 {
+			delete(b.prefixMembers, p)
+		}
+	}
+}
+
+// aggregatable reports whether an individual block may be merged into a
+// prefix block. Permanent, peer and listed blocks stay apart: merged, a
+// permanent block would make its neighbours' blocks permanent too, and a
+// peer or the block list could no longer lift its own block.
+func aggregatable(blocked *BlockedIP) bool {
+	return !blocked.Permanent && blocked.Origin == "" && !blocked.Listed
+}
+
+// aggregatableLocked returns the individual blocks within prefix that may
+// be merged into a prefix block. b.mu must be held.
+func (b *IPBlocker) aggregatableLocked(prefix string) []string {
+	var members []string
+	for ip := range b.prefixMembers[prefix] {
+		if aggregatable(b.blockedIPs[ip]) {
+			members = append(members, ip)
+		}
+	}
+	return members
+}
+
+// aggregateLocked collapses the aggregatable individual blocks within
+// prefix into a single prefix block carrying their combined metadata. The
+// prefix block is decided, and so dated, when it is formed. b.mu must be
+// held.
+func (b *IPBlocker) aggregateLocked(prefix string) {
+	members := b.aggregatableLocked(prefix)
+	if len(members) == 0 {
+		return
+	}
+
+	merged := &BlockedIP{IP: prefix, BlockedAt: time.Now()}
+	reasons := make(map[string]struct{})
+	for _, ip := range members {
+		blocked := b.blockedIPs[ip]
+		if blocked.BlockUntil.After(merged.BlockUntil) {
+			merged.BlockUntil = blocked.BlockUntil
+		}
+		merged.Severity = severity.Max(merged.Severity, blocked.Severity)
+		merged.BlockCount += blocked.BlockCount
+		reasons[blocked.Reason] = struct{}{}
+	}
+
+	distinct := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		distinct = append(distinct, reason)
+	}
+	sort.Strings(distinct)
+	merged.Reason = fmt.Sprintf("aggregated %d blocked IPs: %s", len(members), strings.Join(distinct, ", "))
+
+	for _, ip := range members {
+		b.removeLocked(ip)
+	}
+	b.addLocked(merged)
+	blockAggregations.Inc()
+
+	b.events.Publish(events.Event{
+		Type:     events.IPBlocked,
+		IP:       prefix,
+		Reason:   merged.Reason,
+		Severity: merged.Severity,
+		Duration: time.Until(merged.BlockUntil),
+	})
+}
+
+// aggregateDensestLocked collapses the prefix with the most individual
+// blocks, returning false if no prefix holds more than one. b.mu must be
+// held.
+func (b *IPBlocker) aggregateDensestLocked() bool {
+	densest, most := "", 1
+	for prefix := range b.prefixMembers {
+		if n := len(b.aggregatableLocked(prefix)); n > most {
+			densest, most = prefix, n
+		}
+	}
+	if densest == "" {
+		return false
+	}
+	b.aggregateLocked(densest)
+	return true
+}
@@ -0,0 +1,122 @@
+package blocker
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"sync/atomic"
+
+	"ddd/internal/metrics"
+)
+
+var blockFilterRebuilds = metrics.NewCounter("ddd_blocklist_filter_rebuilds_total",
+	"Rebuilds of the bloom filter in front of the block list")
+
+const (
+	// filterHashes is the number of bits set per key; with filterBitsPerKey
+	// bits per key it gives about 1% false positives at capacity
+	filterHashes     = 7
+	filterBitsPerKey = 10
+	// minFilterCapacity is the fewest keys a filter is sized for
+	minFilterCapacity = 1024
+)
+
+// blockFilter is a bloom filter over the individually blocked IPs. It
+// answers "definitely not blocked" for most clients with one hash and a
+// few atomic loads, so the exact index is only consulted for blocked IPs
+// and false positives. Keys are added in place; removals cannot clear
+// bits, so they are counted and the filter is rebuilt once stale keys
+// outnumber live ones, or once it outgrows its capacity.
+type blockFilter struct {
+	seed     maphash.Seed
+	bits     []atomic.Uint64
+	mask     uint64 // bit count - 1
+	capacity int
+
+	// Maintained under the blocker's mu
+	keys  int
+	stale int
+}
+
+// newBlockFilter returns an empty filter sized for capacity keys
+func newBlockFilter(capacity int) *blockFilter {
+	capacity = max(capacity, minFilterCapacity)
+	n := uint64(1) << bits.Len64(uint64(capacity*filterBitsPerKey-1))
+	return &blockFilter{
+		seed:     maphash.MakeSeed(),
+		bits:     make([]atomic.Uint64, n/64),
+		mask:     n - 1,
+		capacity: capacity,
+	}
+}
+
+// positions returns the two hashes each key's bit positions derive from
+func (f *blockFilter) positions(key string) (h1, h2 uint64) {
+	h := maphash.String(f.seed, key)
+	return h, h>>32 | 1
+}
+
+// add sets key's bits. It is safe with concurrent mayContain.
+func (f *blockFilter) add(key string) {
+	h1, h2 := f.positions(key)
+	for i := uint64(0); i < filterHashes; i++ {
+		pos := (h1 + i*h2) & f.mask
+		word, bit := &f.bits[pos/64], uint64(1)<<(pos%64)
+		for {
+			old := word.Load()
+			if old&bit != 0 || word.CompareAndSwap(old, old|bit) {
+				break
+			}
+		}
+	}
+	f.keys++
+}
+
+// mayContain reports whether key may have been added; false is certain
+func (f *blockFilter) mayContain(key string) bool {
+	h1, h2 := f.positions(key)
+	for i := uint64(0); i < filterHashes; i++ {
+		pos := (h1 + i*h2) & f.mask
+		if f.bits[pos/64].Load()&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterAddLocked adds an individually blocked IP to the filter, growing
+// it when full. b.mu must be held.
+func (b *IPBlocker) filterAddLocked(ip string) {
+	f := b.filter.Load()
+	if f == nil || f.keys+1 > f.capacity {
+		b.rebuildFilterLocked()
+		return // the rebuild included ip
+	}
+	f.add(ip)
+}
+
+// filterRemoveLocked records the removal of an individually blocked IP,
+// rebuilding the filter once most of its keys are stale. b.mu must be
+// held.
+func (b *IPBlocker) filterRemoveLocked() {
+	f := b.filter.Load()
+	if f == nil {
+		return
+	}
+	f.stale++
+	if f.stale > f.keys-f.stale && f.stale >= minFilterCapacity/4 {
+		b.rebuildFilterLocked()
+	}
+}
+
+// rebuildFilterLocked publishes a filter holding exactly the individually
+// blocked IPs. b.mu must be held.
+func (b *IPBlocker) rebuildFilterLocked() {
+	f := newBlockFilter(2 * len(b.blockedIPs))
+	for key := range b.blockedIPs {
+		if !isPrefix(key) {
+			f.add(key)
+		}
+	}
+	b.filter.Store(f)
+	blockFilterRebuilds.Inc()
+}
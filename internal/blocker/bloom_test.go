@@ -0,0 +1,39 @@
+package blocker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBlockFilter(t *testing.T) {
+	b := newTestBlocker(t, 60)
+	for i := 0; i < 3000; i++ {
+		b.BlockIP(fmt.Sprintf("10.%d.%d.1", i/256, i%256), "test")
+	}
+	for i := 0; i < 3000; i++ {
+		if ip := fmt.Sprintf("10.%d.%d.1", i/256, i%256); !b.IsBlocked(ip) {
+			t.Fatalf("Expected %s blocked after the filter grew", ip)
+		}
+	}
+
+	f := b.filter.Load()
+	positives := 0
+	for i := 0; i < 10000; i++ {
+		if f.mayContain(fmt.Sprintf("172.16.%d.%d", i/256, i%256)) {
+			positives++
+		}
+	}
+	if positives > 200 {
+		t.Errorf("Expected about 1%% false positives, got %d in 10000", positives)
+	}
+
+	for i := 0; i < 2900; i++ {
+		b.UnblockIP(fmt.Sprintf("10.%d.%d.1", i/256, i%256))
+	}
+	if f := b.filter.Load(); f.keys-f.stale != 100 || f.stale > 100 {
+		t.Errorf("Expected the filter rebuilt once most keys were stale, got %d keys, %d stale", f.keys, f.stale)
+	}
+	if b.IsBlocked("10.0.0.1") || !b.IsBlocked("10.11.100.1") {
+		t.Error("Expected only the remaining blocks after the rebuild")
+	}
+}
@@ -0,0 +1,208 @@
+package blocker
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
+)
+
+// forever is the expiry of permanent blocks
+var forever = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// LoadBlocklist reads a block list file with one IP address or CIDR per
+// line. Blank lines and text after # are ignored.
+func LoadBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if _, err := normalize(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, text)
+	}
+	return entries, scanner.Err()
+}
+
+// BlockRange blocks an IP address or CIDR range. Permanent blocks never
+// expire and are never evicted to make room; other blocks last as long as
+// a detection of the given severity.
+func (b *IPBlocker) BlockRange(entry, reason string, level severity.Level, permanent bool) error {
+	return b.blockRange(entry, reason, level, permanent, false)
+}
+
+// blockRange blocks entry as BlockRange does. A new block made for the
+// block list file is marked listed; a block made or refreshed otherwise is
+// no longer the list's to lift.
+func (b *IPBlocker) blockRange(entry, reason string, level severity.Level, permanent, listed bool) error {
+	key, err := normalize(entry)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until := b.blockRangeLocked(key, reason, level, permanent, listed)
+	b.checkCapacityLocked()
+
+	b.events.Publish(events.Event{
+		Type:     events.IPBlocked,
+		IP:       key,
+		Reason:   reason,
+		Severity: level,
+		Duration: time.Until(until),
+	})
+	return nil
+}
+
+// blockRangeLocked adds or refreshes the block of a normalized key and
+// returns when it ends. b.mu must be held.
+func (b *IPBlocker) blockRangeLocked(key, reason string, level severity.Level, permanent, listed bool) time.Time {
+	until := forever
+	if !permanent {
+		until = time.Now().Add(b.durationFor(level))
+	}
+
+	if blocked, exists := b.blockedIPs[key]; exists {
+		blocked.Severity = severity.Max(blocked.Severity, level)
+		blocked.Permanent = blocked.Permanent || permanent
+		if until.After(blocked.BlockUntil) {
+			blocked.BlockUntil = until
+		}
+		blocked.BlockCount++
+		if !listed {
+			blocked.Listed = false
+		}
+		b.blockIndex.Store(key, blocked.BlockUntil)
+		return until
+	}
+
+	b.makeRoomLocked()
+	b.addLocked(&BlockedIP{
+		IP:         key,
+		BlockedAt:  time.Now(),
+		BlockUntil: until,
+		Reason:     reason,
+		Severity:   level,
+		BlockCount: 1,
+		Permanent:  permanent,
+		Listed:     listed,
+	})
+	return until
+}
+
+// UnblockRange lifts the block of an IP address or CIDR range given as to
+// BlockRange and reports whether there was one. Addresses blocked
+// individually within a range stay blocked.
+func (b *IPBlocker) UnblockRange(entry string) (bool, error) {
+	key, err := normalize(entry)
+	if err != nil {
+		return false, err
+	}
+	b.mu.RLock()
+	_, exists := b.blockedIPs[key]
+	b.mu.RUnlock()
+	if !exists {
+		return false, nil
+	}
+	b.UnblockIP(key)
+	return true, nil
+}
+
+// SyncBlocklist applies a block list that changed from previous to
+// entries, previous being nil when the list is first loaded: newly listed
+// entries are blocked as by BlockRange, and the blocks the list made of
+// entries no longer listed are lifted. Blocks that were in place before
+// the list named them, or that were detected, made or imported since,
+// stay. Entries must be valid, as returned by LoadBlocklist. It returns
+// how many entries were added and removed.
+//
+// The whole change is applied under one hold of the lock, so readers of
+// the block list see it before or after and never half-applied, and the
+// prefix index the hot path consults for ranges is swapped in once at the
+// end. It is published as a single BlockListSynced event rather than an
+// event per entry.
+func (b *IPBlocker) SyncBlocklist(previous, entries []string, reason string, level severity.Level, permanent bool) (added, removed int) {
+	listed := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if key, err := normalize(entry); err == nil {
+			listed[key] = true
+		}
+	}
+	was := make(map[string]bool, len(previous))
+	for _, entry := range previous {
+		if key, err := normalize(entry); err == nil {
+			was[key] = true
+		}
+	}
+
+	b.mu.Lock()
+	endBatch := b.batchPrefixesLocked()
+	for key := range was {
+		if listed[key] {
+			continue
+		}
+		if blocked, exists := b.blockedIPs[key]; exists && blocked.Listed {
+			b.removeLocked(key)
+			removed++
+		}
+	}
+	for key := range listed {
+		if was[key] {
+			continue
+		}
+		b.blockRangeLocked(key, reason, level, permanent, true)
+		added++
+	}
+	endBatch()
+	b.checkCapacityLocked()
+	b.mu.Unlock()
+
+	if added > 0 || removed > 0 {
+		b.events.Publish(events.Event{
+			Type:     events.BlockListSynced,
+			Reason:   fmt.Sprintf("%s: %d added, %d removed", reason, added, removed),
+			Severity: level,
+		})
+	}
+	return added, removed
+}
+
+// normalize returns the block list key for an IP address or CIDR: the
+// address for single hosts, otherwise the masked prefix
+func normalize(entry string) (string, error) {
+	if !strings.Contains(entry, "/") {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return "", err
+		}
+		return addr.Unmap().String(), nil
+	}
+
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return "", err
+	}
+	prefix = prefix.Masked()
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String(), nil
+	}
+	return prefix.String(), nil
+}
@@ -0,0 +1,147 @@
+package blocker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
+)
+
+func TestBootstrapBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	data := "# previous incident\n203.0.113.7\n10.0.0.0/8  # botnet range\n\n2001:db8::/32\n198.51.100.0/24\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %v", entries)
+	}
+
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{MaxEntries: 5})
+	for _, entry := range entries {
+		if err := b.BlockRange(entry, "bootstrap", severity.High, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ip := range []string{"203.0.113.7", "10.200.3.4", "2001:db8:ffff::1", "198.51.100.20", "::ffff:10.1.1.1"} {
+		if !b.IsBlocked(ip) {
+			t.Errorf("Expected %s to be blocked", ip)
+		}
+	}
+	if b.IsBlocked("192.0.2.1") {
+		t.Error("Expected an address outside the list to pass")
+	}
+
+	// Permanent blocks survive overflow and repeat detections
+	for i := 1; i <= 3; i++ {
+		b.BlockIPWithSeverity(fmt.Sprintf("192.0.2.%d", i), "flood", severity.Low)
+	}
+	b.BlockIPWithSeverity("203.0.113.7", "flood", severity.Low)
+	if !b.IsBlocked("10.1.1.1") || !b.GetBlockedIP("203.0.113.7").Permanent {
+		t.Error("Expected permanent blocks to be kept")
+	}
+
+	b.UnblockIP("10.0.0.0/8")
+	if b.IsBlocked("10.1.1.1") {
+		t.Error("Expected the range to be unblocked")
+	}
+}
+
+func TestLoadBlocklistRejectsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("203.0.113.7\nnot-an-ip\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBlocklist(path); err == nil {
+		t.Error("Expected an invalid line to be rejected")
+	}
+}
+
+func TestSyncBlocklist(t *testing.T) {
+	b := NewIPBlocker(60, events.NewBus())
+	previous := []string{"203.0.113.7", "10.0.0.0/8"}
+	if added, _ := b.SyncBlocklist(nil, previous, "bootstrap", severity.High, true); added != 2 {
+		t.Fatalf("Expected the first load to add 2 entries, got %d", added)
+	}
+
+	added, removed := b.SyncBlocklist(previous, []string{"10.0.0.0/8", "198.51.100.0/24", "198.51.100.0/24"}, "bootstrap", severity.High, true)
+	if added != 1 || removed != 1 {
+		t.Errorf("Expected 1 entry added and 1 removed, got %d and %d", added, removed)
+	}
+	if b.IsBlocked("203.0.113.7") {
+		t.Error("Expected an entry no longer listed to be unblocked")
+	}
+	if !b.IsBlocked("10.1.1.1") || !b.IsBlocked("198.51.100.20") {
+		t.Error("Expected listed entries to stay or become blocked")
+	}
+}
+
+func TestSyncBlocklistAppliesAtOnce(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe("test", 16)
+	b := NewIPBlocker(60, bus)
+
+	var entries []string
+	for i := 0; i < 500; i++ {
+		entries = append(entries, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256), fmt.Sprintf("192.0.%d.%d", i/256, i%256))
+	}
+	if added, _ := b.SyncBlocklist(nil, entries, "bootstrap", severity.High, true); added != 1000 {
+		t.Fatalf("Expected 1000 entries added, got %d", added)
+	}
+	if set := b.prefixes.Load(); set == nil || len(set.keys) != 500 {
+		t.Fatal("Expected every range in the prefix index")
+	}
+	if !b.IsBlocked("10.1.243.7") || !b.IsBlocked("192.0.1.243") {
+		t.Error("Expected the last entries to be blocked")
+	}
+
+	if e := <-sub; e.Type != events.BlockListSynced || e.Reason != "bootstrap: 1000 added, 0 removed" {
+		t.Errorf("Expected one event for the whole list, got %+v", e)
+	}
+	select {
+	case e := <-sub:
+		t.Errorf("Expected no event per entry, got %+v", e)
+	default:
+	}
+}
+
+func TestSyncBlocklistLiftsOnlyListedBlocks(t *testing.T) {
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{AggregateThreshold: 3})
+	b.BlockIPWithSeverity("203.0.113.7", "detected", severity.Medium)
+	previous := []string{"203.0.113.7", "198.51.100.5", "192.0.2.9"}
+	b.SyncBlocklist(nil, previous, "bootstrap", severity.High, true)
+
+	// A detection of a listed address makes the block this node's own
+	b.BlockIPWithSeverity("192.0.2.9", "detected", severity.Medium)
+
+	// Detections around the listed address aggregate without it
+	for i := 1; i <= 3; i++ {
+		b.BlockIPWithSeverity(fmt.Sprintf("198.51.100.%d", 100+i), "detected", severity.Medium)
+	}
+	if b.GetBlockedIP("198.51.100.0/24") == nil {
+		t.Fatal("Expected the detected addresses to be aggregated")
+	}
+	if blocked := b.GetBlockedIP("198.51.100.5"); blocked == nil || blocked.IP != "198.51.100.5" || !blocked.Listed {
+		t.Fatalf("Expected the listed address to stay a block of its own, got %+v", blocked)
+	}
+
+	_, removed := b.SyncBlocklist(previous, nil, "bootstrap", severity.High, true)
+	if removed != 1 {
+		t.Errorf("Expected only the block the list made to be lifted, got %d", removed)
+	}
+	if !b.IsBlocked("203.0.113.7") || !b.IsBlocked("192.0.2.9") {
+		t.Error("Expected detected blocks to survive their entries leaving the list")
+	}
+	if blocked := b.GetBlockedIP("198.51.100.5"); blocked == nil || blocked.IP != "198.51.100.0/24" {
+		t.Errorf("Expected the listed address to be lifted from under the prefix block, got %+v", blocked)
+	}
+}
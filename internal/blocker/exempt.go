@@ -0,0 +1,267 @@
+package blocker
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/events"
+)
+
+// maxExemptions bounds the pending and active exemptions
+const maxExemptions = 1000
+
+// Exemption states
+const (
+	ExemptionPending = "pending" // waiting for a second operator's approval
+	ExemptionActive  = "active"
+)
+
+// Exemption lets an address or network through rate limiting and attack
+// detection until it expires, by operator decision. Unlike a firewall
+// allow rule it is created at run time, and it ends by itself.
+type Exemption struct {
+	ID          uint64    `json:"id"`
+	CIDR        string    `json:"cidr"`
+	Note        string    `json:"note,omitempty"`
+	RequestedBy string    `json:"requested_by"`
+	ApprovedBy  string    `json:"approved_by,omitempty"`
+	State       string    `json:"state"`
+	Created     time.Time `json:"created"`
+	// Until is when the exemption expires, or a pending request lapses
+	Until time.Time `json:"until"`
+}
+
+// exemptions holds the exemptions; active mirrors the active ones for the
+// lock-free lookup on the per-query path
+type exemptions struct {
+	mu     sync.Mutex
+	seq    uint64
+	byID   map[uint64]*Exemption
+	active atomic.Pointer[[]activeExemption]
+}
+
+// activeExemption is an active exemption's network and expiry
+type activeExemption struct {
+	prefix netip.Prefix
+	until  time.Time
+}
+
+// parseExemptCIDR parses an address or CIDR into a prefix
+func parseExemptCIDR(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address or CIDR %q", cidr)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address or CIDR %q", cidr)
+	}
+	return prefix.Masked(), nil
+}
+
+// Exempt creates an exemption of cidr (an address or CIDR) for d,
+// requested by requestedBy. It is pending until approved by another
+// operator if pending is true, and active at once otherwise.
+func (b *IPBlocker) Exempt(cidr string, d time.Duration, requestedBy, note string, pending bool) (Exemption, error) {
+	prefix, err := parseExemptCIDR(cidr)
+	if err != nil {
+		return Exemption{}, err
+	}
+	switch {
+	case d <= 0:
+		return Exemption{}, fmt.Errorf("duration must be positive, got %v", d)
+	case requestedBy == "":
+		return Exemption{}, fmt.Errorf("requested_by must name the operator asking")
+	}
+
+	now := time.Now()
+	x := &b.exemptions
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireLocked(now)
+	if len(x.byID) >= maxExemptions {
+		return Exemption{}, fmt.Errorf("too many exemptions, at most %d", maxExemptions)
+	}
+	if x.byID == nil {
+		x.byID = make(map[uint64]*Exemption)
+	}
+	x.seq++
+	e := &Exemption{
+		ID:          x.seq,
+		CIDR:        prefix.String(),
+		Note:        note,
+		RequestedBy: requestedBy,
+		State:       ExemptionActive,
+		Created:     now,
+		Until:       now.Add(d),
+	}
+	if pending {
+		e.State = ExemptionPending
+	}
+	x.byID[e.ID] = e
+	x.rebuildLocked()
+
+	b.publishExemption(events.ExemptionRequested, *e, "requested by "+requestedBy, now)
+	if !pending {
+		b.publishExemption(events.ExemptionGranted, *e, "granted without approval", now)
+	}
+	return *e, nil
+}
+
+// ApproveExemption activates a pending exemption. The approver must not be
+// the operator who asked for it.
+func (b *IPBlocker) ApproveExemption(id uint64, approvedBy string) (Exemption, error) {
+	now := time.Now()
+	x := &b.exemptions
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireLocked(now)
+
+	e, ok := x.byID[id]
+	switch {
+	case !ok:
+		return Exemption{}, fmt.Errorf("no exemption %d", id)
+	case e.State != ExemptionPending:
+		return Exemption{}, fmt.Errorf("exemption %d is already %s", id, e.State)
+	case approvedBy == "":
+		return Exemption{}, fmt.Errorf("approved_by must name the approving operator")
+	case approvedBy == e.RequestedBy:
+		return Exemption{}, fmt.Errorf("exemption %d must be approved by someone other than %s", id, approvedBy)
+	}
+	e.State = ExemptionActive
+	e.ApprovedBy = approvedBy
+	x.rebuildLocked()
+
+	b.publishExemption(events.ExemptionGranted, *e, "approved by "+approvedBy, now)
+	return *e, nil
+}
+
+// RevokeExemption ends an exemption, pending or active, before it expires
+func (b *IPBlocker) RevokeExemption(id uint64) (Exemption, error) {
+	now := time.Now()
+	x := &b.exemptions
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireLocked(now)
+
+	e, ok := x.byID[id]
+	if !ok {
+		return Exemption{}, fmt.Errorf("no exemption %d", id)
+	}
+	delete(x.byID, id)
+	x.rebuildLocked()
+
+	b.publishExemption(events.ExemptionEnded, *e, "revoked", now)
+	return *e, nil
+}
+
+// Exemptions returns the pending and active exemptions, soonest to expire
+// first
+func (b *IPBlocker) Exemptions() []Exemption {
+	x := &b.exemptions
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireLocked(time.Now())
+
+	out := make([]Exemption, 0, len(x.byID))
+	for _, e := range x.byID {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Until.Equal(out[j].Until) {
+			return out[i].Until.Before(out[j].Until)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// IsExempt reports whether an active exemption covers ip at now
+func (b *IPBlocker) IsExempt(ip string, now time.Time) bool {
+	active := b.exemptions.active.Load()
+	if active == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, a := range *active {
+		if now.Before(a.until) && a.prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupExemptions removes expired exemptions and lapsed requests and
+// returns how many it scanned and removed
+func (b *IPBlocker) cleanupExemptions(now time.Time) (scanned, removed int) {
+	x := &b.exemptions
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	scanned = len(x.byID)
+	return scanned, b.expireLocked(now)
+}
+
+// expireLocked removes the exemptions expired at now, publishing their
+// end, and returns how many it removed
+func (b *IPBlocker) expireLocked(now time.Time) int {
+	x := &b.exemptions
+	removed := 0
+	for id, e := range x.byID {
+		if now.Before(e.Until) {
+			continue
+		}
+		delete(x.byID, id)
+		removed++
+		reason := "expired"
+		if e.State == ExemptionPending {
+			reason = "lapsed without approval"
+		}
+		b.publishExemption(events.ExemptionEnded, *e, reason, now)
+	}
+	if removed > 0 {
+		x.rebuildLocked()
+	}
+	return removed
+}
+
+// rebuildLocked replaces the active list read by IsExempt; nil when no
+// exemption is active
+func (x *exemptions) rebuildLocked() {
+	var active []activeExemption
+	for _, e := range x.byID {
+		if e.State == ExemptionActive {
+			active = append(active, activeExemption{prefix: netip.MustParsePrefix(e.CIDR), until: e.Until})
+		}
+	}
+	if len(active) == 0 {
+		x.active.Store(nil)
+		return
+	}
+	x.active.Store(&active)
+}
+
+// publishExemption publishes a change to exemption e
+func (b *IPBlocker) publishExemption(t events.Type, e Exemption, reason string, now time.Time) {
+	if e.Note != "" {
+		reason += ": " + e.Note
+	}
+	b.events.Publish(events.Event{
+		Type:     t,
+		IP:       e.CIDR,
+		Reason:   fmt.Sprintf("exemption %d %s", e.ID, reason),
+		Duration: e.Until.Sub(now),
+	})
+}
@@ -0,0 +1,72 @@
+package blocker
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+)
+
+func TestExemptionApproval(t *testing.T) {
+	bus := events.NewBus()
+	audit := bus.Subscribe("test", 16)
+	b := NewIPBlocker(60, bus)
+
+	if _, err := b.Exempt("198.51.100.0/33", time.Hour, "alice", "", true); err == nil {
+		t.Error("Expected an invalid CIDR to be rejected")
+	}
+	if _, err := b.Exempt("198.51.100.0/24", time.Hour, "", "", true); err == nil {
+		t.Error("Expected a request without a requester to be rejected")
+	}
+
+	e, err := b.Exempt("198.51.100.7/24", time.Hour, "alice", "load test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.CIDR != "198.51.100.0/24" || e.State != ExemptionPending {
+		t.Fatalf("Expected a pending exemption of the masked network, got %+v", e)
+	}
+	now := time.Now()
+	if b.IsExempt("198.51.100.9", now) {
+		t.Error("Expected a pending exemption not to apply")
+	}
+	if _, err := b.ApproveExemption(e.ID, "alice"); err == nil {
+		t.Error("Expected the requester not to approve their own exemption")
+	}
+	if e, err = b.ApproveExemption(e.ID, "bob"); err != nil || e.State != ExemptionActive || e.ApprovedBy != "bob" {
+		t.Fatalf("Expected an approved exemption, got %+v, %v", e, err)
+	}
+	if !b.IsExempt("198.51.100.9", now) || b.IsExempt("198.51.101.9", now) {
+		t.Error("Expected the exemption to cover its network only")
+	}
+
+	for _, want := range []events.Type{events.ExemptionRequested, events.ExemptionGranted} {
+		if ev := <-audit; ev.Type != want || ev.IP != "198.51.100.0/24" {
+			t.Errorf("Expected %s, got %+v", want, ev)
+		}
+	}
+
+	// Expiry removes the exemption and publishes its end
+	_, removed := b.cleanupExemptions(e.Until)
+	if removed != 1 || b.IsExempt("198.51.100.9", e.Until) || len(b.Exemptions()) != 0 {
+		t.Errorf("Expected the exemption to expire, removed %d", removed)
+	}
+	if ev := <-audit; ev.Type != events.ExemptionEnded || ev.Reason != "exemption 1 expired: load test" {
+		t.Errorf("Expected the exemption's end, got %+v", ev)
+	}
+}
+
+func TestExemptionWithoutApproval(t *testing.T) {
+	b := newTestBlocker(t, 60)
+	e, err := b.Exempt("2001:db8::1", time.Hour, "alice", "", false)
+	if err != nil || e.State != ExemptionActive || e.CIDR != "2001:db8::1/128" {
+		t.Fatalf("Expected an active exemption of one address, got %+v, %v", e, err)
+	}
+	if !b.IsExempt("2001:db8::1", time.Now()) {
+		t.Error("Expected the address to be exempt")
+	}
+	revoked, err := b.RevokeExemption(e.ID)
+	if err != nil || revoked.ID != e.ID || b.IsExempt("2001:db8::1", time.Now()) {
+		t.Errorf("Expected the exemption revoked, got %+v, %v", revoked, err)
+	}
+}
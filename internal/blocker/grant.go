@@ -0,0 +1,280 @@
+package blocker
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/events"
+)
+
+// maxGrants bounds the scheduled and running budget grants
+const maxGrants = 1000
+
+// grantTokenPrefix marks budget grant tokens apart from API tokens
+const grantTokenPrefix = "dddg_"
+
+// Grant raises the query budgets of an address or network by Factor for
+// a declared window, so that a batch job (a nightly crawl, a backup
+// verification) does not trip attack detection. Operators mint grants;
+// the job holds the grant's token, which lets it release the grant when
+// it finishes early.
+type Grant struct {
+	ID          uint64    `json:"id"`
+	CIDR        string    `json:"cidr"`
+	Factor      float64   `json:"factor"` // multiplies the rate and new client budgets
+	Job         string    `json:"job"`
+	RequestedBy string    `json:"requested_by"`
+	Note        string    `json:"note,omitempty"`
+	Created     time.Time `json:"created"`
+	Start       time.Time `json:"start"`
+	Until       time.Time `json:"until"`
+
+	token [sha256.Size]byte // hash of the token, which is shown once
+}
+
+// IssuedGrant is a newly minted grant with its token, which is not kept
+// and cannot be shown again
+type IssuedGrant struct {
+	Grant
+	Token string `json:"token"`
+}
+
+// grants holds the budget grants; active mirrors them for the lock-free
+// lookup on the per-query path
+type grants struct {
+	mu     sync.Mutex
+	seq    uint64
+	byID   map[uint64]*Grant
+	active atomic.Pointer[[]activeGrant]
+}
+
+// activeGrant is a grant's network, window and factor
+type activeGrant struct {
+	prefix      netip.Prefix
+	start, till time.Time
+	factor      float64
+}
+
+// IssueGrant mints a grant multiplying the budgets of cidr (an address or
+// CIDR) by factor from start until until, for job, requested by
+// requestedBy. It returns the grant with its token.
+func (b *IPBlocker) IssueGrant(cidr string, factor float64, start, until time.Time, job, requestedBy, note string) (IssuedGrant, error) {
+	prefix, err := parseExemptCIDR(cidr)
+	if err != nil {
+		return IssuedGrant{}, err
+	}
+	now := time.Now()
+	switch {
+	case factor <= 1:
+		return IssuedGrant{}, fmt.Errorf("factor must be above 1, got %v", factor)
+	case !until.After(start) || !until.After(now):
+		return IssuedGrant{}, fmt.Errorf("window must end after it starts and in the future")
+	case job == "":
+		return IssuedGrant{}, fmt.Errorf("job must name the batch job")
+	case requestedBy == "":
+		return IssuedGrant{}, fmt.Errorf("requested_by must name the operator asking")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return IssuedGrant{}, err
+	}
+	token := grantTokenPrefix + hex.EncodeToString(secret)
+
+	x := &b.grants
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireGrantsLocked(now)
+	if len(x.byID) >= maxGrants {
+		return IssuedGrant{}, fmt.Errorf("too many budget grants, at most %d", maxGrants)
+	}
+	if x.byID == nil {
+		x.byID = make(map[uint64]*Grant)
+	}
+	x.seq++
+	g := &Grant{
+		ID:          x.seq,
+		CIDR:        prefix.String(),
+		Factor:      factor,
+		Job:         job,
+		RequestedBy: requestedBy,
+		Note:        note,
+		Created:     now,
+		Start:       start,
+		Until:       until,
+		token:       sha256.Sum256([]byte(token)),
+	}
+	x.byID[g.ID] = g
+	x.rebuildLocked()
+
+	b.publishGrant(events.GrantIssued, *g, "issued by "+requestedBy, now)
+	return IssuedGrant{Grant: *g, Token: token}, nil
+}
+
+// RevokeGrant ends a grant, scheduled or running, before its window closes
+func (b *IPBlocker) RevokeGrant(id uint64) (Grant, error) {
+	now := time.Now()
+	x := &b.grants
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireGrantsLocked(now)
+
+	g, ok := x.byID[id]
+	if !ok {
+		return Grant{}, fmt.Errorf("no budget grant %d", id)
+	}
+	b.endGrantLocked(g, "revoked", now)
+	return *g, nil
+}
+
+// ReleaseGrant ends the grant token belongs to, for a job that finished
+// before its window closed
+func (b *IPBlocker) ReleaseGrant(token string) (Grant, error) {
+	now := time.Now()
+	x := &b.grants
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireGrantsLocked(now)
+
+	g := x.byTokenLocked(token)
+	if g == nil {
+		return Grant{}, fmt.Errorf("unknown or expired budget grant token")
+	}
+	b.endGrantLocked(g, "released by its job", now)
+	return *g, nil
+}
+
+// Grants returns the scheduled and running budget grants, soonest to
+// start first
+func (b *IPBlocker) Grants() []Grant {
+	x := &b.grants
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.expireGrantsLocked(time.Now())
+
+	out := make([]Grant, 0, len(x.byID))
+	for _, g := range x.byID {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Start.Equal(out[j].Start) {
+			return out[i].Start.Before(out[j].Start)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// BudgetFactor returns how much the grants covering ip at now multiply
+// its budgets: the largest factor among them, or 1 when there is none
+func (b *IPBlocker) BudgetFactor(ip string, now time.Time) float64 {
+	active := b.grants.active.Load()
+	if active == nil {
+		return 1
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 1
+	}
+	addr = addr.Unmap()
+	factor := 1.0
+	for _, a := range *active {
+		if !now.Before(a.start) && now.Before(a.till) && a.prefix.Contains(addr) {
+			factor = max(factor, a.factor)
+		}
+	}
+	return factor
+}
+
+// cleanupGrants removes the grants whose window has closed and returns
+// how many it scanned and removed
+func (b *IPBlocker) cleanupGrants(now time.Time) (scanned, removed int) {
+	x := &b.grants
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	scanned = len(x.byID)
+	return scanned, b.expireGrantsLocked(now)
+}
+
+// expireGrantsLocked removes the grants whose window closed by now,
+// publishing their end, and returns how many it removed
+func (b *IPBlocker) expireGrantsLocked(now time.Time) int {
+	x := &b.grants
+	removed := 0
+	for id, g := range x.byID {
+		if now.Before(g.Until) {
+			continue
+		}
+		delete(x.byID, id)
+		removed++
+		b.publishGrant(events.GrantEnded, *g, "expired", now)
+	}
+	if removed > 0 {
+		x.rebuildLocked()
+	}
+	return removed
+}
+
+// endGrantLocked removes g before its window closes
+func (b *IPBlocker) endGrantLocked(g *Grant, reason string, now time.Time) {
+	delete(b.grants.byID, g.ID)
+	b.grants.rebuildLocked()
+	b.publishGrant(events.GrantEnded, *g, reason, now)
+}
+
+// byTokenLocked returns the grant token belongs to, or nil
+func (x *grants) byTokenLocked(token string) *Grant {
+	if !strings.HasPrefix(token, grantTokenPrefix) {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, g := range x.byID {
+		if subtle.ConstantTimeCompare(sum[:], g.token[:]) == 1 {
+			return g
+		}
+	}
+	return nil
+}
+
+// rebuildLocked replaces the list read by BudgetFactor; nil when there is
+// no grant
+func (x *grants) rebuildLocked() {
+	if len(x.byID) == 0 {
+		x.active.Store(nil)
+		return
+	}
+	active := make([]activeGrant, 0, len(x.byID))
+	for _, g := range x.byID {
+		active = append(active, activeGrant{
+			prefix: netip.MustParsePrefix(g.CIDR),
+			start:  g.Start,
+			till:   g.Until,
+			factor: g.Factor,
+		})
+	}
+	x.active.Store(&active)
+}
+
+// publishGrant publishes a change to grant g
+func (b *IPBlocker) publishGrant(t events.Type, g Grant, reason string, now time.Time) {
+	reason = fmt.Sprintf("budget grant %d for %s (x%g from %s) %s", g.ID, g.Job, g.Factor,
+		g.Start.UTC().Format(time.RFC3339), reason)
+	if g.Note != "" {
+		reason += ": " + g.Note
+	}
+	b.events.Publish(events.Event{
+		Type:     t,
+		IP:       g.CIDR,
+		Reason:   reason,
+		Duration: g.Until.Sub(now),
+	})
+}
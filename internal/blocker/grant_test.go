@@ -0,0 +1,82 @@
+package blocker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+)
+
+func TestBudgetGrants(t *testing.T) {
+	bus := events.NewBus()
+	audit := bus.Subscribe("test", 16)
+	b := NewIPBlocker(60, bus)
+
+	now := time.Now()
+	start := now.Add(time.Hour)
+	if _, err := b.IssueGrant("203.0.113.0/28", 1, start, start.Add(time.Hour), "crawl", "alice", ""); err == nil {
+		t.Error("Expected a factor of 1 to be rejected")
+	}
+	if _, err := b.IssueGrant("203.0.113.0/28", 5, start, start, "crawl", "alice", ""); err == nil {
+		t.Error("Expected an empty window to be rejected")
+	}
+	if _, err := b.IssueGrant("203.0.113.0/28", 5, start, start.Add(time.Hour), "", "alice", ""); err == nil {
+		t.Error("Expected a grant without a job to be rejected")
+	}
+
+	g, err := b.IssueGrant("203.0.113.7/28", 5, start, start.Add(time.Hour), "nightly-crawl", "alice", "search index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.CIDR != "203.0.113.0/28" || !strings.HasPrefix(g.Token, grantTokenPrefix) {
+		t.Fatalf("Expected a grant of the masked network with a token, got %+v", g)
+	}
+	if ev := <-audit; ev.Type != events.GrantIssued || ev.IP != "203.0.113.0/28" {
+		t.Errorf("Expected the grant's minting, got %+v", ev)
+	}
+
+	// The grant applies within its window and network only
+	for _, c := range []struct {
+		ip   string
+		at   time.Time
+		want float64
+	}{
+		{"203.0.113.9", now, 1},
+		{"203.0.113.9", start, 5},
+		{"203.0.113.99", start, 1},
+		{"203.0.113.9", start.Add(time.Hour), 1},
+	} {
+		if got := b.BudgetFactor(c.ip, c.at); got != c.want {
+			t.Errorf("Expected factor %v for %s at %v, got %v", c.want, c.ip, c.at, got)
+		}
+	}
+
+	// The token releases the grant, once
+	if _, err := b.ReleaseGrant(grantTokenPrefix + "00"); err == nil {
+		t.Error("Expected an unknown token to be rejected")
+	}
+	released, err := b.ReleaseGrant(g.Token)
+	if err != nil || released.ID != g.ID || len(b.Grants()) != 0 || b.BudgetFactor("203.0.113.9", start) != 1 {
+		t.Fatalf("Expected the grant released, got %+v, %v", released, err)
+	}
+	if ev := <-audit; ev.Type != events.GrantEnded || !strings.HasSuffix(ev.Reason, "released by its job: search index") {
+		t.Errorf("Expected the grant's release, got %+v", ev)
+	}
+	if _, err := b.ReleaseGrant(g.Token); err == nil {
+		t.Error("Expected a released grant's token to be rejected")
+	}
+
+	// Grants expire when their window closes
+	g, err = b.IssueGrant("2001:db8::/64", 3, now, now.Add(time.Hour), "backup-verify", "bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-audit
+	if _, removed := b.cleanupGrants(g.Until); removed != 1 || len(b.Grants()) != 0 {
+		t.Errorf("Expected the grant to expire, removed %d", removed)
+	}
+	if ev := <-audit; ev.Type != events.GrantEnded || !strings.HasSuffix(ev.Reason, "expired") {
+		t.Errorf("Expected the grant's expiry, got %+v", ev)
+	}
+}
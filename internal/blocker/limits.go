@@ -0,0 +1,103 @@
+package blocker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+var (
+	blockEntries = metrics.NewGauge("ddd_blocklist_entries",
+		"Entries in the block list")
+	blockEvictions = metrics.NewCounterVec("ddd_blocklist_evictions_total",
+		"Blocks evicted early because the block list was full", "severity")
+)
+
+// Limits bounds the block list so a spoofed flood cannot grow it without
+// limit, and sets how long blocks last by severity
+type Limits struct {
+	// MaxEntries caps the number of blocks (0 means unlimited). When full,
+	// the lowest-severity blocks with the least time remaining are evicted
+	// to make room, after first trying to aggregate the densest prefix.
+	MaxEntries int
+	// AggregateThreshold collapses the blocks within a /24 (IPv4) or /48
+	// (IPv6) into one prefix block once this many are blocked (0 disables
+	// aggregation)
+	AggregateThreshold int
+	// WarnRatio publishes a capacity warning when the list reaches this
+	// fraction of MaxEntries
+	WarnRatio float64
+	// SeverityDurations overrides the block duration for the given
+	// severities, so that escalating detections block for longer
+	SeverityDurations map[severity.Level]time.Duration
+}
+
+// evictionBatch is the fraction of capacity evicted at once when full, so
+// a flood of new blocks does not rescan the list on every insert
+const evictionBatch = 0.01
+
+// makeRoomLocked evicts blocks when the list is full. b.mu must be held.
+func (b *IPBlocker) makeRoomLocked() {
+	if b.limits.MaxEntries <= 0 || len(b.blockedIPs) < b.limits.MaxEntries {
+		return
+	}
+	if b.limits.AggregateThreshold > 0 && b.aggregateDensestLocked() &&
+		len(b.blockedIPs) < b.limits.MaxEntries {
+		return
+	}
+
+	victims := make([]*BlockedIP, 0, len(b.blockedIPs))
+	for _, blocked := range b.blockedIPs {
+		if !blocked.Permanent {
+			victims = append(victims, blocked)
+		}
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		if victims[i].Severity != victims[j].Severity {
+			return victims[i].Severity < victims[j].Severity
+		}
+		return victims[i].BlockUntil.Before(victims[j].BlockUntil)
+	})
+
+	n := int(float64(b.limits.MaxEntries) * evictionBatch)
+	if n < 1 {
+		n = 1
+	}
+	n += len(b.blockedIPs) - b.limits.MaxEntries // already over, e.g. after a limit change
+	if n > len(victims) {
+		n = len(victims)
+	}
+
+	for _, victim := range victims[:n] {
+		b.removeLocked(victim.IP)
+		blockEvictions.With(victim.Severity.String()).Inc()
+	}
+}
+
+// checkCapacityLocked updates the size gauge and publishes a warning when
+// the list first reaches the warning threshold. b.mu must be held.
+func (b *IPBlocker) checkCapacityLocked() {
+	size := len(b.blockedIPs)
+	blockEntries.Set(float64(size))
+
+	if b.limits.MaxEntries <= 0 || b.limits.WarnRatio <= 0 {
+		return
+	}
+
+	threshold := int(float64(b.limits.MaxEntries) * b.limits.WarnRatio)
+	switch {
+	case size >= threshold && !b.nearCapacity:
+		b.nearCapacity = true
+		b.events.Publish(events.Event{
+			Type:   events.BlockListNearCapacity,
+			Reason: fmt.Sprintf("block list holds %d of %d entries", size, b.limits.MaxEntries),
+			Time:   time.Now(),
+		})
+	case size < threshold:
+		b.nearCapacity = false
+	}
+}
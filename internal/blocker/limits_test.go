@@ -0,0 +1,152 @@
+package blocker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
+)
+
+func TestBlockListEvictsLowestSeverity(t *testing.T) {
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{MaxEntries: 3})
+
+	b.BlockIPWithSeverity("192.0.2.1", "flood", severity.High)
+	b.BlockIPWithSeverity("192.0.2.2", "flood", severity.Low)
+	b.BlockIPWithSeverity("192.0.2.3", "flood", severity.Medium)
+	b.BlockIPWithSeverity("192.0.2.4", "flood", severity.Medium)
+
+	if b.IsBlocked("192.0.2.2") {
+		t.Error("low-severity block survived overflow")
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.3", "192.0.2.4"} {
+		if !b.IsBlocked(ip) {
+			t.Errorf("%s evicted, want low-severity entry evicted first", ip)
+		}
+	}
+	if got := len(b.GetAllBlockedIPs()); got != 3 {
+		t.Errorf("block list holds %d entries, want 3", got)
+	}
+}
+
+func TestBlockListCapacityWarning(t *testing.T) {
+	bus := events.NewBus()
+	ch := bus.Subscribe("test", 64)
+	b := NewIPBlockerWithLimits(60, bus, Limits{MaxEntries: 10, WarnRatio: 0.5})
+
+	for i := 1; i <= 8; i++ {
+		b.BlockIP(fmt.Sprintf("192.0.2.%d", i), "flood")
+	}
+
+	warnings := 0
+	for len(ch) > 0 {
+		if e := <-ch; e.Type == events.BlockListNearCapacity {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("got %d capacity warnings, want 1", warnings)
+	}
+}
+
+func TestBlockAggregation(t *testing.T) {
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{AggregateThreshold: 4})
+
+	for i := 1; i <= 4; i++ {
+		b.BlockIPWithSeverity(fmt.Sprintf("192.0.2.%d", i), "flood", severity.Medium)
+	}
+	b.BlockIP("2001:db8:1:2::1", "flood")
+
+	all := b.GetAllBlockedIPs()
+	if len(all) != 2 {
+		t.Fatalf("block list holds %d entries, want prefix block plus one IP", len(all))
+	}
+	prefix := b.GetBlockedIP("192.0.2.0/24")
+	if prefix == nil {
+		t.Fatal("no prefix block after reaching the aggregation threshold")
+	}
+	if prefix.BlockCount != 4 || prefix.Severity != severity.Medium {
+		t.Errorf("prefix block count %d severity %v, want 4 medium", prefix.BlockCount, prefix.Severity)
+	}
+	if !b.IsBlocked("192.0.2.200") {
+		t.Error("IP within the aggregated prefix is not blocked")
+	}
+	if b.IsBlocked("192.0.3.1") {
+		t.Error("IP outside the aggregated prefix is blocked")
+	}
+
+	b.UnblockIP("192.0.2.0/24")
+	if b.IsBlocked("192.0.2.1") {
+		t.Error("IP still blocked after unblocking its prefix")
+	}
+}
+
+func TestAggregationLeavesPermanentAndPeerBlocksApart(t *testing.T) {
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{AggregateThreshold: 3})
+
+	b.BlockRange("192.0.2.1", "operator", severity.High, true)
+	b.BlockFromPeer("peer-a", "192.0.2.2", "shared", severity.High, time.Now().Add(time.Hour))
+	for i := 3; i <= 5; i++ {
+		b.BlockIPWithSeverity(fmt.Sprintf("192.0.2.%d", i), "flood", severity.Medium)
+	}
+
+	prefix := b.GetBlockedIP("192.0.2.0/24")
+	if prefix == nil {
+		t.Fatal("no prefix block after reaching the aggregation threshold")
+	}
+	if prefix.Permanent || prefix.BlockCount != 3 {
+		t.Errorf("prefix block permanent %v count %d, want a temporary block of the 3 detections", prefix.Permanent, prefix.BlockCount)
+	}
+	if blocked := b.GetBlockedIP("192.0.2.1"); blocked == nil || blocked.IP != "192.0.2.1" || !blocked.Permanent {
+		t.Errorf("permanent member merged, got %+v", blocked)
+	}
+
+	if released := b.ReleasePeerBlocks("peer-a", nil); released != 1 {
+		t.Errorf("peer released %d blocks, want its own 1", released)
+	}
+	b.UnblockIP("192.0.2.0/24")
+	if !b.IsBlocked("192.0.2.1") {
+		t.Error("permanent block lifted with the prefix block")
+	}
+	if b.IsBlocked("192.0.2.2") || b.IsBlocked("192.0.2.3") {
+		t.Error("peer or detected block outlived its release")
+	}
+}
+
+func TestOverflowPrefersAggregation(t *testing.T) {
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{MaxEntries: 3, AggregateThreshold: 100})
+
+	b.BlockIPWithSeverity("198.51.100.1", "flood", severity.Low)
+	b.BlockIPWithSeverity("192.0.2.1", "flood", severity.High)
+	b.BlockIPWithSeverity("192.0.2.2", "flood", severity.High)
+	b.BlockIPWithSeverity("203.0.113.1", "flood", severity.Low)
+
+	for _, ip := range []string{"198.51.100.1", "192.0.2.1", "192.0.2.2", "203.0.113.1"} {
+		if !b.IsBlocked(ip) {
+			t.Errorf("%s evicted, want overflow resolved by aggregation", ip)
+		}
+	}
+}
+
+func TestSeverityDurations(t *testing.T) {
+	b := NewIPBlockerWithLimits(60, events.NewBus(), Limits{
+		SeverityDurations: map[severity.Level]time.Duration{severity.High: time.Hour},
+	})
+
+	b.BlockIPWithSeverity("192.0.2.1", "burst", severity.Low)
+	if remaining := time.Until(b.GetBlockedIP("192.0.2.1").BlockUntil); remaining > time.Minute {
+		t.Errorf("Low severity block lasts %v, want the default minute", remaining)
+	}
+
+	b.BlockIPWithSeverity("192.0.2.1", "flood", severity.High)
+	blocked := b.GetBlockedIP("192.0.2.1")
+	if blocked.Severity != severity.High || time.Until(blocked.BlockUntil) < 59*time.Minute {
+		t.Errorf("Escalated block is %v for %v, want high for an hour", blocked.Severity, time.Until(blocked.BlockUntil))
+	}
+
+	b.BlockIPWithSeverity("192.0.2.1", "burst", severity.Low)
+	if b.GetBlockedIP("192.0.2.1").Severity != severity.High {
+		t.Error("A lower severity detection downgraded the block")
+	}
+}
@@ -0,0 +1,140 @@
+package blocker
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"ddd/internal/events"
+)
+
+// ManualLimit caps the query rate of one address by operator decision,
+// apart from detection and from blocks. Queries beyond QPS are refused.
+type ManualLimit struct {
+	IP      string    `json:"ip"`
+	QPS     float64   `json:"qps"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+// manualLimit is a ManualLimit with its token bucket, which holds up to
+// one second of queries
+type manualLimit struct {
+	ManualLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token for a query at now
+func (l *manualLimit) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := max(l.QPS, 1)
+	l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*l.QPS)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// LimitManually caps ip at qps queries per second for d, replacing any
+// earlier manual limit of the address
+func (b *IPBlocker) LimitManually(ip string, qps float64, d time.Duration, reason string) (ManualLimit, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ManualLimit{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+	if qps <= 0 {
+		return ManualLimit{}, fmt.Errorf("qps must be positive, got %v", qps)
+	}
+	if d <= 0 {
+		return ManualLimit{}, fmt.Errorf("window must be positive, got %v", d)
+	}
+
+	now := time.Now()
+	l := &manualLimit{
+		ManualLimit: ManualLimit{
+			IP:      addr.Unmap().String(),
+			QPS:     qps,
+			Reason:  reason,
+			Created: now,
+			Until:   now.Add(d),
+		},
+		tokens: max(qps, 1),
+		last:   now,
+	}
+	b.manualLimits.Store(l.IP, l)
+
+	description := fmt.Sprintf("manual rate limit of %g qps", qps)
+	if reason != "" {
+		description += ": " + reason
+	}
+	b.events.Publish(events.Event{
+		Type:     events.IPRateLimited,
+		IP:       l.IP,
+		Reason:   description,
+		Duration: d,
+	})
+	return l.ManualLimit, nil
+}
+
+// AllowManual reports whether a query from ip at now is within its manual
+// limit, if it has one. It is safe on the per-packet hot path.
+func (b *IPBlocker) AllowManual(ip string, now time.Time) bool {
+	v, ok := b.manualLimits.Load(ip)
+	if !ok {
+		return true
+	}
+	l := v.(*manualLimit)
+	return !now.Before(l.Until) || l.allow(now)
+}
+
+// LiftManualLimit removes the manual limit of ip and reports whether it
+// had one
+func (b *IPBlocker) LiftManualLimit(ip string) bool {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		ip = addr.Unmap().String()
+	}
+	_, ok := b.manualLimits.LoadAndDelete(ip)
+	return ok
+}
+
+// ManualLimits returns the manual limits in force, soonest to expire first
+func (b *IPBlocker) ManualLimits() []ManualLimit {
+	now := time.Now()
+	var out []ManualLimit
+	b.manualLimits.Range(func(_, v interface{}) bool {
+		if l := v.(*manualLimit); now.Before(l.Until) {
+			out = append(out, l.ManualLimit)
+		}
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Until.Equal(out[j].Until) {
+			return out[i].Until.Before(out[j].Until)
+		}
+		return out[i].IP < out[j].IP
+	})
+	return out
+}
+
+// cleanupManualLimits removes expired manual limits and returns how many
+// it scanned and removed
+func (b *IPBlocker) cleanupManualLimits(now time.Time) (scanned, removed int) {
+	b.manualLimits.Range(func(ip, v interface{}) bool {
+		scanned++
+		if !now.Before(v.(*manualLimit).Until) && b.manualLimits.CompareAndDelete(ip, v) {
+			removed++
+		}
+		return true
+	})
+	return scanned, removed
+}
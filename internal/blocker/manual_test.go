@@ -0,0 +1,54 @@
+package blocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualLimit(t *testing.T) {
+	b := newTestBlocker(t, 60)
+	if _, err := b.LimitManually("192.0.2.300", 5, time.Minute, ""); err == nil {
+		t.Error("Expected an invalid address to be rejected")
+	}
+	if _, err := b.LimitManually("192.0.2.1", 0, time.Minute, ""); err == nil {
+		t.Error("Expected a zero rate to be rejected")
+	}
+
+	limit, err := b.LimitManually("192.0.2.1", 2, time.Minute, "noisy agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := limit.Created
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.AllowManual("192.0.2.1", now) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("Expected a burst of 2 queries allowed, got %d", allowed)
+	}
+	if !b.AllowManual("192.0.2.1", now.Add(500*time.Millisecond)) || b.AllowManual("192.0.2.1", now.Add(500*time.Millisecond)) {
+		t.Error("Expected one more query allowed after half a second at 2 qps")
+	}
+	if !b.AllowManual("192.0.2.2", now) {
+		t.Error("Expected other addresses to be unaffected")
+	}
+	if b.IsBlocked("192.0.2.1") || b.IsRateLimited("192.0.2.1") {
+		t.Error("Expected a manual limit to be neither a block nor a detection rate limit")
+	}
+
+	if limits := b.ManualLimits(); len(limits) != 1 || limits[0].Reason != "noisy agent" {
+		t.Errorf("Expected the limit listed, got %+v", limits)
+	}
+	if !b.AllowManual("192.0.2.1", limit.Until) {
+		t.Error("Expected the limit to end with its window")
+	}
+	if !b.LiftManualLimit("192.0.2.1") || b.LiftManualLimit("192.0.2.1") {
+		t.Error("Expected the limit lifted once")
+	}
+	if len(b.ManualLimits()) != 0 {
+		t.Error("Expected no limits after lifting")
+	}
+}
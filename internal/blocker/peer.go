@@ -0,0 +1,88 @@
+package blocker
+
+import (
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
+)
+
+// BlockFromPeer adds or refreshes a block of an IP address or CIDR learned
+// from the named peer, lasting until the given time. A block this node
+// decided itself keeps its origin and is only extended.
+func (b *IPBlocker) BlockFromPeer(peer, entry, reason string, level severity.Level, until time.Time) error {
+	key, err := normalize(entry)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if blocked, exists := b.blockedIPs[key]; exists {
+		blocked.Severity = severity.Max(blocked.Severity, level)
+		if blocked.Origin == peer {
+			blocked.Reason = reason
+			if !blocked.Permanent {
+				blocked.BlockUntil = until
+			}
+		} else if until.After(blocked.BlockUntil) {
+			blocked.BlockUntil = until
+		}
+		b.blockIndex.Store(key, blocked.BlockUntil)
+		return nil
+	}
+
+	b.makeRoomLocked()
+	b.addLocked(&BlockedIP{
+		IP:         key,
+		BlockedAt:  time.Now(),
+		BlockUntil: until,
+		Reason:     reason,
+		Severity:   level,
+		BlockCount: 1,
+		Origin:     peer,
+	})
+	b.checkCapacityLocked()
+
+	b.events.Publish(events.Event{
+		Type:     events.IPBlocked,
+		IP:       key,
+		Reason:   reason,
+		Severity: level,
+		Duration: time.Until(until),
+	})
+	return nil
+}
+
+// ReleasePeerBlocks lifts the blocks learned from the named peer except
+// those of the entries in keep, and returns how many it lifted
+func (b *IPBlocker) ReleasePeerBlocks(peer string, keep []string) int {
+	kept := make(map[string]bool, len(keep))
+	for _, entry := range keep {
+		if key, err := normalize(entry); err == nil {
+			kept[key] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.batchPrefixesLocked()()
+
+	var released []string
+	for key, blocked := range b.blockedIPs {
+		if blocked.Origin == peer && !kept[key] {
+			released = append(released, key)
+		}
+	}
+	for _, key := range released {
+		b.removeLocked(key)
+		b.events.Publish(events.Event{
+			Type:   events.IPUnblocked,
+			IP:     key,
+			Reason: "lifted by peer " + peer,
+		})
+	}
+	b.checkCapacityLocked()
+	return len(released)
+}
@@ -0,0 +1,110 @@
+package blocker
+
+import (
+	"net/netip"
+	"time"
+)
+
+// prefixSet indexes the prefix blocks, aggregation prefixes and ranges
+// alike, by prefix length. The hot path masks a client's address to each
+// length in use and looks the result up, instead of scanning every block.
+// A published set is never modified; changes publish a new one.
+type prefixSet struct {
+	v4, v6 []int                   // prefix lengths in use, longest first
+	keys   map[netip.Prefix]string // prefix -> block list key
+}
+
+// newPrefixSet builds the set for keys, or returns nil when it is empty
+func newPrefixSet(keys map[netip.Prefix]string) *prefixSet {
+	if len(keys) == 0 {
+		return nil
+	}
+	s := &prefixSet{keys: make(map[netip.Prefix]string, len(keys))}
+	var v4 [33]bool
+	var v6 [129]bool
+	for prefix, key := range keys {
+		s.keys[prefix] = key
+		if prefix.Addr().Is4() {
+			v4[prefix.Bits()] = true
+		} else {
+			v6[prefix.Bits()] = true
+		}
+	}
+	for bits := len(v4) - 1; bits >= 0; bits-- {
+		if v4[bits] {
+			s.v4 = append(s.v4, bits)
+		}
+	}
+	for bits := len(v6) - 1; bits >= 0; bits-- {
+		if v6[bits] {
+			s.v6 = append(s.v6, bits)
+		}
+	}
+	return s
+}
+
+// prefixBlocked reports whether ip falls within a prefix block. Like
+// IsBlocked it takes no locks.
+func (b *IPBlocker) prefixBlocked(ip string) bool {
+	set := b.prefixes.Load()
+	if set == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	lengths := set.v6
+	if addr.Is4() {
+		lengths = set.v4
+	}
+	for _, bits := range lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		key, ok := set.keys[prefix]
+		if !ok {
+			continue
+		}
+		if until, exists := b.blockIndex.Load(key); exists && time.Now().Before(until.(time.Time)) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixChangedLocked records a prefix block being added or removed and
+// republishes the set, unless a batch defers it. b.mu must be held.
+func (b *IPBlocker) prefixChangedLocked(key string, added bool) {
+	prefix, err := netip.ParsePrefix(key)
+	if err != nil {
+		return
+	}
+	prefix = prefix.Masked()
+	if added {
+		b.prefixKeys[prefix] = key
+	} else {
+		delete(b.prefixKeys, prefix)
+	}
+	if b.prefixBatch > 0 {
+		b.prefixDirty = true
+		return
+	}
+	b.prefixes.Store(newPrefixSet(b.prefixKeys))
+}
+
+// batchPrefixesLocked defers republishing the prefix set until the
+// returned function is called, so that a bulk change builds it once.
+// b.mu must be held until then.
+func (b *IPBlocker) batchPrefixesLocked() (end func()) {
+	b.prefixBatch++
+	return func() {
+		b.prefixBatch--
+		if b.prefixBatch == 0 && b.prefixDirty {
+			b.prefixDirty = false
+			b.prefixes.Store(newPrefixSet(b.prefixKeys))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package blocker
+
+import (
+	"fmt"
+	"testing"
+
+	"ddd/internal/severity"
+)
+
+func TestPrefixBlocks(t *testing.T) {
+	b := newTestBlocker(t, 60)
+	for i := 0; i < 1000; i++ {
+		b.BlockRange(fmt.Sprintf("10.%d.%d.0/24", i/256, i%256), "list", severity.High, true)
+	}
+	b.BlockRange("172.16.0.0/12", "list", severity.High, true)
+	b.BlockRange("2001:db8::/32", "list", severity.High, true)
+
+	for _, ip := range []string{"10.3.231.9", "172.31.255.1", "2001:db8:1::1", "::ffff:10.0.0.1"} {
+		if !b.IsBlocked(ip) {
+			t.Errorf("Expected %s to be blocked", ip)
+		}
+	}
+	for _, ip := range []string{"10.3.232.9", "172.32.0.1", "2001:db9::1", "not-an-ip"} {
+		if b.IsBlocked(ip) {
+			t.Errorf("Expected %s to pass", ip)
+		}
+	}
+
+	if set := b.prefixes.Load(); len(set.v4) != 2 || len(set.v6) != 1 {
+		t.Errorf("Expected one lookup per prefix length in use, got %v and %v", set.v4, set.v6)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { b.IsBlocked("192.0.2.1") }); allocs != 0 {
+		t.Errorf("Expected no allocations for a client outside every block, got %v", allocs)
+	}
+
+	// A narrower block lifted leaves the wider one enforced
+	b.BlockRange("172.16.5.0/24", "list", severity.High, true)
+	b.UnblockIP("172.16.5.0/24")
+	if !b.IsBlocked("172.16.5.1") {
+		t.Error("Expected the wider block to still apply")
+	}
+	b.UnblockIP("172.16.0.0/12")
+	b.UnblockIP("2001:db8::/32")
+	if b.IsBlocked("172.16.5.1") || len(b.prefixes.Load().v6) != 0 {
+		t.Error("Expected lifted blocks to leave the set")
+	}
+}
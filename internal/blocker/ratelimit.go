@@ -2,138 +2,273 @@ package blocker
 
 import (
 	"context"
+	"net/netip"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"ddd/internal/logger"
+	"ddd/internal/events"
+	"ddd/internal/schedule"
+	"ddd/internal/severity"
 )
 
-// BlockedIP holds information about a blocked IP
+// BlockedIP holds information about a blocked IP. IP is in CIDR notation
+// for a prefix block formed by aggregation.
 type BlockedIP struct {
 	IP          string
 	BlockedAt   time.Time
 	BlockUntil  time.Time
 	Reason      string
+	Severity    severity.Level
 	BlockCount  int
+	Permanent   bool // never expires or is evicted
+	Origin      string // peer the block was learned from; empty for this node's own decisions
+	Listed      bool   // added by the block list file, which lifts it when the entry leaves
 }
 
 // IPBlocker handles IP blocking and rate limiting
 type IPBlocker struct {
 	mu               sync.RWMutex
 	blockedIPs       map[string]*BlockedIP
-	rateLimitedIPs   map[string]time.Time
 	blockDuration    int // in seconds
 	rateLimitWindow  time.Duration
-	log              *logger.Logger
+	events           *events.Bus
+	limits           Limits
+	nearCapacity     bool // a capacity warning has been published
+
+	// Lock-free indexes for the per-packet hot path. blockIndex maps
+	// ip -> time.Time (block expiry) and mirrors blockedIPs, which is only
+	// touched under mu. rateLimitedIPs maps ip -> time.Time (limit expiry).
+	// Expired entries are left in place for cleanup to remove.
+	blockIndex     sync.Map
+	rateLimitedIPs sync.Map
+
+	// manualLimits maps ip -> *manualLimit, the query rate caps set by
+	// operators
+	manualLimits sync.Map
+
+	// exemptions lets operator-chosen networks through rate limiting and
+	// detection for a limited time
+	exemptions exemptions
+
+	// grants raises the budgets of batch jobs' networks for the windows
+	// operators declared
+	grants grants
+
+	// prefixMembers maps each aggregation prefix to the individually
+	// blocked IPs within it
+	prefixMembers map[string]map[string]struct{}
+
+	// prefixes indexes every prefix block, aggregated or a range, for the
+	// hot path; nil when there are none. It is rebuilt from prefixKeys,
+	// which is kept under mu, once per change or per batch of changes.
+	prefixes    atomic.Pointer[prefixSet]
+	prefixKeys  map[netip.Prefix]string
+	prefixBatch int  // nesting depth of batchPrefixesLocked
+	prefixDirty bool // prefixKeys changed during the batch
+
+	// filter screens the blockIndex lookup of individual IPs; nil until
+	// the first one is blocked
+	filter atomic.Pointer[blockFilter]
 }
 
-// NewIPBlocker creates a new IP blocker
-func NewIPBlocker(blockDuration int, log *logger.Logger) *IPBlocker {
+// NewIPBlocker creates a new IP blocker. Enforcement decisions are
+// published on bus rather than logged inline, so that slow log sinks never
+// add latency to blocking.
+func NewIPBlocker(blockDuration int, bus *events.Bus) *IPBlocker {
+	return NewIPBlockerWithLimits(blockDuration, bus, Limits{})
+}
+
+// NewIPBlockerWithLimits creates a new IP blocker with a bounded block list
+func NewIPBlockerWithLimits(blockDuration int, bus *events.Bus, limits Limits) *IPBlocker {
 	return &IPBlocker{
 		blockedIPs:      make(map[string]*BlockedIP),
-		rateLimitedIPs:  make(map[string]time.Time),
+		prefixMembers:   make(map[string]map[string]struct{}),
+		prefixKeys:      make(map[netip.Prefix]string),
 		blockDuration:   blockDuration,
 		rateLimitWindow: 30 * time.Second,
-		log:             log,
+		events:          bus,
+		limits:          limits,
 	}
 }
 
-// IsBlocked checks if an IP is currently blocked
+// IsBlocked checks if an IP, or a prefix block containing it, is currently
+// blocked. It takes no locks and never mutates state, so it is safe on the
+// per-packet hot path.
 func (b *IPBlocker) IsBlocked(ip string) bool {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	if blocked, exists := b.blockedIPs[ip]; exists {
-		// Check if block has expired
-		if time.Now().Before(blocked.BlockUntil) {
+	if f := b.filter.Load(); f == nil || f.mayContain(ip) {
+		if until, exists := b.blockIndex.Load(ip); exists && time.Now().Before(until.(time.Time)) {
 			return true
 		}
-		// Block expired, remove it
-		delete(b.blockedIPs, ip)
 	}
-
-	return false
+	return b.prefixBlocked(ip)
 }
 
 // IsRateLimited checks if an IP is currently rate limited
 func (b *IPBlocker) IsRateLimited(ip string) bool {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	if limitedUntil, exists := b.rateLimitedIPs[ip]; exists {
-		if time.Now().Before(limitedUntil) {
-			return true
-		}
-		// Rate limit expired
-		delete(b.rateLimitedIPs, ip)
+	if until, exists := b.rateLimitedIPs.Load(ip); exists {
+		return time.Now().Before(until.(time.Time))
 	}
-
 	return false
 }
 
+// IsBucketRateLimited checks if an IP, or the client with the given
+// fingerprint behind it, is currently rate limited
+func (b *IPBlocker) IsBucketRateLimited(ip, fingerprint string) bool {
+	return b.IsRateLimited(ip) || fingerprint != "" && b.IsRateLimited(bucketKey(ip, fingerprint))
+}
+
+// bucketKey keys a rate limit on one client fingerprint behind ip
+func bucketKey(ip, fingerprint string) string {
+	if fingerprint == "" {
+		return ip
+	}
+	return ip + "#" + fingerprint
+}
+
 // BlockIP blocks an IP address for the configured duration
 func (b *IPBlocker) BlockIP(ip, reason string) {
+	b.BlockIPWithSeverity(ip, reason, severity.None)
+}
+
+// BlockIPWithSeverity blocks an IP address, recording the severity of the
+// detection so that high-severity blocks last as configured for their
+// level and survive list overflow longest
+func (b *IPBlocker) BlockIPWithSeverity(ip, reason string, level severity.Level) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	blockUntil := time.Now().Add(time.Duration(b.blockDuration) * time.Second)
+	// An IP inside an aggregated prefix extends the prefix block instead
+	key := ip
+	prefix := prefixOf(ip)
+	if _, exists := b.blockedIPs[prefix]; exists {
+		key = prefix
+	}
 
-	if blocked, exists := b.blockedIPs[ip]; exists {
-		// IP already blocked, extend block and increment count
-		blocked.BlockUntil = blockUntil
+	duration := b.durationFor(level)
+	if blocked, exists := b.blockedIPs[key]; exists {
+		// Already blocked, escalate and extend block and increment count
+		blocked.Severity = severity.Max(blocked.Severity, level)
+		duration = b.durationFor(blocked.Severity)
+		if !blocked.Permanent {
+			blocked.BlockUntil = time.Now().Add(duration)
+		}
 		blocked.BlockCount++
-		blocked.Reason = reason
+		if key == ip {
+			blocked.Reason = reason
+			blocked.Origin = "" // detected here too, so no longer the peer's to lift
+			blocked.Listed = false
+		}
+		b.blockIndex.Store(key, blocked.BlockUntil)
 	} else {
 		// New block
-		b.blockedIPs[ip] = &BlockedIP{
+		b.makeRoomLocked()
+		b.addLocked(&BlockedIP{
 			IP:         ip,
 			BlockedAt:  time.Now(),
-			BlockUntil: blockUntil,
+			BlockUntil: time.Now().Add(duration),
 			Reason:     reason,
+			Severity:   level,
 			BlockCount: 1,
+		})
+		if t := b.limits.AggregateThreshold; t > 0 && len(b.aggregatableLocked(prefix)) >= t {
+			b.aggregateLocked(prefix)
 		}
 	}
+	b.checkCapacityLocked()
+
+	b.events.Publish(events.Event{
+		Type:     events.IPBlocked,
+		IP:       ip,
+		Reason:   reason,
+		Severity: level,
+		Duration: duration,
+	})
+}
 
-	b.log.LogIPBlocked(ip, reason, b.blockDuration)
-	b.log.LogMitigationAction(ip, "block", reason)
+// durationFor returns how long a block of the given severity lasts
+func (b *IPBlocker) durationFor(level severity.Level) time.Duration {
+	if d, ok := b.limits.SeverityDurations[level]; ok && d > 0 {
+		return d
+	}
+	return time.Duration(b.blockDuration) * time.Second
 }
 
 // RateLimitIP applies rate limiting to an IP
 func (b *IPBlocker) RateLimitIP(ip string) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	b.RateLimitBucket(ip, "", b.rateLimitWindow)
+}
 
-	limitUntil := time.Now().Add(b.rateLimitWindow)
-	b.rateLimitedIPs[ip] = limitUntil
+// RateLimitBucket rate limits the client with the given fingerprint
+// behind ip for d, leaving other clients sharing the address alone. An
+// empty fingerprint limits the whole IP.
+func (b *IPBlocker) RateLimitBucket(ip, fingerprint string, d time.Duration) {
+	b.rateLimitedIPs.Store(bucketKey(ip, fingerprint), time.Now().Add(d))
+
+	reason := "temporary rate limiting applied"
+	if fingerprint != "" {
+		reason += " to client " + fingerprint
+	}
+	b.events.Publish(events.Event{
+		Type:     events.IPRateLimited,
+		IP:       ip,
+		Reason:   reason,
+		Duration: d,
+	})
+}
 
-	b.log.LogIPRateLimited(ip)
-	b.log.LogMitigationAction(ip, "rate_limit", "temporary rate limiting applied")
+// RateLimitWindow returns how long rate limits last
+func (b *IPBlocker) RateLimitWindow() time.Duration {
+	return b.rateLimitWindow
 }
 
-// UnblockIP manually unblocks an IP address
+// UnblockIP manually unblocks an IP address or, given its CIDR notation, a
+// prefix block. Unblocking a single IP leaves any prefix block covering it
+// in place.
 func (b *IPBlocker) UnblockIP(ip string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	delete(b.blockedIPs, ip)
-	delete(b.rateLimitedIPs, ip)
-
-	b.log.LogMitigationAction(ip, "unblock", "manually unblocked")
+	b.removeLocked(ip)
+	b.rateLimitedIPs.Delete(ip)
+	b.rateLimitedIPs.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), ip+"#") {
+			b.rateLimitedIPs.Delete(key)
+		}
+		return true
+	})
+	b.checkCapacityLocked()
+
+	b.events.Publish(events.Event{
+		Type:   events.IPUnblocked,
+		IP:     ip,
+		Reason: "manually unblocked",
+	})
 }
 
-// GetBlockedIP returns information about a blocked IP
+// GetBlockedIP returns information about a blocked IP, or about the prefix
+// block containing it
 func (b *IPBlocker) GetBlockedIP(ip string) *BlockedIP {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	if blocked, exists := b.blockedIPs[ip]; exists {
+	blocked, exists := b.blockedIPs[ip]
+	if !exists {
+		blocked, exists = b.blockedIPs[prefixOf(ip)]
+	}
+	if exists {
 		// Return a copy
 		return &BlockedIP{
 			IP:         blocked.IP,
 			BlockedAt:  blocked.BlockedAt,
 			BlockUntil: blocked.BlockUntil,
 			Reason:     blocked.Reason,
+			Severity:   blocked.Severity,
 			BlockCount: blocked.BlockCount,
+			Permanent:  blocked.Permanent,
+			Origin:     blocked.Origin,
+			Listed:     blocked.Listed,
 		}
 	}
 
@@ -155,7 +290,11 @@ func (b *IPBlocker) GetAllBlockedIPs() []*BlockedIP {
 				BlockedAt:  ip.BlockedAt,
 				BlockUntil: ip.BlockUntil,
 				Reason:     ip.Reason,
+				Severity:   ip.Severity,
 				BlockCount: ip.BlockCount,
+				Permanent:  ip.Permanent,
+				Origin:     ip.Origin,
+				Listed:     ip.Listed,
 			})
 		}
 	}
@@ -163,41 +302,66 @@ func (b *IPBlocker) GetAllBlockedIPs() []*BlockedIP {
 	return blocked
 }
 
-// StartCleanup periodically cleans up expired blocks and rate limits
-func (b *IPBlocker) StartCleanup(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			b.cleanup()
-		}
-	}
+// StartCleanup periodically cleans up expired blocks and rate limits on a
+// jittered schedule
+func (b *IPBlocker) StartCleanup(ctx context.Context, interval time.Duration, jitter float64) {
+	schedule.RunCleanup(ctx, "blocker", interval, jitter, b.cleanup)
 }
 
 // cleanup removes expired blocks and rate limits
-func (b *IPBlocker) cleanup() {
+func (b *IPBlocker) cleanup() (scanned, removed int, lockHeld time.Duration) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	start := time.Now()
+	endBatch := b.batchPrefixesLocked()
+	defer func() {
+		endBatch()
+		lockHeld = time.Since(start)
+		b.mu.Unlock()
+	}()
 
 	now := time.Now()
 
 	// Clean up expired blocks
 	for ip, blocked := range b.blockedIPs {
+		scanned++
 		if now.After(blocked.BlockUntil) {
-			delete(b.blockedIPs, ip)
+			b.removeLocked(ip)
+			removed++
 		}
 	}
 
-	// Clean up expired rate limits
-	for ip, limitUntil := range b.rateLimitedIPs {
-		if now.After(limitUntil) {
-			delete(b.rateLimitedIPs, ip)
+	b.checkCapacityLocked()
+
+	// Clean up expired rate limits. A limit renewed concurrently is kept
+	// because CompareAndDelete only removes the value we saw.
+	b.rateLimitedIPs.Range(func(ip, until interface{}) bool {
+		scanned++
+		if now.After(until.(time.Time)) && b.rateLimitedIPs.CompareAndDelete(ip, until) {
+			removed++
 		}
-	}
+		return true
+	})
+
+	manualScanned, manualRemoved := b.cleanupManualLimits(now)
+	scanned += manualScanned
+	removed += manualRemoved
+
+	exemptScanned, exemptRemoved := b.cleanupExemptions(now)
+	scanned += exemptScanned
+	removed += exemptRemoved
+
+	grantScanned, grantRemoved := b.cleanupGrants(now)
+	scanned += grantScanned
+	removed += grantRemoved
+
+	return scanned, removed, lockHeld
+}
+
+// BlockedCount returns the number of block list entries
+func (b *IPBlocker) BlockedCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.blockedIPs)
 }
 
 // GetBlockStats returns statistics about blocking
@@ -205,9 +369,17 @@ func (b *IPBlocker) GetBlockStats() map[string]interface{} {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
+	rateLimited := 0
+	b.rateLimitedIPs.Range(func(_, _ interface{}) bool {
+		rateLimited++
+		return true
+	})
+
 	stats := make(map[string]interface{})
 	stats["total_blocked"] = len(b.blockedIPs)
-	stats["total_rate_limited"] = len(b.rateLimitedIPs)
+	stats["total_rate_limited"] = rateLimited
+	stats["total_manual_limits"] = len(b.ManualLimits())
+	stats["max_entries"] = b.limits.MaxEntries
 
 	return stats
 }
@@ -0,0 +1,169 @@
+package blocker
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
+)
+
+func newTestBlocker(t testing.TB, blockSeconds int) *IPBlocker {
+	return NewIPBlocker(blockSeconds, events.NewBus())
+}
+
+func TestBlockAndUnblock(t *testing.T) {
+	b := newTestBlocker(t, 60)
+
+	b.BlockIP("192.0.2.1", "test")
+	if !b.IsBlocked("192.0.2.1") {
+		t.Error("Expected IP to be blocked")
+	}
+	if b.IsBlocked("192.0.2.2") {
+		t.Error("Expected other IP not to be blocked")
+	}
+
+	b.UnblockIP("192.0.2.1")
+	if b.IsBlocked("192.0.2.1") {
+		t.Error("Expected IP to be unblocked")
+	}
+}
+
+func TestBlockedCount(t *testing.T) {
+	b := newTestBlocker(t, 60)
+	b.BlockIP("192.0.2.1", "test")
+	b.BlockIP("192.0.2.2", "test")
+	b.UnblockIP("192.0.2.1")
+
+	if n := b.BlockedCount(); n != 1 || n != b.GetBlockStats()["total_blocked"] {
+		t.Errorf("Expected 1 blocked, got %d", n)
+	}
+}
+
+func TestBlockPublishesEvent(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe("test", 1)
+	b := NewIPBlocker(60, bus)
+
+	b.BlockIP("192.0.2.1", "high_request_rate")
+
+	select {
+	case e := <-sub:
+		if e.Type != events.IPBlocked || e.IP != "192.0.2.1" || e.Duration != time.Minute {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	default:
+		t.Error("Expected a block event to be published")
+	}
+}
+
+func TestExpiredBlockIsNotEnforced(t *testing.T) {
+	b := newTestBlocker(t, 0)
+
+	b.BlockIP("192.0.2.1", "test")
+	time.Sleep(time.Millisecond)
+
+	if b.IsBlocked("192.0.2.1") {
+		t.Error("Expected expired block not to be enforced")
+	}
+
+	if _, removed, _ := b.cleanup(); removed != 1 {
+		t.Errorf("Expected cleanup to remove 1 expired block, got %d", removed)
+	}
+}
+
+// TestConcurrentAccess is meant to be run with -race
+func TestConcurrentAccess(t *testing.T) {
+	b := newTestBlocker(t, 60)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				ip := fmt.Sprintf("10.%d.0.%d", w, i)
+				b.BlockIP(ip, "test")
+				b.RateLimitIP(ip)
+				if i%3 == 0 {
+					b.UnblockIP(ip)
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				ip := fmt.Sprintf("10.%d.0.%d", w, i%200)
+				b.IsBlocked(ip)
+				b.IsRateLimited(ip)
+				if i%500 == 0 {
+					b.cleanup()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func BenchmarkIsBlocked(b *testing.B) {
+	blocker := newTestBlocker(b, 60)
+	for i := 0; i < 1000; i++ {
+		blocker.BlockIP(fmt.Sprintf("10.0.%d.%d", i/256, i%256), "bench")
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			blocker.IsBlocked(fmt.Sprintf("10.0.%d.%d", (i/256)%8, i%256))
+			i++
+		}
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	old := newTestBlocker(t, 60)
+	old.BlockIPWithSeverity("192.0.2.1", "test", severity.High)
+	old.BlockIP("2001:db8::1", "test")
+
+	var buf bytes.Buffer
+	if n, err := old.Export(&buf); err != nil || n != 2 {
+		t.Fatalf("Export = %d, %v", n, err)
+	}
+
+	b := newTestBlocker(t, 60)
+	if n, err := b.Import(&buf); err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+	if !b.IsBlocked("192.0.2.1") || !b.IsBlocked("2001:db8::1") {
+		t.Error("Expected imported blocks to be enforced")
+	}
+	if got := b.GetBlockedIP("192.0.2.1"); got.Severity != severity.High {
+		t.Errorf("Expected severity to survive the transfer, got %v", got.Severity)
+	}
+}
+
+func TestBucketRateLimit(t *testing.T) {
+	b := newTestBlocker(t, 60)
+	b.RateLimitBucket("192.0.2.1", "aaaa", time.Minute)
+
+	if !b.IsBucketRateLimited("192.0.2.1", "aaaa") {
+		t.Error("Expected the fingerprinted client to be rate limited")
+	}
+	if b.IsBucketRateLimited("192.0.2.1", "bbbb") || b.IsRateLimited("192.0.2.1") {
+		t.Error("Expected other clients behind the address to be left alone")
+	}
+
+	b.RateLimitIP("192.0.2.2")
+	if !b.IsBucketRateLimited("192.0.2.2", "bbbb") {
+		t.Error("Expected an address-wide limit to cover every client behind it")
+	}
+
+	b.UnblockIP("192.0.2.1")
+	if b.IsBucketRateLimited("192.0.2.1", "aaaa") {
+		t.Error("Expected unblocking to lift client limits")
+	}
+}
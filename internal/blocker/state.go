@@ -0,0 +1,40 @@
+package blocker
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Export writes the current blocks as JSON, for handing to another process
+func (b *IPBlocker) Export(w io.Writer) (int, error) {
+	blocked := b.GetAllBlockedIPs()
+	return len(blocked), json.NewEncoder(w).Encode(blocked)
+}
+
+// Import adds the unexpired blocks written by Export. Blocks already
+// present are kept as they are.
+func (b *IPBlocker) Import(r io.Reader) (int, error) {
+	var blocked []*BlockedIP
+	if err := json.NewDecoder(r).Decode(&blocked); err != nil {
+		return 0, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.batchPrefixesLocked()()
+
+	now := time.Now()
+	imported := 0
+	for _, entry := range blocked {
+		if _, exists := b.blockedIPs[entry.IP]; exists || !now.Before(entry.BlockUntil) {
+			continue
+		}
+		b.makeRoomLocked()
+		b.addLocked(entry)
+		imported++
+	}
+	b.checkCapacityLocked()
+
+	return imported, nil
+}
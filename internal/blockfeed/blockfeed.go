@@ -0,0 +1,312 @@
+// Package blockfeed shares block decisions between installations. A node
+// publishes its own blocks as a feed that peers long-poll through the
+// admin API; a subscriber adopts the blocks of one peer's feed, within the
+// limits the operator trusts that peer, and lifts them again when the peer
+// does. Blocks learned from a peer are never republished, so feeds do not
+// echo between nodes that subscribe to each other.
+package blockfeed
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"ddd/internal/blocker"
+	"ddd/internal/events"
+	"ddd/internal/federation"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+var (
+	adoptedGauge = metrics.NewGaugeVec("ddd_blockfeed_adopted",
+		"Blocks currently adopted from a peer's block feed", "peer")
+	rejectedGauge = metrics.NewGaugeVec("ddd_blockfeed_rejected",
+		"Blocks in a peer's current feed left out by the trust settings", "peer")
+	fetchErrors = metrics.NewCounterVec("ddd_blockfeed_fetch_errors_total",
+		"Failed polls of a peer's block feed", "peer")
+	propagation = metrics.NewHistogramVec("ddd_block_propagation_seconds",
+		"Time from a peer deciding a block to this node enforcing it, by peer",
+		[]float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}, "peer")
+	slowPropagation = metrics.NewCounterVec("ddd_block_propagation_slow_total",
+		"Blocks adopted from a peer later than federation.propagation_alert", "peer")
+)
+
+// Feed is the list of blocks a node decided itself. Version changes
+// whenever the node's block list does.
+type Feed struct {
+	Node    string             `json:"node"`
+	Version uint64             `json:"version"`
+	Blocks  []federation.Block `json:"blocks"`
+}
+
+// Publisher serves this node's feed
+type Publisher struct {
+	node    string
+	blocker *blocker.IPBlocker
+
+	mu      sync.Mutex
+	version uint64
+	changed chan struct{} // closed and replaced on every change
+}
+
+// NewPublisher creates the feed of b's blocks, published as node. Versions
+// start from the clock so that they keep growing across restarts.
+func NewPublisher(node string, b *blocker.IPBlocker) *Publisher {
+	return &Publisher{
+		node:    node,
+		blocker: b,
+		version: uint64(time.Now().UnixMicro()),
+		changed: make(chan struct{}),
+	}
+}
+
+// Handle moves the feed to a new version when a block is added, extended
+// or lifted. It consumes the event bus.
+func (p *Publisher) Handle(e events.Event) {
+	if e.Type != events.IPBlocked && e.Type != events.IPUnblocked && e.Type != events.BlockListSynced {
+		return
+	}
+	p.mu.Lock()
+	p.version++
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+}
+
+// Wait returns the feed as soon as its version differs from since, or as
+// it stands once wait has passed or ctx is done
+func (p *Publisher) Wait(ctx context.Context, since uint64, wait time.Duration) Feed {
+	p.mu.Lock()
+	version, changed := p.version, p.changed
+	p.mu.Unlock()
+
+	if version == since {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return p.Snapshot()
+}
+
+// Snapshot returns the current feed
+func (p *Publisher) Snapshot() Feed {
+	p.mu.Lock()
+	feed := Feed{Node: p.node, Version: p.version, Blocks: []federation.Block{}}
+	p.mu.Unlock()
+
+	for _, b := range p.blocker.GetAllBlockedIPs() {
+		if b.Origin != "" {
+			continue
+		}
+		feed.Blocks = append(feed.Blocks, federation.Block{
+			IP:       b.IP,
+			Reason:   b.Reason,
+			Severity: b.Severity,
+			Since:    b.BlockedAt.UTC(),
+			Until:    b.BlockUntil.UTC(),
+		})
+	}
+	sort.Slice(feed.Blocks, func(i, j int) bool { return feed.Blocks[i].IP < feed.Blocks[j].IP })
+	return feed
+}
+
+// Fetch long-polls a peer's feed, returning once its version differs from
+// since or wait has passed
+type Fetch func(ctx context.Context, since uint64, wait time.Duration) (*Feed, error)
+
+// Default shortest prefixes a peer may block, so that a mistaken or
+// hostile feed cannot block whole regions of the address space
+const (
+	DefaultMinBits4 = 16
+	DefaultMinBits6 = 32
+)
+
+// Trust limits what a subscriber adopts from its peer
+type Trust struct {
+	MinSeverity severity.Level // blocks of lower severity are ignored
+	MaxTTL      time.Duration  // cap on how long a block outlives its last appearance in the feed; 0 keeps the peer's expiry
+	Networks    []netip.Prefix // the peer may only block within these; empty trusts any address
+	// Shortest IPv4 and IPv6 prefixes the peer may block; 0 uses
+	// DefaultMinBits4 and DefaultMinBits6
+	MinBits4, MinBits6 int
+}
+
+// Subscriber adopts the blocks of one peer's feed
+type Subscriber struct {
+	peer    string
+	fetch   Fetch
+	trust   Trust
+	blocker *blocker.IPBlocker
+	wait    time.Duration
+	timeout time.Duration
+	log     *logger.Logger
+
+	// Propagation alerts, and the blocks adopted from the last feed, so
+	// that only new ones are timed
+	alert   time.Duration
+	bus     *events.Bus
+	synced  bool
+	adopted map[string]bool
+}
+
+// NewSubscriber creates a subscriber adopting into b the blocks that fetch
+// returns from peer. Each poll waits up to wait for a change, plus timeout
+// for the exchange itself.
+func NewSubscriber(peer string, fetch Fetch, trust Trust, b *blocker.IPBlocker, wait, timeout time.Duration, log *logger.Logger) *Subscriber {
+	if trust.MinBits4 == 0 {
+		trust.MinBits4 = DefaultMinBits4
+	}
+	if trust.MinBits6 == 0 {
+		trust.MinBits6 = DefaultMinBits6
+	}
+	return &Subscriber{
+		peer:    peer,
+		fetch:   fetch,
+		trust:   trust,
+		blocker: b,
+		wait:    wait,
+		timeout: timeout,
+		log:     log,
+	}
+}
+
+// AlertAfter makes the subscriber publish a BlockPropagationSlow event on
+// bus when blocks arrive more than threshold after the peer decided them
+func (s *Subscriber) AlertAfter(threshold time.Duration, bus *events.Bus) *Subscriber {
+	s.alert, s.bus = threshold, bus
+	return s
+}
+
+// Run polls the peer until ctx is cancelled, backing off while it is
+// unreachable. Adopted blocks stay in place, until they expire, while the
+// peer cannot be reached.
+func (s *Subscriber) Run(ctx context.Context) {
+	var since uint64
+	backoff := time.Second
+	failing := false
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, s.wait+s.timeout)
+		feed, err := s.fetch(pollCtx, since, s.wait)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			fetchErrors.With(s.peer).Inc()
+			if !failing {
+				s.log.Warnw("Failed to poll peer block feed", "peer", s.peer, "error", err)
+				failing = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+
+		if failing || since == 0 {
+			s.log.Infow("Subscribed to peer block feed", "peer", s.peer, "node", feed.Node, "blocks", len(feed.Blocks))
+		}
+		failing, backoff = false, time.Second
+		since = feed.Version
+		s.apply(feed, time.Now())
+	}
+}
+
+// apply adopts the trusted blocks of feed and lifts those adopted earlier
+// that the peer no longer lists. Blocks new since the previous feed are
+// timed from the peer's decision; those of the first feed are not, as
+// they were decided before this node subscribed.
+func (s *Subscriber) apply(feed *Feed, now time.Time) {
+	var keep []string
+	rejected := 0
+	slow, worst := 0, time.Duration(0)
+	adopted := make(map[string]bool, len(feed.Blocks))
+	for _, b := range feed.Blocks {
+		if b.Severity < s.trust.MinSeverity || !s.trusted(b.IP) {
+			rejected++
+			continue
+		}
+		until := b.Until
+		if s.trust.MaxTTL > 0 && until.After(now.Add(s.trust.MaxTTL)) {
+			until = now.Add(s.trust.MaxTTL)
+		}
+		if !now.Before(until) {
+			continue
+		}
+		if err := s.blocker.BlockFromPeer(s.peer, b.IP, "peer "+s.peer+": "+b.Reason, b.Severity, until); err != nil {
+			rejected++
+			continue
+		}
+		keep = append(keep, b.IP)
+		adopted[b.IP] = true
+
+		if !s.synced || s.adopted[b.IP] || b.Since.IsZero() {
+			continue
+		}
+		latency := max(now.Sub(b.Since), 0) // clocks may disagree
+		propagation.With(s.peer).Observe(latency.Seconds())
+		if s.alert > 0 && latency > s.alert {
+			slowPropagation.With(s.peer).Inc()
+			slow++
+			worst = max(worst, latency)
+		}
+	}
+	s.synced, s.adopted = true, adopted
+
+	if slow > 0 && s.bus != nil {
+		s.bus.Publish(events.Event{
+			Type:     events.BlockPropagationSlow,
+			Reason:   fmt.Sprintf("%d blocks from peer %s arrived later than %v", slow, s.peer, s.alert),
+			Duration: worst,
+		})
+	}
+
+	if released := s.blocker.ReleasePeerBlocks(s.peer, keep); released > 0 {
+		s.log.Debugw("Lifted blocks the peer no longer lists", "peer", s.peer, "blocks", released)
+	}
+	adoptedGauge.With(s.peer).Set(float64(len(keep)))
+	rejectedGauge.With(s.peer).Set(float64(rejected))
+}
+
+// trusted reports whether entry, an address or CIDR, is no wider than the
+// peer may block and lies within the networks it may block
+func (s *Subscriber) trusted(entry string) bool {
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	minBits := s.trust.MinBits6
+	if prefix.Addr().Is4() {
+		minBits = s.trust.MinBits4
+	}
+	if prefix.Bits() < minBits {
+		return false
+	}
+	if len(s.trust.Networks) == 0 {
+		return true
+	}
+	for _, n := range s.trust.Networks {
+		if n.Bits() <= prefix.Bits() && n.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package blockfeed
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"ddd/internal/blocker"
+	"ddd/internal/events"
+	"ddd/internal/federation"
+	"ddd/internal/logger"
+	"ddd/internal/severity"
+)
+
+func TestPublisher(t *testing.T) {
+	bus := events.NewBus()
+	b := blocker.NewIPBlocker(60, bus)
+	p := NewPublisher("hq", b)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go events.Consume(ctx, bus.Subscribe("blockfeed", 16), p.Handle)
+
+	first := p.Wait(ctx, 0, time.Second)
+	if len(first.Blocks) != 0 {
+		t.Fatalf("Expected an empty feed, got %+v", first)
+	}
+
+	done := make(chan Feed)
+	go func() { done <- p.Wait(ctx, first.Version, 5*time.Second) }()
+	b.BlockIPWithSeverity("192.0.2.1", "flood", severity.High)
+	b.BlockFromPeer("branch", "198.51.100.7", "peer branch: flood", severity.High, time.Now().Add(time.Minute))
+
+	select {
+	case feed := <-done:
+		if feed.Version == first.Version {
+			t.Error("Expected a new version after a block")
+		}
+		if len(feed.Blocks) != 1 || feed.Blocks[0].IP != "192.0.2.1" || feed.Blocks[0].Severity != severity.High {
+			t.Errorf("Expected only the local block in the feed, got %+v", feed.Blocks)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the long poll to return on a change")
+	}
+}
+
+func TestPublisherDatesAggregatedBlocks(t *testing.T) {
+	b := blocker.NewIPBlockerWithLimits(60, events.NewBus(), blocker.Limits{AggregateThreshold: 2})
+	b.BlockIPWithSeverity("192.0.2.1", "flood", severity.High)
+	time.Sleep(20 * time.Millisecond)
+	merged := time.Now()
+	b.BlockIPWithSeverity("192.0.2.2", "flood", severity.High)
+
+	feed := NewPublisher("hq", b).Snapshot()
+	if len(feed.Blocks) != 1 || feed.Blocks[0].IP != "192.0.2.0/24" {
+		t.Fatalf("Expected the aggregated prefix alone in the feed, got %+v", feed.Blocks)
+	}
+	// Dated from its first member, the prefix would be timed as arriving
+	// late by however long that member was blocked alone
+	if since := feed.Blocks[0].Since; since.Before(merged) {
+		t.Errorf("Expected the prefix dated when it was merged, at %v or later, got %v", merged, since)
+	}
+}
+
+func TestSubscriberApply(t *testing.T) {
+	b := blocker.NewIPBlocker(60, events.NewBus())
+	s := NewSubscriber("hq", nil, Trust{
+		MinSeverity: severity.Medium,
+		MaxTTL:      time.Hour,
+		Networks:    []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("10.0.0.0/8")},
+	}, b, time.Second, time.Second, logger.NewNop())
+
+	now := time.Now()
+	s.apply(&Feed{Blocks: []federation.Block{
+		{IP: "192.0.2.1", Reason: "flood", Severity: severity.High, Until: now.Add(24 * time.Hour)},
+		{IP: "192.0.2.2", Reason: "burst", Severity: severity.Low, Until: now.Add(time.Hour)},
+		{IP: "10.1.0.0/16", Reason: "botnet", Severity: severity.High, Until: now.Add(time.Hour)},
+		{IP: "0.0.0.0/0", Reason: "oops", Severity: severity.High, Until: now.Add(time.Hour)},
+		{IP: "203.0.113.5", Reason: "flood", Severity: severity.High, Until: now.Add(time.Hour)},
+	}}, now)
+
+	got := b.GetBlockedIP("192.0.2.1")
+	if got == nil || got.Origin != "hq" || got.Reason != "peer hq: flood" {
+		t.Fatalf("Expected the trusted block adopted from hq, got %+v", got)
+	}
+	if got.BlockUntil.After(now.Add(time.Hour)) {
+		t.Errorf("Expected the expiry capped at max_ttl, got %v", got.BlockUntil)
+	}
+	if !b.IsBlocked("10.1.2.3") {
+		t.Error("Expected the trusted range to be adopted")
+	}
+	for _, ip := range []string{"192.0.2.2", "203.0.113.5", "198.51.100.1"} {
+		if b.IsBlocked(ip) {
+			t.Errorf("Expected %s to be left out by the trust settings", ip)
+		}
+	}
+
+	// A block also detected locally stays when the peer lifts it
+	b.BlockIPWithSeverity("192.0.2.1", "rate", severity.High)
+	s.apply(&Feed{}, now)
+	if !b.IsBlocked("192.0.2.1") {
+		t.Error("Expected the local detection to keep its block")
+	}
+	if b.IsBlocked("10.1.2.3") {
+		t.Error("Expected the range lifted with the peer's feed")
+	}
+}
+
+func TestSubscriberRefusesWidePrefixes(t *testing.T) {
+	b := blocker.NewIPBlocker(60, events.NewBus())
+	s := NewSubscriber("hq", nil, Trust{}, b, time.Second, time.Second, logger.NewNop())
+
+	now := time.Now()
+	s.apply(&Feed{Blocks: []federation.Block{
+		{IP: "0.0.0.0/0", Reason: "oops", Severity: severity.High, Until: now.Add(time.Hour)},
+		{IP: "::/0", Reason: "oops", Severity: severity.High, Until: now.Add(time.Hour)},
+		{IP: "10.0.0.0/8", Reason: "botnet", Severity: severity.High, Until: now.Add(time.Hour)},
+		{IP: "10.1.0.0/16", Reason: "botnet", Severity: severity.High, Until: now.Add(time.Hour)},
+		{IP: "2001:db8::/32", Reason: "botnet", Severity: severity.High, Until: now.Add(time.Hour)},
+	}}, now)
+
+	for _, ip := range []string{"198.51.100.1", "10.2.0.1", "2001:db9::1"} {
+		if b.IsBlocked(ip) {
+			t.Errorf("Expected %s left unblocked, as only networks up to /16 and /32 are trusted", ip)
+		}
+	}
+	if !b.IsBlocked("10.1.2.3") || !b.IsBlocked("2001:db8::1") {
+		t.Error("Expected the /16 and /32 ranges to be adopted")
+	}
+}
+
+func TestSubscriberPropagation(t *testing.T) {
+	bus := events.NewBus()
+	alerts := bus.Subscribe("test", 4)
+	b := blocker.NewIPBlocker(60, events.NewBus())
+	s := NewSubscriber("hq", nil, Trust{}, b, time.Second, time.Second, logger.NewNop()).AlertAfter(5*time.Second, bus)
+	hist := propagation.With("hq")
+	before := hist.Count()
+
+	now := time.Now()
+	old := federation.Block{IP: "192.0.2.1", Severity: severity.High, Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}
+	s.apply(&Feed{Blocks: []federation.Block{old}}, now)
+	if hist.Count() != before {
+		t.Error("Expected the blocks of the first feed not to be timed")
+	}
+
+	fast := federation.Block{IP: "192.0.2.2", Severity: severity.High, Since: now.Add(-time.Second), Until: now.Add(time.Hour)}
+	s.apply(&Feed{Blocks: []federation.Block{old, fast}}, now)
+	if hist.Count() != before+1 {
+		t.Errorf("Expected only the new block timed, got %d observations", hist.Count()-before)
+	}
+	select {
+	case e := <-alerts:
+		t.Errorf("Expected no alert for a fast block, got %+v", e)
+	default:
+	}
+
+	slow := federation.Block{IP: "192.0.2.3", Severity: severity.High, Since: now.Add(-time.Minute), Until: now.Add(time.Hour)}
+	s.apply(&Feed{Blocks: []federation.Block{old, fast, slow}}, now)
+	select {
+	case e := <-alerts:
+		if e.Type != events.BlockPropagationSlow || e.Duration != time.Minute {
+			t.Errorf("Expected a slow propagation alert of 1m, got %+v", e)
+		}
+	default:
+		t.Error("Expected a slow propagation alert")
+	}
+}
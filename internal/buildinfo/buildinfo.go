@@ -0,0 +1,139 @@
+// Package buildinfo describes the running binary for support requests:
+// the version and VCS revision, the platform and how long the process has
+// been up. Release builds stamp the version, commit and build date with
+//
+//	-ldflags "-X ddd/internal/buildinfo.version=v1.2.3 -X ddd/internal/buildinfo.commit=... -X ddd/internal/buildinfo.date=..."
+//
+// and other builds fall back to what the Go toolchain recorded.
+package buildinfo
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stamped by release builds
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// started is when the process started, near enough
+var started = time.Now()
+
+// Info describes the running binary
+type Info struct {
+	Version   string    `json:"version"`
+	Revision  string    `json:"revision,omitempty"`
+	Modified  bool      `json:"modified,omitempty"` // built from a dirty tree
+	BuildTime string    `json:"build_time,omitempty"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	CPUs      int       `json:"cpus"`
+	PID       int       `json:"pid"`
+	Started   time.Time `json:"started"`
+
+	// Builtin lists the optional subsystems compiled into the binary and
+	// Omitted those left out of it, because it was built without their
+	// tags or does not link them
+	Builtin []string `json:"builtin"`
+	Omitted []string `json:"omitted,omitempty"`
+
+	// Filled in by the server: the optional features its configuration
+	// enables and, when the update check found one, the newer release
+	Features      []string `json:"features,omitempty"`
+	LatestRelease string   `json:"latest_release,omitempty"`
+	ReleaseURL    string   `json:"release_url,omitempty"`
+}
+
+// Read returns the running binary's build information
+func Read() Info {
+	info := Info{
+		Version:   "(devel)",
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		PID:       os.Getpid(),
+		Started:   started,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Revision = s.Value
+			case "vcs.time":
+				info.BuildTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Revision = commit
+	}
+	if date != "" {
+		info.BuildTime = date
+	}
+	info.Builtin, info.Omitted = optionalFeatures()
+	return info
+}
+
+// Optional maps each subsystem a plain build leaves out of the binary to
+// the build tag that adds it
+var Optional = map[string]string{
+	"geoip":     "geoip",
+	"pcap":      "pcap",
+	"recursion": "recursion",
+	"script":    "script",
+}
+
+// registry holds the optional subsystems compiled in
+var registry struct {
+	sync.Mutex
+	builtin map[string]bool
+}
+
+// Register records that the optional subsystem name is compiled in. Each
+// calls it from an init function in the files its build tag adds.
+func Register(name string) {
+	if _, ok := Optional[name]; !ok {
+		panic("buildinfo: unknown optional subsystem " + name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.builtin == nil {
+		registry.builtin = make(map[string]bool)
+	}
+	registry.builtin[name] = true
+}
+
+// optionalFeatures returns the optional subsystems compiled in and those
+// left out, sorted
+func optionalFeatures() (builtin, omitted []string) {
+	registry.Lock()
+	defer registry.Unlock()
+	builtin = []string{}
+	for name := range Optional {
+		if registry.builtin[name] {
+			builtin = append(builtin, name)
+		} else {
+			omitted = append(omitted, name)
+		}
+	}
+	sort.Strings(builtin)
+	sort.Strings(omitted)
+	return builtin, omitted
+}
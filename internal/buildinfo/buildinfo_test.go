@@ -0,0 +1,27 @@
+package buildinfo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptionalFeatures(t *testing.T) {
+	// Nothing optional is linked into this test binary
+	if info := Read(); len(info.Builtin) != 0 || len(info.Omitted) != len(Optional) {
+		t.Fatalf("Expected every optional subsystem omitted, got %v and %v", info.Builtin, info.Omitted)
+	}
+
+	Register("script")
+	Register("geoip")
+	info := Read()
+	if !reflect.DeepEqual(info.Builtin, []string{"geoip", "script"}) || !reflect.DeepEqual(info.Omitted, []string{"pcap", "recursion"}) {
+		t.Errorf("Expected geoip and script built in, got %v and %v", info.Builtin, info.Omitted)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected an unknown subsystem to panic")
+		}
+	}()
+	Register("kafka")
+}
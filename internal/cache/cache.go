@@ -0,0 +1,371 @@
+package cache
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+// Key identifies a cached response
+type Key struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	// The DO and CD bits of the query, which change what the answer
+	// holds: DNSSEC records, and data that failed validation
+	DO, CD bool
+}
+
+// entryOverhead approximates the memory an entry costs beyond its wire
+// data and name: the map slot, the entry itself and its slice header
+const entryOverhead = 96
+
+var (
+	cacheHits = metrics.NewCounterVec("ddd_cache_hits_total",
+		"Response cache hits", "shard")
+	cacheMisses = metrics.NewCounterVec("ddd_cache_misses_total",
+		"Response cache misses", "shard")
+	cacheEvictions = metrics.NewCounterVec("ddd_cache_evictions_total",
+		"Responses removed from the cache because they expired or to stay within budget", "shard", "reason")
+	cacheBytes = metrics.NewGaugeVec("ddd_cache_bytes",
+		"Memory held by cached responses", "shard")
+)
+
+// entry holds a cached response in wire format, so that its size is known
+// exactly, and when it was stored
+type entry struct {
+	wire    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// size is what the entry counts against the memory budget
+func (e *entry) size(key Key) int64 {
+	return int64(len(e.wire) + len(key.Name) + entryOverhead)
+}
+
+// shard is one lock's worth of the cache
+type shard struct {
+	name string // metric label
+
+	mu      sync.Mutex
+	entries map[Key]*entry
+	bytes   int64
+}
+
+// Cache is a TTL-aware DNS response cache. Entries are spread across
+// shards by key hash so lookups for different names rarely share a lock.
+// The entry and memory budgets apply to the cache as a whole.
+type Cache struct {
+	shards     []*shard
+	maxEntries int
+	maxBytes   int64
+	maxTTL     time.Duration
+	popular    func(name string) bool // names to keep over others, optional
+
+	entries atomic.Int64
+	bytes   atomic.Int64
+}
+
+// New creates a response cache holding at most maxEntries responses
+func New(maxEntries int, maxTTL time.Duration) *Cache {
+	return NewSharded(maxEntries, 0, 1, maxTTL)
+}
+
+// NewSharded creates a response cache split into shards that holds at most
+// maxEntries responses using at most maxBytes of memory. A zero limit is
+// no limit.
+func NewSharded(maxEntries int, maxBytes int64, shards int, maxTTL time.Duration) *Cache {
+	if shards < 1 {
+		shards = 1
+	}
+	c := &Cache{
+		shards:     make([]*shard, shards),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		maxTTL:     maxTTL,
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard{name: strconv.Itoa(i), entries: make(map[Key]*entry)}
+	}
+	return c
+}
+
+// WithPopularity makes eviction prefer names for which popular is false
+func (c *Cache) WithPopularity(popular func(name string) bool) *Cache {
+	c.popular = popular
+	return c
+}
+
+// KeyFor returns the cache key for a query, or for a response, which
+// carries over the DO and CD bits of its query. m must have a question.
+func KeyFor(m *dns.Msg) Key {
+	q := m.Question[0]
+	opt := m.IsEdns0()
+	return Key{
+		Name:  strings.ToLower(q.Name),
+		Type:  q.Qtype,
+		Class: q.Qclass,
+		DO:    opt != nil && opt.Do(),
+		CD:    m.CheckingDisabled,
+	}
+}
+
+// shardFor returns the index of the shard holding key
+func (c *Cache) shardFor(key Key) int {
+	if len(c.shards) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key.Name))
+	h.Write([]byte{byte(key.Type >> 8), byte(key.Type)})
+	return int(h.Sum32() % uint32(len(c.shards)))
+}
+
+// Get returns a copy of the cached response to query r with TTLs reduced
+// by the time spent in the cache, or nil on a miss
+func (c *Cache) Get(r *dns.Msg) *dns.Msg {
+	if c == nil || len(r.Question) == 0 {
+		return nil
+	}
+
+	key := KeyFor(r)
+	s := c.shards[c.shardFor(key)]
+	now := time.Now()
+
+	s.mu.Lock()
+	e, exists := s.entries[key]
+	if exists && !now.Before(e.expires) {
+		c.removeLocked(s, key, e, "expired")
+		exists = false
+	}
+	s.mu.Unlock()
+
+	if !exists {
+		cacheMisses.With(s.name).Inc()
+		return nil
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(e.wire); err != nil {
+		cacheMisses.With(s.name).Inc()
+		return nil
+	}
+	cacheHits.With(s.name).Inc()
+	age(msg, uint32(now.Sub(e.stored)/time.Second))
+	return msg
+}
+
+// Has reports whether a fresh response to query r is cached, without
+// counting a lookup
+func (c *Cache) Has(r *dns.Msg) bool {
+	if c == nil || len(r.Question) == 0 {
+		return false
+	}
+
+	key := KeyFor(r)
+	s := c.shards[c.shardFor(key)]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.entries[key]
+	return exists && time.Now().Before(e.expires)
+}
+
+// Set caches resp if it is cacheable
+func (c *Cache) Set(resp *dns.Msg) {
+	if c == nil || len(resp.Question) == 0 {
+		return
+	}
+
+	ttl, ok := cacheTTL(resp)
+	if !ok {
+		return
+	}
+	if c.maxTTL > 0 && ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+
+	msg := resp.Copy()
+	msg.Compress = true
+	wire, err := msg.Pack()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	c.store(KeyFor(resp), &entry{
+		wire:    wire,
+		stored:  now,
+		expires: now.Add(ttl),
+	})
+}
+
+// store inserts an entry, then evicts others until the cache is back
+// within budget
+func (c *Cache) store(key Key, e *entry) {
+	i := c.shardFor(key)
+	s := c.shards[i]
+
+	s.mu.Lock()
+	if old, exists := s.entries[key]; exists {
+		c.removeLocked(s, key, old, "")
+	}
+	s.entries[key] = e
+	s.bytes += e.size(key)
+	c.entries.Add(1)
+	c.bytes.Add(e.size(key))
+	cacheBytes.With(s.name).Set(float64(s.bytes))
+	s.mu.Unlock()
+
+	c.enforce(i, key)
+}
+
+// overBudget reports whether the cache holds more than it may
+func (c *Cache) overBudget() bool {
+	return (c.maxEntries > 0 && c.entries.Load() > int64(c.maxEntries)) ||
+		(c.maxBytes > 0 && c.bytes.Load() > c.maxBytes)
+}
+
+// enforce evicts entries other than keep until the cache is within
+// budget, starting with the shard keep was stored in and moving on to the
+// next when a shard has nothing left to give. One shard is locked at a
+// time.
+func (c *Cache) enforce(start int, keep Key) {
+	now := time.Now()
+	for i := 0; i < len(c.shards) && c.overBudget(); {
+		s := c.shards[(start+i)%len(c.shards)]
+		s.mu.Lock()
+		evicted := c.evictLocked(s, keep, now)
+		s.mu.Unlock()
+		if !evicted {
+			i++
+		}
+	}
+}
+
+// evictLocked removes an expired entry of s if one is found quickly,
+// otherwise one for an unpopular name, otherwise an arbitrary one other
+// than keep
+func (c *Cache) evictLocked(s *shard, keep Key, now time.Time) bool {
+	var victim Key
+	var victimEntry *entry
+	var victimPopular bool
+	scanned := 0
+
+	for key, e := range s.entries {
+		if key == keep {
+			continue
+		}
+		if !now.Before(e.expires) {
+			victim, victimEntry = key, e
+			break
+		}
+		popular := c.popular != nil && c.popular(key.Name)
+		if victimEntry == nil || (victimPopular && !popular) {
+			victim, victimEntry, victimPopular = key, e, popular
+		}
+		if scanned++; scanned >= 16 {
+			break
+		}
+	}
+
+	if victimEntry == nil {
+		return false
+	}
+	reason := "budget"
+	if !now.Before(victimEntry.expires) {
+		reason = "expired"
+	}
+	c.removeLocked(s, victim, victimEntry, reason)
+	return true
+}
+
+// removeLocked deletes key from s and counts the eviction under reason,
+// if any
+func (c *Cache) removeLocked(s *shard, key Key, e *entry, reason string) {
+	delete(s.entries, key)
+	s.bytes -= e.size(key)
+	c.entries.Add(-1)
+	c.bytes.Add(-e.size(key))
+	cacheBytes.With(s.name).Set(float64(s.bytes))
+	if reason != "" {
+		cacheEvictions.With(s.name, reason).Inc()
+	}
+}
+
+// Len returns the number of cached responses
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	return int(c.entries.Load())
+}
+
+// Bytes returns the memory counted against the budget
+func (c *Cache) Bytes() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.bytes.Load()
+}
+
+// cacheTTL returns how long resp may be cached. Positive answers use the
+// smallest record TTL; negative answers use the SOA minimum (RFC 2308).
+// Server failures and truncated responses are not cached.
+func cacheTTL(resp *dns.Msg) (time.Duration, bool) {
+	if resp.Truncated {
+		return 0, false
+	}
+
+	var rrs []dns.RR
+	switch {
+	case resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		rrs = resp.Answer
+	case resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError:
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := soa.Hdr.Ttl
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				return time.Duration(ttl) * time.Second, ttl > 0
+			}
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+
+	minTTL := ^uint32(0)
+	for _, rr := range rrs {
+		if rr.Header().Ttl < minTTL {
+			minTTL = rr.Header().Ttl
+		}
+	}
+	return time.Duration(minTTL) * time.Second, minTTL > 0
+}
+
+// age reduces every TTL in msg by secs seconds
+func age(msg *dns.Msg, secs uint32) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if hdr.Ttl > secs {
+				hdr.Ttl -= secs
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+}
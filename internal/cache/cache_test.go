@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func answer(t *testing.T, name string, ttl uint32) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeA)
+	rr, err := dns.NewRR(name + " 300 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr.Header().Ttl = ttl
+	msg.Answer = []dns.RR{rr}
+	return msg
+}
+
+func query(name string, qtype uint16) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	return msg
+}
+
+func TestGetSet(t *testing.T) {
+	c := New(10, time.Hour)
+	resp := answer(t, "example.com.", 300)
+	c.Set(resp)
+
+	got := c.Get(query("EXAMPLE.com.", dns.TypeA))
+	if got == nil {
+		t.Fatal("Expected cache hit for case-insensitive name")
+	}
+	if got.Answer[0].Header().Ttl > 300 {
+		t.Errorf("Expected TTL <= 300, got %d", got.Answer[0].Header().Ttl)
+	}
+
+	if c.Get(query("example.com.", dns.TypeAAAA)) != nil {
+		t.Error("Expected miss for a different qtype")
+	}
+}
+
+func TestDNSSECFlagsKeptApart(t *testing.T) {
+	c := New(10, time.Hour)
+	resp := answer(t, "example.com.", 300)
+	resp.SetEdns0(1232, true)
+	c.Set(resp)
+
+	do := query("example.com.", dns.TypeA)
+	do.SetEdns0(4096, true)
+	if c.Get(do) == nil {
+		t.Error("Expected a hit for a DO query of any buffer size")
+	}
+	if c.Get(query("example.com.", dns.TypeA)) != nil {
+		t.Error("Expected a miss for a query without DO")
+	}
+	do.CheckingDisabled = true
+	if c.Get(do) != nil {
+		t.Error("Expected a miss for a query with CD")
+	}
+}
+
+func TestHTTPSRecordsCached(t *testing.T) {
+	c := New(10, time.Hour)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeHTTPS)
+	rr, err := dns.NewRR(`example.com. 300 IN HTTPS 1 . alpn="h3,h2" ipv4hint="192.0.2.1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Answer = []dns.RR{rr}
+	c.Set(msg)
+
+	got := c.Get(query("example.com.", dns.TypeHTTPS))
+	if got == nil || len(got.Answer) != 1 {
+		t.Fatal("Expected cache hit for HTTPS query")
+	}
+	if _, ok := got.Answer[0].(*dns.HTTPS); !ok {
+		t.Errorf("Expected HTTPS record, got %T", got.Answer[0])
+	}
+	if c.Get(query("example.com.", dns.TypeSVCB)) != nil {
+		t.Error("Expected HTTPS and SVCB to be cached separately")
+	}
+}
+
+func TestServerFailureNotCached(t *testing.T) {
+	c := New(10, time.Hour)
+	resp := answer(t, "example.com.", 300)
+	resp.Rcode = dns.RcodeServerFailure
+	c.Set(resp)
+
+	if c.Len() != 0 {
+		t.Error("Expected SERVFAIL not to be cached")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c := New(2, time.Hour)
+	c.Set(answer(t, "a.example.com.", 300))
+	c.Set(answer(t, "b.example.com.", 300))
+	c.Set(answer(t, "c.example.com.", 300))
+
+	if c.Len() != 2 {
+		t.Errorf("Expected cache to stay at 2 entries, got %d", c.Len())
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	c := New(10, time.Hour)
+	c.Set(answer(t, "a.example.com.", 300))
+	c.Set(answer(t, "b.example.com.", 300))
+
+	saved, err := c.Save(path)
+	if err != nil || saved != 2 {
+		t.Fatalf("Expected 2 entries saved, got %d (%v)", saved, err)
+	}
+
+	restored := New(10, time.Hour)
+	loaded, err := restored.Load(path)
+	if err != nil || loaded != 2 {
+		t.Fatalf("Expected 2 entries loaded, got %d (%v)", loaded, err)
+	}
+
+	if restored.Get(query("a.example.com.", dns.TypeA)) == nil {
+		t.Error("Expected restored entry to be served")
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	probe := New(0, time.Hour)
+	probe.Set(answer(t, "a.example.com.", 300))
+	size := probe.Bytes()
+	if size <= entryOverhead {
+		t.Fatalf("Expected an entry to cost more than its overhead, got %d", size)
+	}
+
+	c := NewSharded(0, 3*size, 4, time.Hour)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		c.Set(answer(t, name+".example.com.", 300))
+	}
+	if c.Len() != 3 || c.Bytes() != 3*size {
+		t.Errorf("Expected 3 entries in %d bytes, got %d in %d", 3*size, c.Len(), c.Bytes())
+	}
+	if c.Get(query("e.example.com.", dns.TypeA)) == nil {
+		t.Error("Expected the newest entry to be kept")
+	}
+
+	// Replacing an entry does not count it twice
+	c.Set(answer(t, "e.example.com.", 600))
+	if c.Len() != 3 || c.Bytes() != 3*size {
+		t.Errorf("Expected replacement to keep 3 entries in %d bytes, got %d in %d", 3*size, c.Len(), c.Bytes())
+	}
+}
+
+func TestEvictionKeepsPopularNames(t *testing.T) {
+	c := New(2, time.Hour).WithPopularity(func(name string) bool {
+		return name == "popular.example.com."
+	})
+	c.Set(answer(t, "popular.example.com.", 300))
+	c.Set(answer(t, "b.example.com.", 300))
+	c.Set(answer(t, "c.example.com.", 300))
+
+	if c.Get(query("popular.example.com.", dns.TypeA)) == nil {
+		t.Error("Expected the popular name to be kept")
+	}
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// snapshotEntry is the on-disk form of a cached response
+type snapshotEntry struct {
+	Msg     []byte    `json:"msg"` // wire format
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+// Save writes all unexpired entries to path. The file is written to a
+// temporary name and renamed so a crash never leaves a partial snapshot.
+func (c *Cache) Save(path string) (int, error) {
+	now := time.Now()
+
+	entries := make([]snapshotEntry, 0, c.Len())
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if now.Before(e.expires) {
+				entries = append(entries, snapshotEntry{Msg: e.wire, Stored: e.stored, Expires: e.expires})
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-snapshot-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return len(entries), os.Rename(tmp.Name(), path)
+}
+
+// Load restores entries saved by Save. Entries keep their original expiry
+// so TTLs account for the time the server was down; anything that expired
+// in the meantime is skipped. A missing file is not an error.
+func (c *Cache) Load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var entries []snapshotEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	loaded := 0
+	for _, se := range entries {
+		if !now.Before(se.Expires) {
+			continue
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(se.Msg); err != nil || len(msg.Question) == 0 {
+			continue
+		}
+
+		c.store(KeyFor(msg), &entry{wire: se.Msg, stored: se.Stored, expires: se.Expires})
+		loaded++
+	}
+
+	return loaded, nil
+}
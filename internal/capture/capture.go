@@ -0,0 +1,203 @@
+//go:build pcap
+
+// Package capture records bounded packet captures of offending sources
+// for offline analysis. A capture starts when a source is blocked and
+// covers its datagrams to the DNS listener for a fixed time. It is built
+// in only with the pcap tag.
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/buildinfo"
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	capturesStarted = metrics.NewCounter("ddd_captures_started_total",
+		"Packet captures started for blocked sources")
+	capturesSkipped = metrics.NewCounter("ddd_captures_skipped_total",
+		"Packet captures not started because the concurrency limit was reached")
+	capturedPackets = metrics.NewCounter("ddd_captured_packets_total",
+		"Datagrams written to packet captures")
+)
+
+// Available reports whether packet capture is built in
+const Available = true
+
+func init() {
+	buildinfo.Register("pcap")
+}
+
+// session is one capture in progress
+type session struct {
+	mu      sync.Mutex
+	ip      string
+	reason  string
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	packets int
+	closed  bool
+	timer   *time.Timer
+}
+
+// Recorder runs packet captures for blocked sources
+type Recorder struct {
+	cfg config.CaptureConfig
+	bus *events.Bus
+	log *logger.Logger
+
+	active   atomic.Int64
+	sessions sync.Map // ip -> *session
+}
+
+// New creates a recorder writing captures to cfg.Dir
+func New(cfg config.CaptureConfig, bus *events.Bus, log *logger.Logger) *Recorder {
+	return &Recorder{cfg: cfg, bus: bus, log: log}
+}
+
+// Handle starts a capture for each newly blocked address. Prefix blocks
+// are not captured.
+func (r *Recorder) Handle(e events.Event) {
+	if e.Type != events.IPBlocked || strings.Contains(e.IP, "/") {
+		return
+	}
+	if _, err := r.Start(e.IP, e.Reason); err != nil {
+		r.log.Warnw("Failed to start packet capture", "ip", e.IP, "error", err)
+	}
+}
+
+// Start begins capturing datagrams from ip and returns the capture file.
+// It does nothing when ip is already being captured or the concurrency
+// limit is reached.
+func (r *Recorder) Start(ip, reason string) (string, error) {
+	if _, exists := r.sessions.Load(ip); exists {
+		return "", nil
+	}
+	if r.cfg.MaxConcurrent > 0 && r.active.Load() >= int64(r.cfg.MaxConcurrent) {
+		capturesSkipped.Inc()
+		return "", nil
+	}
+
+	if err := os.MkdirAll(r.cfg.Dir, 0o750); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s.pcap", time.Now().UTC().Format("20060102T150405Z"), strings.ReplaceAll(ip, ":", "_"))
+	path := filepath.Join(r.cfg.Dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return "", err
+	}
+
+	s := &session{ip: ip, reason: reason, path: path, file: file, w: bufio.NewWriter(file)}
+	if err := writeFileHeader(s.w); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+	s.timer = time.AfterFunc(r.cfg.Duration, func() { r.finish(s) })
+	if _, loaded := r.sessions.LoadOrStore(ip, s); loaded {
+		s.timer.Stop()
+		file.Close()
+		os.Remove(path)
+		return "", nil
+	}
+
+	r.active.Add(1)
+	capturesStarted.Inc()
+	return path, nil
+}
+
+// Packet records a datagram received from src on the listener at dst if
+// src is being captured. It is cheap when nothing is being captured and
+// safe to call on a nil recorder.
+func (r *Recorder) Packet(src, dst net.Addr, payload []byte) {
+	if r == nil || r.active.Load() == 0 {
+		return
+	}
+	from, ok := src.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	v, exists := r.sessions.Load(from.IP.String())
+	if !exists {
+		return
+	}
+	to, _ := dst.(*net.UDPAddr)
+	if to == nil {
+		to = &net.UDPAddr{}
+	}
+
+	s := v.(*session)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	err := writePacket(s.w, time.Now(), from, to, payload)
+	s.packets++
+	full := r.cfg.MaxPackets > 0 && s.packets >= r.cfg.MaxPackets
+	s.mu.Unlock()
+	capturedPackets.Inc()
+
+	if err != nil {
+		r.log.Warnw("Packet capture write failed", "ip", s.ip, "file", s.path, "error", err)
+		r.finish(s)
+	} else if full {
+		r.finish(s)
+	}
+}
+
+// Close finishes all captures in progress
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+	r.sessions.Range(func(_, v interface{}) bool {
+		r.finish(v.(*session))
+		return true
+	})
+}
+
+// finish closes a capture and publishes where it was written
+func (r *Recorder) finish(s *session) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.timer.Stop()
+	err := s.w.Flush()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	packets := s.packets
+	s.mu.Unlock()
+
+	if r.sessions.CompareAndDelete(s.ip, s) {
+		r.active.Add(-1)
+	}
+
+	if err != nil {
+		r.log.Warnw("Packet capture write failed", "ip", s.ip, "file", s.path, "error", err)
+	}
+	r.bus.Publish(events.Event{
+		Type:   events.PacketCaptured,
+		IP:     s.ip,
+		Reason: fmt.Sprintf("%d packets after block: %s", packets, s.reason),
+		File:   s.path,
+	})
+}
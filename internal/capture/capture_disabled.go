@@ -0,0 +1,40 @@
+//go:build !pcap
+
+package capture
+
+import (
+	"errors"
+	"net"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+)
+
+// Available reports whether packet capture is built in
+const Available = false
+
+// ErrDisabled means the binary was built without packet capture
+var ErrDisabled = errors.New("capture: not built in (build with the pcap tag)")
+
+// Recorder stands in for the recorder left out of this build
+type Recorder struct{}
+
+// New returns nil; packet capture is not built in
+func New(cfg config.CaptureConfig, bus *events.Bus, log *logger.Logger) *Recorder {
+	return nil
+}
+
+// Handle does nothing
+func (r *Recorder) Handle(e events.Event) {}
+
+// Start always fails; packet capture is not built in
+func (r *Recorder) Start(ip, reason string) (string, error) {
+	return "", ErrDisabled
+}
+
+// Packet does nothing
+func (r *Recorder) Packet(src, dst net.Addr, payload []byte) {}
+
+// Close does nothing
+func (r *Recorder) Close() {}
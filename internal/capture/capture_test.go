@@ -0,0 +1,82 @@
+//go:build pcap
+
+package capture
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+)
+
+func TestCaptureBlockedSource(t *testing.T) {
+	bus := events.NewBus()
+	captured := bus.Subscribe("test", 8)
+	r := New(config.CaptureConfig{Dir: t.TempDir(), Duration: time.Minute, MaxPackets: 2}, bus, logger.NewNop())
+
+	r.Handle(events.Event{Type: events.IPBlocked, IP: "192.0.2.1", Reason: "query_burst"})
+	r.Handle(events.Event{Type: events.IPBlocked, IP: "198.51.100.0/24"})
+
+	listener := &net.UDPAddr{IP: net.ParseIP("203.0.113.53"), Port: 53}
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1}
+	r.Packet(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}, listener, query)
+	r.Packet(&net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 40000}, listener, query)
+	r.Packet(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40001}, listener, query) // reaches MaxPackets
+
+	var e events.Event
+	for e = range captured {
+		if e.Type == events.PacketCaptured {
+			break
+		}
+	}
+	if e.IP != "192.0.2.1" || e.File == "" {
+		t.Fatalf("Expected a capture of 192.0.2.1, got %+v", e)
+	}
+	if r.active.Load() != 0 {
+		t.Error("Expected the capture to end at MaxPackets")
+	}
+
+	data, err := os.ReadFile(e.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetLen := 20 + 8 + len(query)
+	if want := 24 + 2*(16+packetLen); len(data) != want {
+		t.Fatalf("Expected %d bytes for two packets, got %d", want, len(data))
+	}
+	if binary.LittleEndian.Uint32(data[20:]) != linkTypeRaw {
+		t.Error("Expected raw IP link type")
+	}
+
+	ip := data[24+16 : 24+16+20]
+	if checksum(nil, ip) != 0xffff && checksum(nil, ip) != 0 {
+		t.Error("Expected a valid IPv4 header checksum")
+	}
+	if !net.IP(ip[12:16]).Equal(net.ParseIP("192.0.2.1")) || !net.IP(ip[16:20]).Equal(listener.IP) {
+		t.Errorf("Expected 192.0.2.1 -> %s, got %v -> %v", listener.IP, net.IP(ip[12:16]), net.IP(ip[16:20]))
+	}
+}
+
+func TestUDPPacketIPv6Checksum(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}
+	dst := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+	packet := udpPacket(src, dst, []byte("odd length payload!"))
+
+	udp := packet[40:]
+	// Summing the pseudo header and the datagram, checksum included, gives
+	// all ones for a valid checksum
+	if sum := ^checksum(pseudoHeader(src.IP.To16(), dst.IP.To16(), len(udp)), udp); sum != 0 {
+		t.Errorf("Invalid UDP checksum %#04x", binary.BigEndian.Uint16(udp[6:]))
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Packet(&net.UDPAddr{}, &net.UDPAddr{}, nil)
+	r.Close()
+}
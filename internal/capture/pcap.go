@@ -0,0 +1,125 @@
+//go:build pcap
+
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// linkTypeRaw is the pcap link type for packets that start with an IP
+// header
+const linkTypeRaw = 101
+
+// snapLen is the largest packet recorded in full
+const snapLen = 65535
+
+// writeFileHeader writes the pcap global header
+func writeFileHeader(w io.Writer) error {
+	var h [24]byte
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b2c3d4) // microsecond timestamps
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], snapLen)
+	binary.LittleEndian.PutUint32(h[20:], linkTypeRaw)
+	_, err := w.Write(h[:])
+	return err
+}
+
+// writePacket writes payload as a UDP datagram from src to dst. The
+// server only sees the payload, so the IP and UDP headers are rebuilt.
+func writePacket(w io.Writer, ts time.Time, src, dst *net.UDPAddr, payload []byte) error {
+	packet := udpPacket(src, dst, payload)
+	incl := len(packet)
+	if incl > snapLen {
+		incl = snapLen
+	}
+
+	var h [16]byte
+	binary.LittleEndian.PutUint32(h[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(h[8:], uint32(incl))
+	binary.LittleEndian.PutUint32(h[12:], uint32(len(packet)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(packet[:incl])
+	return err
+}
+
+// udpPacket builds an IPv4 or IPv6 packet carrying payload. An unspecified
+// or mismatched destination (e.g. a dual-stack wildcard listener) is
+// written as the unspecified address of the source's family.
+func udpPacket(src, dst *net.UDPAddr, payload []byte) []byte {
+	udpLen := 8 + len(payload)
+	udp := make([]byte, udpLen)
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[8:], payload)
+
+	if src4 := src.IP.To4(); src4 != nil {
+		dst4 := dst.IP.To4()
+		if dst4 == nil {
+			dst4 = net.IPv4zero.To4()
+		}
+		binary.BigEndian.PutUint16(udp[6:], checksum(pseudoHeader(src4, dst4, udpLen), udp))
+
+		ip := make([]byte, 20, 20+udpLen)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+udpLen))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64
+		ip[9] = 17
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(nil, ip))
+		return append(ip, udp...)
+	}
+
+	src16 := src.IP.To16()
+	dst16 := dst.IP.To16()
+	if dst16 == nil || dst.IP.To4() != nil {
+		dst16 = net.IPv6unspecified
+	}
+	binary.BigEndian.PutUint16(udp[6:], checksum(pseudoHeader(src16, dst16, udpLen), udp))
+
+	ip := make([]byte, 40, 40+udpLen)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+	ip[6] = 17
+	ip[7] = 64
+	copy(ip[8:], src16)
+	copy(ip[24:], dst16)
+	return append(ip, udp...)
+}
+
+// pseudoHeader returns the IP pseudo header covered by the UDP checksum
+func pseudoHeader(src, dst net.IP, udpLen int) []byte {
+	h := append(append([]byte{}, src...), dst...)
+	return append(h, 0, 17, byte(udpLen>>8), byte(udpLen))
+}
+
+// checksum computes the Internet checksum over prefix followed by data
+func checksum(prefix, data []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(prefix) // always even length
+	add(data)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	if c := ^uint16(sum); c != 0 {
+		return c
+	}
+	return 0xffff
+}
@@ -0,0 +1,1633 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+
+	"ddd/internal/detector"
+	"ddd/internal/severity"
+)
+
+// Config holds the complete server configuration
+type Config struct {
+	// Version is the schema version of the file (see CurrentVersion)
+	Version int `yaml:"version"`
+
+	// Include lists config files that are loaded before this one. Values in
+	// the including file override values from its includes, so a site file
+	// can include a shared base profile and only set what differs.
+	Include []string `yaml:"include,omitempty"`
+
+	// Profile picks the defaults for what the proxy fronts: a resolver
+	// (the default) or an authoritative server (see ProfileDefaults)
+	Profile string `yaml:"profile"`
+
+	Server     ServerConfig     `yaml:"server"`
+	Log        LogConfig        `yaml:"log"`
+	Detection  DetectionConfig  `yaml:"detection"`
+	Blocking   BlockingConfig   `yaml:"blocking"`
+	API        APIConfig        `yaml:"api"`
+	Public     PublicConfig     `yaml:"public"`
+	Federation FederationConfig `yaml:"federation"`
+	Policy     PolicyConfig     `yaml:"policy"`
+	Notify     NotifyConfig     `yaml:"notify"`
+	SLO        SLOConfig        `yaml:"slo"`
+	Adaptive   AdaptiveConfig   `yaml:"adaptive"`
+	Watch      WatchConfig      `yaml:"watch"`
+	GeoIP      GeoIPConfig      `yaml:"geoip"`
+	Capture    CaptureConfig    `yaml:"capture"`
+	Dataset    DatasetConfig    `yaml:"dataset"`
+	Mirror     MirrorConfig     `yaml:"mirror"`
+	Journal    JournalConfig    `yaml:"journal"`
+	Reports    ReportsConfig    `yaml:"reports"`
+	Exemptions ExemptionsConfig `yaml:"exemptions"`
+	Grants     GrantsConfig     `yaml:"grants"`
+	DataFiles  DataFilesConfig  `yaml:"data_files"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Sandbox    SandboxConfig    `yaml:"sandbox"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Popularity PopularityConfig `yaml:"popularity"`
+	Cleanup    CleanupConfig    `yaml:"cleanup"`
+	Monitor    MonitorConfig    `yaml:"monitor"`
+	Archive    ArchiveConfig    `yaml:"archive"`
+	Integrity  IntegrityConfig  `yaml:"integrity"`
+	Chaos      ChaosConfig      `yaml:"chaos"`
+	Privacy    PrivacyConfig    `yaml:"privacy"`
+	Mobility   MobilityConfig   `yaml:"mobility"`
+	DualStack  DualStackConfig  `yaml:"dual_stack"`
+	Panic      PanicConfig      `yaml:"panic"`
+	Emergency  EmergencyConfig  `yaml:"emergency"`
+	Script     ScriptConfig     `yaml:"script"`
+	Update     UpdateConfig     `yaml:"update"`
+	Rewrite    []RewriteRule    `yaml:"rewrite"`
+	Firewall   []string         `yaml:"firewall"` // rules evaluated per query, first match wins
+	Critical   []CriticalQuery  `yaml:"critical"`
+	// Groups give sets of clients their own detection thresholds and
+	// mitigation policy
+	Groups []ClientGroup `yaml:"groups"`
+	// Authoritative holds the zone budgets and response minimization of
+	// the authoritative profile
+	Authoritative AuthoritativeConfig `yaml:"authoritative"`
+}
+
+// ServerConfig holds DNS listener settings
+type ServerConfig struct {
+	Port          int           `yaml:"port"`
+	Upstream      string        `yaml:"upstream"`
+	ReadBuffer    int           `yaml:"rcvbuf"`
+	MaxInFlight   int           `yaml:"max_inflight"`
+	UDPBatch      int           `yaml:"udp_batch"`
+	StatsInterval time.Duration `yaml:"stats_interval"`
+	CNAMEFlatten  bool          `yaml:"cname_flatten"`
+	MaxCNAMEChain int           `yaml:"max_cname_chain"`
+	Transparent   bool          `yaml:"transparent"` // TPROXY interception (Linux, CAP_NET_ADMIN)
+	// DuplicateWindow answers client retransmissions of an identical query
+	// from the original resolution; 0 disables it
+	DuplicateWindow time.Duration `yaml:"duplicate_window"`
+	// PairQTypes fetches the other address type along with the A or AAAA
+	// query of a client that asks for both, so its second query is
+	// answered from the first's upstream exchange
+	PairQTypes bool `yaml:"pair_qtypes"`
+	// TCP serves DNS over TCP on the same port. Connections from
+	// TrustedProxies (CIDRs) to it and to the TCP, DoT and DoH listeners
+	// must start with a PROXY v2 header naming the real client.
+	TCP            bool     `yaml:"tcp"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// PaddingBlockSize pads responses (RFC 7830) to clients whose proxy
+	// reports a TLS connection and who padded their query, to a multiple
+	// of this many bytes; 0 disables padding
+	PaddingBlockSize int `yaml:"padding_block_size"`
+	// InstanceID identifies this node in logs, metrics and events, e.g.
+	// within an anycast fleet; empty uses the hostname
+	InstanceID string `yaml:"instance_id"`
+	// MaxEDNSOptions rejects queries carrying more EDNS options as
+	// protocol abuse (0 means unlimited)
+	MaxEDNSOptions int `yaml:"max_edns_options"`
+	// MaxRRSet drops upstream responses holding an RRset of more records
+	// as malformed (0 means unlimited)
+	MaxRRSet int `yaml:"max_rrset"`
+	// TCPAbuse limits abuse of the TCP listener, which the query-rate
+	// detection built for UDP does not see
+	TCPAbuse TCPAbuseConfig `yaml:"tcp_abuse"`
+	// Shed is how queries beyond MaxInFlight are answered
+	Shed ShedConfig `yaml:"shed"`
+	// LocalZone is the authority of the negative answers the server makes
+	// up itself
+	LocalZone LocalZoneConfig `yaml:"local_zone"`
+	// Listeners serves DNS on further addresses and transports: DNS over
+	// TLS and DNS over HTTPS, or UDP and TCP on other ports
+	Listeners []ListenerConfig `yaml:"listeners"`
+}
+
+// ShedConfig holds the response to queries shed under load. Response is
+// refused, servfail or drop; error responses carry an extended DNS error
+// asking the client to retry after RetryAfter, at most ErrorBudget per
+// second (0 means unlimited) with the rest dropped. Popular names
+// answered from cache while shedding get TTLs of at least RetryAfter.
+type ShedConfig struct {
+	Response    string        `yaml:"response"`
+	RetryAfter  time.Duration `yaml:"retry_after"`
+	ErrorBudget int           `yaml:"error_budget"`
+}
+
+// LocalZoneConfig is the SOA put in the authority section of NXDOMAIN
+// answers the server synthesizes (firewall rules and policy scripts), so
+// resolvers downstream cache them for NegativeTTL (RFC 2308) rather than
+// asking again for every query. A NegativeTTL of 0 sends no SOA.
+type LocalZoneConfig struct {
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	MName       string        `yaml:"mname"` // primary name server in the SOA
+	RName       string        `yaml:"rname"` // responsible mailbox, as a name
+}
+
+// Shed responses
+const (
+	ShedRefused  = "refused"
+	ShedServfail = "servfail"
+	ShedDrop     = "drop"
+)
+
+// TCPAbuseConfig holds per-client TCP limits. A client over any of them
+// has its connection closed and new TCP connections refused for
+// RefuseFor; 0 disables a limit.
+type TCPAbuseConfig struct {
+	MaxConnections    int           `yaml:"max_connections"`      // open at once
+	ConnectionRate    int           `yaml:"connection_rate"`      // new connections per minute
+	MaxQueriesPerConn int           `yaml:"max_queries_per_conn"` // before the connection is closed (0 means 128)
+	PipelineRate      int           `yaml:"pipeline_rate"`        // queries per second on one connection
+	RefuseFor         time.Duration `yaml:"refuse_for"`
+}
+
+// LogConfig holds logging settings
+type LogConfig struct {
+	File string `yaml:"file"`
+
+	// ResolveHostnames adds client PTR names to detection and mitigation
+	// entries. Lookups go to the upstream in the background, at most
+	// HostnameLookupRate per second, and are cached for HostnameTTL.
+	ResolveHostnames   bool          `yaml:"resolve_hostnames"`
+	HostnameTTL        time.Duration `yaml:"hostname_ttl"`
+	HostnameLookupRate int           `yaml:"hostname_lookup_rate"`
+
+	// Mode "summary" replaces the entry logged per query with one line
+	// per client every SummaryInterval (query count, unique domains and
+	// verdicts), for constrained disks; "full" logs every query
+	Mode            string        `yaml:"mode"`
+	SummaryInterval time.Duration `yaml:"summary_interval"`
+}
+
+// Log modes
+const (
+	LogModeFull    = "full"
+	LogModeSummary = "summary"
+)
+
+// DetectionConfig holds detector thresholds
+type DetectionConfig struct {
+	// Sensitivity (low, medium, high, paranoid) scales every threshold
+	// below and the built-in pattern thresholds together
+	Sensitivity string `yaml:"sensitivity"`
+
+	RateLimit int           `yaml:"rate_limit"` // requests per IP per minute
+	Window    time.Duration `yaml:"window"`     // analysis window for all checks
+
+	// Stricter limit for clients first seen within NewClientWindow
+	NewClientWindow time.Duration `yaml:"new_client_window"`
+	NewClientLimit  int           `yaml:"new_client_limit"`
+
+	// Machine-gun timing check; timing_min_samples 0 disables it
+	TimingMinSamples         int     `yaml:"timing_min_samples"`
+	TimingMaxCV              float64 `yaml:"timing_max_cv"`
+	TimingMinAutocorrelation float64 `yaml:"timing_min_autocorrelation"`
+
+	// Names no client had queried before, per minute: per client (limit)
+	// and across all clients (alert only). 0 disables either.
+	NewDomainRate       int `yaml:"new_domain_rate"`
+	GlobalNewDomainRate int `yaml:"global_new_domain_rate"`
+
+	// Malformed or abusive messages per window before a client is
+	// blocked; fewer are rate limited. 0 never blocks.
+	ProtocolAbuseLimit int `yaml:"protocol_abuse_limit"`
+
+	// Distinct confusable or mixed-script IDN names a client may query
+	// per window, e.g. xn--80ak6aa92e.com (Cyrillic "apple"). 0 disables.
+	HomographLimit int `yaml:"homograph_limit"`
+
+	// Requests taken off a client's rate budget per SERVFAIL or REFUSED
+	// its queries caused upstream, down to failure_floor of the budget.
+	// 0 disables the penalty.
+	FailurePenalty float64 `yaml:"failure_penalty"`
+	FailureFloor   float64 `yaml:"failure_floor"`
+
+	// Cost makes the rate budget count what answering a client costs
+	// rather than how many queries it sends
+	Cost CostConfig `yaml:"cost"`
+
+	// Benign high-rate traffic the repeated query and timing checks
+	// discount
+	Noise NoiseConfig `yaml:"noise"`
+
+	// CheckBudget is how long each pattern check may take for one client
+	// before it is abandoned and the remaining checks run without it;
+	// 0, the default, disables the watchdog
+	CheckBudget time.Duration `yaml:"check_budget"`
+}
+
+// CostConfig weighs each query by how it was answered, in units of a
+// query resolved upstream. With Enabled, rate_limit is a budget of these
+// units per minute. Costs are recorded with the top-level weights; a
+// group may only turn cost budgets off.
+type CostConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	Cache     float64 `yaml:"cache"`     // answered from the cache
+	Forwarded float64 `yaml:"forwarded"` // resolved upstream or by local recursion
+	Refused   float64 `yaml:"refused"`   // refused, blocked or dropped by a rule
+	PerKiB    float64 `yaml:"per_kib"`   // added per KiB of response
+}
+
+// validate checks the weights
+func (c CostConfig) validate() error {
+	if c.Cache < 0 || c.Forwarded < 0 || c.Refused < 0 || c.PerKiB < 0 {
+		return fmt.Errorf("detection.cost weights must not be negative")
+	}
+	if c.Enabled && c.Cache+c.Forwarded+c.Refused+c.PerKiB == 0 {
+		return fmt.Errorf("detection.cost needs a weight above 0")
+	}
+	return nil
+}
+
+// NoiseConfig lists benign high-rate query patterns: fixed names (health
+// check records), search domains whose expansions are retries rather than
+// lookups (Kubernetes ndots), and monitoring probes recognized by their
+// steady period. probe_min_samples 0 disables probe recognition.
+type NoiseConfig struct {
+	Names           []string      `yaml:"names"`           // benign names, with their subdomains
+	SearchSuffixes  []string      `yaml:"search_suffixes"` // e.g. cluster.local
+	ProbeMinSamples int           `yaml:"probe_min_samples"`
+	ProbeMinPeriod  time.Duration `yaml:"probe_min_period"`
+	ProbeMaxCV      float64       `yaml:"probe_max_cv"`
+}
+
+// MonitorConfig holds per-IP traffic history retention
+type MonitorConfig struct {
+	HistorySize int           `yaml:"history_size"` // queries kept per IP
+	Retention   time.Duration `yaml:"retention"`    // idle time before an IP's stats are dropped
+	SeenDomains int           `yaml:"seen_domains"` // distinct names remembered for new-domain rates
+}
+
+// ArchiveConfig holds the warm tier of query history: per-minute client
+// aggregates spilled to disk from the in-memory history. An empty Dir
+// disables it.
+type ArchiveConfig struct {
+	Dir           string        `yaml:"dir"`
+	Retention     time.Duration `yaml:"retention"`      // how long aggregates are kept on disk
+	SpillInterval time.Duration `yaml:"spill_interval"` // how often finished minutes are written
+
+	// Clients averaging more than SustainedRate requests a minute over
+	// SustainedWindow are rate limited; checked every ScanInterval
+	// (0 disables the check)
+	SustainedRate   int           `yaml:"sustained_rate"`
+	SustainedWindow time.Duration `yaml:"sustained_window"`
+	ScanInterval    time.Duration `yaml:"scan_interval"`
+}
+
+// BlockingConfig holds mitigation settings
+type BlockingConfig struct {
+	BlockDuration time.Duration `yaml:"block_duration"`
+
+	// Blocked clients' refusals are cached for VerdictTTL so a flood skips
+	// logging and analysis; past VerdictDropAfter hits per TTL the client
+	// is dropped silently (0 always refuses)
+	VerdictTTL       time.Duration `yaml:"verdict_ttl"`
+	VerdictDropAfter int           `yaml:"verdict_drop_after"`
+
+	// FlaggedMinTTL raises the TTLs of answers to rate limited clients to
+	// at least this, so a buggy client re-queries less and a slow abuser
+	// gets less fresh data (0 disables it)
+	FlaggedMinTTL time.Duration `yaml:"flagged_min_ttl"`
+
+	// MaxEntries caps the block list (0 means unlimited); a warning is
+	// raised at CapacityWarning (fraction of MaxEntries)
+	MaxEntries      int     `yaml:"max_entries"`
+	CapacityWarning float64 `yaml:"capacity_warning"`
+
+	// AggregateThreshold collapses this many blocks within one /24 (or
+	// /48 for IPv6) into a single prefix block (0 disables)
+	AggregateThreshold int `yaml:"aggregate_threshold"`
+
+	// SeverityDurations overrides BlockDuration per detection severity
+	// (low, medium, high)
+	SeverityDurations map[string]time.Duration `yaml:"severity_durations"`
+
+	// Bootstrap is a file of IPs and CIDRs blocked at startup, e.g. known
+	// attackers from a previous incident; with BootstrapPermanent they
+	// never expire, otherwise they last as long as a high severity block
+	Bootstrap          string `yaml:"bootstrap"`
+	BootstrapPermanent bool   `yaml:"bootstrap_permanent"`
+}
+
+// CacheConfig holds response cache settings
+type CacheConfig struct {
+	MaxEntries int           `yaml:"max_entries"` // 0 disables the cache
+	MaxBytes   int64         `yaml:"max_bytes"`   // memory for cached responses; 0 means unlimited
+	Shards     int           `yaml:"shards"`      // independently locked parts
+	MaxTTL     time.Duration `yaml:"max_ttl"`
+	// SnapshotFile, if set, is loaded at startup and written on shutdown so
+	// a restart does not begin with a cold cache
+	SnapshotFile string `yaml:"snapshot_file"`
+	// Warm resolves popular names into the cache at startup, before the
+	// server takes traffic
+	Warm WarmConfig `yaml:"warm"`
+
+	NXDomainPatterns NXDomainPatternConfig `yaml:"nxdomain_patterns"`
+}
+
+// WarmConfig lists the names resolved into the cache at startup: Names
+// and those in File, one per line or the JSON of the top domains API.
+// With Save, the popular names are written to File on shutdown for the
+// next start. Startup waits at most Timeout for warming.
+type WarmConfig struct {
+	Names       []string      `yaml:"names"`
+	File        string        `yaml:"file"`
+	Save        bool          `yaml:"save"`
+	QTypes      []string      `yaml:"qtypes"`      // resolved for each name
+	Concurrency int           `yaml:"concurrency"` // resolutions at once
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// enabled reports whether any names are warmed
+func (w WarmConfig) enabled() bool {
+	return len(w.Names) > 0 || w.File != ""
+}
+
+// PopularityConfig holds the ranking of domains by decayed query count.
+// The TopN most popular names are kept in the cache over others,
+// refreshed PrefetchBefore their cached answer expires, and answered from
+// cache while the server is shedding load. MaxDomains 0 disables it.
+type PopularityConfig struct {
+	MaxDomains     int           `yaml:"max_domains"`     // names tracked; the least popular are dropped
+	TopN           int           `yaml:"top_n"`           // names treated as popular
+	HalfLife       time.Duration `yaml:"half_life"`       // how long until a query counts half
+	PrefetchBefore time.Duration `yaml:"prefetch_before"` // 0 disables prefetching
+}
+
+// NXDomainPatternConfig holds wildcard negative caching for random
+// subdomain floods: after Threshold distinct NXDOMAINs under one
+// registered domain within Window, every uncached name under it is
+// answered NXDOMAIN for TTL without asking upstream
+type NXDomainPatternConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	TTL       time.Duration `yaml:"ttl"`
+}
+
+// IntegrityConfig holds upstream answer stability tracking settings
+type IntegrityConfig struct {
+	Enabled         bool `yaml:"enabled"`
+	MinObservations int  `yaml:"min_observations"` // answers before a name's baseline is trusted
+	MaxNames        int  `yaml:"max_names"`
+
+	// CheckpointFile, if set, persists learned baselines across restarts.
+	// Baselines are discounted by age on load and dropped after
+	// BaselineMaxAge.
+	CheckpointFile     string        `yaml:"checkpoint_file"`
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+	BaselineMaxAge     time.Duration `yaml:"baseline_max_age"`
+}
+
+// ChaosConfig holds fault injection settings for resilience testing. It
+// must never be enabled in production: rates are the fraction (0-1) of
+// upstream exchanges that time out, are delayed by Latency, or return
+// SERVFAIL.
+type ChaosConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	TimeoutRate  float64       `yaml:"timeout_rate"`
+	ServfailRate float64       `yaml:"servfail_rate"`
+	LatencyRate  float64       `yaml:"latency_rate"`
+	Latency      time.Duration `yaml:"latency"`
+}
+
+// PrivacyConfig spreads queries across several upstreams so that none of
+// them sees the complete query stream. Without Upstreams every query goes
+// to server.upstream.
+type PrivacyConfig struct {
+	Upstreams []string `yaml:"upstreams"`
+	// HashLabels picks the upstream from the last HashLabels labels of the
+	// name, so each upstream sees all queries for its share of domains;
+	// 0 picks one at random per query
+	HashLabels int `yaml:"hash_labels"`
+	// Failover retries a failed exchange on the next upstream, which then
+	// also sees that query
+	Failover bool `yaml:"failover"`
+}
+
+// MobilityConfig keys soft penalties (rate limits and the upstream
+// failure penalty) to the client fingerprint behind an address rather than
+// to the address alone, so a penalty does not follow a dynamic address to
+// its next user
+type MobilityConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	DynamicRanges []string `yaml:"dynamic_ranges"` // CIDRs of DHCP and CGNAT pools
+	DynamicDecay  float64  `yaml:"dynamic_decay"`  // share of penalty lifetimes kept in dynamic ranges
+}
+
+// DualStackConfig links the IPv4 and IPv6 addresses of one dual-stack
+// client, so its blocks and reputation follow it across address families
+type DualStackConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	LinkTTL time.Duration `yaml:"link_ttl"` // how long a link lasts without fresh evidence
+	// CoQueryWindow is how close together a v4 and a v6 address must query
+	// the same name, with the same query and TLS fingerprints, for the
+	// query to count toward a link; MinCoQueries is how many distinct
+	// names it takes
+	CoQueryWindow time.Duration `yaml:"co_query_window"`
+	MinCoQueries  int           `yaml:"min_co_queries"`
+}
+
+// PanicConfig tunes panic mode, the strictest profile an operator can
+// engage through the admin API during an extreme event
+type PanicConfig struct {
+	MaxQPS int `yaml:"max_qps"` // global query cap while engaged; 0 disables it
+}
+
+// UpdateConfig holds the opt-in check for newer releases. A newer
+// release is only logged; upgrading stays with the operator.
+type UpdateConfig struct {
+	Check    bool          `yaml:"check"`
+	Feed     string        `yaml:"feed"` // latest release as GitHub API JSON
+	Interval time.Duration `yaml:"interval"`
+}
+
+// EmergencyConfig holds the fallback to local recursion from the root
+// hints for critical domains when every upstream is down
+type EmergencyConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	After      time.Duration `yaml:"after"`       // how long every upstream must be failing
+	Timeout    time.Duration `yaml:"timeout"`     // per exchange with an authoritative server
+	MaxQueries int           `yaml:"max_queries"` // exchanges per lookup
+	Roots      []string      `yaml:"roots"`       // root server addresses; empty uses the built-in hints
+}
+
+// CleanupConfig holds background cleanup schedules. Each run is delayed by
+// the interval adjusted by up to ±Jitter (a fraction of the interval).
+type CleanupConfig struct {
+	BlockerInterval time.Duration `yaml:"blocker_interval"`
+	MonitorInterval time.Duration `yaml:"monitor_interval"`
+	Jitter          float64       `yaml:"jitter"`
+}
+
+// APIConfig holds admin API settings
+type APIConfig struct {
+	Listen  string `yaml:"listen"`
+	Token   Secret `yaml:"token"`
+	TLSCert string `yaml:"tls_cert"` // path to the PEM certificate
+	TLSKey  Secret `yaml:"tls_key"`  // PEM private key contents
+	// Insecure serves the admin API without a token. Without it a listen
+	// address needs a token.
+	Insecure bool `yaml:"insecure"`
+	// MetricsLabelLimit caps the distinct values of each metric label;
+	// later values are counted as "other". 0 disables the cap.
+	MetricsLabelLimit int `yaml:"metrics_label_limit"`
+}
+
+// FederationConfig lists the peer instances whose stats snapshots the
+// admin API merges into a cluster-wide view. Peers are other instances'
+// admin APIs; no peers gives a view of this node alone. Subscribe lists
+// the instances whose block decisions this node adopts. A replica serves
+// no DNS and follows the event feeds of the peers instead, answering the
+// cluster's API and analytics queries in their place.
+type FederationConfig struct {
+	Node       string              `yaml:"node"` // name this node reports under; the hostname when empty
+	Peers      []FederationPeer    `yaml:"peers"`
+	CA         string              `yaml:"ca"`          // PEM file of CAs trusted for peers; system roots when empty
+	Timeout    time.Duration       `yaml:"timeout"`     // per-peer fetch timeout
+	TopTalkers int                 `yaml:"top_talkers"` // talkers reported per node and in the merged view
+	Subscribe  []BlockSubscription `yaml:"subscribe"`
+	Wait       time.Duration       `yaml:"wait"` // how long a feed poll waits for a change
+	// PropagationAlert raises an event when blocks from subscribed peers
+	// are enforced here later than this after the peer decided them; 0
+	// only measures
+	PropagationAlert time.Duration `yaml:"propagation_alert"`
+	// EventBuffer is how many recent events are kept for the event feed
+	// and event queries
+	EventBuffer int  `yaml:"event_buffer"`
+	Replica     bool `yaml:"replica"`
+}
+
+// FederationPeer is another instance's admin API
+type FederationPeer struct {
+	Name  string `yaml:"name"`
+	URL   string `yaml:"url"` // e.g. https://10.0.0.2:8080
+	Token Secret `yaml:"token"`
+}
+
+// BlockSubscription is an instance whose blocks this node adopts, and how
+// far it is trusted
+type BlockSubscription struct {
+	Name        string        `yaml:"name"`
+	URL         string        `yaml:"url"` // the instance's admin API
+	Token       Secret        `yaml:"token"`
+	MinSeverity string        `yaml:"min_severity"` // blocks of lower severity are ignored; all are adopted when empty
+	MaxTTL      time.Duration `yaml:"max_ttl"`      // longest an adopted block outlives the peer listing it; 0 keeps the peer's expiry
+	Networks    []string      `yaml:"networks"`     // CIDRs the peer may block; any address when empty
+	// MinPrefixV4 and MinPrefixV6 are the shortest prefixes the peer may
+	// block; /16 and /32 when 0
+	MinPrefixV4 int `yaml:"min_prefix_v4"`
+	MinPrefixV6 int `yaml:"min_prefix_v6"`
+}
+
+// PublicConfig holds the unauthenticated stats endpoint settings. It
+// listens separately from the admin API; an empty Listen disables it.
+type PublicConfig struct {
+	Listen    string `yaml:"listen"`
+	RateLimit int    `yaml:"rate_limit"` // requests per client per minute
+}
+
+// GeoIPConfig holds the client location database and the query heat map
+// built from it. An empty Database disables both.
+type GeoIPConfig struct {
+	Database  string        `yaml:"database"`  // CSV of network,country,asn,as_name
+	Bucket    time.Duration `yaml:"bucket"`    // heat map time resolution
+	Retention time.Duration `yaml:"retention"` // how far back the heat map goes
+	MaxASNs   int           `yaml:"max_asns"`  // systems tracked per bucket; the rest are aggregated
+}
+
+// ScriptConfig holds the operator policy script. An empty File disables
+// it.
+type ScriptConfig struct {
+	File     string        `yaml:"file"`
+	MaxSteps int           `yaml:"max_steps"` // per hook call
+	Timeout  time.Duration `yaml:"timeout"`   // per hook call
+}
+
+// CaptureConfig holds packet captures of blocked sources. An empty Dir
+// disables them.
+type CaptureConfig struct {
+	Dir           string        `yaml:"dir"`
+	Duration      time.Duration `yaml:"duration"`       // how long each source is captured
+	MaxPackets    int           `yaml:"max_packets"`    // per capture; 0 means unlimited
+	MaxConcurrent int           `yaml:"max_concurrent"` // captures at once; 0 means unlimited
+}
+
+// DatasetConfig holds the export of labeled feature vectors for offline
+// model training. An empty File disables it.
+type DatasetConfig struct {
+	File string `yaml:"file"` // CSV, appended to
+	// SampleRate is the fraction of benign decisions exported alongside
+	// every attack verdict
+	SampleRate float64 `yaml:"sample_rate"`
+	// ConfirmAfter is how long a row waits for the client's later
+	// behaviour to confirm or reverse its label
+	ConfirmAfter time.Duration `yaml:"confirm_after"`
+	MaxPending   int           `yaml:"max_pending"` // rows awaiting confirmation; 0 means unlimited
+	// Client is how client addresses are written: hash (keyed with
+	// HashKey, or a per-process key when empty), prefix (/24 or /48),
+	// omit or raw
+	Client  string `yaml:"client"`
+	HashKey Secret `yaml:"hash_key"`
+	QNames  bool   `yaml:"qnames"` // write query names, not only their shape
+}
+
+// MirrorConfig copies a share of live queries, fire and forget, to a test
+// instance. An empty Address disables it.
+type MirrorConfig struct {
+	Address    string  `yaml:"address"`     // host:port, over UDP
+	SampleRate float64 `yaml:"sample_rate"` // share of queries copied
+	Queue      int     `yaml:"queue"`       // copies waiting to be sent; more are dropped
+}
+
+// JournalConfig holds the hash-chained journal of mitigation decisions.
+// An empty File disables it.
+type JournalConfig struct {
+	File string `yaml:"file"` // JSON lines, appended to
+	// Key makes the entry hashes HMACs, so the chain cannot be rebuilt
+	// after tampering without it
+	Key Secret `yaml:"key"`
+}
+
+// Reporter trust levels
+const (
+	TrustHigh   = "high"   // act on the report as asked
+	TrustMedium = "medium" // rate limit at most
+	TrustLow    = "low"    // only raise the risk score
+	TrustNone   = "none"   // reject the report
+)
+
+// ReportsConfig sets how far abuse reports from other services (a WAF, a
+// mail server, an IDS) are trusted. Every report raises the client's risk
+// score; a client reaching BlockScore is blocked.
+type ReportsConfig struct {
+	// DefaultTrust applies to reports sent without a reporter's token
+	DefaultTrust string           `yaml:"default_trust"`
+	BlockScore   float64          `yaml:"block_score"` // 0 never blocks on the score
+	Window       time.Duration    `yaml:"window"`      // how long a report counts toward the score
+	Reporters    []ReporterConfig `yaml:"reporters"`
+}
+
+// ExemptionsConfig governs the time-limited rate limit exemptions
+// operators create through the admin API
+type ExemptionsConfig struct {
+	// RequireApproval keeps a new exemption pending until an operator
+	// other than the one who asked for it approves it
+	RequireApproval bool          `yaml:"require_approval"`
+	MaxDuration     time.Duration `yaml:"max_duration"` // longest exemption that may be asked for
+}
+
+// GrantsConfig bounds the budget grants operators mint through the admin
+// API for batch jobs
+type GrantsConfig struct {
+	MaxFactor   float64       `yaml:"max_factor"`   // largest budget multiplier a grant may give
+	MaxDuration time.Duration `yaml:"max_duration"` // longest window a grant may cover
+	MaxLead     time.Duration `yaml:"max_lead"`     // how far ahead a grant's window may start
+}
+
+// Data file mismatch actions
+const (
+	MismatchRefuse = "refuse" // exit rather than load the file
+	MismatchWarn   = "warn"   // log a warning and load it anyway
+)
+
+// DataFilesConfig checks the data files policy is loaded from (block
+// lists and the GeoIP database) before loading them
+type DataFilesConfig struct {
+	OnMismatch string `yaml:"on_mismatch"` // a Mismatch constant
+	// Require makes a data file with neither a checksum nor a signature
+	// key configured a mismatch
+	Require bool `yaml:"require"`
+	// PublicKey is a base64 Ed25519 public key; each data file then needs
+	// a valid base64 signature of its contents in <file>.sig
+	PublicKey string `yaml:"public_key"`
+	// Checksums maps data file paths, as written in the configuration, to
+	// the hex SHA-256 digest of their contents
+	Checksums map[string]string `yaml:"checksums"`
+	// Watch reloads the block lists when they change on disk, checking
+	// them again first and keeping the previous list if they fail
+	Watch bool `yaml:"watch"`
+}
+
+// validate checks the mismatch action, key and digests
+func (d DataFilesConfig) validate() error {
+	if d.OnMismatch != MismatchRefuse && d.OnMismatch != MismatchWarn {
+		return fmt.Errorf("data_files.on_mismatch must be refuse or warn, got %q", d.OnMismatch)
+	}
+	if d.PublicKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(d.PublicKey); err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("data_files.public_key must be a base64 Ed25519 public key")
+		}
+	}
+	for path, digest := range d.Checksums {
+		if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("data_files.checksums: %s: want a hex SHA-256 digest, got %q", path, digest)
+		}
+	}
+	return nil
+}
+
+// ReporterConfig is the trust given to one reporting service, which
+// identifies itself by its token
+type ReporterConfig struct {
+	Name  string `yaml:"name"`
+	Trust string `yaml:"trust"`
+	Token Secret `yaml:"token"` // inline, file:// or env://
+}
+
+// ReporterFor returns the reporter token belongs to
+func (r ReportsConfig) ReporterFor(token string) (ReporterConfig, bool) {
+	for _, reporter := range r.Reporters {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(reporter.Token.Value())) == 1 {
+			return reporter, true
+		}
+	}
+	return ReporterConfig{}, false
+}
+
+// validate checks the trust levels and that each reporter is listed once
+// with a token of its own
+func (r ReportsConfig) validate() error {
+	if !validTrust(r.DefaultTrust) {
+		return fmt.Errorf("reports.default_trust must be high, medium, low or none, got %q", r.DefaultTrust)
+	}
+	if r.BlockScore < 0 || r.Window <= 0 {
+		return fmt.Errorf("reports.block_score must not be negative and reports.window must be positive")
+	}
+	seen := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for _, reporter := range r.Reporters {
+		token := reporter.Token.Value()
+		switch {
+		case reporter.Name == "":
+			return fmt.Errorf("reports.reporters: every reporter needs a name")
+		case seen[reporter.Name]:
+			return fmt.Errorf("reports.reporters: duplicate reporter %s", reporter.Name)
+		case !validTrust(reporter.Trust):
+			return fmt.Errorf("reports.reporters: %s: trust must be high, medium, low or none, got %q", reporter.Name, reporter.Trust)
+		case token == "":
+			return fmt.Errorf("reports.reporters: %s: token is required", reporter.Name)
+		case tokens[token]:
+			return fmt.Errorf("reports.reporters: %s: token is shared with another reporter", reporter.Name)
+		}
+		seen[reporter.Name] = true
+		tokens[token] = true
+	}
+	return nil
+}
+
+// validTrust reports whether t is a trust level
+func validTrust(t string) bool {
+	return t == TrustHigh || t == TrustMedium || t == TrustLow || t == TrustNone
+}
+
+// SandboxConfig confines the process once it is serving (Linux only).
+// The paths the server still needs are derived from the rest of the
+// config; ReadPaths and WritePaths add to them.
+type SandboxConfig struct {
+	Landlock   bool     `yaml:"landlock"`    // restrict file access
+	Seccomp    bool     `yaml:"seccomp"`     // restrict system calls
+	Audit      bool     `yaml:"audit"`       // log system calls seccomp would deny instead of failing them
+	AllowExec  bool     `yaml:"allow_exec"`  // keep in-place upgrades working
+	ReadPaths  []string `yaml:"read_paths"`  // extra files and directories to read
+	WritePaths []string `yaml:"write_paths"` // extra directories to write in
+}
+
+// PolicyConfig holds the external decision service consulted for
+// borderline detections (those that would only be rate limited). The
+// service receives the client context as JSON and answers allow,
+// rate_limit or block; an empty URL disables it. The URL may carry a
+// credential, so it is a secret.
+type PolicyConfig struct {
+	URL         Secret        `yaml:"url"`
+	Timeout     time.Duration `yaml:"timeout"`
+	CacheTTL    time.Duration `yaml:"cache_ttl"`    // how long a client's decision is reused
+	MaxInFlight int           `yaml:"max_inflight"` // concurrent requests before falling back
+}
+
+// NotifyConfig holds abuse notifications sent to the operators of zones
+// targeted by attacks seen at this resolver. Only zones with a contact
+// are notified, and never zones listed as owned.
+type NotifyConfig struct {
+	Reporter string        `yaml:"reporter"` // identifies this operator in notifications
+	Interval time.Duration `yaml:"interval"` // minimum time between notifications per zone
+	Owned    []string      `yaml:"owned"`    // zone suffixes run by this operator
+	Zones    []ZoneContact `yaml:"zones"`
+	SMTP     SMTPConfig    `yaml:"smtp"`
+}
+
+// ZoneContact is where notifications about a zone suffix are sent; either
+// or both of Webhook and Email may be set. Webhook URLs often carry a
+// credential, so Webhook is a secret.
+type ZoneContact struct {
+	Suffix  string `yaml:"suffix"`
+	Webhook Secret `yaml:"webhook"`
+	Email   string `yaml:"email"`
+}
+
+// SMTPConfig holds the mail relay used for email notifications
+type SMTPConfig struct {
+	Addr     string `yaml:"addr"` // host:port
+	From     string `yaml:"from"`
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+}
+
+// SLOConfig holds the latency objective for allowed queries: Target of
+// them answered within Latency. Latency is rounded up to the next
+// histogram bucket bound. An alert event is published when the error
+// budget burns faster than a window's rate over both its long and short
+// spans.
+type SLOConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Target   float64       `yaml:"target"`   // e.g. 0.99
+	Latency  time.Duration `yaml:"latency"`  // e.g. 50ms
+	Interval time.Duration `yaml:"interval"` // how often burn rates are computed
+	Windows  []BurnWindow  `yaml:"windows"`
+}
+
+// AdaptiveConfig ties clients' rate budgets to the server's load. While
+// any signal is at or above its threshold, budgets shrink by Step every
+// Interval down to MinFactor of the configured limits. Once every signal
+// has been below Relax of its threshold for Hold, they grow back by the
+// same step, one step per Hold. A zero threshold ignores its signal.
+type AdaptiveConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // how often load is sampled
+
+	CPU             float64       `yaml:"cpu"`              // process CPU use, as a share of all cores
+	SocketDrops     float64       `yaml:"socket_drops"`     // datagrams dropped per second
+	UpstreamLatency time.Duration `yaml:"upstream_latency"` // p90 exchange time, weighted by upstream QPS
+
+	Step      float64       `yaml:"step"`       // budget multiplier per tightening, e.g. 0.5
+	MinFactor float64       `yaml:"min_factor"` // smallest share of the configured budgets
+	Relax     float64       `yaml:"relax"`      // share of each threshold load must fall below
+	Hold      time.Duration `yaml:"hold"`       // how long load must stay low before each relaxing step
+}
+
+// WatchConfig lists the domains vital services depend on. Their
+// resolution success rate and latency are tracked on their own, and an
+// alert is raised when either degrades.
+type WatchConfig struct {
+	Interval time.Duration `yaml:"interval"` // how often health is evaluated
+	Window   time.Duration `yaml:"window"`   // span success rate and latency cover
+	// MinSuccess and MinResolutions apply to domains that do not set
+	// their own
+	MinSuccess     float64         `yaml:"min_success"`
+	MinResolutions int             `yaml:"min_resolutions"`
+	Domains        []WatchedDomain `yaml:"domains"`
+}
+
+// WatchedDomain is one watched domain, with its subdomains
+type WatchedDomain struct {
+	Domain     string        `yaml:"domain"`
+	MinSuccess float64       `yaml:"min_success"` // share of resolutions that must succeed
+	MaxLatency time.Duration `yaml:"max_latency"` // p95 resolution time; 0 sets no limit
+	// MinResolutions is how many resolutions in the window it takes to
+	// judge the domain
+	MinResolutions int `yaml:"min_resolutions"`
+	// Probe resolves the domain when clients have not for this long, so
+	// rarely queried domains are watched too; 0 never probes
+	Probe time.Duration `yaml:"probe"`
+}
+
+// BurnWindow is one multiwindow burn-rate alert
+type BurnWindow struct {
+	Long     time.Duration `yaml:"long"`
+	Short    time.Duration `yaml:"short"`
+	BurnRate float64       `yaml:"burn_rate"` // multiple of the sustainable budget burn
+}
+
+// RewriteRule rewrites matching records in upstream answers. Name and RData
+// are regular expressions; empty match fields match everything. Replace
+// may reference RData capture groups ($1).
+type RewriteRule struct {
+	Name    string `yaml:"name"`
+	QType   string `yaml:"qtype"`
+	RData   string `yaml:"rdata"`
+	Replace string `yaml:"replace"`
+	TTL     uint32 `yaml:"ttl"`
+	Drop    bool   `yaml:"drop"`
+}
+
+// CriticalQuery marks queries for Domain (and its subdomains), optionally
+// restricted to QType, as critical. Critical queries are forwarded even
+// while the server sheds load and skip the rate-limit delay; clients that
+// are blocked outright are still refused.
+type CriticalQuery struct {
+	Domain string `yaml:"domain"`
+	QType  string `yaml:"qtype"`
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Version: CurrentVersion,
+		Server: ServerConfig{
+			Port:             8053,
+			Upstream:         "8.8.8.8:53",
+			MaxInFlight:      10000,
+			UDPBatch:         32,
+			StatsInterval:    time.Minute,
+			MaxCNAMEChain:    8,
+			DuplicateWindow:  2 * time.Second,
+			PaddingBlockSize: 468, // RFC 8467
+			MaxEDNSOptions:   8,
+			MaxRRSet:         128,
+			TCPAbuse: TCPAbuseConfig{
+				MaxConnections:    16,
+				ConnectionRate:    120,
+				MaxQueriesPerConn: 128,
+				PipelineRate:      100,
+				RefuseFor:         5 * time.Minute,
+			},
+			Shed: ShedConfig{
+				Response:    ShedRefused,
+				RetryAfter:  5 * time.Second,
+				ErrorBudget: 1000,
+			},
+			LocalZone: LocalZoneConfig{
+				NegativeTTL: 5 * time.Minute,
+				MName:       "ddd.invalid.",
+				RName:       "hostmaster.ddd.invalid.",
+			},
+		},
+		API: APIConfig{
+			MetricsLabelLimit: 1000,
+		},
+		Log: LogConfig{
+			File:               "logs/dns-defense.log",
+			HostnameTTL:        time.Hour,
+			HostnameLookupRate: 10,
+			Mode:               LogModeFull,
+			SummaryInterval:    time.Minute,
+		},
+		Detection: DetectionConfig{
+			Sensitivity:     "medium",
+			RateLimit:       100,
+			Window:          time.Minute,
+			NewClientWindow: 10 * time.Second,
+			NewClientLimit:  50,
+
+			TimingMinSamples:         30,
+			TimingMaxCV:              0.05,
+			TimingMinAutocorrelation: 0.9,
+
+			NewDomainRate:      120,
+			ProtocolAbuseLimit: 10,
+			HomographLimit:     10,
+
+			FailurePenalty: 5,
+			FailureFloor:   0.25,
+
+			Cost: CostConfig{
+				Cache:     0.2,
+				Forwarded: 1,
+				Refused:   0.1,
+				PerKiB:    0.5,
+			},
+
+			Noise: NoiseConfig{
+				SearchSuffixes:  []string{"cluster.local"},
+				ProbeMinSamples: 10,
+				ProbeMinPeriod:  500 * time.Millisecond,
+				ProbeMaxCV:      0.1,
+			},
+		},
+		Monitor: MonitorConfig{
+			HistorySize: 100,
+			Retention:   30 * time.Minute,
+			SeenDomains: 100000,
+		},
+		Blocking: BlockingConfig{
+			BlockDuration:      5 * time.Minute,
+			VerdictTTL:         time.Second,
+			VerdictDropAfter:   100,
+			MaxEntries:         100000,
+			CapacityWarning:    0.9,
+			AggregateThreshold: 16,
+		},
+		Public: PublicConfig{
+			RateLimit: 60,
+		},
+		Capture: CaptureConfig{
+			Duration:      30 * time.Second,
+			MaxPackets:    10000,
+			MaxConcurrent: 4,
+		},
+		GeoIP: GeoIPConfig{
+			Bucket:    5 * time.Minute,
+			Retention: 24 * time.Hour,
+			MaxASNs:   1000,
+		},
+		Notify: NotifyConfig{
+			Interval: time.Hour,
+		},
+		Policy: PolicyConfig{
+			Timeout:     250 * time.Millisecond,
+			CacheTTL:    30 * time.Second,
+			MaxInFlight: 16,
+		},
+		SLO: SLOConfig{
+			Target:   0.99,
+			Latency:  50 * time.Millisecond,
+			Interval: 10 * time.Second,
+			Windows: []BurnWindow{
+				{Long: time.Hour, Short: 5 * time.Minute, BurnRate: 14.4},
+				{Long: 6 * time.Hour, Short: 30 * time.Minute, BurnRate: 6},
+			},
+		},
+		Cache: CacheConfig{
+			MaxEntries: 10000,
+			MaxBytes:   64 << 20,
+			Shards:     16,
+			MaxTTL:     time.Hour,
+			Warm: WarmConfig{
+				QTypes:      []string{"A", "AAAA"},
+				Concurrency: 16,
+				Timeout:     30 * time.Second,
+			},
+			NXDomainPatterns: NXDomainPatternConfig{
+				Threshold: 50,
+				Window:    10 * time.Second,
+				TTL:       time.Minute,
+			},
+		},
+		Integrity: IntegrityConfig{
+			Enabled:            true,
+			MinObservations:    20,
+			MaxNames:           10000,
+			CheckpointInterval: 5 * time.Minute,
+			BaselineMaxAge:     7 * 24 * time.Hour,
+		},
+		Federation: FederationConfig{
+			Timeout:          2 * time.Second,
+			TopTalkers:       20,
+			Wait:             30 * time.Second,
+			PropagationAlert: 10 * time.Second,
+			EventBuffer:      10000,
+		},
+		Mobility: MobilityConfig{
+			DynamicDecay: 0.25,
+		},
+		DualStack: DualStackConfig{
+			LinkTTL:       time.Hour,
+			CoQueryWindow: 2 * time.Second,
+			MinCoQueries:  3,
+		},
+		Archive: ArchiveConfig{
+			Retention:       7 * 24 * time.Hour,
+			SpillInterval:   time.Minute,
+			SustainedWindow: 6 * time.Hour,
+			ScanInterval:    5 * time.Minute,
+		},
+		Panic: PanicConfig{
+			MaxQPS: 1000,
+		},
+		Adaptive: AdaptiveConfig{
+			Interval:        5 * time.Second,
+			CPU:             0.8,
+			SocketDrops:     100,
+			UpstreamLatency: 500 * time.Millisecond,
+			Step:            0.5,
+			MinFactor:       0.25,
+			Relax:           0.7,
+			Hold:            time.Minute,
+		},
+		Watch: WatchConfig{
+			Interval:       time.Minute,
+			Window:         5 * time.Minute,
+			MinSuccess:     0.99,
+			MinResolutions: 5,
+		},
+		Reports: ReportsConfig{
+			DefaultTrust: TrustNone,
+			BlockScore:   100,
+			Window:       24 * time.Hour,
+		},
+		Exemptions: ExemptionsConfig{
+			RequireApproval: true,
+			MaxDuration:     7 * 24 * time.Hour,
+		},
+		Grants: GrantsConfig{
+			MaxFactor:   10,
+			MaxDuration: 12 * time.Hour,
+			MaxLead:     7 * 24 * time.Hour,
+		},
+		DataFiles: DataFilesConfig{
+			OnMismatch: MismatchRefuse,
+		},
+		Storage: StorageConfig{
+			Interval:   time.Minute,
+			Blocks:     BackendMemory,
+			Reputation: BackendMemory,
+			Baselines:  BackendMemory,
+			Redis: RedisStorageConfig{
+				Address: "127.0.0.1:6379",
+				Prefix:  "ddd:",
+				Timeout: 2 * time.Second,
+			},
+			Etcd: EtcdStorageConfig{
+				Prefix:  "/ddd/",
+				Timeout: 2 * time.Second,
+			},
+		},
+		Update: UpdateConfig{
+			Feed:     "https://api.github.com/repos/therealshammz/ddd/releases/latest",
+			Interval: 24 * time.Hour,
+		},
+		Emergency: EmergencyConfig{
+			After:      30 * time.Second,
+			Timeout:    2 * time.Second,
+			MaxQueries: 32,
+		},
+		Dataset: DatasetConfig{
+			SampleRate:   0.01,
+			ConfirmAfter: 10 * time.Minute,
+			MaxPending:   100000,
+			Client:       "hash",
+		},
+		Mirror: MirrorConfig{
+			SampleRate: 0.1,
+			Queue:      1024,
+		},
+		Script: ScriptConfig{
+			MaxSteps: 10000,
+			Timeout:  2 * time.Millisecond,
+		},
+		Popularity: PopularityConfig{
+			MaxDomains:     10000,
+			TopN:           1000,
+			HalfLife:       10 * time.Minute,
+			PrefetchBefore: 10 * time.Second,
+		},
+		Cleanup: CleanupConfig{
+			BlockerInterval: time.Minute,
+			MonitorInterval: 5 * time.Minute,
+			Jitter:          0.1,
+		},
+	}
+}
+
+// Load reads the config file at path on top of the defaults, following
+// includes, and resolves secret references. Files written for older schema
+// versions are migrated in memory; the returned warnings describe each
+// change so operators can update their files.
+func Load(path string) (*Config, []string, error) {
+	cfg := Default()
+	var warnings []string
+	if err := loadFile(cfg, path, map[string]bool{}, &warnings); err != nil {
+		return nil, nil, err
+	}
+	// A profile changes the defaults the files are layered on, so they
+	// are read again on top of the profile's
+	if cfg.Profile != "" && cfg.Profile != ProfileResolver {
+		profile, err := ProfileDefaults(cfg.Profile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg, warnings = profile, nil
+		if err := loadFile(cfg, path, map[string]bool{}, &warnings); err != nil {
+			return nil, nil, err
+		}
+	}
+	cfg.Include = nil
+	cfg.Version = CurrentVersion
+
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, warnings, nil
+}
+
+// loadFile decodes path into cfg after first decoding its includes.
+// visiting tracks the current include chain to reject cycles.
+func loadFile(cfg *Config, path string, visiting map[string]bool, warnings *[]string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visiting[abs] {
+		return fmt.Errorf("config include cycle at %s", path)
+	}
+	visiting[abs] = true
+	defer delete(visiting, abs)
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	data, migrated, err := migrate(path, data)
+	if err != nil {
+		return err
+	}
+	*warnings = append(*warnings, migrated...)
+
+	// Includes are decoded first so that this file's values win
+	var header struct {
+		Include []string `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for _, inc := range header.Include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(abs), inc)
+		}
+		if err := loadFile(cfg, inc, visiting, warnings); err != nil {
+			return err
+		}
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// resolveSecrets replaces secret references with their values
+func (c *Config) resolveSecrets() error {
+	secrets := map[string]*Secret{
+		"api.token":   &c.API.Token,
+		"api.tls_key": &c.API.TLSKey,
+
+		"notify.smtp.password":   &c.Notify.SMTP.Password,
+		"dataset.hash_key":       &c.Dataset.HashKey,
+		"journal.key":            &c.Journal.Key,
+		"storage.redis.password": &c.Storage.Redis.Password,
+		"storage.etcd.password":  &c.Storage.Etcd.Password,
+		"policy.url":             &c.Policy.URL,
+	}
+	for i := range c.Federation.Peers {
+		secrets[fmt.Sprintf("federation.peers[%d].token", i)] = &c.Federation.Peers[i].Token
+	}
+	for i := range c.Federation.Subscribe {
+		secrets[fmt.Sprintf("federation.subscribe[%d].token", i)] = &c.Federation.Subscribe[i].Token
+	}
+	for i := range c.Server.Listeners {
+		secrets[fmt.Sprintf("server.listeners[%d].tls_key", i)] = &c.Server.Listeners[i].TLSKey
+	}
+	for i := range c.Reports.Reporters {
+		secrets[fmt.Sprintf("reports.reporters[%d].token", i)] = &c.Reports.Reporters[i].Token
+	}
+	for i := range c.Notify.Zones {
+		secrets[fmt.Sprintf("notify.zones[%d].webhook", i)] = &c.Notify.Zones[i].Webhook
+	}
+
+	for name, secret := range secrets {
+		if err := secret.resolve(); err != nil {
+			return fmt.Errorf("resolving %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks settings that are invalid on their own or in combination
+func (c *Config) Validate() error {
+	sensitivity, err := detector.ParseSensitivity(c.Detection.Sensitivity)
+	if err != nil {
+		return fmt.Errorf("detection.sensitivity: %w", err)
+	}
+	switch {
+	case c.Detection.Window < time.Second:
+		return fmt.Errorf("detection.window must be at least 1s, got %v", c.Detection.Window)
+	case c.Detection.FailurePenalty < 0 || c.Detection.FailureFloor <= 0 || c.Detection.FailureFloor > 1:
+		return fmt.Errorf("detection.failure_penalty must not be negative and detection.failure_floor must be in (0, 1]")
+	case c.Detection.Noise.ProbeMinSamples < 0 || c.Detection.Noise.ProbeMinPeriod < 0 || c.Detection.Noise.ProbeMaxCV < 0:
+		return fmt.Errorf("detection.noise probe settings must not be negative")
+	case c.Log.Mode != LogModeFull && c.Log.Mode != LogModeSummary:
+		return fmt.Errorf("log.mode must be %s or %s, got %q", LogModeFull, LogModeSummary, c.Log.Mode)
+	case c.Log.Mode == LogModeSummary && c.Log.SummaryInterval < time.Second:
+		return fmt.Errorf("log.summary_interval must be at least 1s, got %v", c.Log.SummaryInterval)
+	case c.Detection.CheckBudget < 0:
+		return fmt.Errorf("detection.check_budget must not be negative, got %v", c.Detection.CheckBudget)
+	case c.Monitor.HistorySize < 1:
+		return fmt.Errorf("monitor.history_size must be positive, got %d", c.Monitor.HistorySize)
+	case c.Monitor.HistorySize < sensitivity.MinHistory():
+		return fmt.Errorf("monitor.history_size must be at least %d for pattern detection at %s sensitivity", sensitivity.MinHistory(), sensitivity)
+	case c.Monitor.Retention < c.Detection.Window:
+		return fmt.Errorf("monitor.retention (%v) must cover detection.window (%v)", c.Monitor.Retention, c.Detection.Window)
+	case c.Cleanup.MonitorInterval > c.Monitor.Retention:
+		return fmt.Errorf("cleanup.monitor_interval (%v) must not exceed monitor.retention (%v)", c.Cleanup.MonitorInterval, c.Monitor.Retention)
+	case len(c.Authoritative.TransferPeers) > 0 && c.Profile != ProfileAuthoritative:
+		return fmt.Errorf("authoritative.transfer_peers requires profile %q", ProfileAuthoritative)
+	case len(c.Server.TrustedProxies) > 0 && !c.Server.TCP && !c.Server.streamListeners():
+		return fmt.Errorf("server.trusted_proxies requires server.tcp or a tcp, dot or doh listener")
+	case c.Server.PaddingBlockSize < 0 || c.Server.PaddingBlockSize > 65535:
+		return fmt.Errorf("server.padding_block_size must be between 0 and 65535, got %d", c.Server.PaddingBlockSize)
+	case c.Server.MaxRRSet < 0:
+		return fmt.Errorf("server.max_rrset must not be negative, got %d", c.Server.MaxRRSet)
+	case c.Server.MaxCNAMEChain < 1:
+		return fmt.Errorf("server.max_cname_chain must be positive, got %d", c.Server.MaxCNAMEChain)
+	case c.Server.TCPAbuse.MaxConnections < 0 || c.Server.TCPAbuse.ConnectionRate < 0 ||
+		c.Server.TCPAbuse.MaxQueriesPerConn < 0 || c.Server.TCPAbuse.PipelineRate < 0:
+		return fmt.Errorf("server.tcp_abuse limits must not be negative")
+	case c.Server.TCPAbuse.RefuseFor < 0:
+		return fmt.Errorf("server.tcp_abuse.refuse_for must not be negative, got %v", c.Server.TCPAbuse.RefuseFor)
+	case c.Server.Shed.Response != ShedRefused && c.Server.Shed.Response != ShedServfail && c.Server.Shed.Response != ShedDrop:
+		return fmt.Errorf("server.shed.response must be refused, servfail or drop, got %q", c.Server.Shed.Response)
+	case c.Server.Shed.RetryAfter < 0 || c.Server.Shed.ErrorBudget < 0:
+		return fmt.Errorf("server.shed.retry_after and server.shed.error_budget must not be negative")
+	case c.Server.LocalZone.NegativeTTL < 0 || c.Server.LocalZone.NegativeTTL > 24*time.Hour:
+		return fmt.Errorf("server.local_zone.negative_ttl must be between 0 and 24h, got %v", c.Server.LocalZone.NegativeTTL)
+	case c.Server.LocalZone.NegativeTTL > 0 && (c.Server.LocalZone.MName == "" || c.Server.LocalZone.RName == ""):
+		return fmt.Errorf("server.local_zone needs an mname and rname")
+	case c.API.Listen != "" && c.API.Token.Value() == "" && !c.API.Insecure:
+		return fmt.Errorf("api.listen needs api.token, or api.insecure to serve the admin API unauthenticated")
+	case c.Public.Listen != "" && c.Public.Listen == c.API.Listen:
+		return fmt.Errorf("public.listen must differ from api.listen")
+	case (len(c.Federation.Peers) > 0 || len(c.Federation.Subscribe) > 0) && c.Federation.Timeout <= 0:
+		return fmt.Errorf("federation.timeout must be positive")
+	case len(c.Federation.Subscribe) > 0 && c.Federation.Wait < time.Second:
+		return fmt.Errorf("federation.wait must be at least 1s, got %v", c.Federation.Wait)
+	case c.API.MetricsLabelLimit < 0:
+		return fmt.Errorf("api.metrics_label_limit must not be negative, got %d", c.API.MetricsLabelLimit)
+	case c.Federation.TopTalkers < 0:
+		return fmt.Errorf("federation.top_talkers must not be negative, got %d", c.Federation.TopTalkers)
+	case c.Integrity.CheckpointFile != "" && c.Integrity.BaselineMaxAge <= 0:
+		return fmt.Errorf("integrity.baseline_max_age must be positive when checkpointing")
+	case !validRate(c.Blocking.CapacityWarning):
+		return fmt.Errorf("blocking.capacity_warning must be between 0 and 1, got %v", c.Blocking.CapacityWarning)
+	case c.Capture.Dir != "" && c.Capture.Duration < time.Second:
+		return fmt.Errorf("capture.duration must be at least 1s, got %v", c.Capture.Duration)
+	case c.GeoIP.Database != "" && (c.GeoIP.Bucket < time.Second || c.GeoIP.Retention < c.GeoIP.Bucket):
+		return fmt.Errorf("geoip.bucket must be at least 1s and geoip.retention at least one bucket")
+	case c.Blocking.FlaggedMinTTL < 0:
+		return fmt.Errorf("blocking.flagged_min_ttl must not be negative, got %v", c.Blocking.FlaggedMinTTL)
+	case c.Dataset.File != "" && (!validRate(c.Dataset.SampleRate) || c.Dataset.ConfirmAfter <= 0 || c.Dataset.MaxPending < 0):
+		return fmt.Errorf("dataset.sample_rate must be between 0 and 1, dataset.confirm_after positive and dataset.max_pending not negative")
+	case c.Mirror.Address != "" && (!validRate(c.Mirror.SampleRate) || c.Mirror.Queue < 1):
+		return fmt.Errorf("mirror.sample_rate must be between 0 and 1 and mirror.queue positive")
+	case c.Dataset.Client != "hash" && c.Dataset.Client != "prefix" && c.Dataset.Client != "omit" && c.Dataset.Client != "raw":
+		return fmt.Errorf("dataset.client must be hash, prefix, omit or raw, got %q", c.Dataset.Client)
+	case c.Script.File != "" && (c.Script.MaxSteps <= 0 || c.Script.Timeout <= 0):
+		return fmt.Errorf("script.max_steps and script.timeout must be positive")
+	case c.Policy.URL.IsSet() && c.Policy.Timeout <= 0:
+		return fmt.Errorf("policy.timeout must be positive")
+	case c.Cache.MaxEntries > 0 && (c.Cache.Shards < 1 || c.Cache.MaxBytes < 0):
+		return fmt.Errorf("cache.shards must be positive and cache.max_bytes must not be negative")
+	case c.Popularity.MaxDomains > 0 && (c.Popularity.TopN < 1 || c.Popularity.HalfLife < time.Second):
+		return fmt.Errorf("popularity.top_n must be positive and popularity.half_life at least 1s")
+	case c.Cache.Warm.enabled() && (c.Cache.MaxEntries == 0 || len(c.Cache.Warm.QTypes) == 0 ||
+		c.Cache.Warm.Concurrency < 1 || c.Cache.Warm.Timeout <= 0):
+		return fmt.Errorf("cache.warm needs the cache, qtypes, a positive concurrency and a positive timeout")
+	case c.Cache.Warm.Save && (c.Cache.Warm.File == "" || c.Popularity.MaxDomains == 0):
+		return fmt.Errorf("cache.warm.save needs cache.warm.file and the popularity ranking")
+	case c.Cache.NXDomainPatterns.Enabled && (c.Cache.NXDomainPatterns.Threshold <= 0 ||
+		c.Cache.NXDomainPatterns.Window <= 0 || c.Cache.NXDomainPatterns.TTL <= 0):
+		return fmt.Errorf("cache.nxdomain_patterns needs a positive threshold, window and ttl")
+	case c.Mobility.Enabled && (c.Mobility.DynamicDecay <= 0 || c.Mobility.DynamicDecay > 1):
+		return fmt.Errorf("mobility.dynamic_decay must be in (0, 1], got %v", c.Mobility.DynamicDecay)
+	case c.Exemptions.MaxDuration <= 0:
+		return fmt.Errorf("exemptions.max_duration must be positive")
+	case c.Grants.MaxFactor <= 1 || c.Grants.MaxDuration <= 0 || c.Grants.MaxLead < 0:
+		return fmt.Errorf("grants needs a max_factor above 1, a positive max_duration and a non-negative max_lead")
+	case c.DualStack.Enabled && (c.DualStack.LinkTTL <= 0 || c.DualStack.CoQueryWindow <= 0 || c.DualStack.MinCoQueries < 1):
+		return fmt.Errorf("dual_stack needs a positive link_ttl, co_query_window and min_co_queries")
+	case c.Emergency.Enabled && len(c.Critical) == 0:
+		return fmt.Errorf("emergency.enabled needs critical domains to resolve")
+	case c.Emergency.Enabled && (c.Emergency.After <= 0 || c.Emergency.Timeout <= 0 || c.Emergency.MaxQueries < 1):
+		return fmt.Errorf("emergency needs a positive after, timeout and max_queries")
+	case c.Archive.Dir != "" && (c.Archive.Retention < time.Hour || c.Archive.SpillInterval < time.Second || c.Archive.ScanInterval <= 0):
+		return fmt.Errorf("archive.retention must be at least 1h, archive.spill_interval at least 1s and archive.scan_interval positive")
+	case c.Archive.SustainedRate < 0:
+		return fmt.Errorf("archive.sustained_rate must not be negative, got %d", c.Archive.SustainedRate)
+	case c.Archive.SustainedRate > 0 && c.Archive.Dir == "":
+		return fmt.Errorf("archive.sustained_rate needs archive.dir")
+	case c.Archive.SustainedRate > 0 && (c.Archive.SustainedWindow < time.Minute || c.Archive.SustainedWindow > c.Archive.Retention):
+		return fmt.Errorf("archive.sustained_window must be between 1m and archive.retention")
+	case c.Panic.MaxQPS < 0:
+		return fmt.Errorf("panic.max_qps must not be negative, got %d", c.Panic.MaxQPS)
+	case c.Update.Check && !strings.HasPrefix(c.Update.Feed, "https://") && !strings.HasPrefix(c.Update.Feed, "http://"):
+		return fmt.Errorf("update.feed must be an http or https URL, got %q", c.Update.Feed)
+	case c.Update.Check && c.Update.Interval < time.Hour:
+		return fmt.Errorf("update.interval must be at least 1h, got %v", c.Update.Interval)
+	case c.Privacy.HashLabels < 0:
+		return fmt.Errorf("privacy.hash_labels must not be negative, got %d", c.Privacy.HashLabels)
+	case !validRate(c.Chaos.TimeoutRate) || !validRate(c.Chaos.ServfailRate) || !validRate(c.Chaos.LatencyRate):
+		return fmt.Errorf("chaos rates must be between 0 and 1")
+	}
+	if _, err := ProfileDefaults(c.Profile); err != nil {
+		return err
+	}
+	if err := c.Authoritative.validate(); err != nil {
+		return err
+	}
+	if err := c.Notify.validate(); err != nil {
+		return err
+	}
+	if err := c.SLO.validate(); err != nil {
+		return err
+	}
+	if err := c.Adaptive.validate(); err != nil {
+		return err
+	}
+	if err := c.Watch.validate(); err != nil {
+		return err
+	}
+	if err := c.Detection.Cost.validate(); err != nil {
+		return err
+	}
+	if err := c.Federation.validate(); err != nil {
+		return err
+	}
+	if c.Federation.Replica && c.API.Listen == "" {
+		return fmt.Errorf("federation.replica needs api.listen to serve queries")
+	}
+	if err := c.Emergency.validate(); err != nil {
+		return err
+	}
+	if err := c.Reports.validate(); err != nil {
+		return err
+	}
+	if err := c.DataFiles.validate(); err != nil {
+		return err
+	}
+	if err := c.Storage.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.validateListeners(); err != nil {
+		return err
+	}
+	if err := c.validateGroups(); err != nil {
+		return err
+	}
+	if err := validateCritical(c.Critical); err != nil {
+		return err
+	}
+	_, err = c.Blocking.Durations()
+	return err
+}
+
+// validateCritical checks that every critical qtype is a known record
+// type, so that a typo cannot silently drop the protection
+func validateCritical(entries []CriticalQuery) error {
+	for _, e := range entries {
+		if e.QType == "" {
+			continue
+		}
+		if _, ok := dns.StringToType[strings.ToUpper(e.QType)]; !ok {
+			return fmt.Errorf("critical: unknown qtype %q for %s", e.QType, e.Domain)
+		}
+	}
+	return nil
+}
+
+// validate checks that every zone contact can be delivered to
+func (n NotifyConfig) validate() error {
+	for _, z := range n.Zones {
+		switch {
+		case z.Suffix == "":
+			return fmt.Errorf("notify.zones: every contact needs a suffix")
+		case !z.Webhook.IsSet() && z.Email == "":
+			return fmt.Errorf("notify.zones: %s needs a webhook or email", z.Suffix)
+		case z.Email != "" && (n.SMTP.Addr == "" || n.SMTP.From == ""):
+			return fmt.Errorf("notify.zones: %s: email needs notify.smtp.addr and notify.smtp.from", z.Suffix)
+		}
+	}
+	return nil
+}
+
+// validate checks that every peer has a URL and a name distinct from the
+// other peers'
+func (f FederationConfig) validate() error {
+	switch {
+	case f.EventBuffer < 1:
+		return fmt.Errorf("federation.event_buffer must be positive, got %d", f.EventBuffer)
+	case f.Replica && len(f.Peers) == 0:
+		return fmt.Errorf("federation.replica needs peers to follow")
+	case f.PropagationAlert < 0:
+		return fmt.Errorf("federation.propagation_alert must not be negative, got %v", f.PropagationAlert)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range f.Peers {
+		switch {
+		case p.Name == "" || p.URL == "":
+			return fmt.Errorf("federation.peers: every peer needs a name and url")
+		case seen[p.Name]:
+			return fmt.Errorf("federation.peers: duplicate peer %s", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	subscribed := make(map[string]bool)
+	for _, s := range f.Subscribe {
+		switch {
+		case s.Name == "" || s.URL == "":
+			return fmt.Errorf("federation.subscribe: every subscription needs a name and url")
+		case subscribed[s.Name]:
+			return fmt.Errorf("federation.subscribe: duplicate subscription %s", s.Name)
+		case s.MaxTTL < 0:
+			return fmt.Errorf("federation.subscribe: %s: max_ttl must not be negative", s.Name)
+		case s.MinPrefixV4 < 0 || s.MinPrefixV4 > 32:
+			return fmt.Errorf("federation.subscribe: %s: min_prefix_v4 must be between 0 and 32", s.Name)
+		case s.MinPrefixV6 < 0 || s.MinPrefixV6 > 128:
+			return fmt.Errorf("federation.subscribe: %s: min_prefix_v6 must be between 0 and 128", s.Name)
+		}
+		if s.MinSeverity != "" {
+			if _, err := severity.Parse(s.MinSeverity); err != nil {
+				return fmt.Errorf("federation.subscribe: %s: %w", s.Name, err)
+			}
+		}
+		for _, n := range s.Networks {
+			if _, _, err := net.ParseCIDR(n); err != nil {
+				return fmt.Errorf("federation.subscribe: %s: %w", s.Name, err)
+			}
+		}
+		subscribed[s.Name] = true
+	}
+	return nil
+}
+
+// validate checks that the root servers are IPv4 addresses
+func (e EmergencyConfig) validate() error {
+	for _, root := range e.Roots {
+		if ip := net.ParseIP(root); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("emergency.roots: %q is not an IPv4 address", root)
+		}
+	}
+	return nil
+}
+
+// validate checks the objective and its alert windows
+func (s SLOConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	switch {
+	case s.Target <= 0 || s.Target >= 1:
+		return fmt.Errorf("slo.target must be between 0 and 1 exclusive, got %v", s.Target)
+	case s.Latency <= 0:
+		return fmt.Errorf("slo.latency must be positive")
+	case s.Interval <= 0:
+		return fmt.Errorf("slo.interval must be positive")
+	case len(s.Windows) == 0:
+		return fmt.Errorf("slo.windows must not be empty")
+	}
+	for _, w := range s.Windows {
+		if w.Short <= 0 || w.Long <= w.Short || w.BurnRate <= 0 {
+			return fmt.Errorf("slo.windows: need 0 < short < long and a positive burn_rate, got %v/%v/%v", w.Long, w.Short, w.BurnRate)
+		}
+	}
+	return nil
+}
+
+// validate checks the sampling interval, thresholds and steps
+func (a AdaptiveConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	switch {
+	case a.Interval < time.Second:
+		return fmt.Errorf("adaptive.interval must be at least 1s, got %v", a.Interval)
+	case a.CPU < 0 || a.SocketDrops < 0 || a.UpstreamLatency < 0:
+		return fmt.Errorf("adaptive.cpu, adaptive.socket_drops and adaptive.upstream_latency must not be negative")
+	case a.CPU == 0 && a.SocketDrops == 0 && a.UpstreamLatency == 0:
+		return fmt.Errorf("adaptive needs at least one of cpu, socket_drops and upstream_latency")
+	case a.Step <= 0 || a.Step >= 1:
+		return fmt.Errorf("adaptive.step must be between 0 and 1 exclusive, got %v", a.Step)
+	case a.MinFactor <= 0 || a.MinFactor > 1:
+		return fmt.Errorf("adaptive.min_factor must be between 0 exclusive and 1, got %v", a.MinFactor)
+	case a.Relax <= 0 || a.Relax > 1:
+		return fmt.Errorf("adaptive.relax must be between 0 exclusive and 1, got %v", a.Relax)
+	case a.Hold < a.Interval:
+		return fmt.Errorf("adaptive.hold must be at least adaptive.interval")
+	}
+	return nil
+}
+
+// validate checks the evaluation timing and each watched domain
+func (w WatchConfig) validate() error {
+	if len(w.Domains) == 0 {
+		return nil
+	}
+	switch {
+	case w.Interval < time.Second || w.Window < w.Interval:
+		return fmt.Errorf("watch.interval must be at least 1s and watch.window at least one interval")
+	case w.MinSuccess < 0 || w.MinSuccess > 1 || w.MinResolutions < 1:
+		return fmt.Errorf("watch.min_success must be between 0 and 1 and watch.min_resolutions positive")
+	}
+	seen := make(map[string]bool)
+	for _, d := range w.Domains {
+		name := strings.ToLower(strings.TrimSuffix(d.Domain, "."))
+		switch {
+		case name == "":
+			return fmt.Errorf("watch.domains: every entry needs a domain")
+		case seen[name]:
+			return fmt.Errorf("watch.domains: duplicate domain %s", d.Domain)
+		case d.MinSuccess < 0 || d.MinSuccess > 1:
+			return fmt.Errorf("watch.domains: %s: min_success must be between 0 and 1", d.Domain)
+		case d.MaxLatency < 0 || d.MinResolutions < 0 || d.Probe < 0:
+			return fmt.Errorf("watch.domains: %s: max_latency, min_resolutions and probe must not be negative", d.Domain)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Durations returns the per-severity block durations
+func (b BlockingConfig) Durations() (map[severity.Level]time.Duration, error) {
+	durations := make(map[severity.Level]time.Duration, len(b.SeverityDurations))
+	for name, d := range b.SeverityDurations {
+		level, err := severity.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("blocking.severity_durations: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("blocking.severity_durations.%s must be positive, got %v", name, d)
+		}
+		durations[level] = d
+	}
+	return durations, nil
+}
+
+// Instance returns the configured instance ID, falling back to the hostname
+func (c *Config) Instance() string {
+	if c.Server.InstanceID != "" {
+		return c.Server.InstanceID
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "unknown"
+}
+
+// validRate reports whether r is a fraction between 0 and 1
+func validRate(r float64) bool {
+	return r >= 0 && r <= 1
+}
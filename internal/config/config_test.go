@@ -0,0 +1,495 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadIncludesAndOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base.yaml", `
+server:
+  port: 53
+  upstream: 8.8.8.8:53
+detection:
+  rate_limit: 100
+`)
+	site := writeFile(t, dir, "site.yaml", `
+include: [base.yaml]
+detection:
+  rate_limit: 50
+`)
+
+	cfg, _, err := Load(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Port != 53 || cfg.Server.Upstream != "8.8.8.8:53" {
+		t.Errorf("Expected base values to be inherited, got %+v", cfg.Server)
+	}
+	if cfg.Detection.RateLimit != 50 {
+		t.Errorf("Expected site override rate_limit 50, got %d", cfg.Detection.RateLimit)
+	}
+	if cfg.Blocking.BlockDuration != Default().Blocking.BlockDuration {
+		t.Errorf("Expected default block_duration, got %v", cfg.Blocking.BlockDuration)
+	}
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.yaml", "include: [b.yaml]\n")
+	writeFile(t, dir, "b.yaml", "include: [a.yaml]\n")
+
+	if _, _, err := Load(filepath.Join(dir, "a.yaml")); err == nil {
+		t.Error("Expected include cycle to be rejected")
+	}
+}
+
+func TestLoadSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "api.key", "-----KEY-----\n")
+	t.Setenv("DDD_TEST_TOKEN", "s3cret")
+
+	path := writeFile(t, dir, "config.yaml", `
+api:
+  token: env://DDD_TEST_TOKEN
+  tls_key: file://`+filepath.Join(dir, "api.key")+`
+`)
+
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.API.Token.Value() != "s3cret" {
+		t.Errorf("Expected token from environment, got %q", cfg.API.Token.Value())
+	}
+	if cfg.API.TLSKey.Value() != "-----KEY-----" {
+		t.Errorf("Expected key from file, got %q", cfg.API.TLSKey.Value())
+	}
+}
+
+func TestLoadMissingSecretEnv(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", "api:\n  token: env://DDD_TEST_UNSET_TOKEN\n")
+
+	if _, _, err := Load(path); err == nil {
+		t.Error("Expected unset environment secret to fail loading")
+	}
+}
+
+func TestLoadMigratesVersion1(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "old.yaml", "blocking:\n  block_time: 600\n")
+
+	cfg, warnings, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Blocking.BlockDuration != 10*time.Minute {
+		t.Errorf("Expected block_time to migrate to 10m, got %v", cfg.Blocking.BlockDuration)
+	}
+	if len(warnings) == 0 {
+		t.Error("Expected migration warnings for a version 1 file")
+	}
+}
+
+func TestLoadFragmentWithoutVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base.yaml", "detection:\n  rate_limit: 200\n")
+	path := writeFile(t, dir, "site.yaml", "version: 2\ninclude: [base.yaml]\nserver:\n  port: 5353\n")
+
+	cfg, warnings, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Detection.RateLimit != 200 {
+		t.Errorf("Expected the fragment loaded, got rate_limit %d", cfg.Detection.RateLimit)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings for a fragment without legacy keys, got %q", warnings)
+	}
+}
+
+func TestLoadRejectsNewerVersion(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "new.yaml", "version: 99\n")
+
+	if _, _, err := Load(path); err == nil {
+		t.Error("Expected config from a newer release to be rejected")
+	}
+}
+
+func TestApplyEnvAndRedaction(t *testing.T) {
+	t.Setenv("DDD_DETECTION_RATE_LIMIT", "42")
+	t.Setenv("DDD_SERVER_STATS_INTERVAL", "30s")
+	t.Setenv("DDD_API_TOKEN", "inline-token")
+
+	cfg := Default()
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Detection.RateLimit != 42 || cfg.Server.StatsInterval != 30*time.Second {
+		t.Errorf("Expected environment overrides to apply, got %+v %+v", cfg.Detection, cfg.Server)
+	}
+
+	effective, err := cfg.Effective()
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := effective["api"].(map[string]interface{})
+	if api["token"] != redacted {
+		t.Errorf("Expected inline token to be redacted, got %v", api["token"])
+	}
+}
+
+func TestWebhookURLsAreSecrets(t *testing.T) {
+	t.Setenv("DDD_TEST_WEBHOOK", "https://hooks.example/T000/secret")
+	dir := t.TempDir()
+	path := writeFile(t, dir, "notify.yaml", `
+policy:
+  url: https://decide.example/v1?key=secret
+notify:
+  zones:
+    - suffix: victim.example
+      webhook: https://abuse.victim.example/dns?token=secret
+    - suffix: other.example
+      webhook: env://DDD_TEST_WEBHOOK
+`)
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Notify.Zones[1].Webhook.Value(); got != "https://hooks.example/T000/secret" {
+		t.Errorf("Expected the webhook read from the environment, got %q", got)
+	}
+
+	effective, err := cfg.Effective()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones := effective["notify"].(map[string]interface{})["zones"].([]interface{})
+	if got := zones[0].(map[string]interface{})["webhook"]; got != redacted {
+		t.Errorf("Expected an inline webhook redacted, got %v", got)
+	}
+	if got := effective["policy"].(map[string]interface{})["url"]; got != redacted {
+		t.Errorf("Expected an inline policy URL redacted, got %v", got)
+	}
+}
+
+func TestValidateRetentionCoversWindow(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected defaults to be valid, got %v", err)
+	}
+
+	cfg.Monitor.Retention = 30 * time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected retention shorter than the detection window to be rejected")
+	}
+}
+
+func TestValidateHistoryCoversSensitivity(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "history.yaml", `
+monitor:
+  history_size: 40
+groups:
+  - name: lab
+    cidrs: [192.0.2.0/24]
+`)
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected 40 queries of history enough at medium sensitivity, got %v", err)
+	}
+
+	cfg.Detection.Sensitivity = "low"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected 40 queries of history rejected at low sensitivity")
+	}
+	cfg.Detection.Sensitivity = "loud"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an unknown sensitivity rejected")
+	}
+	cfg.Detection.Sensitivity = "medium"
+
+	if err := cfg.Groups[0].Detection.Encode(map[string]string{"sensitivity": "low"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "groups lab: monitor.history_size") {
+		t.Errorf("Expected the group's low sensitivity rejected, got %v", err)
+	}
+}
+
+func TestValidateMaxCNAMEChain(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		cfg := Default()
+		cfg.Server.MaxCNAMEChain = n
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected max_cname_chain %d rejected", n)
+		}
+	}
+}
+
+func TestValidateSeverityDurations(t *testing.T) {
+	cfg := Default()
+	cfg.Blocking.SeverityDurations = map[string]time.Duration{"high": time.Hour}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected a high severity duration to be valid, got %v", err)
+	}
+
+	cfg.Blocking.SeverityDurations = map[string]time.Duration{"critical": time.Hour}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an unknown severity to be rejected")
+	}
+}
+
+func TestValidateReporterTokens(t *testing.T) {
+	cfg := Default()
+	cfg.Reports.Reporters = []ReporterConfig{
+		{Name: "waf", Trust: TrustHigh, Token: InlineSecret("a")},
+		{Name: "ids", Trust: TrustLow, Token: InlineSecret("b")},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected reporters with tokens of their own to be valid, got %v", err)
+	}
+	if r, ok := cfg.Reports.ReporterFor("b"); !ok || r.Name != "ids" {
+		t.Errorf("Expected token b to identify ids, got %+v", r)
+	}
+	if _, ok := cfg.Reports.ReporterFor(""); ok {
+		t.Error("Expected no reporter for an empty token")
+	}
+
+	cfg.Reports.Reporters[1].Token = InlineSecret("a")
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a shared reporter token to be rejected")
+	}
+	cfg.Reports.Reporters[1].Token = Secret{}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a reporter without a token to be rejected")
+	}
+}
+
+func TestValidateAPIToken(t *testing.T) {
+	cfg := Default()
+	cfg.API.Listen = "127.0.0.1:8080"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an admin API without a token to be rejected")
+	}
+	cfg.API.Insecure = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected api.insecure to allow no token, got %v", err)
+	}
+	cfg.API.Insecure = false
+	cfg.API.Token = InlineSecret("s3cret")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected an admin API with a token to be valid, got %v", err)
+	}
+}
+
+func TestGroupDetectionInheritance(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "groups.yaml", `
+detection:
+  rate_limit: 100
+groups:
+  - name: campus
+    cidrs: [10.0.0.0/8]
+    mitigation: rate_limit
+    detection:
+      rate_limit: 400
+      new_client_limit: 80
+  - name: lab
+    parent: campus
+    cidrs: [10.1.0.0/16]
+    detection:
+      rate_limit: 1000
+`)
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	lab := cfg.Groups[1]
+	d, err := cfg.GroupDetection(lab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.RateLimit != 1000 || d.NewClientLimit != 80 || d.Window != cfg.Detection.Window {
+		t.Errorf("Expected own, parent and top-level settings merged, got %+v", d)
+	}
+	if got := cfg.GroupMitigation(lab); got != MitigationRateLimit {
+		t.Errorf("Expected the parent's mitigation inherited, got %s", got)
+	}
+	if cfg.Detection.RateLimit != 100 {
+		t.Errorf("Expected top-level detection untouched, got %d", cfg.Detection.RateLimit)
+	}
+
+	cfg.Groups[1].Fingerprints = []string{"0123456789ABCDEF0123456789abcdef"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an uppercase fingerprint rejected")
+	}
+	cfg.Groups[1].Fingerprints = nil
+
+	cfg.Groups[0].Parent = "lab"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a parent cycle rejected")
+	}
+}
+
+func TestValidateGroupCost(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "cost.yaml", `
+detection:
+  cost:
+    enabled: true
+groups:
+  - name: resolvers
+    cidrs: [10.0.0.0/8]
+    detection:
+      cost:
+        enabled: false
+  - name: heavy
+    cidrs: [192.0.2.0/24]
+    detection:
+      cost:
+        per_kib: 2
+`)
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a group's own cost weights rejected")
+	}
+	cfg.Groups = cfg.Groups[:1]
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a group to turn cost budgets off, got %v", err)
+	}
+	if d, _ := cfg.GroupDetection(cfg.Groups[0]); d.Cost.Enabled || d.Cost.Forwarded != 1 {
+		t.Errorf("Expected the group's cost budgets off with the default weights, got %+v", d.Cost)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	tests := []struct {
+		name      string
+		listeners []ListenerConfig
+		ok        bool
+	}{
+		{"dot with certificate", []ListenerConfig{{Protocol: ProtocolDoT, Listen: ":853", TLSCert: "cert.pem", TLSKey: Secret{ref: "key", value: "key"}}}, true},
+		{"plain doh", []ListenerConfig{{Protocol: ProtocolDoH, Listen: "127.0.0.1:8443", Path: "/q"}}, true},
+		{"extra udp port", []ListenerConfig{{Protocol: ProtocolUDP, Listen: ":5353"}}, true},
+		{"dot without certificate", []ListenerConfig{{Protocol: ProtocolDoT, Listen: ":853"}}, false},
+		{"doq", []ListenerConfig{{Protocol: ProtocolDoQ, Listen: ":853"}}, false},
+		{"unknown protocol", []ListenerConfig{{Protocol: "sctp", Listen: ":53"}}, false},
+		{"missing port", []ListenerConfig{{Protocol: ProtocolTCP, Listen: "127.0.0.1"}}, false},
+		{"same as server.port", []ListenerConfig{{Protocol: ProtocolUDP, Listen: ":8053"}}, false},
+		{"relative doh path", []ListenerConfig{{Protocol: ProtocolDoH, Listen: ":443", Path: "dns-query"}}, false},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.Server.Listeners = tt.listeners
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base.yaml", `
+profile: authoritative
+authoritative:
+  zones:
+    - name: example.com
+`)
+	site := writeFile(t, dir, "site.yaml", `
+include: [base.yaml]
+detection:
+  new_client_limit: 80
+`)
+
+	cfg, _, err := Load(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile's defaults are layered under every file, even one
+	// loaded before the profile was known
+	if cfg.Detection.RateLimit != Default().Detection.RateLimit*authoritativeScale {
+		t.Errorf("Expected the authoritative rate limit, got %d", cfg.Detection.RateLimit)
+	}
+	if !cfg.Authoritative.MinimalResponses || !cfg.Cache.NXDomainPatterns.Enabled {
+		t.Errorf("Expected minimal responses and NXDOMAIN synthesis, got %+v", cfg.Authoritative)
+	}
+	if cfg.Detection.NewClientLimit != 80 || len(cfg.Authoritative.Zones) != 1 {
+		t.Errorf("Expected the files to override the profile, got %d and %v",
+			cfg.Detection.NewClientLimit, cfg.Authoritative.Zones)
+	}
+
+	writeFile(t, dir, "bad.yaml", "profile: recursive\n")
+	if _, _, err := Load(filepath.Join(dir, "bad.yaml")); err == nil {
+		t.Error("Expected an unknown profile to be rejected")
+	}
+}
+
+func TestValidateCriticalQType(t *testing.T) {
+	cfg := Default()
+	cfg.Critical = []CriticalQuery{{Domain: "example.org"}, {Domain: "in-addr.arpa", QType: "ptr"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Critical = append(cfg.Critical, CriticalQuery{Domain: "example.com", QType: "AAA"})
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `"AAA"`) || !strings.Contains(err.Error(), "example.com") {
+		t.Errorf("Expected the unknown qtype rejected with its entry, got %v", err)
+	}
+}
+
+func TestValidateTransferPeers(t *testing.T) {
+	cfg := Default()
+	cfg.Authoritative.TransferPeers = []string{"192.0.2.1"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected transfer peers rejected outside the authoritative profile")
+	}
+
+	cfg.Profile = ProfileAuthoritative
+	cfg.Authoritative.TransferPeers = []string{"192.0.2.1", "2001:db8::/64"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if peers := cfg.Authoritative.TransferPrefixes(); len(peers) != 2 || peers[0].Bits() != 32 {
+		t.Errorf("Expected both peers parsed, got %v", peers)
+	}
+
+	cfg.Authoritative.TransferPeers = []string{"primary.example.com"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a hostname rejected as a transfer peer")
+	}
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces inline secret values in dumps
+const redacted = "[REDACTED]"
+
+// EnvPrefix is the prefix for environment variable overrides. Each config
+// key maps to PREFIX_SECTION_KEY, e.g. DDD_SERVER_PORT or DDD_API_TOKEN.
+const EnvPrefix = "DDD"
+
+// MarshalYAML redacts the secret. References are shown as configured since
+// they only name where the value lives.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s.ref == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(s.ref, "env://") || strings.HasPrefix(s.ref, "file://") {
+		return s.ref, nil
+	}
+	return redacted, nil
+}
+
+// Effective returns the resolved configuration as a nested map with secrets
+// redacted, suitable for logging or serving from the API
+func (c *Config) Effective() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ApplyEnv overrides config values from DDD_* environment variables. It is
+// applied after the config file and before command line flags.
+func (c *Config) ApplyEnv() error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix)
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	secretType   = reflect.TypeOf(Secret{})
+)
+
+// applyEnv walks the struct using yaml tags to build variable names
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" || tag == "include" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct && fv.Type() != secretType {
+			if err := applyEnv(fv, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(fv, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromString parses raw into the field according to its type
+func setFromString(fv reflect.Value, raw string) error {
+	switch {
+	case fv.Type() == secretType:
+		secret := Secret{ref: raw}
+		if err := secret.resolve(); err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(secret))
+	case fv.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+	case fv.Kind() == reflect.String:
+		fv.SetString(raw)
+	case fv.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(n))
+	case fv.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case fv.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
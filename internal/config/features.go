@@ -0,0 +1,72 @@
+package config
+
+// Features lists the optional features the configuration enables, for
+// version reports and support requests
+func (c *Config) Features() []string {
+	features := []string{}
+	add := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	add("authoritative", c.Profile == ProfileAuthoritative)
+	add("zone_budgets", len(c.Authoritative.Zones) > 0)
+	add("minimal_responses", c.Authoritative.MinimalResponses)
+	add("tcp", c.Server.TCP)
+	for _, protocol := range []string{ProtocolDoT, ProtocolDoH} {
+		add(protocol, c.Server.hasListener(protocol))
+	}
+	add("proxy_protocol", len(c.Server.TrustedProxies) > 0)
+	add("transparent", c.Server.Transparent)
+	add("cname_flatten", c.Server.CNAMEFlatten)
+	add("pair_qtypes", c.Server.PairQTypes)
+	add("cache", c.Cache.MaxEntries > 0)
+	add("cache_warm", c.Cache.Warm.enabled())
+	add("nxdomain_patterns", c.Cache.NXDomainPatterns.Enabled)
+	add("prefetch", c.Popularity.MaxDomains > 0)
+	add("privacy", len(c.Privacy.Upstreams) > 0)
+	add("emergency", c.Emergency.Enabled)
+	add("chaos", c.Chaos.Enabled)
+	add("integrity", c.Integrity.Enabled)
+	add("mobility", c.Mobility.Enabled)
+	add("dual_stack", c.DualStack.Enabled)
+	add("groups", len(c.Groups) > 0)
+	add("rewrite", len(c.Rewrite) > 0)
+	add("firewall", len(c.Firewall) > 0)
+	add("script", c.Script.File != "")
+	add("policy", c.Policy.URL.IsSet())
+	add("geoip", c.GeoIP.Database != "")
+	add("data_file_checks", c.DataFiles.PublicKey != "" || len(c.DataFiles.Checksums) > 0)
+	add("data_file_watch", c.DataFiles.Watch)
+	add("archive", c.Archive.Dir != "")
+	add("capture", c.Capture.Dir != "")
+	add("dataset", c.Dataset.File != "")
+	add("mirror", c.Mirror.Address != "")
+	add("journal", c.Journal.File != "")
+	for _, backend := range []string{BackendFile, BackendRedis, BackendEtcd} {
+		add("storage_"+backend, c.Storage.uses(backend))
+	}
+	add("notify", len(c.Notify.Zones) > 0)
+	add("slo", c.SLO.Enabled)
+	add("adaptive", c.Adaptive.Enabled)
+	add("cost_budgets", c.Detection.Cost.Enabled)
+	add("watch", len(c.Watch.Domains) > 0)
+	add("api", c.API.Listen != "")
+	add("public", c.Public.Listen != "")
+	add("federation", len(c.Federation.Peers) > 0)
+	add("block_feed", len(c.Federation.Subscribe) > 0)
+	add("replica", c.Federation.Replica)
+	add("sandbox", c.Sandbox.Landlock || c.Sandbox.Seccomp)
+	add("update_check", c.Update.Check)
+	return features
+}
+
+// hasListener reports whether a listener serves protocol
+func (s ServerConfig) hasListener(protocol string) bool {
+	for _, l := range s.Listeners {
+		if l.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ddd/internal/detector"
+)
+
+// ClientGroup gives a set of clients their own detection thresholds and
+// mitigation policy. A client belongs to the group listing its TLS
+// fingerprint, or else the group with the most specific CIDR containing
+// it. Groups form a hierarchy through Parent: a group's
+// Detection overrides are applied on top of its parent's, and those on
+// top of the top-level detection section.
+type ClientGroup struct {
+	Name   string `yaml:"name"`
+	Parent string `yaml:"parent,omitempty"`
+	// Tenant labels the group's detections in logs and metrics
+	Tenant string   `yaml:"tenant,omitempty"`
+	CIDRs  []string `yaml:"cidrs,omitempty"`
+	// CIDRFile lists further CIDRs, one per line with # comments, e.g. a
+	// reputation feed of known-bad ranges
+	CIDRFile string `yaml:"cidr_file,omitempty"`
+	// Fingerprints lists TLS client fingerprints (DoT and DoH clients) of
+	// client software to group wherever it connects from
+	Fingerprints []string `yaml:"fingerprints,omitempty"`
+	// Detection holds detection settings that differ from the parent's
+	Detection yaml.Node `yaml:"detection,omitempty"`
+	// Mitigation is block (act on detections as usual), rate_limit (never
+	// block) or monitor (log detections only); empty inherits the parent's
+	Mitigation string `yaml:"mitigation,omitempty"`
+}
+
+// Group mitigation policies
+const (
+	MitigationBlock     = "block"
+	MitigationRateLimit = "rate_limit"
+	MitigationMonitor   = "monitor"
+)
+
+// maxGroupDepth bounds the group hierarchy, which also catches cycles
+const maxGroupDepth = 16
+
+// GroupDetection returns the detection settings of group g: the top-level
+// settings overridden by each of its ancestors' and then its own
+func (c *Config) GroupDetection(g ClientGroup) (DetectionConfig, error) {
+	chain, err := c.groupChain(g)
+	if err != nil {
+		return DetectionConfig{}, err
+	}
+	d := c.Detection
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].Detection.Kind == 0 {
+			continue
+		}
+		if err := chain[i].Detection.Decode(&d); err != nil {
+			return DetectionConfig{}, fmt.Errorf("groups %s: detection: %w", chain[i].Name, err)
+		}
+	}
+	return d, nil
+}
+
+// GroupMitigation returns the mitigation policy of group g, inherited from
+// the nearest ancestor that sets one
+func (c *Config) GroupMitigation(g ClientGroup) string {
+	chain, _ := c.groupChain(g)
+	for _, group := range chain {
+		if group.Mitigation != "" {
+			return group.Mitigation
+		}
+	}
+	return MitigationBlock
+}
+
+// groupChain returns g followed by its ancestors
+func (c *Config) groupChain(g ClientGroup) ([]ClientGroup, error) {
+	chain := []ClientGroup{g}
+	for g.Parent != "" {
+		if len(chain) > maxGroupDepth {
+			return nil, fmt.Errorf("groups %s: parent chain too deep or cyclic", chain[0].Name)
+		}
+		parent, ok := c.group(g.Parent)
+		if !ok {
+			return nil, fmt.Errorf("groups %s: unknown parent %q", g.Name, g.Parent)
+		}
+		chain = append(chain, parent)
+		g = parent
+	}
+	return chain, nil
+}
+
+// group looks up a group by name
+func (c *Config) group(name string) (ClientGroup, bool) {
+	for _, g := range c.Groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return ClientGroup{}, false
+}
+
+// validateGroups checks names, parents, CIDRs and policies, and that each
+// group's merged detection settings decode
+func (c *Config) validateGroups() error {
+	seen := make(map[string]bool, len(c.Groups))
+	for _, g := range c.Groups {
+		switch {
+		case g.Name == "":
+			return fmt.Errorf("groups: every group needs a name")
+		case seen[g.Name]:
+			return fmt.Errorf("groups: duplicate group %q", g.Name)
+		case g.Mitigation != "" && g.Mitigation != MitigationBlock &&
+			g.Mitigation != MitigationRateLimit && g.Mitigation != MitigationMonitor:
+			return fmt.Errorf("groups %s: mitigation must be block, rate_limit or monitor, got %q", g.Name, g.Mitigation)
+		}
+		seen[g.Name] = true
+		for _, cidr := range g.CIDRs {
+			if _, err := ParseGroupCIDR(cidr); err != nil {
+				return fmt.Errorf("groups %s: %w", g.Name, err)
+			}
+		}
+		for _, fp := range g.Fingerprints {
+			if !validFingerprint(fp) {
+				return fmt.Errorf("groups %s: fingerprint must be 32 lowercase hex digits, got %q", g.Name, fp)
+			}
+		}
+		d, err := c.GroupDetection(g)
+		if err != nil {
+			return err
+		}
+		if d.Window < time.Second || d.Window > c.Monitor.Retention {
+			return fmt.Errorf("groups %s: detection.window must be between 1s and monitor.retention (%v), got %v", g.Name, c.Monitor.Retention, d.Window)
+		}
+		sensitivity, err := detector.ParseSensitivity(d.Sensitivity)
+		if err != nil {
+			return fmt.Errorf("groups %s: detection.sensitivity: %w", g.Name, err)
+		}
+		if c.Monitor.HistorySize < sensitivity.MinHistory() {
+			return fmt.Errorf("groups %s: monitor.history_size must be at least %d for pattern detection at %s sensitivity", g.Name, sensitivity.MinHistory(), sensitivity)
+		}
+		if cost := c.Detection.Cost; d.Cost.Enabled && (!cost.Enabled || d.Cost != cost) {
+			return fmt.Errorf("groups %s: detection.cost may only be turned off; costs are recorded with the top-level weights", g.Name)
+		}
+	}
+	return nil
+}
+
+// validFingerprint reports whether fp is a TLS client fingerprint: an MD5
+// in lowercase hex
+func validFingerprint(fp string) bool {
+	if len(fp) != 32 {
+		return false
+	}
+	for _, c := range fp {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseGroupCIDR parses a group CIDR or bare address
+func ParseGroupCIDR(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return prefix.Masked(), nil
+}
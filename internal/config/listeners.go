@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Listener protocols. DNS over QUIC is reserved for when a QUIC stack is
+// available and is rejected for now.
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+	ProtocolDoT = "dot"
+	ProtocolDoH = "doh"
+	ProtocolDoQ = "doq"
+)
+
+// DefaultDoHPath is the URL path DNS over HTTPS is served on by default
+const DefaultDoHPath = "/dns-query"
+
+// ListenerConfig adds a DNS listener beside the UDP listener on
+// server.port (and the TCP one with server.tcp)
+type ListenerConfig struct {
+	Protocol string `yaml:"protocol"` // udp, tcp, dot or doh
+	Listen   string `yaml:"listen"`   // address, e.g. ":853"
+	// TLSCert and TLSKey are the PEM certificate file and private key.
+	// DoT needs them; DoH without them serves plain HTTP for a TLS
+	// terminating proxy in front.
+	TLSCert string `yaml:"tls_cert,omitempty"`
+	TLSKey  Secret `yaml:"tls_key,omitempty"`
+	Path    string `yaml:"path,omitempty"` // DoH URL path (default /dns-query)
+}
+
+// Name identifies the listener in logs and metrics
+func (l ListenerConfig) Name() string {
+	return l.Protocol + "@" + l.Listen
+}
+
+// streamListeners reports whether any extra listener is served over TCP
+func (s ServerConfig) streamListeners() bool {
+	for _, l := range s.Listeners {
+		switch l.Protocol {
+		case ProtocolTCP, ProtocolDoT, ProtocolDoH:
+			return true
+		}
+	}
+	return false
+}
+
+// validateListeners checks each extra listener's protocol, address and
+// certificate
+func (s ServerConfig) validateListeners() error {
+	seen := map[string]bool{
+		ListenerConfig{Protocol: ProtocolUDP, Listen: fmt.Sprintf(":%d", s.Port)}.Name(): true,
+	}
+	if s.TCP {
+		seen[ListenerConfig{Protocol: ProtocolTCP, Listen: fmt.Sprintf(":%d", s.Port)}.Name()] = true
+	}
+	for _, l := range s.Listeners {
+		switch l.Protocol {
+		case ProtocolUDP, ProtocolTCP, ProtocolDoT, ProtocolDoH:
+		case ProtocolDoQ:
+			return fmt.Errorf("server.listeners: %s is not supported yet", ProtocolDoQ)
+		default:
+			return fmt.Errorf("server.listeners: protocol must be udp, tcp, dot or doh, got %q", l.Protocol)
+		}
+		if _, _, err := net.SplitHostPort(l.Listen); err != nil {
+			return fmt.Errorf("server.listeners: %s: invalid listen address: %w", l.Protocol, err)
+		}
+		switch {
+		case seen[l.Name()]:
+			return fmt.Errorf("server.listeners: duplicate listener %s", l.Name())
+		case l.Protocol == ProtocolDoT && (l.TLSCert == "" || !l.TLSKey.IsSet()):
+			return fmt.Errorf("server.listeners: %s needs tls_cert and tls_key", l.Name())
+		case (l.TLSCert == "") != !l.TLSKey.IsSet():
+			return fmt.Errorf("server.listeners: %s needs both tls_cert and tls_key, or neither", l.Name())
+		case l.TLSCert != "" && l.Protocol != ProtocolDoT && l.Protocol != ProtocolDoH:
+			return fmt.Errorf("server.listeners: %s takes no certificate", l.Name())
+		case l.Path != "" && (l.Protocol != ProtocolDoH || !strings.HasPrefix(l.Path, "/")):
+			return fmt.Errorf("server.listeners: %s: path must start with / and is for doh only", l.Name())
+		}
+		seen[l.Name()] = true
+	}
+	return nil
+}
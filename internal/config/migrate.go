@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the config schema version written by this release
+const CurrentVersion = 2
+
+// migration upgrades a raw config document from one schema version to the
+// next, returning warnings describing what changed
+type migration func(doc map[string]interface{}) ([]string, error)
+
+// migrations[v] upgrades a version v document to version v+1
+var migrations = map[int]migration{
+	1: migrateV1ToV2,
+}
+
+// migrate upgrades a config document to CurrentVersion. Files without a
+// version field predate versioning and are treated as version 1, as are
+// include fragments and profile overlays, which rarely carry one; a file
+// that no migration changes is returned as it is, without warnings.
+func migrate(path string, data []byte) ([]byte, []string, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if doc == nil {
+		return data, nil, nil
+	}
+
+	version := 1
+	if v, ok := doc["version"]; ok {
+		n, ok := v.(int)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: version must be an integer", path)
+		}
+		version = n
+	}
+
+	if version > CurrentVersion {
+		return nil, nil, fmt.Errorf("%s: config version %d is newer than supported version %d", path, version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return data, nil, nil
+	}
+
+	var warnings []string
+	for v := version; v < CurrentVersion; v++ {
+		changes, err := migrations[v](doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: migrating from version %d: %w", path, v, err)
+		}
+		for _, change := range changes {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", path, change))
+		}
+	}
+
+	if len(warnings) == 0 {
+		return data, nil, nil
+	}
+	warnings = append(warnings, fmt.Sprintf("%s: migrated from config version %d to %d; update the file to silence this warning", path, version, CurrentVersion))
+
+	doc["version"] = CurrentVersion
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out, warnings, nil
+}
+
+// migrateV1ToV2 replaces blocking.block_time (integer seconds) with
+// blocking.block_duration (a duration string such as "5m")
+func migrateV1ToV2(doc map[string]interface{}) ([]string, error) {
+	blocking, ok := doc["blocking"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	raw, ok := blocking["block_time"]
+	if !ok {
+		return nil, nil
+	}
+
+	seconds, ok := raw.(int)
+	if !ok {
+		return nil, fmt.Errorf("blocking.block_time must be an integer number of seconds")
+	}
+
+	delete(blocking, "block_time")
+	blocking["block_duration"] = (time.Duration(seconds) * time.Second).String()
+
+	return []string{"blocking.block_time is deprecated, use blocking.block_duration"}, nil
+}
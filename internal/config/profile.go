@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Deployment profiles. A profile picks the defaults a config file is
+// layered on; settings in the file still override them.
+const (
+	// ProfileResolver fronts a recursive resolver; clients are stub
+	// resolvers of individual hosts
+	ProfileResolver = "resolver"
+	// ProfileAuthoritative fronts an authoritative server; clients are
+	// recursive resolvers, each speaking for many users
+	ProfileAuthoritative = "authoritative"
+)
+
+// authoritativeScale is how many times a stub resolver's rate budgets a
+// recursive resolver gets in the authoritative profile
+const authoritativeScale = 10
+
+// AuthoritativeConfig protects an authoritative server the proxy fronts.
+// With zones listed, names outside them are refused and each zone's
+// queries are held to its budgets.
+type AuthoritativeConfig struct {
+	Zones []AuthZone `yaml:"zones"`
+	// Budgets of zones that set none of their own, in queries per second:
+	// all clients together (past it queries are dropped) and each client
+	// (past it UDP clients are told to retry over TCP). 0 is unlimited.
+	ZoneQPS   int `yaml:"zone_qps"`
+	ClientQPS int `yaml:"client_qps"`
+	// MinimalResponses strips the authority and additional records an
+	// answer does not need, and glue for names other than a referral's
+	// name servers, so responses give spoofed floods less to amplify
+	MinimalResponses bool `yaml:"minimal_responses"`
+	// TransferPeers are the primaries and secondaries of the zones (CIDRs
+	// or addresses). Their NOTIFY messages and AXFR/IXFR queries are
+	// relayed to the authoritative server instead of refused.
+	TransferPeers []string `yaml:"transfer_peers"`
+}
+
+// TransferPrefixes returns the parsed transfer peers. They must be valid,
+// as checked by Validate.
+func (a AuthoritativeConfig) TransferPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(a.TransferPeers))
+	for _, peer := range a.TransferPeers {
+		if prefix, err := ParseGroupCIDR(peer); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// AuthZone is a zone the authoritative server serves, with its budgets;
+// 0 takes the authoritative section's default
+type AuthZone struct {
+	Name      string `yaml:"name"`
+	QPS       int    `yaml:"qps"`
+	ClientQPS int    `yaml:"client_qps"`
+}
+
+// ProfileDefaults returns the defaults of a profile; empty is the
+// resolver profile
+func ProfileDefaults(profile string) (*Config, error) {
+	cfg := Default()
+	switch profile {
+	case "", ProfileResolver:
+	case ProfileAuthoritative:
+		cfg.Profile = ProfileAuthoritative
+		// A resolver asks for many users, retries little and has no use
+		// for prefetching or address pairing; random subdomains of the
+		// zones are the usual flood
+		cfg.Detection.RateLimit *= authoritativeScale
+		cfg.Detection.NewClientLimit *= authoritativeScale
+		cfg.Detection.NewDomainRate *= authoritativeScale
+		cfg.Popularity.PrefetchBefore = 0
+		cfg.Cache.NXDomainPatterns.Enabled = true
+		cfg.Authoritative.ClientQPS = 200
+		cfg.Authoritative.MinimalResponses = true
+	default:
+		return nil, fmt.Errorf("unknown profile %q (want %s or %s)", profile, ProfileResolver, ProfileAuthoritative)
+	}
+	return cfg, nil
+}
+
+// validate checks the zones and their budgets
+func (a AuthoritativeConfig) validate() error {
+	if a.ZoneQPS < 0 || a.ClientQPS < 0 {
+		return fmt.Errorf("authoritative.zone_qps and authoritative.client_qps must not be negative")
+	}
+	seen := make(map[string]bool)
+	for _, z := range a.Zones {
+		name := strings.ToLower(strings.TrimSuffix(z.Name, "."))
+		switch {
+		case name == "":
+			return fmt.Errorf("authoritative.zones: zone without a name")
+		case seen[name]:
+			return fmt.Errorf("authoritative.zones: %s listed twice", z.Name)
+		case z.QPS < 0 || z.ClientQPS < 0:
+			return fmt.Errorf("authoritative.zones: %s budgets must not be negative", z.Name)
+		}
+		seen[name] = true
+	}
+	for _, peer := range a.TransferPeers {
+		if _, err := ParseGroupCIDR(peer); err != nil {
+			return fmt.Errorf("authoritative.transfer_peers: %w", err)
+		}
+	}
+	return nil
+}
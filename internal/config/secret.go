@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a sensitive config value. In the config file it may be written
+// inline or as a reference that is resolved at load time:
+//
+//	file:///etc/ddd/api-token   read from a file (trailing newline trimmed)
+//	env://DDD_API_TOKEN         read from an environment variable
+type Secret struct {
+	ref   string
+	value string
+}
+
+// Value returns the resolved secret
+func (s Secret) Value() string {
+	return s.value
+}
+
+// Ref returns the reference the secret was configured with
+func (s Secret) Ref() string {
+	return s.ref
+}
+
+// IsSet reports whether the secret was configured
+func (s Secret) IsSet() bool {
+	return s.ref != ""
+}
+
+// UnmarshalYAML stores the raw reference; it is resolved by Load
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	var ref string
+	if err := node.Decode(&ref); err != nil {
+		return err
+	}
+	s.ref = ref
+	s.value = ""
+	return nil
+}
+
+// InlineSecret returns a secret holding value itself, as an inline
+// reference does
+func InlineSecret(value string) Secret {
+	return Secret{ref: value, value: value}
+}
+
+// ResolveSecret resolves a secret reference given outside the config
+// file, e.g. on a command line
+func ResolveSecret(ref string) (string, error) {
+	s := Secret{ref: ref}
+	if err := s.resolve(); err != nil {
+		return "", err
+	}
+	return s.value, nil
+}
+
+// resolve looks up the secret value for its reference
+func (s *Secret) resolve() error {
+	switch {
+	case s.ref == "":
+		s.value = ""
+
+	case strings.HasPrefix(s.ref, "env://"):
+		name := strings.TrimPrefix(s.ref, "env://")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", name)
+		}
+		s.value = value
+
+	case strings.HasPrefix(s.ref, "file://"):
+		data, err := os.ReadFile(strings.TrimPrefix(s.ref, "file://"))
+		if err != nil {
+			return err
+		}
+		s.value = strings.TrimRight(string(data), "\r\n")
+
+	default:
+		s.value = s.ref
+	}
+
+	return nil
+}
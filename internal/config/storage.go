@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Storage backends
+const (
+	BackendMemory = "memory" // kept in the process only; lost on restart
+	BackendFile   = "file"   // one file per component in storage.file.dir
+	BackendRedis  = "redis"
+	BackendEtcd   = "etcd"
+)
+
+// StorageConfig selects where each component's state is kept between
+// restarts and shared between instances. Every backend goes through the
+// same save and restore path; they differ only in durability.
+type StorageConfig struct {
+	// Interval is how often state is saved; it is also saved at shutdown
+	Interval   time.Duration `yaml:"interval"`
+	Blocks     string        `yaml:"blocks"`     // backend for the block list
+	Reputation string        `yaml:"reputation"` // backend for abuse reports
+	Baselines  string        `yaml:"baselines"`  // backend for answer baselines
+
+	File  FileStorageConfig  `yaml:"file"`
+	Redis RedisStorageConfig `yaml:"redis"`
+	Etcd  EtcdStorageConfig  `yaml:"etcd"`
+}
+
+// FileStorageConfig is the file backend
+type FileStorageConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+// RedisStorageConfig is the Redis backend
+type RedisStorageConfig struct {
+	Address  string        `yaml:"address"` // host:port
+	Username string        `yaml:"username,omitempty"`
+	Password Secret        `yaml:"password,omitempty"`
+	DB       int           `yaml:"db"`
+	Prefix   string        `yaml:"prefix"` // prepended to each key
+	Timeout  time.Duration `yaml:"timeout"`
+	// TLS connects over TLS, verifying the server against CA (a PEM file)
+	// or, when CA is empty, the system roots
+	TLS bool   `yaml:"tls"`
+	CA  string `yaml:"ca,omitempty"`
+}
+
+// EtcdStorageConfig is the etcd v3 backend, reached through its JSON
+// gateway
+type EtcdStorageConfig struct {
+	Endpoints []string      `yaml:"endpoints"` // e.g. http://10.0.0.5:2379; tried in order
+	Username  string        `yaml:"username,omitempty"`
+	Password  Secret        `yaml:"password,omitempty"`
+	Prefix    string        `yaml:"prefix"` // prepended to each key
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// Backends returns the backend of each component, by component name
+func (s StorageConfig) Backends() map[string]string {
+	return map[string]string{
+		"blocks":     s.Blocks,
+		"reputation": s.Reputation,
+		"baselines":  s.Baselines,
+	}
+}
+
+// uses reports whether any component is kept in backend
+func (s StorageConfig) uses(backend string) bool {
+	for _, b := range s.Backends() {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks each component's backend and the settings of the
+// backends in use
+func (s StorageConfig) validate() error {
+	for component, backend := range s.Backends() {
+		switch backend {
+		case BackendMemory, BackendFile, BackendRedis, BackendEtcd:
+		default:
+			return fmt.Errorf("storage.%s must be memory, file, redis or etcd, got %q", component, backend)
+		}
+	}
+	if s.Interval < time.Second {
+		return fmt.Errorf("storage.interval must be at least 1s, got %v", s.Interval)
+	}
+	if s.uses(BackendFile) && s.File.Dir == "" {
+		return fmt.Errorf("storage.file.dir is required for the file backend")
+	}
+	if s.uses(BackendRedis) {
+		if _, _, err := net.SplitHostPort(s.Redis.Address); err != nil {
+			return fmt.Errorf("storage.redis.address: %w", err)
+		}
+		if s.Redis.DB < 0 {
+			return fmt.Errorf("storage.redis.db must not be negative, got %d", s.Redis.DB)
+		}
+		if s.Redis.Timeout <= 0 {
+			return fmt.Errorf("storage.redis.timeout must be positive, got %v", s.Redis.Timeout)
+		}
+		if s.Redis.CA != "" && !s.Redis.TLS {
+			return fmt.Errorf("storage.redis.ca needs storage.redis.tls")
+		}
+	}
+	if s.uses(BackendEtcd) {
+		if len(s.Etcd.Endpoints) == 0 {
+			return fmt.Errorf("storage.etcd.endpoints is required for the etcd backend")
+		}
+		for _, e := range s.Etcd.Endpoints {
+			if !strings.HasPrefix(e, "http://") && !strings.HasPrefix(e, "https://") {
+				return fmt.Errorf("storage.etcd.endpoints must be http or https URLs, got %q", e)
+			}
+		}
+		if s.Etcd.Timeout <= 0 {
+			return fmt.Errorf("storage.etcd.timeout must be positive")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package datafile checks the data files the server loads policy from,
+// such as block lists and the GeoIP database, against checksums and
+// signatures in the configuration before they are loaded, so that a
+// tampered file cannot silently rewrite who is blocked or where clients
+// are placed.
+package datafile
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var checks = metrics.NewCounterVec("ddd_data_file_checks_total",
+	"Data file checks at load time, by kind and result", "kind", "result")
+
+// Check results
+const (
+	ResultVerified   = "verified"   // matched its checksum or signature
+	ResultUnverified = "unverified" // nothing to check it against
+	ResultMismatch   = "mismatch"
+)
+
+// maxSignature bounds the signature file read
+const maxSignature = 4096
+
+// errUnverified is the mismatch of a file with nothing to check it
+// against when data_files.require is set
+var errUnverified = errors.New("no checksum or signature key configured")
+
+// Checker verifies data files. A nil Checker verifies nothing.
+type Checker struct {
+	cfg config.DataFilesConfig
+	key ed25519.PublicKey
+	log *logger.Logger
+}
+
+// New creates a checker from the data_files configuration
+func New(cfg config.DataFilesConfig, log *logger.Logger) *Checker {
+	c := &Checker{cfg: cfg, log: log}
+	if cfg.PublicKey != "" {
+		c.key, _ = base64.StdEncoding.DecodeString(cfg.PublicKey) // checked by Validate
+	}
+	return c
+}
+
+// Check verifies path, a data file of the given kind (e.g. "blocklist"),
+// before it is loaded. A mismatch is an error with data_files.on_mismatch
+// refuse; with warn it is logged and Check returns nil.
+func (c *Checker) Check(kind, path string) error {
+	if c == nil {
+		return nil
+	}
+	result, digest, err := c.verify(path)
+	checks.With(kind, result).Inc()
+	switch {
+	case err == nil && result == ResultVerified:
+		c.log.Infow("Data file verified", "kind", kind, "file", path, "sha256", digest)
+		return nil
+	case err == nil:
+		return nil
+	case c.cfg.OnMismatch == config.MismatchWarn:
+		c.log.Warnw("Data file failed verification, loading it anyway", "kind", kind, "file", path, "sha256", digest, "error", err)
+		return nil
+	}
+	return fmt.Errorf("%s %s failed verification: %w", kind, path, err)
+}
+
+// verify returns the result of checking path, its SHA-256 digest and why
+// it does not match
+func (c *Checker) verify(path string) (result, digest string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ResultMismatch, "", err
+	}
+	sum := sha256.Sum256(data)
+	digest = hex.EncodeToString(sum[:])
+
+	want, hasSum := c.cfg.Checksums[path]
+	if !hasSum && c.key == nil {
+		if c.cfg.Require {
+			return ResultMismatch, digest, errUnverified
+		}
+		return ResultUnverified, digest, nil
+	}
+	if hasSum && !strings.EqualFold(want, digest) {
+		return ResultMismatch, digest, fmt.Errorf("checksum mismatch: want %s", strings.ToLower(want))
+	}
+	if c.key != nil {
+		if err := c.verifySignature(path, data); err != nil {
+			return ResultMismatch, digest, err
+		}
+	}
+	return ResultVerified, digest, nil
+}
+
+// verifySignature checks data against the signature in path.sig
+func (c *Checker) verifySignature(path string, data []byte) error {
+	f, err := os.Open(path + ".sig")
+	if err != nil {
+		return fmt.Errorf("reading signature: %w", err)
+	}
+	defer f.Close()
+	text, err := io.ReadAll(io.LimitReader(f, maxSignature))
+	if err != nil {
+		return fmt.Errorf("reading signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%s.sig is not a base64 Ed25519 signature", path)
+	}
+	if !ed25519.Verify(c.key, data, sig) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package datafile
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "blocklist.txt")
+	writeFile(t, list, "192.0.2.0/24\n")
+	sum := sha256.Sum256([]byte("192.0.2.0/24\n"))
+
+	cfg := config.Default().DataFiles
+	cfg.Checksums = map[string]string{list: hex.EncodeToString(sum[:])}
+	c := New(cfg, logger.NewNop())
+	if err := c.Check("blocklist", list); err != nil {
+		t.Fatalf("Expected the file to match its checksum, got %v", err)
+	}
+
+	// A rewritten file is refused, or only warned about
+	writeFile(t, list, "0.0.0.0/0\n")
+	if err := c.Check("blocklist", list); err == nil {
+		t.Error("Expected a tampered file to be refused")
+	}
+	cfg.OnMismatch = config.MismatchWarn
+	if err := New(cfg, logger.NewNop()).Check("blocklist", list); err != nil {
+		t.Errorf("Expected a warning only, got %v", err)
+	}
+
+	// Files with nothing to check against pass unless required
+	other := filepath.Join(dir, "geoip.csv")
+	writeFile(t, other, "")
+	if err := c.Check("geoip", other); err != nil {
+		t.Errorf("Expected an unlisted file to load, got %v", err)
+	}
+	cfg.OnMismatch, cfg.Require = config.MismatchRefuse, true
+	if err := New(cfg, logger.NewNop()).Check("geoip", other); err == nil {
+		t.Error("Expected an unlisted file to be refused when required")
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	list := filepath.Join(dir, "blocklist.txt")
+	writeFile(t, list, "198.51.100.7\n")
+
+	cfg := config.Default().DataFiles
+	cfg.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	c := New(cfg, logger.NewNop())
+	if err := c.Check("blocklist", list); err == nil {
+		t.Error("Expected a file without a signature to be refused")
+	}
+
+	writeFile(t, list+".sig", base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("198.51.100.7\n")))+"\n")
+	if err := c.Check("blocklist", list); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	writeFile(t, list, "198.51.100.8\n")
+	if err := c.Check("blocklist", list); err == nil {
+		t.Error("Expected a file changed after signing to be refused")
+	}
+}
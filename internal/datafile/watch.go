@@ -0,0 +1,131 @@
+package datafile
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"ddd/internal/metrics"
+)
+
+var reloads = metrics.NewCounterVec("ddd_data_file_reloads_total",
+	"Data files reloaded after they changed, by kind and result (applied, rejected)", "kind", "result")
+
+// settle is how long a changed file must stay unchanged before it is
+// reloaded, so that an editor's several writes apply once
+var settle = time.Second
+
+// Reloader applies a data file again after it changed on disk
+type Reloader struct {
+	Kind string // as passed to Check
+	Path string
+	// Load reads and applies the file. It must leave what was loaded
+	// before in effect if it returns an error.
+	Load func(path string) error
+}
+
+// Watcher reloads data files when they or their signatures change
+type Watcher struct {
+	checker   *Checker
+	reloaders map[string][]Reloader
+	watcher   *fsnotify.Watcher
+	// files maps the absolute path of each watched file, and of its
+	// signature, to the paths as the reloaders give them
+	files map[string][]string
+}
+
+// Watch starts watching the files of reloaders and their path.sig
+// signatures. It watches their directories, which outlive files replaced
+// by a rename, and is called before the sandbox is applied; Run then
+// reloads the files as they change.
+func (c *Checker) Watch(reloaders []Reloader) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{checker: c, reloaders: make(map[string][]Reloader), watcher: fw, files: make(map[string][]string)}
+	watched := make(map[string]bool)
+	for _, r := range reloaders {
+		if _, ok := w.reloaders[r.Path]; !ok {
+			abs, err := filepath.Abs(r.Path)
+			if err != nil {
+				fw.Close()
+				return nil, err
+			}
+			if dir := filepath.Dir(abs); !watched[dir] {
+				if err := fw.Add(dir); err != nil {
+					fw.Close()
+					return nil, err
+				}
+				watched[dir] = true
+			}
+			w.files[abs] = append(w.files[abs], r.Path)
+			w.files[abs+".sig"] = append(w.files[abs+".sig"], r.Path)
+		}
+		w.reloaders[r.Path] = append(w.reloaders[r.Path], r)
+	}
+	return w, nil
+}
+
+// Run reloads each file once it and its signature have settled after a
+// change, until ctx is done. A file that fails its check or does not load
+// is rejected and the previous contents stay in effect.
+func (w *Watcher) Run(ctx context.Context) {
+	defer w.watcher.Close()
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(settle)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			// A file written in place, or created or moved over the
+			// watched one as editors and atomic writers do
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			paths := w.files[filepath.Clean(event.Name)]
+			for _, path := range paths {
+				pending[path] = true
+			}
+			if len(paths) > 0 {
+				timer.Reset(settle)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.checker.log.Warnw("Watching data files failed", "error", err)
+		case <-timer.C:
+			for path := range pending {
+				for _, r := range w.reloaders[path] {
+					w.reload(r)
+				}
+			}
+			clear(pending)
+		}
+	}
+}
+
+// reload checks and loads the file of r
+func (w *Watcher) reload(r Reloader) {
+	err := w.checker.Check(r.Kind, r.Path)
+	if err == nil {
+		err = r.Load(r.Path)
+	}
+	if err != nil {
+		reloads.With(r.Kind, "rejected").Inc()
+		w.checker.log.Errorw("Changed data file rejected, keeping the previous contents", "kind", r.Kind, "file", r.Path, "error", err)
+		return
+	}
+	reloads.With(r.Kind, "applied").Inc()
+	w.checker.log.Infow("Changed data file reloaded", "kind", r.Kind, "file", r.Path)
+}
@@ -0,0 +1,98 @@
+package datafile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+)
+
+func TestWatchReloadsChangedFiles(t *testing.T) {
+	settle = 50 * time.Millisecond
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocklist.txt")
+	writeFile(t, path, "203.0.113.7\n")
+
+	loaded := make(chan string, 4)
+	c := New(config.DataFilesConfig{OnMismatch: config.MismatchRefuse}, logger.NewNop())
+	w, err := c.Watch([]Reloader{{Kind: "blocklist", Path: path, Load: func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if string(data) == "garbage\n" {
+			return errors.New("invalid")
+		}
+		loaded <- string(data)
+		return nil
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// Written in place, then replaced by a rename
+	writeFile(t, path, "198.51.100.1\n")
+	if got := waitLoad(t, loaded); got != "198.51.100.1\n" {
+		t.Errorf("Expected the rewritten file loaded, got %q", got)
+	}
+	tmp := filepath.Join(dir, ".blocklist.tmp")
+	writeFile(t, tmp, "192.0.2.1\n")
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitLoad(t, loaded); got != "192.0.2.1\n" {
+		t.Errorf("Expected the replaced file loaded, got %q", got)
+	}
+
+	// Other files in the directory are ignored
+	writeFile(t, filepath.Join(dir, "other.txt"), "x\n")
+	writeFile(t, path, "garbage\n")
+	select {
+	case got := <-loaded:
+		t.Errorf("Expected nothing loaded, got %q", got)
+	case <-time.After(5 * settle):
+	}
+}
+
+func TestWatchReloadsOnNewSignature(t *testing.T) {
+	settle = 50 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeFile(t, path, "203.0.113.7\n")
+
+	loaded := make(chan string, 4)
+	c := New(config.DataFilesConfig{OnMismatch: config.MismatchRefuse}, logger.NewNop())
+	w, err := c.Watch([]Reloader{{Kind: "blocklist", Path: path, Load: func(path string) error {
+		loaded <- path
+		return nil
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	writeFile(t, path+".sig", "c2lnbmF0dXJl\n")
+	if got := waitLoad(t, loaded); got != path {
+		t.Errorf("Expected %s reloaded for its new signature, got %q", path, got)
+	}
+}
+
+func waitLoad(t *testing.T, loaded <-chan string) string {
+	t.Helper()
+	select {
+	case got := <-loaded:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the changed file to be reloaded")
+		return ""
+	}
+}
@@ -0,0 +1,293 @@
+// Package dataset exports labeled feature vectors for offline model
+// training. Each row holds what the detector knew about a client when it
+// decided, the verdict and mitigation, and an outcome label settled by the
+// client's behaviour afterwards: an attack is confirmed when the client is
+// flagged again and reversed when an operator unblocks it; a benign
+// decision is reversed when the client is flagged later.
+package dataset
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"math"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	rowsWritten = metrics.NewCounterVec("ddd_dataset_rows_total",
+		"Feature vectors exported, by outcome label", "outcome")
+	rowsDropped = metrics.NewCounter("ddd_dataset_rows_dropped_total",
+		"Feature vectors dropped because too many were awaiting confirmation")
+)
+
+// Outcome labels
+const (
+	Confirmed   = "confirmed"   // later behaviour agrees with the decision
+	Reversed    = "reversed"    // later behaviour contradicts it
+	Unconfirmed = "unconfirmed" // an attack nothing has corroborated yet
+)
+
+// header names the CSV columns
+var header = []string{
+	"time", "client", "qname", "qtype", "qname_length", "labels",
+	"requests", "failures", "new_domains", "client_age_seconds", "client_requests",
+	"verdict", "severity", "decision", "outcome",
+}
+
+// Sample is one detection decision
+type Sample struct {
+	Time   time.Time
+	Client string
+	QName  string
+	QType  string
+
+	// Client features at decision time, over the detection window
+	Requests       int
+	Failures       int
+	NewDomains     int
+	ClientAge      time.Duration
+	ClientRequests int // since the client was first seen
+
+	Verdict  string // attack type, empty for benign traffic
+	Severity string
+	Decision string // block, rate_limit or allow; empty for benign traffic
+}
+
+// row is a sample awaiting its outcome
+type row struct {
+	Sample
+	flagged   bool // the client was flagged again afterwards
+	unblocked bool // an operator unblocked the client afterwards
+}
+
+// outcome labels the row from what happened since
+func (r *row) outcome() string {
+	switch {
+	case r.Verdict == "" && r.flagged, r.Verdict != "" && r.unblocked:
+		return Reversed
+	case r.Verdict == "" || r.flagged:
+		return Confirmed
+	}
+	return Unconfirmed
+}
+
+// Exporter writes labeled samples to a CSV file once their confirmation
+// window has passed
+type Exporter struct {
+	cfg config.DatasetConfig
+	key []byte
+	log *logger.Logger
+
+	mu      sync.Mutex
+	file    *os.File
+	w       *csv.Writer
+	pending []*row            // in arrival order
+	clients map[string][]*row // pending rows by client
+}
+
+// New opens cfg.File for appending, writing the header if it is new
+func New(cfg config.DatasetConfig, log *logger.Logger) (*Exporter, error) {
+	file, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	e := &Exporter{
+		cfg:     cfg,
+		key:     []byte(cfg.HashKey.Value()),
+		log:     log,
+		file:    file,
+		w:       csv.NewWriter(file),
+		clients: make(map[string][]*row),
+	}
+	if len(e.key) == 0 {
+		e.key = make([]byte, 32)
+		rand.Read(e.key)
+	}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		e.w.Write(header)
+		e.w.Flush()
+	}
+	return e, nil
+}
+
+// Wants reports whether a decision should be sampled: every attack, and
+// benign traffic at the configured rate. It is false on a nil exporter,
+// so callers can skip gathering features.
+func (e *Exporter) Wants(attack bool) bool {
+	if e == nil {
+		return false
+	}
+	return attack || mathrand.Float64() < e.cfg.SampleRate
+}
+
+// Record queues a sample until its outcome is known. An attack counts as
+// the client being flagged again for its earlier pending rows.
+func (e *Exporter) Record(s Sample) {
+	if e == nil {
+		return
+	}
+	if s.Time.IsZero() {
+		s.Time = time.Now()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if s.Verdict != "" {
+		for _, r := range e.clients[s.Client] {
+			r.flagged = true
+		}
+	}
+	if e.cfg.MaxPending > 0 && len(e.pending) >= e.cfg.MaxPending {
+		rowsDropped.Inc()
+		return
+	}
+	r := &row{Sample: s}
+	e.pending = append(e.pending, r)
+	e.clients[s.Client] = append(e.clients[s.Client], r)
+}
+
+// Handle marks the pending attack rows of a client an operator unblocked
+func (e *Exporter) Handle(ev events.Event) {
+	if ev.Type != events.IPUnblocked {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.clients[ev.IP] {
+		r.unblocked = true
+	}
+}
+
+// Run writes rows whose confirmation window has passed until ctx is
+// cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(max(e.cfg.ConfirmAfter/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.flush(now.Add(-e.cfg.ConfirmAfter))
+		}
+	}
+}
+
+// Close writes every pending row, labeled with what is known so far, and
+// closes the file. It is safe to call on a nil exporter.
+func (e *Exporter) Close() {
+	if e == nil {
+		return
+	}
+	e.flush(time.Now())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.file.Close()
+}
+
+// flush writes the rows recorded before cutoff
+func (e *Exporter) flush(cutoff time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := 0
+	for n < len(e.pending) && !e.pending[n].Time.After(cutoff) {
+		r := e.pending[n]
+		outcome := r.outcome()
+		e.w.Write(e.record(r, outcome))
+		rowsWritten.With(outcome).Inc()
+
+		rows := e.clients[r.Client][1:]
+		if len(rows) == 0 {
+			delete(e.clients, r.Client)
+		} else {
+			e.clients[r.Client] = rows
+		}
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	e.pending = append(e.pending[:0:0], e.pending[n:]...)
+
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		e.log.Errorw("Failed to write dataset", "file", e.cfg.File, "error", err)
+	}
+}
+
+// record formats a row as CSV fields
+func (e *Exporter) record(r *row, outcome string) []string {
+	qname := ""
+	if e.cfg.QNames {
+		qname = r.QName
+	}
+	return []string{
+		r.Time.UTC().Format(time.RFC3339Nano),
+		e.client(r.Client),
+		qname,
+		r.QType,
+		strconv.Itoa(len(r.QName)),
+		strconv.Itoa(labels(r.QName)),
+		strconv.Itoa(r.Requests),
+		strconv.Itoa(r.Failures),
+		strconv.Itoa(r.NewDomains),
+		strconv.FormatInt(int64(math.Round(r.ClientAge.Seconds())), 10),
+		strconv.Itoa(r.ClientRequests),
+		r.Verdict,
+		r.Severity,
+		r.Decision,
+		outcome,
+	}
+}
+
+// client writes a client address as the privacy settings allow
+func (e *Exporter) client(ip string) string {
+	switch e.cfg.Client {
+	case "raw":
+		return ip
+	case "omit":
+		return ""
+	case "prefix":
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return ""
+		}
+		if v4 := addr.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+		return addr.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	}
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// labels counts the labels of a name without its trailing dot
+func labels(name string) int {
+	if name == "" {
+		return 0
+	}
+	n := 1
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package dataset
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+)
+
+func TestExportLabels(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dataset.csv")
+	cfg := config.DatasetConfig{File: file, ConfirmAfter: time.Minute, Client: "prefix"}
+	e, err := New(cfg, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	attack := func(client string, s int) Sample {
+		return Sample{Time: at(s), Client: client, QName: "x1.example.com", QType: "A", Requests: 500,
+			Verdict: "high_request_rate", Severity: "high", Decision: "block"}
+	}
+
+	e.Record(Sample{Time: at(0), Client: "192.0.2.1", QName: "example.com", QType: "A", Requests: 3}) // flagged later
+	e.Record(attack("192.0.2.1", 1))                                                                  // flagged again
+	e.Record(attack("192.0.2.1", 2))                                                                  // nothing since
+	e.Record(attack("198.51.100.7", 3))                                                               // unblocked
+	e.Handle(events.Event{Type: events.IPUnblocked, IP: "198.51.100.7"})
+	e.Record(Sample{Time: at(4), Client: "2001:db8::1", QName: "example.org", QType: "AAAA"}) // benign
+
+	e.flush(at(3))
+	if len(e.pending) != 1 || len(e.clients) != 1 {
+		t.Errorf("Expected one row left pending, got %d (%d clients)", len(e.pending), len(e.clients))
+	}
+	e.Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 || len(rows[0]) != len(header) {
+		t.Fatalf("Expected a header and 5 rows, got %v", rows)
+	}
+
+	col := func(name string) int {
+		for i, h := range header {
+			if h == name {
+				return i
+			}
+		}
+		t.Fatalf("No column %s", name)
+		return -1
+	}
+	want := []struct{ client, outcome string }{
+		{"192.0.2.0/24", Reversed},
+		{"192.0.2.0/24", Confirmed},
+		{"192.0.2.0/24", Unconfirmed},
+		{"198.51.100.0/24", Reversed},
+		{"2001:db8::/48", Confirmed},
+	}
+	for i, w := range want {
+		row := rows[i+1]
+		if row[col("client")] != w.client || row[col("outcome")] != w.outcome {
+			t.Errorf("Row %d: client %s outcome %s, want %s %s", i, row[col("client")], row[col("outcome")], w.client, w.outcome)
+		}
+		if row[col("qname")] != "" {
+			t.Errorf("Row %d: expected query names left out, got %q", i, row[col("qname")])
+		}
+	}
+	if rows[2][col("labels")] != "3" || rows[2][col("requests")] != "500" || rows[2][col("decision")] != "block" {
+		t.Errorf("Unexpected features %v", rows[2])
+	}
+}
+
+func TestClientPrivacy(t *testing.T) {
+	cfg := config.DatasetConfig{File: filepath.Join(t.TempDir(), "dataset.csv"), Client: "hash"}
+	e, err := New(cfg, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	hashed := e.client("192.0.2.1")
+	if len(hashed) != 16 || hashed == e.client("192.0.2.2") || hashed != e.client("192.0.2.1") {
+		t.Errorf("Expected a stable per-client hash, got %q", hashed)
+	}
+	e.cfg.Client = "omit"
+	if c := e.client("192.0.2.1"); c != "" {
+		t.Errorf("Expected the client omitted, got %q", c)
+	}
+
+	var none *Exporter
+	if none.Wants(true) {
+		t.Error("Expected a nil exporter to want nothing")
+	}
+}
@@ -0,0 +1,44 @@
+package detector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestCostBudgets(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tm := monitor.NewTrafficMonitor().WithClock(func() time.Time { return now })
+	d := NewDDoSDetectorWithThresholds(Thresholds{RateLimit: 50, Window: time.Minute, CostBudgets: true}, logger.NewNop())
+
+	// Many cheap cache hits stay within the budget
+	for i := 0; i < 80; i++ {
+		tm.RecordRequest("192.0.2.1", fmt.Sprintf("cached%d.example.com", i%5), "A")
+		tm.RecordCost("192.0.2.1", 0.2)
+	}
+	if result := d.AnalyzeTraffic("192.0.2.1", tm); result.AttackType == "high_request_rate" {
+		t.Errorf("Expected 80 cache hits (16 units) within a budget of 50, got %+v", result)
+	}
+
+	// Fewer expensive queries, with large answers, exceed it
+	for i := 0; i < 30; i++ {
+		tm.RecordRequest("192.0.2.2", fmt.Sprintf("big%d.example.com", i), "TXT")
+		tm.RecordCost("192.0.2.2", 1)
+		tm.RecordCost("192.0.2.2", 0.5*3)
+	}
+	result := d.AnalyzeTraffic("192.0.2.2", tm)
+	if !result.IsAttack || result.AttackType != "high_request_rate" || result.Description != "Excessive query cost detected" {
+		t.Errorf("Expected 30 large forwarded queries (75 units) over a budget of 50, got %+v", result)
+	}
+
+	// A client charged nothing yet is counted by its queries
+	for i := 0; i < 60; i++ {
+		tm.RecordRequest("192.0.2.3", fmt.Sprintf("n%d.example.com", i), "A")
+	}
+	if result := d.AnalyzeTraffic("192.0.2.3", tm); result.AttackType != "high_request_rate" {
+		t.Error("Expected an uncharged client to be held to its query count")
+	}
+}
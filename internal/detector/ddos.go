@@ -1,217 +1,502 @@
 package detector
 
 import (
+	"fmt"
+	"math"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"ddd/internal/abuse"
 	"ddd/internal/logger"
 	"ddd/internal/monitor"
+	"ddd/internal/severity"
 )
 
-// DDoSDetector detects various DDoS attack patterns
+// MinHistory is the number of recent queries per IP the pattern checks
+// need; a monitor keeping less history silently disables them
+const MinHistory = 30
+
+// Thresholds holds the detector's tunable limits
+type Thresholds struct {
+	RateLimit int           // Max requests per minute
+	Window    time.Duration // Analysis window for all checks
+
+	// Clients first seen less than NewClientWindow ago may make at most
+	// NewClientLimit requests; a zero window disables the check
+	NewClientWindow time.Duration
+	NewClientLimit  int
+
+	// Regular timing check: with more than TimingMinSamples queries in the
+	// window, intervals with a coefficient of variation at or below
+	// TimingMaxCV, or autocorrelation at or above TimingMinAutocorrelation,
+	// are flagged. Zero TimingMinSamples disables the check.
+	TimingMinSamples         int
+	TimingMaxCV              float64
+	TimingMinAutocorrelation float64
+
+	// NewDomainRate limits how many names no client had queried before a
+	// single client may introduce per minute; GlobalNewDomainRate raises
+	// an alert when all clients together exceed it. Zero disables either.
+	// Both need the monitor to track seen names (Retention.SeenDomains).
+	NewDomainRate       int
+	GlobalNewDomainRate int
+
+	// ProtocolAbuseLimit is how many malformed or abusive messages
+	// (unsupported opcodes, multiple questions, EDNS option abuse) a client
+	// may send per window before it is blocked; below it, the client is
+	// rate limited
+	ProtocolAbuseLimit int
+
+	// HomographLimit is how many distinct confusable or mixed-script
+	// internationalized names (homographs of Latin ones) a client may
+	// query per window; zero disables the check
+	HomographLimit int
+
+	// Each SERVFAIL or REFUSED answer a client's queries caused within
+	// the window takes FailurePenalty requests off its rate budget, down to
+	// FailureFloor of the full budget. Zero FailurePenalty disables it.
+	FailurePenalty float64
+	FailureFloor   float64
+
+	// CostBudgets counts the rate budget in the units of cost the monitor
+	// was charged for a client's queries rather than in queries
+	CostBudgets bool
+
+	// PatternScale multiplies the built-in repeated query, random
+	// subdomain and burst thresholds; zero means 1 (see Sensitivity)
+	PatternScale float64
+
+	// Noise is benign traffic the repeated query and timing checks
+	// discount
+	Noise Noise
+
+	// CheckBudget is how long each pattern check may run for one client
+	// before the watchdog abandons it; zero disables the watchdog
+	CheckBudget time.Duration
+}
+
+// DDoSDetector detects various DDoS attack patterns. The rate and
+// pattern checks are the generic abuse detectors applied to clients and
+// the domains they query; the remaining checks are DNS specific.
 type DDoSDetector struct {
-	rateLimit int // Max requests per minute
-	log       *logger.Logger
+	thresholds Thresholds
+
+	window          time.Duration
+	newClientWindow time.Duration
+	newClientLimit  int
+
+	newDomainRate       int
+	globalNewDomainRate int
+	lastGlobalAlert     atomic.Int64 // unix nanoseconds
+
+	protocolAbuseLimit int
+	homographLimit     int
+
+	failurePenalty float64
+	failureFloor   float64
+	costBudgets    bool
+
+	rate     abuse.Rate
+	patterns *abuse.Engine
+
+	stormMinQueries int
+	advisor         *advisor
+
+	// load returns the share of the rate and new client budgets clients
+	// get under the server's current load; nil gives them all
+	load func() float64
+
+	log *logger.Logger
 }
 
-// NewDDoSDetector creates a new DDoS detector
+// logReasons names pattern findings in detection log entries
+var logReasons = map[string]string{
+	"repeated_queries": "repeated queries",
+	"random_subdomain": "random subdomain attack",
+	"query_burst":      "query burst",
+	"regular_timing":   "regular timing",
+}
+
+// NewDDoSDetector creates a new DDoS detector with a one minute window
 func NewDDoSDetector(rateLimit int, log *logger.Logger) *DDoSDetector {
-	return &DDoSDetector{
-		rateLimit: rateLimit,
-		log:       log,
+	return NewDDoSDetectorWithThresholds(Thresholds{RateLimit: rateLimit, Window: time.Minute}, log)
+}
+
+// NewDDoSDetectorWithThresholds creates a new DDoS detector
+func NewDDoSDetectorWithThresholds(t Thresholds, log *logger.Logger) *DDoSDetector {
+	if t.PatternScale <= 0 {
+		t.PatternScale = 1
+	}
+	scaled := func(n int) int { return scaleCount(n, t.PatternScale) }
+	benign := t.Noise.classifier()
+
+	d := &DDoSDetector{
+		thresholds:      t,
+		window:          t.Window,
+		newClientWindow: t.NewClientWindow,
+		newClientLimit:  t.NewClientLimit,
+
+		newDomainRate:       t.NewDomainRate,
+		globalNewDomainRate: t.GlobalNewDomainRate,
+
+		protocolAbuseLimit: t.ProtocolAbuseLimit,
+		homographLimit:     t.HomographLimit,
+
+		failurePenalty: t.FailurePenalty,
+		failureFloor:   t.FailureFloor,
+		costBudgets:    t.CostBudgets,
+
+		// The per-minute limit scaled to the window
+		rate: abuse.Rate{
+			Limit: int(float64(t.RateLimit) * t.Window.Minutes()),
+		},
+		patterns: abuse.NewEngine(t.Window,
+			abuse.Repeat{
+				Name:        "repeated_queries",
+				Description: "Repeated queries to same domain detected",
+				MinRequests: scaled(20),
+				MinCount:    scaled(10),
+				Benign:      benign,
+			},
+			abuse.Random{
+				Name:        "random_subdomain",
+				Description: "Random subdomain attack detected",
+				MinRequests: scaled(30),
+				MaxUnique:   scaled(20),
+				MaxRandom:   scaled(10),
+				Parent:      abuse.SuffixParent(".", 2),
+			},
+			abuse.Burst{
+				Name:        "query_burst",
+				Description: "Query burst detected",
+				MinRequests: scaled(10),
+				Span:        10 * time.Second,
+				Limit:       scaled(50),
+			},
+			abuse.Timing{
+				Name:               "regular_timing",
+				Description:        "Regular query timing detected",
+				MinSamples:         t.TimingMinSamples,
+				MaxCV:              t.TimingMaxCV,
+				MinAutocorrelation: t.TimingMinAutocorrelation,
+				Benign:             benign,
+			},
+		),
+
+		stormMinQueries: scaled(stormMinQueries),
+		advisor:         &advisor{window: t.Window, advised: make(map[string]time.Time)},
+
+		log: log,
+	}
+	if t.CostBudgets {
+		d.rate.Description = d.rateDescription()
 	}
+	d.patterns.WithBudget(t.CheckBudget, d.overrun)
+	return d
+}
+
+// WithLoad scales the request rate and new client budgets by the share
+// load returns, so that they tighten while the server is loaded
+func (d *DDoSDetector) WithLoad(load func() float64) *DDoSDetector {
+	d.load = load
+	return d
+}
+
+// underLoad returns n scaled to the current load, at least 1
+func (d *DDoSDetector) underLoad(n int) int {
+	if d.load == nil || n <= 0 {
+		return n
+	}
+	f := d.load()
+	if f >= 1 {
+		return n
+	}
+	return int(math.Max(float64(n)*f, 1))
+}
+
+// Thresholds returns the limits the detector was created with
+func (d *DDoSDetector) Thresholds() Thresholds {
+	return d.thresholds
 }
 
 // DetectionResult holds the result of DDoS detection
 type DetectionResult struct {
-	IsAttack    bool
-	AttackType  string
-	Severity    string // "low", "medium", "high"
-	Description string
-	ShouldBlock bool
+	IsAttack    bool           `json:"is_attack"`
+	AttackType  string         `json:"attack_type,omitempty"`
+	Severity    severity.Level `json:"severity"`
+	Description string         `json:"description,omitempty"`
+	ShouldBlock bool           `json:"should_block"`
+
+	// Domain is the targeted domain for attacks aimed at one zone rather
+	// than at the resolver
+	Domain string `json:"domain,omitempty"`
+
+	// Evidence holds the names the detection was made on, for checks
+	// that judge names rather than volume
+	Evidence *Evidence `json:"evidence,omitempty"`
+
+	// Advice is set, at most once per window for a client, when its
+	// traffic points to a misconfiguration rather than an attack
+	Advice *Advice `json:"advice,omitempty"`
+}
+
+// Client identifies the source of a query. With a Fingerprint, soft
+// penalties are counted for that client alone rather than for everyone
+// sharing its address, over PenaltyWindow when it is shorter than the
+// detection window. A Budget above 1, from an operator's budget grant,
+// multiplies its rate and new client budgets.
+type Client struct {
+	IP            string
+	Fingerprint   string
+	PenaltyWindow time.Duration
+	Budget        float64
+}
+
+// granted returns the budget n multiplied by the client's budget grant
+func (c Client) granted(n int) int {
+	if c.Budget <= 1 || n <= 0 {
+		return n
+	}
+	return int(float64(n) * c.Budget)
 }
 
 // AnalyzeTraffic analyzes traffic from an IP and detects DDoS patterns
 func (d *DDoSDetector) AnalyzeTraffic(ip string, trafficMonitor *monitor.TrafficMonitor) *DetectionResult {
+	return d.AnalyzeClient(Client{IP: ip}, trafficMonitor)
+}
+
+// AnalyzeClient analyzes traffic from a client and detects DDoS patterns.
+// Queries a search-list storm adds are a misconfiguration, advised on
+// rather than held against the client.
+func (d *DDoSDetector) AnalyzeClient(client Client, trafficMonitor *monitor.TrafficMonitor) *DetectionResult {
+	storm := findSearchStorm(trafficMonitor.GetRecentQueries(client.IP, d.window), d.stormMinQueries)
+	result := d.analyze(client, trafficMonitor, storm)
+	if storm != nil && d.advisor.due(client.IP, trafficMonitor.Now()) {
+		result.Advice = storm.advice()
+	}
+	return result
+}
+
+// analyze runs the checks, leaving out the expansions of a search-list
+// storm when there is one
+func (d *DDoSDetector) analyze(client Client, trafficMonitor *monitor.TrafficMonitor, storm *searchStorm) *DetectionResult {
+	ip := client.IP
 	result := &DetectionResult{
 		IsAttack:    false,
 		ShouldBlock: false,
 	}
+	source := MonitorSource{Monitor: trafficMonitor}
+	if storm != nil {
+		source.Skip = storm.skip
+	}
 
-	// Check 1: High request rate
-	recentCount := trafficMonitor.GetRecentRequestCount(ip, 1*time.Minute)
-	if recentCount > d.rateLimit {
-		result.IsAttack = true
-		result.AttackType = "high_request_rate"
-		result.Severity = d.calculateSeverity(recentCount, d.rateLimit)
-		result.Description = "Excessive request rate detected"
-		result.ShouldBlock = recentCount > d.rateLimit*2
-		
-		d.log.LogDDoSDetected(ip, "high request rate", recentCount)
-		return result
+	// Check 1: High request rate, against a budget shrunk by the upstream
+	// failures the client has caused
+	count := d.requests(ip, trafficMonitor)
+	if storm != nil {
+		count = storm.discount(count)
+	}
+	rate := d.rate
+	granted := client.granted(d.rate.Limit)
+	if rate.Limit = d.underLoad(granted); rate.Limit < granted {
+		rate.Description = fmt.Sprintf("%s (budget %d under server load)", d.rateDescription(), rate.Limit)
+	} else if granted > d.rate.Limit {
+		rate.Description = fmt.Sprintf("%s (budget %d under a budget grant)", d.rateDescription(), rate.Limit)
+	}
+	if failures := d.failures(client, trafficMonitor); failures > 0 {
+		full := rate.Limit
+		if rate.Limit = d.shapedLimit(full, failures); rate.Limit < full {
+			rate.Description = fmt.Sprintf("%s (budget %d after %d upstream failures)", d.rateDescription(), rate.Limit, failures)
+		}
+	}
+	if f, ok := rate.Detect(&abuse.Window{Client: ip, Count: count}); ok {
+		d.log.LogDDoSDetected(ip, "high request rate", f.Count)
+		return findingResult(f)
 	}
 
-	// Check 2: Repeated queries (same domain queried many times)
-	queries := trafficMonitor.GetRecentQueries(ip, 1*time.Minute)
-	if repeatedQueriesDetected := d.checkRepeatedQueries(queries); repeatedQueriesDetected {
+	// Check 1b: Brand-new client bursting straight to high volume
+	if burst, requests, limit := d.checkNewClientBurst(client, trafficMonitor); burst {
 		result.IsAttack = true
-		result.AttackType = "repeated_queries"
-		result.Severity = "medium"
-		result.Description = "Repeated queries to same domain detected"
-		result.ShouldBlock = true
-		
-		d.log.LogDDoSDetected(ip, "repeated queries", len(queries))
+		result.AttackType = "new_client_burst"
+		result.Severity = abuse.SeverityFor(requests, limit)
+		result.Description = "High volume from newly seen client"
+		result.ShouldBlock = requests > limit*2
+
+		d.log.LogDDoSDetected(ip, "new client burst", requests)
 		return result
 	}
 
-	// Check 3: Random subdomain attack
-	if randomSubdomainAttack := d.checkRandomSubdomains(queries); randomSubdomainAttack {
-		result.IsAttack = true
-		result.AttackType = "random_subdomain"
-		result.Severity = "high"
-		result.Description = "Random subdomain attack detected"
-		result.ShouldBlock = true
-		
-		d.log.LogDDoSDetected(ip, "random subdomain attack", len(queries))
-		return result
+	// Check 1c: Client introducing never-before-seen names at a high rate,
+	// the signature of water torture and DGA activity
+	d.checkGlobalNewDomains(trafficMonitor)
+	if limit := int(float64(d.newDomainRate) * d.window.Minutes()); limit > 0 {
+		if novel := trafficMonitor.GetRecentNewDomainCount(ip, d.window); novel > limit {
+			result.IsAttack = true
+			result.AttackType = "new_domain_rate"
+			result.Severity = abuse.SeverityFor(novel, limit)
+			result.Description = "Excessive rate of never-before-seen names"
+			result.ShouldBlock = novel > limit*2
+
+			d.log.LogDDoSDetected(ip, "new domain rate", novel)
+			return result
+		}
 	}
 
-	// Check 4: Query burst (many queries in very short time)
-	if burstDetected := d.checkQueryBurst(queries); burstDetected {
-		result.IsAttack = true
-		result.AttackType = "query_burst"
-		result.Severity = "medium"
-		result.Description = "Query burst detected"
-		result.ShouldBlock = false // Rate limit instead of block
-		
-		d.log.LogDDoSDetected(ip, "query burst", len(queries))
-		return result
+	// Check 1d: Client querying many lookalikes of Latin names, the
+	// signature of a homograph phishing campaign
+	if limit := d.homographLimit; limit > 0 {
+		if count, evidence := findHomographs(trafficMonitor.GetRecentQueries(ip, d.window)); count > limit {
+			result.IsAttack = true
+			result.AttackType = "idn_homograph"
+			result.Severity = abuse.SeverityFor(count, limit)
+			result.Description = homographDescription(count, evidence)
+			result.ShouldBlock = count > limit*2
+			result.Evidence = evidence
+
+			d.log.LogDDoSDetected(ip, "IDN homograph names", count)
+			return result
+		}
+	}
+
+	// Checks 2-5: repeated queries, random subdomains, query bursts and
+	// machine-gun timing
+	if f, ok := d.patterns.Check(source, ip, trafficMonitor.Now()); ok {
+		d.log.LogDDoSDetected(ip, logReasons[f.Kind], f.Count)
+		return findingResult(f)
 	}
 
 	return result
 }
 
-// checkRepeatedQueries detects if the same domain is queried repeatedly
-func (d *DDoSDetector) checkRepeatedQueries(queries []monitor.QueryInfo) bool {
-	if len(queries) < 20 {
-		return false
+// requests returns what the client's queries in the window count against
+// its rate budget: their number, or with cost budgets the units of cost
+// charged for them. Clients charged nothing yet, and replayed traces,
+// which carry no costs, are counted by number.
+func (d *DDoSDetector) requests(ip string, trafficMonitor *monitor.TrafficMonitor) int {
+	count := trafficMonitor.GetRecentRequestCount(ip, d.window)
+	if !d.costBudgets {
+		return count
+	}
+	if cost, ok := trafficMonitor.GetRecentCost(ip, d.window); ok {
+		return int(math.Ceil(cost))
 	}
+	return count
+}
 
-	domainCounts := make(map[string]int)
-	for _, q := range queries {
-		domainCounts[q.Domain]++
+// rateDescription describes an exceeded rate budget
+func (d *DDoSDetector) rateDescription() string {
+	if d.costBudgets {
+		return "Excessive query cost detected"
 	}
+	return "Excessive request rate detected"
+}
 
-	// If any domain is queried more than 50% of the time, it's suspicious
-	for _, count := range domainCounts {
-		if float64(count)/float64(len(queries)) > 0.5 && count > 10 {
-			return true
-		}
+// failures returns how many of the client's forwarded queries failed
+// within its penalty window
+func (d *DDoSDetector) failures(client Client, trafficMonitor *monitor.TrafficMonitor) int {
+	window := d.window
+	if client.PenaltyWindow > 0 && client.PenaltyWindow < window {
+		window = client.PenaltyWindow
+	}
+	if client.Fingerprint != "" {
+		return trafficMonitor.GetRecentClientFailureCount(client.IP, client.Fingerprint, window)
 	}
+	return trafficMonitor.GetRecentFailureCount(client.IP, window)
+}
 
-	return false
+// shapedLimit returns what is left of the rate budget full to a client
+// whose queries caused failures upstream failures within the window
+func (d *DDoSDetector) shapedLimit(full, failures int) int {
+	if d.failurePenalty <= 0 {
+		return full
+	}
+	budget := float64(full)
+	return int(math.Max(math.Max(budget-d.failurePenalty*float64(failures), budget*d.failureFloor), 1))
 }
 
-// checkRandomSubdomains detects random subdomain attacks
-func (d *DDoSDetector) checkRandomSubdomains(queries []monitor.QueryInfo) bool {
-	if len(queries) < 30 {
-		return false
+// findingResult converts a generic finding into a detection result
+func findingResult(f abuse.Finding) *DetectionResult {
+	result := &DetectionResult{
+		IsAttack:    true,
+		AttackType:  f.Kind,
+		Severity:    f.Severity,
+		Description: f.Description,
+		ShouldBlock: f.Block,
+	}
+	if f.Kind == "random_subdomain" {
+		result.Domain = f.Resource
 	}
+	return result
+}
 
-	// Extract base domains and subdomains
-	baseDomains := make(map[string][]string)
-	
-	for _, q := range queries {
-		parts := strings.Split(q.Domain, ".")
-		if len(parts) >= 2 {
-			// Get base domain (last two parts)
-			baseDomain := strings.Join(parts[len(parts)-2:], ".")
-			subdomain := strings.Join(parts[:len(parts)-2], ".")
-			
-			if subdomain != "" {
-				baseDomains[baseDomain] = append(baseDomains[baseDomain], subdomain)
-			}
-		}
+// AnalyzeZoneTransfer classifies an AXFR/IXFR query or NOTIFY sent to the
+// resolver. These have no legitimate use against a resolver and indicate
+// reconnaissance, so they are rate limited rather than blocked outright.
+func (d *DDoSDetector) AnalyzeZoneTransfer(ip, kind string) *DetectionResult {
+	d.log.LogDDoSDetected(ip, "zone transfer attempt ("+kind+")", 1)
+
+	return &DetectionResult{
+		IsAttack:    true,
+		AttackType:  "zone_transfer",
+		Severity:    severity.Low,
+		Description: fmt.Sprintf("Zone transfer attempt (%s) against resolver", strings.ToUpper(kind)),
+		ShouldBlock: false,
 	}
+}
 
-	// Check if many unique subdomains for same base domain
-	for _, subdomains := range baseDomains {
-		uniqueSubdomains := make(map[string]bool)
-		for _, sub := range subdomains {
-			uniqueSubdomains[sub] = true
-		}
-		
-		// If more than 20 unique subdomains, likely random subdomain attack
-		if len(uniqueSubdomains) > 20 {
-			return true
-		}
-		
-		// Check if subdomains look random (contain many numbers/random chars)
-		randomCount := 0
-		for sub := range uniqueSubdomains {
-			if d.looksRandom(sub) {
-				randomCount++
-			}
-		}
-		
-		if randomCount > 10 {
-			return true
-		}
+// AnalyzeProtocolAbuse classifies a malformed or abusive message. count is
+// how many the client has sent within the window, including this one.
+func (d *DDoSDetector) AnalyzeProtocolAbuse(ip, kind string, count int) *DetectionResult {
+	d.log.LogDDoSDetected(ip, "protocol abuse ("+kind+")", count)
+
+	result := &DetectionResult{
+		IsAttack:    true,
+		AttackType:  "protocol_abuse",
+		Severity:    severity.Low,
+		Description: fmt.Sprintf("Protocol abuse (%s), %d in window", kind, count),
 	}
+	if d.protocolAbuseLimit > 0 && count > d.protocolAbuseLimit {
+		result.Severity = severity.Medium
+		result.ShouldBlock = true
+	}
+	return result
+}
 
-	return false
+// checkGlobalNewDomains alerts, at most once per window, when all clients
+// together introduce never-before-seen names faster than the global limit
+func (d *DDoSDetector) checkGlobalNewDomains(trafficMonitor *monitor.TrafficMonitor) {
+	limit := int(float64(d.globalNewDomainRate) * d.window.Minutes())
+	if limit <= 0 {
+		return
+	}
+
+	now := trafficMonitor.Now()
+	last := d.lastGlobalAlert.Load()
+	if now.Sub(time.Unix(0, last)) < d.window {
+		return
+	}
+
+	if novel := trafficMonitor.GetGlobalNewDomainCount(d.window); novel > limit &&
+		d.lastGlobalAlert.CompareAndSwap(last, now.UnixNano()) {
+		d.log.LogNewDomainSurge(novel, limit)
+	}
 }
 
-// checkQueryBurst detects sudden bursts of queries
-func (d *DDoSDetector) checkQueryBurst(queries []monitor.QueryInfo) bool {
-	if len(queries) < 10 {
-		return false
+// checkNewClientBurst applies the stricter limit to clients that appeared
+// within the new-client window. Sudden appearance plus instant high volume
+// is a strong attack signal that steady-state thresholds miss. It returns
+// the limit applied under the client's budget grant and the current load.
+func (d *DDoSDetector) checkNewClientBurst(client Client, trafficMonitor *monitor.TrafficMonitor) (bool, int, int) {
+	if d.newClientWindow <= 0 {
+		return false, 0, 0
 	}
 
-	// Check if more than 50 queries in last 10 seconds
-	cutoff := time.Now().Add(-10 * time.Second)
-	recentCount := 0
-	
-	for _, q := range queries {
-		if q.Timestamp.After(cutoff) {
-			recentCount++
-		}
+	firstSeen, requests, ok := trafficMonitor.GetClientAge(client.IP)
+	if !ok || trafficMonitor.Now().Sub(firstSeen) > d.newClientWindow {
+		return false, requests, 0
 	}
 
-	return recentCount > 50
-}
-
-// Add entropy check alongside digit check
-func (d *DDoSDetector) looksRandom(s string) bool {
-    if len(s) < 8 {
-        return false
-    }
-    
-    // Check for high digit percentage (current logic)
-    digitCount := 0
-    uniqueChars := make(map[rune]bool)
-    for _, c := range s {
-        uniqueChars[c] = true
-        if c >= '0' && c <= '9' {
-            digitCount++
-        }
-    }
-    
-    // Require both:  high digits AND high entropy
-    highDigitRatio := float64(digitCount)/float64(len(s)) > 0.4
-    highEntropy := float64(len(uniqueChars))/float64(len(s)) > 0.6
-    
-    return highDigitRatio && highEntropy
-}
-
-// calculateSeverity calculates attack severity based on request count
-func (d *DDoSDetector) calculateSeverity(requestCount, limit int) string {
-	ratio := float64(requestCount) / float64(limit)
-	
-	if ratio > 5 {
-		return "high"
-	} else if ratio > 2 {
-		return "medium"
-	}
-	return "low"
+	limit := d.underLoad(client.granted(d.newClientLimit))
+	return requests > limit, requests, limit
 }
@@ -0,0 +1,36 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestBudgetGrant(t *testing.T) {
+	now := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	tm := monitor.NewTrafficMonitor().WithClock(func() time.Time { return now })
+	d := NewDDoSDetectorWithThresholds(Thresholds{RateLimit: 50, Window: time.Minute}, logger.NewNop())
+
+	for i := 0; i < 80; i++ {
+		tm.RecordRequest("203.0.113.5", fmt.Sprintf("page%d.example.com", i), "A")
+	}
+	if result := d.AnalyzeTraffic("203.0.113.5", tm); result.AttackType != "high_request_rate" {
+		t.Fatalf("Expected 80 queries over a budget of 50 without a grant, got %+v", result)
+	}
+	crawler := Client{IP: "203.0.113.5", Budget: 2}
+	if result := d.AnalyzeClient(crawler, tm); result.AttackType == "high_request_rate" {
+		t.Errorf("Expected 80 queries within a granted budget of 100, got %+v", result)
+	}
+
+	for i := 80; i < 120; i++ {
+		tm.RecordRequest("203.0.113.5", fmt.Sprintf("page%d.example.com", i), "A")
+	}
+	result := d.AnalyzeClient(crawler, tm)
+	if result.AttackType != "high_request_rate" || !strings.Contains(result.Description, "budget 100 under a budget grant") {
+		t.Errorf("Expected 120 queries over the granted budget of 100, got %+v", result)
+	}
+}
@@ -0,0 +1,282 @@
+package detector
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"ddd/internal/monitor"
+)
+
+// maxEvidenceNames bounds the names a detection carries as evidence
+const maxEvidenceNames = 10
+
+// Evidence is what a detection was made on, for the operator and the
+// services the decision is passed to
+type Evidence struct {
+	// Names are offending names the client queried, in the order first
+	// seen, up to maxEvidenceNames
+	Names []string `json:"names"`
+	// Scripts counts the distinct internationalized names the client
+	// queried by the scripts of their labels, e.g. latin+cyrillic: the
+	// client's language fingerprint
+	Scripts map[string]int `json:"scripts,omitempty"`
+}
+
+// scripts are the scripts internationalized labels are told apart by, in
+// the order they are named in a mix; letters of other scripts count as
+// "other"
+var scripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"latin", unicode.Latin},
+	{"cyrillic", unicode.Cyrillic},
+	{"greek", unicode.Greek},
+	{"armenian", unicode.Armenian},
+	{"cherokee", unicode.Cherokee},
+	{"georgian", unicode.Georgian},
+	{"arabic", unicode.Arabic},
+	{"hebrew", unicode.Hebrew},
+	{"devanagari", unicode.Devanagari},
+	{"thai", unicode.Thai},
+	{"han", unicode.Han},
+	{"hiragana", unicode.Hiragana},
+	{"katakana", unicode.Katakana},
+	{"hangul", unicode.Hangul},
+}
+
+// lookalikeScripts have letters drawn like Latin ones. Mixing one of them
+// with another script in a label, or spelling a label in one of them with
+// Latin lookalikes only, is how homograph names imitate Latin ones.
+var lookalikeScripts = map[string]bool{
+	"cyrillic": true,
+	"greek":    true,
+	"armenian": true,
+	"cherokee": true,
+}
+
+// latinLookalikes are the letters of the lookalike scripts that pass for
+// Latin letters
+const latinLookalikes = "аВвсеһіјКкМмНорԛѕТтԝхуԁӏЅАЕІЈОРСХҮ" + // Cyrillic
+	"αβεικνορτυχΑΒΕΗΙΚΜΝΟΡΤΥΧΖ" + // Greek
+	"ազհոռսցօ" + // Armenian
+	"ᎪᎬᎻᏆᎫᏦᎷᏅᏎᎢᏙᏔᏩᏃ" // Cherokee
+
+// labelScripts returns the scripts of the letters of a decoded label, in
+// the order of scripts, and whether all of them pass for Latin letters
+func labelScripts(label string) ([]string, bool) {
+	seen := make(map[string]bool)
+	lookalike := true
+	for _, r := range label {
+		if !unicode.IsLetter(r) || unicode.In(r, unicode.Common, unicode.Inherited) {
+			continue
+		}
+		name := "other"
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				name = s.name
+				break
+			}
+		}
+		seen[name] = true
+		if !strings.ContainsRune(latinLookalikes, r) {
+			lookalike = false
+		}
+	}
+	found := make([]string, 0, len(seen))
+	for _, s := range scripts {
+		if seen[s.name] {
+			found = append(found, s.name)
+		}
+	}
+	if seen["other"] {
+		found = append(found, "other")
+	}
+	return found, lookalike && len(found) > 0
+}
+
+// idnScripts returns the script mix of a name's internationalized labels
+// ("" if it has none) and whether one of them is a homograph: mixing a
+// lookalike script with another, or spelt in a lookalike script with
+// letters that pass for Latin only
+func idnScripts(name string) (string, bool) {
+	var mix []string
+	homograph := false
+	for _, label := range strings.Split(strings.ToLower(name), ".") {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		decoded, err := decodePunycode(label[len("xn--"):])
+		if err != nil {
+			continue
+		}
+		found, lookalike := labelScripts(decoded)
+		for _, s := range found {
+			if !containsString(mix, s) {
+				mix = append(mix, s)
+			}
+		}
+		for _, s := range found {
+			if lookalikeScripts[s] && (len(found) > 1 || lookalike) {
+				homograph = true
+			}
+		}
+	}
+	sort.Slice(mix, func(i, j int) bool { return scriptOrder(mix[i]) < scriptOrder(mix[j]) })
+	return strings.Join(mix, "+"), homograph
+}
+
+// scriptOrder returns the position of a script in scripts; other is last
+func scriptOrder(name string) int {
+	for i, s := range scripts {
+		if s.name == name {
+			return i
+		}
+	}
+	return len(scripts)
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findHomographs returns the distinct homograph names among a client's
+// queries, with the script fingerprint of all the internationalized names
+// it queried
+func findHomographs(queries []monitor.QueryInfo) (int, *Evidence) {
+	evidence := &Evidence{Scripts: make(map[string]int)}
+	seen := make(map[string]bool)
+	count := 0
+	for _, q := range queries {
+		name := strings.ToLower(strings.TrimSuffix(q.Domain, "."))
+		if seen[name] || !strings.Contains(name, "xn--") {
+			continue
+		}
+		seen[name] = true
+		mix, homograph := idnScripts(name)
+		if mix == "" {
+			continue
+		}
+		evidence.Scripts[mix]++
+		if homograph {
+			count++
+			if len(evidence.Names) < maxEvidenceNames {
+				evidence.Names = append(evidence.Names, name)
+			}
+		}
+	}
+	return count, evidence
+}
+
+// Punycode parameters (RFC 3492)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+	// punyMaxDelta bounds the decoder's state well below overflow
+	punyMaxDelta = 1 << 30
+)
+
+var errPunycode = errors.New("invalid punycode")
+
+// decodePunycode decodes the part of an A-label after its xn-- prefix
+func decodePunycode(s string) (string, error) {
+	var output []rune
+	pos := 0
+	if b := strings.LastIndexByte(s, '-'); b >= 0 {
+		for _, c := range []byte(s[:b]) {
+			if c >= 0x80 {
+				return "", errPunycode
+			}
+			output = append(output, rune(c))
+		}
+		pos = b + 1
+	}
+
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for pos < len(s) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos == len(s) {
+				return "", errPunycode
+			}
+			digit := punyDigit(s[pos])
+			pos++
+			if digit < 0 {
+				return "", errPunycode
+			}
+			i += digit * w
+			t := min(max(k-bias, punyTMin), punyTMax)
+			if digit < t {
+				break
+			}
+			w *= punyBase - t
+			if i > punyMaxDelta || w > punyMaxDelta {
+				return "", errPunycode
+			}
+		}
+		bias = punyAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > unicode.MaxRune {
+			return "", errPunycode
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
+
+// punyDigit returns the value of a punycode digit, or -1
+func punyDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A')
+	}
+	return -1
+}
+
+// punyAdapt is the bias adaptation function of RFC 3492
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// homographDescription describes a homograph detection
+func homographDescription(count int, e *Evidence) string {
+	mixes := make([]string, 0, len(e.Scripts))
+	for mix, n := range e.Scripts {
+		mixes = append(mixes, fmt.Sprintf("%s %d", mix, n))
+	}
+	sort.Strings(mixes)
+	return fmt.Sprintf("%d confusable or mixed-script IDN names queried (scripts: %s)", count, strings.Join(mixes, ", "))
+}
@@ -0,0 +1,79 @@
+package detector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestDecodePunycode(t *testing.T) {
+	for encoded, want := range map[string]string{
+		"80ak6aa92e":      "аррӏе",
+		"pypal-4ve":       "pаypal",
+		"mnchen-3ya":      "münchen",
+		"1lqs71d":         "東京",
+		"bck1dqbybe0esgb": "ファミリーマート",
+	} {
+		if got, err := decodePunycode(encoded); err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", encoded, want, got, err)
+		}
+	}
+	for _, bad := range []string{"ü-abc", "99999999999", "abc!"} {
+		if _, err := decodePunycode(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestIDNScripts(t *testing.T) {
+	for name, want := range map[string]struct {
+		mix       string
+		homograph bool
+	}{
+		"xn--80ak6aa92e.com":     {"cyrillic", true},       // аррӏе
+		"www.xn--pypal-4ve.com":  {"latin+cyrillic", true}, // pаypal
+		"xn--ank-rxc.com":        {"latin+greek", true},    // βank
+		"xn--mnchen-3ya.de":      {"latin", false},         // münchen
+		"xn--1lqs71d.jp":         {"han", false},           // 東京
+		"xn--bck1dqbybe0esgb.jp": {"katakana", false},
+		"xn--r8jz45g.jp":         {"han+hiragana", false}, // 例え
+		"example.com":            {"", false},
+	} {
+		mix, homograph := idnScripts(name)
+		if mix != want.mix || homograph != want.homograph {
+			t.Errorf("%s: expected %q (homograph %v), got %q (%v)", name, want.mix, want.homograph, mix, homograph)
+		}
+	}
+}
+
+func TestAnalyzeHomographs(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tm := monitor.NewTrafficMonitor().WithClock(func() time.Time { return now })
+	d := NewDDoSDetectorWithThresholds(Thresholds{RateLimit: 100, Window: time.Minute, HomographLimit: 5}, logger.NewNop())
+
+	// Legitimate IDNs are not counted
+	for i := 0; i < 10; i++ {
+		tm.RecordRequest("192.0.2.1", fmt.Sprintf("shop%d.xn--mnchen-3ya.de", i), "A")
+	}
+	if result := d.AnalyzeTraffic("192.0.2.1", tm); result.IsAttack {
+		t.Fatalf("Expected no detection on single-script IDNs, got %+v", result)
+	}
+
+	for i := 0; i < 12; i++ {
+		tm.RecordRequest("192.0.2.1", fmt.Sprintf("login%d.xn--80ak6aa92e.com.", i), "A")
+	}
+	result := d.AnalyzeTraffic("192.0.2.1", tm)
+	if !result.IsAttack || result.AttackType != "idn_homograph" || !result.ShouldBlock {
+		t.Fatalf("Expected 12 homograph names over a limit of 5 to block, got %+v", result)
+	}
+	e := result.Evidence
+	if e == nil || len(e.Names) != maxEvidenceNames || e.Names[0] != "login0.xn--80ak6aa92e.com" {
+		t.Fatalf("Expected the first %d names as evidence, got %+v", maxEvidenceNames, e)
+	}
+	if e.Scripts["cyrillic"] != 12 || e.Scripts["latin"] != 10 {
+		t.Errorf("Expected the client's names counted by script, got %v", e.Scripts)
+	}
+}
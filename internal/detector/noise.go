@@ -0,0 +1,78 @@
+package detector
+
+import (
+	"strings"
+	"time"
+
+	"ddd/internal/abuse"
+)
+
+// Noise lists benign high-rate query patterns that the repeated query and
+// regular timing checks discount: monitoring systems resolving one health
+// check record every few seconds, and resolver search-list expansions such
+// as Kubernetes pods retrying a name under each ndots search domain
+type Noise struct {
+	// Names are benign names, with their subdomains
+	Names []string
+	// SearchSuffixes are search domains whose expansions are benign, e.g.
+	// cluster.local for api.example.com.default.svc.cluster.local
+	SearchSuffixes []string
+
+	// A name queried at least ProbeMinSamples times within the window, at
+	// a mean period of at least ProbeMinPeriod with a coefficient of
+	// variation of at most ProbeMaxCV, is a monitoring probe. Zero
+	// ProbeMinSamples disables probe recognition.
+	ProbeMinSamples int
+	ProbeMinPeriod  time.Duration
+	ProbeMaxCV      float64
+}
+
+// classifier returns the noise classification, or nil when no pattern is
+// configured
+func (n Noise) classifier() abuse.Benign {
+	if len(n.Names) == 0 && len(n.SearchSuffixes) == 0 && n.ProbeMinSamples <= 0 {
+		return nil
+	}
+	names := normalizeNames(n.Names)
+	suffixes := normalizeNames(n.SearchSuffixes)
+
+	return func(domain string, times []time.Time) bool {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		for _, name := range names {
+			if domain == name || strings.HasSuffix(domain, "."+name) {
+				return true
+			}
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(domain, "."+suffix) {
+				return true
+			}
+		}
+		return n.isProbe(times)
+	}
+}
+
+// isProbe reports whether query times have the steady, unhurried period
+// of a monitoring probe
+func (n Noise) isProbe(times []time.Time) bool {
+	if n.ProbeMinSamples <= 0 || len(times) < n.ProbeMinSamples {
+		return false
+	}
+	requests := make([]abuse.Request, len(times))
+	for i, t := range times {
+		requests[i].Time = t
+	}
+	stats := abuse.IntervalStats(requests)
+	return stats.Mean >= n.ProbeMinPeriod.Seconds() && stats.CV <= n.ProbeMaxCV
+}
+
+// normalizeNames lower-cases names and strips their trailing dots
+func normalizeNames(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.ToLower(strings.Trim(name, ".")); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package detector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNoiseProbes(t *testing.T) {
+	benign := Noise{ProbeMinSamples: 10, ProbeMinPeriod: 500 * time.Millisecond, ProbeMaxCV: 0.1}.classifier()
+
+	at := func(n int, period time.Duration, jitter func(i int) time.Duration) []time.Time {
+		start := time.Unix(1700000000, 0)
+		times := make([]time.Time, n)
+		for i := range times {
+			times[i] = start.Add(time.Duration(i)*period + jitter(i))
+		}
+		return times
+	}
+	none := func(int) time.Duration { return 0 }
+	small := func(i int) time.Duration { return time.Duration(i%3) * 20 * time.Millisecond }
+
+	cases := []struct {
+		name  string
+		times []time.Time
+		want  bool
+	}{
+		{"steady probe", at(30, time.Second, none), true},
+		{"jittered probe", at(30, time.Second, small), true},
+		{"too few samples", at(5, time.Second, none), false},
+		{"machine gun", at(30, 50*time.Millisecond, none), false},
+		{"irregular", at(30, time.Second, func(i int) time.Duration { return time.Duration(i*i) * 10 * time.Millisecond }), false},
+	}
+	for _, c := range cases {
+		if got := benign("status.example.com.", c.times); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	if (Noise{}).classifier() != nil {
+		t.Error("Expected no classifier without patterns")
+	}
+}
@@ -0,0 +1,59 @@
+package detector
+
+import (
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+// Replay is a detection re-run on a client's traced traffic as it stood
+// at a past time, with the inputs the checks decided on
+type Replay struct {
+	IP     string           `json:"ip"`
+	At     time.Time        `json:"at"`
+	Result *DetectionResult `json:"result"`
+
+	Requests       int       `json:"requests"`   // in the window, less search-list expansions; cost units with cost budgets
+	RateLimit      int       `json:"rate_limit"` // the window's budget after failure shaping
+	Failures       int       `json:"failures"`
+	NewDomains     int       `json:"new_domains"`
+	Queries        int       `json:"queries"` // kept in history within the window
+	FirstSeen      time.Time `json:"first_seen"`
+	ClientRequests int       `json:"client_requests"` // since first seen
+	SearchStorm    bool      `json:"search_storm"`
+}
+
+// Replay runs the detector's checks on trace as it stood at at, the
+// way AnalyzeTraffic ran them then. It uses a fresh detector with the
+// same thresholds, so nothing is logged and no once-per-window state of d
+// is touched; pattern checks run without a time budget.
+func (d *DDoSDetector) Replay(trace monitor.Trace, retention monitor.Retention, at time.Time) Replay {
+	t := d.Thresholds()
+	t.CheckBudget = 0
+	replayed := NewDDoSDetectorWithThresholds(t, logger.NewNop())
+	tm := monitor.Replay(trace, retention, at)
+
+	client := Client{IP: trace.IP}
+	queries := tm.GetRecentQueries(client.IP, replayed.window)
+	storm := findSearchStorm(queries, replayed.stormMinQueries)
+	r := Replay{
+		IP:          trace.IP,
+		At:          at,
+		Result:      replayed.AnalyzeClient(client, tm),
+		Requests:    replayed.requests(client.IP, tm),
+		RateLimit:   replayed.rate.Limit,
+		Failures:    replayed.failures(client, tm),
+		NewDomains:  tm.GetRecentNewDomainCount(client.IP, replayed.window),
+		Queries:     len(queries),
+		SearchStorm: storm != nil,
+	}
+	if storm != nil {
+		r.Requests = storm.discount(r.Requests)
+	}
+	if r.Failures > 0 {
+		r.RateLimit = replayed.shapedLimit(r.RateLimit, r.Failures)
+	}
+	r.FirstSeen, r.ClientRequests, _ = tm.GetClientAge(client.IP)
+	return r
+}
@@ -0,0 +1,42 @@
+package detector
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestReplay(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retention := monitor.DefaultRetention()
+	tm := monitor.NewTrafficMonitorWithRetention(retention).WithClock(func() time.Time { return now })
+	for i := 0; i < 40; i++ {
+		tm.RecordRequest("192.0.2.1", fmt.Sprintf("site%d.example", i), "A")
+		now = now.Add(time.Second)
+	}
+	d := NewDDoSDetector(30, logger.NewNop())
+	live := d.AnalyzeTraffic("192.0.2.1", tm)
+	if !live.IsAttack {
+		t.Fatal("Expected 40 requests in a minute to exceed a limit of 30")
+	}
+
+	trace, _ := tm.Trace("192.0.2.1")
+	r := d.Replay(trace, retention, trace.Dumped)
+	if !reflect.DeepEqual(r.Result, live) || r.Requests != 40 || r.RateLimit != 30 {
+		t.Errorf("Expected the live decision on 40 requests replayed, got %+v on %d of %d", r.Result, r.Requests, r.RateLimit)
+	}
+
+	// The detection fired with the 31st request
+	before := d.Replay(trace, retention, trace.FirstSeen.Add(29*time.Second))
+	if before.Result.IsAttack || before.Requests != 30 {
+		t.Errorf("Expected no detection on 30 requests, got %+v on %d", before.Result, before.Requests)
+	}
+	at := d.Replay(trace, retention, trace.FirstSeen.Add(30*time.Second))
+	if !at.Result.IsAttack || at.Requests != 31 {
+		t.Errorf("Expected a detection on 31 requests, got %+v on %d", at.Result, at.Requests)
+	}
+}
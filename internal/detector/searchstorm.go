@@ -0,0 +1,146 @@
+package detector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/monitor"
+)
+
+const (
+	// stormMinQueries is how many retained queries a client needs before
+	// its search-list expansion is judged (scaled by sensitivity)
+	stormMinQueries = 20
+	// stormMinSuffixes is how many distinct search domains must be
+	// expanded: a single one is as likely a coincidence of names
+	stormMinSuffixes = 2
+	// maxAdvised bounds the clients remembered as already advised
+	maxAdvised = 4096
+)
+
+// Advice describes a client misconfiguration worth telling the operator
+// about. It is not an attack and is not mitigated.
+type Advice struct {
+	Kind        string `json:"kind"`
+	Domain      string `json:"domain"` // the search domain involved
+	Description string `json:"description"`
+}
+
+// searchStorm is a client's search-list expansion: queries for a name the
+// client also queried, with a search domain appended. Stub resolvers with
+// a long search list and a high ndots (Kubernetes pods default to 5) try
+// every external name under each search domain before the name itself.
+type searchStorm struct {
+	expansions map[string]bool // expanded names queried
+	suffixes   map[string]int  // search domain -> expansion queries
+	expanded   int             // expansion queries among the retained
+	total      int             // retained queries
+}
+
+// findSearchStorm returns the client's search-list expansion when it makes
+// up at least half of its retained queries, or nil
+func findSearchStorm(queries []monitor.QueryInfo, minQueries int) *searchStorm {
+	if len(queries) < minQueries {
+		return nil
+	}
+
+	names := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		names[strings.ToLower(strings.TrimSuffix(q.Domain, "."))] = true
+	}
+
+	storm := &searchStorm{
+		expansions: make(map[string]bool),
+		suffixes:   make(map[string]int),
+		total:      len(queries),
+	}
+	for _, q := range queries {
+		name := strings.ToLower(strings.TrimSuffix(q.Domain, "."))
+		// Try every split into a queried name and a suffix of at least two
+		// labels, longest name first
+		for i := len(name); ; {
+			if i = strings.LastIndexByte(name[:i], '.'); i <= 0 {
+				break
+			}
+			suffix := name[i+1:]
+			if !strings.Contains(suffix, ".") {
+				continue
+			}
+			if names[name[:i]] {
+				storm.expansions[name] = true
+				storm.suffixes[suffix]++
+				storm.expanded++
+				break
+			}
+		}
+	}
+
+	if len(storm.suffixes) < stormMinSuffixes || storm.expanded*2 < storm.total {
+		return nil
+	}
+	return storm
+}
+
+// discount scales a request count down to the client's own lookups
+func (s *searchStorm) discount(count int) int {
+	return count - count*s.expanded/s.total
+}
+
+// skip reports whether a queried name is one of the expansions
+func (s *searchStorm) skip(domain string) bool {
+	return s.expansions[strings.ToLower(strings.TrimSuffix(domain, "."))]
+}
+
+// advice describes the storm for the operator
+func (s *searchStorm) advice() *Advice {
+	domains := make([]string, 0, len(s.suffixes))
+	for d := range s.suffixes {
+		domains = append(domains, d)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if s.suffixes[domains[i]] != s.suffixes[domains[j]] {
+			return s.suffixes[domains[i]] > s.suffixes[domains[j]]
+		}
+		return domains[i] < domains[j]
+	})
+	return &Advice{
+		Kind:   "search_domain_storm",
+		Domain: domains[0],
+		Description: fmt.Sprintf("%d%% of queries are search-list expansions under %d search domains (%s); "+
+			"lower ndots or use fully qualified names", s.expanded*100/s.total, len(domains), strings.Join(domains, ", ")),
+	}
+}
+
+// advisor hands out advice for each client at most once per window
+type advisor struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	advised map[string]time.Time
+}
+
+// due reports whether ip has not been advised within the window, and
+// records it as advised now if so
+func (a *advisor) due(ip string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if last, ok := a.advised[ip]; ok && now.Sub(last) < a.window {
+		return false
+	}
+	if len(a.advised) >= maxAdvised {
+		for k, last := range a.advised {
+			if now.Sub(last) >= a.window {
+				delete(a.advised, k)
+			}
+		}
+		if len(a.advised) >= maxAdvised {
+			return false
+		}
+	}
+	a.advised[ip] = now
+	return true
+}
@@ -0,0 +1,59 @@
+package detector
+
+import (
+	"strings"
+	"testing"
+
+	"ddd/internal/monitor"
+)
+
+func queries(names ...string) []monitor.QueryInfo {
+	out := make([]monitor.QueryInfo, len(names))
+	for i, n := range names {
+		out[i] = monitor.QueryInfo{Domain: n, QueryType: "A"}
+	}
+	return out
+}
+
+func TestFindSearchStorm(t *testing.T) {
+	var names []string
+	for _, base := range []string{"github.com", "registry.npmjs.org", "api.stripe.com", "s3.amazonaws.com", "example.org"} {
+		names = append(names,
+			base+".payments.svc.cluster.local.",
+			base+".svc.cluster.local.",
+			base+".cluster.local.",
+			base+".ec2.internal.",
+			base+".")
+	}
+
+	storm := findSearchStorm(queries(names...), 20)
+	if storm == nil {
+		t.Fatal("Expected a search domain storm")
+	}
+	if storm.expanded != 20 || len(storm.suffixes) != 4 {
+		t.Errorf("Expected 20 expansions under 4 search domains, got %d under %v", storm.expanded, storm.suffixes)
+	}
+	if !storm.skip("GitHub.com.ec2.internal.") || storm.skip("github.com.") {
+		t.Error("Expected only expansions to be skipped")
+	}
+	if got := storm.discount(100); got != 20 {
+		t.Errorf("Expected the count discounted to the client's own lookups, got %d", got)
+	}
+	if advice := storm.advice(); !strings.Contains(advice.Description, "80%") {
+		t.Errorf("Unexpected advice %+v", advice)
+	}
+
+	// Ordinary browsing has names under names, but not queried names
+	// with search domains appended
+	normal := queries(
+		"example.com", "www.example.com", "cdn.example.com", "static.example.com",
+		"google.com", "www.google.com", "fonts.googleapis.com", "google.com.au",
+		"mail.google.com", "apis.google.com", "github.com", "api.github.com",
+		"raw.githubusercontent.com", "avatars.githubusercontent.com", "example.org",
+		"www.example.org", "news.ycombinator.com", "ycombinator.com", "cloudflare.com",
+		"www.cloudflare.com",
+	)
+	if storm := findSearchStorm(normal, 20); storm != nil {
+		t.Errorf("Expected no storm in ordinary traffic, got %v", storm.suffixes)
+	}
+}
@@ -0,0 +1,91 @@
+package detector
+
+import (
+	"fmt"
+	"math"
+)
+
+// Sensitivity scales all detector thresholds together
+type Sensitivity string
+
+// Sensitivity levels. Count thresholds (rate limits, pattern minimums) are
+// multiplied by the level's multiplier, so lower values flag sooner:
+//
+//	low       2.0   fewer false positives, slower to react
+//	medium    1.0   the configured thresholds as-is
+//	high      0.6
+//	paranoid  0.35  flags aggressively; expect false positives
+//
+// Timing thresholds move the same way: the allowed coefficient of variation
+// is divided by the multiplier and the autocorrelation margin below 1 is
+// divided by it as well.
+const (
+	SensitivityLow      Sensitivity = "low"
+	SensitivityMedium   Sensitivity = "medium"
+	SensitivityHigh     Sensitivity = "high"
+	SensitivityParanoid Sensitivity = "paranoid"
+)
+
+// sensitivityMultipliers maps each level to its threshold multiplier
+var sensitivityMultipliers = map[Sensitivity]float64{
+	SensitivityLow:      2.0,
+	SensitivityMedium:   1.0,
+	SensitivityHigh:     0.6,
+	SensitivityParanoid: 0.35,
+}
+
+// ParseSensitivity validates a sensitivity name; empty means medium
+func ParseSensitivity(s string) (Sensitivity, error) {
+	if s == "" {
+		return SensitivityMedium, nil
+	}
+	if _, ok := sensitivityMultipliers[Sensitivity(s)]; !ok {
+		return "", fmt.Errorf("unknown sensitivity %q (want low, medium, high or paranoid)", s)
+	}
+	return Sensitivity(s), nil
+}
+
+// Multiplier returns the threshold multiplier for the level
+func (s Sensitivity) Multiplier() float64 {
+	if m, ok := sensitivityMultipliers[s]; ok {
+		return m
+	}
+	return 1
+}
+
+// MinHistory returns the per-IP history the pattern checks need at this
+// level
+func (s Sensitivity) MinHistory() int {
+	return scaleCount(MinHistory, s.Multiplier())
+}
+
+// Scaled returns the thresholds adjusted for the sensitivity level
+func (t Thresholds) Scaled(s Sensitivity) Thresholds {
+	m := s.Multiplier()
+
+	t.RateLimit = scaleCount(t.RateLimit, m)
+	t.NewClientLimit = scaleCount(t.NewClientLimit, m)
+	t.NewDomainRate = scaleCount(t.NewDomainRate, m)
+	t.GlobalNewDomainRate = scaleCount(t.GlobalNewDomainRate, m)
+	t.ProtocolAbuseLimit = scaleCount(t.ProtocolAbuseLimit, m)
+	t.HomographLimit = scaleCount(t.HomographLimit, m)
+	t.TimingMinSamples = scaleCount(t.TimingMinSamples, m)
+	t.TimingMaxCV = t.TimingMaxCV / m
+	t.TimingMinAutocorrelation = math.Max(0, 1-(1-t.TimingMinAutocorrelation)/m)
+	t.PatternScale = m
+
+	return t
+}
+
+// scaleCount multiplies a count threshold, keeping positive values at
+// least 1 and leaving zero (disabled) alone
+func scaleCount(n int, m float64) int {
+	if n <= 0 {
+		return n
+	}
+	scaled := int(math.Round(float64(n) * m))
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
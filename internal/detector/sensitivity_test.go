@@ -0,0 +1,46 @@
+package detector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholdsScaled(t *testing.T) {
+	base := Thresholds{
+		RateLimit:                100,
+		Window:                   time.Minute,
+		NewClientLimit:           50,
+		TimingMinSamples:         30,
+		TimingMaxCV:              0.05,
+		TimingMinAutocorrelation: 0.9,
+	}
+
+	if got := base.Scaled(SensitivityMedium); got.RateLimit != 100 || got.TimingMaxCV != 0.05 || got.PatternScale != 1 {
+		t.Errorf("Expected medium to keep thresholds, got %+v", got)
+	}
+
+	high := base.Scaled(SensitivityHigh)
+	if high.RateLimit != 60 || high.NewClientLimit != 30 || high.TimingMinSamples != 18 {
+		t.Errorf("Expected high to lower count thresholds, got %+v", high)
+	}
+	if high.TimingMaxCV <= base.TimingMaxCV || high.TimingMinAutocorrelation >= base.TimingMinAutocorrelation {
+		t.Errorf("Expected high to loosen timing thresholds, got %+v", high)
+	}
+
+	low := base.Scaled(SensitivityLow)
+	if low.RateLimit != 200 || low.Window != time.Minute {
+		t.Errorf("Expected low to raise the rate limit and keep the window, got %+v", low)
+	}
+}
+
+func TestParseSensitivity(t *testing.T) {
+	if s, err := ParseSensitivity(""); err != nil || s != SensitivityMedium {
+		t.Errorf("Expected empty to mean medium, got %q, %v", s, err)
+	}
+	if _, err := ParseSensitivity("extreme"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if got := SensitivityLow.MinHistory(); got != 60 {
+		t.Errorf("Expected low sensitivity to need 60 queries of history, got %d", got)
+	}
+}
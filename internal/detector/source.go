@@ -0,0 +1,48 @@
+package detector
+
+import (
+	"time"
+
+	"ddd/internal/abuse"
+	"ddd/internal/monitor"
+)
+
+// MonitorSource presents the traffic monitor to the generic abuse
+// detectors: clients are IPs and resources are queried domains
+type MonitorSource struct {
+	Monitor *monitor.TrafficMonitor
+	// Skip leaves queried domains out of windows when set
+	Skip func(domain string) bool
+}
+
+// Window implements abuse.Source
+func (s MonitorSource) Window(ip string, span time.Duration, now time.Time) *abuse.Window {
+	queries := s.Monitor.GetRecentQueries(ip, span)
+	count := s.Monitor.GetRecentRequestCount(ip, span)
+	if s.Skip != nil {
+		kept := queries[:0:0]
+		for _, q := range queries {
+			if !s.Skip(q.Domain) {
+				kept = append(kept, q)
+			}
+		}
+		count -= len(queries) - len(kept)
+		queries = kept
+	}
+	return &abuse.Window{
+		Client:   ip,
+		Start:    now.Add(-span),
+		End:      now,
+		Count:    count,
+		Requests: requests(queries),
+	}
+}
+
+// requests converts queries to generic requests for their domains
+func requests(queries []monitor.QueryInfo) []abuse.Request {
+	reqs := make([]abuse.Request, len(queries))
+	for i, q := range queries {
+		reqs[i] = abuse.Request{Resource: q.Domain, Time: q.Timestamp}
+	}
+	return reqs
+}
@@ -0,0 +1,44 @@
+package detector
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/severity"
+)
+
+// AnalyzeSustained looks at each client's totals over a long window from
+// the warm history and flags those averaging more than perMinute requests
+// a minute across it. This catches low-and-slow sources that stay under
+// the short-window rate limit for hours. Flagged clients are rate limited
+// rather than blocked.
+func (d *DDoSDetector) AnalyzeSustained(totals map[string]archive.Aggregate, window time.Duration, perMinute int) map[string]*DetectionResult {
+	if perMinute <= 0 || window < time.Minute {
+		return nil
+	}
+	limit := int(float64(perMinute) * window.Minutes())
+
+	ips := make([]string, 0, len(totals))
+	for ip, t := range totals {
+		if t.Requests > limit {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+
+	results := make(map[string]*DetectionResult, len(ips))
+	for _, ip := range ips {
+		count := totals[ip].Requests
+		d.log.LogDDoSDetected(ip, "sustained request rate", count)
+		results[ip] = &DetectionResult{
+			IsAttack:   true,
+			AttackType: "sustained_rate",
+			Severity:   severity.Low,
+			Description: fmt.Sprintf("Sustained rate: %d requests in %v (%.1f/min, limit %d/min)",
+				count, window, float64(count)/window.Minutes(), perMinute),
+		}
+	}
+	return results
+}
@@ -0,0 +1,28 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/logger"
+)
+
+func TestAnalyzeSustained(t *testing.T) {
+	d := NewDDoSDetector(100, logger.NewNop())
+	totals := map[string]archive.Aggregate{
+		"192.0.2.1": {Requests: 6*60*10 + 1}, // just over 10/min for 6h
+		"192.0.2.2": {Requests: 6 * 60 * 10},
+	}
+
+	results := d.AnalyzeSustained(totals, 6*time.Hour, 10)
+	if len(results) != 1 || results["192.0.2.1"] == nil {
+		t.Fatalf("Expected only 192.0.2.1 flagged, got %v", results)
+	}
+	if r := results["192.0.2.1"]; r.AttackType != "sustained_rate" || r.ShouldBlock {
+		t.Errorf("Expected a rate limit for a sustained rate, got %+v", r)
+	}
+	if results := d.AnalyzeSustained(totals, 6*time.Hour, 0); results != nil {
+		t.Errorf("Expected no check with a zero rate, got %v", results)
+	}
+}
@@ -0,0 +1,15 @@
+package detector
+
+import (
+	"ddd/internal/abuse"
+	"ddd/internal/monitor"
+)
+
+// TimingStats summarises the gaps between a client's consecutive queries
+type TimingStats = abuse.TimingStats
+
+// IntervalStats computes inter-query interval statistics for queries,
+// which must be in arrival order
+func IntervalStats(queries []monitor.QueryInfo) TimingStats {
+	return abuse.IntervalStats(requests(queries))
+}
@@ -0,0 +1,46 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/monitor"
+)
+
+func queriesAt(offsets []time.Duration) []monitor.QueryInfo {
+	start := time.Now()
+	queries := make([]monitor.QueryInfo, len(offsets))
+	for i, off := range offsets {
+		queries[i] = monitor.QueryInfo{Domain: "example.com", QueryType: "A", Timestamp: start.Add(off)}
+	}
+	return queries
+}
+
+func TestIntervalStatsConstant(t *testing.T) {
+	offsets := make([]time.Duration, 40)
+	for i := range offsets {
+		offsets[i] = time.Duration(i) * 500 * time.Millisecond
+	}
+
+	stats := IntervalStats(queriesAt(offsets))
+	if stats.Samples != 39 || stats.CV > 0.001 {
+		t.Errorf("Expected 39 constant intervals, got %+v", stats)
+	}
+}
+
+func TestIntervalStatsPeriodic(t *testing.T) {
+	// Alternating 100ms / 900ms gaps repeat with period 2
+	offsets := make([]time.Duration, 40)
+	for i := 1; i < len(offsets); i++ {
+		gap := 100 * time.Millisecond
+		if i%2 == 0 {
+			gap = 900 * time.Millisecond
+		}
+		offsets[i] = offsets[i-1] + gap
+	}
+
+	stats := IntervalStats(queriesAt(offsets))
+	if stats.Period != 2 || stats.Periodicity < 0.9 {
+		t.Errorf("Expected strong periodicity at lag 2, got %+v", stats)
+	}
+}
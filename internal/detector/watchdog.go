@@ -0,0 +1,21 @@
+package detector
+
+import (
+	"ddd/internal/abuse"
+	"ddd/internal/metrics"
+)
+
+var checkOverruns = metrics.NewCounterVec("ddd_detector_check_overruns_total",
+	"Pattern checks abandoned for exceeding their time budget, or skipped while an abandoned run finishes", "check", "outcome")
+
+// overrun records a pattern check the watchdog abandoned or skipped
+func (d *DDoSDetector) overrun(o abuse.Overrun) {
+	outcome := "abandoned"
+	if o.Skipped {
+		outcome = "skipped"
+	}
+	checkOverruns.With(o.Detector, outcome).Inc()
+	if !o.Skipped {
+		d.log.LogCheckOverrun(o.Client, o.Detector, o.Requests, o.Elapsed)
+	}
+}
@@ -0,0 +1,160 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchIO is the batched I/O of ipv4.PacketConn and ipv6.PacketConn, whose
+// Message types are the same
+type batchIO interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchConn wraps the UDP listener so that datagrams are received and sent
+// in batches (recvmmsg/sendmmsg on Linux). On other platforms the batch
+// calls degrade to one message per syscall, so behaviour matches the
+// standard read path.
+type batchConn struct {
+	*net.UDPConn
+	pc batchIO
+
+	readMu   sync.Mutex
+	readMsgs []ipv4.Message
+	readN    int // messages filled by the last batch read
+	readPos  int // next message to hand out
+
+	writeQueue  chan outgoingPacket
+	writeErrors atomic.Uint64
+	done        chan struct{}
+	exited      chan struct{} // closed when the writer loop returns
+	closeOnce   sync.Once
+}
+
+// outgoingPacket is a response waiting to be flushed by the writer loop
+type outgoingPacket struct {
+	payload []byte
+	addr    net.Addr
+}
+
+// newBatchConn wraps conn with batched I/O of up to size messages per syscall
+func newBatchConn(conn *net.UDPConn, size int) *batchConn {
+	c := &batchConn{
+		UDPConn:    conn,
+		pc:         batchPacketConn(conn),
+		readMsgs:   make([]ipv4.Message, size),
+		writeQueue: make(chan outgoingPacket, size*4),
+		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+
+	for i := range c.readMsgs {
+		c.readMsgs[i].Buffers = [][]byte{make([]byte, dns.MaxMsgSize)}
+	}
+
+	go c.writeLoop(size)
+	return c
+}
+
+// batchPacketConn returns the batched I/O for conn's address family. A
+// socket bound to an IPv6 or unspecified address is an IPv6 socket, also
+// when it serves IPv4 clients as IPv4-mapped addresses, and addresses
+// written to it must be encoded as IPv6.
+func batchPacketConn(conn *net.UDPConn) batchIO {
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn)
+}
+
+// ReadFrom returns the next buffered datagram, refilling the batch with a
+// single recvmmsg call when it has been drained
+func (c *batchConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.readPos >= c.readN {
+		n, err := c.pc.ReadBatch(c.readMsgs, 0)
+		if err != nil {
+			return 0, nil, err
+		}
+		c.readN, c.readPos = n, 0
+	}
+
+	msg := &c.readMsgs[c.readPos]
+	c.readPos++
+
+	n := copy(b, msg.Buffers[0][:msg.N])
+	return n, msg.Addr, nil
+}
+
+// WriteTo queues a response for the writer loop. The payload is copied
+// because the caller may reuse its buffer as soon as we return.
+func (c *batchConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	payload := make([]byte, len(b))
+	copy(payload, b)
+
+	select {
+	case c.writeQueue <- outgoingPacket{payload: payload, addr: addr}:
+		return len(b), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+}
+
+// writeLoop drains queued responses and sends them with sendmmsg
+func (c *batchConn) writeLoop(size int) {
+	defer close(c.exited)
+	msgs := make([]ipv4.Message, 0, size)
+
+	for {
+		var first outgoingPacket
+		select {
+		case first = <-c.writeQueue:
+		case <-c.done:
+			return
+		}
+
+		msgs = append(msgs[:0], ipv4.Message{Buffers: [][]byte{first.payload}, Addr: first.addr})
+
+	drain:
+		for len(msgs) < size {
+			select {
+			case p := <-c.writeQueue:
+				msgs = append(msgs, ipv4.Message{Buffers: [][]byte{p.payload}, Addr: p.addr})
+			default:
+				break drain
+			}
+		}
+
+		// WriteBatch may send fewer messages than requested; keep going
+		// until the batch is flushed or the socket errors out
+		for sent := 0; sent < len(msgs); {
+			n, err := c.pc.WriteBatch(msgs[sent:], 0)
+			if err != nil {
+				c.writeErrors.Add(uint64(len(msgs) - sent))
+				break
+			}
+			sent += n
+		}
+	}
+}
+
+// Close stops the writer loop, letting it finish the batch it is sending,
+// and closes the underlying socket
+func (c *batchConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	<-c.exited
+	return c.UDPConn.Close()
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBatchConnLoopback(t *testing.T) {
+	for _, tc := range []struct{ listen, client string }{
+		{"127.0.0.1:0", "127.0.0.1"},
+		{"[::1]:0", "::1"},
+		{":0", "127.0.0.1"}, // dual stack: IPv4 clients on an IPv6 socket
+	} {
+		t.Run(tc.listen, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp", mustResolveUDP(t, tc.listen))
+			if err != nil {
+				t.Skipf("Cannot listen on %s: %v", tc.listen, err)
+			}
+			c := newBatchConn(conn, 4)
+			defer c.Close()
+
+			port := conn.LocalAddr().(*net.UDPAddr).Port
+			client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP(tc.client), Port: port})
+			if err != nil {
+				t.Skipf("Cannot reach %s: %v", tc.client, err)
+			}
+			defer client.Close()
+
+			// More datagrams than one batch holds, echoed back in batches
+			const sent = 6
+			for i := 0; i < sent; i++ {
+				if _, err := client.Write([]byte(fmt.Sprintf("query %d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			buf := make([]byte, 512)
+			for i := 0; i < sent; i++ {
+				n, addr, err := c.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(buf[:n]), fmt.Sprintf("query %d", i); got != want {
+					t.Errorf("Expected %q read, got %q", want, got)
+				}
+				if _, err := c.WriteTo(append([]byte("reply to "), buf[:n]...), addr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i := 0; i < sent; i++ {
+				n, err := client.Read(buf)
+				if err != nil {
+					t.Fatalf("Expected %d replies, got %d: %v", sent, i, err)
+				}
+				if got, want := string(buf[:n]), fmt.Sprintf("reply to query %d", i); got != want {
+					t.Errorf("Expected %q, got %q", want, got)
+				}
+			}
+			if errs := c.writeErrors.Load(); errs != 0 {
+				t.Errorf("Expected no write errors, got %d", errs)
+			}
+
+			// Closing stops the writer loop before it returns
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-c.exited:
+			default:
+				t.Error("Expected the writer loop stopped once closed")
+			}
+			if _, err := c.WriteTo([]byte("late"), client.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Expected writes after close to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func mustResolveUDP(t *testing.T, address string) *net.UDPAddr {
+	t.Helper()
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
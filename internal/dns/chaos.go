@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/metrics"
+)
+
+var chaosFaults = metrics.NewCounterVec("ddd_chaos_faults_total",
+	"Upstream faults injected by chaos mode", "fault")
+
+// errChaosTimeout is returned for upstream exchanges chaos mode times out
+var errChaosTimeout = errors.New("chaos: injected upstream timeout")
+
+// chaosInjector fails upstream exchanges on purpose so operators can
+// rehearse failure behaviour. A nil injector injects nothing.
+type chaosInjector struct {
+	cfg     config.ChaosConfig
+	timeout time.Duration // how long an injected timeout blocks
+	rand    func() float64
+}
+
+// newChaosInjector returns nil unless chaos mode is enabled
+func newChaosInjector(cfg config.ChaosConfig, timeout time.Duration) *chaosInjector {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &chaosInjector{cfg: cfg, timeout: timeout, rand: rand.Float64}
+}
+
+// exchange performs an upstream exchange through the chaos injector and
+// returns the resolver that answered, empty for injected faults
+func (s *Server) exchange(r *dns.Msg) (*dns.Msg, string, error) {
+	c := s.chaos
+	if c == nil {
+		return s.forward(r)
+	}
+
+	if c.rand() < c.cfg.TimeoutRate {
+		chaosFaults.With("timeout").Inc()
+		time.Sleep(c.timeout)
+		return nil, "", errChaosTimeout
+	}
+
+	if c.rand() < c.cfg.LatencyRate {
+		chaosFaults.With("latency").Inc()
+		time.Sleep(c.cfg.Latency)
+	}
+
+	if c.rand() < c.cfg.ServfailRate {
+		chaosFaults.With("servfail").Inc()
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		return m, "", nil
+	}
+
+	return s.forward(r)
+}
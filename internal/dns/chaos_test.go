@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+func TestChaosDisabledByDefault(t *testing.T) {
+	if newChaosInjector(config.ChaosConfig{ServfailRate: 1}, time.Second) != nil {
+		t.Error("Expected no injector unless chaos mode is enabled")
+	}
+}
+
+func TestChaosInjectsServfail(t *testing.T) {
+	s := &Server{chaos: newChaosInjector(config.ChaosConfig{Enabled: true, ServfailRate: 1}, time.Second)}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	resp, _, err := s.exchange(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeServerFailure || resp.Id != q.Id {
+		t.Errorf("Expected injected SERVFAIL for query %d, got rcode %d id %d", q.Id, resp.Rcode, resp.Id)
+	}
+}
+
+func TestChaosInjectsTimeout(t *testing.T) {
+	s := &Server{chaos: newChaosInjector(config.ChaosConfig{Enabled: true, TimeoutRate: 1}, 10*time.Millisecond)}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	start := time.Now()
+	if _, _, err := s.exchange(q); err != errChaosTimeout {
+		t.Errorf("Expected injected timeout, got %v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Error("Expected injected timeout to block for the client timeout")
+	}
+}
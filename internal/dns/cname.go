@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// cnameChain follows the CNAME chain starting at name through rrs. It
+// returns the final target, the number of CNAME hops, and whether the chain
+// loops back on itself.
+func cnameChain(name string, rrs []dns.RR) (string, int, bool) {
+	targets := make(map[string]string)
+	for _, rr := range rrs {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			targets[strings.ToLower(cname.Hdr.Name)] = cname.Target
+		}
+	}
+
+	visited := map[string]bool{strings.ToLower(name): true}
+	current := name
+	hops := 0
+
+	for {
+		next, ok := targets[strings.ToLower(current)]
+		if !ok {
+			return current, hops, false
+		}
+		hops++
+		if visited[strings.ToLower(next)] {
+			return next, hops, true
+		}
+		visited[strings.ToLower(next)] = true
+		current = next
+	}
+}
+
+// checkCNAMEChain flags upstream answers whose CNAME chain loops or exceeds
+// the configured length. It reports whether the answer is sane.
+func (s *Server) checkCNAMEChain(domain string, resp *dns.Msg) bool {
+	if len(resp.Question) == 0 {
+		return true
+	}
+
+	_, hops, loop := cnameChain(resp.Question[0].Name, resp.Answer)
+	if !loop && hops <= s.opts.MaxCNAMEChain {
+		return true
+	}
+
+	s.cnameAnomalies.Add(1)
+	reason := "cname chain too long"
+	if loop {
+		reason = "cname loop"
+	}
+	s.log.LogUpstreamAnomaly(domain, reason, hops)
+	return false
+}
+
+// flattenCNAMEs resolves the CNAME chain in resp on behalf of the client and
+// returns an answer containing only the final records, owned by the queried
+// name. resp is returned unchanged if the chain cannot be fully resolved
+// within MaxCNAMEChain hops.
+func (s *Server) flattenCNAMEs(resp *dns.Msg) *dns.Msg {
+	if len(resp.Question) == 0 || resp.Rcode != dns.RcodeSuccess {
+		return resp
+	}
+
+	question := resp.Question[0]
+	if question.Qtype == dns.TypeCNAME || question.Qtype == dns.TypeANY {
+		return resp
+	}
+
+	answers := append([]dns.RR(nil), resp.Answer...)
+	visited := map[string]bool{}
+
+	for {
+		target, hops, loop := cnameChain(question.Name, answers)
+		if loop || hops > s.opts.MaxCNAMEChain {
+			return resp
+		}
+		if hops == 0 {
+			// No CNAME to flatten
+			return resp
+		}
+
+		final := recordsFor(target, question.Qtype, answers)
+		if len(final) > 0 {
+			return buildFlattened(resp, question, answers, final)
+		}
+
+		// Chain ends in a name the upstream did not resolve for us
+		key := strings.ToLower(target)
+		if visited[key] {
+			return resp
+		}
+		visited[key] = true
+
+		next := new(dns.Msg)
+		next.SetQuestion(target, question.Qtype)
+		next.RecursionDesired = true
+
+		nextResp, _, err := s.exchange(next)
+		if err != nil || nextResp.Rcode != dns.RcodeSuccess || len(nextResp.Answer) == 0 {
+			return resp
+		}
+		answers = append(answers, nextResp.Answer...)
+	}
+}
+
+// recordsFor returns the records of qtype owned by name
+func recordsFor(name string, qtype uint16, rrs []dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, name) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// buildFlattened copies resp with the answer replaced by final records
+// renamed to the question name. The TTL is capped at the smallest TTL
+// along the chain so the flattened answer expires no later than any link.
+func buildFlattened(resp *dns.Msg, question dns.Question, chain, final []dns.RR) *dns.Msg {
+	minTTL := ^uint32(0)
+	for _, rr := range chain {
+		if rr.Header().Rrtype == dns.TypeCNAME && rr.Header().Ttl < minTTL {
+			minTTL = rr.Header().Ttl
+		}
+	}
+
+	flat := resp.Copy()
+	flat.Answer = make([]dns.RR, 0, len(final))
+	for _, rr := range final {
+		rr = dns.Copy(rr)
+		rr.Header().Name = question.Name
+		if rr.Header().Ttl > minTTL {
+			rr.Header().Ttl = minTTL
+		}
+		flat.Answer = append(flat.Answer, rr)
+	}
+
+	return flat
+}
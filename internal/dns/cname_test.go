@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestCNAMEChain(t *testing.T) {
+	rrs := []dns.RR{
+		mustRR(t, "www.example.com. 300 IN CNAME edge.example.net."),
+		mustRR(t, "edge.example.net. 60 IN CNAME pop1.example.net."),
+		mustRR(t, "pop1.example.net. 30 IN A 192.0.2.1"),
+	}
+
+	target, hops, loop := cnameChain("www.example.com.", rrs)
+	if target != "pop1.example.net." || hops != 2 || loop {
+		t.Errorf("Expected pop1.example.net. after 2 hops, got %s after %d (loop=%v)", target, hops, loop)
+	}
+}
+
+func TestCNAMELoop(t *testing.T) {
+	rrs := []dns.RR{
+		mustRR(t, "a.example.com. 300 IN CNAME b.example.com."),
+		mustRR(t, "b.example.com. 300 IN CNAME a.example.com."),
+	}
+
+	if _, _, loop := cnameChain("a.example.com.", rrs); !loop {
+		t.Error("Expected CNAME loop to be detected")
+	}
+}
+
+func TestBuildFlattened(t *testing.T) {
+	chain := []dns.RR{
+		mustRR(t, "www.example.com. 300 IN CNAME edge.example.net."),
+		mustRR(t, "edge.example.net. 60 IN A 192.0.2.1"),
+	}
+
+	resp := new(dns.Msg)
+	resp.SetQuestion("www.example.com.", dns.TypeA)
+	resp.Answer = chain
+
+	flat := buildFlattened(resp, resp.Question[0], chain, chain[1:])
+	if len(flat.Answer) != 1 {
+		t.Fatalf("Expected one flattened record, got %d", len(flat.Answer))
+	}
+	if flat.Answer[0].Header().Name != "www.example.com." || flat.Answer[0].Header().Ttl != 60 {
+		t.Errorf("Expected www.example.com. with ttl 60, got %s", flat.Answer[0])
+	}
+}
@@ -0,0 +1,49 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+// costWriter charges the client for the size of each response it is sent
+type costWriter struct {
+	dns.ResponseWriter
+	server   *Server
+	clientIP string
+}
+
+// costWriter returns w wrapped to charge clientIP per KiB of response
+// when cost budgets are on; otherwise w is returned unchanged
+func (s *Server) costWriter(w dns.ResponseWriter, clientIP string) dns.ResponseWriter {
+	if !s.opts.Cost.Enabled || s.opts.Cost.PerKiB <= 0 {
+		return w
+	}
+	return &costWriter{ResponseWriter: w, server: s, clientIP: clientIP}
+}
+
+// WriteMsg charges for the size of m and writes it
+func (w *costWriter) WriteMsg(m *dns.Msg) error {
+	w.server.trafficMonitor.RecordCost(w.clientIP, w.server.opts.Cost.PerKiB*float64(m.Len())/1024)
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// chargeCost charges clientIP the weight of the verdict h its query was
+// handled with
+func (s *Server) chargeCost(clientIP string, h *metrics.Histogram) {
+	if !s.opts.Cost.Enabled {
+		return
+	}
+	var units float64
+	switch h {
+	case latencyCache:
+		units = s.opts.Cost.Cache
+	case latencyForwarded:
+		units = s.opts.Cost.Forwarded
+	case latencyRefused, latencyBlocked:
+		units = s.opts.Cost.Refused
+	default:
+		return
+	}
+	s.trafficMonitor.RecordCost(clientIP, units)
+}
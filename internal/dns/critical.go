@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+// criticalRule marks queries for a domain suffix (and optionally a qtype)
+// as critical
+type criticalRule struct {
+	suffix string
+	qtype  uint16
+}
+
+// criticalClassifier decides whether a query belongs to the critical class
+// of service, which is exempt from global overload shedding
+type criticalClassifier struct {
+	rules []criticalRule
+}
+
+// newCriticalClassifier builds a classifier from the configured entries.
+// config.Validate rejects unknown qtypes; one that gets here is skipped
+// rather than marking every type critical.
+func newCriticalClassifier(entries []config.CriticalQuery) *criticalClassifier {
+	c := &criticalClassifier{}
+
+	for _, e := range entries {
+		rule := criticalRule{suffix: strings.ToLower(strings.Trim(e.Domain, "."))}
+		if e.QType != "" {
+			qtype, ok := dns.StringToType[strings.ToUpper(e.QType)]
+			if !ok {
+				continue
+			}
+			rule.qtype = qtype
+		}
+		c.rules = append(c.rules, rule)
+	}
+
+	return c
+}
+
+// isCritical reports whether the question matches a critical rule
+func (c *criticalClassifier) isCritical(q dns.Question) bool {
+	if len(c.rules) == 0 {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	for _, rule := range c.rules {
+		if rule.qtype != 0 && rule.qtype != q.Qtype {
+			continue
+		}
+		if rule.suffix == "" || name == rule.suffix || strings.HasSuffix(name, "."+rule.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
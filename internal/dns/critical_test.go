@@ -0,0 +1,35 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+func TestCriticalClassifier(t *testing.T) {
+	c := newCriticalClassifier([]config.CriticalQuery{
+		{Domain: "example.org"},
+		{Domain: "in-addr.arpa", QType: "PTR"},
+	})
+
+	cases := []struct {
+		name     string
+		qtype    uint16
+		critical bool
+	}{
+		{"example.org.", dns.TypeA, true},
+		{"mail.example.org.", dns.TypeMX, true},
+		{"notexample.org.", dns.TypeA, false},
+		{"1.2.0.192.in-addr.arpa.", dns.TypePTR, true},
+		{"1.2.0.192.in-addr.arpa.", dns.TypeTXT, false},
+	}
+
+	for _, tc := range cases {
+		q := dns.Question{Name: tc.name, Qtype: tc.qtype, Qclass: dns.ClassINET}
+		if got := c.isCritical(q); got != tc.critical {
+			t.Errorf("isCritical(%s %s) = %v, want %v", tc.name, dns.TypeToString[tc.qtype], got, tc.critical)
+		}
+	}
+}
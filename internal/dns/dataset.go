@@ -0,0 +1,39 @@
+package dns
+
+import (
+	"strings"
+	"time"
+
+	"ddd/internal/dataset"
+	"ddd/internal/detector"
+	"ddd/internal/policy"
+)
+
+// exportSample hands a detection decision and the client's features to
+// the training set exporter, when it wants this one. decision is empty
+// for benign traffic.
+func (s *Server) exportSample(clientIP, domain, qtype string, result *detector.DetectionResult, decision policy.Decision) {
+	if !s.opts.Dataset.Wants(result.IsAttack) {
+		return
+	}
+	window := s.trafficMonitor.RateWindow()
+	sample := dataset.Sample{
+		Time:       time.Now(),
+		Client:     clientIP,
+		QName:      strings.ToLower(domain),
+		QType:      qtype,
+		Requests:   s.trafficMonitor.GetRecentRequestCount(clientIP, window),
+		Failures:   s.trafficMonitor.GetRecentFailureCount(clientIP, window),
+		NewDomains: s.trafficMonitor.GetRecentNewDomainCount(clientIP, window),
+		Decision:   string(decision),
+	}
+	if firstSeen, requests, ok := s.trafficMonitor.GetClientAge(clientIP); ok {
+		sample.ClientAge = sample.Time.Sub(firstSeen)
+		sample.ClientRequests = requests
+	}
+	if result.IsAttack {
+		sample.Verdict = result.AttackType
+		sample.Severity = result.Severity.String()
+	}
+	s.opts.Dataset.Record(sample)
+}
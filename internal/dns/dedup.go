@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var duplicatesSuppressed = metrics.NewCounter("ddd_duplicate_queries_suppressed_total",
+	"Client retransmissions answered from an in-flight or just-answered query")
+
+// flight is one upstream resolution that retransmissions can wait on
+type flight struct {
+	done     chan struct{}
+	resp     *dns.Msg // nil when the resolution failed
+	finished time.Time
+}
+
+// dupSuppressor recognises a client retransmitting the identical query
+// (same ID, name and type) and lets it share the original resolution
+// instead of forwarding it upstream again
+type dupSuppressor struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	flights   map[string]*flight
+	lastSweep time.Time
+}
+
+// newDupSuppressor creates a suppressor that remembers answers for window
+// after they complete; a non-positive window disables it
+func newDupSuppressor(window time.Duration) *dupSuppressor {
+	if window <= 0 {
+		return nil
+	}
+	return &dupSuppressor{window: window, flights: make(map[string]*flight)}
+}
+
+// dupKey identifies a query from a client
+func dupKey(clientIP string, r *dns.Msg) string {
+	q := r.Question[0]
+	return clientIP + "|" + strconv.Itoa(int(r.Id)) + "|" + strings.ToLower(q.Name) + "|" + strconv.Itoa(int(q.Qtype))
+}
+
+// begin returns the flight for key and whether the caller started it and
+// must resolve the query and call finish
+func (d *dupSuppressor) begin(key string) (*flight, bool) {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) >= d.window {
+		for k, f := range d.flights {
+			if !f.finished.IsZero() && now.Sub(f.finished) >= d.window {
+				delete(d.flights, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if f, ok := d.flights[key]; ok && (f.finished.IsZero() || now.Sub(f.finished) < d.window) {
+		return f, false
+	}
+
+	f := &flight{done: make(chan struct{})}
+	d.flights[key] = f
+	return f, true
+}
+
+// finish records the outcome of a flight and releases its waiters
+func (d *dupSuppressor) finish(f *flight, resp *dns.Msg) {
+	d.mu.Lock()
+	f.resp = resp
+	f.finished = time.Now()
+	d.mu.Unlock()
+	close(f.done)
+}
+
+// wait blocks until the flight finishes or timeout elapses and returns a
+// copy of its response, or nil if there is none
+func (f *flight) wait(timeout time.Duration) *dns.Msg {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+	case <-timer.C:
+		return nil
+	}
+	if f.resp == nil {
+		return nil
+	}
+	return f.resp.Copy()
+}
@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDuplicateSharesInFlightAnswer(t *testing.T) {
+	d := newDupSuppressor(time.Second)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	key := dupKey("192.0.2.1", q)
+
+	f, first := d.begin(key)
+	if !first {
+		t.Fatal("Expected the first query to start a flight")
+	}
+
+	dup, first := d.begin(key)
+	if first || dup != f {
+		t.Fatal("Expected a retransmission to join the in-flight query")
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	go d.finish(f, resp)
+
+	got := dup.wait(time.Second)
+	if got == nil || got.Id != q.Id {
+		t.Fatalf("Expected the shared answer, got %v", got)
+	}
+
+	// Just answered: still shared within the window
+	if _, first := d.begin(key); first {
+		t.Error("Expected a retransmission right after the answer to be suppressed")
+	}
+}
+
+func TestDuplicateKeyDistinguishesQueries(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	other := q.Copy()
+	other.Id++
+
+	if dupKey("192.0.2.1", q) == dupKey("192.0.2.1", other) {
+		t.Error("Expected different query IDs to be distinct")
+	}
+	if dupKey("192.0.2.1", q) == dupKey("192.0.2.2", q) {
+		t.Error("Expected different clients to be distinct")
+	}
+}
@@ -0,0 +1,293 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/metrics"
+	"ddd/internal/upgrade"
+)
+
+// dohMediaType is the DNS message media type of RFC 8484
+const dohMediaType = "application/dns-message"
+
+// dohListener serves DNS over HTTPS (RFC 8484) on one path, or over plain
+// HTTP for a TLS terminating proxy in front. Queries are answered by the
+// same handler as every other transport; clients count as encrypted.
+// Trusted proxies' connections carry a PROXY v2 header first, as on TCP.
+type dohListener struct {
+	s       *Server
+	cfg     config.ListenerConfig
+	path    string
+	trusted []string
+	handler dns.Handler
+	errors  *metrics.Counter
+
+	mu       sync.Mutex
+	srv      *http.Server
+	listener net.Listener
+}
+
+// newDoHListener creates a DoH listener for cfg
+func (s *Server) newDoHListener(cfg config.ListenerConfig, trusted []string) *dohListener {
+	path := cfg.Path
+	if path == "" {
+		path = config.DefaultDoHPath
+	}
+	return &dohListener{
+		s:       s,
+		cfg:     cfg,
+		path:    path,
+		trusted: trusted,
+		handler: s.protocolHandler(config.ProtocolDoH),
+		errors:  listenerErrors.With(config.ProtocolDoH),
+	}
+}
+
+// Name identifies the listener
+func (l *dohListener) Name() string {
+	return config.ProtocolDoH + "@" + l.cfg.Listen
+}
+
+// Protocol returns doh
+func (l *dohListener) Protocol() string {
+	return config.ProtocolDoH
+}
+
+// Listen opens the socket and loads the certificate, if any
+func (l *dohListener) Listen() error {
+	trusted, err := parseCIDRs(l.trusted)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if l.cfg.TLSCert != "" {
+		if tlsConfig, err = loadTLSConfig(l.cfg, "h2", "http/1.1"); err != nil {
+			return err
+		}
+	}
+
+	listener, err := upgrade.Listen("tcp", l.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	if len(trusted) > 0 {
+		listener = &proxyListener{Listener: listener, trusted: trusted}
+	}
+	if l.s.tcpGuard != nil {
+		listener = &guardListener{Listener: listener, guard: l.s.tcpGuard}
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(&encryptedListener{Listener: listener}, tlsConfig)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(l.path, l.serveHTTP)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listener = listener
+	l.srv = &http.Server{
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ConnContext:       withConn,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	return nil
+}
+
+// Serve answers queries until Shutdown
+func (l *dohListener) Serve() error {
+	l.mu.Lock()
+	srv, listener := l.srv, l.listener
+	l.mu.Unlock()
+
+	err := srv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown closes the socket and waits briefly for queries in progress
+func (l *dohListener) Shutdown() error {
+	l.mu.Lock()
+	srv := l.srv
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	upgrade.Release("tcp", l.cfg.Listen)
+	return err
+}
+
+// serveHTTP answers one DoH request: a GET with the query in the dns
+// parameter, or a POST with it as the body
+func (l *dohListener) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var packed []byte
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil || len(packed) == 0 {
+			l.fail(w, http.StatusBadRequest, "invalid dns parameter")
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			l.fail(w, http.StatusUnsupportedMediaType, "content type must be "+dohMediaType)
+			return
+		}
+		var err error
+		packed, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize+1))
+		if err != nil || len(packed) > dns.MaxMsgSize {
+			l.fail(w, http.StatusBadRequest, "invalid query")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		l.fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Screen the header as the other transports do before decoding
+	if len(packed) < headerLen || acceptMessage(dohHeader(packed)) != dns.MsgAccept {
+		l.fail(w, http.StatusBadRequest, "invalid query")
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(packed); err != nil {
+		l.fail(w, http.StatusBadRequest, "invalid query")
+		return
+	}
+
+	rw := &dohWriter{local: l.localAddr(r), remote: dohRemoteAddr(r)}
+	l.handler.ServeDNS(rw, req)
+	if rw.msg == nil {
+		// Dropped: there is no answer to give
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohMediaType)
+	// The lowest TTL bounds how long HTTP caches may keep the answer
+	// (RFC 8484 section 5.1)
+	if len(rw.msg.Answer)+len(rw.msg.Ns) > 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(minTTL(rw.msg)/time.Second)))
+	}
+	if _, err := w.Write(rw.packed); err != nil {
+		l.errors.Inc()
+	}
+}
+
+// fail rejects a request that carried no valid query
+func (l *dohListener) fail(w http.ResponseWriter, status int, msg string) {
+	l.errors.Inc()
+	http.Error(w, msg, status)
+}
+
+// localAddr returns the address the request was received on
+func (l *dohListener) localAddr(r *http.Request) net.Addr {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return nil
+}
+
+// connKey is the request context key of the connection a DoH request
+// arrived on
+type connKey struct{}
+
+// withConn records a DoH connection in its requests' context
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// dohRemoteAddr returns the client of a DoH request as an encrypted
+// transport client, with its TLS fingerprint when this server terminated
+// its TLS. Behind a trusted proxy the request's remote address is the
+// client named in the PROXY header.
+func dohRemoteAddr(r *http.Request) net.Addr {
+	client := &encryptedAddr{Client: &net.TCPAddr{}}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		client.Client = addr
+	}
+	if c, ok := r.Context().Value(connKey{}).(*tls.Conn); ok {
+		if conn, ok := c.NetConn().(*encryptedConn); ok {
+			client.Fingerprint = conn.fingerprint.Load()
+		}
+	}
+	return client
+}
+
+// dohHeader decodes the fixed header of a packed message
+func dohHeader(packed []byte) dns.Header {
+	return dns.Header{
+		Id:      binary.BigEndian.Uint16(packed[0:]),
+		Bits:    binary.BigEndian.Uint16(packed[2:]),
+		Qdcount: binary.BigEndian.Uint16(packed[4:]),
+		Ancount: binary.BigEndian.Uint16(packed[6:]),
+		Nscount: binary.BigEndian.Uint16(packed[8:]),
+		Arcount: binary.BigEndian.Uint16(packed[10:]),
+	}
+}
+
+// dohWriter collects the handler's response to a DoH request
+type dohWriter struct {
+	local, remote net.Addr
+	msg           *dns.Msg
+	packed        []byte
+}
+
+// LocalAddr returns the address the request was received on
+func (w *dohWriter) LocalAddr() net.Addr { return w.local }
+
+// RemoteAddr returns the client address
+func (w *dohWriter) RemoteAddr() net.Addr { return w.remote }
+
+// WriteMsg packs the response
+func (w *dohWriter) WriteMsg(m *dns.Msg) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	w.msg, w.packed = m, packed
+	return nil
+}
+
+// Write takes a packed response
+func (w *dohWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+	w.msg, w.packed = m, append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// Close does nothing; the HTTP server owns the connection
+func (w *dohWriter) Close() error { return nil }
+
+// TsigStatus reports no TSIG error; DoH requests are not signed
+func (w *dohWriter) TsigStatus() error { return nil }
+
+// TsigTimersOnly does nothing
+func (w *dohWriter) TsigTimersOnly(bool) {}
+
+// Hijack does nothing; the HTTP server owns the connection
+func (w *dohWriter) Hijack() {}
@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+// newTestDoHListener answers every query with an A record of TTL 300
+func newTestDoHListener(t *testing.T) *dohListener {
+	t.Helper()
+	l := (&Server{}).newDoHListener(config.ListenerConfig{Protocol: config.ProtocolDoH, Listen: ":443"}, nil)
+	l.handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*encryptedAddr); !ok {
+			t.Errorf("Expected DoH clients on an encrypted transport, got %T", w.RemoteAddr())
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 192.0.2.1")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})
+	return l
+}
+
+func TestDoHGetAndPost(t *testing.T) {
+	l := newTestDoHListener(t)
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.Id = 0 // RFC 8484 asks GET clients to use 0 for cacheability
+	packed, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := map[string]*http.Request{
+		"GET":  httptest.NewRequest(http.MethodGet, "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(packed), nil),
+		"POST": httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(packed)),
+	}
+	requests["POST"].Header.Set("Content-Type", dohMediaType)
+
+	for method, req := range requests {
+		rec := httptest.NewRecorder()
+		l.serveHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != dohMediaType {
+			t.Fatalf("%s: expected a DNS message, got %d %q", method, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "max-age=300" {
+			t.Errorf("%s: expected max-age from the answer TTL, got %q", method, cc)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(rec.Body.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Answer) != 1 || resp.Question[0].Name != "example.com." {
+			t.Errorf("%s: unexpected response %v", method, resp)
+		}
+	}
+}
+
+func TestDoHRejectsInvalidRequests(t *testing.T) {
+	l := newTestDoHListener(t)
+	response := new(dns.Msg)
+	response.SetQuestion("example.com.", dns.TypeA)
+	response.Response = true
+	packedResponse, _ := response.Pack()
+
+	tests := map[string]struct {
+		req  *http.Request
+		code int
+	}{
+		"missing parameter": {httptest.NewRequest(http.MethodGet, "/dns-query", nil), http.StatusBadRequest},
+		"not base64url":     {httptest.NewRequest(http.MethodGet, "/dns-query?dns=!!", nil), http.StatusBadRequest},
+		"short message":     {httptest.NewRequest(http.MethodGet, "/dns-query?dns=AAAA", nil), http.StatusBadRequest},
+		"response":          {httptest.NewRequest(http.MethodGet, "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(packedResponse), nil), http.StatusBadRequest},
+		"wrong media type":  {httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(packedResponse)), http.StatusUnsupportedMediaType},
+		"wrong method":      {httptest.NewRequest(http.MethodPut, "/dns-query", nil), http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		rec := httptest.NewRecorder()
+		l.serveHTTP(rec, tt.req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", name, tt.code, rec.Code)
+		}
+	}
+}
+
+func TestDoHBehindTrustedProxy(t *testing.T) {
+	l := (&Server{}).newDoHListener(config.ListenerConfig{Protocol: config.ProtocolDoH, Listen: "127.0.0.1:0"}, []string{"127.0.0.1"})
+	clients := make(chan string, 1)
+	l.handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		clients <- w.RemoteAddr().String()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	if err := l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go l.Serve()
+	defer l.Shutdown()
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	packed, _ := q.Pack()
+	conn, err := net.Dial("tcp", l.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(proxyV2Header(net.ParseIP("203.0.113.9"), 40000))
+	fmt.Fprintf(conn, "POST /dns-query HTTP/1.1\r\nHost: dns.example\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", dohMediaType, len(packed))
+	conn.Write(packed)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the query answered, got %d", resp.StatusCode)
+	}
+	if client := <-clients; client != "203.0.113.9:40000" {
+		t.Errorf("Expected the client named by the proxy, got %s", client)
+	}
+}
@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"errors"
+
+	"ddd/internal/config"
+)
+
+// errDoQUnsupported is returned by the DoQ listener until a QUIC stack is
+// available
+var errDoQUnsupported = errors.New("DNS over QUIC is not supported yet")
+
+// doqListener holds the place of DNS over QUIC (RFC 9250) among the
+// listeners. The module has no QUIC stack, so it never opens; config
+// validation rejects doq listeners before one is created.
+type doqListener struct {
+	cfg config.ListenerConfig
+}
+
+// newDoQListener creates a DoQ listener for cfg
+func (s *Server) newDoQListener(cfg config.ListenerConfig) *doqListener {
+	return &doqListener{cfg: cfg}
+}
+
+// Name identifies the listener
+func (l *doqListener) Name() string {
+	return config.ProtocolDoQ + "@" + l.cfg.Listen
+}
+
+// Protocol returns doq
+func (l *doqListener) Protocol() string {
+	return config.ProtocolDoQ
+}
+
+// Listen fails: there is no QUIC stack to listen with
+func (l *doqListener) Listen() error {
+	return errDoQUnsupported
+}
+
+// Serve is never reached, as Listen fails
+func (l *doqListener) Serve() error {
+	return errDoQUnsupported
+}
+
+// Shutdown does nothing; the listener never opened
+func (l *doqListener) Shutdown() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/mobility"
+)
+
+// observeDualStack hands the evidence of a query to the dual-stack
+// correlator: the client cookie, and the query fingerprint with the TLS
+// fingerprint of encrypted clients. Only queries over TCP, DoT and DoH
+// count, as a UDP source address can be forged.
+func (s *Server) observeDualStack(w dns.ResponseWriter, r *dns.Msg, clientIP, domain string) {
+	if s.opts.DualStack == nil {
+		return
+	}
+	switch w.RemoteAddr().(type) {
+	case *encryptedAddr, *net.TCPAddr:
+	default:
+		return
+	}
+	fingerprint := mobility.QueryFingerprint(r) + "/" + clientFingerprint(w.RemoteAddr())
+	s.opts.DualStack.Observe(clientIP, clientCookie(r), fingerprint, domain, time.Now())
+}
+
+// clientCookie returns the client cookie of a query's EDNS COOKIE option
+// (RFC 7873), or "" without one
+func clientCookie(r *dns.Msg) string {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, o := range opt.Option {
+		// The client cookie is the first 8 bytes, in hex
+		if c, ok := o.(*dns.EDNS0_COOKIE); ok && len(c.Cookie) >= 16 {
+			return c.Cookie[:16]
+		}
+	}
+	return ""
+}
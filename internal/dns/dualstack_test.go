@@ -0,0 +1,49 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/dualstack"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+)
+
+func TestDualStackIgnoresUDPEvidence(t *testing.T) {
+	b := blocker.NewIPBlocker(300, events.NewBus())
+	s := &Server{opts: Options{DualStack: dualstack.New(config.Default().DualStack, b, logger.NewNop())}}
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	r.SetEdns0(1232, false)
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"})
+
+	// Forged queries from a victim's IPv4 address, alongside the
+	// attacker's own IPv6 address
+	victim := &recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 53000}}
+	attacker := &recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::10"), Port: 53000}}
+	for i := 0; i < 3; i++ {
+		s.observeDualStack(victim, r, "192.0.2.10", "example.com")
+		s.observeDualStack(attacker, r, "2001:db8::10", "example.com")
+	}
+	if peers := s.opts.DualStack.Peers("192.0.2.10", time.Now()); len(peers) != 0 {
+		t.Fatalf("Expected UDP evidence to link nothing, got %v", peers)
+	}
+
+	// The same evidence over TCP links them
+	victim.addr = &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 53000}
+	attacker.addr = &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 53000}
+	for i := 0; i < 2; i++ {
+		s.observeDualStack(victim, r, "192.0.2.10", "example.com")
+		s.observeDualStack(attacker, r, "2001:db8::10", "example.com")
+	}
+	if peers := s.opts.DualStack.Peers("192.0.2.10", time.Now()); len(peers) != 1 {
+		t.Errorf("Expected TCP evidence to link the addresses, got %v", peers)
+	}
+}
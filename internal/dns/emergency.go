@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+	"ddd/internal/recursor"
+)
+
+var (
+	emergencyGauge = metrics.NewGauge("ddd_emergency_recursion",
+		"1 while every upstream is down and critical domains are resolved locally")
+	emergencyResolutions = metrics.NewCounterVec("ddd_emergency_resolutions_total",
+		"Critical queries resolved by local recursion while upstreams were down, by result", "result")
+)
+
+// recursionUpstream names local recursion where an upstream is expected
+const recursionUpstream = "recursion"
+
+// emergencyFallback resolves critical queries by local recursion from the
+// root hints once every upstream exchange has failed for longer than
+// after. Any successful exchange ends the emergency.
+type emergencyFallback struct {
+	resolver *recursor.Resolver
+	after    time.Duration
+	events   *events.Bus
+
+	failingSince atomic.Int64 // unix nanos of the first failure since the last success; 0 while healthy
+	engaged      atomic.Bool
+	lastProbe    atomic.Int64 // unix seconds of the last upstream probe while engaged
+}
+
+// newEmergencyFallback creates the fallback, or nil without a resolver
+func newEmergencyFallback(resolver *recursor.Resolver, after time.Duration, bus *events.Bus) *emergencyFallback {
+	if resolver == nil {
+		return nil
+	}
+	return &emergencyFallback{resolver: resolver, after: after, events: bus}
+}
+
+// observe records the outcome of an upstream exchange
+func (e *emergencyFallback) observe(err error, now time.Time) {
+	if e == nil {
+		return
+	}
+	if err != nil {
+		e.failingSince.CompareAndSwap(0, now.UnixNano())
+		return
+	}
+	since := e.failingSince.Swap(0)
+	if e.engaged.CompareAndSwap(true, false) {
+		emergencyGauge.Set(0)
+		e.events.Publish(events.Event{
+			Type:     events.UpstreamsRecovered,
+			Duration: now.Sub(time.Unix(0, since)),
+		})
+	}
+}
+
+// degraded reports whether upstreams have been failing for longer than
+// the threshold, announcing the emergency the first time it does
+func (e *emergencyFallback) degraded(now time.Time) bool {
+	if e == nil {
+		return false
+	}
+	since := e.failingSince.Load()
+	if since == 0 || now.Sub(time.Unix(0, since)) < e.after {
+		return false
+	}
+	if e.engaged.CompareAndSwap(false, true) {
+		emergencyGauge.Set(1)
+		e.events.Publish(events.Event{
+			Type:     events.UpstreamsDown,
+			Duration: now.Sub(time.Unix(0, since)),
+		})
+	}
+	return true
+}
+
+// probeDue reports whether a critical query should still try upstream
+// first, so recovery is noticed: at most one a second while degraded
+func (e *emergencyFallback) probeDue(now time.Time) bool {
+	sec := now.Unix()
+	last := e.lastProbe.Load()
+	return last != sec && e.lastProbe.CompareAndSwap(last, sec)
+}
+
+// exchangeOrRecurse queries upstream, or resolves a critical query by
+// local recursion while every upstream is down
+func (s *Server) exchangeOrRecurse(r *dns.Msg) (*dns.Msg, string, error) {
+	e := s.emergency
+	critical := e != nil && len(r.Question) > 0 && s.critical.isCritical(r.Question[0])
+	if critical && e.degraded(time.Now()) && !e.probeDue(time.Now()) {
+		return s.recurse(r)
+	}
+
+	resp, upstream, err := s.exchange(r)
+	e.observe(err, time.Now())
+	if err != nil && critical && e.degraded(time.Now()) {
+		s.log.Debugw("Upstream failed, resolving critical query locally", "upstream", upstream, "error", err)
+		return s.recurse(r)
+	}
+	return resp, upstream, err
+}
+
+// recurse resolves r from the root hints
+func (s *Server) recurse(r *dns.Msg) (*dns.Msg, string, error) {
+	resp, err := s.emergency.resolver.Resolve(r)
+	if err != nil {
+		emergencyResolutions.With("error").Inc()
+		return nil, recursionUpstream, err
+	}
+	emergencyResolutions.With("answer").Inc()
+	return resp, recursionUpstream, nil
+}
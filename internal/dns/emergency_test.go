@@ -0,0 +1,56 @@
+//go:build recursion
+
+package dns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/recursor"
+)
+
+func TestEmergencyFallback(t *testing.T) {
+	bus := events.NewBus()
+	ch := bus.Subscribe("test", 8)
+	e := newEmergencyFallback(recursor.New(nil, time.Second, 8), 30*time.Second, bus)
+	start := time.Now()
+	down := errors.New("timeout")
+
+	if e.degraded(start) {
+		t.Fatal("Expected a healthy start")
+	}
+	e.observe(down, start)
+	e.observe(down, start.Add(20*time.Second))
+	if e.degraded(start.Add(29 * time.Second)) {
+		t.Error("Expected no emergency before the threshold")
+	}
+	if !e.degraded(start.Add(30*time.Second)) || !e.degraded(start.Add(31*time.Second)) {
+		t.Error("Expected an emergency once upstreams failed for the threshold")
+	}
+	e.observe(nil, start.Add(40*time.Second))
+	if e.degraded(start.Add(41 * time.Second)) {
+		t.Error("Expected a successful exchange to end the emergency")
+	}
+
+	var got []events.Event
+	for len(ch) > 0 {
+		got = append(got, <-ch)
+	}
+	if len(got) != 2 || got[0].Type != events.UpstreamsDown || got[1].Type != events.UpstreamsRecovered ||
+		got[1].Duration != 40*time.Second {
+		t.Errorf("Expected one down and one recovered event, got %+v", got)
+	}
+
+	now := start.Add(time.Minute)
+	if !e.probeDue(now) || e.probeDue(now) || !e.probeDue(now.Add(time.Second)) {
+		t.Error("Expected one upstream probe a second")
+	}
+
+	var none *emergencyFallback
+	none.observe(down, start)
+	if none.degraded(start.Add(time.Hour)) {
+		t.Error("Expected no emergency without a resolver")
+	}
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"net"
+	"time"
+
+	"ddd/internal/metrics"
+)
+
+var preParseFiltered = metrics.NewCounterVec("ddd_preparse_filtered_total",
+	"Datagrams from blocked sources handled before DNS message decoding", "action")
+
+// headerLen is the size of the fixed DNS message header
+const headerLen = 12
+
+// filterConn sits between the listener and the DNS server's read loop.
+// Datagrams from blocked sources are refused or dropped here, before
+// miekg/dns unpacks them, so a flood from a blocked IP costs a map lookup
+// rather than a full decode.
+type filterConn struct {
+	net.PacketConn
+	s *Server
+}
+
+// ReadFrom returns the next datagram from a source that is not blocked
+func (c *filterConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+		c.s.opts.Capture.Packet(addr, c.LocalAddr(), b[:n])
+
+		if !c.filter(b[:n], addr) {
+			return n, addr, nil
+		}
+		// Never reaching the handler, where queries are counted
+		c.s.queries.Add(1)
+	}
+}
+
+// filter reports whether the datagram was handled (refused or dropped)
+// and must not reach the DNS handler
+func (c *filterConn) filter(packet []byte, addr net.Addr) bool {
+	ip := c.s.extractClientIP(addr)
+	now := time.Now()
+
+	v := c.s.verdicts.lookup(ip, now)
+	if v == verdictNone {
+		if !c.s.ipBlocker.IsBlocked(ip) {
+			return false
+		}
+		c.s.verdicts.refuse(ip, now)
+		v = verdictRefuse
+	}
+
+	preParseFiltered.With(v.String()).Inc()
+	if v == verdictRefuse {
+		if reply := rawRefusal(packet); reply != nil {
+			c.PacketConn.WriteTo(reply, addr)
+			c.s.finish(ip, "", latencyPrefiltered, now)
+			return true
+		}
+	}
+	c.s.opts.Summary.Record(ip, "", "dropped")
+	return true
+}
+
+// rawRefusal builds a REFUSED response to query by copying its header and
+// first question and patching the flags, without decoding the message.
+// It returns nil for datagrams that are not a plain single-question query.
+func rawRefusal(query []byte) []byte {
+	if len(query) < headerLen || query[2]&0x80 != 0 { // too short, or a response
+		return nil
+	}
+	if query[4] != 0 || query[5] != 1 { // QDCOUNT must be 1
+		return nil
+	}
+
+	// Walk the question name; queries never use compression pointers
+	end := headerLen
+	for {
+		if end >= len(query) {
+			return nil
+		}
+		labelLen := int(query[end])
+		if labelLen == 0 {
+			end++
+			break
+		}
+		if labelLen&0xC0 != 0 {
+			return nil
+		}
+		end += 1 + labelLen
+	}
+	end += 4 // QTYPE, QCLASS
+	if end > len(query) {
+		return nil
+	}
+
+	reply := make([]byte, end)
+	copy(reply, query[:end])
+	reply[2] = 0x80 | query[2]&0x79 // QR, keep opcode and RD
+	reply[3] = 0x80 | 0x05          // RA, RCODE=REFUSED
+	// ANCOUNT, NSCOUNT, ARCOUNT
+	for i := 6; i < headerLen; i++ {
+		reply[i] = 0
+	}
+	return reply
+}
@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"ddd/internal/blocker"
+	"github.com/miekg/dns"
+)
+
+func TestRawRefusal(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeAAAA)
+	q.SetEdns0(1232, false)
+	packed, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(rawRefusal(packed)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reply.Response || reply.Rcode != dns.RcodeRefused || reply.Id != q.Id {
+		t.Errorf("Expected REFUSED response to query %d, got %v", q.Id, reply)
+	}
+	if !reply.RecursionDesired {
+		t.Error("Expected RD to be copied from the query")
+	}
+	if len(reply.Question) != 1 || reply.Question[0] != q.Question[0] {
+		t.Errorf("Expected question to be echoed, got %v", reply.Question)
+	}
+	if len(reply.Answer)+len(reply.Ns)+len(reply.Extra) != 0 {
+		t.Error("Expected no records beyond the question")
+	}
+}
+
+func TestRawRefusalRejectsMalformed(t *testing.T) {
+	for name, packet := range map[string][]byte{
+		"short":     {0x12, 0x34, 0x01},
+		"truncated": {0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 3, 'w', 'w'},
+		"response":  {0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1},
+	} {
+		if rawRefusal(packet) != nil {
+			t.Errorf("Expected no refusal for %s packet", name)
+		}
+	}
+}
+
+func TestFilterRecordsPrefilteredLatency(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := &Server{ipBlocker: blocker.NewIPBlocker(300, nil)}
+	s.ipBlocker.BlockIP("127.0.0.1", "test")
+	c := &filterConn{PacketConn: conn, s: s}
+
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.com.", dns.TypeA)
+	packed, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, answered := latencyPrefiltered.Count(), latencyForwarded.Count()
+	if !c.filter(packed, conn.LocalAddr()) {
+		t.Fatal("Expected datagram from blocked source to be filtered")
+	}
+	if got := latencyPrefiltered.Count() - before; got != 1 {
+		t.Errorf("Expected 1 prefiltered latency observation, got %d", got)
+	}
+	if latencyForwarded.Count() != answered {
+		t.Error("Expected refusal to stay out of forwarded latency")
+	}
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// tlsFingerprint returns a JA3-style fingerprint of a TLS ClientHello: the
+// MD5 of the highest version offered and the cipher suites, curves, point
+// formats, signature schemes and ALPN protocols in the order offered.
+// These follow the client's TLS library rather than its address, so one
+// client software family shares a fingerprint across addresses. Go does
+// not expose the extension list, so it is not interchangeable with JA3.
+func tlsFingerprint(hello *tls.ClientHelloInfo) string {
+	var version uint16
+	for _, v := range hello.SupportedVersions {
+		if !grease(v) && v > version {
+			version = v
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(version)))
+	b.WriteByte(',')
+	writeValues(&b, hello.CipherSuites)
+	b.WriteByte(',')
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	writeValues(&b, curves)
+	b.WriteByte(',')
+	for i, p := range hello.SupportedPoints {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(p)))
+	}
+	b.WriteByte(',')
+	schemes := make([]uint16, len(hello.SignatureSchemes))
+	for i, s := range hello.SignatureSchemes {
+		schemes[i] = uint16(s)
+	}
+	writeValues(&b, schemes)
+	b.WriteByte(',')
+	b.WriteString(strings.Join(hello.SupportedProtos, "-"))
+
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeValues writes values joined by dashes, leaving out GREASE values
+// (RFC 8701), which clients pick at random
+func writeValues(b *strings.Builder, values []uint16) {
+	first := true
+	for _, v := range values {
+		if grease(v) {
+			continue
+		}
+		if !first {
+			b.WriteByte('-')
+		}
+		first = false
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+}
+
+// grease reports whether v is a GREASE value (0x0a0a, 0x1a1a, ... 0xfafa)
+func grease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// fingerprintTLS returns cfg recording the fingerprint of each client's
+// ClientHello on its connection, which must be an *encryptedConn
+func fingerprintTLS(cfg *tls.Config) *tls.Config {
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if conn, ok := hello.Conn.(*encryptedConn); ok {
+			conn.fingerprint.Store(tlsFingerprint(hello))
+		}
+		return nil, nil
+	}
+	return cfg
+}
+
+// clientFingerprint returns the TLS fingerprint of the client at addr, or
+// "" when it did not connect over TLS to this server
+func clientFingerprint(addr net.Addr) string {
+	if a, ok := addr.(*encryptedAddr); ok {
+		return a.Fingerprint
+	}
+	return ""
+}
+
+// fingerprintFields appends the client's TLS fingerprint, if any, to log
+// fields
+func fingerprintFields(addr net.Addr, fields ...interface{}) []interface{} {
+	if fp := clientFingerprint(addr); fp != "" {
+		fields = append(fields, "fingerprint", fp)
+	}
+	return fields
+}
+
+// fingerprintValue holds a connection's fingerprint once its handshake
+// has begun
+type fingerprintValue struct {
+	v atomic.Value
+}
+
+// Store sets the fingerprint
+func (f *fingerprintValue) Store(fp string) {
+	f.v.Store(fp)
+}
+
+// Load returns the fingerprint, or "" before the handshake
+func (f *fingerprintValue) Load() string {
+	fp, _ := f.v.Load().(string)
+	return fp
+}
@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestTLSFingerprint(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+		CipherSuites:      []uint16{0x0a0a, tls.TLS_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedProtos:   []string{"dot"},
+	}
+	fp := tlsFingerprint(hello)
+	if len(fp) != 32 {
+		t.Fatalf("Expected an MD5 in hex, got %q", fp)
+	}
+
+	// GREASE values are random per connection and must not matter
+	hello.CipherSuites[0] = 0xdada
+	hello.SupportedVersions[0] = 0x7a7a
+	if got := tlsFingerprint(hello); got != fp {
+		t.Errorf("Expected GREASE values ignored, got %s and %s", fp, got)
+	}
+
+	hello.CipherSuites = hello.CipherSuites[1:2]
+	if got := tlsFingerprint(hello); got == fp {
+		t.Error("Expected different cipher suites to change the fingerprint")
+	}
+}
+
+func TestHandshakeRecordsFingerprint(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := &encryptedConn{Conn: server}
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "dns.example", NextProtos: []string{"dot"}}).Handshake()
+		client.Close()
+	}()
+	// With no certificate the handshake fails after the ClientHello
+	tls.Server(conn, fingerprintTLS(&tls.Config{})).Handshake()
+
+	if fp := conn.fingerprint.Load(); len(fp) != 32 {
+		t.Errorf("Expected the ClientHello fingerprinted, got %q", fp)
+	}
+}
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/firewall"
+	"ddd/internal/journal"
+	"ddd/internal/metrics"
+)
+
+var firewallMatches = metrics.NewCounterVec("ddd_firewall_matches_total",
+	"Requests matched by a firewall rule, by rule index and action", "rule", "action")
+
+const (
+	// journalRepeat is how long a rule's or the script's decision about a
+	// client is journaled once, however many of its queries match
+	journalRepeat = time.Minute
+	// maxJournaled bounds the decisions remembered as journaled
+	maxJournaled = 100000
+)
+
+// applyFirewall evaluates the firewall rules for a query, then the policy
+// script when no rule matched. It reports whether the query was answered
+// (or dropped) here and whether attack detection should be skipped for it.
+func (s *Server) applyFirewall(w dns.ResponseWriter, r *dns.Msg, clientIP, domain, qtype string) (handled, skipDetection bool) {
+	action, rule := s.opts.Firewall.Evaluate(firewall.Request{
+		Client: clientIP,
+		QName:  strings.ToLower(domain),
+		QType:  qtype,
+	})
+	if action == firewall.None {
+		return s.applyScript(w, r, clientIP, domain, qtype)
+	}
+	firewallMatches.With(strconv.Itoa(rule), action.String()).Inc()
+	s.log.Debugw("Firewall rule matched", "ip", clientIP, "domain", domain, "rule", rule, "action", action.String())
+	return s.applyAction(w, r, clientIP, domain, qtype, action, journal.SourceFirewall, "firewall rule "+strconv.Itoa(rule))
+}
+
+// applyAction applies a firewall action to a query. reason is recorded
+// when the client is blocked; blocks and rate limits are journaled as
+// decisions of source.
+func (s *Server) applyAction(w dns.ResponseWriter, r *dns.Msg, clientIP, domain, qtype string, action firewall.Action, source, reason string) (handled, skipDetection bool) {
+	switch action {
+	case firewall.Allow:
+		return false, true
+	case firewall.RateLimit:
+		s.journalAction(clientIP, domain, qtype, action, source, reason)
+		s.rateLimit(clientIP, r)
+		return false, false
+	case firewall.Block:
+		s.journalAction(clientIP, domain, qtype, action, source, reason)
+		s.ipBlocker.BlockIP(clientIP, reason)
+		s.sendRefused(w, r)
+	case firewall.Refuse:
+		s.sendRefused(w, r)
+	case firewall.NXDomain:
+		w.WriteMsg(s.nxdomain(r, domain))
+	case firewall.Drop:
+	}
+	return true, false
+}
+
+// journalAction records a block or rate limit by a firewall rule or the
+// policy script in the decision journal, once per client, action and
+// source within journalRepeat
+func (s *Server) journalAction(clientIP, domain, qtype string, action firewall.Action, source, reason string) {
+	if s.opts.Journal == nil || !s.journaled.first(journaledKey{clientIP, source, action}, time.Now()) {
+		return
+	}
+	s.opts.Journal.Record(journal.Entry{
+		Client:      clientIP,
+		Source:      source,
+		Description: reason,
+		Domain:      strings.ToLower(domain),
+		QType:       qtype,
+		Decision:    action.String(),
+		DecidedBy:   source,
+	})
+}
+
+// journaledKey is a client's decision by a source
+type journaledKey struct {
+	client string
+	source string
+	action firewall.Action
+}
+
+// journaledDecisions remembers the decisions journaled lately, so that a
+// flood matching one rule is journaled once rather than per query
+type journaledDecisions struct {
+	mu    sync.Mutex
+	until map[journaledKey]time.Time
+}
+
+// first reports whether k has not been journaled within journalRepeat of
+// now, and remembers it if so. When full of recent decisions it forgets
+// them all rather than stop journaling.
+func (d *journaledDecisions) first(k journaledKey, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Before(d.until[k]) {
+		return false
+	}
+	if len(d.until) >= maxJournaled {
+		for key, until := range d.until {
+			if !now.Before(until) {
+				delete(d.until, key)
+			}
+		}
+		if len(d.until) >= maxJournaled {
+			d.until = nil
+		}
+	}
+	if d.until == nil {
+		d.until = make(map[journaledKey]time.Time)
+	}
+	d.until[k] = now.Add(journalRepeat)
+	return true
+}
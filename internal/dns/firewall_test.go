@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/detector"
+	"ddd/internal/firewall"
+	"ddd/internal/journal"
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestFirewallJournalsOncePerClient(t *testing.T) {
+	log := logger.NewNop()
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := journal.Open(path, nil, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := firewall.NewEngine([]string{`qtype == "TXT" => rate_limit`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(0, "127.0.0.1:1", monitor.NewTrafficMonitor(), detector.NewDDoSDetector(100, log),
+		blocker.NewIPBlocker(60, nil), log, Options{Firewall: rules, Journal: j})
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeTXT)
+	for _, client := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		w := &recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP(client), Port: 5300}}
+		s.applyFirewall(w, r, client, "example.com.", "TXT")
+	}
+	j.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := journal.Verify(f, nil); err != nil || n != 2 {
+		t.Errorf("Expected one entry per client, got %d: %v", n, err)
+	}
+
+	var d journaledDecisions
+	k := journaledKey{"192.0.2.1", journal.SourceFirewall, firewall.RateLimit}
+	now := time.Now()
+	if !d.first(k, now) || d.first(k, now.Add(time.Second)) || !d.first(k, now.Add(journalRepeat)) {
+		t.Error("Expected a decision journaled again only after journalRepeat")
+	}
+}
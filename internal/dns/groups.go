@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"ddd/internal/config"
+	"ddd/internal/detector"
+	"ddd/internal/groups"
+	"ddd/internal/metrics"
+	"ddd/internal/policy"
+)
+
+var groupDetections = metrics.NewCounterVec("ddd_group_detections_total",
+	"Detections by client group and tenant", "group", "tenant")
+
+// detectorFor returns the detector for the client at clientIP with TLS
+// fingerprint fingerprint ("" for none) and its group, nil when it is in
+// none
+func (s *Server) detectorFor(clientIP, fingerprint string) (*detector.DDoSDetector, *groups.Group) {
+	g := s.opts.Groups.Match(clientIP, fingerprint)
+	if g == nil {
+		return s.ddosDetector, nil
+	}
+	return g.Detector, g
+}
+
+// groupDecision caps a mitigation decision at what the client's group
+// allows: monitored groups are only logged, rate_limit groups never
+// blocked
+func groupDecision(g *groups.Group, decision policy.Decision) policy.Decision {
+	if g == nil {
+		return decision
+	}
+	switch g.Mitigation {
+	case config.MitigationMonitor:
+		return ""
+	case config.MitigationRateLimit:
+		if decision == policy.Block {
+			return policy.RateLimit
+		}
+	}
+	return decision
+}
+
+// groupFields appends the client's group to log fields
+func groupFields(g *groups.Group, fields ...interface{}) []interface{} {
+	if g == nil {
+		return fields
+	}
+	fields = append(fields, "group", g.Name)
+	if g.Tenant != "" {
+		fields = append(fields, "tenant", g.Tenant)
+	}
+	return fields
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"strings"
+
+	"ddd/internal/detector"
+	"ddd/internal/groups"
+	"ddd/internal/journal"
+	"ddd/internal/policy"
+)
+
+// journalDetection records a mitigation decision on a detection in the
+// decision journal, with the thresholds of the detector that made it.
+// An empty decision, left by a monitored group, is journaled as monitor.
+func (s *Server) journalDetection(source, clientIP, domain, qtype string, d *detector.DDoSDetector, g *groups.Group, result *detector.DetectionResult, inputs map[string]float64, decision policy.Decision, decidedBy string) {
+	if s.opts.Journal == nil {
+		return
+	}
+	e := journal.Entry{
+		Client:      clientIP,
+		Source:      source,
+		AttackType:  result.AttackType,
+		Severity:    result.Severity,
+		Description: result.Description,
+		Domain:      strings.ToLower(domain),
+		QType:       qtype,
+		Inputs:      inputs,
+		Thresholds:  detectorThresholds(d),
+		Decision:    string(decision),
+		DecidedBy:   decidedBy,
+	}
+	if g != nil {
+		e.Group = g.Name
+	}
+	if result.Evidence != nil {
+		e.Evidence = strings.Join(result.Evidence.Names, " ")
+	}
+	if decision == "" {
+		e.Decision = "monitor"
+	}
+	s.opts.Journal.Record(e)
+}
+
+// clientInputs returns the client's traffic over the detection window,
+// as the detector saw it
+func (s *Server) clientInputs(clientIP string) map[string]float64 {
+	if s.opts.Journal == nil {
+		return nil
+	}
+	window := s.trafficMonitor.RateWindow()
+	inputs := map[string]float64{
+		"requests":    float64(s.trafficMonitor.GetRecentRequestCount(clientIP, window)),
+		"failures":    float64(s.trafficMonitor.GetRecentFailureCount(clientIP, window)),
+		"new_domains": float64(s.trafficMonitor.GetRecentNewDomainCount(clientIP, window)),
+	}
+	if cost, ok := s.trafficMonitor.GetRecentCost(clientIP, window); ok {
+		inputs["cost"] = cost
+	}
+	return inputs
+}
+
+// detectorThresholds returns the limits of d to journal
+func detectorThresholds(d *detector.DDoSDetector) map[string]float64 {
+	t := d.Thresholds()
+	return map[string]float64{
+		"rate_limit":           float64(t.RateLimit),
+		"window_seconds":       t.Window.Seconds(),
+		"new_client_limit":     float64(t.NewClientLimit),
+		"new_domain_rate":      float64(t.NewDomainRate),
+		"protocol_abuse_limit": float64(t.ProtocolAbuseLimit),
+		"homograph_limit":      float64(t.HomographLimit),
+		"failure_penalty":      t.FailurePenalty,
+		"pattern_scale":        t.PatternScale,
+	}
+}
+
+// decidedBy names what made a detection's mitigation decision when the
+// policy script did not: the detector when it was sure or there is no
+// decision service, else the decision service
+func (s *Server) decidedBy(result *detector.DetectionResult) string {
+	if result.ShouldBlock || s.opts.Policy == nil {
+		return "detector"
+	}
+	return "policy"
+}
@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"time"
+
+	"ddd/internal/metrics"
+)
+
+var queryDuration = metrics.NewHistogramVec("ddd_query_duration_seconds",
+	"Time taken to answer queries, by verdict", metrics.DefBuckets, "verdict")
+
+// Per-verdict latency, resolved once so the hot path skips the label lookup.
+// Fast-path verdicts are kept apart from answered queries so that a
+// regression in the mitigation path shows up on its own.
+var (
+	// latencyPrefiltered covers refusals built in the read loop, before
+	// the message is decoded
+	latencyPrefiltered = queryDuration.With("prefiltered")
+	// latencyRefused covers refusals by the handler: blocked clients,
+	// protocol abuse, zone transfers and empty queries
+	latencyRefused = queryDuration.With("refused")
+	// latencyBlocked covers queries whose analysis blocked the client
+	latencyBlocked = queryDuration.With("blocked")
+	// latencyCache covers answers from the response cache and NXDOMAIN
+	// patterns
+	latencyCache = queryDuration.With("cache")
+	// latencyForwarded covers queries answered by upstream
+	latencyForwarded = queryDuration.With("forwarded")
+)
+
+// AllowedQueryDurations returns the latency histograms of allowed queries,
+// from receipt to response, for SLO tracking
+func AllowedQueryDurations() []*metrics.Histogram {
+	return []*metrics.Histogram{latencyCache, latencyForwarded}
+}
+
+// summaryVerdicts names the latency verdicts in per-client log summaries
+var summaryVerdicts = map[*metrics.Histogram]string{
+	latencyPrefiltered: "prefiltered",
+	latencyRefused:     "refused",
+	latencyBlocked:     "blocked",
+	latencyCache:       "cache",
+	latencyForwarded:   "forwarded",
+}
+
+// observeLatency records the time taken to handle a query
+func observeLatency(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// finish records the time taken to handle a query from clientIP and, with
+// summary logging, counts the query under its verdict
+func (s *Server) finish(clientIP, domain string, h *metrics.Histogram, start time.Time) {
+	observeLatency(h, start)
+	s.chargeCost(clientIP, h)
+	s.opts.Summary.Record(clientIP, domain, summaryVerdicts[h])
+}
@@ -0,0 +1,271 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	listenerQueries = metrics.NewCounterVec("ddd_listener_queries_total",
+		"Queries handled, by listener protocol", "protocol")
+	listenerErrors = metrics.NewCounterVec("ddd_listener_errors_total",
+		"Malformed requests and responses that could not be written, by listener protocol", "protocol")
+	listenerDuration = metrics.NewHistogramVec("ddd_listener_query_duration_seconds",
+		"Time taken to answer queries, by listener protocol", metrics.DefBuckets, "protocol")
+	listenerRunning = metrics.NewGaugeVec("ddd_listener_running",
+		"Whether each DNS listener is serving", "listener")
+)
+
+// ErrNoListener is returned for a listener name the server does not have
+var ErrNoListener = errors.New("no listener")
+
+// Listener serves DNS over one transport on one address. The listener
+// manager starts and stops each on its own, so one failing or being
+// stopped leaves the others serving.
+type Listener interface {
+	// Name identifies the listener as protocol@address
+	Name() string
+	Protocol() string
+	// Listen opens the listening socket. Serving needs nothing further,
+	// so listeners are opened before the process is sandboxed.
+	Listen() error
+	// Serve answers queries until Shutdown
+	Serve() error
+	Shutdown() error
+}
+
+// ListenerStatus is the state of a listener
+type ListenerStatus struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Running  bool   `json:"running"`
+	Error    string `json:"error,omitempty"` // why it last stopped serving
+}
+
+// protocolHandler returns the query handler for listeners of protocol,
+// counting their queries, errors and latency apart, and their queries
+// towards the server's total
+func (s *Server) protocolHandler(protocol string) dns.Handler {
+	queries := listenerQueries.With(protocol)
+	errors := listenerErrors.With(protocol)
+	duration := listenerDuration.With(protocol)
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		start := time.Now()
+		s.queries.Add(1)
+		queries.Inc()
+		s.handleDNSRequest(&countingWriter{ResponseWriter: w, errors: errors}, r)
+		observeLatency(duration, start)
+	})
+}
+
+// countingWriter counts responses that could not be written
+type countingWriter struct {
+	dns.ResponseWriter
+	errors *metrics.Counter
+}
+
+// WriteMsg writes m, counting failures
+func (w *countingWriter) WriteMsg(m *dns.Msg) error {
+	err := w.ResponseWriter.WriteMsg(m)
+	if err != nil {
+		w.errors.Inc()
+	}
+	return err
+}
+
+// listenerManager starts, stops and tracks the server's listeners
+type listenerManager struct {
+	log *logger.Logger
+
+	mu        sync.Mutex
+	listeners []*managedListener
+	serving   sync.WaitGroup
+}
+
+// managedListener is a listener and its serving state
+type managedListener struct {
+	Listener
+	running bool
+	err     error
+	done    chan struct{} // closed when Serve returns
+}
+
+// newListenerManager creates an empty manager
+func newListenerManager(log *logger.Logger) *listenerManager {
+	return &listenerManager{log: log}
+}
+
+// add registers a listener, stopped
+func (m *listenerManager) add(l Listener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, &managedListener{Listener: l})
+}
+
+// findLocked returns the listener called name, or nil
+func (m *listenerManager) findLocked(name string) *managedListener {
+	for _, l := range m.listeners {
+		if l.Name() == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// startAll starts every listener, stopping those already started when one
+// fails to open
+func (m *listenerManager) startAll() error {
+	for _, status := range m.status() {
+		if err := m.start(status.Name); err != nil {
+			m.stopAll()
+			return err
+		}
+	}
+	return nil
+}
+
+// start opens the named listener and serves it in the background. Starting
+// a running listener does nothing.
+func (m *listenerManager) start(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.findLocked(name)
+	if l == nil {
+		return fmt.Errorf("%w %s", ErrNoListener, name)
+	}
+	if l.running {
+		return nil
+	}
+	if err := l.Listen(); err != nil {
+		return fmt.Errorf("listener %s: %w", name, err)
+	}
+	l.running, l.err, l.done = true, nil, make(chan struct{})
+	listenerRunning.With(name).Set(1)
+
+	m.serving.Add(1)
+	go m.serve(l, l.done)
+	return nil
+}
+
+// serve runs the listener until it is shut down or fails
+func (m *listenerManager) serve(l *managedListener, done chan struct{}) {
+	defer m.serving.Done()
+	defer close(done)
+
+	err := l.Serve()
+	m.mu.Lock()
+	l.running, l.err = false, err
+	m.mu.Unlock()
+	listenerRunning.With(l.Name()).Set(0)
+	if err != nil {
+		m.log.Errorw("DNS listener failed", "listener", l.Name(), "error", err)
+	}
+}
+
+// stop shuts the named listener down and waits for it to stop serving.
+// Stopping a stopped listener does nothing.
+func (m *listenerManager) stop(name string) error {
+	m.mu.Lock()
+	l := m.findLocked(name)
+	if l == nil {
+		m.mu.Unlock()
+		return fmt.Errorf("%w %s", ErrNoListener, name)
+	}
+	if !l.running {
+		m.mu.Unlock()
+		return nil
+	}
+	done := l.done
+	m.mu.Unlock()
+
+	err := l.Shutdown()
+	<-done
+	return err
+}
+
+// stopAll stops every listener, returning the first error
+func (m *listenerManager) stopAll() error {
+	var first error
+	for _, status := range m.status() {
+		if err := m.stop(status.Name); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// wait blocks until no listener is serving
+func (m *listenerManager) wait() {
+	m.serving.Wait()
+}
+
+// status returns the state of each listener in registration order
+func (m *listenerManager) status() []ListenerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]ListenerStatus, 0, len(m.listeners))
+	for _, l := range m.listeners {
+		status := ListenerStatus{Name: l.Name(), Protocol: l.Protocol(), Running: l.running}
+		if l.err != nil {
+			status.Error = l.err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Listeners returns the state of each DNS listener
+func (s *Server) Listeners() []ListenerStatus {
+	return s.listeners.status()
+}
+
+// StartListener opens and serves the named listener (protocol@address) if
+// it is stopped
+func (s *Server) StartListener(name string) error {
+	return s.listeners.start(name)
+}
+
+// StopListener stops the named listener, leaving the others serving
+func (s *Server) StopListener(name string) error {
+	return s.listeners.stop(name)
+}
+
+// serverRun runs a miekg/dns server for a listener. Shutting one down
+// before it has started fails, so shutdown waits for it to start first.
+type serverRun struct {
+	srv     *dns.Server
+	started chan struct{}
+	exited  chan struct{}
+}
+
+// newServerRun prepares srv to be served
+func newServerRun(srv *dns.Server) *serverRun {
+	r := &serverRun{srv: srv, started: make(chan struct{}), exited: make(chan struct{})}
+	srv.NotifyStartedFunc = func() { close(r.started) }
+	return r
+}
+
+// serve answers queries until shutdown
+func (r *serverRun) serve() error {
+	defer close(r.exited)
+	return r.srv.ActivateAndServe()
+}
+
+// shutdown stops the server once it has started
+func (r *serverRun) shutdown() error {
+	select {
+	case <-r.started:
+	case <-r.exited:
+		return nil
+	}
+	return r.srv.Shutdown()
+}
@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/cache"
+	"ddd/internal/config"
+	"ddd/internal/detector"
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+// fakeListener serves until shut down, or fails to open
+type fakeListener struct {
+	name    string
+	openErr error
+	stopped chan struct{}
+}
+
+func (l *fakeListener) Name() string     { return l.name }
+func (l *fakeListener) Protocol() string { return "fake" }
+
+func (l *fakeListener) Listen() error {
+	if l.openErr != nil {
+		return l.openErr
+	}
+	l.stopped = make(chan struct{})
+	return nil
+}
+
+func (l *fakeListener) Serve() error {
+	<-l.stopped
+	return nil
+}
+
+func (l *fakeListener) Shutdown() error {
+	close(l.stopped)
+	return nil
+}
+
+func running(m *listenerManager) map[string]bool {
+	states := make(map[string]bool)
+	for _, s := range m.status() {
+		states[s.Name] = s.Running
+	}
+	return states
+}
+
+func TestListenerManagerIndependentStartStop(t *testing.T) {
+	m := newListenerManager(logger.NewNop())
+	m.add(&fakeListener{name: "udp@:53"})
+	m.add(&fakeListener{name: "dot@:853"})
+
+	if err := m.startAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.stop("dot@:853"); err != nil {
+		t.Fatal(err)
+	}
+	if states := running(m); !states["udp@:53"] || states["dot@:853"] {
+		t.Fatalf("Expected only udp serving, got %v", states)
+	}
+
+	if err := m.start("dot@:853"); err != nil {
+		t.Fatal(err)
+	}
+	if states := running(m); !states["udp@:53"] || !states["dot@:853"] {
+		t.Fatalf("Expected both serving after restart, got %v", states)
+	}
+	if err := m.stop("doh@:443"); err == nil {
+		t.Error("Expected an error stopping an unknown listener")
+	}
+
+	if err := m.stopAll(); err != nil {
+		t.Fatal(err)
+	}
+	m.wait()
+	if states := running(m); states["udp@:53"] || states["dot@:853"] {
+		t.Errorf("Expected nothing serving, got %v", states)
+	}
+}
+
+func TestListenerManagerStartAllRollsBack(t *testing.T) {
+	m := newListenerManager(logger.NewNop())
+	m.add(&fakeListener{name: "udp@:53"})
+	m.add(&fakeListener{name: "doh@:443", openErr: errors.New("address in use")})
+
+	if err := m.startAll(); err == nil {
+		t.Fatal("Expected startAll to fail")
+	}
+	m.wait()
+	if states := running(m); states["udp@:53"] {
+		t.Errorf("Expected the opened listener stopped again, got %v", states)
+	}
+}
+
+func TestQueriesCountedOnEveryTransport(t *testing.T) {
+	log := logger.NewNop()
+	responses := cache.New(100, time.Hour)
+	s := NewServer(0, "127.0.0.1:1", monitor.NewTrafficMonitor(), detector.NewDDoSDetector(100, log),
+		blocker.NewIPBlocker(60, nil), log, Options{Cache: responses})
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetReply(r)
+	reply.Answer = append(reply.Answer, mustRR(t, "example.com. 300 IN A 192.0.2.1"))
+	responses.Set(reply)
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.100"), Port: 5300}
+	for _, protocol := range []string{config.ProtocolTCP, config.ProtocolDoT, config.ProtocolDoH} {
+		w := &recordingWriter{addr: client}
+		s.protocolHandler(protocol).ServeDNS(w, r)
+		if w.msg == nil {
+			t.Fatalf("Expected a %s query answered", protocol)
+		}
+	}
+	if got := s.QueryCount(); got != 3 {
+		t.Errorf("Expected 3 queries counted, got %d", got)
+	}
+}
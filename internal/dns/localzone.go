@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SOA timers of the local zone; only the negative TTL matters to
+// resolvers, as the zone is never transferred
+const (
+	localZoneRefresh = 3600
+	localZoneRetry   = 600
+	localZoneExpire  = 86400
+)
+
+// nxdomain returns an NXDOMAIN answer to r, for domain, with the local
+// zone's SOA in the authority section so that resolvers downstream cache
+// it for the negative TTL (RFC 2308)
+func (s *Server) nxdomain(r *dns.Msg, domain string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeNameError)
+	m.RecursionAvailable = true
+	if soa := s.localSOA(domain); soa != nil {
+		m.Ns = append(m.Ns, soa)
+	}
+	return m
+}
+
+// localSOA returns the SOA of the local zone answering for domain, or nil
+// without a negative TTL. Its owner is the registered domain above
+// domain, or its parent, so that it is in bailiwick for the name denied.
+func (s *Server) localSOA(domain string) dns.RR {
+	cfg := s.opts.LocalZone
+	ttl := uint32(cfg.NegativeTTL.Seconds())
+	if ttl == 0 {
+		return nil
+	}
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: soaOwner(domain), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      dns.Fqdn(cfg.MName),
+		Mbox:    dns.Fqdn(cfg.RName),
+		Serial:  1,
+		Refresh: localZoneRefresh,
+		Retry:   localZoneRetry,
+		Expire:  localZoneExpire,
+		Minttl:  ttl,
+	}
+}
+
+// soaOwner returns the zone a denial of domain is made from
+func soaOwner(domain string) string {
+	if base := registeredDomain(domain); base != "" {
+		return dns.Fqdn(base)
+	}
+	name := strings.ToLower(strings.TrimSuffix(domain, "."))
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		return dns.Fqdn(parent)
+	}
+	return "."
+}
@@ -0,0 +1,40 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+func TestSynthesizedNXDomainSOA(t *testing.T) {
+	s := &Server{opts: Options{LocalZone: config.LocalZoneConfig{
+		NegativeTTL: 5 * time.Minute,
+		MName:       "ddd.invalid",
+		RName:       "hostmaster.ddd.invalid.",
+	}}}
+	r := new(dns.Msg)
+	r.SetQuestion("Tracker.Ads.Example.com.", dns.TypeA)
+
+	m := s.nxdomain(r, "Tracker.Ads.Example.com")
+	if m.Rcode != dns.RcodeNameError || m.Id != r.Id || len(m.Ns) != 1 {
+		t.Fatalf("Expected NXDOMAIN with an SOA, got %v", m)
+	}
+	soa := m.Ns[0].(*dns.SOA)
+	if soa.Hdr.Name != "example.com." || soa.Hdr.Ttl != 300 || soa.Minttl != 300 || soa.Ns != "ddd.invalid." {
+		t.Errorf("Expected the SOA of example.com with a 300s negative TTL, got %v", soa)
+	}
+
+	for domain, want := range map[string]string{"example.com": "com.", "com": ".", "x.y.example.co.uk": "example.co.uk."} {
+		if got := soaOwner(domain); got != want {
+			t.Errorf("soaOwner(%s) = %s, want %s", domain, got, want)
+		}
+	}
+
+	s.opts.LocalZone.NegativeTTL = 0
+	if m := s.nxdomain(r, "tracker.ads.example.com"); len(m.Ns) != 0 {
+		t.Errorf("Expected no SOA without a negative TTL, got %v", m.Ns)
+	}
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// minimizeResponse strips the records a response does not need, the way
+// authoritative servers with minimal responses answer: answers lose the
+// zone's name servers and additional records, keeping only the proofs of
+// a wildcard expansion, and referrals keep only the glue of their own
+// name servers. Negative answers keep their SOA and denial proofs.
+func minimizeResponse(resp *dns.Msg) {
+	switch {
+	case len(resp.Answer) > 0:
+		resp.Ns = keepRecords(resp.Ns, func(rr dns.RR) bool {
+			switch rr := rr.(type) {
+			case *dns.NSEC, *dns.NSEC3:
+				return true
+			case *dns.RRSIG:
+				return rr.TypeCovered == dns.TypeNSEC || rr.TypeCovered == dns.TypeNSEC3
+			}
+			return false
+		})
+		resp.Extra = keepRecords(resp.Extra, isTransportRecord)
+	case resp.Rcode == dns.RcodeSuccess && isReferral(resp):
+		servers := make(map[string]bool)
+		for _, rr := range resp.Ns {
+			if ns, ok := rr.(*dns.NS); ok {
+				servers[strings.ToLower(ns.Ns)] = true
+			}
+		}
+		resp.Extra = keepRecords(resp.Extra, func(rr dns.RR) bool {
+			switch rr.Header().Rrtype {
+			case dns.TypeA, dns.TypeAAAA:
+				return servers[strings.ToLower(rr.Header().Name)]
+			}
+			return isTransportRecord(rr)
+		})
+	default:
+		resp.Extra = keepRecords(resp.Extra, isTransportRecord)
+	}
+}
+
+// isReferral reports whether resp delegates to other name servers
+func isReferral(resp *dns.Msg) bool {
+	for _, rr := range resp.Ns {
+		if rr.Header().Rrtype == dns.TypeNS {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransportRecord reports whether rr belongs to the message rather than
+// the data: EDNS options and signatures
+func isTransportRecord(rr dns.RR) bool {
+	switch rr.Header().Rrtype {
+	case dns.TypeOPT, dns.TypeTSIG, dns.TypeSIG:
+		return true
+	}
+	return false
+}
+
+// keepRecords returns the records of rrs keep accepts, in place
+func keepRecords(rrs []dns.RR, keep func(dns.RR) bool) []dns.RR {
+	kept := rrs[:0]
+	for _, rr := range rrs {
+		if keep(rr) {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMinimizeAnswer(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.SetQuestion("www.example.com.", dns.TypeA)
+	resp.Answer = []dns.RR{mustRR(t, "www.example.com. 300 IN A 192.0.2.1")}
+	resp.Ns = []dns.RR{
+		mustRR(t, "example.com. 300 IN NS ns1.example.com."),
+		mustRR(t, "example.com. 300 IN NSEC z.example.com. A"),
+	}
+	resp.Extra = []dns.RR{mustRR(t, "ns1.example.com. 300 IN A 192.0.2.53")}
+	resp.SetEdns0(1232, false)
+
+	minimizeResponse(resp)
+	if len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeNSEC {
+		t.Errorf("Expected only the wildcard proof in authority, got %v", resp.Ns)
+	}
+	if len(resp.Extra) != 1 || resp.IsEdns0() == nil {
+		t.Errorf("Expected only the OPT record in additional, got %v", resp.Extra)
+	}
+}
+
+func TestMinimizeReferral(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.SetQuestion("www.sub.example.com.", dns.TypeA)
+	resp.Ns = []dns.RR{mustRR(t, "sub.example.com. 300 IN NS ns1.sub.example.com.")}
+	resp.Extra = []dns.RR{
+		mustRR(t, "ns1.sub.example.com. 300 IN A 192.0.2.53"),
+		mustRR(t, "NS1.sub.example.com. 300 IN AAAA 2001:db8::53"),
+		mustRR(t, "mail.example.com. 300 IN A 192.0.2.25"),
+	}
+
+	minimizeResponse(resp)
+	if len(resp.Ns) != 1 {
+		t.Errorf("Expected the delegation kept, got %v", resp.Ns)
+	}
+	if len(resp.Extra) != 2 {
+		t.Errorf("Expected only the name server's glue, got %v", resp.Extra)
+	}
+}
@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/detector"
+	"ddd/internal/logger"
+	"ddd/internal/mirror"
+	"ddd/internal/monitor"
+)
+
+func TestMirrorSkipsRefusedClients(t *testing.T) {
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	log := logger.NewNop()
+	m := mirror.New(config.MirrorConfig{Address: target.LocalAddr().String(), SampleRate: 1, Queue: 4}, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	ipBlocker := blocker.NewIPBlocker(60, nil)
+	ipBlocker.BlockIP("192.0.2.1", "test")
+	s := NewServer(0, "127.0.0.1:1", monitor.NewTrafficMonitor(), detector.NewDDoSDetector(100, log),
+		ipBlocker, log, Options{Mirror: m})
+
+	mirrored := func(name string) bool {
+		buf := make([]byte, dns.MaxMsgSize)
+		target.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := target.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		var copied dns.Msg
+		return copied.Unpack(buf[:n]) == nil && copied.Question[0].Name == name
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("blocked.example.com.", dns.TypeA)
+	s.handleDNSRequest(&recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5300}}, r)
+	if mirrored("blocked.example.com.") {
+		t.Error("Expected a blocked client's query not mirrored")
+	}
+
+	// The upstream is unreachable; the copy is sent before forwarding
+	r = new(dns.Msg)
+	r.SetQuestion("allowed.example.com.", dns.TypeA)
+	go s.handleDNSRequest(&recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5300}}, r)
+	if !mirrored("allowed.example.com.") {
+		t.Error("Expected an allowed client's query mirrored")
+	}
+}
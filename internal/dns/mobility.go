@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/detector"
+	"ddd/internal/metrics"
+)
+
+var manualLimitRefusals = metrics.NewCounter("ddd_manual_rate_limit_refusals_total",
+	"Queries refused for exceeding an operator's manual rate limit")
+
+// overManualLimit refuses a query from a client over the rate an operator
+// capped it at, and reports whether it did
+func (s *Server) overManualLimit(w dns.ResponseWriter, r *dns.Msg, clientIP string) bool {
+	if s.ipBlocker.AllowManual(clientIP, time.Now()) {
+		return false
+	}
+	manualLimitRefusals.Inc()
+	s.sendRefused(w, r)
+	return true
+}
+
+// isRateLimited reports whether the client behind a query is rate
+// limited, either as a whole address or, with mobility buckets, as the
+// fingerprinted client
+func (s *Server) isRateLimited(clientIP string, r *dns.Msg) bool {
+	return s.ipBlocker.IsBucketRateLimited(clientIP, s.opts.Mobility.Fingerprint(r))
+}
+
+// rateLimit rate limits the client behind a query: the whole address, or
+// with mobility buckets only the fingerprinted client, for less time in
+// dynamic ranges
+func (s *Server) rateLimit(clientIP string, r *dns.Msg) {
+	window := s.opts.Mobility.Decay(clientIP, s.ipBlocker.RateLimitWindow())
+	s.ipBlocker.RateLimitBucket(clientIP, s.opts.Mobility.Fingerprint(r), window)
+}
+
+// detectionClient describes the client behind a query to the detector,
+// with the budget grant covering it, if any
+func (s *Server) detectionClient(clientIP string, r *dns.Msg) detector.Client {
+	return detector.Client{
+		IP:            clientIP,
+		Fingerprint:   s.opts.Mobility.Fingerprint(r),
+		PenaltyWindow: s.opts.Mobility.Decay(clientIP, s.trafficMonitor.RateWindow()),
+		Budget:        s.ipBlocker.BudgetFactor(clientIP, time.Now()),
+	}
+}
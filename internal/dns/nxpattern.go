@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+
+	"ddd/internal/config"
+	"ddd/internal/metrics"
+)
+
+var (
+	nxPatternsArmed = metrics.NewCounter("ddd_nxdomain_patterns_armed_total",
+		"Wildcard NXDOMAIN patterns activated by random-subdomain floods")
+	nxPatternHits = metrics.NewCounter("ddd_nxdomain_pattern_hits_total",
+		"Queries answered NXDOMAIN from a wildcard pattern without asking upstream")
+)
+
+// maxNXBases bounds the number of registered domains tracked at once
+const maxNXBases = 10000
+
+// nxBase tracks NXDOMAIN answers under one registered domain
+type nxBase struct {
+	names map[string]struct{} // distinct NXDOMAIN names since start
+	start time.Time
+	until time.Time // pattern active until, zero when inactive
+	reply *dns.Msg  // last upstream NXDOMAIN, whose SOA is reused
+	hits  uint64
+}
+
+// nxPatternCache answers NXDOMAIN for every uncached name under a
+// registered domain (*.victim.com) once upstream has returned NXDOMAIN for
+// Threshold distinct names under it within Window. During a random
+// subdomain flood this stops each random name costing an upstream
+// exchange. A pattern lasts TTL; if the flood is still running when it
+// expires, the next Threshold NXDOMAINs re-arm it.
+type nxPatternCache struct {
+	cfg config.NXDomainPatternConfig
+
+	mu    sync.Mutex
+	bases map[string]*nxBase
+}
+
+// newNXPatternCache creates the pattern cache, or nil when disabled
+func newNXPatternCache(cfg config.NXDomainPatternConfig) *nxPatternCache {
+	if !cfg.Enabled || cfg.Threshold <= 0 {
+		return nil
+	}
+	return &nxPatternCache{cfg: cfg, bases: make(map[string]*nxBase)}
+}
+
+// registeredDomain returns the registered domain (eTLD+1) of name, or ""
+// when name is itself a public suffix or registered domain
+func registeredDomain(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	base, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil || base == name {
+		return ""
+	}
+	return base
+}
+
+// answer returns an NXDOMAIN reply for r when an active pattern covers
+// domain. Expired patterns are removed.
+func (c *nxPatternCache) answer(r *dns.Msg, domain string, now time.Time) (*dns.Msg, bool) {
+	if c == nil {
+		return nil, false
+	}
+	base := registeredDomain(domain)
+	if base == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	b, ok := c.bases[base]
+	if !ok || b.until.IsZero() {
+		c.mu.Unlock()
+		return nil, false
+	}
+	if !now.Before(b.until) {
+		delete(c.bases, base)
+		c.mu.Unlock()
+		return nil, false
+	}
+	b.hits++
+	template := b.reply
+	c.mu.Unlock()
+
+	nxPatternHits.Inc()
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeNameError)
+	m.RecursionAvailable = true
+	for _, rr := range template.Ns {
+		m.Ns = append(m.Ns, dns.Copy(rr))
+	}
+	return m, true
+}
+
+// observe records an upstream answer for domain and reports the
+// registered domain whose pattern it armed, if any
+func (c *nxPatternCache) observe(domain string, resp *dns.Msg, now time.Time) string {
+	if c == nil || resp == nil || resp.Rcode != dns.RcodeNameError {
+		return ""
+	}
+	base := registeredDomain(domain)
+	if base == "" {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.bases[base]
+	if !ok {
+		if len(c.bases) >= maxNXBases {
+			c.sweepLocked(now)
+			if len(c.bases) >= maxNXBases {
+				return ""
+			}
+		}
+		b = &nxBase{}
+		c.bases[base] = b
+	}
+	if !b.until.IsZero() {
+		return "" // already active
+	}
+	if b.names == nil || now.Sub(b.start) > c.cfg.Window {
+		b.names = make(map[string]struct{})
+		b.start = now
+	}
+	b.names[strings.ToLower(domain)] = struct{}{}
+	b.reply = resp
+
+	if len(b.names) < c.cfg.Threshold {
+		return ""
+	}
+	b.until = now.Add(c.cfg.TTL)
+	b.names = nil
+	nxPatternsArmed.Inc()
+	return base
+}
+
+// sweepLocked drops inactive bases whose window has passed and expired
+// patterns. c.mu must be held.
+func (c *nxPatternCache) sweepLocked(now time.Time) {
+	for base, b := range c.bases {
+		if b.until.IsZero() && now.Sub(b.start) > c.cfg.Window ||
+			!b.until.IsZero() && !now.Before(b.until) {
+			delete(c.bases, base)
+		}
+	}
+}
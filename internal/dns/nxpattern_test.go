@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+func nxdomain(name string) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	resp := new(dns.Msg)
+	resp.SetRcode(q, dns.RcodeNameError)
+	soa, _ := dns.NewRR("victim.com. 300 IN SOA ns.victim.com. admin.victim.com. 1 3600 600 86400 300")
+	resp.Ns = []dns.RR{soa}
+	return resp
+}
+
+func TestNXPatternCache(t *testing.T) {
+	c := newNXPatternCache(config.NXDomainPatternConfig{
+		Enabled:   true,
+		Threshold: 3,
+		Window:    10 * time.Second,
+		TTL:       time.Minute,
+	})
+	now := time.Now()
+
+	q := new(dns.Msg)
+	q.SetQuestion("fresh.victim.com.", dns.TypeA)
+
+	for i := 0; i < 2; i++ {
+		name := fmt.Sprintf("r%d.victim.com", i)
+		if base := c.observe(name, nxdomain(name), now); base != "" {
+			t.Fatalf("Pattern armed after %d NXDOMAINs", i+1)
+		}
+	}
+	if _, ok := c.answer(q, "fresh.victim.com", now); ok {
+		t.Fatal("Expected no pattern answer below the threshold")
+	}
+
+	if base := c.observe("r2.victim.com", nxdomain("r2.victim.com"), now); base != "victim.com" {
+		t.Fatalf("Expected victim.com pattern armed, got %q", base)
+	}
+
+	resp, ok := c.answer(q, "fresh.victim.com", now)
+	if !ok || resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 || resp.Id != q.Id {
+		t.Fatalf("Expected NXDOMAIN with SOA for a name under the pattern, got %v", resp)
+	}
+	if _, ok := c.answer(q, "victim.com", now); ok {
+		t.Error("Pattern must not cover the registered domain itself")
+	}
+	if _, ok := c.answer(q, "other.example.com", now); ok {
+		t.Error("Pattern covered an unrelated domain")
+	}
+
+	if _, ok := c.answer(q, "fresh.victim.com", now.Add(2*time.Minute)); ok {
+		t.Error("Expected the pattern to expire after its TTL")
+	}
+}
+
+func TestNXPatternCacheWindow(t *testing.T) {
+	c := newNXPatternCache(config.NXDomainPatternConfig{
+		Enabled:   true,
+		Threshold: 2,
+		Window:    time.Second,
+		TTL:       time.Minute,
+	})
+	now := time.Now()
+
+	c.observe("a.victim.com", nxdomain("a.victim.com"), now)
+	if base := c.observe("b.victim.com", nxdomain("b.victim.com"), now.Add(2*time.Second)); base != "" {
+		t.Error("NXDOMAINs outside one window armed a pattern")
+	}
+
+	if newNXPatternCache(config.NXDomainPatternConfig{Threshold: 2}) != nil {
+		t.Error("Expected a disabled pattern cache to be nil")
+	}
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var paddedResponses = metrics.NewCounter("ddd_padded_responses_total",
+	"Responses padded (RFC 7830) for clients on encrypted transports")
+
+// encryptedAddr is the remote address of a client on an encrypted
+// transport: DoT or DoH, or a connection a trusted proxy terminated TLS
+// for (DNS over TLS in front of the TCP listener)
+type encryptedAddr struct {
+	Client *net.TCPAddr
+	// Fingerprint is the client's TLS fingerprint when this server
+	// terminated its TLS
+	Fingerprint string
+}
+
+// Network returns the address network
+func (a *encryptedAddr) Network() string {
+	return "tcp"
+}
+
+// String returns the client address
+func (a *encryptedAddr) String() string {
+	return a.Client.String()
+}
+
+// paddingWriter pads responses to a multiple of blockSize bytes so their
+// size says less about what was asked
+type paddingWriter struct {
+	dns.ResponseWriter
+	blockSize int
+}
+
+// paddedWriter returns w wrapped to pad responses when the client is on
+// an encrypted transport and padded its query, as RFC 7830 asks of
+// responders; otherwise w is returned unchanged. Padding on unencrypted
+// transports would only cost bandwidth.
+func (s *Server) paddedWriter(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	if s.opts.PaddingBlockSize <= 0 {
+		return w
+	}
+	if _, ok := w.RemoteAddr().(*encryptedAddr); !ok {
+		return w
+	}
+	opt := r.IsEdns0()
+	if opt == nil || paddingOption(opt) == nil {
+		return w
+	}
+	return &paddingWriter{ResponseWriter: w, blockSize: s.opts.PaddingBlockSize}
+}
+
+// WriteMsg pads a copy of m, which may be shared with the cache, and
+// writes it
+func (w *paddingWriter) WriteMsg(m *dns.Msg) error {
+	m = m.Copy()
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	padding := paddingOption(opt)
+	if padding == nil {
+		padding = &dns.EDNS0_PADDING{}
+		opt.Option = append(opt.Option, padding)
+	}
+
+	padding.Padding = nil
+	if rem := m.Len() % w.blockSize; rem != 0 {
+		padding.Padding = make([]byte, w.blockSize-rem)
+	}
+	paddedResponses.Inc()
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// paddingOption returns the padding option of opt, or nil
+func paddingOption(opt *dns.OPT) *dns.EDNS0_PADDING {
+	for _, o := range opt.Option {
+		if p, ok := o.(*dns.EDNS0_PADDING); ok {
+			return p
+		}
+	}
+	return nil
+}
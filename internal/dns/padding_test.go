@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// recordingWriter keeps the last message written to a client at addr
+type recordingWriter struct {
+	dns.ResponseWriter
+	addr net.Addr
+	msg  *dns.Msg
+}
+
+func (w *recordingWriter) RemoteAddr() net.Addr      { return w.addr }
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error { w.msg = m; return nil }
+
+func TestParseProxyV2TLSClient(t *testing.T) {
+	header := proxyV2Header(net.ParseIP("203.0.113.9"), 40000)
+	// PP2_TYPE_SSL: client flags, verify result, no sub-TLVs
+	tlv := []byte{pp2TypeSSL, 0, 5, pp2ClientSSL, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[14:], uint16(12+len(tlv)))
+
+	addr, err := parseProxyV2(bytes.NewReader(append(header, tlv...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tls, ok := addr.(*encryptedAddr); !ok || tls.Client.Port != 40000 {
+		t.Errorf("Expected an encrypted client address, got %#v", addr)
+	}
+	if ip := (&Server{}).extractClientIP(addr); ip != "203.0.113.9" {
+		t.Errorf("Expected the client IP, got %s", ip)
+	}
+}
+
+func TestPaddingForEncryptedClients(t *testing.T) {
+	s := &Server{opts: Options{PaddingBlockSize: 468}}
+	tls := &encryptedAddr{Client: &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 40000}}
+
+	padded := new(dns.Msg)
+	padded.SetQuestion("example.com.", dns.TypeA)
+	padded.SetEdns0(dns.DefaultMsgSize, false)
+	opt := padded.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 20)})
+
+	plain := new(dns.Msg)
+	plain.SetQuestion("example.com.", dns.TypeA)
+
+	resp := new(dns.Msg)
+	resp.SetReply(plain)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+
+	rec := &recordingWriter{addr: tls}
+	s.paddedWriter(rec, padded).WriteMsg(resp)
+	packed, err := rec.msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed)%468 != 0 {
+		t.Errorf("Expected the response padded to a multiple of 468 bytes, got %d", len(packed))
+	}
+	if resp.IsEdns0() != nil {
+		t.Error("Expected the original response to be left unpadded")
+	}
+
+	for _, tt := range []struct {
+		name string
+		addr net.Addr
+		q    *dns.Msg
+	}{
+		{"unpadded query", tls, plain},
+		{"plain TCP", tls.Client, padded},
+	} {
+		if _, wrapped := s.paddedWriter(&recordingWriter{addr: tt.addr}, tt.q).(*paddingWriter); wrapped {
+			t.Errorf("%s: expected no padding", tt.name)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/cache"
+	"ddd/internal/metrics"
+)
+
+var pairedQueries = metrics.NewCounterVec("ddd_qtype_pairs_total",
+	"A/AAAA companions fetched for clients asking for both, and address queries answered from a resolution already in flight", "result")
+
+const (
+	// pairWindow is how soon after one address type of a name a client
+	// must ask for the other to be seen pairing them
+	pairWindow = time.Second
+	// pairMemory is how long companions are fetched for a client after
+	// it was last seen pairing
+	pairMemory = 10 * time.Minute
+	// maxPairClients bounds the clients the pairer remembers
+	maxPairClients = 1 << 16
+)
+
+// addressQuery is the latest A or AAAA query of a client
+type addressQuery struct {
+	name  string
+	qtype uint16
+	at    time.Time
+}
+
+// qtypePairer recognises clients that ask for A and AAAA of a name back
+// to back, as dual-stack stub resolvers do, and tracks the address
+// resolutions in progress so the second query of a pair waits for the
+// exchange the first one started instead of forwarding its own
+type qtypePairer struct {
+	mu      sync.Mutex
+	last    map[string]addressQuery // by client
+	pairing map[string]time.Time    // client -> when it last paired
+	flights map[cache.Key]*flight
+}
+
+// newQTypePairer creates an empty pairer
+func newQTypePairer() *qtypePairer {
+	return &qtypePairer{
+		last:    make(map[string]addressQuery),
+		pairing: make(map[string]time.Time),
+		flights: make(map[cache.Key]*flight),
+	}
+}
+
+// companionType returns the other address type of qtype, or 0 if qtype is
+// not an address type
+func companionType(qtype uint16) uint16 {
+	switch qtype {
+	case dns.TypeA:
+		return dns.TypeAAAA
+	case dns.TypeAAAA:
+		return dns.TypeA
+	}
+	return 0
+}
+
+// observe records an address query of client at now and reports whether
+// the client pairs A with AAAA
+func (p *qtypePairer) observe(client string, q dns.Question, now time.Time) bool {
+	name := strings.ToLower(q.Name)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if prev, ok := p.last[client]; ok && prev.name == name &&
+		prev.qtype == companionType(q.Qtype) && now.Sub(prev.at) < pairWindow {
+		p.pairing[client] = now
+	}
+	if len(p.last) >= maxPairClients || len(p.pairing) >= maxPairClients {
+		p.sweep(now)
+	}
+	p.last[client] = addressQuery{name: name, qtype: q.Qtype, at: now}
+
+	paired, ok := p.pairing[client]
+	return ok && now.Sub(paired) < pairMemory
+}
+
+// sweep forgets clients that can no longer complete a pair or have
+// stopped pairing, and everyone if that frees nothing
+func (p *qtypePairer) sweep(now time.Time) {
+	for client, q := range p.last {
+		if now.Sub(q.at) >= pairWindow {
+			delete(p.last, client)
+		}
+	}
+	for client, at := range p.pairing {
+		if now.Sub(at) >= pairMemory {
+			delete(p.pairing, client)
+		}
+	}
+	if len(p.last) >= maxPairClients {
+		p.last = make(map[string]addressQuery)
+	}
+	if len(p.pairing) >= maxPairClients {
+		p.pairing = make(map[string]time.Time)
+	}
+}
+
+// begin returns the resolution in progress for key and whether the
+// caller started it and must resolve the query and call finish
+func (p *qtypePairer) begin(key cache.Key) (*flight, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if f, ok := p.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	p.flights[key] = f
+	return f, true
+}
+
+// finish records the outcome of the resolution for key and releases its
+// waiters. Later queries are answered from the cache.
+func (p *qtypePairer) finish(key cache.Key, f *flight, resp *dns.Msg) {
+	p.mu.Lock()
+	f.resp = resp
+	f.finished = time.Now()
+	delete(p.flights, key)
+	p.mu.Unlock()
+	close(f.done)
+}
+
+// pairQType notes an address query from a client and, once the client is
+// seen asking for A and AAAA together, starts resolving the other type of
+// the name unless it is cached or already being resolved. Nothing is
+// fetched ahead while panic mode serves from cache only.
+func (s *Server) pairQType(r *dns.Msg, clientIP, domain string, critical bool) {
+	if s.pairs == nil || s.cacheOnly(critical) {
+		return
+	}
+	q := r.Question[0]
+	other := companionType(q.Qtype)
+	if other == 0 || !s.pairs.observe(clientIP, q, time.Now()) {
+		return
+	}
+
+	m := r.Copy()
+	m.Id = dns.Id()
+	m.Question[0].Qtype = other
+	if s.opts.Cache.Has(m) {
+		return
+	}
+	key := cache.KeyFor(m)
+	f, first := s.pairs.begin(key)
+	if !first {
+		return
+	}
+
+	pairedQueries.With("fetched").Inc()
+	go func() {
+		s.pairs.finish(key, f, s.resolve(m, domain))
+	}()
+}
+
+// resolvePaired resolves query r upstream. With pairing enabled, an
+// address query whose name and type are already being resolved, as a
+// companion or for another client, waits for that exchange instead.
+func (s *Server) resolvePaired(r *dns.Msg, domain string) *dns.Msg {
+	if s.pairs == nil || companionType(r.Question[0].Qtype) == 0 {
+		return s.resolve(r, domain)
+	}
+
+	key := cache.KeyFor(r)
+	f, first := s.pairs.begin(key)
+	if first {
+		resp := s.resolve(r, domain)
+		s.pairs.finish(key, f, resp)
+		return resp
+	}
+
+	resp := f.wait(s.upstreamClient.Timeout)
+	if resp == nil {
+		return nil
+	}
+	pairedQueries.With("joined").Inc()
+	resp.Id = r.Id
+	resp.Question = []dns.Question{r.Question[0]}
+	return resp
+}
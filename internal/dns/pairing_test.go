@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/cache"
+)
+
+func TestPairerRecognisesAddressPairs(t *testing.T) {
+	p := newQTypePairer()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := dns.Question{Name: "Example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	aaaa := dns.Question{Name: "example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+
+	if p.observe("192.0.2.1", a, now) {
+		t.Fatal("Expected a single A query not to count as pairing")
+	}
+	if !p.observe("192.0.2.1", aaaa, now.Add(10*time.Millisecond)) {
+		t.Fatal("Expected A then AAAA of the same name to count as pairing")
+	}
+	// Remembered for later names
+	other := dns.Question{Name: "other.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	if !p.observe("192.0.2.1", other, now.Add(time.Minute)) {
+		t.Error("Expected a pairing client to stay pairing")
+	}
+	if p.observe("192.0.2.1", other, now.Add(time.Minute+pairMemory)) {
+		t.Error("Expected pairing to be forgotten after a quiet spell")
+	}
+
+	// Too far apart, or another client
+	if p.observe("192.0.2.2", a, now) || p.observe("192.0.2.2", aaaa, now.Add(2*pairWindow)) {
+		t.Error("Expected queries further apart than the window not to pair")
+	}
+	if p.observe("192.0.2.3", aaaa, now) {
+		t.Error("Expected another client's pair not to count")
+	}
+}
+
+func TestPairerSharesAddressResolutions(t *testing.T) {
+	p := newQTypePairer()
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeAAAA)
+	key := cache.KeyFor(q)
+
+	f, first := p.begin(key)
+	if !first {
+		t.Fatal("Expected the first resolution to start a flight")
+	}
+	joined, first := p.begin(key)
+	if first || joined != f {
+		t.Fatal("Expected a query for the same name and type to join the flight")
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	go p.finish(key, f, resp)
+	if got := joined.wait(time.Second); got == nil || got.Question[0].Qtype != dns.TypeAAAA {
+		t.Fatalf("Expected the shared answer, got %v", got)
+	}
+
+	// Finished flights are left to the cache
+	if _, first := p.begin(key); !first {
+		t.Error("Expected a finished resolution to be forgotten")
+	}
+}
@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var panicRejections = metrics.NewCounterVec("ddd_panic_rejections_total",
+	"Queries turned away in panic mode, by reason (cap, unknown_client, cache_miss)", "reason")
+
+// panicGate applies panic mode to a query: past the global cap it is
+// dropped, and clients first seen after panic mode was engaged are
+// refused. It reports whether the query was dealt with. Critical queries
+// are exempt.
+func (s *Server) panicGate(w dns.ResponseWriter, r *dns.Msg, clientIP string, critical bool) bool {
+	since, engaged := s.opts.Panic.Engaged()
+	if !engaged || critical {
+		return false
+	}
+	now := time.Now()
+	if !s.opts.Panic.Admit(now) {
+		panicRejections.With("cap").Inc()
+		return true
+	}
+	if firstSeen, _, known := s.trafficMonitor.GetClientAge(clientIP); !known || !firstSeen.Before(since) {
+		panicRejections.With("unknown_client").Inc()
+		s.sendRefused(w, r)
+		return true
+	}
+	return false
+}
+
+// cacheOnly reports whether a cache miss must not be forwarded upstream
+// because panic mode is engaged
+func (s *Server) cacheOnly(critical bool) bool {
+	_, engaged := s.opts.Panic.Engaged()
+	return engaged && !critical
+}
@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/cache"
+	"ddd/internal/metrics"
+)
+
+var (
+	prefetches = metrics.NewCounter("ddd_prefetches_total",
+		"Cached answers for popular names refreshed before they expired")
+	overloadPopularAnswers = metrics.NewCounter("ddd_overload_popular_answers_total",
+		"Queries for popular names answered from cache while shedding load")
+)
+
+// prefetch refreshes the cached answer to query r for a popular name in
+// the background when it is about to expire, so the name stays cached
+func (s *Server) prefetch(r *dns.Msg, domain string, cached *dns.Msg) {
+	if s.opts.PrefetchBefore <= 0 || minTTL(cached) > s.opts.PrefetchBefore || !s.opts.Popularity.Popular(domain) {
+		return
+	}
+	key := cache.KeyFor(r)
+	if _, running := s.prefetching.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	prefetches.Inc()
+	go func() {
+		defer s.prefetching.Delete(key)
+		// Asked with the client's DO and CD bits, to refresh the same entry
+		q := r.Question[0]
+		m := new(dns.Msg)
+		m.SetQuestion(q.Name, q.Qtype)
+		m.Question[0].Qclass = q.Qclass
+		m.CheckingDisabled = key.CD
+		if key.DO {
+			m.SetEdns0(dns.DefaultMsgSize, true)
+		}
+		s.resolve(m, domain)
+	}()
+}
+
+// cachedReply builds the reply to r from a response cached for another
+// query with the same key: r's header and question, which may differ in
+// ID and letter case, the cached records without the OPT record they were
+// stored with, and r's own EDNS. It is truncated to what r's client can
+// receive over UDP.
+func cachedReply(w dns.ResponseWriter, r, cached *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Rcode = cached.Rcode
+	m.Authoritative = cached.Authoritative
+	m.RecursionAvailable = cached.RecursionAvailable
+	m.AuthenticatedData = cached.AuthenticatedData
+	m.Answer = cached.Answer
+	m.Ns = cached.Ns
+	for _, rr := range cached.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			m.Extra = append(m.Extra, rr)
+		}
+	}
+
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = max(int(opt.UDPSize()), dns.MinMsgSize)
+		m.SetEdns0(uint16(size), opt.Do())
+	}
+	if w.RemoteAddr().Network() != "udp" {
+		size = dns.MaxMsgSize
+	}
+	m.Truncate(size)
+	return m
+}
+
+// minTTL returns the smallest TTL of the records in msg's answer and
+// authority sections
+func minTTL(msg *dns.Msg) time.Duration {
+	lowest := ^uint32(0)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range section {
+			if ttl := rr.Header().Ttl; ttl < lowest {
+				lowest = ttl
+			}
+		}
+	}
+	return time.Duration(lowest) * time.Second
+}
+
+// answerPopular answers a query for a popular name from the cache while
+// the server is shedding load, and reports whether it did. TTLs are
+// raised to the shed retry interval. Blocked clients are not answered.
+func (s *Server) answerPopular(w dns.ResponseWriter, r *dns.Msg, clientIP string) bool {
+	if len(r.Question) == 0 || !s.opts.Popularity.Popular(r.Question[0].Name) || s.ipBlocker.IsBlocked(clientIP) {
+		return false
+	}
+	cached := s.opts.Cache.Get(r)
+	if cached == nil {
+		return false
+	}
+
+	reply := cachedReply(w, r, cached)
+	// Caching stubs keep the answer at least until the load should be gone
+	raiseTTLs(reply, uint32(s.opts.Shed.RetryAfter.Seconds()))
+	if err := w.WriteMsg(reply); err != nil {
+		s.log.Errorw("Error writing response", "error", err)
+	}
+	overloadPopularAnswers.Inc()
+	return true
+}
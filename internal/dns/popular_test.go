@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/cache"
+	"ddd/internal/detector"
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestCacheHitRepliesToEachQuery(t *testing.T) {
+	log := logger.NewNop()
+	responses := cache.New(100, time.Hour)
+	s := NewServer(0, "127.0.0.1:1", monitor.NewTrafficMonitor(), detector.NewDDoSDetector(100, log),
+		blocker.NewIPBlocker(60, nil), log, Options{Cache: responses})
+
+	// Cached from a query with a large EDNS buffer, in lower case
+	stored := new(dns.Msg)
+	stored.SetQuestion("example.com.", dns.TypeA)
+	stored.SetEdns0(4096, false)
+	reply := new(dns.Msg)
+	reply.SetReply(stored)
+	for i := 1; i <= 40; i++ {
+		reply.Answer = append(reply.Answer, mustRR(t, fmt.Sprintf("example.com. 300 IN A 192.0.2.%d", i)))
+	}
+	reply.SetEdns0(4096, false)
+	responses.Set(reply)
+
+	udp := &recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.100"), Port: 5300}}
+	r := new(dns.Msg)
+	r.SetQuestion("ExAmPlE.CoM.", dns.TypeA)
+	r.SetEdns0(1232, false)
+	s.handleDNSRequest(udp, r)
+	got := udp.msg
+	if got == nil || got.Id != r.Id || got.Question[0].Name != "ExAmPlE.CoM." {
+		t.Fatalf("Expected the reply to carry the query's ID and casing, got %v", got)
+	}
+	if opt := got.IsEdns0(); opt == nil || opt.UDPSize() != 1232 || len(got.Extra) != 1 {
+		t.Errorf("Expected the query's own EDNS in the reply alone, got %v", got.Extra)
+	}
+	if got.Truncated || len(got.Answer) != 40 {
+		t.Errorf("Expected all 40 records within 1232 bytes, got %d (truncated %v)", len(got.Answer), got.Truncated)
+	}
+
+	// The same question without EDNS gets none, and only what fits 512 bytes
+	r = new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	s.handleDNSRequest(udp, r)
+	got = udp.msg
+	if got == nil || got.IsEdns0() != nil {
+		t.Fatalf("Expected no OPT record for a query without EDNS, got %v", got)
+	}
+	if !got.Truncated || got.Len() > dns.MinMsgSize {
+		t.Errorf("Expected a truncated reply within %d bytes, got %d (truncated %v)", dns.MinMsgSize, got.Len(), got.Truncated)
+	}
+
+	// Over TCP the whole answer fits
+	tcp := &recordingWriter{addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.100"), Port: 5300}}
+	s.handleDNSRequest(tcp, r)
+	if got := tcp.msg; got == nil || got.Truncated || len(got.Answer) != 40 {
+		t.Errorf("Expected the whole answer over TCP, got %v", got)
+	}
+}
@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/journal"
+	"ddd/internal/metrics"
+	"ddd/internal/policy"
+)
+
+var protocolAbuseMessages = metrics.NewCounterVec("ddd_protocol_abuse_total",
+	"Malformed or abusive messages rejected, by kind", "kind")
+
+// acceptMessage replaces the library's default accept check, which
+// rejects unsupported opcodes and multi-question messages before the
+// handler sees them. Those are passed on so they can be counted against
+// the client; responses and messages with implausible section counts are
+// still dropped or rejected up front.
+func acceptMessage(dh dns.Header) dns.MsgAcceptAction {
+	const qr = 1 << 15
+	if dh.Bits&qr != 0 {
+		return dns.MsgIgnore
+	}
+	if dh.Ancount > 1 || dh.Nscount > 1 || dh.Arcount > 2 {
+		return dns.MsgReject
+	}
+	return dns.MsgAccept
+}
+
+// protocolAbuse classifies messages a well-behaved stub never sends and
+// returns the kind and the rcode to reject them with, or "" for messages
+// that may be processed. NOTIFY is left to the zone transfer check.
+func protocolAbuse(r *dns.Msg, maxEDNSOptions int) (string, int) {
+	if r.Opcode != dns.OpcodeQuery && r.Opcode != dns.OpcodeNotify {
+		return "opcode", dns.RcodeNotImplemented
+	}
+	if len(r.Question) > 1 {
+		return "multi_question", dns.RcodeFormatError
+	}
+
+	opts := 0
+	for _, rr := range r.Extra {
+		if opt, ok := rr.(*dns.OPT); ok {
+			opts++
+			if maxEDNSOptions > 0 && len(opt.Option) > maxEDNSOptions {
+				return "edns_options", dns.RcodeFormatError
+			}
+		}
+	}
+	if opts > 1 {
+		return "edns_multiple_opt", dns.RcodeFormatError
+	}
+	return "", dns.RcodeSuccess
+}
+
+// rejectProtocolAbuse rejects an abusive message, counts it against the
+// client and applies the detector's verdict
+func (s *Server) rejectProtocolAbuse(w dns.ResponseWriter, r *dns.Msg, clientIP, kind string, rcode int) {
+	protocolAbuseMessages.With(kind).Inc()
+
+	domain, qtype := "", ""
+	if len(r.Question) > 0 {
+		domain = strings.TrimSuffix(r.Question[0].Name, ".")
+		qtype = qtypeName(r.Question[0].Qtype)
+	}
+	s.trafficMonitor.RecordRequest(clientIP, domain, qtype)
+	count := s.trafficMonitor.RecordProtocolAbuse(clientIP)
+
+	ddosDetector, group := s.detectorFor(clientIP, clientFingerprint(w.RemoteAddr()))
+	result := ddosDetector.AnalyzeProtocolAbuse(clientIP, kind, count)
+	s.countDetection(clientIP, group, result)
+	s.log.Warnw("Attack detected", groupFields(group, fingerprintFields(w.RemoteAddr(),
+		"ip", clientIP,
+		"attack_type", result.AttackType,
+		"severity", result.Severity.String(),
+		"abuse", kind,
+	)...)...)
+	decision, decidedBy := policy.RateLimit, "detector"
+	if result.ShouldBlock {
+		decision = policy.Block
+	}
+	if capped := groupDecision(group, decision); capped != decision {
+		decision, decidedBy = capped, "group"
+	}
+	s.journalDetection(journal.SourceProtocolAbuse, clientIP, domain, qtype, ddosDetector, group,
+		result, map[string]float64{"abusive_messages": float64(count)}, decision, decidedBy)
+	switch decision {
+	case policy.Block:
+		s.ipBlocker.BlockIPWithSeverity(clientIP, result.AttackType, result.Severity)
+	case policy.RateLimit:
+		s.rateLimit(clientIP, r)
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	w.WriteMsg(m)
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestProtocolAbuse(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	query.SetEdns0(1232, true)
+
+	update := new(dns.Msg)
+	update.SetUpdate("example.com.")
+
+	multi := new(dns.Msg)
+	multi.SetQuestion("example.com.", dns.TypeA)
+	multi.Question = append(multi.Question, dns.Question{Name: "example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+
+	options := new(dns.Msg)
+	options.SetQuestion("example.com.", dns.TypeA)
+	options.SetEdns0(1232, false)
+	opt := options.IsEdns0()
+	for i := 0; i < 4; i++ {
+		opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: dns.EDNS0LOCALSTART, Data: []byte{byte(i)}})
+	}
+
+	twoOPT := new(dns.Msg)
+	twoOPT.SetQuestion("example.com.", dns.TypeA)
+	twoOPT.SetEdns0(1232, false)
+	twoOPT.Extra = append(twoOPT.Extra, dns.Copy(twoOPT.Extra[0]))
+
+	notify := new(dns.Msg)
+	notify.SetNotify("example.com.")
+
+	cases := []struct {
+		msg   *dns.Msg
+		kind  string
+		rcode int
+	}{
+		{query, "", dns.RcodeSuccess},
+		{notify, "", dns.RcodeSuccess},
+		{update, "opcode", dns.RcodeNotImplemented},
+		{multi, "multi_question", dns.RcodeFormatError},
+		{options, "edns_options", dns.RcodeFormatError},
+		{twoOPT, "edns_multiple_opt", dns.RcodeFormatError},
+	}
+	for _, c := range cases {
+		if kind, rcode := protocolAbuse(c.msg, 3); kind != c.kind || rcode != c.rcode {
+			t.Errorf("Expected %q/%s, got %q/%s", c.kind, dns.RcodeToString[c.rcode], kind, dns.RcodeToString[rcode])
+		}
+	}
+}
+
+func TestAcceptMessagePassesAbuseToHandler(t *testing.T) {
+	if got := acceptMessage(dns.Header{Bits: uint16(dns.OpcodeUpdate) << 11, Qdcount: 1}); got != dns.MsgAccept {
+		t.Errorf("Expected UPDATE to reach the handler, got %v", got)
+	}
+	if got := acceptMessage(dns.Header{Qdcount: 2}); got != dns.MsgAccept {
+		t.Errorf("Expected multi-question messages to reach the handler, got %v", got)
+	}
+	if got := acceptMessage(dns.Header{Bits: 1 << 15, Qdcount: 1}); got != dns.MsgIgnore {
+		t.Errorf("Expected responses to be ignored, got %v", got)
+	}
+	if got := acceptMessage(dns.Header{Qdcount: 1, Arcount: 10}); got != dns.MsgReject {
+		t.Errorf("Expected oversized additional sections to be rejected, got %v", got)
+	}
+}
@@ -0,0 +1,189 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"ddd/internal/metrics"
+)
+
+var proxyHeaderErrors = metrics.NewCounter("ddd_proxy_protocol_errors_total",
+	"Connections from trusted proxies closed for a missing or invalid PROXY v2 header")
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// PROXY v2 TLV announcing that the client connected over TLS
+const (
+	pp2TypeSSL   = 0x20
+	pp2ClientSSL = 0x01
+)
+
+// parseProxyV2 reads a PROXY protocol v2 header from r and returns the
+// original client address, as an *encryptedAddr when the proxy reports
+// that the client connected over TLS. LOCAL commands (health checks from
+// the proxy itself) return a nil address.
+func parseProxyV2(r io.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return nil, errors.New("missing PROXY v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY version %d", hdr[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading PROXY addresses: %w", err)
+	}
+
+	switch hdr[12] & 0x0F {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY command %d", hdr[12]&0x0F)
+	}
+
+	// Family in the high nibble, transport in the low nibble
+	switch hdr[13] >> 4 {
+	case 0x1: // IPv4: src, dst, src port, dst port
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY IPv4 address block")
+		}
+		return withTLS(&net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), body[0:4]...)),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, body[12:]), nil
+	case 0x2: // IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY IPv6 address block")
+		}
+		return withTLS(&net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), body[0:16]...)),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, body[36:]), nil
+	default: // UNSPEC or unix sockets: keep the proxy's address
+		return nil, nil
+	}
+}
+
+// withTLS returns client as an *encryptedAddr if the TLVs following the
+// address block report a TLS connection
+func withTLS(client *net.TCPAddr, tlvs []byte) net.Addr {
+	for len(tlvs) >= 3 {
+		typ, n := tlvs[0], int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			break
+		}
+		if typ == pp2TypeSSL && n >= 1 && tlvs[3]&pp2ClientSSL != 0 {
+			return &encryptedAddr{Client: client}
+		}
+		tlvs = tlvs[3+n:]
+	}
+	return client
+}
+
+// proxyListener accepts TCP connections and, for peers in trusted, takes
+// the client address from a PROXY v2 header so the real client, not the
+// load balancer, is monitored, rate limited and blocked
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// Accept returns the next connection; headers are parsed on first use so
+// a slow proxy cannot stall the accept loop
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !ipInNets(tcpAddr.IP, l.trusted) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection from a trusted proxy
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// init reads the PROXY header once. The DNS server's read deadline for
+// the first message also bounds the header read.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remote, c.err = parseProxyV2(c.reader)
+
+		if c.err != nil {
+			proxyHeaderErrors.Inc()
+			c.Conn.Close()
+		}
+		if c.remote == nil {
+			c.remote = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+// Read reads DNS data following the PROXY header
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address announced by the proxy
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// ipInNets reports whether ip is inside any of nets
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses a list of CIDRs or bare addresses
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// proxyV2Header builds a PROXY v2 TCP/IPv4 header for src
+func proxyV2Header(src net.IP, port uint16) []byte {
+	var b bytes.Buffer
+	b.Write(proxyV2Signature)
+	b.WriteByte(0x21) // version 2, PROXY
+	b.WriteByte(0x11) // IPv4, stream
+	binary.Write(&b, binary.BigEndian, uint16(12))
+	b.Write(src.To4())
+	b.Write(net.IPv4(192, 0, 2, 53).To4())
+	binary.Write(&b, binary.BigEndian, port)
+	binary.Write(&b, binary.BigEndian, uint16(53))
+	return b.Bytes()
+}
+
+func TestParseProxyV2(t *testing.T) {
+	r := bytes.NewReader(append(proxyV2Header(net.ParseIP("203.0.113.9"), 40000), "dns"...))
+
+	addr, err := parseProxyV2(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || !tcp.IP.Equal(net.ParseIP("203.0.113.9")) || tcp.Port != 40000 {
+		t.Errorf("Expected 203.0.113.9:40000, got %v", addr)
+	}
+
+	rest := make([]byte, 3)
+	if _, err := r.Read(rest); err != nil || string(rest) != "dns" {
+		t.Errorf("Expected payload after the header to be left unread, got %q", rest)
+	}
+}
+
+func TestParseProxyV2RejectsPlainDNS(t *testing.T) {
+	if _, err := parseProxyV2(bytes.NewReader(make([]byte, 32))); err == nil {
+		t.Error("Expected an error for a stream without a PROXY header")
+	}
+}
+
+func TestProxyListenerUsesHeaderAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	trusted, _ := parseCIDRs([]string{"127.0.0.1"})
+	pl := &proxyListener{Listener: ln, trusted: trusted}
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write(append(proxyV2Header(net.ParseIP("198.51.100.4"), 1234), "x"...))
+	}()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "198.51.100.4" {
+		t.Errorf("Expected client address from the PROXY header, got %s", got)
+	}
+}
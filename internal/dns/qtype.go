@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"strconv"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var queriesByType = metrics.NewCounterVec("ddd_dns_queries_total",
+	"DNS queries received, by query type", "qtype")
+
+// labelledQTypes get their own qtype label in metrics; everything else is
+// counted as "other" to keep label cardinality bounded. SVCB and HTTPS are
+// listed because browsers send them alongside nearly every A/AAAA lookup.
+var labelledQTypes = map[uint16]bool{
+	dns.TypeA:      true,
+	dns.TypeAAAA:   true,
+	dns.TypeANY:    true,
+	dns.TypeCAA:    true,
+	dns.TypeCNAME:  true,
+	dns.TypeDNSKEY: true,
+	dns.TypeDS:     true,
+	dns.TypeHTTPS:  true,
+	dns.TypeMX:     true,
+	dns.TypeNAPTR:  true,
+	dns.TypeNS:     true,
+	dns.TypePTR:    true,
+	dns.TypeSOA:    true,
+	dns.TypeSRV:    true,
+	dns.TypeSVCB:   true,
+	dns.TypeTXT:    true,
+}
+
+// qtypeName returns the mnemonic for t, or the RFC 3597 TYPEnnn form for
+// types without one
+func qtypeName(t uint16) string {
+	if name, ok := dns.TypeToString[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// qtypeLabel returns the metrics label for t
+func qtypeLabel(t uint16) string {
+	if labelledQTypes[t] {
+		return qtypeName(t)
+	}
+	return "other"
+}
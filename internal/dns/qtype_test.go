@@ -0,0 +1,25 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQTypeLabels(t *testing.T) {
+	for qtype, want := range map[uint16]string{
+		dns.TypeSVCB:  "SVCB",
+		dns.TypeHTTPS: "HTTPS",
+		dns.TypeA:     "A",
+		dns.TypeHINFO: "other",
+		65280:         "other",
+	} {
+		if got := qtypeLabel(qtype); got != want {
+			t.Errorf("qtypeLabel(%d) = %q, want %q", qtype, got, want)
+		}
+	}
+
+	if got := qtypeName(65280); got != "TYPE65280" {
+		t.Errorf("Expected RFC 3597 name for unknown type, got %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var malformedResponses = metrics.NewCounterVec("ddd_upstream_malformed_total",
+	"Upstream responses dropped as malformed, by reason", "reason")
+
+// errMalformedResponse is returned for upstream responses dropped by the
+// sanity checks
+var errMalformedResponse = errors.New("malformed upstream response")
+
+// maxNamePointers is the most compression pointers followed for one name.
+// Compressors point each name at one earlier occurrence, which itself
+// rarely ends in more than a couple of pointers.
+const maxNamePointers = 16
+
+// rdataNames locates the domain names in the RDATA of types whose names
+// may be compressed: skip bytes precede count names
+var rdataNames = map[uint16]struct{ skip, count int }{
+	dns.TypeCNAME: {0, 1},
+	dns.TypeNS:    {0, 1},
+	dns.TypePTR:   {0, 1},
+	dns.TypeDNAME: {0, 1},
+	dns.TypeMX:    {2, 1},
+	dns.TypeSRV:   {6, 1},
+	dns.TypeSOA:   {0, 2},
+}
+
+// checkWire walks the names of a wire format message, and returns why it
+// is malformed or "" when it is sane. Every compression pointer must point
+// before the label sequence it continues, so pointers can neither loop nor
+// point ahead into data an attacker laid out for a careless parser.
+func checkWire(msg []byte) string {
+	if len(msg) < 12 {
+		return "truncated"
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < questions; i++ {
+		end, reason := checkName(msg, off)
+		if reason != "" {
+			return reason
+		}
+		if off = end + 4; off > len(msg) {
+			return "truncated"
+		}
+	}
+
+	for i := 0; i < records; i++ {
+		end, reason := checkName(msg, off)
+		if reason != "" {
+			return reason
+		}
+		if end+10 > len(msg) {
+			return "truncated"
+		}
+		rrtype := binary.BigEndian.Uint16(msg[end:])
+		rdata := end + 10
+		rdend := rdata + int(binary.BigEndian.Uint16(msg[end+8:]))
+		if rdend > len(msg) {
+			return "truncated"
+		}
+
+		if names, ok := rdataNames[rrtype]; ok && rdend > rdata {
+			pos := rdata + names.skip
+			for n := 0; n < names.count; n++ {
+				if pos >= rdend {
+					return "truncated"
+				}
+				if pos, reason = checkName(msg[:rdend], pos); reason != "" {
+					return reason
+				}
+			}
+		}
+		off = rdend
+	}
+	return ""
+}
+
+// checkName walks the name at off and returns the offset just past it, or
+// why it is malformed
+func checkName(msg []byte, off int) (int, string) {
+	end := -1
+	limit := off // pointers must point before this
+	length := 1  // the root label
+	pointers := 0
+	for {
+		if off >= len(msg) {
+			return 0, "truncated"
+		}
+		c := int(msg[off])
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				return end, ""
+			}
+			if length += c + 1; length > 255 {
+				return 0, "name_too_long"
+			}
+			off += c + 1
+		case 0xC0:
+			if off+1 >= len(msg) {
+				return 0, "truncated"
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if pointers++; pointers > maxNamePointers {
+				return 0, "pointer_chain"
+			}
+			ptr := (c&0x3F)<<8 | int(msg[off+1])
+			if ptr < 12 {
+				return 0, "header_pointer"
+			}
+			if ptr >= limit {
+				return 0, "forward_pointer"
+			}
+			off, limit = ptr, ptr
+		default:
+			return 0, "bad_label"
+		}
+	}
+}
+
+// oversizedRRSet reports whether any RRset in resp holds more than max
+// records (0 means unlimited)
+func oversizedRRSet(resp *dns.Msg, max int) bool {
+	if max <= 0 {
+		return false
+	}
+	type key struct {
+		name          string
+		rrtype, class uint16
+	}
+	counts := make(map[key]int)
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+			k := key{strings.ToLower(h.Name), h.Rrtype, h.Class}
+			if counts[k]++; counts[k] > max {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exchangeRaw sends r to addr and returns the answer, checking its wire
+// format before it is unpacked. Malformed answers are returned as a
+// *malformedError so they never reach the cache or a client.
+func (s *Server) exchangeRaw(r *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+	c := s.upstreamClient
+	co, err := c.Dial(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer co.Close()
+
+	if opt := r.IsEdns0(); opt != nil && opt.UDPSize() >= dns.MinMsgSize {
+		co.UDPSize = opt.UDPSize()
+	}
+	start := time.Now()
+	co.SetDeadline(start.Add(c.Timeout))
+	if err := co.WriteMsg(r); err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		var hdr dns.Header
+		raw, err := co.ReadMsgHeader(&hdr)
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+		// Replies to earlier queries that timed out may still arrive
+		if hdr.Id != r.Id {
+			if c.Net == "" || c.Net == "udp" {
+				continue
+			}
+			return nil, time.Since(start), dns.ErrId
+		}
+		rtt := time.Since(start)
+
+		if reason := checkWire(raw); reason != "" {
+			return nil, rtt, &malformedError{reason}
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(raw); err != nil {
+			return nil, rtt, err
+		}
+		if oversizedRRSet(resp, s.opts.MaxRRSet) {
+			return nil, rtt, &malformedError{"oversized_rrset"}
+		}
+		return resp, rtt, nil
+	}
+}
+
+// malformedError is a response dropped by the sanity checks
+type malformedError struct {
+	reason string
+}
+
+func (e *malformedError) Error() string {
+	return errMalformedResponse.Error() + ": " + e.reason
+}
+
+func (e *malformedError) Unwrap() error {
+	return errMalformedResponse
+}
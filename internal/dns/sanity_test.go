@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+)
+
+// header returns a response header with the given section counts
+func header(qd, an byte) []byte {
+	return []byte{0x12, 0x34, 0x81, 0x80, 0, qd, 0, an, 0, 0, 0, 0}
+}
+
+func TestCheckWire(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	m.Response = true
+	m.Compress = true
+	m.Answer = append(m.Answer,
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "cdn.example.com."},
+		&dns.A{Hdr: dns.RR_Header{Name: "cdn.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(192, 0, 2, 1)},
+		&dns.MX{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 60}, Preference: 10, Mx: "mail.example.com."},
+		&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example.com.", Mbox: "hostmaster.example.com."},
+	)
+	wire, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := checkWire(wire); reason != "" {
+		t.Errorf("Expected a compressed answer to be sane, got %s", reason)
+	}
+	if reason := checkWire(wire[:len(wire)-3]); reason != "truncated" {
+		t.Errorf("Expected a cut answer to be truncated, got %q", reason)
+	}
+
+	question := []byte{3, 'w', 'w', 'w', 0, 0, 1, 0, 1}
+	answer := func(name ...byte) []byte {
+		rr := append(append(header(1, 1), question...), name...)
+		return append(rr, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+	}
+	for name, tc := range map[string]struct {
+		msg  []byte
+		want string
+	}{
+		"pointer to itself":       {answer(0xC0, 21), "forward_pointer"},
+		"pointer ahead":           {answer(0xC0, 40), "forward_pointer"},
+		"loop through a label":    {append(append(header(1, 0), 1, 'a', 0xC0, 12), 0, 1, 0, 1), "forward_pointer"},
+		"pointer into the header": {answer(0xC0, 2), "header_pointer"},
+		"reserved label type":     {answer(0x40, 1), "bad_label"},
+		"backward pointer":        {answer(0xC0, 12), ""},
+	} {
+		if got := checkWire(tc.msg); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", name, tc.want, got)
+		}
+	}
+
+	// A name stretched past 255 bytes through pointers
+	long := header(0, 0)
+	prev := 0
+	for i := 0; i < 5; i++ {
+		long[7]++
+		start := len(long)
+		long = append(long, 63)
+		for j := 0; j < 63; j++ {
+			long = append(long, 'x')
+		}
+		if i == 0 {
+			long = append(long, 0)
+		} else {
+			long = append(long, 0xC0|byte(prev>>8), byte(prev))
+		}
+		prev = start
+		long = append(long, 0, 1, 0, 1, 0, 0, 0, 60, 0, 0)
+	}
+	if got := checkWire(long); got != "name_too_long" {
+		t.Errorf("Expected an overlong name, got %q", got)
+	}
+}
+
+func TestOversizedRRSet(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	for i := 0; i < 5; i++ {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "Example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+	m.SetEdns0(1232, false)
+
+	if !oversizedRRSet(m, 4) {
+		t.Error("Expected five records to exceed a limit of four")
+	}
+	if oversizedRRSet(m, 5) || oversizedRRSet(m, 0) {
+		t.Error("Expected the RRset within the limit")
+	}
+}
+
+func TestMalformedUpstreamDropped(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Answer every query with a copy whose answer name points at itself
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := append([]byte(nil), buf[:n]...)
+			resp[2] |= 0x80
+			resp[7] = 1
+			self := len(resp)
+			resp = append(resp, 0xC0|byte(self>>8), byte(self), 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	s := &Server{
+		log:            logger.NewNop(),
+		upstreamClient: &dns.Client{Timeout: time.Second},
+		upstreams:      newUpstreamSelector(conn.LocalAddr().String(), config.PrivacyConfig{}),
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	resp, _, err := s.forward(q)
+	if resp != nil || !errors.Is(err, errMalformedResponse) {
+		t.Fatalf("Expected the looping answer to be dropped, got %v, %v", resp, err)
+	}
+	if got := fmt.Sprint(err); got != "malformed upstream response: forward_pointer" {
+		t.Errorf("Unexpected error %q", got)
+	}
+}
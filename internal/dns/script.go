@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/detector"
+	"ddd/internal/firewall"
+	"ddd/internal/journal"
+	"ddd/internal/metrics"
+	"ddd/internal/policy"
+	"ddd/internal/script"
+)
+
+var (
+	scriptActions = metrics.NewCounterVec("ddd_script_actions_total",
+		"Actions taken on a policy script's say, by hook and action", "hook", "action")
+	scriptErrors = metrics.NewCounterVec("ddd_script_errors_total",
+		"Policy script hook failures, by hook and reason (steps, timeout, result, error)", "hook", "reason")
+)
+
+// scriptRequestActions are the results on_request may return: the
+// firewall's actions
+var scriptRequestActions = map[string]bool{
+	"allow": true, "rate_limit": true, "refuse": true,
+	"nxdomain": true, "drop": true, "block": true,
+}
+
+// scriptVerdictActions are the results on_verdict may return
+var scriptVerdictActions = map[string]bool{
+	string(policy.Allow): true, string(policy.RateLimit): true, string(policy.Block): true,
+}
+
+// applyScript runs the policy script's on_request hook for a query no
+// firewall rule matched. Its result is applied like a firewall action.
+func (s *Server) applyScript(w dns.ResponseWriter, r *dns.Msg, clientIP, domain, qtype string) (handled, skipDetection bool) {
+	if !s.opts.Script.Has(script.OnRequest) {
+		return false, false
+	}
+	name, err := s.opts.Script.Request(scriptRequest(w, r, clientIP, domain, qtype), scriptRequestActions)
+	if err != nil {
+		s.scriptFailed(script.OnRequest, clientIP, err)
+		return false, false
+	}
+	action, ok := firewall.ParseAction(name)
+	if !ok {
+		return false, false
+	}
+	scriptActions.With(script.OnRequest, name).Inc()
+	s.log.Debugw("Policy script acted on request", "ip", clientIP, "domain", domain, "action", name)
+	return s.applyAction(w, r, clientIP, domain, qtype, action, journal.SourceScript, "policy script")
+}
+
+// scriptVerdict runs the policy script's on_verdict hook for a detected
+// attack. It returns the decision the script made, or "" to mitigate as
+// the detector and policy hook would.
+func (s *Server) scriptVerdict(w dns.ResponseWriter, r *dns.Msg, clientIP, domain, qtype string, result *detector.DetectionResult) policy.Decision {
+	if !s.opts.Script.Has(script.OnVerdict) {
+		return ""
+	}
+	verdict := script.Verdict{
+		AttackType:  result.AttackType,
+		Severity:    result.Severity.String(),
+		Description: result.Description,
+		Domain:      result.Domain,
+		Block:       result.ShouldBlock,
+	}
+	if result.Evidence != nil {
+		verdict.Evidence = result.Evidence.Names
+	}
+	name, err := s.opts.Script.Verdict(scriptRequest(w, r, clientIP, domain, qtype), verdict, scriptVerdictActions)
+	if err != nil {
+		s.scriptFailed(script.OnVerdict, clientIP, err)
+		return ""
+	}
+	if name != "" {
+		scriptActions.With(script.OnVerdict, name).Inc()
+		s.log.Debugw("Policy script overrode verdict", "ip", clientIP, "attack_type", result.AttackType, "decision", name)
+	}
+	return policy.Decision(name)
+}
+
+// scriptFailed counts and logs a hook failure. Failures leave the query
+// to the built-in handling.
+func (s *Server) scriptFailed(hook, clientIP string, err error) {
+	reason := "error"
+	switch {
+	case errors.Is(err, script.ErrStepLimit):
+		reason = "steps"
+	case errors.Is(err, script.ErrTimeout):
+		reason = "timeout"
+	case errors.Is(err, script.ErrResult):
+		reason = "result"
+	}
+	scriptErrors.With(hook, reason).Inc()
+	s.log.Debugw("Policy script failed", "hook", hook, "ip", clientIP, "error", err)
+}
+
+// scriptRequest describes a query to the policy script
+func scriptRequest(w dns.ResponseWriter, r *dns.Msg, clientIP, domain, qtype string) script.Request {
+	req := script.Request{
+		Client:    clientIP,
+		QName:     strings.ToLower(domain),
+		QType:     qtype,
+		Transport: "udp",
+		RD:        r.RecursionDesired,
+		CD:        r.CheckingDisabled,
+	}
+	switch addr := w.RemoteAddr().(type) {
+	case *encryptedAddr:
+		req.Transport = "tls"
+		req.Fingerprint = addr.Fingerprint
+	case *net.TCPAddr:
+		req.Transport = "tcp"
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		req.DO = opt.Do()
+		for _, o := range opt.Option {
+			req.EDNSOptions = append(req.EDNSOptions, int(o.Option()))
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				req.ECS = fmt.Sprintf("%s/%d", ecs.Address, ecs.SourceNetmask)
+			}
+		}
+	}
+	return req
+}
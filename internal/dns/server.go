@@ -1,28 +1,230 @@
 package dns
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 	"ddd/internal/blocker"
+	"ddd/internal/cache"
+	"ddd/internal/capture"
+	"ddd/internal/config"
+	"ddd/internal/dataset"
 	"ddd/internal/detector"
+	"ddd/internal/dualstack"
+	"ddd/internal/events"
+	"ddd/internal/firewall"
+	"ddd/internal/geoip"
+	"ddd/internal/groups"
+	"ddd/internal/integrity"
+	"ddd/internal/journal"
+	"ddd/internal/killswitch"
 	"ddd/internal/logger"
+	"ddd/internal/metrics"
+	"ddd/internal/mirror"
+	"ddd/internal/mobility"
 	"ddd/internal/monitor"
+	"ddd/internal/policy"
+	"ddd/internal/popularity"
+	"ddd/internal/recursor"
+	"ddd/internal/rewrite"
+	"ddd/internal/script"
+	"ddd/internal/upstream"
+	"ddd/internal/watch"
+	"ddd/internal/zones"
 )
 
+var (
+	detections = metrics.NewCounterVec("ddd_detections_total",
+		"Attacks detected, by attack type and severity", "attack_type", "severity")
+	upstreamFailures = metrics.NewCounterVec("ddd_upstream_failures_total",
+		"Forwarded queries charged to the client's rate budget, by outcome (servfail, refused, error)", "rcode")
+)
+
+// Options holds tunables and optional components for the DNS server
+type Options struct {
+	// ReadBufferSize is the requested SO_RCVBUF in bytes (0 keeps the OS default)
+	ReadBufferSize int
+	// MaxInFlight caps concurrently handled requests; datagrams beyond it
+	// are dropped and counted as userspace drops (0 means unlimited)
+	MaxInFlight int
+	// BatchSize is the number of datagrams read/written per recvmmsg/sendmmsg
+	// call; values below 2 use the standard one-syscall-per-packet path
+	BatchSize int
+	// FlattenCNAMEs resolves CNAME chains on behalf of clients and returns
+	// only the final records
+	FlattenCNAMEs bool
+	// MaxCNAMEChain is the longest CNAME chain accepted from upstream;
+	// longer chains and loops are counted as upstream anomalies
+	MaxCNAMEChain int
+	// Critical lists queries that are always forwarded, even when the
+	// server is shedding load
+	Critical []config.CriticalQuery
+	// Rewriter rewrites upstream answers before they are returned (optional)
+	Rewriter *rewrite.Engine
+	// Cache stores upstream answers (optional)
+	Cache *cache.Cache
+	// NXDomainPatterns answers NXDOMAIN for a whole registered domain
+	// during random subdomain floods
+	NXDomainPatterns config.NXDomainPatternConfig
+	// Events receives attacks aimed at particular zones (optional)
+	Events *events.Bus
+	// Policy decides borderline detections externally (optional)
+	Policy *policy.Hook
+	// Integrity watches upstream answers for hijack/poisoning (optional)
+	Integrity *integrity.Watcher
+	// Chaos injects upstream faults for resilience testing
+	Chaos config.ChaosConfig
+	// Privacy spreads queries across several upstreams instead of the
+	// one the server was created with
+	Privacy config.PrivacyConfig
+	// VerdictTTL is how long a blocked client's refusal is cached so
+	// further packets skip logging and analysis (0 disables the cache)
+	VerdictTTL time.Duration
+	// VerdictDropAfter switches a cached refusal to a silent drop after
+	// this many hits within one TTL (0 always refuses)
+	VerdictDropAfter int
+	// DuplicateWindow is how long after an answer an identical query (same
+	// client, ID, name and type) is answered from it rather than forwarded
+	// again; retransmissions of in-flight queries always wait for the
+	// original (0 disables suppression)
+	DuplicateWindow time.Duration
+	// PairQTypes resolves the other address type along with the A or
+	// AAAA query of a client that asks for both, and lets its second
+	// query wait for that resolution instead of forwarding its own
+	PairQTypes bool
+	// TCP also serves DNS over TCP on the same port
+	TCP bool
+	// TrustedProxies lists load balancers (CIDRs or addresses) whose
+	// connections to any TCP, DoT or DoH listener start with a PROXY v2
+	// header carrying the real client
+	TrustedProxies []string
+	// PaddingBlockSize pads responses to clients the proxy reports as
+	// connected over TLS to a multiple of this many bytes, when their
+	// query was padded (0 disables padding)
+	PaddingBlockSize int
+	// MaxEDNSOptions is the most EDNS options a query may carry before it
+	// is rejected as protocol abuse (0 means unlimited)
+	MaxEDNSOptions int
+	// MaxRRSet is the most records an RRset in an upstream response may
+	// hold before the response is dropped as malformed (0 means unlimited)
+	MaxRRSet int
+	// FlaggedMinTTL raises the TTLs of answers to rate limited clients to
+	// at least this, so flagged clients re-query less (0 disables it)
+	FlaggedMinTTL time.Duration
+	// Transparent accepts queries redirected by a TPROXY rule and answers
+	// from the resolver address the client originally queried (Linux only;
+	// disables batching)
+	Transparent bool
+	// Geo counts queries and attacks by client location (optional)
+	Geo *geoip.Heatmap
+	// Firewall applies operator rules to each query before attack
+	// detection (optional)
+	Firewall *firewall.Engine
+	// Script runs operator policy hooks for queries no firewall rule
+	// matched and for detected attacks (optional)
+	Script *script.Program
+	// Capture records datagrams from sources under packet capture
+	// (optional)
+	Capture *capture.Recorder
+	// Panic is the operator's kill switch; while engaged the strictest
+	// profile applies (optional)
+	Panic *killswitch.Switch
+	// Mobility keys soft penalties to the client fingerprint behind an
+	// address and shortens them in dynamic ranges (optional)
+	Mobility *mobility.Buckets
+	// DualStack links the IPv4 and IPv6 addresses of one client so blocks
+	// follow it across address families (optional)
+	DualStack *dualstack.Correlator
+	// Zones holds queries to the zones of the authoritative server behind
+	// the proxy and to their budgets (optional)
+	Zones *zones.Guard
+	// TransferPeers are the primaries and secondaries of those zones,
+	// whose NOTIFY and AXFR/IXFR are relayed instead of refused
+	TransferPeers []netip.Prefix
+	// MinimalResponses strips authority and additional records answers
+	// do not need
+	MinimalResponses bool
+	// Dataset exports detection decisions and client features for
+	// offline model training (optional)
+	Dataset *dataset.Exporter
+	// Mirror copies a share of queries to a test instance (optional)
+	Mirror *mirror.Mirror
+	// Journal records mitigation decisions in a hash-chained file
+	// (optional)
+	Journal *journal.Journal
+	// Watch tracks resolution health of the domains vital services
+	// depend on (optional)
+	Watch *watch.List
+	// Recursor resolves critical queries from the root hints once every
+	// upstream has been failing for EmergencyAfter (optional)
+	Recursor       *recursor.Resolver
+	EmergencyAfter time.Duration
+	// Popularity ranks queried names; popular names are refreshed before
+	// their cached answer expires and still answered from cache while
+	// shedding load (optional)
+	Popularity *popularity.Tracker
+	// PrefetchBefore is how close to expiry a popular cached answer is
+	// refreshed (0 disables prefetching)
+	PrefetchBefore time.Duration
+	// Summary replaces per-query log entries with one line per client per
+	// interval (optional)
+	Summary *logger.Summary
+	// TCPAbuse limits connection floods and pipelined query floods over
+	// TCP apart from UDP detection
+	TCPAbuse config.TCPAbuseConfig
+	// Groups give clients their group's detector thresholds and
+	// mitigation policy (optional)
+	Groups *groups.Set
+	// Shed is how queries beyond MaxInFlight are answered
+	Shed config.ShedConfig
+	// LocalZone is the SOA of synthesized NXDOMAIN answers
+	LocalZone config.LocalZoneConfig
+	// Cost weighs clients' queries for cost-based rate budgets; nothing
+	// is charged unless it is enabled
+	Cost config.CostConfig
+	// Listeners serve DNS on further addresses and transports
+	Listeners []config.ListenerConfig
+}
+
 // Server is the DNS server with DDoS protection
 type Server struct {
 	port            int
 	upstreamDNS     string
-	server          *dns.Server
+	opts            Options
+	listeners       *listenerManager
+	udp             *udpListener // on port
 	trafficMonitor  *monitor.TrafficMonitor
 	ddosDetector    *detector.DDoSDetector
 	ipBlocker       *blocker.IPBlocker
 	log             *logger.Logger
 	upstreamClient  *dns.Client
+	upstreams       *upstreamSelector
+	upstreamHealth  *upstream.Tracker
+	critical        *criticalClassifier
+	chaos           *chaosInjector
+	verdicts        *verdictCache
+	duplicates      *dupSuppressor
+	pairs           *qtypePairer
+	nxPatterns      *nxPatternCache
+	emergency       *emergencyFallback
+	tcpGuard        *tcpGuard
+	shedBudget      *shedBudget
+	prefetching     sync.Map // cache.Key -> struct{}, refreshes in progress
+	journaled       journaledDecisions
+	listening       chan struct{} // closed once every listener is open
+
+	queries        atomic.Uint64
+	inFlight       atomic.Int64
+	userDrops      atomic.Uint64
+	cnameAnomalies atomic.Uint64
+	criticalBypass atomic.Uint64
 }
 
 // NewServer creates a new DNS server
@@ -33,10 +235,12 @@ func NewServer(
 	ddosDetector *detector.DDoSDetector,
 	ipBlocker *blocker.IPBlocker,
 	log *logger.Logger,
+	opts Options,
 ) *Server {
 	s := &Server{
 		port:           port,
 		upstreamDNS:    upstreamDNS,
+		opts:           opts,
 		trafficMonitor: trafficMonitor,
 		ddosDetector:   ddosDetector,
 		ipBlocker:      ipBlocker,
@@ -44,107 +248,439 @@ func NewServer(
 		upstreamClient: &dns.Client{
 			Timeout: 5 * time.Second,
 		},
+		critical:  newCriticalClassifier(opts.Critical),
+		listening: make(chan struct{}),
+	}
+	s.upstreams = newUpstreamSelector(upstreamDNS, opts.Privacy)
+	s.upstreamHealth = upstream.NewTracker(s.upstreams.upstreams)
+	s.chaos = newChaosInjector(opts.Chaos, s.upstreamClient.Timeout)
+	s.verdicts = newVerdictCache(opts.VerdictTTL, opts.VerdictDropAfter)
+	s.duplicates = newDupSuppressor(opts.DuplicateWindow)
+	if opts.PairQTypes {
+		s.pairs = newQTypePairer()
 	}
+	s.nxPatterns = newNXPatternCache(opts.NXDomainPatterns)
+	s.emergency = newEmergencyFallback(opts.Recursor, opts.EmergencyAfter, opts.Events)
+	s.tcpGuard = newTCPGuard(opts.TCPAbuse, opts.Journal, log)
+	s.shedBudget = newShedBudget(opts.Shed.ErrorBudget)
 
-	// Create DNS server
-	s.server = &dns.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Net:     "udp",
-		Handler: dns.HandlerFunc(s.handleDNSRequest),
+	// UDP on port, TCP with it, then any further listeners
+	address := fmt.Sprintf(":%d", port)
+	s.listeners = newListenerManager(log)
+	s.udp = s.newUDPListener(address, opts.Transparent)
+	s.listeners.add(s.udp)
+	if opts.TCP {
+		s.listeners.add(s.newStreamListener(config.ListenerConfig{Protocol: config.ProtocolTCP, Listen: address}, opts.TrustedProxies))
+	}
+	for _, l := range opts.Listeners {
+		switch l.Protocol {
+		case config.ProtocolUDP:
+			s.listeners.add(s.newUDPListener(l.Listen, false))
+		case config.ProtocolTCP, config.ProtocolDoT:
+			s.listeners.add(s.newStreamListener(l, opts.TrustedProxies))
+		case config.ProtocolDoH:
+			s.listeners.add(s.newDoHListener(l, opts.TrustedProxies))
+		case config.ProtocolDoQ:
+			s.listeners.add(s.newDoQListener(l))
+		}
 	}
 
 	return s
 }
 
-// Start starts the DNS server
+// Start opens every listener and serves until all of them have stopped.
+// It fails if any listener cannot be opened.
 func (s *Server) Start() error {
-	s.log.Info("DNS server listening", "port", s.port)
-	return s.server.ListenAndServe()
+	if err := s.listeners.startAll(); err != nil {
+		return err
+	}
+	close(s.listening)
+
+	var names []string
+	for _, l := range s.listeners.status() {
+		names = append(names, l.Name)
+	}
+	s.log.Infow("DNS server listening",
+		"port", s.port,
+		"read_buffer", s.opts.ReadBufferSize,
+		"max_in_flight", s.opts.MaxInFlight,
+		"batch_size", s.opts.BatchSize,
+		"transparent", s.opts.Transparent,
+		"tcp", s.opts.TCP,
+		"trusted_proxies", len(s.opts.TrustedProxies),
+		"listeners", names,
+	)
+	s.listeners.wait()
+	return nil
+}
+
+// Listening is closed once Start has opened every listener
+func (s *Server) Listening() <-chan struct{} {
+	return s.listening
 }
 
 // Stop stops the DNS server
 func (s *Server) Stop() error {
-	return s.server.Shutdown()
+	return s.listeners.stopAll()
 }
 
 // handleDNSRequest handles incoming DNS requests
 func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	w = s.paddedWriter(w, r)
+
 	// Extract client IP
 	clientIP := s.extractClientIP(w.RemoteAddr())
+	w = s.costWriter(w, clientIP)
 
-	// Check if IP is blocked
+	// Queries pipelined on one TCP connection faster than its limit close
+	// the connection unanswered
+	if !s.tcpGuard.query(w.RemoteAddr(), start) {
+		s.opts.Summary.Record(clientIP, "", "tcp_abuse")
+		return
+	}
+
+	critical := len(r.Question) > 0 && s.critical.isCritical(r.Question[0])
+
+	// Shed load when too many requests are already waiting on upstream.
+	// Critical queries are always forwarded.
+	inFlight := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	if s.opts.MaxInFlight > 0 && inFlight > int64(s.opts.MaxInFlight) {
+		if !critical {
+			if s.answerPopular(w, r, clientIP) {
+				s.opts.Summary.Record(clientIP, "", "popular")
+			} else {
+				s.userDrops.Add(1)
+				s.shed(w, r)
+				s.opts.Summary.Record(clientIP, "", "shed")
+			}
+			return
+		}
+		s.criticalBypass.Add(1)
+	}
+
+	// Check if IP is blocked. Most packets from blocked sources are
+	// filtered before decoding; this catches clients blocked while their
+	// packets were already queued.
 	if s.ipBlocker.IsBlocked(clientIP) {
-		s.log.Info("Blocked IP attempted request", "ip", clientIP)
+		if s.opts.Summary == nil {
+			s.log.Info("Blocked IP attempted request", "ip", clientIP)
+		}
+		s.verdicts.refuse(clientIP, time.Now())
 		s.sendRefused(w, r)
+		s.finish(clientIP, "", latencyRefused, start)
+		return
+	}
+	s.opts.Geo.RecordQuery(clientIP)
+
+	// Panic mode: a global cap and known clients only
+	if s.panicGate(w, r, clientIP, critical) {
+		s.finish(clientIP, "", latencyRefused, start)
+		return
+	}
+
+	// Operator-imposed query rate caps
+	if !critical && s.overManualLimit(w, r, clientIP) {
+		s.finish(clientIP, "", latencyRefused, start)
 		return
 	}
 
+	// Operator exemptions skip rate limiting and attack detection until
+	// they expire
+	exempt := s.ipBlocker.IsExempt(clientIP, start)
+
 	// Check if IP is rate limited
-	if s.ipBlocker.IsRateLimited(clientIP) {
-		s.log.Info("Rate limited IP request", "ip", clientIP)
-		// Still process but with delay
+	if !critical && !exempt && s.isRateLimited(clientIP, r) {
+		if s.opts.Summary == nil {
+			s.log.Info("Rate limited IP request", "ip", clientIP)
+		}
+		// Still process but with delay. The deliberate delay is left out
+		// of the query latency metrics.
 		time.Sleep(500 * time.Millisecond)
+		start = time.Now()
+	}
+
+	// Reject unsupported opcodes, multiple questions and EDNS abuse
+	if kind, rcode := protocolAbuse(r, s.opts.MaxEDNSOptions); kind != "" {
+		s.rejectProtocolAbuse(w, r, clientIP, kind, rcode)
+		s.finish(clientIP, "", latencyRefused, start)
+		return
 	}
 
 	// Extract query information
 	if len(r.Question) == 0 {
 		s.sendRefused(w, r)
+		s.finish(clientIP, "", latencyRefused, start)
 		return
 	}
 
 	question := r.Question[0]
 	domain := strings.TrimSuffix(question.Name, ".")
-	qtype := dns.TypeToString[question.Qtype]
+	qtype := qtypeName(question.Qtype)
+	queriesByType.With(qtypeLabel(question.Qtype)).Inc()
+
+	// Zone transfers and NOTIFY are only valid between the authoritative
+	// server and its transfer peers
+	if kind := zoneTransferKind(r); kind != "" {
+		s.trafficMonitor.RecordRequest(clientIP, domain, qtype)
+		if s.transferPeer(clientIP) {
+			s.relayZoneTransfer(w, r, kind)
+		} else {
+			s.refuseZoneTransfer(w, r, clientIP, kind)
+		}
+		s.finish(clientIP, domain, latencyRefused, start)
+		return
+	}
 
-	// Record the request
+	// Record the request, and copy it to the mirror now that it has
+	// passed the checks that refuse a client outright
 	s.trafficMonitor.RecordRequest(clientIP, domain, qtype)
-	s.log.LogDNSQuery(clientIP, domain, qtype)
+	s.opts.Mirror.Offer(r)
+	s.observeDualStack(w, r, clientIP, domain)
+	// Summary logging counts the query once it has been handled
+	if s.opts.Summary == nil {
+		if dst := originalDestination(w.RemoteAddr()); dst != "" {
+			s.log.LogTransparentDNSQuery(clientIP, dst, domain, qtype)
+		} else {
+			s.log.LogDNSQuery(clientIP, domain, qtype)
+		}
+	}
+
+	// Zones of the authoritative server behind the proxy
+	if s.zoneGate(w, r, clientIP, domain, critical) {
+		s.finish(clientIP, domain, latencyRefused, start)
+		return
+	}
 
-	// Analyze traffic for DDoS patterns
-	detectionResult := s.ddosDetector.AnalyzeTraffic(clientIP, s.trafficMonitor)
+	// Operator firewall rules
+	handled, allowed := s.applyFirewall(w, r, clientIP, domain, qtype)
+	if handled {
+		s.finish(clientIP, domain, latencyRefused, start)
+		return
+	}
+	allowed = allowed || exempt
+
+	// Analyze traffic for DDoS patterns, with the thresholds of the
+	// client's group
+	ddosDetector, group := s.detectorFor(clientIP, clientFingerprint(w.RemoteAddr()))
+	detectionResult := &detector.DetectionResult{}
+	if !allowed {
+		detectionResult = ddosDetector.AnalyzeClient(s.detectionClient(clientIP, r), s.trafficMonitor)
+	}
+	if advice := detectionResult.Advice; advice != nil {
+		s.opts.Events.Publish(events.Event{
+			Type:   events.SearchDomainStorm,
+			IP:     clientIP,
+			Domain: advice.Domain,
+			Reason: advice.Description,
+		})
+	}
 
 	if detectionResult.IsAttack {
-		s.log.Warnw("Attack detected",
+		s.countDetection(clientIP, group, detectionResult)
+		if detectionResult.Domain != "" {
+			s.opts.Events.Publish(events.Event{
+				Type:   events.DomainAttacked,
+				IP:     clientIP,
+				Domain: detectionResult.Domain,
+				Reason: detectionResult.AttackType,
+			})
+		}
+		s.log.Warnw("Attack detected", groupFields(group, fingerprintFields(w.RemoteAddr(),
 			"ip", clientIP,
 			"attack_type", detectionResult.AttackType,
-			"severity", detectionResult.Severity,
-		)
+			"severity", detectionResult.Severity.String(),
+		)...)...)
 
-		// Apply mitigation
-		if detectionResult.ShouldBlock {
-			s.ipBlocker.BlockIP(clientIP, detectionResult.AttackType)
+		// Apply mitigation. The policy script has the first say; blocks
+		// the detector is sure of come next, and borderline detections
+		// are rate limited unless the decision service says otherwise.
+		// The client's group policy caps the outcome.
+		decision := s.scriptVerdict(w, r, clientIP, domain, qtype, detectionResult)
+		decidedBy := "script"
+		if decision == "" {
+			decidedBy = s.decidedBy(detectionResult)
+		}
+		if decision == "" && detectionResult.ShouldBlock {
+			decision = policy.Block
+		}
+		if decision == "" {
+			request := policy.Request{
+				ClientIP:    clientIP,
+				AttackType:  detectionResult.AttackType,
+				Severity:    detectionResult.Severity,
+				Description: detectionResult.Description,
+				Domain:      domain,
+				QueryType:   qtype,
+			}
+			if detectionResult.Evidence != nil {
+				request.Evidence = detectionResult.Evidence.Names
+			}
+			decision = s.opts.Policy.Decide(request, policy.RateLimit)
+		}
+		if capped := groupDecision(group, decision); capped != decision {
+			decision, decidedBy = capped, "group"
+		}
+		s.journalDetection(journal.SourceDetection, clientIP, domain, qtype, ddosDetector, group,
+			detectionResult, s.clientInputs(clientIP), decision, decidedBy)
+		s.exportSample(clientIP, domain, qtype, detectionResult, decision)
+		switch decision {
+		case policy.Block:
+			s.ipBlocker.BlockIPWithSeverity(clientIP, detectionResult.AttackType, detectionResult.Severity)
 			s.sendRefused(w, r)
+			s.finish(clientIP, domain, latencyBlocked, start)
 			return
-		} else {
-			// Just rate limit
-			s.ipBlocker.RateLimitIP(clientIP)
+		case policy.RateLimit:
+			s.rateLimit(clientIP, r)
 		}
+	} else if !allowed {
+		s.exportSample(clientIP, domain, qtype, detectionResult, "")
+	}
+
+	w = s.ttlFloorWriter(w, r, clientIP)
+
+	// Answer from cache when possible
+	s.opts.Popularity.Record(domain)
+	s.pairQType(r, clientIP, domain, critical)
+	if cached := s.opts.Cache.Get(r); cached != nil {
+		if err := w.WriteMsg(cachedReply(w, r, cached)); err != nil {
+			s.log.Errorw("Error writing response", "error", err)
+		}
+		s.prefetch(r, domain, cached)
+		s.finish(clientIP, domain, latencyCache, start)
+		return
+	}
+
+	// Names under a domain being flooded with random subdomains
+	if nx, ok := s.nxPatterns.answer(r, domain, time.Now()); ok {
+		s.writeResponse(w, r, nx)
+		s.finish(clientIP, domain, latencyCache, start)
+		return
+	}
+
+	// Panic mode answers from cache only
+	if s.cacheOnly(critical) {
+		panicRejections.With("cache_miss").Inc()
+		s.sendServerFailure(w, r)
+		s.finish(clientIP, domain, latencyRefused, start)
+		return
 	}
 
 	// Forward request to upstream DNS server
-	s.forwardRequest(w, r)
+	s.forwardRequest(w, r, clientIP, domain)
+	s.finish(clientIP, domain, latencyForwarded, start)
 }
 
-// forwardRequest forwards the DNS request to upstream server
-func (s *Server) forwardRequest(w dns.ResponseWriter, r *dns.Msg) {
+// forwardRequest forwards the DNS request to upstream server. A client
+// retransmitting a query that is still in flight, or was just answered,
+// is given that answer instead of a second upstream exchange, as is an
+// address query paired with one already being resolved.
+func (s *Server) forwardRequest(w dns.ResponseWriter, r *dns.Msg, clientIP, domain string) {
+	if s.duplicates == nil {
+		resp := s.resolvePaired(r, domain)
+		s.recordFailure(clientIP, r, resp)
+		s.writeResponse(w, r, resp)
+		return
+	}
+
+	f, first := s.duplicates.begin(dupKey(clientIP, r))
+	if !first {
+		duplicatesSuppressed.Inc()
+		s.writeResponse(w, r, f.wait(s.upstreamClient.Timeout))
+		return
+	}
+
+	resp := s.resolvePaired(r, domain)
+	s.duplicates.finish(f, resp)
+	s.recordFailure(clientIP, r, resp)
+	s.writeResponse(w, r, resp)
+}
+
+// recordFailure charges the client behind query r for an upstream
+// exchange that failed or was refused, shrinking its rate budget
+func (s *Server) recordFailure(clientIP string, r, resp *dns.Msg) {
+	rcode := "servfail"
+	switch {
+	case resp == nil:
+		rcode = "error"
+	case resp.Rcode == dns.RcodeRefused:
+		rcode = "refused"
+	case resp.Rcode != dns.RcodeServerFailure:
+		return
+	}
+	upstreamFailures.With(rcode).Inc()
+	s.trafficMonitor.RecordClientFailure(clientIP, s.opts.Mobility.Fingerprint(r))
+}
+
+// resolve queries upstream and post-processes the answer. It returns nil
+// when upstream could not be reached.
+func (s *Server) resolve(r *dns.Msg, domain string) *dns.Msg {
 	// Query upstream DNS
-	resp, _, err := s.upstreamClient.Exchange(r, s.upstreamDNS)
+	start := time.Now()
+	resp, upstream, err := s.exchangeOrRecurse(r)
+	s.watchResolution(domain, resp, err, start)
 	if err != nil {
 		s.log.Errorw("Error querying upstream DNS",
 			"error", err,
-			"upstream", s.upstreamDNS,
+			"upstream", upstream,
 		)
+		return nil
+	}
+
+	if upstream != recursionUpstream {
+		s.opts.Integrity.Observe(domain, resp)
+	}
+
+	if s.checkCNAMEChain(domain, resp) && s.opts.FlattenCNAMEs {
+		resp = s.flattenCNAMEs(resp)
+	}
+
+	if changed := s.opts.Rewriter.Apply(resp); changed > 0 {
+		s.log.Debugw("Rewrote upstream answer", "records", changed)
+	}
+	if s.opts.MinimalResponses {
+		minimizeResponse(resp)
+	}
+
+	s.opts.Cache.Set(resp)
+	if base := s.nxPatterns.observe(domain, resp, time.Now()); base != "" {
+		s.log.Warnw("Wildcard NXDOMAIN pattern armed",
+			"pattern", "*."+base,
+			"ttl", s.opts.NXDomainPatterns.TTL,
+			"event", "nxdomain_pattern",
+		)
+		s.opts.Events.Publish(events.Event{
+			Type:   events.DomainAttacked,
+			Domain: base,
+			Reason: "nxdomain_flood",
+		})
+	}
+	return resp
+}
+
+// writeResponse sends resp to the client, or SERVFAIL when it is nil
+func (s *Server) writeResponse(w dns.ResponseWriter, r *dns.Msg, resp *dns.Msg) {
+	if resp == nil {
 		s.sendServerFailure(w, r)
 		return
 	}
 
 	// Send response back to client
-	if err != nil {
-    s.log. Errorw("Error writing response", "error", err)
-    return  // Exit to prevent sending multiple responses
+	if err := w.WriteMsg(resp); err != nil {
+		s.log.Errorw("Error writing response", "error", err)
 	}
 }
 
+// countDetection records a detection in the metrics and the geo heat map
+func (s *Server) countDetection(clientIP string, group *groups.Group, result *detector.DetectionResult) {
+	detections.With(result.AttackType, result.Severity.String()).Inc()
+	if group != nil {
+		groupDetections.With(group.Name, group.Tenant).Inc()
+	}
+	s.opts.Geo.RecordAttack(clientIP)
+}
+
 // sendRefused sends a REFUSED response
 func (s *Server) sendRefused(w dns.ResponseWriter, r *dns.Msg) {
 	m := new(dns.Msg)
@@ -166,8 +702,12 @@ func (s *Server) extractClientIP(addr net.Addr) string {
 	switch v := addr.(type) {
 	case *net.UDPAddr:
 		return v.IP.String()
+	case *transparentAddr:
+		return v.Client.IP.String()
 	case *net.TCPAddr:
 		return v.IP.String()
+	case *encryptedAddr:
+		return v.Client.IP.String()
 	default:
 		// Fallback: try to parse string representation
 		host, _, err := net.SplitHostPort(addr.String())
@@ -177,3 +717,58 @@ func (s *Server) extractClientIP(addr net.Addr) string {
 		return host
 	}
 }
+
+// QueryCount returns the number of queries received since startup
+func (s *Server) QueryCount() uint64 {
+	return s.queries.Load()
+}
+
+// GetSocketStats returns kernel and userspace drop counters for the listener
+func (s *Server) GetSocketStats() (SocketStats, error) {
+	stats := SocketStats{}
+	conn, batch := s.udp.socket()
+	if conn != nil {
+		kernel, err := readSocketStats(conn)
+		if err != nil {
+			return stats, err
+		}
+		stats = kernel
+	}
+
+	stats.UserDrops = s.userDrops.Load()
+	stats.InFlight = s.inFlight.Load()
+	stats.CriticalBypass = s.criticalBypass.Load()
+	if batch != nil {
+		stats.WriteErrors = batch.writeErrors.Load()
+	}
+	return stats, nil
+}
+
+// StartStatsReporter periodically logs socket drop counters so operators can
+// tell whether packets are lost in the kernel or in userspace during an attack
+func (s *Server) StartStatsReporter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last SocketStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := s.GetSocketStats()
+			if err != nil {
+				s.log.Debugw("Unable to read socket stats", "error", err)
+			}
+
+			s.log.LogSocketStats(
+				stats.KernelDrops-last.KernelDrops,
+				stats.UserDrops-last.UserDrops,
+				stats.RxQueueBytes,
+				stats.ReadBufferSize,
+				stats.InFlight,
+			)
+			last = stats
+		}
+	}
+}
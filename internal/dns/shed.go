@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/metrics"
+)
+
+var shedResponses = metrics.NewCounterVec("ddd_shed_responses_total",
+	"Queries shed under load, by how they were answered (refused, servfail, dropped)", "response")
+
+// shedBudget caps the error responses sent while shedding load per
+// second, so answering shed queries stays cheaper than resolving them
+// and cannot be used for reflection. A nil budget is unlimited.
+type shedBudget struct {
+	limit int
+
+	mu     sync.Mutex
+	second int64
+	used   int
+}
+
+// newShedBudget returns nil for a limit of 0
+func newShedBudget(limit int) *shedBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &shedBudget{limit: limit}
+}
+
+// take reports whether another error response fits this second's budget
+func (b *shedBudget) take(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sec := now.Unix(); sec != b.second {
+		b.second, b.used = sec, 0
+	}
+	if b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// shed answers a query the server has no capacity for and returns how it
+// was answered. Dropping it makes every stub retransmit once its timeout
+// expires, and a bare SERVFAIL is retried at once by musl and makes
+// systemd-resolved downgrade and resend. REFUSED is final for resolved,
+// costs glibc no more than a drop and is ignored by musl, which resends
+// as if dropped. The Not Ready
+// extended error tells resolvers that read it to back off for
+// RetryAfter.
+func (s *Server) shed(w dns.ResponseWriter, r *dns.Msg) string {
+	var rcode int
+	switch s.opts.Shed.Response {
+	case config.ShedRefused:
+		rcode = dns.RcodeRefused
+	case config.ShedServfail:
+		rcode = dns.RcodeServerFailure
+	default:
+		shedResponses.With("dropped").Inc()
+		return "dropped"
+	}
+	if !s.shedBudget.take(time.Now()) {
+		shedResponses.With("dropped").Inc()
+		return "dropped"
+	}
+
+	w.WriteMsg(shedReply(r, rcode, s.opts.Shed.RetryAfter))
+	shedResponses.With(s.opts.Shed.Response).Inc()
+	return s.opts.Shed.Response
+}
+
+// shedReply builds the error response to a shed query. The extended error
+// is only added for clients that sent EDNS, as RFC 6891 requires.
+func shedReply(r *dns.Msg, rcode int, retryAfter time.Duration) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(max(opt.UDPSize(), dns.MinMsgSize), false)
+		ede := &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeNotReady, ExtraText: "server overloaded"}
+		if retryAfter > 0 {
+			ede.ExtraText = fmt.Sprintf("server overloaded, retry after %s", retryAfter)
+		}
+		m.IsEdns0().Option = append(m.IsEdns0().Option, ede)
+	}
+	return m
+}
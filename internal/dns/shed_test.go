@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+// stubRetries models how many more times a stub resolver with this server
+// as its only nameserver sends a query after getting resp (nil when the
+// query went unanswered), following the retry logic of its sources, cited
+// per stub below. Responses that do not match the query are ignored like
+// timeouts.
+type stubRetries func(q, resp *dns.Msg) int
+
+var stubs = map[string]stubRetries{
+	// musl 1.2.5, src/network/res_msend.c, __res_msend_rc: replies are
+	// only accepted with rcode 0 or 3 ("retry immediately on server
+	// failure, and ignore all other codes such as refusal"). SERVFAIL is
+	// resent at once while servfail_retry (2 * nameservers) lasts, then
+	// ignored. Ignored and unanswered queries are resent every
+	// retry_interval, timeout/attempts: 2.5s of the default 5s with 2
+	// attempts (src/network/resolvconf.c), so once.
+	"musl": func(q, resp *dns.Msg) int {
+		if matches(q, resp) && resp.Rcode == dns.RcodeServerFailure {
+			return 3
+		}
+		if matches(q, resp) && (resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError) {
+			return 0
+		}
+		return 1
+	},
+	// glibc 2.39, resolv/res_send.c: send_dg treats SERVFAIL, NOTIMP and
+	// REFUSED like a timeout and goes to next_ns, and __res_context_send
+	// tries each nameserver statp->retry times, RES_DFLRETRY (2) by
+	// default (resolv/resolv.h)
+	"glibc": func(q, resp *dns.Msg) int {
+		if !matches(q, resp) {
+			return 1
+		}
+		switch resp.Rcode {
+		case dns.RcodeServerFailure, dns.RcodeNotImplemented, dns.RcodeRefused:
+			return 1
+		}
+		return 0
+	},
+	// systemd-resolved v255, src/resolve/resolved-dns-transaction.c,
+	// dns_transaction_process_reply: FORMERR, SERVFAIL and NOTIMP lower
+	// the feature level by one and resend to the same server, from EDNS0
+	// with DO through EDNS0 to plain UDP, where the rcode is accepted once
+	// every server has been tried. REFUSED goes through
+	// dns_transaction_limited_retry, which only switches to a server not
+	// yet tried, so with one server it is final. Timeouts are resent with
+	// backoff up to DNS_TRANSACTION_ATTEMPTS_MAX, so 3 is a lower bound.
+	"systemd-resolved": func(q, resp *dns.Msg) int {
+		switch {
+		case !matches(q, resp):
+			return 3
+		case resp.Rcode == dns.RcodeServerFailure:
+			return 2
+		}
+		return 0
+	},
+}
+
+// matches reports whether resp answers q as stubs require: same ID and
+// question
+func matches(q, resp *dns.Msg) bool {
+	return resp != nil && resp.Response && resp.Id == q.Id &&
+		len(resp.Question) == 1 && resp.Question[0] == q.Question[0]
+}
+
+// shedResponse returns what the server sends for a query it sheds
+func shedResponse(t *testing.T, s *Server, q *dns.Msg) *dns.Msg {
+	t.Helper()
+	rec := &recordingWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}}
+	s.shed(rec, q)
+	if rec.msg == nil {
+		return nil
+	}
+	// Round trip through the wire format, as a stub would see it
+	wire, err := rec.msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(wire); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestShedResponsesLimitStubRetries(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(1232, false)
+
+	retries := func(response string) map[string]int {
+		s := &Server{opts: Options{Shed: config.ShedConfig{Response: response, RetryAfter: 5 * time.Second}}}
+		resp := shedResponse(t, s, q)
+		out := make(map[string]int)
+		for name, stub := range stubs {
+			out[name] = stub(q, resp)
+		}
+		return out
+	}
+
+	refused, servfail, dropped := retries(config.ShedRefused), retries(config.ShedServfail), retries(config.ShedDrop)
+	for name := range stubs {
+		if refused[name] > servfail[name] || refused[name] > dropped[name] {
+			t.Errorf("%s: expected REFUSED to cause the fewest retries, got refused %d, servfail %d, dropped %d",
+				name, refused[name], servfail[name], dropped[name])
+		}
+	}
+	if refused["systemd-resolved"] != 0 {
+		t.Errorf("Expected REFUSED final for systemd-resolved, got %v", refused)
+	}
+	if servfail["musl"] <= dropped["musl"] {
+		t.Errorf("Expected SERVFAIL to cost musl more retries than a drop, got %v and %v", servfail, dropped)
+	}
+}
+
+func TestShedReplyExtendedError(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	s := &Server{opts: Options{Shed: config.ShedConfig{Response: config.ShedRefused, RetryAfter: 5 * time.Second}}}
+
+	if resp := shedResponse(t, s, q); resp.Rcode != dns.RcodeRefused || resp.IsEdns0() != nil {
+		t.Errorf("Expected a plain REFUSED to a client without EDNS, got %v", resp)
+	}
+
+	q.SetEdns0(1232, false)
+	resp := shedResponse(t, s, q)
+	opt := resp.IsEdns0()
+	if opt == nil || len(opt.Option) != 1 {
+		t.Fatalf("Expected an extended error for an EDNS client, got %v", resp)
+	}
+	ede, ok := opt.Option[0].(*dns.EDNS0_EDE)
+	if !ok || ede.InfoCode != dns.ExtendedErrorCodeNotReady || ede.ExtraText != "server overloaded, retry after 5s" {
+		t.Errorf("Expected Not Ready with a retry hint, got %v", opt.Option[0])
+	}
+}
+
+func TestShedErrorBudget(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	s := &Server{
+		opts:       Options{Shed: config.ShedConfig{Response: config.ShedRefused}},
+		shedBudget: newShedBudget(2),
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := s.shed(&recordingWriter{}, q); got != config.ShedRefused {
+			t.Fatalf("Expected query %d within the budget refused, got %s", i, got)
+		}
+	}
+	if got := s.shed(&recordingWriter{}, q); got != "dropped" {
+		t.Errorf("Expected queries beyond the budget dropped, got %s", got)
+	}
+}
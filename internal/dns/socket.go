@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/upgrade"
+)
+
+// SocketStats holds counters for the UDP listening socket
+type SocketStats struct {
+	KernelDrops    uint64 // datagrams dropped by the kernel (socket buffer full)
+	RxQueueBytes   uint64 // bytes currently queued in the socket receive buffer
+	ReadBufferSize int    // effective SO_RCVBUF as reported by the kernel
+	UserDrops      uint64 // datagrams dropped by us because too many were in flight
+	InFlight       int64  // requests currently being handled
+	WriteErrors    uint64 // responses lost because a batched send failed
+	CriticalBypass uint64 // critical queries forwarded despite overload
+}
+
+// listenUDP opens the UDP listening socket and applies the configured
+// receive buffer size
+func listenUDP(address string, readBuffer int) (*net.UDPConn, error) {
+	pc, err := upgrade.ListenPacket(&net.ListenConfig{}, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+
+	if readBuffer > 0 {
+		if err := setReadBuffer(conn, readBuffer); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("setting receive buffer to %d bytes: %w", readBuffer, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// udpListener serves DNS over UDP. Datagrams from blocked sources are
+// filtered before decoding; reads and writes are batched and, for the
+// server's own port, may be intercepted transparently.
+type udpListener struct {
+	s           *Server
+	address     string
+	transparent bool
+	handler     dns.Handler
+
+	mu    sync.Mutex
+	conn  *net.UDPConn
+	batch *batchConn
+	run   *serverRun
+}
+
+// newUDPListener creates a UDP listener on address
+func (s *Server) newUDPListener(address string, transparent bool) *udpListener {
+	return &udpListener{
+		s:           s,
+		address:     address,
+		transparent: transparent,
+		handler:     s.protocolHandler(config.ProtocolUDP),
+	}
+}
+
+// Name identifies the listener
+func (l *udpListener) Name() string {
+	return config.ProtocolUDP + "@" + l.address
+}
+
+// Protocol returns udp
+func (l *udpListener) Protocol() string {
+	return config.ProtocolUDP
+}
+
+// Listen opens the socket
+func (l *udpListener) Listen() error {
+	opts := l.s.opts
+	var conn *net.UDPConn
+	var pc net.PacketConn
+	if l.transparent {
+		c, tconn, err := listenTransparent(l.address, opts.ReadBufferSize)
+		if err != nil {
+			return err
+		}
+		conn, pc = c, tconn
+	} else {
+		c, err := listenUDP(l.address, opts.ReadBufferSize)
+		if err != nil {
+			return err
+		}
+		conn, pc = c, c
+	}
+
+	var batch *batchConn
+	if opts.BatchSize > 1 && !l.transparent {
+		batch = newBatchConn(conn, opts.BatchSize)
+		pc = batch
+	}
+
+	// Blocked sources are filtered before any DNS decoding
+	pc = &filterConn{PacketConn: pc, s: l.s}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conn, l.batch = conn, batch
+	l.run = newServerRun(&dns.Server{
+		Net:           "udp",
+		PacketConn:    pc,
+		Handler:       l.handler,
+		MsgAcceptFunc: acceptMessage,
+	})
+	return nil
+}
+
+// Serve answers queries until Shutdown
+func (l *udpListener) Serve() error {
+	l.mu.Lock()
+	run := l.run
+	l.mu.Unlock()
+	return run.serve()
+}
+
+// Shutdown closes the socket
+func (l *udpListener) Shutdown() error {
+	l.mu.Lock()
+	run := l.run
+	l.mu.Unlock()
+	err := run.shutdown()
+	upgrade.Release("udp", l.address)
+	return err
+}
+
+// socket returns the open socket and its batching wrapper, if any
+func (l *udpListener) socket() (*net.UDPConn, *batchConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conn, l.batch
+}
@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// setReadBuffer sets SO_RCVBUF on the socket. SO_RCVBUFFORCE is tried first
+// so that, when running with CAP_NET_ADMIN, net.core.rmem_max does not
+// silently cap the requested size.
+func setReadBuffer(conn *net.UDPConn, size int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, size) == nil {
+			return
+		}
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF, size)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// readSocketStats reads the kernel counters for the socket from
+// /proc/net/udp and /proc/net/udp6, matching our socket by inode
+func readSocketStats(conn *net.UDPConn) (SocketStats, error) {
+	var stats SocketStats
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return stats, err
+	}
+
+	var inode string
+	var ctrlErr error
+	err = raw.Control(func(fd uintptr) {
+		stats.ReadBufferSize, ctrlErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		if ctrlErr != nil {
+			return
+		}
+
+		var link string
+		link, ctrlErr = os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+		inode = strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+	})
+	if err != nil {
+		return stats, err
+	}
+	if ctrlErr != nil {
+		return stats, ctrlErr
+	}
+
+	for _, path := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		found, err := scanProcNetUDP(path, inode, &stats)
+		if err != nil {
+			return stats, err
+		}
+		if found {
+			return stats, nil
+		}
+	}
+
+	return stats, fmt.Errorf("socket inode %s not found in /proc/net/udp", inode)
+}
+
+// scanProcNetUDP looks for the socket with the given inode in a
+// /proc/net/udp style table and fills in its queue and drop counters
+func scanProcNetUDP(path, inode string, stats *SocketStats) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+
+	for scanner.Scan() {
+		// sl local rem st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ref pointer drops
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 || fields[9] != inode {
+			continue
+		}
+
+		if queues := strings.SplitN(fields[4], ":", 2); len(queues) == 2 {
+			stats.RxQueueBytes, _ = strconv.ParseUint(queues[1], 16, 64)
+		}
+		stats.KernelDrops, err = strconv.ParseUint(fields[12], 10, 64)
+		return true, err
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package dns
+
+import (
+	"errors"
+	"net"
+)
+
+// setReadBuffer sets the socket receive buffer size
+func setReadBuffer(conn *net.UDPConn, size int) error {
+	return conn.SetReadBuffer(size)
+}
+
+// readSocketStats is only implemented on Linux, where the kernel exposes
+// per-socket drop counters
+func readSocketStats(conn *net.UDPConn) (SocketStats, error) {
+	return SocketStats{}, errors.New("kernel socket statistics are not supported on this platform")
+}
@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/upgrade"
+)
+
+// streamListener serves DNS over TCP or, with a certificate, over TLS
+// (RFC 7858). Connections pass the TCP abuse guard; on the server's own
+// TCP port, trusted proxies' connections carry a PROXY v2 header first.
+type streamListener struct {
+	s        *Server
+	protocol string
+	address  string
+	cfg      config.ListenerConfig // certificate, for DoT
+	trusted  []string
+	handler  dns.Handler
+
+	mu  sync.Mutex
+	run *serverRun
+}
+
+// newStreamListener creates a TCP or DoT listener for cfg
+func (s *Server) newStreamListener(cfg config.ListenerConfig, trusted []string) *streamListener {
+	return &streamListener{
+		s:        s,
+		protocol: cfg.Protocol,
+		address:  cfg.Listen,
+		cfg:      cfg,
+		trusted:  trusted,
+		handler:  s.protocolHandler(cfg.Protocol),
+	}
+}
+
+// Name identifies the listener
+func (l *streamListener) Name() string {
+	return l.protocol + "@" + l.address
+}
+
+// Protocol returns tcp or dot
+func (l *streamListener) Protocol() string {
+	return l.protocol
+}
+
+// Listen opens the socket and, for DoT, loads the certificate
+func (l *streamListener) Listen() error {
+	trusted, err := parseCIDRs(l.trusted)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if l.protocol == config.ProtocolDoT {
+		if tlsConfig, err = loadTLSConfig(l.cfg, "dot"); err != nil {
+			return err
+		}
+	}
+
+	listener, err := upgrade.Listen("tcp", l.address)
+	if err != nil {
+		return err
+	}
+	if len(trusted) > 0 {
+		listener = &proxyListener{Listener: listener, trusted: trusted}
+	}
+	if l.s.tcpGuard != nil {
+		listener = &guardListener{Listener: listener, guard: l.s.tcpGuard}
+	}
+	netName := "tcp"
+	if tlsConfig != nil {
+		listener = tls.NewListener(&encryptedListener{Listener: listener}, tlsConfig)
+		netName = "tcp-tls"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.run = newServerRun(&dns.Server{
+		Net:           netName,
+		Listener:      listener,
+		Handler:       l.handler,
+		MsgAcceptFunc: acceptMessage,
+		MaxTCPQueries: l.s.opts.TCPAbuse.MaxQueriesPerConn,
+	})
+	return nil
+}
+
+// Serve answers queries until Shutdown
+func (l *streamListener) Serve() error {
+	l.mu.Lock()
+	run := l.run
+	l.mu.Unlock()
+	return run.serve()
+}
+
+// Shutdown closes the socket
+func (l *streamListener) Shutdown() error {
+	l.mu.Lock()
+	run := l.run
+	l.mu.Unlock()
+	err := run.shutdown()
+	upgrade.Release("tcp", l.address)
+	return err
+}
+
+// loadTLSConfig builds the server TLS configuration from a listener's
+// certificate file and key, offering the ALPN protocols given. Clients'
+// fingerprints are recorded on connections from an encryptedListener.
+func loadTLSConfig(cfg config.ListenerConfig, protos ...string) (*tls.Config, error) {
+	certPEM, err := os.ReadFile(cfg.TLSCert)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, []byte(cfg.TLSKey.Value()))
+	if err != nil {
+		return nil, err
+	}
+	return fingerprintTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   protos,
+	}), nil
+}
+
+// encryptedListener marks the clients of the connections it accepts as
+// on an encrypted transport, for response padding and policy scripts
+type encryptedListener struct {
+	net.Listener
+}
+
+// Accept returns the next connection
+func (l *encryptedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedConn{Conn: conn}, nil
+}
+
+// encryptedConn reports its client as an *encryptedAddr, with its TLS
+// fingerprint
+type encryptedConn struct {
+	net.Conn
+	fingerprint fingerprintValue
+}
+
+// RemoteAddr returns the client address
+func (c *encryptedConn) RemoteAddr() net.Addr {
+	if addr, ok := c.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		return &encryptedAddr{Client: addr, Fingerprint: c.fingerprint.Load()}
+	}
+	return c.Conn.RemoteAddr()
+}
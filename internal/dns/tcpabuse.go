@@ -0,0 +1,255 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/journal"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var tcpAbuse = metrics.NewCounterVec("ddd_tcp_abuse_total",
+	"TCP connections closed for abuse, by kind (connection_rate, concurrent, pipeline, refused)", "kind")
+
+// errTCPRefused is returned when reading from a connection the TCP guard
+// closed
+var errTCPRefused = errors.New("tcp: connection refused for abuse")
+
+// maxTCPClients bounds the clients the TCP guard tracks; idle entries are
+// pruned beyond it
+const maxTCPClients = 65536
+
+// tcpGuard limits TCP abuse apart from the UDP-oriented detector: floods
+// of connections from one client, too many connections held open at once,
+// and queries pipelined on one connection faster than a resolver sends
+// them. A client that crosses a limit has its connection closed and every
+// new TCP connection refused for a while; its UDP queries are left to the
+// detector. A nil guard limits nothing.
+type tcpGuard struct {
+	cfg     config.TCPAbuseConfig
+	journal *journal.Journal
+	log     *logger.Logger
+
+	mu      sync.Mutex
+	clients map[string]*tcpClient
+	conns   map[string]*tcpConnState // remote address -> connection
+}
+
+// tcpClient is one client's TCP activity
+type tcpClient struct {
+	open         int
+	windowStart  time.Time // of the one minute connection rate window
+	accepted     int
+	refusedUntil time.Time
+}
+
+// tcpConnState is the query rate on one connection
+type tcpConnState struct {
+	ip          string
+	windowStart time.Time // of the one second pipelining window
+	queries     int
+	conn        net.Conn
+}
+
+// newTCPGuard returns nil when no TCP abuse limit is configured
+func newTCPGuard(cfg config.TCPAbuseConfig, j *journal.Journal, log *logger.Logger) *tcpGuard {
+	if cfg.MaxConnections <= 0 && cfg.ConnectionRate <= 0 && cfg.PipelineRate <= 0 {
+		return nil
+	}
+	return &tcpGuard{
+		cfg:     cfg,
+		journal: j,
+		log:     log,
+		clients: make(map[string]*tcpClient),
+		conns:   make(map[string]*tcpConnState),
+	}
+}
+
+// open registers a new connection from the client behind remote, and
+// reports why it must be closed ("" to keep it)
+func (g *tcpGuard) open(conn net.Conn, remote net.Addr, now time.Time) string {
+	ip := tcpClientIP(remote)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.clients) >= maxTCPClients {
+		g.pruneLocked(now)
+	}
+	c := g.clients[ip]
+	if c == nil {
+		c = &tcpClient{windowStart: now}
+		g.clients[ip] = c
+	}
+	if now.Before(c.refusedUntil) {
+		return "refused"
+	}
+
+	if now.Sub(c.windowStart) >= time.Minute {
+		c.windowStart, c.accepted = now, 0
+	}
+	c.accepted++
+	switch {
+	case g.cfg.ConnectionRate > 0 && c.accepted > g.cfg.ConnectionRate:
+		g.refuseLocked(ip, c, "connection_rate", c.accepted, now)
+		return "connection_rate"
+	case g.cfg.MaxConnections > 0 && c.open >= g.cfg.MaxConnections:
+		g.refuseLocked(ip, c, "concurrent", c.open+1, now)
+		return "concurrent"
+	}
+
+	c.open++
+	g.conns[remote.String()] = &tcpConnState{ip: ip, windowStart: now, conn: conn}
+	return ""
+}
+
+// close unregisters a connection
+func (g *tcpGuard) close(remote net.Addr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	state, ok := g.conns[remote.String()]
+	if !ok {
+		return
+	}
+	delete(g.conns, remote.String())
+	if c := g.clients[state.ip]; c != nil && c.open > 0 {
+		c.open--
+	}
+}
+
+// query counts a query received on the connection from remote, and
+// reports whether it may be answered. Past the pipelining rate the
+// connection is closed and the client refused.
+func (g *tcpGuard) query(remote net.Addr, now time.Time) bool {
+	if g == nil || g.cfg.PipelineRate <= 0 || remote.Network() != "tcp" {
+		return true
+	}
+
+	g.mu.Lock()
+	state, ok := g.conns[remote.String()]
+	if !ok {
+		g.mu.Unlock()
+		return true
+	}
+	if now.Sub(state.windowStart) >= time.Second {
+		state.windowStart, state.queries = now, 0
+	}
+	state.queries++
+	if state.queries <= g.cfg.PipelineRate {
+		g.mu.Unlock()
+		return true
+	}
+	if c := g.clients[state.ip]; c != nil {
+		g.refuseLocked(state.ip, c, "pipeline", state.queries, now)
+	}
+	conn := state.conn
+	g.mu.Unlock()
+
+	conn.Close()
+	return false
+}
+
+// refuseLocked refuses the client's TCP connections for the configured
+// time, counting and logging the abuse. Connections it already has open
+// are left to finish or time out, except the offending one.
+func (g *tcpGuard) refuseLocked(ip string, c *tcpClient, kind string, count int, now time.Time) {
+	c.refusedUntil = now.Add(g.cfg.RefuseFor)
+	tcpAbuse.With(kind).Inc()
+	g.log.LogTCPAbuse(ip, kind, count, g.cfg.RefuseFor)
+	g.journal.Record(journal.Entry{
+		Time:        now,
+		Client:      ip,
+		Source:      journal.SourceTCPAbuse,
+		AttackType:  "tcp_" + kind,
+		Description: "TCP connections refused for " + g.cfg.RefuseFor.String(),
+		Inputs:      map[string]float64{kind: float64(count)},
+		Thresholds: map[string]float64{
+			"connection_rate":    float64(g.cfg.ConnectionRate),
+			"max_connections":    float64(g.cfg.MaxConnections),
+			"pipeline_rate":      float64(g.cfg.PipelineRate),
+			"refuse_for_seconds": g.cfg.RefuseFor.Seconds(),
+		},
+		Decision:  "refuse",
+		DecidedBy: "tcp_guard",
+	})
+}
+
+// pruneLocked forgets clients with no open connections and no refusal or
+// connection rate window in force
+func (g *tcpGuard) pruneLocked(now time.Time) {
+	for ip, c := range g.clients {
+		if c.open == 0 && !now.Before(c.refusedUntil) && now.Sub(c.windowStart) >= time.Minute {
+			delete(g.clients, ip)
+		}
+	}
+}
+
+// tcpClientIP returns the IP of a TCP client address
+func tcpClientIP(addr net.Addr) string {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return v.IP.String()
+	case *encryptedAddr:
+		return v.Client.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// guardListener hands accepted connections to the TCP guard
+type guardListener struct {
+	net.Listener
+	guard *tcpGuard
+}
+
+// Accept returns the next connection. The guard sees it on first read,
+// once a trusted proxy's header has named the real client.
+func (l *guardListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &guardConn{Conn: conn, guard: l.guard}, nil
+}
+
+// guardConn is a TCP connection watched by the guard
+type guardConn struct {
+	net.Conn
+	guard *tcpGuard
+
+	once      sync.Once
+	refused   bool
+	closeOnce sync.Once
+}
+
+// Read registers the connection on first use, and fails once the guard
+// refused it
+func (c *guardConn) Read(b []byte) (int, error) {
+	c.once.Do(func() {
+		if kind := c.guard.open(c, c.Conn.RemoteAddr(), time.Now()); kind != "" {
+			if kind == "refused" {
+				tcpAbuse.With(kind).Inc()
+			}
+			c.refused = true
+			c.Conn.Close()
+		}
+	})
+	if c.refused {
+		return 0, errTCPRefused
+	}
+	return c.Conn.Read(b)
+}
+
+// Close unregisters the connection and closes it
+func (c *guardConn) Close() error {
+	c.closeOnce.Do(func() { c.guard.close(c.Conn.RemoteAddr()) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+	"ddd/internal/logger/loggertest"
+)
+
+// stubConn is a connection from a fixed remote address
+type stubConn struct {
+	net.Conn
+	remote net.Addr
+	closed bool
+}
+
+func (c *stubConn) RemoteAddr() net.Addr { return c.remote }
+func (c *stubConn) Close() error         { c.closed = true; return nil }
+
+func tcpRemote(ip string, port int) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func TestTCPGuardConnectionRate(t *testing.T) {
+	g := newTCPGuard(config.TCPAbuseConfig{ConnectionRate: 3, RefuseFor: time.Minute}, nil, logger.NewNop())
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 1000+i), now); kind != "" {
+			t.Fatalf("Expected connection %d accepted, got %q", i, kind)
+		}
+	}
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 2000), now); kind != "connection_rate" {
+		t.Errorf("Expected the fourth connection in a minute closed, got %q", kind)
+	}
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 2001), now.Add(2*time.Second)); kind != "refused" {
+		t.Errorf("Expected the client refused after the flood, got %q", kind)
+	}
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.5", 1000), now); kind != "" {
+		t.Errorf("Expected other clients unaffected, got %q", kind)
+	}
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 2002), now.Add(2*time.Minute)); kind != "" {
+		t.Errorf("Expected the client accepted once the refusal expired, got %q", kind)
+	}
+}
+
+func TestTCPGuardConcurrentConnections(t *testing.T) {
+	g := newTCPGuard(config.TCPAbuseConfig{MaxConnections: 2}, nil, logger.NewNop())
+	now := time.Now()
+
+	first, second := tcpRemote("198.51.100.4", 1000), tcpRemote("198.51.100.4", 1001)
+	g.open(&stubConn{}, first, now)
+	g.open(&stubConn{}, second, now)
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 1002), now); kind != "concurrent" {
+		t.Errorf("Expected a third open connection closed, got %q", kind)
+	}
+
+	g.close(first)
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 1003), now); kind != "" {
+		t.Errorf("Expected a slot freed by the closed connection, got %q", kind)
+	}
+}
+
+func TestTCPGuardPipelining(t *testing.T) {
+	log, rec := loggertest.New(t)
+	g := newTCPGuard(config.TCPAbuseConfig{PipelineRate: 2, RefuseFor: time.Minute}, nil, log)
+	now := time.Now()
+
+	remote := tcpRemote("198.51.100.4", 1000)
+	conn := &stubConn{remote: remote}
+	g.open(conn, remote, now)
+
+	if !g.query(remote, now) || !g.query(remote, now) {
+		t.Fatal("Expected queries within the pipelining rate answered")
+	}
+	if g.query(remote, now) || !conn.closed {
+		t.Error("Expected the connection closed past the pipelining rate")
+	}
+	if kind := g.open(&stubConn{}, tcpRemote("198.51.100.4", 1001), now); kind != "refused" {
+		t.Errorf("Expected new connections refused after pipelining abuse, got %q", kind)
+	}
+	if events := rec.Events("tcp_abuse"); len(events) != 1 || events[0].ContextMap()["kind"] != "pipeline" {
+		t.Errorf("Expected one pipeline abuse logged, got %v", events)
+	}
+
+	if !g.query(&net.UDPAddr{IP: net.ParseIP("198.51.100.4"), Port: 1000}, now) {
+		t.Error("Expected UDP queries left to detection")
+	}
+}
@@ -0,0 +1,30 @@
+package dns
+
+import "net"
+
+// transparentAddr is the remote address of a datagram received in
+// transparent (TPROXY) mode. It carries the destination the client
+// originally addressed so the reply can be sent from it.
+type transparentAddr struct {
+	Client  *net.UDPAddr
+	OrigDst *net.UDPAddr
+}
+
+// Network returns the address network
+func (a *transparentAddr) Network() string {
+	return "udp"
+}
+
+// String returns the client address
+func (a *transparentAddr) String() string {
+	return a.Client.String()
+}
+
+// originalDestination returns the resolver IP a transparently intercepted
+// client addressed, or "" outside transparent mode
+func originalDestination(addr net.Addr) string {
+	if t, ok := addr.(*transparentAddr); ok && t.OrigDst != nil {
+		return t.OrigDst.IP.String()
+	}
+	return ""
+}
@@ -0,0 +1,241 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ddd/internal/upgrade"
+)
+
+// maxReplySockets bounds the cached per-destination reply sockets
+const maxReplySockets = 1024
+
+// transparentConn receives datagrams redirected by an iptables/nftables
+// TPROXY rule and answers them from the address the client originally
+// queried, so clients keep their configured resolver.
+type transparentConn struct {
+	*net.UDPConn
+	bind func(origDst *net.UDPAddr) (net.PacketConn, error)
+
+	mu      sync.Mutex
+	replies map[string]*replySocket // original destination -> reply socket
+}
+
+// replySocket is a cached reply socket. A socket evicted while replies are
+// being sent on it is closed once the last of them is.
+type replySocket struct {
+	pc       net.PacketConn
+	users    int // replies being sent
+	lastUsed time.Time
+	evicted  bool
+}
+
+// listenTransparent opens the listening socket with IP_TRANSPARENT and
+// original destination reporting enabled. It requires CAP_NET_ADMIN.
+func listenTransparent(address string, readBuffer int) (*net.UDPConn, net.PacketConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = setTransparent(int(fd), true)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+
+	pc, err := upgrade.ListenPacket(&lc, "udp", address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening transparent listener: %w", err)
+	}
+	conn := pc.(*net.UDPConn)
+
+	if readBuffer > 0 {
+		if err := setReadBuffer(conn, readBuffer); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("setting receive buffer to %d bytes: %w", readBuffer, err)
+		}
+	}
+
+	return conn, &transparentConn{UDPConn: conn, bind: bindReplySocket, replies: make(map[string]*replySocket)}, nil
+}
+
+// setTransparent enables IP_TRANSPARENT on fd and, for listeners, asks the
+// kernel to report each datagram's original destination. The IPv6 options
+// are best effort because they do not apply to IPv4-only sockets.
+func setTransparent(fd int, listener bool) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		return fmt.Errorf("enabling IP_TRANSPARENT (needs CAP_NET_ADMIN): %w", err)
+	}
+	unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+
+	if !listener {
+		return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1); err != nil {
+		return fmt.Errorf("enabling IP_RECVORIGDSTADDR: %w", err)
+	}
+	unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+	return nil
+}
+
+// ReadFrom reads a datagram and returns its client and original destination
+func (c *transparentConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	oob := make([]byte, 128)
+	n, oobn, _, client, err := c.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	origDst, err := parseOrigDst(oob[:oobn])
+	if err != nil || origDst == nil {
+		// Not redirected by TPROXY; treat it as addressed to us
+		return n, client, nil
+	}
+	return n, &transparentAddr{Client: client, OrigDst: origDst}, nil
+}
+
+// WriteTo sends b to the client, from the original destination when the
+// query was intercepted
+func (c *transparentConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	t, ok := addr.(*transparentAddr)
+	if !ok {
+		return c.UDPConn.WriteTo(b, addr)
+	}
+
+	reply, err := c.acquireReply(t.OrigDst)
+	if err != nil {
+		return 0, err
+	}
+	defer c.releaseReply(reply)
+	return reply.pc.WriteTo(b, t.Client)
+}
+
+// acquireReply returns the socket bound to the (non-local) original
+// destination, creating it on first use. The socket stays open until
+// releaseReply. When the cache is full, the least recently used socket is
+// evicted.
+func (c *transparentConn) acquireReply(origDst *net.UDPAddr) (*replySocket, error) {
+	key := origDst.String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.replies[key]
+	if !ok {
+		if len(c.replies) >= maxReplySockets {
+			c.evictOldestLocked()
+		}
+		pc, err := c.bind(origDst)
+		if err != nil {
+			return nil, fmt.Errorf("binding reply socket to %s: %w", key, err)
+		}
+		r = &replySocket{pc: pc}
+		c.replies[key] = r
+	}
+	r.users++
+	r.lastUsed = time.Now()
+	return r, nil
+}
+
+// releaseReply ends a reply sent on r, closing it if it was evicted
+// meanwhile
+func (c *transparentConn) releaseReply(r *replySocket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.users--
+	if r.evicted && r.users == 0 {
+		r.pc.Close()
+	}
+}
+
+// bindReplySocket opens a socket bound to the original destination
+func bindReplySocket(origDst *net.UDPAddr) (net.PacketConn, error) {
+	network := "udp6"
+	if origDst.IP.To4() != nil {
+		network = "udp4"
+	}
+
+	lc := net.ListenConfig{Control: func(network, address string, rc syscall.RawConn) error {
+		var sockErr error
+		err := rc.Control(func(fd uintptr) {
+			sockErr = setTransparent(int(fd), false)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	return lc.ListenPacket(context.Background(), network, origDst.String())
+}
+
+// evictOldestLocked evicts the least recently used reply socket
+func (c *transparentConn) evictOldestLocked() {
+	var oldest string
+	for key, r := range c.replies {
+		if oldest == "" || r.lastUsed.Before(c.replies[oldest].lastUsed) {
+			oldest = key
+		}
+	}
+	if oldest != "" {
+		c.evictLocked(oldest)
+	}
+}
+
+// evictLocked drops the reply socket for key from the cache, closing it
+// unless a reply is being sent on it
+func (c *transparentConn) evictLocked(key string) {
+	r := c.replies[key]
+	delete(c.replies, key)
+	r.evicted = true
+	if r.users == 0 {
+		r.pc.Close()
+	}
+}
+
+// Close closes the reply sockets and the listener
+func (c *transparentConn) Close() error {
+	c.mu.Lock()
+	for key := range c.replies {
+		c.evictLocked(key)
+	}
+	c.mu.Unlock()
+	return c.UDPConn.Close()
+}
+
+// parseOrigDst extracts IP_ORIGDSTADDR/IPV6_ORIGDSTADDR from control
+// messages. The sockaddr port is in network byte order on every
+// architecture; the family field is not needed because the message type
+// already identifies it.
+func parseOrigDst(oob []byte) (*net.UDPAddr, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_ORIGDSTADDR && len(m.Data) >= 8:
+			return &net.UDPAddr{
+				IP:   net.IPv4(m.Data[4], m.Data[5], m.Data[6], m.Data[7]),
+				Port: int(binary.BigEndian.Uint16(m.Data[2:4])),
+			}, nil
+		case m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_ORIGDSTADDR && len(m.Data) >= 24:
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, m.Data[8:24])
+			return &net.UDPAddr{
+				IP:   ip,
+				Port: int(binary.BigEndian.Uint16(m.Data[2:4])),
+			}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// cmsg builds a single control message with the given payload
+func cmsg(level, typ int32, data []byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = level
+	h.Type = typ
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return b
+}
+
+func TestParseOrigDstIPv4(t *testing.T) {
+	// sockaddr_in: family, port 53 (network order), 192.0.2.53
+	data := []byte{unix.AF_INET, 0, 0, 53, 192, 0, 2, 53, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	addr, err := parseOrigDst(cmsg(unix.SOL_IP, unix.IP_ORIGDSTADDR, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr == nil || !addr.IP.Equal(net.ParseIP("192.0.2.53")) || addr.Port != 53 {
+		t.Errorf("Expected 192.0.2.53:53, got %v", addr)
+	}
+}
+
+func TestParseOrigDstMissing(t *testing.T) {
+	addr, err := parseOrigDst(nil)
+	if err != nil || addr != nil {
+		t.Errorf("Expected no original destination, got %v, %v", addr, err)
+	}
+}
+
+func TestReplySocketEvictionWaitsForWriters(t *testing.T) {
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Loopback sockets stand in for transparent ones
+	c := &transparentConn{
+		bind: func(*net.UDPAddr) (net.PacketConn, error) {
+			return net.ListenPacket("udp4", "127.0.0.1:0")
+		},
+		replies: make(map[string]*replySocket),
+	}
+	dst := &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+	r, err := c.acquireReply(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Evicted while a reply is in flight, the socket stays open for it
+	c.mu.Lock()
+	c.evictLocked(dst.String())
+	c.mu.Unlock()
+	if _, err := r.pc.WriteTo([]byte("reply"), client.LocalAddr()); err != nil {
+		t.Fatalf("Expected the in-flight reply sent, got %v", err)
+	}
+	c.releaseReply(r)
+	if _, err := r.pc.WriteTo([]byte("reply"), client.LocalAddr()); err == nil {
+		t.Error("Expected the evicted socket closed after its last reply")
+	}
+
+	// A full cache evicts only the least recently used socket
+	now := time.Now()
+	for i, key := range []string{"a", "b", "c"} {
+		pc, _ := c.bind(nil)
+		c.replies[key] = &replySocket{pc: pc, lastUsed: now.Add(time.Duration(i-1) * time.Second)}
+	}
+	c.mu.Lock()
+	c.evictOldestLocked()
+	c.mu.Unlock()
+	if _, ok := c.replies["a"]; ok || len(c.replies) != 2 {
+		t.Errorf("Expected only the oldest socket evicted, left %d", len(c.replies))
+	}
+	for key := range c.replies {
+		c.evictLocked(key)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dns
+
+import (
+	"errors"
+	"net"
+)
+
+// listenTransparent is only implemented on Linux, which provides TPROXY
+func listenTransparent(address string, readBuffer int) (*net.UDPConn, net.PacketConn, error) {
+	return nil, nil, errors.New("transparent mode is not supported on this platform")
+}
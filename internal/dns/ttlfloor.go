@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var ttlFloorResponses = metrics.NewCounter("ddd_ttl_floor_responses_total",
+	"Responses to rate limited clients whose record TTLs were raised to the floor")
+
+// ttlFloorWriter raises record TTLs to at least floor seconds, so a
+// flagged client re-queries less often and gets less fresh data
+type ttlFloorWriter struct {
+	dns.ResponseWriter
+	floor uint32
+}
+
+// ttlFloorWriter returns w wrapped to raise response TTLs when the client
+// is rate limited (suspicious but not blocked); otherwise w is returned
+// unchanged
+func (s *Server) ttlFloorWriter(w dns.ResponseWriter, r *dns.Msg, clientIP string) dns.ResponseWriter {
+	floor := uint32(s.opts.FlaggedMinTTL.Seconds())
+	if floor == 0 || !s.isRateLimited(clientIP, r) {
+		return w
+	}
+	return &ttlFloorWriter{ResponseWriter: w, floor: floor}
+}
+
+// WriteMsg raises the TTLs of a copy of m, which may be shared with the
+// cache, and writes it
+func (w *ttlFloorWriter) WriteMsg(m *dns.Msg) error {
+	m = m.Copy()
+	if raiseTTLs(m, w.floor) {
+		ttlFloorResponses.Inc()
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// raiseTTLs raises the TTLs of m's records to at least floor seconds and
+// reports whether any was raised
+func raiseTTLs(m *dns.Msg, floor uint32) bool {
+	raised := false
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype != dns.TypeOPT && hdr.Ttl < floor {
+				hdr.Ttl = floor
+				raised = true
+			}
+		}
+	}
+	return raised
+}
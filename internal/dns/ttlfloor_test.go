@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+)
+
+func TestTTLFloorForFlaggedClients(t *testing.T) {
+	s := &Server{
+		ipBlocker: blocker.NewIPBlocker(300, nil),
+		opts:      Options{FlaggedMinTTL: 5 * time.Minute},
+	}
+	s.ipBlocker.RateLimitIP("192.0.2.1")
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer,
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}, A: net.ParseIP("192.0.2.53")},
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600}, A: net.ParseIP("192.0.2.54")},
+	)
+	resp.SetEdns0(dns.DefaultMsgSize, false)
+
+	rec := &recordingWriter{}
+	s.ttlFloorWriter(rec, req, "192.0.2.1").WriteMsg(resp)
+	if ttl := rec.msg.Answer[0].Header().Ttl; ttl != 300 {
+		t.Errorf("Expected a short TTL raised to 300, got %d", ttl)
+	}
+	if ttl := rec.msg.Answer[1].Header().Ttl; ttl != 3600 {
+		t.Errorf("Expected a long TTL kept, got %d", ttl)
+	}
+	if opt := rec.msg.IsEdns0(); opt == nil || opt.Hdr.Ttl != 0 {
+		t.Error("Expected the OPT record left alone")
+	}
+	if resp.Answer[0].Header().Ttl != 30 {
+		t.Error("Expected the original response left unchanged")
+	}
+
+	if w := s.ttlFloorWriter(rec, req, "192.0.2.2"); w != dns.ResponseWriter(rec) {
+		t.Error("Expected responses to unflagged clients left alone")
+	}
+}
@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/metrics"
+	"ddd/internal/upstream"
+)
+
+var upstreamExchanges = metrics.NewCounterVec("ddd_upstream_exchanges_total",
+	"Upstream exchanges by resolver", "upstream")
+
+// upstreamSelector picks the resolver for each query. With privacy
+// rotation configured, queries are spread across several resolvers so
+// that none of them sees the complete query stream.
+type upstreamSelector struct {
+	upstreams  []string
+	hashLabels int  // hash the last hashLabels labels; 0 picks at random
+	failover   bool // retry a failed exchange on the next resolver
+	rand       func(n int) int
+}
+
+// newUpstreamSelector rotates across cfg.Upstreams, or always picks
+// primary when none are configured
+func newUpstreamSelector(primary string, cfg config.PrivacyConfig) *upstreamSelector {
+	u := &upstreamSelector{upstreams: []string{primary}, rand: rand.Intn}
+	if len(cfg.Upstreams) > 0 {
+		u.upstreams = cfg.Upstreams
+		u.hashLabels = cfg.HashLabels
+		u.failover = cfg.Failover
+	}
+	return u
+}
+
+// pick returns the index of the resolver for qname. Hashing sends every
+// query for a domain to the same resolver, so each sees all queries for
+// its share of domains and none for the rest.
+func (u *upstreamSelector) pick(qname string) int {
+	if len(u.upstreams) == 1 {
+		return 0
+	}
+	if u.hashLabels <= 0 {
+		return u.rand(len(u.upstreams))
+	}
+
+	labels := dns.SplitDomainName(strings.ToLower(qname))
+	if len(labels) > u.hashLabels {
+		labels = labels[len(labels)-u.hashLabels:]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(labels, ".")))
+	return int(h.Sum32() % uint32(len(u.upstreams)))
+}
+
+// forward sends r to the resolver picked for it and returns the answer and
+// the resolver that gave it. With failover, a failed exchange is retried
+// once on the next resolver. A SERVFAIL is only retried while the resolver
+// is answering SERVFAIL for many domains: otherwise the domain is broken,
+// and the next resolver would fail it too.
+func (s *Server) forward(r *dns.Msg) (*dns.Msg, string, error) {
+	var qname string
+	if len(r.Question) > 0 {
+		qname = r.Question[0].Name
+	}
+	i := s.available(s.upstreams.pick(qname))
+	addr := s.upstreams.upstreams[i]
+	resp, err := s.exchangeWith(r, addr)
+	if !s.upstreams.failover || err == nil && !s.retryServfail(resp, addr) {
+		return resp, addr, err
+	}
+
+	addr = s.upstreams.upstreams[s.available((i+1)%len(s.upstreams.upstreams))]
+	resp, err = s.exchangeWith(r, addr)
+	return resp, addr, err
+}
+
+// available returns the first resolver from index i on whose circuit
+// breaker lets a query through, or i when every breaker is open
+func (s *Server) available(i int) int {
+	n := len(s.upstreams.upstreams)
+	now := time.Now()
+	for k := 0; k < n; k++ {
+		j := (i + k) % n
+		if s.upstreamHealth.Allow(s.upstreams.upstreams[j], now) {
+			return j
+		}
+	}
+	return i
+}
+
+// exchangeWith sends r to addr and records the outcome in the resolver's
+// health. Malformed answers are dropped and logged, but not held against
+// the resolver: anyone able to spoof its address could send them.
+func (s *Server) exchangeWith(r *dns.Msg, addr string) (*dns.Msg, error) {
+	upstreamExchanges.With(addr).Inc()
+	resp, rtt, err := s.exchangeRaw(r, addr)
+
+	var qname string
+	if len(r.Question) > 0 {
+		qname = r.Question[0].Name
+	}
+
+	var malformed *malformedError
+	if errors.As(err, &malformed) {
+		malformedResponses.With(malformed.reason).Inc()
+		s.log.LogMalformedResponse(addr, qname, malformed.reason)
+		s.upstreamHealth.Observe(addr, qname, rtt, false, nil, time.Now())
+		return nil, err
+	}
+
+	servfail := resp != nil && resp.Rcode == dns.RcodeServerFailure
+	s.upstreamHealth.Observe(addr, qname, rtt, servfail, err, time.Now())
+	return resp, err
+}
+
+// retryServfail reports whether a SERVFAIL answer from addr is worth
+// retrying on another resolver
+func (s *Server) retryServfail(resp *dns.Msg, addr string) bool {
+	return resp != nil && resp.Rcode == dns.RcodeServerFailure && s.upstreamHealth.Servfailing(addr, time.Now())
+}
+
+// UpstreamStats returns the health of each upstream resolver
+func (s *Server) UpstreamStats() []upstream.Stats {
+	return s.upstreamHealth.Snapshot(time.Now())
+}
@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/upstream"
+)
+
+func TestUpstreamHashByDomain(t *testing.T) {
+	u := newUpstreamSelector("192.0.2.53:53", config.PrivacyConfig{
+		Upstreams:  []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"},
+		HashLabels: 2,
+	})
+
+	picked := make(map[int]bool)
+	for _, domain := range []string{"example.com.", "example.org.", "example.net.", "b.test.", "b.c.", "e.f."} {
+		i := u.pick(domain)
+		picked[i] = true
+		for _, name := range []string{"www." + domain, "A.random." + domain} {
+			if u.pick(name) != i {
+				t.Errorf("Expected %s to use the same upstream as %s", name, domain)
+			}
+		}
+	}
+	if len(picked) < 2 {
+		t.Error("Expected domains to be spread across upstreams")
+	}
+
+	if single := newUpstreamSelector("192.0.2.53:53", config.PrivacyConfig{HashLabels: 2}); single.pick("example.com.") != 0 || single.upstreams[0] != "192.0.2.53:53" {
+		t.Error("Expected the primary upstream without privacy rotation")
+	}
+}
+
+func TestUpstreamFailover(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})}
+	go live.ActivateAndServe()
+	defer live.Shutdown()
+
+	// Nothing listens here, so exchanges fail straight away
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.LocalAddr().String()
+	dead.Close()
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	for _, failover := range []bool{false, true} {
+		s := &Server{
+			upstreamClient: &dns.Client{Timeout: time.Second},
+			upstreams: newUpstreamSelector("", config.PrivacyConfig{
+				Upstreams: []string{deadAddr, conn.LocalAddr().String()},
+				Failover:  failover,
+			}),
+		}
+		s.upstreams.rand = func(int) int { return 0 }
+
+		resp, upstream, err := s.forward(q)
+		if failover && (err != nil || resp == nil || upstream != conn.LocalAddr().String()) {
+			t.Errorf("Expected failover to the live upstream, got %v from %q", err, upstream)
+		}
+		if !failover && err == nil {
+			t.Error("Expected the failed exchange to be returned without failover")
+		}
+	}
+}
+
+func TestServfailFailover(t *testing.T) {
+	serve := func(rcode int) (string, func()) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetRcode(r, rcode)
+			w.WriteMsg(m)
+		})}
+		go srv.ActivateAndServe()
+		return conn.LocalAddr().String(), func() { srv.Shutdown() }
+	}
+	failing, stop := serve(dns.RcodeServerFailure)
+	defer stop()
+	good, stop := serve(dns.RcodeSuccess)
+	defer stop()
+
+	s := &Server{
+		upstreamClient: &dns.Client{Timeout: time.Second},
+		upstreams: newUpstreamSelector("", config.PrivacyConfig{
+			Upstreams: []string{failing, good},
+			Failover:  true,
+		}),
+		upstreamHealth: upstream.NewTracker([]string{failing, good}),
+	}
+	s.upstreams.rand = func(int) int { return 0 }
+
+	// A SERVFAIL for one domain is the domain's problem: it is not
+	// retried elsewhere
+	q := new(dns.Msg)
+	q.SetQuestion("broken.example.", dns.TypeA)
+	if resp, addr, err := s.forward(q); err != nil || resp.Rcode != dns.RcodeServerFailure || addr != failing {
+		t.Fatalf("Expected the SERVFAIL returned as is, got %v from %s", err, addr)
+	}
+
+	// Once the resolver fails many domains, its SERVFAIL is retried
+	var addr string
+	for i := 0; i < 20 && addr != good; i++ {
+		q.SetQuestion(fmt.Sprintf("name%d.example.", i), dns.TypeA)
+		_, addr, _ = s.forward(q)
+	}
+	if addr != good {
+		t.Error("Expected SERVFAIL across many domains to fail over")
+	}
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/metrics"
+)
+
+var verdictHits = metrics.NewCounterVec("ddd_verdict_cache_hits_total",
+	"Requests answered from the verdict cache without logging or analysis", "verdict")
+
+// verdict is a cached decision for a client
+type verdict int
+
+const (
+	verdictNone verdict = iota
+	verdictRefuse
+	verdictDrop
+)
+
+// String returns the verdict name used in metrics
+func (v verdict) String() string {
+	switch v {
+	case verdictRefuse:
+		return "refuse"
+	case verdictDrop:
+		return "drop"
+	default:
+		return "none"
+	}
+}
+
+// maxVerdicts bounds the verdict cache. A flood from spoofed sources in a
+// blocked range brings a new client with nearly every packet; past the
+// bound new verdicts are not cached and those clients take the full path.
+const maxVerdicts = 1 << 17
+
+// verdictEntry is a cached decision and the number of times it was used
+type verdictEntry struct {
+	expires time.Time
+	hits    atomic.Uint64
+}
+
+// verdictCache remembers, for a short time, that a client is blocked so a
+// flood from it is refused without walking the full request path. Clients
+// that keep hammering after dropAfter refusals within one entry's lifetime
+// are dropped silently instead, so the flood gets no responses at all.
+type verdictCache struct {
+	ttl       time.Duration
+	dropAfter uint64
+	entries   sync.Map // ip -> *verdictEntry
+	size      atomic.Int64
+	lastSweep atomic.Int64 // unix nanoseconds
+}
+
+// newVerdictCache creates a verdict cache; a non-positive ttl disables it
+func newVerdictCache(ttl time.Duration, dropAfter int) *verdictCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &verdictCache{ttl: ttl, dropAfter: uint64(dropAfter)}
+}
+
+// lookup returns the cached verdict for ip, if any
+func (c *verdictCache) lookup(ip string, now time.Time) verdict {
+	if c == nil {
+		return verdictNone
+	}
+
+	v, ok := c.entries.Load(ip)
+	if !ok {
+		return verdictNone
+	}
+	e := v.(*verdictEntry)
+	if !now.Before(e.expires) {
+		c.delete(ip, v)
+		return verdictNone
+	}
+
+	result := verdictRefuse
+	if hits := e.hits.Add(1); c.dropAfter > 0 && hits > c.dropAfter {
+		result = verdictDrop
+	}
+	verdictHits.With(result.String()).Inc()
+	return result
+}
+
+// refuse caches a refuse verdict for ip. Expired entries are swept once
+// per ttl, as clients that are not seen again never look theirs up.
+func (c *verdictCache) refuse(ip string, now time.Time) {
+	if c == nil {
+		return
+	}
+	if last := c.lastSweep.Load(); now.UnixNano()-last >= int64(c.ttl) && c.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		c.sweep(now)
+	}
+
+	e := &verdictEntry{expires: now.Add(c.ttl)}
+	if c.size.Load() >= maxVerdicts {
+		// Only refresh clients already cached
+		if _, ok := c.entries.Load(ip); ok {
+			c.entries.Store(ip, e)
+		}
+		return
+	}
+	if _, loaded := c.entries.Swap(ip, e); !loaded {
+		c.size.Add(1)
+	}
+}
+
+// sweep drops the entries expired at now
+func (c *verdictCache) sweep(now time.Time) {
+	c.entries.Range(func(ip, v interface{}) bool {
+		if !now.Before(v.(*verdictEntry).expires) {
+			c.delete(ip.(string), v)
+		}
+		return true
+	})
+}
+
+// delete drops the entry for ip if it is still v
+func (c *verdictCache) delete(ip string, v interface{}) {
+	if c.entries.CompareAndDelete(ip, v) {
+		c.size.Add(-1)
+	}
+}
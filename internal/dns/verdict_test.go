@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVerdictCache(t *testing.T) {
+	c := newVerdictCache(time.Second, 2)
+	now := time.Now()
+
+	if v := c.lookup("192.0.2.1", now); v != verdictNone {
+		t.Fatalf("Expected no verdict for unknown client, got %v", v)
+	}
+
+	c.refuse("192.0.2.1", now)
+	for i := 0; i < 2; i++ {
+		if v := c.lookup("192.0.2.1", now); v != verdictRefuse {
+			t.Fatalf("Expected refuse verdict on hit %d, got %v", i+1, v)
+		}
+	}
+	if v := c.lookup("192.0.2.1", now); v != verdictDrop {
+		t.Errorf("Expected drop verdict after repeated hits, got %v", v)
+	}
+
+	if v := c.lookup("192.0.2.1", now.Add(2*time.Second)); v != verdictNone {
+		t.Errorf("Expected verdict to expire, got %v", v)
+	}
+}
+
+func TestVerdictCacheDisabled(t *testing.T) {
+	c := newVerdictCache(0, 0)
+	c.refuse("192.0.2.1", time.Now())
+	if v := c.lookup("192.0.2.1", time.Now()); v != verdictNone {
+		t.Errorf("Expected disabled cache to return no verdict, got %v", v)
+	}
+}
+
+func TestVerdictCacheEvictsUnderChurn(t *testing.T) {
+	c := newVerdictCache(time.Second, 0)
+	start := time.Now()
+
+	// A new spoofed source every millisecond, none seen again
+	for i := 0; i < 10000; i++ {
+		c.refuse(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255), start.Add(time.Duration(i)*time.Millisecond))
+	}
+	if size := c.size.Load(); size > 2000 {
+		t.Errorf("Expected expired entries to be swept, %d remain", size)
+	}
+
+	count := 0
+	c.entries.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	if int64(count) != c.size.Load() {
+		t.Errorf("Expected the size to track the entries, got %d for %d", c.size.Load(), count)
+	}
+}
+
+func TestVerdictCacheBounded(t *testing.T) {
+	c := newVerdictCache(time.Hour, 0)
+	now := time.Now()
+	for i := 0; i < maxVerdicts+100; i++ {
+		c.refuse(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255), now)
+	}
+	if size := c.size.Load(); size != maxVerdicts {
+		t.Errorf("Expected the cache to stop at %d entries, got %d", maxVerdicts, size)
+	}
+	if v := c.lookup("10.0.0.1", now); v != verdictRefuse {
+		t.Errorf("Expected cached clients to keep their verdict, got %v", v)
+	}
+}
@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var cacheWarming = metrics.NewCounterVec("ddd_cache_warm_total",
+	"Questions resolved into the cache at startup, by result (warmed, cached, failed)", "result")
+
+// WarmCache resolves each name for each qtype into the cache, at most
+// concurrency at a time, until ctx is done. Questions already cached (from
+// a snapshot) are skipped. It returns how many were resolved.
+func (s *Server) WarmCache(ctx context.Context, names, qtypes []string, concurrency int) (int, error) {
+	if s.opts.Cache == nil {
+		return 0, nil
+	}
+	types := make([]uint16, 0, len(qtypes))
+	for _, name := range qtypes {
+		qtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown qtype %q", name)
+		}
+		types = append(types, qtype)
+	}
+
+	var warmed atomic.Int64
+	work := make(chan *dns.Msg)
+	var workers sync.WaitGroup
+	for i := 0; i < max(concurrency, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for m := range work {
+				if s.resolve(m, strings.TrimSuffix(m.Question[0].Name, ".")) == nil {
+					cacheWarming.With("failed").Inc()
+					continue
+				}
+				cacheWarming.With("warmed").Inc()
+				warmed.Add(1)
+			}
+		}()
+	}
+
+feed:
+	for _, name := range names {
+		for _, qtype := range types {
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(strings.ToLower(name)), qtype)
+			if s.opts.Cache.Has(m) {
+				cacheWarming.With("cached").Inc()
+				continue
+			}
+			select {
+			case work <- m:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(work)
+	workers.Wait()
+	return int(warmed.Load()), nil
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/cache"
+	"ddd/internal/detector"
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestWarmCache(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked atomic.Int64
+	upstream := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		asked.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 192.0.2.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})}
+	go upstream.ActivateAndServe()
+	defer upstream.Shutdown()
+
+	log := logger.NewNop()
+	responses := cache.New(100, time.Hour)
+	s := NewServer(0, conn.LocalAddr().String(), monitor.NewTrafficMonitor(), detector.NewDDoSDetector(100, log),
+		blocker.NewIPBlocker(60, nil), log, Options{Cache: responses})
+
+	// Already cached questions are not asked again
+	cached := new(dns.Msg)
+	cached.SetQuestion("cached.example.", dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetReply(cached)
+	reply.Answer = append(reply.Answer, mustRR(t, "cached.example. 300 IN A 192.0.2.2"))
+	responses.Set(reply)
+
+	names := []string{"Example.com", "example.org.", "cached.example"}
+	warmed, err := s.WarmCache(context.Background(), names, []string{"A", "aaaa"}, 2)
+	if err != nil || warmed != 5 || asked.Load() != 5 {
+		t.Fatalf("Expected 5 questions resolved upstream, got %d of %d (%v)", warmed, asked.Load(), err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	if got := responses.Get(q); got == nil || len(got.Answer) != 1 {
+		t.Errorf("Expected example.com A cached, got %v", got)
+	}
+
+	if _, err := s.WarmCache(context.Background(), names, []string{"BOGUS"}, 1); err == nil {
+		t.Error("Expected an unknown qtype to fail")
+	}
+}
@@ -0,0 +1,27 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// watchResolution records an upstream resolution of domain started at
+// start in the watch list. A missing answer, SERVFAIL and REFUSED count as
+// failures; NXDOMAIN is a successful resolution.
+func (s *Server) watchResolution(domain string, resp *dns.Msg, err error, start time.Time) {
+	if s.opts.Watch == nil {
+		return
+	}
+	now := time.Now()
+	ok := err == nil && resp != nil && resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused
+	s.opts.Watch.Record(domain, now.Sub(start), ok, now)
+}
+
+// ProbeWatched resolves an A query for a watched domain that clients have
+// not queried lately, refreshing its cached answer and its health
+func (s *Server) ProbeWatched(domain string) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	s.resolve(m, domain)
+}
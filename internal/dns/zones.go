@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+	"ddd/internal/zones"
+)
+
+var zoneRejections = metrics.NewCounterVec("ddd_zone_rejections_total",
+	"Queries turned away in front of the authoritative server, by zone and reason (out_of_zone, zone_budget, client_budget)", "zone", "reason")
+
+// zoneGate holds a query to the zones of the authoritative server behind
+// the proxy and reports whether it was dealt with. Names outside them are
+// refused, and queries past a zone's budget dropped. Past a client's
+// budget, UDP clients get an empty truncated answer so that a real
+// resolver retries over TCP, which a spoofed source cannot, and TCP
+// clients are refused. Critical queries are exempt.
+func (s *Server) zoneGate(w dns.ResponseWriter, r *dns.Msg, clientIP, domain string, critical bool) bool {
+	if s.opts.Zones == nil || critical {
+		return false
+	}
+	zone, verdict := s.opts.Zones.Admit(clientIP, domain, time.Now())
+	switch verdict {
+	case zones.OutOfZone:
+		zoneRejections.With("", "out_of_zone").Inc()
+		s.sendRefused(w, r)
+	case zones.OverZone:
+		zoneRejections.With(zone, "zone_budget").Inc()
+	case zones.OverClient:
+		zoneRejections.With(zone, "client_budget").Inc()
+		switch w.RemoteAddr().(type) {
+		case *encryptedAddr, *net.TCPAddr:
+			s.sendRefused(w, r)
+		default:
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Truncated = true
+			if err := w.WriteMsg(m); err != nil {
+				s.log.Errorw("Error writing response", "error", err)
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/journal"
+	"ddd/internal/metrics"
+	"ddd/internal/policy"
+)
+
+var zoneTransferAttempts = metrics.NewCounterVec("ddd_zone_transfer_attempts_total",
+	"AXFR/IXFR queries and NOTIFY messages refused", "kind")
+
+var zoneTransfersRelayed = metrics.NewCounterVec("ddd_zone_transfers_relayed_total",
+	"AXFR/IXFR queries and NOTIFY messages from transfer peers relayed upstream", "kind")
+
+// zoneTransferKind returns "axfr", "ixfr" or "notify" for messages that
+// try to transfer or update a zone, or "" for ordinary queries. A resolver
+// serves no zones, so there these are reconnaissance or abuse; in front of
+// an authoritative server they are valid from its transfer peers only.
+func zoneTransferKind(r *dns.Msg) string {
+	if r.Opcode == dns.OpcodeNotify {
+		return "notify"
+	}
+	if len(r.Question) == 0 {
+		return ""
+	}
+	switch r.Question[0].Qtype {
+	case dns.TypeAXFR:
+		return "axfr"
+	case dns.TypeIXFR:
+		return "ixfr"
+	}
+	return ""
+}
+
+// refuseZoneTransfer refuses a zone transfer or NOTIFY, counts it and
+// rate limits the client as a detection signal
+func (s *Server) refuseZoneTransfer(w dns.ResponseWriter, r *dns.Msg, clientIP, kind string) {
+	zoneTransferAttempts.With(kind).Inc()
+
+	ddosDetector, group := s.detectorFor(clientIP, clientFingerprint(w.RemoteAddr()))
+	result := ddosDetector.AnalyzeZoneTransfer(clientIP, kind)
+	s.countDetection(clientIP, group, result)
+	s.log.Warnw("Attack detected", groupFields(group, fingerprintFields(w.RemoteAddr(),
+		"ip", clientIP,
+		"attack_type", result.AttackType,
+		"severity", result.Severity.String(),
+	)...)...)
+	decision, decidedBy := policy.RateLimit, "detector"
+	if capped := groupDecision(group, decision); capped != decision {
+		decision, decidedBy = capped, "group"
+	}
+	s.journalDetection(journal.SourceZoneTransfer, clientIP, "", kind, ddosDetector, group,
+		result, nil, decision, decidedBy)
+	if decision == policy.RateLimit {
+		s.rateLimit(clientIP, r)
+	}
+
+	s.sendRefused(w, r)
+}
+
+// transferPeer reports whether clientIP is a configured transfer peer
+func (s *Server) transferPeer(clientIP string) bool {
+	if len(s.opts.TransferPeers) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, peer := range s.opts.TransferPeers {
+		if peer.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// relayZoneTransfer passes a transfer peer's NOTIFY or zone transfer to
+// the authoritative server. Transfers over TCP are streamed back message
+// by message; over UDP, like NOTIFY, they are a single exchange.
+func (s *Server) relayZoneTransfer(w dns.ResponseWriter, r *dns.Msg, kind string) {
+	zoneTransfersRelayed.With(kind).Inc()
+
+	if kind == "notify" {
+		s.relayExchange(w, r)
+		return
+	}
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok {
+		s.relayExchange(w, r)
+		return
+	}
+
+	addr := s.upstreams.upstreams[s.upstreams.pick(r.Question[0].Name)]
+	tr := &dns.Transfer{DialTimeout: s.upstreamClient.Timeout, ReadTimeout: s.upstreamClient.Timeout}
+	envelopes, err := tr.In(r, addr)
+	if err != nil {
+		if tr.Conn != nil {
+			tr.Conn.Close()
+		}
+		s.log.Warnw("Zone transfer relay failed", "upstream", addr, "kind", kind, "error", err)
+		s.sendServerFailure(w, r)
+		return
+	}
+
+	// The transfer goroutine only exits once its channel is drained
+	defer func() {
+		for range envelopes {
+		}
+	}()
+	first := true
+	for env := range envelopes {
+		if env.Error != nil {
+			s.log.Warnw("Zone transfer relay failed", "upstream", addr, "kind", kind, "error", env.Error)
+			if first {
+				s.sendServerFailure(w, r)
+			} else {
+				w.Close()
+			}
+			return
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = env.RR
+		if err := w.WriteMsg(m); err != nil {
+			return
+		}
+		first = false
+	}
+}
+
+// relayExchange forwards r upstream and writes back the answer
+func (s *Server) relayExchange(w dns.ResponseWriter, r *dns.Msg) {
+	resp, _, err := s.forward(r)
+	if err != nil {
+		s.sendServerFailure(w, r)
+		return
+	}
+	w.WriteMsg(resp)
+}
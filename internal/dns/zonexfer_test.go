@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/blocker"
+	"ddd/internal/detector"
+	"ddd/internal/logger"
+	"ddd/internal/monitor"
+)
+
+func TestZoneTransferKind(t *testing.T) {
+	axfr := new(dns.Msg)
+	axfr.SetAxfr("example.com.")
+
+	ixfr := new(dns.Msg)
+	ixfr.SetIxfr("example.com.", 1, "ns.example.com.", "admin.example.com.")
+
+	notify := new(dns.Msg)
+	notify.SetNotify("example.com.")
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeSOA)
+
+	for want, msg := range map[string]*dns.Msg{"axfr": axfr, "ixfr": ixfr, "notify": notify, "": query} {
+		if got := zoneTransferKind(msg); got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+// transferWriter keeps every message written to a client at addr
+type transferWriter struct {
+	dns.ResponseWriter
+	addr net.Addr
+	msgs []*dns.Msg
+}
+
+func (w *transferWriter) RemoteAddr() net.Addr      { return w.addr }
+func (w *transferWriter) WriteMsg(m *dns.Msg) error { w.msgs = append(w.msgs, m); return nil }
+func (w *transferWriter) Close() error              { return nil }
+
+// serveZone starts an authoritative server for example.com on UDP and TCP
+// that answers NOTIFY and AXFR, returning its address
+func serveZone(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeNotify {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			w.WriteMsg(m)
+			return
+		}
+		soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 300")
+		a, _ := dns.NewRR("www.example.com. 3600 IN A 192.0.2.80")
+		ch := make(chan *dns.Envelope)
+		go func() {
+			ch <- &dns.Envelope{RR: []dns.RR{soa, a}}
+			ch <- &dns.Envelope{RR: []dns.RR{soa}}
+			close(ch)
+		}()
+		new(dns.Transfer).Out(w, r, ch)
+	})
+	tcp := &dns.Server{Listener: l, Handler: handler}
+	udp := &dns.Server{PacketConn: conn, Handler: handler}
+	go tcp.ActivateAndServe()
+	go udp.ActivateAndServe()
+	t.Cleanup(func() {
+		tcp.Shutdown()
+		udp.Shutdown()
+	})
+	return l.Addr().String()
+}
+
+func TestZoneTransferPeers(t *testing.T) {
+	log := logger.NewNop()
+	s := NewServer(0, serveZone(t), monitor.NewTrafficMonitor(), detector.NewDDoSDetector(100, log),
+		blocker.NewIPBlocker(60, nil), log, Options{
+			TransferPeers: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/28")},
+		})
+
+	axfr := new(dns.Msg)
+	axfr.SetAxfr("example.com.")
+	peer := &transferWriter{addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}}
+	s.handleDNSRequest(peer, axfr)
+	var records int
+	for _, m := range peer.msgs {
+		records += len(m.Answer)
+	}
+	if len(peer.msgs) != 2 || records != 3 {
+		t.Errorf("Expected the zone relayed in 2 messages of 3 records, got %d of %d", len(peer.msgs), records)
+	}
+
+	notify := new(dns.Msg)
+	notify.SetNotify("example.com.")
+	primary := &transferWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}}
+	s.handleDNSRequest(primary, notify)
+	if len(primary.msgs) != 1 || primary.msgs[0].Rcode != dns.RcodeSuccess || primary.msgs[0].Opcode != dns.OpcodeNotify {
+		t.Errorf("Expected the NOTIFY acknowledged, got %v", primary.msgs)
+	}
+
+	other := &transferWriter{addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.20"), Port: 40000}}
+	s.handleDNSRequest(other, axfr)
+	if len(other.msgs) != 1 || other.msgs[0].Rcode != dns.RcodeRefused {
+		t.Errorf("Expected a transfer from outside the peers refused, got %v", other.msgs)
+	}
+}
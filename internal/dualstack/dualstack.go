@@ -0,0 +1,343 @@
+// Package dualstack links the IPv4 and IPv6 addresses of one dual-stack
+// client, so that a block earned over one address family follows the
+// client to the other and its reputation covers both. Two kinds of
+// evidence link addresses: the same EDNS client cookie (RFC 7873) sent
+// from both, or the same names queried from both within moments of each
+// other with the same query and TLS fingerprints. Evidence shared by
+// several addresses of one family, as behind a NAT, links nothing. Only
+// queries whose source cannot be forged, over TCP, DoT or DoH, should be
+// observed: a forged query from a victim's address would otherwise link it
+// to an attacker's and carry the attacker's block to the victim.
+package dualstack
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	linksMade = metrics.NewCounterVec("ddd_dualstack_links_total",
+		"IPv4 and IPv6 addresses linked as one client, by evidence", "evidence")
+	linkedPairs = metrics.NewGauge("ddd_dualstack_links",
+		"IPv4 and IPv6 address pairs currently linked")
+	carried = metrics.NewCounterVec("ddd_dualstack_carried_total",
+		"Blocks and unblocks carried to linked addresses", "action")
+)
+
+const (
+	// maxSightings bounds the cookies and fingerprinted names remembered
+	maxSightings = 100000
+	// maxAddresses bounds the addresses with links
+	maxAddresses = 100000
+	// maxPeers bounds the addresses one address is linked to
+	maxPeers = 8
+	// cookieSightings is how many queries each address must present a
+	// cookie in, in a row, before it links them
+	cookieSightings = 2
+
+	// linkedReason starts the reason of a block carried over a link
+	linkedReason = "linked to "
+)
+
+// Correlator links the addresses of dual-stack clients and carries blocks
+// between them. It is safe for concurrent use.
+type Correlator struct {
+	ttl     time.Duration
+	window  time.Duration
+	minCo   int
+	blocker *blocker.IPBlocker
+	log     *logger.Logger
+
+	mu         sync.Mutex
+	cookies    map[string]*sighting
+	queries    map[string]*sighting
+	candidates map[pair]*candidate
+	links      map[string]map[string]time.Time // address -> peer -> expiry
+	pairs      int
+}
+
+// sighting is the last IPv4 and IPv6 address a piece of evidence came from
+type sighting struct {
+	v4, v6     string
+	v4At, v6At time.Time
+	// v4N and v6N count the sightings in a row from each address
+	v4N, v6N int
+	// ambiguous is until when the evidence links nothing, after two
+	// addresses of one family shared it
+	ambiguous time.Time
+}
+
+// pair is an IPv4 and an IPv6 address
+type pair struct {
+	v4, v6 string
+}
+
+// candidate is a pair seen querying the same names, not yet linked
+type candidate struct {
+	names map[string]bool
+	last  time.Time
+}
+
+// New creates a correlator carrying blocks between linked addresses in b
+func New(cfg config.DualStackConfig, b *blocker.IPBlocker, log *logger.Logger) *Correlator {
+	return &Correlator{
+		ttl:        cfg.LinkTTL,
+		window:     cfg.CoQueryWindow,
+		minCo:      cfg.MinCoQueries,
+		blocker:    b,
+		log:        log,
+		cookies:    make(map[string]*sighting),
+		queries:    make(map[string]*sighting),
+		candidates: make(map[pair]*candidate),
+		links:      make(map[string]map[string]time.Time),
+	}
+}
+
+// Observe takes the evidence of a query for name from ip: its client
+// cookie and its fingerprint, either of which may be empty. It links ip to
+// an address of the other family when both presented the same cookie in
+// two queries within the link TTL, or when that address queried enough of the same names with the same
+// fingerprint within the co-query window, carrying over a block of either.
+// It is safe to call on a nil correlator.
+func (c *Correlator) Observe(ip, cookie, fingerprint, name string, now time.Time) {
+	if c == nil {
+		return
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return
+	}
+	v4 := addr.To4() != nil
+
+	type link struct {
+		p        pair
+		evidence string
+	}
+	var made []link
+	c.mu.Lock()
+	if cookie != "" {
+		if other := c.sightLocked(c.cookies, cookie, now).see(ip, v4, now, c.ttl, c.window, cookieSightings); other != "" {
+			p := pairOf(ip, other, v4)
+			if c.linkLocked(p, now) {
+				made = append(made, link{p, "cookie"})
+			}
+		}
+	}
+	if fingerprint != "" && name != "" {
+		name = strings.ToLower(name)
+		if other := c.sightLocked(c.queries, fingerprint+"|"+name, now).see(ip, v4, now, c.window, c.window, 1); other != "" {
+			p := pairOf(ip, other, v4)
+			if c.confirmLocked(p, name, now) && c.linkLocked(p, now) {
+				made = append(made, link{p, "queries"})
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, l := range made {
+		linksMade.With(l.evidence).Inc()
+		c.log.Infow("Linked dual-stack client addresses", "ipv4", l.p.v4, "ipv6", l.p.v6, "evidence", l.evidence)
+		c.carryBlock(l.p.v4, l.p.v6)
+		c.carryBlock(l.p.v6, l.p.v4)
+	}
+}
+
+// pairOf orders ip and other, its peer of the other family
+func pairOf(ip, other string, v4 bool) pair {
+	if v4 {
+		return pair{v4: ip, v6: other}
+	}
+	return pair{v4: other, v6: ip}
+}
+
+// see records evidence coming from ip at now and returns the address of
+// the other family it came from within link, if any, once each address has
+// presented it min times in a row. Evidence coming from two addresses of
+// one family within exclusive links nothing until exclusive has passed.
+func (s *sighting) see(ip string, v4 bool, now time.Time, link, exclusive time.Duration, min int) string {
+	own, ownAt, ownN, other, otherAt, otherN := &s.v4, &s.v4At, &s.v4N, s.v6, s.v6At, s.v6N
+	if !v4 {
+		own, ownAt, ownN, other, otherAt, otherN = &s.v6, &s.v6At, &s.v6N, s.v4, s.v4At, s.v4N
+	}
+	if *own != "" && *own != ip && now.Sub(*ownAt) <= exclusive {
+		s.ambiguous = now.Add(exclusive)
+	}
+	if *own != ip || now.Sub(*ownAt) > link {
+		*ownN = 0
+	}
+	*own, *ownAt = ip, now
+	*ownN++
+	if now.Before(s.ambiguous) || other == "" || now.Sub(otherAt) > link || *ownN < min || otherN < min {
+		return ""
+	}
+	return other
+}
+
+// sightLocked returns the sighting of key in m, making room for it when m
+// is full
+func (c *Correlator) sightLocked(m map[string]*sighting, key string, now time.Time) *sighting {
+	s, ok := m[key]
+	if ok {
+		return s
+	}
+	if len(m) >= maxSightings {
+		for k, old := range m {
+			if now.Sub(old.v4At) > c.ttl && now.Sub(old.v6At) > c.ttl {
+				delete(m, k)
+			}
+		}
+		if len(m) >= maxSightings {
+			for k := range m {
+				delete(m, k)
+			}
+		}
+	}
+	s = &sighting{}
+	m[key] = s
+	return s
+}
+
+// confirmLocked counts name as queried by both addresses of p and reports
+// whether they have queried enough names together to be linked
+func (c *Correlator) confirmLocked(p pair, name string, now time.Time) bool {
+	cand, ok := c.candidates[p]
+	if !ok || now.Sub(cand.last) > c.ttl {
+		if len(c.candidates) >= maxSightings {
+			for q, old := range c.candidates {
+				if now.Sub(old.last) > c.ttl {
+					delete(c.candidates, q)
+				}
+			}
+			if len(c.candidates) >= maxSightings {
+				return false
+			}
+		}
+		cand = &candidate{names: make(map[string]bool)}
+		c.candidates[p] = cand
+	}
+	cand.names[name] = true
+	cand.last = now
+	if len(cand.names) < c.minCo {
+		return false
+	}
+	delete(c.candidates, p)
+	return true
+}
+
+// linkLocked links the addresses of p until the link TTL from now and
+// reports whether they were not linked before. A link that would exceed
+// the limits is not made.
+func (c *Correlator) linkLocked(p pair, now time.Time) bool {
+	if expiry, ok := c.links[p.v4][p.v6]; ok && now.Before(expiry) {
+		c.links[p.v4][p.v6] = now.Add(c.ttl)
+		c.links[p.v6][p.v4] = now.Add(c.ttl)
+		return false
+	}
+	if len(c.links) >= maxAddresses {
+		c.pruneLocked(now)
+	}
+	if len(c.links) >= maxAddresses ||
+		len(c.peersLocked(p.v4, now)) >= maxPeers || len(c.peersLocked(p.v6, now)) >= maxPeers {
+		return false
+	}
+	for _, end := range [][2]string{{p.v4, p.v6}, {p.v6, p.v4}} {
+		peers := c.links[end[0]]
+		if peers == nil {
+			peers = make(map[string]time.Time)
+			c.links[end[0]] = peers
+		}
+		peers[end[1]] = now.Add(c.ttl)
+	}
+	c.pairs++
+	linkedPairs.Set(float64(c.pairs))
+	return true
+}
+
+// peersLocked returns the addresses ip is linked to, forgetting expired
+// links
+func (c *Correlator) peersLocked(ip string, now time.Time) []string {
+	var peers []string
+	for peer, expiry := range c.links[ip] {
+		if now.Before(expiry) {
+			peers = append(peers, peer)
+			continue
+		}
+		delete(c.links[ip], peer)
+		delete(c.links[peer], ip)
+		if len(c.links[peer]) == 0 {
+			delete(c.links, peer)
+		}
+		c.pairs--
+		linkedPairs.Set(float64(c.pairs))
+	}
+	if len(c.links[ip]) == 0 {
+		delete(c.links, ip)
+	}
+	return peers
+}
+
+// pruneLocked forgets expired links
+func (c *Correlator) pruneLocked(now time.Time) {
+	for ip := range c.links {
+		c.peersLocked(ip, now)
+	}
+}
+
+// Peers returns the addresses of the other family linked to ip, sorted. It
+// is safe to call on a nil correlator.
+func (c *Correlator) Peers(ip string, now time.Time) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	peers := c.peersLocked(ip, now)
+	c.mu.Unlock()
+	sort.Strings(peers)
+	return peers
+}
+
+// Handle carries blocks and operator unblocks of an address to the
+// addresses linked to it. Blocks that were themselves carried over a link
+// go no further.
+func (c *Correlator) Handle(e events.Event) {
+	switch e.Type {
+	case events.IPBlocked:
+		if strings.HasPrefix(e.Reason, linkedReason) {
+			return
+		}
+		for _, peer := range c.Peers(e.IP, time.Now()) {
+			c.carryBlock(e.IP, peer)
+		}
+	case events.IPUnblocked:
+		for _, peer := range c.Peers(e.IP, time.Now()) {
+			b := c.blocker.GetBlockedIP(peer)
+			if b != nil && b.IP == peer && strings.HasPrefix(b.Reason, linkedReason+e.IP+": ") {
+				c.blocker.UnblockIP(peer)
+				carried.With("unblock").Inc()
+			}
+		}
+	}
+}
+
+// carryBlock blocks peer like from, if from is blocked and peer is not
+func (c *Correlator) carryBlock(from, peer string) {
+	b := c.blocker.GetBlockedIP(from)
+	if b == nil || c.blocker.IsBlocked(peer) {
+		return
+	}
+	reason := b.Reason
+	if strings.HasPrefix(reason, linkedReason) {
+		return
+	}
+	c.blocker.BlockIPWithSeverity(peer, linkedReason+from+": "+reason, b.Severity)
+	carried.With("block").Inc()
+}
@@ -0,0 +1,93 @@
+package dualstack
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/severity"
+)
+
+func newCorrelator() (*Correlator, *blocker.IPBlocker) {
+	b := blocker.NewIPBlocker(300, events.NewBus())
+	return New(config.Default().DualStack, b, logger.NewNop()), b
+}
+
+func TestCookieLinksAndCarriesBlock(t *testing.T) {
+	c, b := newCorrelator()
+	now := time.Now()
+
+	b.BlockIPWithSeverity("192.0.2.10", "random_subdomain", severity.High)
+	c.Observe("192.0.2.10", "0102030405060708", "", "", now)
+	c.Observe("2001:db8::10", "0102030405060708", "", "", now.Add(5*time.Minute))
+	c.Observe("192.0.2.10", "0102030405060708", "", "", now.Add(5*time.Minute))
+	if peers := c.Peers("192.0.2.10", now.Add(5*time.Minute)); len(peers) != 0 {
+		t.Fatalf("Expected one query with the cookie from the IPv6 address to link nothing, got %v", peers)
+	}
+	c.Observe("2001:db8::10", "0102030405060708", "", "", now.Add(10*time.Minute))
+
+	if peers := c.Peers("192.0.2.10", now.Add(10*time.Minute)); len(peers) != 1 || peers[0] != "2001:db8::10" {
+		t.Fatalf("Expected the addresses linked by their cookie, got %v", peers)
+	}
+	blocked := b.GetBlockedIP("2001:db8::10")
+	if blocked == nil || blocked.Severity != severity.High || !strings.HasPrefix(blocked.Reason, "linked to 192.0.2.10: ") {
+		t.Fatalf("Expected the block carried to the IPv6 address, got %+v", blocked)
+	}
+
+	// An operator unblocking the address lifts the carried block too
+	b.UnblockIP("192.0.2.10")
+	c.Handle(events.Event{Type: events.IPUnblocked, IP: "192.0.2.10"})
+	if b.IsBlocked("2001:db8::10") {
+		t.Error("Expected the carried block lifted with the original")
+	}
+
+	// Links expire without fresh evidence
+	if peers := c.Peers("2001:db8::10", now.Add(2*time.Hour)); len(peers) != 0 {
+		t.Errorf("Expected the link to expire, got %v", peers)
+	}
+}
+
+func TestCoQueriesLink(t *testing.T) {
+	c, b := newCorrelator()
+	now := time.Now()
+
+	for i, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		at := now.Add(time.Duration(i) * time.Minute)
+		if len(c.Peers("198.51.100.7", at)) != 0 {
+			t.Fatalf("Linked after %d names, want 3", i)
+		}
+		c.Observe("198.51.100.7", "", "fp", name, at)
+		c.Observe("2001:db8::7", "", "fp", name, at.Add(time.Second))
+	}
+	if peers := c.Peers("2001:db8::7", now.Add(3*time.Minute)); len(peers) != 1 || peers[0] != "198.51.100.7" {
+		t.Fatalf("Expected a link after three shared names, got %v", peers)
+	}
+
+	// Blocks detected later follow the link
+	b.BlockIPWithSeverity("2001:db8::7", "query_burst", severity.Medium)
+	c.Handle(events.Event{Type: events.IPBlocked, IP: "2001:db8::7", Reason: "query_burst"})
+	if !b.IsBlocked("198.51.100.7") {
+		t.Error("Expected the block carried to the IPv4 address")
+	}
+
+	// Other fingerprints, slow follow-ups and names shared by several
+	// addresses of a family link nothing
+	c.Observe("203.0.113.1", "", "fp", "x.example.com", now)
+	c.Observe("2001:db8::1", "", "other", "x.example.com", now)
+	c.Observe("203.0.113.2", "", "fp", "y.example.com", now)
+	c.Observe("2001:db8::2", "", "fp", "y.example.com", now.Add(time.Minute))
+	for _, name := range []string{"p.example.com", "q.example.com", "r.example.com"} {
+		c.Observe("203.0.113.3", "", "fp", name, now)
+		c.Observe("203.0.113.4", "", "fp", name, now)
+		c.Observe("2001:db8::3", "", "fp", name, now)
+	}
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4"} {
+		if peers := c.Peers(ip, now); len(peers) != 0 {
+			t.Errorf("Expected %s unlinked, got %v", ip, peers)
+		}
+	}
+}
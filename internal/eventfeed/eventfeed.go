@@ -0,0 +1,281 @@
+// Package eventfeed keeps the recent events of the bus in a bounded log
+// and shares them between installations. Each node serves the events it
+// published itself as a feed that peers long-poll through the admin API;
+// a read replica follows the feeds of the serving nodes and republishes
+// their events on its own bus, so that it can answer queries about the
+// whole cluster without adding to their load. Followed events are never
+// served on, so feeds do not echo between nodes that follow each other.
+package eventfeed
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
+)
+
+// maxPage bounds the events returned by one feed poll
+const maxPage = 1000
+
+// Record is an event as the feed and the API carry it
+type Record struct {
+	Seq      uint64         `json:"seq"`
+	Type     events.Type    `json:"type"`
+	Time     time.Time      `json:"time"`
+	Instance string         `json:"instance"`
+	IP       string         `json:"ip,omitempty"`
+	Domain   string         `json:"domain,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Severity severity.Level `json:"severity,omitempty"` // left out when none
+	Duration time.Duration  `json:"duration,omitempty"` // nanoseconds
+	File     string         `json:"file,omitempty"`
+}
+
+// Event returns the event the record carries
+func (r Record) Event() events.Event {
+	return events.Event{
+		Type:     r.Type,
+		Time:     r.Time,
+		Instance: r.Instance,
+		IP:       r.IP,
+		Domain:   r.Domain,
+		Reason:   r.Reason,
+		Severity: r.Severity,
+		Duration: r.Duration,
+		File:     r.File,
+	}
+}
+
+// record returns e as the record numbered seq
+func record(seq uint64, e events.Event) Record {
+	return Record{
+		Seq:      seq,
+		Type:     e.Type,
+		Time:     e.Time.UTC(),
+		Instance: e.Instance,
+		IP:       e.IP,
+		Domain:   e.Domain,
+		Reason:   e.Reason,
+		Severity: e.Severity,
+		Duration: e.Duration,
+		File:     e.File,
+	}
+}
+
+// Page is one poll of a node's feed
+type Page struct {
+	Node string `json:"node"`
+	// Start is the first sequence number of the node's log; it grows
+	// across restarts
+	Start uint64 `json:"start"`
+	// Next is the since of the following poll
+	Next uint64 `json:"next"`
+	// Missed counts events since the previous poll that the log no
+	// longer held
+	Missed uint64   `json:"missed,omitempty"`
+	Events []Record `json:"events"`
+}
+
+// Log holds the latest events of the bus, numbered in order
+type Log struct {
+	instance string
+
+	mu      sync.Mutex
+	ring    []Record
+	start   uint64 // first sequence number
+	next    uint64 // sequence number of the next event
+	changed chan struct{}
+}
+
+// NewLog creates a log of the last size events, serving those instance
+// published as its feed. Sequence numbers start from the clock so that
+// they keep growing across restarts.
+func NewLog(instance string, size int) *Log {
+	start := uint64(time.Now().UnixMicro())
+	return &Log{
+		instance: instance,
+		ring:     make([]Record, max(size, 1)),
+		start:    start,
+		next:     start,
+		changed:  make(chan struct{}),
+	}
+}
+
+// Handle appends an event. It consumes the event bus.
+func (l *Log) Handle(e events.Event) {
+	l.mu.Lock()
+	l.ring[l.next%uint64(len(l.ring))] = record(l.next, e)
+	l.next++
+	close(l.changed)
+	l.changed = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// oldest returns the sequence number of the oldest event held. The
+// caller holds l.mu.
+func (l *Log) oldest() uint64 {
+	if held := uint64(len(l.ring)); l.next-l.start > held {
+		return l.next - held
+	}
+	return l.start
+}
+
+// Wait returns the events this node published from since on, waiting up
+// to wait for one if there are none yet, or less if ctx is done. A since
+// of 0, or from before the log started, begins with the oldest event
+// held.
+func (l *Log) Wait(ctx context.Context, since uint64, wait time.Duration) Page {
+	l.mu.Lock()
+	next, changed := l.next, l.changed
+	l.mu.Unlock()
+
+	if since >= next {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	page := Page{Node: l.instance, Start: l.start, Next: l.next, Events: []Record{}}
+	from := l.oldest()
+	switch {
+	case since > l.next:
+		// From a log kept by a clock running ahead: start over
+	case since >= from:
+		from = since
+	case since >= l.start:
+		page.Missed = from - since
+	}
+	for seq := from; seq < l.next; seq++ {
+		r := l.ring[seq%uint64(len(l.ring))]
+		if r.Instance != l.instance {
+			continue
+		}
+		if len(page.Events) == maxPage {
+			page.Next = seq
+			break
+		}
+		page.Events = append(page.Events, r)
+	}
+	return page
+}
+
+// Filter selects events from the log; empty fields match any event
+type Filter struct {
+	Type     events.Type
+	IP       string
+	Instance string
+	Since    time.Time
+	Limit    int // 0 returns all
+}
+
+// Query returns the events held that match f, the last taken in first
+func (l *Log) Query(f Filter) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found := []Record{}
+	for seq := l.next; seq > l.oldest(); seq-- {
+		r := l.ring[(seq-1)%uint64(len(l.ring))]
+		// Followed events arrive out of order, so all are looked at
+		if r.Time.Before(f.Since) ||
+			(f.Type != "" && r.Type != f.Type) || (f.IP != "" && r.IP != f.IP) ||
+			(f.Instance != "" && r.Instance != f.Instance) {
+			continue
+		}
+		found = append(found, r)
+		if f.Limit > 0 && len(found) == f.Limit {
+			break
+		}
+	}
+	return found
+}
+
+// Count is how many events named a key
+type Count struct {
+	Key    string `json:"key"`
+	Events int    `json:"events"`
+}
+
+// Summary aggregates the events of a period across the nodes
+type Summary struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Events int       `json:"events"`
+	// Complete is false when events of the period have been overwritten
+	Complete   bool           `json:"complete"`
+	ByType     map[string]int `json:"by_type"`
+	ByInstance map[string]int `json:"by_instance"`
+	// Addresses most often blocked or rate limited, and domains most
+	// often attacked
+	TopClients []Count `json:"top_clients"`
+	TopDomains []Count `json:"top_domains"`
+}
+
+// Summarize aggregates the events held from since to now, keeping the top
+// entries of each ranking
+func (l *Log) Summarize(since, now time.Time, top int) Summary {
+	s := Summary{
+		Since:      since,
+		Until:      now,
+		Complete:   true,
+		ByType:     map[string]int{},
+		ByInstance: map[string]int{},
+	}
+	clients := map[string]int{}
+	domains := map[string]int{}
+
+	l.mu.Lock()
+	oldest := l.oldest()
+	if oldest > l.start {
+		// Events before the oldest held have been overwritten
+		s.Complete = !l.ring[oldest%uint64(len(l.ring))].Time.After(since)
+	}
+	for seq := l.next; seq > oldest; seq-- {
+		r := l.ring[(seq-1)%uint64(len(l.ring))]
+		if r.Time.Before(since) || r.Time.After(now) {
+			continue
+		}
+		s.Events++
+		s.ByType[string(r.Type)]++
+		s.ByInstance[r.Instance]++
+		switch r.Type {
+		case events.IPBlocked, events.IPRateLimited:
+			clients[r.IP]++
+		case events.DomainAttacked:
+			domains[r.Domain]++
+		}
+	}
+	l.mu.Unlock()
+
+	s.TopClients = ranking(clients, top)
+	s.TopDomains = ranking(domains, top)
+	return s
+}
+
+// ranking returns the n keys with the most events, most first
+func ranking(counts map[string]int, n int) []Count {
+	ranked := make([]Count, 0, len(counts))
+	for key, events := range counts {
+		ranked = append(ranked, Count{Key: key, Events: events})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Events != ranked[j].Events {
+			return ranked[i].Events > ranked[j].Events
+		}
+		return ranked[i].Key < ranked[j].Key
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
@@ -0,0 +1,103 @@
+package eventfeed
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/severity"
+)
+
+func TestFeedServesOwnEvents(t *testing.T) {
+	l := NewLog("dns-a", 4)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	l.Handle(events.Event{Type: events.IPBlocked, Time: now, Instance: "dns-a", IP: "192.0.2.1", Severity: severity.High})
+	l.Handle(events.Event{Type: events.IPBlocked, Time: now, Instance: "dns-b", IP: "192.0.2.2"})
+
+	page := l.Wait(context.Background(), 0, 0)
+	if len(page.Events) != 1 || page.Events[0].IP != "192.0.2.1" || page.Events[0].Severity != severity.High {
+		t.Fatalf("Expected only this node's event, got %+v", page.Events)
+	}
+
+	// Nothing new: the poll waits, and answers when an event comes in
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.Handle(events.Event{Type: events.DomainAttacked, Time: now, Instance: "dns-a", Domain: "example.com"})
+	}()
+	next := l.Wait(context.Background(), page.Next, time.Second)
+	if len(next.Events) != 1 || next.Events[0].Domain != "example.com" || next.Missed != 0 {
+		t.Fatalf("Expected the new event, got %+v", next)
+	}
+
+	// A follower that fell behind the ring is told how much it missed
+	for i := 0; i < 6; i++ {
+		l.Handle(events.Event{Type: events.IPRateLimited, Time: now, Instance: "dns-a", IP: "192.0.2.3"})
+	}
+	if behind := l.Wait(context.Background(), next.Next, 0); behind.Missed != 2 || len(behind.Events) != 4 {
+		t.Errorf("Expected 2 events missed and 4 returned, got %d and %d", behind.Missed, len(behind.Events))
+	}
+}
+
+func TestFollowerRepublishesEvents(t *testing.T) {
+	peer := NewLog("dns-a", 16)
+	peer.Handle(events.Event{Type: events.IPBlocked, Instance: "dns-a", IP: "192.0.2.1", Severity: severity.Medium, Duration: time.Hour})
+
+	// Through JSON, as the API carries it
+	fetch := func(ctx context.Context, since uint64, wait time.Duration) (*Page, error) {
+		page := peer.Wait(ctx, since, wait)
+		data, err := json.Marshal(page)
+		if err != nil {
+			return nil, err
+		}
+		var decoded Page
+		return &decoded, json.Unmarshal(data, &decoded)
+	}
+
+	bus := events.NewBus()
+	bus.SetInstance("replica")
+	ch := bus.Subscribe("test", 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewFollower("dns-a", fetch, bus, time.Second, time.Second, logger.NewNop()).Run(ctx)
+
+	select {
+	case e := <-ch:
+		if e.Instance != "dns-a" || e.IP != "192.0.2.1" || e.Severity != severity.Medium || e.Duration != time.Hour {
+			t.Errorf("Expected the peer's event republished as it was, got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the peer's event on the bus")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	l := NewLog("replica", 16)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, e := range []events.Event{
+		{Type: events.IPBlocked, Time: now.Add(-2 * time.Hour), Instance: "dns-a", IP: "192.0.2.9"},
+		{Type: events.IPBlocked, Time: now.Add(-time.Minute), Instance: "dns-a", IP: "192.0.2.1"},
+		{Type: events.IPRateLimited, Time: now.Add(-time.Minute), Instance: "dns-b", IP: "192.0.2.1"},
+		{Type: events.IPBlocked, Time: now.Add(-time.Minute), Instance: "dns-b", IP: "192.0.2.2"},
+		{Type: events.DomainAttacked, Time: now.Add(-time.Minute), Instance: "dns-b", Domain: "example.com"},
+	} {
+		l.Handle(e)
+	}
+
+	s := l.Summarize(now.Add(-time.Hour), now, 1)
+	if s.Events != 4 || !s.Complete || s.ByType["ip_blocked"] != 2 || s.ByInstance["dns-b"] != 3 {
+		t.Errorf("Expected 4 events of the last hour counted, got %+v", s)
+	}
+	if len(s.TopClients) != 1 || s.TopClients[0] != (Count{Key: "192.0.2.1", Events: 2}) {
+		t.Errorf("Expected the most flagged client first, got %v", s.TopClients)
+	}
+	if len(s.TopDomains) != 1 || s.TopDomains[0].Key != "example.com" {
+		t.Errorf("Expected the attacked domain ranked, got %v", s.TopDomains)
+	}
+
+	if got := l.Query(Filter{IP: "192.0.2.1", Since: now.Add(-time.Hour)}); len(got) != 2 || got[0].Type != events.IPRateLimited {
+		t.Errorf("Expected the client's 2 events, last first, got %+v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package eventfeed
+
+import (
+	"context"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	followedEvents = metrics.NewCounterVec("ddd_eventfeed_followed_total",
+		"Events taken in from a peer's event feed", "peer")
+	missedEvents = metrics.NewCounterVec("ddd_eventfeed_missed_total",
+		"Events a peer's feed no longer held when it was polled", "peer")
+	fetchErrors = metrics.NewCounterVec("ddd_eventfeed_fetch_errors_total",
+		"Failed polls of a peer's event feed", "peer")
+)
+
+// Fetch long-polls a peer's feed for the events from since on, waiting up
+// to wait for one
+type Fetch func(ctx context.Context, since uint64, wait time.Duration) (*Page, error)
+
+// Follower republishes the events of one peer's feed on the local bus
+type Follower struct {
+	peer    string
+	fetch   Fetch
+	bus     *events.Bus
+	wait    time.Duration
+	timeout time.Duration
+	log     *logger.Logger
+}
+
+// NewFollower creates a follower publishing on bus the events fetch
+// returns from peer. Each poll waits up to wait for an event, plus
+// timeout for the exchange itself.
+func NewFollower(peer string, fetch Fetch, bus *events.Bus, wait, timeout time.Duration, log *logger.Logger) *Follower {
+	return &Follower{
+		peer:    peer,
+		fetch:   fetch,
+		bus:     bus,
+		wait:    wait,
+		timeout: timeout,
+		log:     log,
+	}
+}
+
+// Run polls the peer until ctx is cancelled, backing off while it is
+// unreachable. The first poll takes in the events the peer still holds.
+func (f *Follower) Run(ctx context.Context) {
+	var since uint64
+	backoff := time.Second
+	failing := false
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, f.wait+f.timeout)
+		page, err := f.fetch(pollCtx, since, f.wait)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			fetchErrors.With(f.peer).Inc()
+			if !failing {
+				f.log.Warnw("Failed to poll peer event feed", "peer", f.peer, "error", err)
+				failing = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+
+		if failing || since == 0 {
+			f.log.Infow("Following peer event feed", "peer", f.peer, "node", page.Node, "events", len(page.Events))
+		}
+		if page.Missed > 0 {
+			missedEvents.With(f.peer).Add(page.Missed)
+			f.log.Warnw("Peer event feed overran between polls", "peer", f.peer, "missed", page.Missed)
+		}
+		failing, backoff = false, time.Second
+		since = page.Next
+		f.apply(page)
+	}
+}
+
+// apply publishes the events of page
+func (f *Follower) apply(page *Page) {
+	for _, r := range page.Events {
+		f.bus.Publish(r.Event())
+	}
+	followedEvents.With(f.peer).Add(uint64(len(page.Events)))
+}
@@ -0,0 +1,196 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+// Type identifies the kind of event
+type Type string
+
+// Event types published by the enforcement path
+const (
+	IPBlocked     Type = "ip_blocked"
+	IPRateLimited Type = "rate_limited"
+	IPUnblocked   Type = "ip_unblocked"
+
+	// UpstreamAnswerChanged reports a stable name resolving into a network
+	// it has never resolved to before (possible hijack or cache poisoning)
+	UpstreamAnswerChanged Type = "upstream_answer_changed"
+
+	// BlockListSynced reports a block list file being applied in one go.
+	// Reason gives the list and how many entries it added and removed;
+	// the entries themselves are not published one by one.
+	BlockListSynced Type = "blocklist_synced"
+
+	// BlockListNearCapacity warns that the block list is close to its
+	// configured size limit and blocks may soon be evicted
+	BlockListNearCapacity Type = "blocklist_near_capacity"
+
+	// DomainAttacked reports an attack aimed at a zone (Domain) rather
+	// than at the resolver, e.g. a random subdomain flood
+	DomainAttacked Type = "domain_attacked"
+
+	// SLOBurnRate warns that the latency error budget is being consumed
+	// faster than an alert window allows (Duration is the long window)
+	SLOBurnRate Type = "slo_burn_rate"
+
+	// PacketCaptured reports a finished packet capture of a blocked
+	// source (IP), written to File
+	PacketCaptured Type = "packet_captured"
+
+	// PanicEngaged and PanicCleared report an operator throwing and
+	// releasing the kill switch (Duration is how long it was engaged)
+	PanicEngaged Type = "panic_engaged"
+	PanicCleared Type = "panic_cleared"
+
+	// UpstreamsDown reports every upstream failing for longer than the
+	// emergency threshold, so critical names are resolved by local
+	// recursion; UpstreamsRecovered reports an upstream answering again
+	// (Duration is how long they were down)
+	UpstreamsDown      Type = "upstreams_down"
+	UpstreamsRecovered Type = "upstreams_recovered"
+
+	// SearchDomainStorm advises that a client (IP) multiplies its lookups
+	// by trying each name under its resolver search list, typically a
+	// Kubernetes node with ndots:5. It is a misconfiguration, not an
+	// attack; Domain is the busiest search domain and Reason the advice.
+	SearchDomainStorm Type = "search_domain_storm"
+
+	// WatchDegraded reports resolution of a watched Domain falling below
+	// its objectives (Reason says which, Duration is the window);
+	// WatchRecovered reports it meeting them again (Duration is how long
+	// it was degraded)
+	WatchDegraded  Type = "watch_degraded"
+	WatchRecovered Type = "watch_recovered"
+
+	// BudgetsTightened reports client rate budgets shrinking under server
+	// load, BudgetsRelaxed them growing back as it passes. Reason gives
+	// the load and the budgets' new share of the configured limits;
+	// Duration is how long load stayed low before relaxing.
+	BudgetsTightened Type = "budgets_tightened"
+	BudgetsRelaxed   Type = "budgets_relaxed"
+
+	// ExemptionRequested, ExemptionGranted and ExemptionEnded trace an
+	// operator's rate limit exemption of a network (IP, in CIDR notation)
+	// from its request through approval to its expiry or revocation.
+	// Reason names the exemption and who acted; Duration is the time
+	// left.
+	ExemptionRequested Type = "exemption_requested"
+	ExemptionGranted   Type = "exemption_granted"
+	ExemptionEnded     Type = "exemption_ended"
+
+	// GrantIssued and GrantEnded trace a batch job's budget grant for a
+	// network (IP, in CIDR notation) from its minting to its expiry,
+	// revocation or release by the job. Reason names the grant, job,
+	// factor and window start; Duration is the time left.
+	GrantIssued Type = "grant_issued"
+	GrantEnded  Type = "grant_ended"
+
+	// BlockPropagationSlow reports blocks adopted from a peer's block feed
+	// later than the propagation alert threshold after the peer decided
+	// them. Reason names the peer; Duration is the slowest.
+	BlockPropagationSlow Type = "block_propagation_slow"
+)
+
+// Event describes something that happened, for consumption by logging,
+// notification and other subsystems that must not slow down enforcement
+type Event struct {
+	Type     Type
+	Time     time.Time
+	Instance string // node that published the event
+
+	IP       string
+	Domain   string
+	Reason   string
+	Severity severity.Level
+	Duration time.Duration
+	File     string // file written for the event, e.g. a packet capture
+}
+
+var (
+	published = metrics.NewCounterVec("ddd_events_published_total",
+		"Events published on the event bus", "type")
+	dropped = metrics.NewCounterVec("ddd_events_dropped_total",
+		"Events dropped because a subscriber was not keeping up", "subscriber")
+)
+
+// subscription is a single consumer's buffered channel
+type subscription struct {
+	name string
+	ch   chan Event
+}
+
+// Bus fans events out to subscribers without ever blocking the publisher
+type Bus struct {
+	mu       sync.RWMutex
+	subs     []*subscription
+	instance string
+}
+
+// NewBus creates an event bus
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// SetInstance sets the instance ID stamped on published events
+func (b *Bus) SetInstance(id string) {
+	b.mu.Lock()
+	b.instance = id
+	b.mu.Unlock()
+}
+
+// Subscribe registers a consumer with the given buffer size. Events are
+// dropped (and counted) for this subscriber when its buffer is full.
+func (b *Bus) Subscribe(name string, buffer int) <-chan Event {
+	sub := &subscription{name: name, ch: make(chan Event, buffer)}
+
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	return sub.ch
+}
+
+// Publish delivers e to every subscriber that has room. It is safe to call
+// on a nil bus.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	published.With(string(e.Type)).Inc()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if e.Instance == "" {
+		e.Instance = b.instance
+	}
+
+	for _, sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			dropped.With(sub.name).Inc()
+		}
+	}
+}
+
+// Consume calls fn for each event on ch until ctx is cancelled
+func Consume(ctx context.Context, ch <-chan Event, fn func(Event)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			fn(e)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package federation builds per-node stats snapshots and merges the
+// snapshots of several instances into one cluster-wide view, so any node
+// can answer for the whole deployment.
+package federation
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"ddd/internal/blocker"
+	"ddd/internal/monitor"
+	"ddd/internal/severity"
+)
+
+// Snapshot is one node's current stats
+type Snapshot struct {
+	Node         string    `json:"node"`
+	Time         time.Time `json:"time"`
+	QPS          float64   `json:"qps"`
+	QueriesTotal uint64    `json:"queries_total"`
+	Blocks       []Block   `json:"blocks"`
+	TopTalkers   []Talker  `json:"top_talkers"`
+}
+
+// Block is a blocked client address or prefix
+type Block struct {
+	IP       string         `json:"ip"`
+	Reason   string         `json:"reason"`
+	Severity severity.Level `json:"severity"`
+	Since    time.Time      `json:"since"` // when the blocking node decided it
+	Until    time.Time      `json:"until"`
+}
+
+// Talker is a client and its requests over the last rate window
+type Talker struct {
+	IP       string `json:"ip"`
+	Requests int    `json:"requests"`
+}
+
+// Local builds snapshots of this node
+type Local struct {
+	node    string
+	queries func() uint64
+	blocker *blocker.IPBlocker
+	monitor *monitor.TrafficMonitor
+	talkers int
+}
+
+// NewLocal creates a snapshot source for this node, named node (the
+// hostname when empty). QPS is averaged over the monitor's rate window and
+// at most talkers top talkers are reported.
+func NewLocal(node string, queries func() uint64, b *blocker.IPBlocker, m *monitor.TrafficMonitor, talkers int) *Local {
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	return &Local{node: node, queries: queries, blocker: b, monitor: m, talkers: talkers}
+}
+
+// Node returns the name this node reports under
+func (l *Local) Node() string {
+	return l.node
+}
+
+// Snapshot returns this node's current stats
+func (l *Local) Snapshot() Snapshot {
+	snap := Snapshot{
+		Node:         l.node,
+		Time:         time.Now().UTC(),
+		QueriesTotal: l.queries(),
+		Blocks:       []Block{},
+		TopTalkers:   []Talker{},
+	}
+
+	for _, b := range l.blocker.GetAllBlockedIPs() {
+		snap.Blocks = append(snap.Blocks, Block{
+			IP:       b.IP,
+			Reason:   b.Reason,
+			Severity: b.Severity,
+			Since:    b.BlockedAt.UTC(),
+			Until:    b.BlockUntil.UTC(),
+		})
+	}
+	sort.Slice(snap.Blocks, func(i, j int) bool { return snap.Blocks[i].IP < snap.Blocks[j].IP })
+
+	talkers, total := l.monitor.TopTalkers(l.talkers)
+	for _, t := range talkers {
+		snap.TopTalkers = append(snap.TopTalkers, Talker{IP: t.IP, Requests: t.Requests})
+	}
+	snap.QPS = float64(total) / l.monitor.RateWindow().Seconds()
+
+	return snap
+}
+
+// NodeStatus summarizes one node's part in a cluster view. Error is set
+// when the node could not be reached, in which case its stats are missing
+// from the totals.
+type NodeStatus struct {
+	Node         string    `json:"node"`
+	Time         time.Time `json:"time"`
+	QPS          float64   `json:"qps"`
+	QueriesTotal uint64    `json:"queries_total"`
+	Blocks       int       `json:"blocks"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ClusterBlock is a block and the nodes enforcing it
+type ClusterBlock struct {
+	Block
+	Nodes []string `json:"nodes"`
+}
+
+// View is the merged stats of a cluster
+type View struct {
+	Nodes        []NodeStatus   `json:"nodes"`
+	QPS          float64        `json:"qps"`
+	QueriesTotal uint64         `json:"queries_total"`
+	Blocks       []ClusterBlock `json:"blocks"`
+	TopTalkers   []Talker       `json:"top_talkers"`
+}
+
+// Result is the outcome of fetching one node's snapshot
+type Result struct {
+	Node     string
+	Snapshot *Snapshot
+	Err      error
+}
+
+// Merge combines node snapshots into a cluster view with at most talkers
+// top talkers. Totals and QPS are summed over the nodes that answered. A
+// block appears once, with the latest expiry and every node enforcing it.
+// A talker's requests are summed across nodes; since each node reports
+// only its own top talkers, clients spread thinly over many nodes may be
+// undercounted.
+func Merge(results []Result, talkers int) View {
+	view := View{Nodes: []NodeStatus{}, Blocks: []ClusterBlock{}, TopTalkers: []Talker{}}
+	blocks := make(map[string]*ClusterBlock)
+	requests := make(map[string]int)
+
+	for _, r := range results {
+		status := NodeStatus{Node: r.Node}
+		if r.Err != nil {
+			status.Error = r.Err.Error()
+			view.Nodes = append(view.Nodes, status)
+			continue
+		}
+
+		snap := r.Snapshot
+		status.Time, status.QPS, status.QueriesTotal, status.Blocks = snap.Time, snap.QPS, snap.QueriesTotal, len(snap.Blocks)
+		view.Nodes = append(view.Nodes, status)
+		view.QPS += snap.QPS
+		view.QueriesTotal += snap.QueriesTotal
+
+		for _, b := range snap.Blocks {
+			cb, ok := blocks[b.IP]
+			if !ok {
+				cb = &ClusterBlock{Block: b}
+				blocks[b.IP] = cb
+			} else if b.Until.After(cb.Until) {
+				cb.Block = b
+			}
+			cb.Nodes = append(cb.Nodes, r.Node)
+		}
+		for _, t := range snap.TopTalkers {
+			requests[t.IP] += t.Requests
+		}
+	}
+
+	for _, cb := range blocks {
+		sort.Strings(cb.Nodes)
+		view.Blocks = append(view.Blocks, *cb)
+	}
+	sort.Slice(view.Blocks, func(i, j int) bool { return view.Blocks[i].IP < view.Blocks[j].IP })
+
+	for ip, n := range requests {
+		view.TopTalkers = append(view.TopTalkers, Talker{IP: ip, Requests: n})
+	}
+	sort.Slice(view.TopTalkers, func(i, j int) bool {
+		if view.TopTalkers[i].Requests != view.TopTalkers[j].Requests {
+			return view.TopTalkers[i].Requests > view.TopTalkers[j].Requests
+		}
+		return view.TopTalkers[i].IP < view.TopTalkers[j].IP
+	})
+	if talkers < len(view.TopTalkers) {
+		view.TopTalkers = view.TopTalkers[:talkers]
+	}
+
+	return view
+}
@@ -0,0 +1,53 @@
+package federation
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	now := time.Now()
+	a := &Snapshot{
+		Node: "a", QPS: 10, QueriesTotal: 1000,
+		Blocks: []Block{
+			{IP: "192.0.2.1", Reason: "rate", Until: now.Add(time.Minute)},
+			{IP: "192.0.2.2", Reason: "subdomain", Until: now.Add(time.Minute)},
+		},
+		TopTalkers: []Talker{{IP: "198.51.100.1", Requests: 50}, {IP: "198.51.100.2", Requests: 40}},
+	}
+	b := &Snapshot{
+		Node: "b", QPS: 5, QueriesTotal: 500,
+		Blocks:     []Block{{IP: "192.0.2.1", Reason: "burst", Until: now.Add(time.Hour)}},
+		TopTalkers: []Talker{{IP: "198.51.100.2", Requests: 30}, {IP: "198.51.100.3", Requests: 45}},
+	}
+
+	view := Merge([]Result{
+		{Node: "a", Snapshot: a},
+		{Node: "b", Snapshot: b},
+		{Node: "c", Err: errors.New("timeout")},
+	}, 2)
+
+	if view.QPS != 15 || view.QueriesTotal != 1500 {
+		t.Errorf("Expected totals from reachable nodes only, got %v QPS and %d queries", view.QPS, view.QueriesTotal)
+	}
+	if len(view.Nodes) != 3 || view.Nodes[2].Error != "timeout" || view.Nodes[1].Blocks != 1 {
+		t.Errorf("Expected a status per node, got %+v", view.Nodes)
+	}
+
+	if len(view.Blocks) != 2 {
+		t.Fatalf("Expected the union of 2 blocks, got %+v", view.Blocks)
+	}
+	shared := view.Blocks[0]
+	if shared.IP != "192.0.2.1" || shared.Reason != "burst" || len(shared.Nodes) != 2 {
+		t.Errorf("Expected the shared block with the latest expiry on both nodes, got %+v", shared)
+	}
+	if only := view.Blocks[1]; len(only.Nodes) != 1 || only.Nodes[0] != "a" {
+		t.Errorf("Expected the second block only on node a, got %+v", only)
+	}
+
+	want := []Talker{{IP: "198.51.100.2", Requests: 70}, {IP: "198.51.100.1", Requests: 50}}
+	if len(view.TopTalkers) != 2 || view.TopTalkers[0] != want[0] || view.TopTalkers[1] != want[1] {
+		t.Errorf("Expected talkers summed across nodes, got %+v", view.TopTalkers)
+	}
+}
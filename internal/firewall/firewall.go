@@ -0,0 +1,132 @@
+// Package firewall evaluates operator rules written in a small expression
+// language against each DNS request, so custom policies need no code
+// changes. A rule is a condition and an action:
+//
+//	qtype == "TXT" && len(qname) > 100 && country == "XX" => rate_limit
+//
+// Rules are compiled once at startup and the first matching rule wins.
+package firewall
+
+import (
+	"fmt"
+
+	"ddd/internal/geoip"
+)
+
+// Action is what happens to a request matching a rule
+type Action int
+
+const (
+	None      Action = iota // no rule matched
+	Allow                   // skip attack detection for this request
+	RateLimit               // rate limit the client and answer normally
+	Refuse                  // answer REFUSED
+	NXDomain                // answer NXDOMAIN
+	Drop                    // send no answer
+	Block                   // block the client and answer REFUSED
+)
+
+// actionNames maps the names used in rules to actions
+var actionNames = map[string]Action{
+	"allow":      Allow,
+	"rate_limit": RateLimit,
+	"refuse":     Refuse,
+	"nxdomain":   NXDomain,
+	"drop":       Drop,
+	"block":      Block,
+}
+
+// ParseAction returns the action written as name in rules
+func ParseAction(name string) (Action, bool) {
+	action, ok := actionNames[name]
+	return action, ok
+}
+
+// String returns the action's name as written in rules
+func (a Action) String() string {
+	for name, action := range actionNames {
+		if action == a {
+			return name
+		}
+	}
+	return "none"
+}
+
+// Request holds the attributes rules can inspect
+type Request struct {
+	Client string // client IP address
+	QName  string // lowercase, without the trailing dot
+	QType  string // e.g. "A", "TXT"
+}
+
+// Rule is a compiled firewall rule
+type Rule struct {
+	Source string
+	Action Action
+	cond   func(*env) bool
+}
+
+// env is the evaluation state for one request. The client's location is
+// looked up only if a rule asks for it.
+type env struct {
+	req     *Request
+	geo     *geoip.DB
+	loc     geoip.Location
+	located bool
+}
+
+// location returns the client's location, or a zero location when no
+// database is configured or the address is not in it
+func (e *env) location() geoip.Location {
+	if !e.located {
+		e.located = true
+		if e.geo != nil {
+			e.loc, _ = e.geo.Lookup(e.req.Client)
+		}
+	}
+	return e.loc
+}
+
+// Engine evaluates an ordered list of rules
+type Engine struct {
+	rules []Rule
+	geo   *geoip.DB
+}
+
+// NewEngine compiles rules. geo resolves the country and asn fields and may
+// be nil, in which case they are always empty.
+func NewEngine(rules []string, geo *geoip.DB) (*Engine, error) {
+	e := &Engine{geo: geo}
+	for i, src := range rules {
+		rule, err := parseRule(src)
+		if err != nil {
+			return nil, fmt.Errorf("firewall rule %d: %w", i, err)
+		}
+		e.rules = append(e.rules, rule)
+	}
+	return e, nil
+}
+
+// Len returns the number of rules
+func (e *Engine) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.rules)
+}
+
+// Evaluate returns the action of the first rule matching req and that
+// rule's index, or None and -1. It is safe to call on a nil engine.
+func (e *Engine) Evaluate(req Request) (Action, int) {
+	if e == nil || len(e.rules) == 0 {
+		return None, -1
+	}
+
+	state := &env{req: &req, geo: e.geo}
+	for i, rule := range e.rules {
+		if rule.cond(state) {
+			return rule.Action, i
+		}
+	}
+	return None, -1
+}
@@ -0,0 +1,111 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+
+	"ddd/internal/geoip"
+)
+
+func TestEvaluate(t *testing.T) {
+	if !geoip.Available {
+		t.Skip("built without the GeoIP database")
+	}
+	db, err := geoip.Read(strings.NewReader("192.0.2.0/24,XX,64500,EXAMPLE\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := NewEngine([]string{
+		`cidr(client, "10.0.0.0/8") => allow`,
+		`qtype == "TXT" && len(qname) > 40 && country == "XX" => rate_limit`,
+		`qtype in ["ANY", "AXFR"] || asn == 64511 => refuse`,
+		`qname =~ "^[a-z0-9]{20,}\\." && !suffix(qname, ".example.com") => nxdomain`,
+		`labels(qname) >= 8 => drop`,
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("a", 40) + ".example.org"
+	tests := []struct {
+		req  Request
+		want Action
+		rule int
+	}{
+		{Request{Client: "10.1.2.3", QName: long, QType: "TXT"}, Allow, 0},
+		{Request{Client: "192.0.2.9", QName: long, QType: "TXT"}, RateLimit, 1},
+		{Request{Client: "203.0.113.1", QName: long, QType: "TXT"}, NXDomain, 3},
+		{Request{Client: "203.0.113.1", QName: "example.org", QType: "ANY"}, Refuse, 2},
+		{Request{Client: "203.0.113.1", QName: long, QType: "A"}, NXDomain, 3},
+		{Request{Client: "203.0.113.1", QName: "a1b2c3d4e5f6g7h8i9j0.example.com", QType: "A"}, None, -1},
+		{Request{Client: "203.0.113.1", QName: "a.b.c.d.e.f.g.h", QType: "A"}, Drop, 4},
+	}
+	for _, tt := range tests {
+		action, rule := engine.Evaluate(tt.req)
+		if action != tt.want || rule != tt.rule {
+			t.Errorf("Evaluate(%+v) = %s (rule %d), want %s (rule %d)", tt.req, action, rule, tt.want, tt.rule)
+		}
+	}
+
+	var none *Engine
+	if action, _ := none.Evaluate(tests[0].req); action != None {
+		t.Errorf("Expected a nil engine to match nothing, got %s", action)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, rule := range []string{
+		`qtype == "TXT"`,
+		`qtype == "TXT" => explode`,
+		`qtype > "A" => drop`,
+		`len(qname) == "long" => drop`,
+		`qname => drop`,
+		`nope == 1 => drop`,
+		`qname =~ "(" => drop`,
+		`cidr(client, "not a network") => block`,
+		`qtype in ["A", 1] => drop`,
+		`(qtype == "A" => drop`,
+		`qtype == "A" => drop drop`,
+		`qtype == "unterminated => drop`,
+	} {
+		if _, err := NewEngine([]string{rule}, nil); err == nil {
+			t.Errorf("Expected %q to be rejected", rule)
+		}
+	}
+}
+
+func TestTallyQueryLog(t *testing.T) {
+	rules, err := ReadRules(strings.NewReader("# policies\nqtype == \"TXT\" => refuse\n\nsuffix(qname, \".test\") => drop\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewEngine(rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := strings.Join([]string{
+		`{"msg":"DNS Query","client_ip":"192.0.2.1","domain":"A.example.test","query_type":"A","event":"dns_query"}`,
+		`{"msg":"DNS Query","client_ip":"192.0.2.2","domain":"a.example.test","query_type":"A","event":"dns_query"}`,
+		`{"msg":"DNS Query","client_ip":"192.0.2.1","domain":"example.org","query_type":"TXT","event":"dns_query"}`,
+		`{"msg":"DNS Query","client_ip":"192.0.2.1","domain":"example.org","query_type":"A","event":"dns_query"}`,
+		`{"msg":"Attack detected","ip":"192.0.2.1","event":"attack"}`,
+		`not json`,
+	}, "\n")
+
+	tally := engine.NewTally()
+	if err := ReadQueryLog(strings.NewReader(log), tally.Add); err != nil {
+		t.Fatal(err)
+	}
+
+	if tally.Total != 4 || tally.Unmatched != 1 {
+		t.Errorf("Expected 4 queries with 1 unmatched, got %d and %d", tally.Total, tally.Unmatched)
+	}
+	if r := tally.Rules[0]; r.Matches != 1 || r.Clients != 1 {
+		t.Errorf("Expected one TXT query, got %+v", r)
+	}
+	if r := tally.Rules[1]; r.Matches != 2 || r.Clients != 2 || len(r.Examples) != 1 {
+		t.Errorf("Expected two queries for one name from two clients, got %+v", r)
+	}
+}
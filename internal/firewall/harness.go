@@ -0,0 +1,106 @@
+package firewall
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// maxExamples is how many distinct names a tally keeps per rule
+const maxExamples = 5
+
+// RuleTally is how much of a sample one rule matched
+type RuleTally struct {
+	Rule     Rule
+	Matches  int
+	Clients  int      // distinct client addresses
+	Examples []string // first few distinct names matched
+}
+
+// Tally is the outcome of evaluating rules against a sample of queries
+type Tally struct {
+	Total     int
+	Unmatched int
+	Rules     []RuleTally
+
+	engine   *Engine
+	clients  []map[string]struct{}
+	examples []map[string]struct{}
+}
+
+// NewTally creates an empty tally for the rules of e
+func (e *Engine) NewTally() *Tally {
+	t := &Tally{
+		Rules:    make([]RuleTally, len(e.rules)),
+		engine:   e,
+		clients:  make([]map[string]struct{}, len(e.rules)),
+		examples: make([]map[string]struct{}, len(e.rules)),
+	}
+	for i, rule := range e.rules {
+		t.Rules[i].Rule = rule
+		t.clients[i] = make(map[string]struct{})
+		t.examples[i] = make(map[string]struct{})
+	}
+	return t
+}
+
+// Add evaluates req and counts the rule it matches
+func (t *Tally) Add(req Request) {
+	t.Total++
+	_, i := t.engine.Evaluate(req)
+	if i < 0 {
+		t.Unmatched++
+		return
+	}
+
+	rt := &t.Rules[i]
+	rt.Matches++
+	if _, seen := t.clients[i][req.Client]; !seen {
+		t.clients[i][req.Client] = struct{}{}
+		rt.Clients++
+	}
+	if _, seen := t.examples[i][req.QName]; !seen && len(rt.Examples) < maxExamples {
+		t.examples[i][req.QName] = struct{}{}
+		rt.Examples = append(rt.Examples, req.QName)
+	}
+}
+
+// ReadRules reads one rule per line. Blank lines and lines starting with #
+// are skipped.
+func ReadRules(r io.Reader) ([]string, error) {
+	var rules []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules, scanner.Err()
+}
+
+// ReadQueryLog calls fn for each query logged by the server in its JSON
+// log format. Other entries and lines that are not JSON are skipped.
+func ReadQueryLog(r io.Reader, fn func(Request)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Event    string `json:"event"`
+			ClientIP string `json:"client_ip"`
+			Domain   string `json:"domain"`
+			Type     string `json:"query_type"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Event != "dns_query" {
+			continue
+		}
+		fn(Request{
+			Client: entry.ClientIP,
+			QName:  strings.ToLower(strings.TrimSuffix(entry.Domain, ".")),
+			QType:  entry.Type,
+		})
+	}
+	return scanner.Err()
+}
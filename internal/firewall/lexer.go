@@ -0,0 +1,95 @@
+package firewall
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenType classifies lexer tokens
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct // operators and brackets; the text says which
+)
+
+// token is one lexeme of a rule
+type token struct {
+	typ  tokenType
+	text string // unquoted for strings
+	num  int64
+	pos  int // 1-based column
+}
+
+// puncts lists operators, longest first so that "<=" wins over "<"
+var puncts = []string{"=>", "==", "!=", "<=", ">=", "=~", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// lex splits a rule into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("col %d: unterminated string", i+1)
+			}
+			text, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("col %d: %w", i+1, err)
+			}
+			tokens = append(tokens, token{typ: tokString, text: text, pos: i + 1})
+			i = end + 1
+
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(src) && src[end] >= '0' && src[end] <= '9' {
+				end++
+			}
+			n, err := strconv.ParseInt(src[i:end], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("col %d: %w", i+1, err)
+			}
+			tokens = append(tokens, token{typ: tokNumber, text: src[i:end], num: n, pos: i + 1})
+			i = end
+
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := i
+			for end < len(src) && (src[end] == '_' || src[end] >= 'a' && src[end] <= 'z' ||
+				src[end] >= 'A' && src[end] <= 'Z' || src[end] >= '0' && src[end] <= '9') {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokIdent, text: src[i:end], pos: i + 1})
+			i = end
+
+		default:
+			matched := false
+			for _, p := range puncts {
+				if strings.HasPrefix(src[i:], p) {
+					tokens = append(tokens, token{typ: tokPunct, text: p, pos: i + 1})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("col %d: unexpected %q", i+1, c)
+			}
+		}
+	}
+	return append(tokens, token{typ: tokEOF, pos: len(src) + 1}), nil
+}
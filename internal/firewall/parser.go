@@ -0,0 +1,416 @@
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// kind is the type of an expression
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindBool
+)
+
+func (k kind) String() string {
+	return [...]string{"string", "int", "bool"}[k]
+}
+
+// node is a compiled expression. Exactly one of str, num and cond is set,
+// according to kind; constants also keep their value for operators that
+// need it at compile time.
+type node struct {
+	kind kind
+	str  func(*env) string
+	num  func(*env) int64
+	cond func(*env) bool
+
+	constant bool
+	text     string
+	value    int64
+}
+
+// fields are the request attributes a rule can inspect
+var fields = map[string]node{
+	"qname":   {kind: kindString, str: func(e *env) string { return e.req.QName }},
+	"qtype":   {kind: kindString, str: func(e *env) string { return e.req.QType }},
+	"client":  {kind: kindString, str: func(e *env) string { return e.req.Client }},
+	"country": {kind: kindString, str: func(e *env) string { return e.location().Country }},
+	"asn":     {kind: kindInt, num: func(e *env) int64 { return int64(e.location().ASN) }},
+	"true":    {kind: kindBool, cond: func(*env) bool { return true }},
+	"false":   {kind: kindBool, cond: func(*env) bool { return false }},
+}
+
+// parser builds a rule from its tokens by recursive descent:
+//
+//	rule       = or "=>" action
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = primary [ op primary | "in" "[" constants "]" ]
+//	primary    = string | number | field | func "(" args ")" | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseRule compiles one rule
+func parseRule(src string) (Rule, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return Rule{}, err
+	}
+	p := &parser{tokens: tokens}
+
+	cond, err := p.parseOr()
+	if err != nil {
+		return Rule{}, err
+	}
+	if cond.kind != kindBool {
+		return Rule{}, fmt.Errorf("condition is a %s, not a bool", cond.kind)
+	}
+	if err := p.expect("=>"); err != nil {
+		return Rule{}, err
+	}
+
+	name := p.next()
+	action, ok := actionNames[name.text]
+	if name.typ != tokIdent || !ok {
+		return Rule{}, fmt.Errorf("col %d: unknown action %q", name.pos, name.text)
+	}
+	if end := p.next(); end.typ != tokEOF {
+		return Rule{}, fmt.Errorf("col %d: unexpected %q after action", end.pos, end.text)
+	}
+
+	return Rule{Source: src, Action: action, cond: cond.cond}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the punctuation or keyword text if it comes next
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.typ == tokPunct || t.typ == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("col %d: expected %q, got %q", t.pos, text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().text == "||" {
+		pos := p.next().pos
+		right, err := p.parseAnd()
+		if err != nil {
+			return node{}, err
+		}
+		if left.kind != kindBool || right.kind != kindBool {
+			return node{}, fmt.Errorf("col %d: || needs bool operands", pos)
+		}
+		l, r := left.cond, right.cond
+		left = node{kind: kindBool, cond: func(e *env) bool { return l(e) || r(e) }}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().text == "&&" {
+		pos := p.next().pos
+		right, err := p.parseUnary()
+		if err != nil {
+			return node{}, err
+		}
+		if left.kind != kindBool || right.kind != kindBool {
+			return node{}, fmt.Errorf("col %d: && needs bool operands", pos)
+		}
+		l, r := left.cond, right.cond
+		left = node{kind: kindBool, cond: func(e *env) bool { return l(e) && r(e) }}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if t := p.peek(); t.typ == tokPunct && t.text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return node{}, err
+		}
+		if operand.kind != kindBool {
+			return node{}, fmt.Errorf("col %d: ! needs a bool operand", t.pos)
+		}
+		c := operand.cond
+		return node{kind: kindBool, cond: func(e *env) bool { return !c(e) }}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return node{}, err
+	}
+
+	op := p.peek()
+	switch {
+	case op.typ == tokIdent && op.text == "in":
+		p.next()
+		return p.parseIn(left, op.pos)
+	case op.typ != tokPunct:
+		return left, nil
+	}
+
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=", "=~":
+		p.next()
+	default:
+		return left, nil
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return node{}, err
+	}
+
+	if op.text == "=~" {
+		if left.kind != kindString || !right.constant || right.kind != kindString {
+			return node{}, fmt.Errorf("col %d: =~ needs a string and a constant pattern", op.pos)
+		}
+		re, err := regexp.Compile(right.text)
+		if err != nil {
+			return node{}, fmt.Errorf("col %d: %w", op.pos, err)
+		}
+		s := left.str
+		return node{kind: kindBool, cond: func(e *env) bool { return re.MatchString(s(e)) }}, nil
+	}
+
+	if left.kind != right.kind || left.kind == kindBool {
+		return node{}, fmt.Errorf("col %d: cannot compare %s %s %s", op.pos, left.kind, op.text, right.kind)
+	}
+	if left.kind == kindString {
+		return compareStrings(op, left.str, right.str)
+	}
+	return compareInts(op, left.num, right.num), nil
+}
+
+// compareStrings compiles a string equality test; strings do not order
+func compareStrings(op token, l, r func(*env) string) (node, error) {
+	switch op.text {
+	case "==":
+		return node{kind: kindBool, cond: func(e *env) bool { return l(e) == r(e) }}, nil
+	case "!=":
+		return node{kind: kindBool, cond: func(e *env) bool { return l(e) != r(e) }}, nil
+	}
+	return node{}, fmt.Errorf("col %d: strings support == and != only", op.pos)
+}
+
+// compareInts compiles an integer comparison
+func compareInts(op token, l, r func(*env) int64) node {
+	var cond func(e *env) bool
+	switch op.text {
+	case "==":
+		cond = func(e *env) bool { return l(e) == r(e) }
+	case "!=":
+		cond = func(e *env) bool { return l(e) != r(e) }
+	case "<":
+		cond = func(e *env) bool { return l(e) < r(e) }
+	case "<=":
+		cond = func(e *env) bool { return l(e) <= r(e) }
+	case ">":
+		cond = func(e *env) bool { return l(e) > r(e) }
+	case ">=":
+		cond = func(e *env) bool { return l(e) >= r(e) }
+	}
+	return node{kind: kindBool, cond: cond}
+}
+
+// parseIn compiles a membership test against a list of constants
+func (p *parser) parseIn(left node, pos int) (node, error) {
+	if left.kind == kindBool {
+		return node{}, fmt.Errorf("col %d: in needs a string or int", pos)
+	}
+	if err := p.expect("["); err != nil {
+		return node{}, err
+	}
+
+	strs := make(map[string]bool)
+	nums := make(map[int64]bool)
+	for !p.accept("]") {
+		if len(strs)+len(nums) > 0 {
+			if err := p.expect(","); err != nil {
+				return node{}, err
+			}
+		}
+		item := p.next()
+		switch {
+		case left.kind == kindString && item.typ == tokString:
+			strs[item.text] = true
+		case left.kind == kindInt && item.typ == tokNumber:
+			nums[item.num] = true
+		default:
+			return node{}, fmt.Errorf("col %d: list items must be %s constants", item.pos, left.kind)
+		}
+	}
+
+	if left.kind == kindString {
+		s := left.str
+		return node{kind: kindBool, cond: func(e *env) bool { return strs[s(e)] }}, nil
+	}
+	n := left.num
+	return node{kind: kindBool, cond: func(e *env) bool { return nums[n(e)] }}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.typ {
+	case tokString:
+		s := t.text
+		return node{kind: kindString, str: func(*env) string { return s }, constant: true, text: s}, nil
+
+	case tokNumber:
+		n := t.num
+		return node{kind: kindInt, num: func(*env) int64 { return n }, constant: true, value: n}, nil
+
+	case tokIdent:
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		field, ok := fields[t.text]
+		if !ok {
+			return node{}, fmt.Errorf("col %d: unknown field %q", t.pos, t.text)
+		}
+		return field, nil
+
+	case tokPunct:
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return node{}, err
+			}
+			return inner, p.expect(")")
+		}
+	}
+
+	if t.typ == tokEOF {
+		return node{}, fmt.Errorf("col %d: unexpected end of rule", t.pos)
+	}
+	return node{}, fmt.Errorf("col %d: unexpected %q", t.pos, t.text)
+}
+
+// parseCall compiles a function call whose opening parenthesis has been
+// consumed
+func (p *parser) parseCall(name token) (node, error) {
+	var args []node
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return node{}, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return node{}, err
+		}
+		args = append(args, arg)
+	}
+
+	sig := func(kinds ...kind) error {
+		if len(args) != len(kinds) {
+			return fmt.Errorf("col %d: %s takes %d arguments", name.pos, name.text, len(kinds))
+		}
+		for i, k := range kinds {
+			if args[i].kind != k {
+				return fmt.Errorf("col %d: %s argument %d must be a %s", name.pos, name.text, i+1, k)
+			}
+		}
+		return nil
+	}
+
+	switch name.text {
+	case "len":
+		if err := sig(kindString); err != nil {
+			return node{}, err
+		}
+		s := args[0].str
+		return node{kind: kindInt, num: func(e *env) int64 { return int64(len(s(e))) }}, nil
+
+	case "lower":
+		if err := sig(kindString); err != nil {
+			return node{}, err
+		}
+		s := args[0].str
+		return node{kind: kindString, str: func(e *env) string { return strings.ToLower(s(e)) }}, nil
+
+	case "labels":
+		if err := sig(kindString); err != nil {
+			return node{}, err
+		}
+		s := args[0].str
+		return node{kind: kindInt, num: func(e *env) int64 {
+			if v := s(e); v != "" {
+				return int64(strings.Count(v, ".") + 1)
+			}
+			return 0
+		}}, nil
+
+	case "prefix", "suffix", "contains":
+		if err := sig(kindString, kindString); err != nil {
+			return node{}, err
+		}
+		match := map[string]func(string, string) bool{
+			"prefix":   strings.HasPrefix,
+			"suffix":   strings.HasSuffix,
+			"contains": strings.Contains,
+		}[name.text]
+		s, sub := args[0].str, args[1].str
+		return node{kind: kindBool, cond: func(e *env) bool { return match(s(e), sub(e)) }}, nil
+
+	case "cidr":
+		if err := sig(kindString, kindString); err != nil {
+			return node{}, err
+		}
+		if !args[1].constant {
+			return node{}, fmt.Errorf("col %d: cidr needs a constant network", name.pos)
+		}
+		network, err := netip.ParsePrefix(args[1].text)
+		if err != nil {
+			return node{}, fmt.Errorf("col %d: %w", name.pos, err)
+		}
+		s := args[0].str
+		return node{kind: kindBool, cond: func(e *env) bool {
+			addr, err := netip.ParseAddr(s(e))
+			return err == nil && network.Contains(addr.Unmap())
+		}}, nil
+	}
+
+	return node{}, fmt.Errorf("col %d: unknown function %q", name.pos, name.text)
+}
@@ -0,0 +1,142 @@
+//go:build geoip
+
+// Package geoip maps client addresses to countries and autonomous systems
+// and aggregates query and attack counts by location over time. It is
+// built in only with the geoip tag.
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ddd/internal/buildinfo"
+)
+
+// Available reports whether the GeoIP database is built in
+const Available = true
+
+func init() {
+	buildinfo.Register("geoip")
+}
+
+// Location is where an address is registered
+type Location struct {
+	Country string // ISO 3166 alpha-2 code
+	ASN     uint32
+	ASName  string
+}
+
+// span is one network of the database as an address range
+type span struct {
+	first, last netip.Addr
+	loc         Location
+}
+
+// DB is an in-memory GeoIP database
+type DB struct {
+	spans []span // sorted by first address
+}
+
+// Load reads a CSV database with one network per line:
+//
+//	network,country,asn,as_name
+//	1.0.0.0/24,AU,13335,CLOUDFLARENET
+//
+// A header line and lines starting with # are skipped. Networks should
+// not overlap; where they do, the one starting last wins.
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read parses a CSV database as described for Load
+func Read(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+
+	db := &DB{}
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && record[0] == "network" {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: need at least network and country", line)
+		}
+
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		loc := Location{Country: strings.ToUpper(strings.TrimSpace(record[1]))}
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(record[2]), "AS"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: asn: %w", line, err)
+			}
+			loc.ASN = uint32(asn)
+		}
+		if len(record) > 3 {
+			loc.ASName = strings.TrimSpace(record[3])
+		}
+
+		prefix = prefix.Masked()
+		db.spans = append(db.spans, span{first: prefix.Addr(), last: lastAddr(prefix), loc: loc})
+	}
+
+	sort.Slice(db.spans, func(i, j int) bool { return db.spans[i].first.Less(db.spans[j].first) })
+	return db, nil
+}
+
+// Len returns the number of networks in the database
+func (db *DB) Len() int {
+	return len(db.spans)
+}
+
+// Lookup returns the location of ip
+func (db *DB) Lookup(ip string) (Location, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	addr = addr.Unmap()
+
+	// The last network starting at or before addr
+	i := sort.Search(len(db.spans), func(i int) bool { return addr.Less(db.spans[i].first) }) - 1
+	if i < 0 || db.spans[i].last.Less(addr) || db.spans[i].first.BitLen() != addr.BitLen() {
+		return Location{}, false
+	}
+	return db.spans[i].loc, true
+}
+
+// lastAddr returns the highest address within prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+	for i := range b {
+		for bit := 0; bit < 8; bit++ {
+			if i*8+bit >= bits {
+				b[i] |= 0x80 >> bit
+			}
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,44 @@
+//go:build !geoip
+
+package geoip
+
+import (
+	"errors"
+	"io"
+)
+
+// Available reports whether the GeoIP database is built in
+const Available = false
+
+// ErrDisabled means the binary was built without the GeoIP database
+var ErrDisabled = errors.New("geoip: not built in (build with the geoip tag)")
+
+// Location is where an address is registered
+type Location struct {
+	Country string // ISO 3166 alpha-2 code
+	ASN     uint32
+	ASName  string
+}
+
+// DB stands in for the database left out of this build
+type DB struct{}
+
+// Load always fails; the GeoIP database is not built in
+func Load(path string) (*DB, error) {
+	return nil, ErrDisabled
+}
+
+// Read always fails; the GeoIP database is not built in
+func Read(r io.Reader) (*DB, error) {
+	return nil, ErrDisabled
+}
+
+// Len returns 0
+func (db *DB) Len() int {
+	return 0
+}
+
+// Lookup finds nothing; the GeoIP database is not built in
+func (db *DB) Lookup(ip string) (Location, bool) {
+	return Location{}, false
+}
@@ -0,0 +1,87 @@
+//go:build geoip
+
+package geoip
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDB = `network,country,asn,as_name
+# documentation ranges
+192.0.2.0/24,au,AS64500,EXAMPLE-AU
+198.51.100.0/25,DE,64501,EXAMPLE-DE
+2001:db8::/32,NL,64502,EXAMPLE-NL
+`
+
+func TestLookup(t *testing.T) {
+	db, err := Read(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 3 {
+		t.Fatalf("Expected 3 networks, got %d", db.Len())
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+		asn     uint32
+		ok      bool
+	}{
+		{"192.0.2.77", "AU", 64500, true},
+		{"198.51.100.127", "DE", 64501, true},
+		{"198.51.100.128", "", 0, false},
+		{"::ffff:192.0.2.1", "AU", 64500, true},
+		{"2001:db8:1::53", "NL", 64502, true},
+		{"203.0.113.1", "", 0, false},
+		{"not an ip", "", 0, false},
+	}
+	for _, tt := range tests {
+		loc, ok := db.Lookup(tt.ip)
+		if ok != tt.ok || loc.Country != tt.country || loc.ASN != tt.asn {
+			t.Errorf("Lookup(%s) = %+v, %v; want %s AS%d, %v", tt.ip, loc, ok, tt.country, tt.asn, tt.ok)
+		}
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	db, err := Read(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHeatmap(db, time.Minute, time.Hour, 1)
+
+	now := time.Now()
+	earlier := now.Add(-10 * time.Minute)
+	inc := func(c *Counts) { c.Queries++ }
+	h.record("192.0.2.1", earlier, inc)
+	h.record("192.0.2.1", now, inc)
+	h.record("198.51.100.1", now, inc)
+	h.record("203.0.113.1", now, func(c *Counts) { c.Attacks++ })
+
+	snap := h.Snapshot(now.Add(-time.Hour))
+	if snap.BucketSeconds != 60 || len(snap.Buckets) != 2 {
+		t.Fatalf("Expected two one-minute buckets, got %+v", snap)
+	}
+
+	latest := snap.Buckets[1]
+	if latest.Countries["AU"].Queries != 1 || latest.Countries["DE"].Queries != 1 {
+		t.Errorf("Expected one query each from AU and DE, got %+v", latest.Countries)
+	}
+	if latest.Countries[Unknown].Attacks != 1 {
+		t.Errorf("Expected the unlisted address counted as unknown, got %+v", latest.Countries)
+	}
+	// With one system tracked per bucket, the second is aggregated as ASN 0
+	if len(latest.ASNs) != 2 || latest.ASNs[0].ASN+latest.ASNs[1].ASN != 64500 {
+		t.Errorf("Expected AS64500 and the overflow entry, got %+v", latest.ASNs)
+	}
+
+	if recent := h.Snapshot(now.Add(-time.Minute)); len(recent.Buckets) != 1 {
+		t.Errorf("Expected only the latest bucket, got %d", len(recent.Buckets))
+	}
+
+	var nilMap *Heatmap
+	nilMap.RecordQuery("192.0.2.1")
+}
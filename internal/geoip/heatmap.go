@@ -0,0 +1,164 @@
+package geoip
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Unknown is the country reported for addresses missing from the database
+const Unknown = "unknown"
+
+// Counts are the queries and attacks seen from one location
+type Counts struct {
+	Queries uint64 `json:"queries"`
+	Attacks uint64 `json:"attacks"`
+}
+
+// ASNCounts are the counts for one autonomous system
+type ASNCounts struct {
+	ASN     uint32 `json:"asn"` // 0 aggregates systems beyond the per-bucket limit
+	Name    string `json:"name,omitempty"`
+	Country string `json:"country,omitempty"`
+	Counts
+}
+
+// Bucket is one time slice of a snapshot
+type Bucket struct {
+	Start     time.Time         `json:"start"`
+	Countries map[string]Counts `json:"countries"`
+	ASNs      []ASNCounts       `json:"asns"` // busiest first
+}
+
+// Snapshot is the heat map over a time range, oldest bucket first
+type Snapshot struct {
+	BucketSeconds int      `json:"bucket_seconds"`
+	Buckets       []Bucket `json:"buckets"`
+}
+
+// slot accumulates one bucket
+type slot struct {
+	start     int64 // unix seconds; 0 when unused
+	countries map[string]*Counts
+	asns      map[uint32]*ASNCounts
+}
+
+// Heatmap aggregates query and attack counts by country and ASN in fixed
+// time buckets
+type Heatmap struct {
+	db      *DB
+	width   time.Duration
+	maxASNs int
+
+	mu    sync.Mutex
+	slots []slot
+}
+
+// NewHeatmap creates a heat map of width buckets kept for retention,
+// tracking at most maxASNs systems per bucket (0 means unlimited)
+func NewHeatmap(db *DB, width, retention time.Duration, maxASNs int) *Heatmap {
+	n := int(retention / width)
+	if n < 1 {
+		n = 1
+	}
+	return &Heatmap{
+		db:      db,
+		width:   width,
+		maxASNs: maxASNs,
+		slots:   make([]slot, n),
+	}
+}
+
+// RecordQuery counts a query from ip. It is safe to call on a nil heat map.
+func (h *Heatmap) RecordQuery(ip string) {
+	h.record(ip, time.Now(), func(c *Counts) { c.Queries++ })
+}
+
+// RecordAttack counts a detected attack from ip. It is safe to call on a
+// nil heat map.
+func (h *Heatmap) RecordAttack(ip string) {
+	h.record(ip, time.Now(), func(c *Counts) { c.Attacks++ })
+}
+
+// record applies inc to the counts for ip's country and ASN
+func (h *Heatmap) record(ip string, now time.Time, inc func(*Counts)) {
+	if h == nil {
+		return
+	}
+	loc, ok := h.db.Lookup(ip)
+	if !ok || loc.Country == "" {
+		loc.Country = Unknown
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.slotLocked(now)
+	country := s.countries[loc.Country]
+	if country == nil {
+		country = &Counts{}
+		s.countries[loc.Country] = country
+	}
+	inc(country)
+
+	if loc.ASN == 0 {
+		return
+	}
+	asn := s.asns[loc.ASN]
+	if asn == nil {
+		if h.maxASNs > 0 && len(s.asns) >= h.maxASNs {
+			loc = Location{}
+			asn = s.asns[0]
+		}
+		if asn == nil {
+			asn = &ASNCounts{ASN: loc.ASN, Name: loc.ASName, Country: loc.Country}
+			s.asns[loc.ASN] = asn
+		}
+	}
+	inc(&asn.Counts)
+}
+
+// slotLocked returns the slot for now, recycling it if it holds an older
+// bucket. h.mu must be held.
+func (h *Heatmap) slotLocked(now time.Time) *slot {
+	start := now.Truncate(h.width).Unix()
+	s := &h.slots[int(start/int64(h.width/time.Second))%len(h.slots)]
+	if s.start != start {
+		s.start = start
+		s.countries = make(map[string]*Counts)
+		s.asns = make(map[uint32]*ASNCounts)
+	}
+	return s
+}
+
+// Snapshot returns the buckets covering the time from since to now
+func (h *Heatmap) Snapshot(since time.Time) Snapshot {
+	snap := Snapshot{BucketSeconds: int(h.width / time.Second), Buckets: []Bucket{}}
+	oldest := since.Truncate(h.width).Unix()
+	now := time.Now().Unix()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.slots {
+		if s.start == 0 || s.start < oldest || s.start > now {
+			continue
+		}
+		b := Bucket{
+			Start:     time.Unix(s.start, 0).UTC(),
+			Countries: make(map[string]Counts, len(s.countries)),
+			ASNs:      make([]ASNCounts, 0, len(s.asns)),
+		}
+		for country, c := range s.countries {
+			b.Countries[country] = *c
+		}
+		for _, a := range s.asns {
+			b.ASNs = append(b.ASNs, *a)
+		}
+		sort.Slice(b.ASNs, func(i, j int) bool { return b.ASNs[i].Queries > b.ASNs[j].Queries })
+		snap.Buckets = append(snap.Buckets, b)
+	}
+
+	sort.Slice(snap.Buckets, func(i, j int) bool { return snap.Buckets[i].Start.Before(snap.Buckets[j].Start) })
+	return snap
+}
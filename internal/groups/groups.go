@@ -0,0 +1,193 @@
+// Package groups assigns clients to the operator's client groups, each
+// with its own detector thresholds and mitigation policy: an office range
+// allowed a higher query rate, a tenant watched but never blocked, or a
+// reputation feed of bad ranges held to stricter limits. A client belongs
+// to the group listing its TLS fingerprint, or else the group with the
+// most specific CIDR containing it; clients in no group get the top-level
+// settings.
+package groups
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"ddd/internal/blocker"
+	"ddd/internal/config"
+	"ddd/internal/detector"
+)
+
+// Group is a client group with its resolved settings
+type Group struct {
+	Name       string
+	Tenant     string
+	Mitigation string // config.MitigationBlock, MitigationRateLimit or MitigationMonitor
+	Detector   *detector.DDoSDetector
+}
+
+// Set matches clients to groups. A nil Set matches nothing.
+type Set struct {
+	groups       []*Group
+	fingerprints map[string]*Group
+	table        atomic.Pointer[table]
+
+	// The CIDRs of each group, as configured and as listed in its
+	// cidr_file, from which the table is rebuilt when a file changes
+	mu     sync.Mutex
+	cidrs  map[*Group][]string
+	listed map[*Group][]string
+}
+
+// table maps the groups' prefixes to them; it is replaced whole
+type table struct {
+	lengths  []int // distinct prefix lengths, longest first
+	prefixes map[netip.Prefix]*Group
+}
+
+// buildTable maps the prefixes of every group, in configuration order
+func (s *Set) buildTable() (*table, error) {
+	t := &table{prefixes: make(map[netip.Prefix]*Group)}
+	lengths := make(map[int]bool)
+	for _, g := range s.groups {
+		for _, cidr := range append(append([]string(nil), s.cidrs[g]...), s.listed[g]...) {
+			prefix, err := config.ParseGroupCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("groups %s: %w", g.Name, err)
+			}
+			if other, ok := t.prefixes[prefix]; ok && other != g {
+				return nil, fmt.Errorf("groups %s: %s is already in group %s", g.Name, prefix, other.Name)
+			}
+			t.prefixes[prefix] = g
+			lengths[prefix.Bits()] = true
+		}
+	}
+	for bits := range lengths {
+		t.lengths = append(t.lengths, bits)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+	return t, nil
+}
+
+// New resolves the groups of cfg, building each group's detector from its
+// merged detection settings with build. It returns nil when no groups are
+// configured.
+func New(cfg *config.Config, build func(config.DetectionConfig) (*detector.DDoSDetector, error)) (*Set, error) {
+	if len(cfg.Groups) == 0 {
+		return nil, nil
+	}
+
+	s := &Set{
+		fingerprints: make(map[string]*Group),
+		cidrs:        make(map[*Group][]string),
+		listed:       make(map[*Group][]string),
+	}
+	for _, gc := range cfg.Groups {
+		settings, err := cfg.GroupDetection(gc)
+		if err != nil {
+			return nil, err
+		}
+		d, err := build(settings)
+		if err != nil {
+			return nil, fmt.Errorf("groups %s: %w", gc.Name, err)
+		}
+		g := &Group{
+			Name:       gc.Name,
+			Tenant:     gc.Tenant,
+			Mitigation: cfg.GroupMitigation(gc),
+			Detector:   d,
+		}
+		s.groups = append(s.groups, g)
+
+		s.cidrs[g] = gc.CIDRs
+		if gc.CIDRFile != "" {
+			listed, err := blocker.LoadBlocklist(gc.CIDRFile)
+			if err != nil {
+				return nil, fmt.Errorf("groups %s: %w", gc.Name, err)
+			}
+			s.listed[g] = listed
+		}
+		for _, fp := range gc.Fingerprints {
+			if other, ok := s.fingerprints[fp]; ok && other != g {
+				return nil, fmt.Errorf("groups %s: fingerprint %s is already in group %s", gc.Name, fp, other.Name)
+			}
+			s.fingerprints[fp] = g
+		}
+	}
+	t, err := s.buildTable()
+	if err != nil {
+		return nil, err
+	}
+	s.table.Store(t)
+	return s, nil
+}
+
+// SetListed replaces the CIDRs listed in the cidr_file of the named
+// group, after the file changed. If one is invalid or in another group
+// the set is left as it was.
+func (s *Set) SetListed(name string, cidrs []string) error {
+	if s == nil {
+		return fmt.Errorf("no group %s", name)
+	}
+	var g *Group
+	for _, candidate := range s.groups {
+		if candidate.Name == name {
+			g = candidate
+		}
+	}
+	if g == nil {
+		return fmt.Errorf("no group %s", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.listed[g]
+	s.listed[g] = cidrs
+	t, err := s.buildTable()
+	if err != nil {
+		s.listed[g] = previous
+		return err
+	}
+	s.table.Store(t)
+	return nil
+}
+
+// Match returns the group of the client at ip with TLS fingerprint
+// fingerprint ("" for none), or nil. It takes one map lookup per distinct
+// prefix length and no locks.
+func (s *Set) Match(ip, fingerprint string) *Group {
+	if s == nil {
+		return nil
+	}
+	if g, ok := s.fingerprints[fingerprint]; ok && fingerprint != "" {
+		return g
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	t := s.table.Load()
+	for _, bits := range t.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if g, ok := t.prefixes[prefix]; ok {
+			return g
+		}
+	}
+	return nil
+}
+
+// Groups returns every group in configuration order
+func (s *Set) Groups() []*Group {
+	if s == nil {
+		return nil
+	}
+	return s.groups
+}
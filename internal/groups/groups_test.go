@@ -0,0 +1,99 @@
+package groups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ddd/internal/config"
+	"ddd/internal/detector"
+	"ddd/internal/logger"
+)
+
+func build(d config.DetectionConfig) (*detector.DDoSDetector, error) {
+	return detector.NewDDoSDetector(d.RateLimit, logger.NewNop()), nil
+}
+
+func TestMatchMostSpecific(t *testing.T) {
+	const botnet = "9f1c2b3a4d5e6f708192a3b4c5d6e7f8"
+	feed := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(feed, []byte("# known bad\n10.1.2.0/24\n2001:db8::/32\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Groups = []config.ClientGroup{
+		{Name: "campus", Tenant: "university", CIDRs: []string{"10.0.0.0/8"}, Mitigation: config.MitigationMonitor},
+		{Name: "lab", Parent: "campus", CIDRs: []string{"10.1.0.0/16"}},
+		{Name: "bad", CIDRFile: feed, Mitigation: config.MitigationBlock},
+		{Name: "botnet", Fingerprints: []string{botnet}, Mitigation: config.MitigationBlock},
+	}
+	s, err := New(cfg, build)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, want := range map[string]string{
+		"10.9.9.9":        "campus",
+		"10.1.9.9":        "lab",
+		"10.1.2.3":        "bad",
+		"::ffff:10.1.9.9": "lab",
+		"2001:db8::1":     "bad",
+		"192.0.2.1":       "",
+	} {
+		got := ""
+		if g := s.Match(ip, ""); g != nil {
+			got = g.Name
+		}
+		if got != want {
+			t.Errorf("Match(%s) = %q, want %q", ip, got, want)
+		}
+	}
+	if g := s.Match("10.1.9.9", botnet); g == nil || g.Name != "botnet" {
+		t.Errorf("Expected a listed fingerprint to place the client whatever its address, got %+v", g)
+	}
+	if g := s.Match("10.1.9.9", "00000000000000000000000000000000"); g.Mitigation != config.MitigationMonitor || g.Detector == nil {
+		t.Errorf("Expected lab to inherit campus's mitigation with its own detector, got %+v", g)
+	}
+
+	cfg.Groups = append(cfg.Groups, config.ClientGroup{Name: "dup", CIDRs: []string{"10.0.0.0/8"}})
+	if _, err := New(cfg, build); err == nil {
+		t.Error("Expected a CIDR in two groups rejected")
+	}
+}
+
+func TestSetListed(t *testing.T) {
+	feed := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(feed, []byte("10.1.2.0/24\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Groups = []config.ClientGroup{
+		{Name: "campus", CIDRs: []string{"10.0.0.0/8"}},
+		{Name: "bad", CIDRFile: feed},
+	}
+	s, err := New(cfg, build)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetListed("bad", []string{"10.3.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	if g := s.Match("10.1.2.3", ""); g == nil || g.Name != "campus" {
+		t.Errorf("Expected a CIDR no longer listed to fall back to campus, got %+v", g)
+	}
+	if g := s.Match("10.3.0.1", ""); g == nil || g.Name != "bad" {
+		t.Errorf("Expected a newly listed CIDR to match, got %+v", g)
+	}
+
+	if err := s.SetListed("bad", []string{"10.0.0.0/8"}); err == nil {
+		t.Error("Expected a CIDR of another group rejected")
+	}
+	if err := s.SetListed("nope", nil); err == nil {
+		t.Error("Expected an unknown group rejected")
+	}
+	if g := s.Match("10.3.0.1", ""); g == nil || g.Name != "bad" {
+		t.Errorf("Expected a rejected list to leave the previous one, got %+v", g)
+	}
+}
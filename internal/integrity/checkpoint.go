@@ -0,0 +1,115 @@
+package integrity
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointEntry is the on-disk form of one name's answer baseline
+type checkpointEntry struct {
+	Name         string               `json:"name"`
+	Observations int                  `json:"observations"`
+	Networks     map[string]time.Time `json:"networks"`
+	LastSeen     time.Time            `json:"last_seen"`
+}
+
+// Export writes the learned baselines as JSON
+func (w *Watcher) Export(out io.Writer) (int, error) {
+	w.mu.Lock()
+	entries := make([]checkpointEntry, 0, len(w.names))
+	for name, h := range w.names {
+		networks := make(map[string]time.Time, len(h.networks))
+		for network, seen := range h.networks {
+			networks[network] = seen
+		}
+		entries = append(entries, checkpointEntry{
+			Name:         name,
+			Observations: h.observations,
+			Networks:     networks,
+			LastSeen:     h.lastSeen,
+		})
+	}
+	w.mu.Unlock()
+
+	return len(entries), json.NewEncoder(out).Encode(entries)
+}
+
+// Save writes the learned baselines to path. The file is written to a
+// temporary name and renamed so a crash never leaves a partial checkpoint.
+func (w *Watcher) Save(path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".integrity-checkpoint-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	saved, err := w.Export(tmp)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return saved, os.Rename(tmp.Name(), path)
+}
+
+// Load restores baselines saved by Save. Stale baselines are discounted:
+// observation counts shrink linearly with the time since the name was last
+// resolved, so a name must be re-learned before it alerts again, and names
+// and networks not seen within maxAge are dropped. A missing file is not
+// an error.
+func (w *Watcher) Load(path string, maxAge time.Duration) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	return w.Import(f, maxAge)
+}
+
+// Import restores baselines written by Export, discounted as by Load
+func (w *Watcher) Import(r io.Reader, maxAge time.Duration) (int, error) {
+	var entries []checkpointEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	loaded := 0
+	for _, e := range entries {
+		age := now.Sub(e.LastSeen)
+		if age >= maxAge || len(w.names) >= w.maxNames {
+			continue
+		}
+
+		h := &nameHistory{
+			observations: int(float64(e.Observations) * (1 - float64(age)/float64(maxAge))),
+			networks:     make(map[string]time.Time, len(e.Networks)),
+			lastSeen:     e.LastSeen,
+		}
+		for network, seen := range e.Networks {
+			if now.Sub(seen) < maxAge {
+				h.networks[network] = seen
+			}
+		}
+
+		w.names[e.Name] = h
+		loaded++
+	}
+	trackedNames.Set(float64(len(w.names)))
+
+	return loaded, nil
+}
@@ -0,0 +1,175 @@
+package integrity
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/events"
+	"ddd/internal/geoip"
+	"ddd/internal/metrics"
+)
+
+var (
+	answerChanges = metrics.NewCounter("ddd_integrity_answer_changes_total",
+		"Upstream answers resolving a stable name to an unseen network")
+	trackedNames = metrics.NewGauge("ddd_integrity_tracked_names",
+		"Names whose answer history is being tracked")
+)
+
+// Classifier maps an answer address to the network it belongs to, e.g. an
+// ASN or country from a GeoIP database. Answers that move to a network
+// never seen for a name are reported.
+type Classifier interface {
+	Classify(ip net.IP) string
+}
+
+// PrefixClassifier groups addresses by prefix (/16 for IPv4, /32 for
+// IPv6). It is used when no ASN/country data is available.
+type PrefixClassifier struct{}
+
+// Classify returns the covering prefix of ip
+func (PrefixClassifier) Classify(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
+
+// GeoClassifier groups addresses by autonomous system, as "AS13335", or
+// by country, as "country:NL", when the database knows no AS for them. A
+// CDN rotating addresses within its AS stays in one network. Addresses the
+// database does not cover are grouped by prefix.
+type GeoClassifier struct {
+	DB *geoip.DB
+}
+
+// Classify returns the AS or country of ip
+func (c GeoClassifier) Classify(ip net.IP) string {
+	if loc, ok := c.DB.Lookup(ip.String()); ok {
+		switch {
+		case loc.ASN != 0:
+			return "AS" + strconv.FormatUint(uint64(loc.ASN), 10)
+		case loc.Country != "":
+			return "country:" + loc.Country
+		}
+	}
+	return PrefixClassifier{}.Classify(ip)
+}
+
+// nameHistory is the answer baseline for one name
+type nameHistory struct {
+	observations int
+	networks     map[string]time.Time // network -> last seen
+	lastSeen     time.Time
+	lastAlert    time.Time
+}
+
+// Watcher tracks answer stability for frequently resolved names and raises
+// a hijack/poisoning alert when a stable name suddenly resolves into a
+// network it has never resolved to before
+type Watcher struct {
+	mu              sync.Mutex
+	names           map[string]*nameHistory
+	classifier      Classifier
+	minObservations int
+	maxNames        int
+	alertInterval   time.Duration
+	bus             *events.Bus
+}
+
+// NewWatcher creates an answer watcher. Names need minObservations answers
+// before they are considered stable; at most maxNames are tracked.
+func NewWatcher(classifier Classifier, minObservations, maxNames int, bus *events.Bus) *Watcher {
+	if classifier == nil {
+		classifier = PrefixClassifier{}
+	}
+	return &Watcher{
+		names:           make(map[string]*nameHistory),
+		classifier:      classifier,
+		minObservations: minObservations,
+		maxNames:        maxNames,
+		alertInterval:   time.Hour,
+		bus:             bus,
+	}
+}
+
+// Observe records the A/AAAA records of an upstream answer for name
+func (w *Watcher) Observe(name string, resp *dns.Msg) {
+	if w == nil || resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return
+	}
+
+	networks := make(map[string]bool)
+	for _, rr := range resp.Answer {
+		switch v := rr.(type) {
+		case *dns.A:
+			networks[w.classifier.Classify(v.A)] = true
+		case *dns.AAAA:
+			networks[w.classifier.Classify(v.AAAA)] = true
+		}
+	}
+	if len(networks) == 0 {
+		return
+	}
+
+	name = strings.ToLower(name)
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	history, exists := w.names[name]
+	if !exists {
+		if len(w.names) >= w.maxNames {
+			w.evictLocked()
+		}
+		history = &nameHistory{networks: make(map[string]time.Time)}
+		w.names[name] = history
+		trackedNames.Set(float64(len(w.names)))
+	}
+
+	var unseen []string
+	for network := range networks {
+		if _, known := history.networks[network]; !known {
+			unseen = append(unseen, network)
+		}
+		history.networks[network] = now
+	}
+
+	stable := history.observations >= w.minObservations
+	history.observations++
+	history.lastSeen = now
+
+	if !stable || len(unseen) == 0 || now.Sub(history.lastAlert) < w.alertInterval {
+		return
+	}
+	history.lastAlert = now
+
+	sort.Strings(unseen)
+	answerChanges.Inc()
+	w.bus.Publish(events.Event{
+		Type:   events.UpstreamAnswerChanged,
+		Domain: name,
+		Reason: fmt.Sprintf("resolved to unseen network(s) %s after %d stable answers",
+			strings.Join(unseen, ","), history.observations-1),
+	})
+}
+
+// evictLocked drops the least recently resolved name
+func (w *Watcher) evictLocked() {
+	var oldest string
+	var oldestSeen time.Time
+	for name, history := range w.names {
+		if oldest == "" || history.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = name, history.lastSeen
+		}
+	}
+	delete(w.names, oldest)
+}
@@ -0,0 +1,139 @@
+package integrity
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/events"
+	"ddd/internal/geoip"
+)
+
+func answerFor(t *testing.T, name, ip string) *dns.Msg {
+	t.Helper()
+	rr, err := dns.NewRR(name + ". 300 IN A " + ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(name+".", dns.TypeA)
+	msg.Answer = []dns.RR{rr}
+	return msg
+}
+
+func TestAlertOnUnseenNetwork(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe("test", 10)
+	w := NewWatcher(nil, 5, 100, bus)
+
+	for i := 0; i < 10; i++ {
+		w.Observe("bank.example", answerFor(t, "bank.example", "198.51.100.10"))
+	}
+	// Same /16, different address: not an alert
+	w.Observe("bank.example", answerFor(t, "bank.example", "198.51.7.7"))
+	if len(sub) != 0 {
+		t.Fatal("Expected no alert for an address in a known network")
+	}
+
+	w.Observe("bank.example", answerFor(t, "bank.example", "203.0.113.66"))
+	select {
+	case e := <-sub:
+		if e.Type != events.UpstreamAnswerChanged || e.Domain != "bank.example" {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	default:
+		t.Error("Expected an alert when a stable name moves to an unseen network")
+	}
+}
+
+func TestGeoClassifierFollowsAS(t *testing.T) {
+	if !geoip.Available {
+		t.Skip("GeoIP is not built in")
+	}
+	db, err := geoip.Read(strings.NewReader(`network,country,asn,as_name
+198.18.0.0/16,US,64500,EXAMPLE-CDN
+198.19.0.0/16,US,64500,EXAMPLE-CDN
+203.0.113.0/24,NL,64511,EXAMPLE-HOSTING
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewBus()
+	sub := bus.Subscribe("test", 10)
+	w := NewWatcher(GeoClassifier{DB: db}, 5, 100, bus)
+
+	for i := 0; i < 10; i++ {
+		w.Observe("cdn.example", answerFor(t, "cdn.example", "198.18.0.10"))
+	}
+	// Another /16 of the same AS, as when a CDN rotates its edges
+	w.Observe("cdn.example", answerFor(t, "cdn.example", "198.19.40.1"))
+	if len(sub) != 0 {
+		t.Fatal("Expected no alert for an address in a known AS")
+	}
+
+	w.Observe("cdn.example", answerFor(t, "cdn.example", "203.0.113.66"))
+	select {
+	case e := <-sub:
+		if !strings.Contains(e.Reason, "AS64511") {
+			t.Errorf("Expected the new AS in the alert, got %q", e.Reason)
+		}
+	default:
+		t.Error("Expected an alert when a stable name moves to an unseen AS")
+	}
+}
+
+func TestNoAlertBeforeBaselineIsStable(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe("test", 10)
+	w := NewWatcher(nil, 5, 100, bus)
+
+	w.Observe("new.example", answerFor(t, "new.example", "198.51.100.10"))
+	w.Observe("new.example", answerFor(t, "new.example", "203.0.113.66"))
+
+	if len(sub) != 0 {
+		t.Error("Expected no alert while the baseline is still being learned")
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := t.TempDir() + "/integrity.json"
+
+	w := NewWatcher(nil, 5, 100, nil)
+	for i := 0; i < 10; i++ {
+		w.Observe("bank.example", answerFor(t, "bank.example", "198.51.100.10"))
+	}
+	if saved, err := w.Save(path); err != nil || saved != 1 {
+		t.Fatalf("Expected 1 saved baseline, got %d, %v", saved, err)
+	}
+
+	bus := events.NewBus()
+	sub := bus.Subscribe("test", 10)
+	restored := NewWatcher(nil, 5, 100, bus)
+	if loaded, err := restored.Load(path, time.Hour); err != nil || loaded != 1 {
+		t.Fatalf("Expected 1 loaded baseline, got %d, %v", loaded, err)
+	}
+
+	// The restored baseline is already stable, so a change alerts at once
+	restored.Observe("bank.example", answerFor(t, "bank.example", "203.0.113.66"))
+	if len(sub) != 1 {
+		t.Error("Expected restored baseline to detect an answer change")
+	}
+}
+
+func TestCheckpointDropsStaleBaselines(t *testing.T) {
+	path := t.TempDir() + "/integrity.json"
+
+	w := NewWatcher(nil, 5, 100, nil)
+	w.Observe("old.example", answerFor(t, "old.example", "198.51.100.10"))
+	w.names["old.example"].lastSeen = time.Now().Add(-2 * time.Hour)
+	if _, err := w.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewWatcher(nil, 5, 100, nil)
+	if loaded, err := restored.Load(path, time.Hour); err != nil || loaded != 0 {
+		t.Errorf("Expected stale baseline to be dropped, got %d, %v", loaded, err)
+	}
+}
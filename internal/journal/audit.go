@@ -0,0 +1,27 @@
+package journal
+
+import "ddd/internal/events"
+
+// Handle records the life of rate limit exemptions (their request,
+// approval and end) and of budget grants (their minting and end), with
+// the operators named in the event reason. Other events are ignored.
+func (j *Journal) Handle(e events.Event) {
+	var source string
+	switch e.Type {
+	case events.ExemptionRequested, events.ExemptionGranted, events.ExemptionEnded:
+		source = SourceExemption
+	case events.GrantIssued, events.GrantEnded:
+		source = SourceGrant
+	default:
+		return
+	}
+	j.Record(Entry{
+		Time:        e.Time,
+		Client:      e.IP,
+		Source:      source,
+		Description: e.Reason,
+		Inputs:      map[string]float64{"remaining_seconds": e.Duration.Seconds()},
+		Decision:    string(e.Type),
+		DecidedBy:   "operator",
+	})
+}
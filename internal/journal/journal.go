@@ -0,0 +1,373 @@
+// Package journal keeps an append-only record of automated mitigation
+// decisions, for environments that must show why traffic was blocked.
+// Each line holds one decision, its inputs and the thresholds in force,
+// and a hash over the entry, which includes the previous entry's hash.
+// Editing, removing or reordering entries breaks the chain from that
+// point on; with a key, the hashes are HMACs, so the chain cannot be
+// rebuilt after tampering without the key either.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+var (
+	entriesWritten = metrics.NewCounterVec("ddd_journal_entries_total",
+		"Mitigation decisions journaled, by source", "source")
+	writeErrors = metrics.NewCounter("ddd_journal_errors_total",
+		"Decision journal writes that failed")
+	entriesDropped = metrics.NewCounter("ddd_journal_dropped_total",
+		"Mitigation decisions not journaled because the write queue was full")
+)
+
+const (
+	// queueSize bounds the decisions waiting to be written
+	queueSize = 4096
+	// maxBatch bounds the decisions appended in one write
+	maxBatch = 256
+)
+
+// Decision sources
+const (
+	SourceDetection     = "detection"      // the detector flagged a query
+	SourceProtocolAbuse = "protocol_abuse" // malformed or abusive messages
+	SourceZoneTransfer  = "zone_transfer"  // AXFR, IXFR or NOTIFY attempts
+	SourceTCPAbuse      = "tcp_abuse"      // TCP connection and pipelining limits
+	SourceSustained     = "sustained"      // the long-window archive scan
+	SourceFirewall      = "firewall"       // an operator firewall rule
+	SourceScript        = "script"         // the policy script's on_request hook
+	SourceReport        = "report"         // abuse reported by another service
+	SourceExemption     = "exemption"      // an operator's rate limit exemption
+	SourceGrant         = "grant"          // a batch job's budget grant
+)
+
+// genesis is the previous hash of the first entry
+var genesis = strings.Repeat("0", sha256.Size*2)
+
+// maxLine bounds the length of a journal line
+const maxLine = 1 << 20
+
+// Entry is one mitigation decision
+type Entry struct {
+	Seq         uint64         `json:"seq"`
+	Time        time.Time      `json:"time"`
+	Client      string         `json:"client"`
+	Source      string         `json:"source"`
+	AttackType  string         `json:"attack_type,omitempty"`
+	Severity    severity.Level `json:"severity,omitempty"` // left out when none
+	Description string         `json:"description,omitempty"`
+	Domain      string         `json:"domain,omitempty"`
+	QType       string         `json:"qtype,omitempty"`
+	Group       string         `json:"group,omitempty"`
+	// Evidence is what a reporting service sent with an abuse report, or
+	// the names a detection judging names was made on
+	Evidence string `json:"evidence,omitempty"`
+	// Inputs are the measurements the decision was made on, e.g. the
+	// client's requests in the window
+	Inputs map[string]float64 `json:"inputs,omitempty"`
+	// Thresholds are the limits in force, e.g. rate_limit
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+	// Decision is block, rate_limit, refuse or monitor, or for exemptions
+	// exemption_requested, exemption_granted or exemption_ended
+	Decision string `json:"decision"`
+	// DecidedBy is what made the decision: detector, script, policy,
+	// group, firewall, tcp_guard, report or operator
+	DecidedBy string `json:"decided_by"`
+	Prev      string `json:"prev"`
+}
+
+// line is a journal line: the entry exactly as hashed, and its hash
+type line struct {
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+}
+
+// Journal appends decisions to a file. Recorded entries are queued and
+// appended in batches by a background writer, under an exclusive lock on
+// the file where the platform has one: during a binary upgrade the old and
+// new process both append, and each picks the chain up from the other's
+// last entry.
+type Journal struct {
+	path string
+	key  []byte
+	log  *logger.Logger
+
+	queue   chan Entry
+	stop    chan struct{}
+	stopped chan struct{}
+	dropped atomic.Bool // an entry was dropped; logged once until one is queued
+
+	mu     sync.Mutex
+	file   *os.File
+	end    int64 // file size after this process's last write
+	seq    uint64
+	prev   string
+	failed bool // a write failed; logged once until one succeeds
+}
+
+// Open opens the journal at path for appending, continuing the chain of
+// the entries already in it. key, if not empty, makes the hashes HMACs.
+func Open(path string, key []byte, log *logger.Logger) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		path:    path,
+		key:     key,
+		log:     log,
+		queue:   make(chan Entry, queueSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		file:    f,
+		end:     -1,
+	}
+
+	lockFile(f)
+	err = j.sync()
+	unlockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	go j.run()
+	return j, nil
+}
+
+// sync continues the chain from the last entry in the file when another
+// process has appended since this one last wrote
+func (j *Journal) sync() error {
+	info, err := j.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == j.end {
+		return nil
+	}
+
+	j.seq, j.prev, j.end = 0, genesis, info.Size()
+	last, err := lastLine(j.file, info.Size())
+	if err != nil || last == nil {
+		return err
+	}
+	var l line
+	var e Entry
+	if err := json.Unmarshal(last, &l); err != nil || json.Unmarshal(l.Entry, &e) != nil || l.Hash == "" {
+		return fmt.Errorf("journal %s: last entry is damaged; verify the file and move it aside", j.path)
+	}
+	j.seq, j.prev = e.Seq, l.Hash
+	return nil
+}
+
+// lastLine returns the last non-empty line of the first size bytes of f,
+// nil when there is none
+func lastLine(f *os.File, size int64) ([]byte, error) {
+	start := size - maxLine
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, size-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, " \t\r\n")
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		return buf[i+1:], nil
+	}
+	if start > 0 {
+		return nil, fmt.Errorf("last line longer than %d bytes", maxLine)
+	}
+	return buf, nil
+}
+
+// Record queues a decision for the background writer, which fills in its
+// sequence number and previous hash. It does not wait for the write; when
+// the queue is full the decision is dropped and counted. It is safe to
+// call on a nil journal.
+func (j *Journal) Record(e Entry) {
+	if j == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case j.queue <- e:
+		j.dropped.Store(false)
+	default:
+		entriesDropped.Inc()
+		if !j.dropped.Swap(true) {
+			j.log.Errorw("Decision journal queue is full; dropping entries", "file", j.path)
+		}
+	}
+}
+
+// run writes queued decisions in batches until the journal is closed,
+// then writes those still queued
+func (j *Journal) run() {
+	defer close(j.stopped)
+	batch := make([]Entry, 0, maxBatch)
+	for {
+		select {
+		case e := <-j.queue:
+			batch = append(batch[:0], e)
+		fill:
+			for len(batch) < maxBatch {
+				select {
+				case e := <-j.queue:
+					batch = append(batch, e)
+				default:
+					break fill
+				}
+			}
+			j.write(batch)
+		case <-j.stop:
+			for {
+				batch = batch[:0]
+				for len(batch) < maxBatch && len(j.queue) > 0 {
+					batch = append(batch, <-j.queue)
+				}
+				if len(batch) == 0 {
+					return
+				}
+				j.write(batch)
+			}
+		}
+	}
+}
+
+// write appends batch in one write, chaining each entry to the one before
+func (j *Journal) write(batch []Entry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	lockFile(j.file)
+	defer unlockFile(j.file)
+	if err := j.sync(); err != nil {
+		j.fail(err)
+		return
+	}
+
+	seq, prev := j.seq, j.prev
+	var buf bytes.Buffer
+	for i := range batch {
+		e := &batch[i]
+		e.Seq, e.Prev = seq+1, prev
+		raw, err := json.Marshal(e)
+		if err != nil {
+			j.fail(err)
+			return
+		}
+		hash := sum(j.key, raw)
+		out, err := json.Marshal(line{Entry: raw, Hash: hash})
+		if err != nil {
+			j.fail(err)
+			return
+		}
+		buf.Write(out)
+		buf.WriteByte('\n')
+		seq, prev = e.Seq, hash
+	}
+	n, err := j.file.Write(buf.Bytes())
+	j.end += int64(n)
+	if err != nil {
+		j.fail(err)
+		return
+	}
+	j.seq, j.prev = seq, prev
+	j.failed = false
+	for _, e := range batch {
+		entriesWritten.With(e.Source).Inc()
+	}
+}
+
+// fail counts a failed write and logs the first of a run
+func (j *Journal) fail(err error) {
+	writeErrors.Inc()
+	if !j.failed {
+		j.log.Errorw("Failed to write decision journal", "file", j.path, "error", err)
+	}
+	j.failed = true
+}
+
+// Close writes the queued decisions and closes the journal. The last
+// entry's hash is logged, so that a journal cut short afterwards can be
+// told from the log.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	close(j.stop)
+	<-j.stopped
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.log.Infow("Closed decision journal", "file", j.path, "entries", j.seq, "hash", j.prev)
+	return j.file.Close()
+}
+
+// sum hashes an entry: an HMAC-SHA256 with key, else SHA-256
+func sum(key, raw []byte) string {
+	if len(key) == 0 {
+		h := sha256.Sum256(raw)
+		return hex.EncodeToString(h[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the journal read from r: every entry's hash, its link to
+// the previous entry and consecutive sequence numbers. It returns how many
+// entries verified, and the first problem found with its line number.
+func Verify(r io.Reader, key []byte) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	prev, n, lineNo := genesis, 0, 0
+	for scanner.Scan() {
+		lineNo++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return n, fmt.Errorf("line %d: malformed: %w", lineNo, err)
+		}
+		var e Entry
+		if err := json.Unmarshal(l.Entry, &e); err != nil {
+			return n, fmt.Errorf("line %d: malformed entry: %w", lineNo, err)
+		}
+		switch {
+		case e.Prev != prev:
+			return n, fmt.Errorf("line %d: entry %d does not follow the previous entry (removed or reordered entries)", lineNo, e.Seq)
+		case e.Seq != uint64(n+1):
+			return n, fmt.Errorf("line %d: entry %d, expected %d", lineNo, e.Seq, n+1)
+		case !hmac.Equal([]byte(sum(key, l.Entry)), []byte(l.Hash)):
+			return n, fmt.Errorf("line %d: entry %d does not match its hash (modified, or the wrong key)", lineNo, e.Seq)
+		}
+		prev = l.Hash
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package journal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ddd/internal/logger"
+	"ddd/internal/severity"
+)
+
+func record(t *testing.T, j *Journal, clients ...string) {
+	t.Helper()
+	for _, client := range clients {
+		j.Record(Entry{
+			Client:     client,
+			Source:     SourceDetection,
+			AttackType: "high_request_rate",
+			Severity:   severity.High,
+			Inputs:     map[string]float64{"requests": 250},
+			Thresholds: map[string]float64{"rate_limit": 100},
+			Decision:   "block",
+			DecidedBy:  "detector",
+		})
+	}
+}
+
+func TestJournalChainsAcrossProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	key := []byte("k")
+
+	old, err := Open(path, key, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, old, "192.0.2.1", "192.0.2.2")
+
+	// A new process takes over while the old one still records
+	upgraded, err := Open(path, key, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, upgraded, "192.0.2.3")
+	record(t, old, "192.0.2.4")
+	record(t, upgraded, "192.0.2.5")
+	old.Close()
+	upgraded.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Verify(bytes.NewReader(data), key); err != nil || n != 5 {
+		t.Fatalf("Expected 5 entries verified, got %d: %v", n, err)
+	}
+	if !bytes.Contains(data, []byte(`"severity":"high"`)) {
+		t.Error("Expected the severity recorded by name")
+	}
+	if _, err := Verify(bytes.NewReader(data), []byte("other")); err == nil {
+		t.Error("Expected the wrong key to fail verification")
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(path, nil, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, j, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	j.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(string(data)), "\n")
+
+	tests := map[string]string{
+		"edited":         strings.Replace(string(data), `"decision":"block"`, `"decision":"rate_limit"`, 1),
+		"removed":        lines[0] + lines[2],
+		"reordered":      lines[1] + lines[0] + lines[2],
+		"truncated head": lines[1] + lines[2],
+	}
+	for name, tampered := range tests {
+		if _, err := Verify(strings.NewReader(tampered), nil); err == nil {
+			t.Errorf("%s: expected verification to fail", name)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package journal
+
+import "os"
+
+// lockFile does nothing: without file locks, only one process may write
+// the journal at a time
+func lockFile(f *os.File) {}
+
+// unlockFile does nothing
+func unlockFile(f *os.File) {}
@@ -0,0 +1,114 @@
+// Package killswitch is the operator's panic button. While engaged the
+// server applies its strictest profile: a global query cap, refusal of
+// clients first seen after the switch was thrown, and answers from cache
+// only. Critical queries are exempt. It is engaged and cleared by a human
+// through the admin API, never automatically.
+package killswitch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+)
+
+var engagedGauge = metrics.NewGauge("ddd_panic_mode",
+	"1 while panic mode is engaged, 0 otherwise")
+
+// Status describes panic mode. Since and Reason are set while engaged.
+type Status struct {
+	Engaged bool      `json:"engaged"`
+	Since   time.Time `json:"since"`
+	Reason  string    `json:"reason,omitempty"`
+	MaxQPS  int       `json:"max_qps"` // global cap while engaged; 0 means none
+}
+
+// Switch holds panic mode state
+type Switch struct {
+	maxQPS int
+	bus    *events.Bus
+
+	mu    sync.Mutex // serializes Engage and Clear
+	state atomic.Pointer[Status]
+
+	// Fixed one-second window for the global cap
+	second atomic.Int64
+	count  atomic.Int64
+}
+
+// New creates a disengaged switch that caps queries at maxQPS while
+// engaged and publishes changes on bus
+func New(maxQPS int, bus *events.Bus) *Switch {
+	s := &Switch{maxQPS: maxQPS, bus: bus}
+	s.state.Store(&Status{MaxQPS: maxQPS})
+	return s
+}
+
+// Engage turns panic mode on. Engaging it again only updates the reason,
+// so clients known at the first engagement stay known.
+func (s *Switch) Engage(reason string) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := *s.state.Load()
+	if !st.Engaged {
+		st.Engaged = true
+		st.Since = time.Now()
+		engagedGauge.Set(1)
+	}
+	st.Reason = reason
+	s.state.Store(&st)
+
+	s.bus.Publish(events.Event{Type: events.PanicEngaged, Reason: reason})
+	return st
+}
+
+// Clear turns panic mode off
+func (s *Switch) Clear() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	was := s.state.Load()
+	st := Status{MaxQPS: s.maxQPS}
+	s.state.Store(&st)
+	engagedGauge.Set(0)
+
+	if was.Engaged {
+		s.bus.Publish(events.Event{
+			Type:     events.PanicCleared,
+			Reason:   was.Reason,
+			Duration: time.Since(was.Since),
+		})
+	}
+	return st
+}
+
+// Status returns the current state
+func (s *Switch) Status() Status {
+	return *s.state.Load()
+}
+
+// Engaged reports whether panic mode is on and since when. It takes no
+// locks and is safe to call on a nil switch.
+func (s *Switch) Engaged() (since time.Time, engaged bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	st := s.state.Load()
+	return st.Since, st.Engaged
+}
+
+// Admit counts a query against the global cap and reports whether it is
+// within it
+func (s *Switch) Admit(now time.Time) bool {
+	if s.maxQPS <= 0 {
+		return true
+	}
+	sec := now.Unix()
+	if old := s.second.Load(); old != sec && s.second.CompareAndSwap(old, sec) {
+		s.count.Store(0)
+	}
+	return s.count.Add(1) <= int64(s.maxQPS)
+}
@@ -0,0 +1,70 @@
+package killswitch
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/events"
+)
+
+func TestEngageAndClear(t *testing.T) {
+	bus := events.NewBus()
+	ch := bus.Subscribe("test", 8)
+	s := New(10, bus)
+
+	if _, engaged := s.Engaged(); engaged {
+		t.Fatal("Expected a new switch to be disengaged")
+	}
+	first := s.Engage("flood")
+	again := s.Engage("still flooding")
+	if !again.Engaged || !again.Since.Equal(first.Since) || again.Reason != "still flooding" {
+		t.Errorf("Expected engaging again to keep the start and update the reason, got %+v", again)
+	}
+	if since, engaged := s.Engaged(); !engaged || !since.Equal(first.Since) {
+		t.Errorf("Engaged() = %v, %v", since, engaged)
+	}
+
+	if st := s.Clear(); st.Engaged || !st.Since.IsZero() || st.MaxQPS != 10 {
+		t.Errorf("Unexpected status after clearing %+v", st)
+	}
+	s.Clear() // no event when already clear
+
+	var types []events.Type
+	for len(ch) > 0 {
+		types = append(types, (<-ch).Type)
+	}
+	want := []events.Type{events.PanicEngaged, events.PanicEngaged, events.PanicCleared}
+	if len(types) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("Event %d: got %s, want %s", i, types[i], want[i])
+		}
+	}
+
+	var none *Switch
+	if _, engaged := none.Engaged(); engaged {
+		t.Error("Expected a nil switch to be disengaged")
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	s := New(3, nil)
+	now := time.Unix(1000, 0)
+	admitted := 0
+	for i := 0; i < 5; i++ {
+		if s.Admit(now) {
+			admitted++
+		}
+	}
+	if admitted != 3 {
+		t.Errorf("Expected 3 queries admitted in a second, got %d", admitted)
+	}
+	if !s.Admit(now.Add(time.Second)) {
+		t.Error("Expected the cap to reset in the next second")
+	}
+	if !New(0, nil).Admit(now) {
+		t.Error("Expected no cap when max_qps is 0")
+	}
+}
@@ -1,12 +1,27 @@
 package logger
 
 import (
+	"runtime"
+	"time"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"ddd/internal/events"
+	"ddd/internal/severity"
 )
 
 type Logger struct {
 	*zap.SugaredLogger
+
+	// hostname, if set, returns a client IP's hostname (or "") for
+	// enriching detection and mitigation entries
+	hostname func(ip string) string
+}
+
+// NewNop returns a logger that discards everything
+func NewNop() *Logger {
+	return &Logger{SugaredLogger: zap.NewNop().Sugar()}
 }
 
 func NewLogger(logFile string) (*Logger, error) {
@@ -25,6 +40,28 @@ func NewLogger(logFile string) (*Logger, error) {
 	}, nil
 }
 
+// WithInstance returns a logger that tags every entry with the instance ID
+func (l *Logger) WithInstance(id string) *Logger {
+	return &Logger{SugaredLogger: l.With("instance", id), hostname: l.hostname}
+}
+
+// SetHostnameLookup enables client hostname enrichment of detection and
+// mitigation entries. fn must not block.
+func (l *Logger) SetHostnameLookup(fn func(ip string) string) {
+	l.hostname = fn
+}
+
+// client returns the fields identifying a client: its IP and, when known,
+// its hostname
+func (l *Logger) client(ip string) []interface{} {
+	if l.hostname != nil {
+		if name := l.hostname(ip); name != "" {
+			return []interface{}{"client_ip", ip, "client_hostname", name}
+		}
+	}
+	return []interface{}{"client_ip", ip}
+}
+
 // LogDNSQuery logs a DNS query
 func (l *Logger) LogDNSQuery(clientIP, domain, qtype string) {
 	l.Infow("DNS Query",
@@ -35,42 +72,250 @@ func (l *Logger) LogDNSQuery(clientIP, domain, qtype string) {
 	)
 }
 
+// LogTransparentDNSQuery logs a DNS query intercepted in transparent mode,
+// along with the resolver the client originally addressed
+func (l *Logger) LogTransparentDNSQuery(clientIP, originalDst, domain, qtype string) {
+	l.Infow("DNS Query",
+		"client_ip", clientIP,
+		"original_dst", originalDst,
+		"domain", domain,
+		"query_type", qtype,
+		"event", "dns_query",
+	)
+}
+
 // LogDDoSDetected logs when DDoS is detected
 func (l *Logger) LogDDoSDetected(clientIP, reason string, requestCount int) {
-	l.Warnw("DDoS Pattern Detected",
-		"client_ip", clientIP,
+	l.Warnw("DDoS Pattern Detected", append(l.client(clientIP),
 		"reason", reason,
 		"request_count", requestCount,
 		"event", "ddos_detected",
+	)...)
+}
+
+// LogNewDomainSurge logs when clients together query never-before-seen
+// names faster than the global limit
+func (l *Logger) LogNewDomainSurge(count, limit int) {
+	l.Warnw("New Domain Surge",
+		"new_domains", count,
+		"limit", limit,
+		"event", "new_domain_surge",
 	)
 }
 
+// LogCheckOverrun logs a detection check the watchdog abandoned for
+// running past its time budget, with hints for profiling it
+func (l *Logger) LogCheckOverrun(clientIP, check string, requests int, elapsed time.Duration) {
+	l.Warnw("Detection Check Overrun", append(l.client(clientIP),
+		"check", check,
+		"requests", requests,
+		"elapsed", elapsed.String(),
+		"goroutines", runtime.NumGoroutine(),
+		"hint", "large windows slow every check: lower monitor.history_size, or capture a CPU profile while the overruns recur",
+		"event", "check_overrun",
+	)...)
+}
+
+// LogEvent logs an event published on the event bus
+func (l *Logger) LogEvent(e events.Event) {
+	switch e.Type {
+	case events.IPBlocked:
+		l.LogIPBlocked(e.IP, e.Reason, e.Severity, int(e.Duration/time.Second))
+	case events.IPRateLimited:
+		l.LogIPRateLimited(e.IP)
+	case events.IPUnblocked:
+		l.LogMitigationAction(e.IP, "unblock", e.Reason)
+	case events.BlockListSynced:
+		l.Infow("Block List Applied",
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	case events.BlockListNearCapacity:
+		l.Warnw("Block List Near Capacity",
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	case events.DomainAttacked:
+		l.Warnw("Domain Under Attack",
+			"domain", e.Domain,
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	case events.SLOBurnRate:
+		l.Warnw("SLO Budget Burning",
+			"reason", e.Reason,
+			"window", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.PacketCaptured:
+		l.Infow("Packet Capture Written",
+			"ip", e.IP,
+			"file", e.File,
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	case events.PanicEngaged:
+		l.Warnw("Panic Mode Engaged",
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	case events.PanicCleared:
+		l.Warnw("Panic Mode Cleared",
+			"reason", e.Reason,
+			"duration", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.UpstreamsDown:
+		l.Errorw("Upstreams Down, Resolving Critical Domains Locally",
+			"duration", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.UpstreamsRecovered:
+		l.Warnw("Upstreams Recovered",
+			"duration", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.SearchDomainStorm:
+		l.Warnw("Client Search Domain Storm", append(l.client(e.IP),
+			"search_domain", e.Domain,
+			"advice", e.Reason,
+			"event", string(e.Type),
+		)...)
+	case events.WatchDegraded:
+		l.Errorw("Watched Domain Degraded",
+			"domain", e.Domain,
+			"reason", e.Reason,
+			"window", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.WatchRecovered:
+		l.Warnw("Watched Domain Recovered",
+			"domain", e.Domain,
+			"duration", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.BudgetsTightened:
+		l.Warnw("Rate Budgets Tightened Under Load",
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	case events.BudgetsRelaxed:
+		l.Infow("Rate Budgets Relaxed",
+			"reason", e.Reason,
+			"calm", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.ExemptionRequested, events.ExemptionGranted, events.ExemptionEnded:
+		l.Warnw("Rate Limit Exemption",
+			"cidr", e.IP,
+			"reason", e.Reason,
+			"remaining", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.GrantIssued, events.GrantEnded:
+		l.Warnw("Budget Grant",
+			"cidr", e.IP,
+			"reason", e.Reason,
+			"remaining", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.BlockPropagationSlow:
+		l.Warnw("Slow Block Propagation",
+			"reason", e.Reason,
+			"latency", e.Duration.String(),
+			"event", string(e.Type),
+		)
+	case events.UpstreamAnswerChanged:
+		l.Warnw("Upstream Answer Changed",
+			"domain", e.Domain,
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	default:
+		l.Infow("Event",
+			"type", string(e.Type),
+			"client_ip", e.IP,
+			"domain", e.Domain,
+			"reason", e.Reason,
+			"event", string(e.Type),
+		)
+	}
+}
+
 // LogIPBlocked logs when an IP is blocked
-func (l *Logger) LogIPBlocked(clientIP, reason string, duration int) {
-	l.Warnw("IP Blocked",
-		"client_ip", clientIP,
+func (l *Logger) LogIPBlocked(clientIP, reason string, level severity.Level, duration int) {
+	l.Warnw("IP Blocked", append(l.client(clientIP),
 		"reason", reason,
+		"severity", level.String(),
 		"block_duration_seconds", duration,
 		"event", "ip_blocked",
 		"action", "block",
-	)
+	)...)
 }
 
 // LogIPRateLimited logs when an IP is rate limited
 func (l *Logger) LogIPRateLimited(clientIP string) {
-	l.Warnw("IP Rate Limited",
-		"client_ip", clientIP,
+	l.Warnw("IP Rate Limited", append(l.client(clientIP),
 		"event", "rate_limited",
 		"action", "rate_limit",
-	)
+	)...)
 }
 
 // LogMitigationAction logs any mitigation action taken
 func (l *Logger) LogMitigationAction(clientIP, action, reason string) {
-	l.Infow("Mitigation Action",
-		"client_ip", clientIP,
+	l.Infow("Mitigation Action", append(l.client(clientIP),
 		"action", action,
 		"reason", reason,
 		"event", "mitigation",
+	)...)
+}
+
+// LogSocketStats logs UDP socket drop counters for the last reporting interval
+func (l *Logger) LogSocketStats(kernelDrops, userDrops, rxQueue uint64, readBuffer int, inFlight int64) {
+	fields := []interface{}{
+		"kernel_drops", kernelDrops,
+		"userspace_drops", userDrops,
+		"rx_queue_bytes", rxQueue,
+		"read_buffer_bytes", readBuffer,
+		"in_flight", inFlight,
+		"event", "socket_stats",
+	}
+
+	if kernelDrops > 0 || userDrops > 0 {
+		l.Warnw("Packets Dropped", fields...)
+		return
+	}
+	l.Infow("Socket Stats", fields...)
+}
+
+// LogUpstreamAnomaly logs a suspicious answer received from upstream
+func (l *Logger) LogUpstreamAnomaly(domain, reason string, chainLength int) {
+	l.Warnw("Upstream Answer Anomaly",
+		"domain", domain,
+		"reason", reason,
+		"chain_length", chainLength,
+		"event", "upstream_anomaly",
+	)
+}
+
+// LogMalformedResponse logs an upstream response dropped as malformed,
+// which may be an attack on the clients it would have been relayed to
+func (l *Logger) LogMalformedResponse(upstream, domain, reason string) {
+	l.Warnw("Malformed Upstream Response",
+		"upstream", upstream,
+		"domain", domain,
+		"reason", reason,
+		"event", "malformed_response",
 	)
 }
+
+// LogTCPAbuse logs a client whose TCP connection was closed for abuse and
+// whose new TCP connections are refused for a while
+func (l *Logger) LogTCPAbuse(ip, kind string, count int, refuseFor time.Duration) {
+	l.Warnw("TCP Abuse", append(l.client(ip),
+		"kind", kind,
+		"count", count,
+		"refuse_for", refuseFor.String(),
+		"event", "tcp_abuse",
+	)...)
+}
@@ -0,0 +1,76 @@
+package logger_test
+
+import (
+	"testing"
+
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/logger/loggertest"
+)
+
+func TestRecordsEvents(t *testing.T) {
+	t.Parallel()
+	log, recorded := loggertest.New(t)
+
+	log.LogDDoSDetected("192.0.2.1", "high request rate", 500)
+	log.LogEvent(events.Event{Type: events.IPBlocked, IP: "192.0.2.1", Reason: "high_request_rate"})
+
+	if got := len(recorded.Events("ddos_detected")); got != 1 {
+		t.Errorf("Expected 1 ddos_detected entry, got %d", got)
+	}
+	blocked := recorded.Events("ip_blocked")
+	if len(blocked) != 1 || blocked[0].ContextMap()["client_ip"] != "192.0.2.1" {
+		t.Errorf("Expected ip_blocked entry for 192.0.2.1, got %v", blocked)
+	}
+}
+
+func TestHostnameEnrichment(t *testing.T) {
+	t.Parallel()
+	log, recorded := loggertest.New(t)
+	log.SetHostnameLookup(func(ip string) string { return "host.example.net" })
+
+	log.LogIPRateLimited("192.0.2.1")
+
+	entries := recorded.Events("rate_limited")
+	if len(entries) != 1 || entries[0].ContextMap()["client_hostname"] != "host.example.net" {
+		t.Errorf("Expected client_hostname on rate limit entry, got %v", entries)
+	}
+}
+
+func TestNewNop(t *testing.T) {
+	logger.NewNop().LogDDoSDetected("192.0.2.1", "test", 1)
+}
+
+func TestSummary(t *testing.T) {
+	t.Parallel()
+	log, recorded := loggertest.New(t)
+	s := logger.NewSummary(log)
+
+	for i := 0; i < 3; i++ {
+		s.Record("192.0.2.1", "example.com", "forwarded")
+	}
+	s.Record("192.0.2.1", "example.org", "cache")
+	s.Record("192.0.2.1", "", "refused")
+	s.Record("192.0.2.2", "example.net", "blocked")
+	s.Flush()
+
+	lines := recorded.Events("client_summary")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per client, got %d", len(lines))
+	}
+	first := lines[0].ContextMap()
+	if first["client_ip"] != "192.0.2.1" || first["queries"] != int64(5) || first["unique_domains"] != int64(2) {
+		t.Errorf("Expected the busiest client first with its counts, got %v", first)
+	}
+	if verdicts, ok := first["verdicts"].(map[string]int); !ok || verdicts["forwarded"] != 3 || verdicts["refused"] != 1 {
+		t.Errorf("Expected counts per verdict, got %#v", first["verdicts"])
+	}
+
+	s.Flush()
+	if got := len(recorded.Events("client_summary")); got != 2 {
+		t.Errorf("Expected an idle interval to log nothing, got %d lines in total", got)
+	}
+
+	var none *logger.Summary
+	none.Record("192.0.2.1", "example.com", "forwarded")
+}
@@ -0,0 +1,32 @@
+// Package loggertest provides a logger that records entries in memory for
+// tests to assert on
+package loggertest
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"ddd/internal/logger"
+)
+
+// New returns a logger that records entries in memory, at all levels.
+// Nothing is written to disk, so tests using it are hermetic and can run
+// in parallel.
+func New(t testing.TB) (*logger.Logger, *Recorded) {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	return &logger.Logger{SugaredLogger: zap.New(core).Sugar()}, &Recorded{logs}
+}
+
+// Recorded holds the entries captured by a test logger
+type Recorded struct {
+	*observer.ObservedLogs
+}
+
+// Events returns the entries whose "event" field equals event
+func (r *Recorded) Events(event string) []observer.LoggedEntry {
+	return r.FilterField(zap.String("event", event)).All()
+}
@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSummaryClients bounds the clients summarized per interval; queries
+	// from further clients are only counted in total
+	maxSummaryClients = 100000
+	// maxSummaryDomains bounds the distinct domains remembered per client
+	maxSummaryDomains = 1024
+)
+
+// Summary replaces per-query log entries with one line per client per
+// interval, for disks that cannot take a line per query. A nil Summary
+// records nothing.
+type Summary struct {
+	log *Logger
+
+	mu       sync.Mutex
+	clients  map[string]*clientSummary
+	overflow int // queries from clients beyond maxSummaryClients
+}
+
+// clientSummary is one client's activity within the current interval
+type clientSummary struct {
+	queries  int
+	domains  map[string]struct{}
+	verdicts map[string]int
+}
+
+// NewSummary creates a summary that writes to l
+func NewSummary(l *Logger) *Summary {
+	return &Summary{log: l, clients: make(map[string]*clientSummary)}
+}
+
+// Record counts a query from ip for domain ("" when the query was refused
+// before its question was read) and how it was handled
+func (s *Summary) Record(ip, domain, verdict string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.clients[ip]
+	if !ok {
+		if len(s.clients) >= maxSummaryClients {
+			s.overflow++
+			return
+		}
+		c = &clientSummary{domains: make(map[string]struct{}), verdicts: make(map[string]int)}
+		s.clients[ip] = c
+	}
+	c.queries++
+	c.verdicts[verdict]++
+	if domain != "" && len(c.domains) < maxSummaryDomains {
+		c.domains[domain] = struct{}{}
+	}
+}
+
+// Run writes the summary every interval until ctx is cancelled, and once
+// more on the way out
+func (s *Summary) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Flush()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Flush writes one line per client seen since the last flush, busiest
+// first, and starts a new interval
+func (s *Summary) Flush() {
+	s.mu.Lock()
+	clients, overflow := s.clients, s.overflow
+	s.clients, s.overflow = make(map[string]*clientSummary), 0
+	s.mu.Unlock()
+
+	ips := make([]string, 0, len(clients))
+	for ip := range clients {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		if clients[ips[i]].queries != clients[ips[j]].queries {
+			return clients[ips[i]].queries > clients[ips[j]].queries
+		}
+		return ips[i] < ips[j]
+	})
+
+	for _, ip := range ips {
+		c := clients[ip]
+		s.log.Infow("Client Summary", append(s.log.client(ip),
+			"queries", c.queries,
+			"unique_domains", len(c.domains),
+			"verdicts", c.verdicts,
+			"event", "client_summary",
+		)...)
+	}
+	if overflow > 0 {
+		s.log.Warnw("Client Summary Overflow",
+			"queries", overflow,
+			"max_clients", maxSummaryClients,
+			"event", "client_summary_overflow",
+		)
+	}
+}
@@ -0,0 +1,473 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the process-wide registry served on /metrics
+var Default = NewRegistry()
+
+// DefBuckets are the default histogram buckets, in seconds
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// DefaultLabelLimit is the number of distinct values each label of a
+// metric may take before further values are counted as Other
+const DefaultLabelLimit = 1000
+
+// Other is the label value standing in for values past the label limit
+const Other = "other"
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add increments the counter by n
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Value returns the current count
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set sets the gauge
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    Gauge
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upper:  buckets,
+		counts: make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	for i, upper := range h.upper {
+		if v <= upper {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+	h.sum.Add(v)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+// Sum returns the sum of observations
+func (h *Histogram) Sum() float64 { return h.sum.Value() }
+
+// Quantile estimates the q-quantile (0..1) from the bucket counts by
+// linear interpolation, as Prometheus' histogram_quantile does
+func (h *Histogram) Quantile(q float64) float64 {
+	total := h.count.Load()
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	lower, prev := 0.0, uint64(0)
+	for i, upper := range h.upper {
+		count := h.counts[i].Load()
+		if float64(count) >= rank {
+			if count == prev {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-float64(prev))/float64(count-prev)
+		}
+		lower, prev = upper, count
+	}
+	return h.upper[len(h.upper)-1]
+}
+
+// Bucket returns the cumulative count of observations <= upper, using the
+// smallest configured bucket bound that is >= upper
+func (h *Histogram) Bucket(upper float64) uint64 {
+	for i, bound := range h.upper {
+		if upper <= bound {
+			return h.counts[i].Load()
+		}
+	}
+	return h.count.Load()
+}
+
+// family is a named metric with zero or more labelled children
+type family struct {
+	name       string
+	help       string
+	kind       string // counter, gauge, histogram
+	labelNames []string
+	buckets    []float64
+	registry   *Registry // for the label limit; nil for no limit
+
+	mu       sync.RWMutex
+	children map[string]interface{}
+	order    []string
+	seen     []map[string]bool // values admitted, per label
+}
+
+// child returns the metric for the given label values, creating it if needed
+func (f *family) child(values []string) interface{} {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(values)))
+	}
+
+	f.mu.RLock()
+	key, admitted, folded := f.keyLocked(values, false)
+	m, ok := f.children[key]
+	f.mu.RUnlock()
+	if ok && admitted {
+		f.countOverflow(folded)
+		return m
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, _, folded = f.keyLocked(values, true)
+	f.countOverflow(folded)
+	if m, ok := f.children[key]; ok {
+		return m
+	}
+
+	switch f.kind {
+	case "counter":
+		m = &Counter{}
+	case "gauge":
+		m = &Gauge{}
+	default:
+		m = newHistogram(f.buckets)
+	}
+	f.children[key] = m
+	f.order = append(f.order, key)
+	return m
+}
+
+// keyLocked returns the child key for values and the indexes of the
+// labels whose value was counted as Other. Each label admits values up to
+// the registry's label limit, in the order they are first seen, and
+// counts every later value as Other. Admitted values are never demoted,
+// so each series stays monotonic: an attack spraying random values fills
+// Other rather than the metrics backend, and the values seen before it
+// keep their series. With admit false (under the read lock) new values
+// are not admitted and admitted reports whether every value was either
+// admitted already or is past the limit.
+func (f *family) keyLocked(values []string, admit bool) (key string, admitted bool, folded []int) {
+	limit := f.registry.LabelLimit()
+	if limit <= 0 || len(values) == 0 {
+		return strings.Join(values, "\xff"), true, nil
+	}
+
+	admitted = true
+	mapped := values
+	for i, v := range values {
+		if f.seen[i][v] {
+			continue
+		}
+		if len(f.seen[i]) < limit {
+			if admit {
+				f.seen[i][v] = true
+			} else {
+				admitted = false
+			}
+			continue
+		}
+		if folded == nil {
+			mapped = append([]string(nil), values...)
+		}
+		mapped[i] = Other
+		folded = append(folded, i)
+	}
+	return strings.Join(mapped, "\xff"), admitted, folded
+}
+
+// countOverflow counts the labels whose value was counted as Other
+func (f *family) countOverflow(folded []int) {
+	for _, i := range folded {
+		f.registry.overflow.With(f.name, f.labelNames[i]).Inc()
+	}
+}
+
+// Registry holds metric families
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+
+	// Labels added to every sample, e.g. the serving instance
+	constNames  []string
+	constValues []string
+
+	labelLimit atomic.Int64
+	overflow   *CounterVec
+}
+
+// NewRegistry creates a registry with the default label limit. It holds
+// only ddd_metrics_label_overflow_total, which counts the label values
+// counted as Other.
+func NewRegistry() *Registry {
+	r := &Registry{families: make(map[string]*family)}
+	r.labelLimit.Store(DefaultLabelLimit)
+	r.overflow = r.CounterVec("ddd_metrics_label_overflow_total",
+		"Label values counted as other past the label limit, by metric and label", "metric", "label")
+	// Its values are metric and label names, which are bounded
+	r.overflow.f.registry = nil
+	return r
+}
+
+// SetLabelLimit sets the number of distinct values each label may take;
+// 0 removes the limit. It applies to values not yet seen.
+func (r *Registry) SetLabelLimit(n int) {
+	r.labelLimit.Store(int64(n))
+}
+
+// LabelLimit returns the label limit; 0 means none. A nil registry has
+// none.
+func (r *Registry) LabelLimit() int {
+	if r == nil {
+		return 0
+	}
+	return int(r.labelLimit.Load())
+}
+
+// SetConstLabels sets labels that are added to every exported sample, so
+// metrics aggregated across a fleet can be attributed to their node
+func (r *Registry) SetConstLabels(labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+
+	r.mu.Lock()
+	r.constNames, r.constValues = names, values
+	r.mu.Unlock()
+}
+
+// register returns the family with the given name, creating it if needed
+func (r *Registry) register(name, help, kind string, buckets []float64, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind {
+			panic(fmt.Sprintf("metrics: %s registered as %s and %s", name, f.kind, kind))
+		}
+		return f
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		registry:   r,
+		children:   make(map[string]interface{}),
+		seen:       make([]map[string]bool, len(labelNames)),
+	}
+	for i := range f.seen {
+		f.seen[i] = make(map[string]bool)
+	}
+	r.families[name] = f
+	return f
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ f *family }
+
+// With returns the counter for the label values
+func (v *CounterVec) With(values ...string) *Counter { return v.f.child(values).(*Counter) }
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ f *family }
+
+// With returns the gauge for the label values
+func (v *GaugeVec) With(values ...string) *Gauge { return v.f.child(values).(*Gauge) }
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct{ f *family }
+
+// With returns the histogram for the label values
+func (v *HistogramVec) With(values ...string) *Histogram { return v.f.child(values).(*Histogram) }
+
+// Counter registers an unlabelled counter
+func (r *Registry) Counter(name, help string) *Counter {
+	return r.register(name, help, "counter", nil, nil).child(nil).(*Counter)
+}
+
+// CounterVec registers a labelled counter
+func (r *Registry) CounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", nil, labelNames)}
+}
+
+// Gauge registers an unlabelled gauge
+func (r *Registry) Gauge(name, help string) *Gauge {
+	return r.register(name, help, "gauge", nil, nil).child(nil).(*Gauge)
+}
+
+// GaugeVec registers a labelled gauge
+func (r *Registry) GaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", nil, labelNames)}
+}
+
+// Histogram registers an unlabelled histogram
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	return r.register(name, help, "histogram", buckets, nil).child(nil).(*Histogram)
+}
+
+// HistogramVec registers a labelled histogram
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, "histogram", buckets, labelNames)}
+}
+
+// SetConstLabels sets labels added to every sample of the default registry
+func SetConstLabels(labels map[string]string) { Default.SetConstLabels(labels) }
+
+// SetLabelLimit sets the label limit of the default registry
+func SetLabelLimit(n int) { Default.SetLabelLimit(n) }
+
+// NewCounter registers an unlabelled counter in the default registry
+func NewCounter(name, help string) *Counter { return Default.Counter(name, help) }
+
+// NewCounterVec registers a labelled counter in the default registry
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.CounterVec(name, help, labelNames...)
+}
+
+// NewGauge registers an unlabelled gauge in the default registry
+func NewGauge(name, help string) *Gauge { return Default.Gauge(name, help) }
+
+// NewGaugeVec registers a labelled gauge in the default registry
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.GaugeVec(name, help, labelNames...)
+}
+
+// NewHistogram registers an unlabelled histogram in the default registry
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.Histogram(name, help, buckets)
+}
+
+// NewHistogramVec registers a labelled histogram in the default registry
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return Default.HistogramVec(name, help, buckets, labelNames...)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	constNames, constValues := r.constNames, r.constValues
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		r.mu.Unlock()
+
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind); err != nil {
+			return err
+		}
+
+		labelNames := append(append([]string(nil), constNames...), f.labelNames...)
+
+		f.mu.RLock()
+		for _, key := range f.order {
+			values := append([]string(nil), constValues...)
+			if len(f.labelNames) > 0 {
+				values = append(values, strings.Split(key, "\xff")...)
+			}
+			writeChild(w, f, labelNames, values, f.children[key])
+		}
+		f.mu.RUnlock()
+	}
+
+	return nil
+}
+
+// writeChild writes the samples of a single labelled metric
+func writeChild(w io.Writer, f *family, labelNames, values []string, m interface{}) {
+	labels := formatLabels(labelNames, values, "", "")
+
+	switch m := m.(type) {
+	case *Counter:
+		fmt.Fprintf(w, "%s%s %d\n", f.name, labels, m.Value())
+	case *Gauge:
+		fmt.Fprintf(w, "%s%s %g\n", f.name, labels, m.Value())
+	case *Histogram:
+		for i, upper := range m.upper {
+			le := formatLabels(labelNames, values, "le", fmt.Sprintf("%g", upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, le, m.counts[i].Load())
+		}
+		inf := formatLabels(labelNames, values, "le", "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, inf, m.Count())
+		fmt.Fprintf(w, "%s_sum%s %g\n", f.name, labels, m.Sum())
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, m.Count())
+	}
+}
+
+// formatLabels renders {name="value",...} with an optional extra label
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=%q", extraName, extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("ddd_test_total", "Test counter").Add(3)
+	r.CounterVec("ddd_test_labelled_total", "Labelled", "kind").With("a").Inc()
+	r.Histogram("ddd_test_seconds", "Test histogram", []float64{0.1, 1}).Observe(0.5)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"ddd_test_total 3",
+		`ddd_test_labelled_total{kind="a"} 1`,
+		`ddd_test_seconds_bucket{le="0.1"} 0`,
+		`ddd_test_seconds_bucket{le="1"} 1`,
+		`ddd_test_seconds_bucket{le="+Inf"} 1`,
+		"ddd_test_seconds_count 1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	r := NewRegistry()
+	r.SetConstLabels(map[string]string{"instance": "ams1"})
+	r.Counter("ddd_test_total", "Test counter").Inc()
+	r.CounterVec("ddd_test_labelled_total", "Labelled", "kind").With("a").Inc()
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`ddd_test_total{instance="ams1"} 1`,
+		`ddd_test_labelled_total{instance="ams1",kind="a"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := newHistogram([]float64{1, 2, 4})
+	for i := 0; i < 100; i++ {
+		h.Observe(0.5)
+	}
+
+	if q := h.Quantile(0.99); q > 1 {
+		t.Errorf("Expected p99 within the first bucket, got %v", q)
+	}
+}
+
+func TestLabelLimit(t *testing.T) {
+	r := NewRegistry()
+	r.SetLabelLimit(2)
+	v := r.CounterVec("ddd_test_domains_total", "Per domain", "domain", "kind")
+	for _, domain := range []string{"a.example", "b.example", "c.example", "d.example", "a.example"} {
+		v.With(domain, "query").Inc()
+	}
+	v.With("e.example", "query").Inc()
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`ddd_test_domains_total{domain="a.example",kind="query"} 2`,
+		`ddd_test_domains_total{domain="b.example",kind="query"} 1`,
+		`ddd_test_domains_total{domain="other",kind="query"} 3`,
+		`ddd_metrics_label_overflow_total{metric="ddd_test_domains_total",label="domain"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "c.example") {
+		t.Errorf("Expected values past the limit counted as other, got:\n%s", out)
+	}
+}
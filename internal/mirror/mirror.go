@@ -0,0 +1,115 @@
+// Package mirror copies a share of live queries to a test instance, so a
+// new version or policy can be judged against production traffic. Copies
+// are fire and forget: they are queued without waiting, sent over UDP
+// from one socket, and their answers read and discarded. A slow or
+// unreachable mirror loses copies, never delays a client.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var mirrored = metrics.NewCounterVec("ddd_mirrored_queries_total",
+	"Queries copied to the mirror, by result (sent, dropped, error)", "result")
+
+// Mirror copies queries to the mirror address
+type Mirror struct {
+	address    string
+	sampleRate float64
+	queue      chan []byte
+	log        *logger.Logger
+}
+
+// New creates a mirror for cfg, or returns nil if no address is set
+func New(cfg config.MirrorConfig, log *logger.Logger) *Mirror {
+	if cfg.Address == "" {
+		return nil
+	}
+	return &Mirror{
+		address:    cfg.Address,
+		sampleRate: cfg.SampleRate,
+		queue:      make(chan []byte, max(cfg.Queue, 1)),
+		log:        log,
+	}
+}
+
+// Offer copies r to the mirror if it is sampled. The copy is dropped if
+// the queue is full.
+func (m *Mirror) Offer(r *dns.Msg) {
+	if m == nil || rand.Float64() >= m.sampleRate {
+		return
+	}
+	packed, err := r.Pack()
+	if err != nil {
+		mirrored.With("error").Inc()
+		return
+	}
+	select {
+	case m.queue <- packed:
+	default:
+		mirrored.With("dropped").Inc()
+	}
+}
+
+// Run sends queued copies until ctx is cancelled, reconnecting with
+// backoff while the address does not resolve
+func (m *Mirror) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		conn, err := net.Dial("udp", m.address)
+		if err == nil {
+			m.log.Infow("Mirroring queries", "address", m.address, "sample_rate", m.sampleRate)
+			m.send(ctx, conn)
+			return
+		}
+		m.log.Warnw("Failed to open mirror socket", "address", m.address, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// send writes queued copies to conn until ctx is cancelled
+func (m *Mirror) send(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go discard(conn)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packed := <-m.queue:
+			// Errors are mostly ICMP unreachables of earlier copies; the
+			// socket stays usable
+			if _, err := conn.Write(packed); err != nil {
+				mirrored.With("error").Inc()
+				continue
+			}
+			mirrored.With("sent").Inc()
+		}
+	}
+}
+
+// discard reads the mirror's answers until conn is closed. Other read
+// errors are ICMP errors of earlier copies.
+func discard(conn net.Conn) {
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		if _, err := conn.Read(buf); errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package mirror
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+)
+
+func TestMirrorCopiesQueries(t *testing.T) {
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	m := New(config.MirrorConfig{Address: target.LocalAddr().String(), SampleRate: 1, Queue: 4}, logger.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	m.Offer(q)
+
+	buf := make([]byte, dns.MaxMsgSize)
+	target.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := target.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Expected the query mirrored: %v", err)
+	}
+	var copied dns.Msg
+	if err := copied.Unpack(buf[:n]); err != nil || copied.Id != q.Id || copied.Question[0].Name != "example.com." {
+		t.Errorf("Expected a copy of the query, got %v (%v)", copied.Question, err)
+	}
+}
+
+func TestMirrorSamplesAndDrops(t *testing.T) {
+	if m := New(config.MirrorConfig{}, logger.NewNop()); m != nil {
+		t.Fatal("Expected no mirror without an address")
+	}
+	var none *Mirror
+	none.Offer(new(dns.Msg))
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	off := New(config.MirrorConfig{Address: "127.0.0.1:53", SampleRate: 0, Queue: 4}, logger.NewNop())
+	off.Offer(q)
+	if len(off.queue) != 0 {
+		t.Error("Expected no copies at a sample rate of 0")
+	}
+
+	// Nothing sends: copies past the queue are dropped, not waited on
+	full := New(config.MirrorConfig{Address: "127.0.0.1:53", SampleRate: 1, Queue: 2}, logger.NewNop())
+	for i := 0; i < 5; i++ {
+		full.Offer(q)
+	}
+	if len(full.queue) != 2 {
+		t.Errorf("Expected the queue to hold 2 copies, got %d", len(full.queue))
+	}
+}
@@ -0,0 +1,95 @@
+// Package mobility keeps soft penalties from following an address to its
+// next user. Residential clients change addresses with DHCP churn, and
+// carrier-grade NAT puts many users behind one, so a rate limit or failure
+// penalty earned by one device should stay with that device. Penalties are
+// keyed to a fingerprint of how the client builds its queries, and last a
+// shorter time on address ranges known to be dynamic.
+package mobility
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+// Buckets maps queries to (IP, fingerprint) penalty buckets
+type Buckets struct {
+	dynamic []*net.IPNet
+	decay   float64
+}
+
+// New parses the dynamic ranges of cfg
+func New(cfg config.MobilityConfig) (*Buckets, error) {
+	b := &Buckets{decay: cfg.DynamicDecay}
+	for _, cidr := range cfg.DynamicRanges {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dynamic range %q: %w", cidr, err)
+		}
+		b.dynamic = append(b.dynamic, n)
+	}
+	return b, nil
+}
+
+// Fingerprint returns the QueryFingerprint of r, or "" on nil buckets,
+// keying penalties to the address alone
+func (b *Buckets) Fingerprint(r *dns.Msg) string {
+	if b == nil {
+		return ""
+	}
+	return QueryFingerprint(r)
+}
+
+// QueryFingerprint identifies the client software behind an address from
+// how it builds queries: header flags, EDNS buffer size, version, DO bit
+// and option codes, and whether it randomizes name case
+func QueryFingerprint(r *dns.Msg) string {
+	var sig strings.Builder
+	for _, flag := range []bool{r.RecursionDesired, r.CheckingDisabled, r.AuthenticatedData} {
+		sig.WriteString(strconv.FormatBool(flag))
+	}
+	if len(r.Question) > 0 {
+		name := r.Question[0].Name
+		sig.WriteString(strconv.FormatBool(name != strings.ToLower(name)))
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		fmt.Fprintf(&sig, "|%d|%d|%t", opt.UDPSize(), opt.Version(), opt.Do())
+		for _, o := range opt.Option {
+			fmt.Fprintf(&sig, ",%d", o.Option())
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(sig.String()))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// Dynamic reports whether ip is in a dynamic range
+func (b *Buckets) Dynamic(ip string) bool {
+	if b == nil {
+		return false
+	}
+	addr := net.ParseIP(ip)
+	for _, n := range b.dynamic {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decay returns how long a penalty lasting d elsewhere lasts for ip:
+// shortened in dynamic ranges, where the next user of the address is
+// likely someone else
+func (b *Buckets) Decay(ip string, d time.Duration) time.Duration {
+	if !b.Dynamic(ip) {
+		return d
+	}
+	return time.Duration(float64(d) * b.decay)
+}
@@ -0,0 +1,64 @@
+package mobility
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+func TestFingerprint(t *testing.T) {
+	b, err := New(config.MobilityConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(name string, edns bool, options ...dns.EDNS0) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		if edns {
+			m.SetEdns0(1232, true)
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, options...)
+		}
+		return m
+	}
+
+	stub := b.Fingerprint(query("example.com.", false))
+	if stub == "" || stub != b.Fingerprint(query("example.org.", false)) {
+		t.Errorf("Expected the same client to keep its fingerprint, got %q", stub)
+	}
+	for _, m := range []*dns.Msg{
+		query("example.com.", true),
+		query("eXaMpLe.com.", false),
+		query("example.com.", true, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"}),
+	} {
+		if fp := b.Fingerprint(m); fp == stub {
+			t.Errorf("Expected a different fingerprint for %v", m)
+		}
+	}
+
+	var none *Buckets
+	if fp := none.Fingerprint(query("example.com.", false)); fp != "" {
+		t.Errorf("Expected nil buckets to key by address, got %q", fp)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	b, err := New(config.MobilityConfig{DynamicRanges: []string{"100.64.0.0/10"}, DynamicDecay: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := b.Decay("100.64.1.2", time.Minute); d != 15*time.Second {
+		t.Errorf("Expected penalties in dynamic ranges to decay faster, got %v", d)
+	}
+	if d := b.Decay("192.0.2.1", time.Minute); d != time.Minute {
+		t.Errorf("Expected penalties elsewhere to keep their lifetime, got %v", d)
+	}
+
+	if _, err := New(config.MobilityConfig{DynamicRanges: []string{"100.64.0.0"}}); err == nil {
+		t.Error("Expected an invalid range to be rejected")
+	}
+}
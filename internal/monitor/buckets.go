@@ -0,0 +1,62 @@
+package monitor
+
+import "time"
+
+// maxClientBuckets caps the fingerprints tracked per IP; failures of
+// further clients only count toward the IP
+const maxClientBuckets = 16
+
+// RecordClientFailure counts a forwarded query that upstream failed or
+// refused, both for ip and for the client with the given fingerprint
+// behind it
+func (tm *TrafficMonitor) RecordClientFailure(ip, fingerprint string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists {
+		return
+	}
+	now := tm.clock()
+	if stats.failures == nil {
+		stats.failures = newSecondRing(tm.retention.RateWindow)
+	}
+	stats.failures.add(now)
+	if c := tm.minute(ip, stats, now); c != nil {
+		c.failures++
+	}
+	if fingerprint == "" {
+		return
+	}
+
+	ring, ok := stats.clientFailures[fingerprint]
+	if !ok {
+		if len(stats.clientFailures) >= maxClientBuckets {
+			return
+		}
+		if stats.clientFailures == nil {
+			stats.clientFailures = make(map[string]*secondRing)
+		}
+		ring = newSecondRing(tm.retention.RateWindow)
+		stats.clientFailures[fingerprint] = ring
+	}
+	ring.add(now)
+}
+
+// GetRecentClientFailureCount returns how many forwarded queries of the
+// client with the given fingerprint behind ip failed in the given duration
+// (at most the rate window)
+func (tm *TrafficMonitor) GetRecentClientFailureCount(ip, fingerprint string, duration time.Duration) int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists {
+		return 0
+	}
+	ring, ok := stats.clientFailures[fingerprint]
+	if !ok {
+		return 0
+	}
+	return ring.sum(tm.clock(), duration)
+}
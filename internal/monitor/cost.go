@@ -0,0 +1,42 @@
+package monitor
+
+import (
+	"math"
+	"time"
+)
+
+// costScale is the resolution of recorded costs: thousandths of a unit
+const costScale = 1000
+
+// RecordCost charges units of cost to ip for a query it sent, such as the
+// weight of how the query was answered or of the size of the answer
+func (tm *TrafficMonitor) RecordCost(ip string, units float64) {
+	scaled := int(math.Round(units * costScale))
+	if scaled <= 0 {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists {
+		return
+	}
+	if stats.cost == nil {
+		stats.cost = newSecondRing(tm.retention.RateWindow)
+	}
+	stats.cost.addN(tm.clock(), scaled)
+}
+
+// GetRecentCost returns the units of cost charged to ip in the given
+// duration (at most the rate window), and whether any were ever charged
+func (tm *TrafficMonitor) GetRecentCost(ip string, duration time.Duration) (float64, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists || stats.cost == nil {
+		return 0, false
+	}
+	return float64(stats.cost.sum(tm.clock(), duration)) / costScale, true
+}
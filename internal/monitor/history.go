@@ -0,0 +1,232 @@
+package monitor
+
+import (
+	"math"
+	"time"
+	"unsafe"
+
+	"ddd/internal/metrics"
+)
+
+var (
+	trackedIPs = metrics.NewGauge("ddd_monitor_tracked_ips",
+		"Client IPs with traffic history")
+	bytesPerIP = metrics.NewGauge("ddd_monitor_bytes_per_ip",
+		"Estimated traffic history memory per tracked IP, including its share of interned names")
+	internedDomains = metrics.NewGauge("ddd_monitor_interned_domains",
+		"Distinct query names held by client histories")
+)
+
+// domainTable interns query names so that each distinct name is stored
+// once however many histories reference it. IDs are reference counted
+// and reused once no history holds them.
+type domainTable struct {
+	ids   map[string]uint32
+	names []string
+	refs  []uint32
+	free  []uint32
+	bytes int // total length of interned names
+}
+
+func newDomainTable() *domainTable {
+	return &domainTable{ids: make(map[string]uint32)}
+}
+
+// intern returns the ID for name, taking a reference to it
+func (t *domainTable) intern(name string) uint32 {
+	if id, ok := t.ids[name]; ok {
+		t.refs[id]++
+		return id
+	}
+
+	var id uint32
+	if n := len(t.free); n > 0 {
+		id = t.free[n-1]
+		t.free = t.free[:n-1]
+		t.names[id] = name
+		t.refs[id] = 1
+	} else {
+		id = uint32(len(t.names))
+		t.names = append(t.names, name)
+		t.refs = append(t.refs, 1)
+	}
+	t.ids[name] = id
+	t.bytes += len(name)
+	return id
+}
+
+// release drops a reference to id, freeing it when none remain
+func (t *domainTable) release(id uint32) {
+	t.refs[id]--
+	if t.refs[id] > 0 {
+		return
+	}
+	name := t.names[id]
+	delete(t.ids, name)
+	t.names[id] = ""
+	t.free = append(t.free, id)
+	t.bytes -= len(name)
+}
+
+// qtypeTable interns query type names. There are few of them, so they are
+// never released.
+type qtypeTable struct {
+	ids   map[string]uint16
+	names []string
+}
+
+func newQtypeTable() *qtypeTable {
+	return &qtypeTable{ids: make(map[string]uint16)}
+}
+
+// intern returns the ID for qtype. Should the table ever fill up, further
+// types share the last ID.
+func (t *qtypeTable) intern(qtype string) uint16 {
+	if id, ok := t.ids[qtype]; ok {
+		return id
+	}
+	if len(t.names) > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	id := uint16(len(t.names))
+	t.names = append(t.names, qtype)
+	t.ids[qtype] = id
+	return id
+}
+
+// historyEntry is one query in compressed form
+type historyEntry struct {
+	domain uint32 // domainTable ID
+	delta  uint32 // microseconds after the previous entry
+	qtype  uint16 // qtypeTable ID
+}
+
+// queryHistory holds a client's recent queries, oldest first, as interned
+// name IDs and timestamps delta-encoded against the oldest entry
+type queryHistory struct {
+	base    int64 // unix microseconds of entries[0]
+	last    int64 // unix microseconds of the newest entry
+	entries []historyEntry
+}
+
+// push appends a query, dropping the oldest beyond limit entries
+func (h *queryHistory) push(domains *domainTable, domain uint32, qtype uint16, now time.Time, limit int) {
+	ts := now.UnixMicro()
+	gap := ts - h.last
+	if gap < 0 {
+		gap = 0 // clock stepped backwards
+	}
+
+	switch {
+	case len(h.entries) == 0:
+		h.base, gap = ts, 0
+	case gap > math.MaxUint32:
+		// Over an hour since the last query: older entries are outside
+		// any analysis window, so start afresh rather than widen deltas
+		h.release(domains)
+		h.base, gap = ts, 0
+	}
+
+	if len(h.entries) > 0 && len(h.entries) >= limit {
+		drop := len(h.entries) - limit + 1
+		for _, e := range h.entries[:drop] {
+			domains.release(e.domain)
+		}
+		if drop < len(h.entries) {
+			for _, e := range h.entries[1 : drop+1] {
+				h.base += int64(e.delta)
+			}
+		} else {
+			h.base, gap = ts, 0
+		}
+		n := copy(h.entries, h.entries[drop:])
+		h.entries = h.entries[:n]
+	}
+
+	h.entries = append(h.entries, historyEntry{domain: domain, delta: uint32(gap), qtype: qtype})
+	h.last = ts
+}
+
+// since decodes the queries at or after cutoff, oldest first
+func (h *queryHistory) since(domains *domainTable, qtypes *qtypeTable, cutoff time.Time) []QueryInfo {
+	from := cutoff.UnixMicro()
+	queries := make([]QueryInfo, 0)
+
+	ts := h.base
+	for i, e := range h.entries {
+		if i > 0 {
+			ts += int64(e.delta)
+		}
+		if ts <= from {
+			continue
+		}
+		qtype := ""
+		if int(e.qtype) < len(qtypes.names) {
+			qtype = qtypes.names[e.qtype]
+		}
+		queries = append(queries, QueryInfo{
+			Domain:    domains.names[e.domain],
+			QueryType: qtype,
+			Timestamp: time.UnixMicro(ts),
+		})
+	}
+	return queries
+}
+
+// count returns the number of queries after cutoff
+func (h *queryHistory) count(cutoff time.Time) int {
+	from := cutoff.UnixMicro()
+	count := 0
+
+	ts := h.base
+	for i, e := range h.entries {
+		if i > 0 {
+			ts += int64(e.delta)
+		}
+		if ts > from {
+			count++
+		}
+	}
+	return count
+}
+
+// release drops every entry and its name references
+func (h *queryHistory) release(domains *domainTable) {
+	for _, e := range h.entries {
+		domains.release(e.domain)
+	}
+	h.entries = h.entries[:0]
+}
+
+// Estimated fixed costs of map entries and per-IP state, used for the
+// memory gauge
+const (
+	mapEntryOverhead = 48
+	stringHeaderSize = int(unsafe.Sizeof(""))
+)
+
+// updateMemoryStats refreshes the history memory gauges. tm.mu must be
+// held.
+func (tm *TrafficMonitor) updateMemoryStats() {
+	trackedIPs.Set(float64(len(tm.stats)))
+	internedDomains.Set(float64(len(tm.domains.ids)))
+	if len(tm.stats) == 0 {
+		bytesPerIP.Set(0)
+		return
+	}
+
+	total := tm.domains.bytes +
+		len(tm.domains.ids)*(mapEntryOverhead+stringHeaderSize+4) +
+		cap(tm.domains.names)*stringHeaderSize + cap(tm.domains.refs)*4 + cap(tm.domains.free)*4
+
+	for ip, stats := range tm.stats {
+		total += len(ip) + mapEntryOverhead + int(unsafe.Sizeof(*stats))
+		total += cap(stats.history.entries) * int(unsafe.Sizeof(historyEntry{}))
+		total += cap(stats.secondCounts)*int(unsafe.Sizeof(int(0))) + cap(stats.secondStamps)*8
+		if stats.newDomains != nil {
+			total += len(stats.newDomains.counts)*int(unsafe.Sizeof(int(0))) + len(stats.newDomains.stamps)*8
+		}
+	}
+
+	bytesPerIP.Set(float64(total) / float64(len(tm.stats)))
+}
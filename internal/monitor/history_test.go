@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHistoryRoundTrip(t *testing.T) {
+	domains, qtypes := newDomainTable(), newQtypeTable()
+	var h queryHistory
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("host%d.example", i)
+		h.push(domains, domains.intern(name), qtypes.intern("AAAA"), start.Add(time.Duration(i)*1500*time.Millisecond), 3)
+	}
+
+	got := h.since(domains, qtypes, time.Time{})
+	if len(got) != 3 {
+		t.Fatalf("Expected history capped at 3 entries, got %d", len(got))
+	}
+	for i, q := range got {
+		wantAt := start.Add(time.Duration(i+2) * 1500 * time.Millisecond)
+		if q.Domain != fmt.Sprintf("host%d.example", i+2) || q.QueryType != "AAAA" ||
+			q.Timestamp.Sub(wantAt).Abs() > time.Microsecond {
+			t.Errorf("Entry %d decoded as %+v, want host%d.example at %v", i, q, i+2, wantAt)
+		}
+	}
+	if n := h.count(start.Add(5 * time.Second)); n != 1 {
+		t.Errorf("Expected 1 query after 5s, got %d", n)
+	}
+
+	if len(domains.ids) != 3 {
+		t.Errorf("Expected dropped names released, %d still interned", len(domains.ids))
+	}
+	h.release(domains)
+	if len(domains.ids) != 0 || domains.bytes != 0 {
+		t.Errorf("Expected all names released, %d interned (%d bytes)", len(domains.ids), domains.bytes)
+	}
+}
+
+func TestHistorySharesNames(t *testing.T) {
+	tm := NewTrafficMonitor()
+	for i := 0; i < 50; i++ {
+		tm.RecordRequest(fmt.Sprintf("192.0.2.%d", i), "popular.example", "A")
+	}
+
+	if len(tm.domains.ids) != 1 {
+		t.Errorf("Expected one interned name shared by all clients, got %d", len(tm.domains.ids))
+	}
+	if q := tm.GetRecentQueries("192.0.2.7", time.Minute); len(q) != 1 || q[0].Domain != "popular.example" {
+		t.Errorf("Unexpected history %+v", q)
+	}
+}
+
+func TestHistoryGapResets(t *testing.T) {
+	domains, qtypes := newDomainTable(), newQtypeTable()
+	var h queryHistory
+
+	start := time.Now()
+	h.push(domains, domains.intern("old.example"), qtypes.intern("A"), start, 10)
+	h.push(domains, domains.intern("new.example"), qtypes.intern("A"), start.Add(2*time.Hour), 10)
+
+	got := h.since(domains, qtypes, time.Time{})
+	if len(got) != 1 || got[0].Domain != "new.example" {
+		t.Errorf("Expected history reset after a long gap, got %+v", got)
+	}
+}
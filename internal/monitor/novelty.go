@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"strings"
+	"time"
+
+	"ddd/internal/metrics"
+)
+
+var newDomains = metrics.NewCounter("ddd_new_domains_total",
+	"Queries for names not seen before by any client")
+
+// seenDomains remembers recently queried names in two generations so
+// memory stays bounded: when the current generation fills up it replaces
+// the previous one, forgetting names not queried since
+type seenDomains struct {
+	current  map[string]struct{}
+	previous map[string]struct{}
+	capacity int
+}
+
+func newSeenDomains(capacity int) *seenDomains {
+	return &seenDomains{
+		current:  make(map[string]struct{}),
+		previous: make(map[string]struct{}),
+		capacity: capacity,
+	}
+}
+
+// observe records name and reports whether it had not been seen before
+func (s *seenDomains) observe(name string) bool {
+	if _, ok := s.current[name]; ok {
+		return false
+	}
+	_, known := s.previous[name]
+
+	if len(s.current) >= s.capacity/2 {
+		s.previous = s.current
+		s.current = make(map[string]struct{})
+	}
+	s.current[name] = struct{}{}
+
+	return !known
+}
+
+// secondRing counts events per second over a fixed span
+type secondRing struct {
+	counts []int
+	stamps []int64
+}
+
+func newSecondRing(span time.Duration) *secondRing {
+	slots := int(span / time.Second)
+	if slots < 1 {
+		slots = 1
+	}
+	return &secondRing{counts: make([]int, slots), stamps: make([]int64, slots)}
+}
+
+func (r *secondRing) add(now time.Time) {
+	r.addN(now, 1)
+}
+
+// addN counts n events at now
+func (r *secondRing) addN(now time.Time, n int) {
+	sec := now.Unix()
+	slot := sec % int64(len(r.counts))
+	if r.stamps[slot] != sec {
+		r.stamps[slot] = sec
+		r.counts[slot] = 0
+	}
+	r.counts[slot] += n
+}
+
+// sum returns the events in the last duration, capped at the ring's span
+func (r *secondRing) sum(now time.Time, duration time.Duration) int {
+	sec := now.Unix()
+	oldest := sec - int64(duration/time.Second)
+	total := 0
+	for i, stamp := range r.stamps {
+		if stamp > oldest && stamp <= sec {
+			total += r.counts[i]
+		}
+	}
+	return total
+}
+
+// recordNovelty counts domain as a new-domain query for stats if no client
+// has queried it recently. tm.mu must be held.
+func (tm *TrafficMonitor) recordNovelty(ip string, stats *IPStats, domain string, now time.Time) {
+	if tm.seen == nil || !tm.seen.observe(strings.ToLower(domain)) {
+		return
+	}
+	newDomains.Inc()
+	if stats.newDomains == nil {
+		stats.newDomains = newSecondRing(tm.retention.RateWindow)
+	}
+	stats.newDomains.add(now)
+	tm.globalNewDomains.add(now)
+	if c := tm.minute(ip, stats, now); c != nil {
+		c.newDomains++
+	}
+}
+
+// GetRecentNewDomainCount returns how many names no client had queried
+// before that ip introduced in the given duration (at most the rate window)
+func (tm *TrafficMonitor) GetRecentNewDomainCount(ip string, duration time.Duration) int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists || stats.newDomains == nil {
+		return 0
+	}
+	return stats.newDomains.sum(tm.clock(), duration)
+}
+
+// GetGlobalNewDomainCount returns how many never-before-seen names all
+// clients together introduced in the given duration (at most the rate
+// window)
+func (tm *TrafficMonitor) GetGlobalNewDomainCount(duration time.Duration) int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	if tm.globalNewDomains == nil {
+		return 0
+	}
+	return tm.globalNewDomains.sum(tm.clock(), duration)
+}
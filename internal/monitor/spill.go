@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/metrics"
+)
+
+var spillDropped = metrics.NewCounter("ddd_archive_aggregates_dropped_total",
+	"Per-minute aggregates dropped because too many were waiting to be spilled")
+
+// maxPendingSpill bounds aggregates waiting for the next spill
+const maxPendingSpill = 1 << 20
+
+// minuteCounts accumulates an IP's traffic during its current minute
+type minuteCounts struct {
+	minute     int64 // unix minute
+	requests   int
+	newDomains int
+	failures   int
+}
+
+// WithArchive spills per-minute aggregates of every client to store, the
+// warm tier behind the in-memory history
+func (tm *TrafficMonitor) WithArchive(store *archive.Store) *TrafficMonitor {
+	tm.archive = store
+	return tm
+}
+
+// minute returns stats' counters for the minute of now, queueing the
+// finished previous minute for spilling. It returns nil without an
+// archive. tm.mu must be held.
+func (tm *TrafficMonitor) minute(ip string, stats *IPStats, now time.Time) *minuteCounts {
+	if tm.archive == nil {
+		return nil
+	}
+	m := now.Unix() / 60
+	if stats.current.minute != m {
+		tm.queueLocked(ip, stats)
+		stats.current = minuteCounts{minute: m}
+	}
+	return &stats.current
+}
+
+// queueLocked queues stats' current minute for spilling if it saw any
+// traffic. tm.mu must be held.
+func (tm *TrafficMonitor) queueLocked(ip string, stats *IPStats) {
+	c := stats.current
+	if c.requests == 0 && c.failures == 0 {
+		return
+	}
+	if len(tm.pendingSpill) >= maxPendingSpill {
+		spillDropped.Inc()
+		return
+	}
+	tm.pendingSpill = append(tm.pendingSpill, archive.Aggregate{
+		IP:         ip,
+		Minute:     time.Unix(c.minute*60, 0),
+		Requests:   c.requests,
+		NewDomains: c.newDomains,
+		Failures:   c.failures,
+	})
+	stats.current = minuteCounts{minute: c.minute}
+}
+
+// Spill writes every finished minute to the archive
+func (tm *TrafficMonitor) Spill() {
+	tm.spill(tm.clock().Unix() / 60)
+}
+
+// Flush writes every minute to the archive, including the current one,
+// for shutdown
+func (tm *TrafficMonitor) Flush() {
+	tm.spill(math.MaxInt64)
+}
+
+// spill writes the minutes before the given unix minute to the archive
+func (tm *TrafficMonitor) spill(before int64) {
+	if tm.archive == nil {
+		return
+	}
+
+	tm.mu.Lock()
+	for ip, stats := range tm.stats {
+		if stats.current.minute < before {
+			tm.queueLocked(ip, stats)
+		}
+	}
+	pending := tm.pendingSpill
+	tm.pendingSpill = nil
+	tm.mu.Unlock()
+
+	tm.archive.Append(pending)
+}
+
+// StartSpill spills finished minutes to the archive every interval until
+// ctx is cancelled
+func (tm *TrafficMonitor) StartSpill(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tm.Spill()
+		}
+	}
+}
+
+// CurrentMinute returns ip's traffic in the minute not yet spilled
+func (tm *TrafficMonitor) CurrentMinute(ip string) (archive.Aggregate, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists || stats.current.requests == 0 && stats.current.failures == 0 {
+		return archive.Aggregate{}, false
+	}
+	c := stats.current
+	return archive.Aggregate{
+		IP:         ip,
+		Minute:     time.Unix(c.minute*60, 0),
+		Requests:   c.requests,
+		NewDomains: c.newDomains,
+		Failures:   c.failures,
+	}, true
+}
@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/logger"
+)
+
+func TestSpill(t *testing.T) {
+	store, err := archive.Open(t.TempDir(), time.Hour, logger.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	retention := DefaultRetention()
+	retention.SeenDomains = 100
+	tm := NewTrafficMonitorWithRetention(retention).WithArchive(store)
+	tm.RecordRequest("192.0.2.1", "a.example.com", "A")
+	tm.RecordRequest("192.0.2.1", "a.example.com", "A")
+	tm.RecordFailure("192.0.2.1")
+
+	cur, ok := tm.CurrentMinute("192.0.2.1")
+	if !ok || cur.Requests != 2 || cur.NewDomains != 1 || cur.Failures != 1 {
+		t.Fatalf("Unexpected current minute %+v", cur)
+	}
+
+	// The current minute is only written on a flush
+	tm.Spill()
+	if h, _ := store.History("192.0.2.1", time.Time{}); len(h) != 0 {
+		t.Errorf("Expected an unfinished minute kept in memory, got %+v", h)
+	}
+	tm.Flush()
+	h, err := store.History("192.0.2.1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 1 || h[0] != cur {
+		t.Errorf("Expected the minute spilled, got %+v", h)
+	}
+	if _, ok := tm.CurrentMinute("192.0.2.1"); ok {
+		t.Error("Expected nothing left after a flush")
+	}
+}
@@ -0,0 +1,49 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+// Talker is a client and its request count over the rate window
+type Talker struct {
+	IP       string
+	Requests int
+}
+
+// TopTalkers returns the n clients with the most requests over the rate
+// window, busiest first, and the total requests from all clients over it
+func (tm *TrafficMonitor) TopTalkers(n int) (talkers []Talker, total int) {
+	tm.mu.RLock()
+	now := tm.clock().Unix()
+	oldest := now - int64(tm.retention.RateWindow/time.Second)
+	for ip, stats := range tm.stats {
+		count := 0
+		for i, stamp := range stats.secondStamps {
+			if stamp > oldest && stamp <= now {
+				count += stats.secondCounts[i]
+			}
+		}
+		if count > 0 {
+			talkers = append(talkers, Talker{IP: ip, Requests: count})
+			total += count
+		}
+	}
+	tm.mu.RUnlock()
+
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Requests != talkers[j].Requests {
+			return talkers[i].Requests > talkers[j].Requests
+		}
+		return talkers[i].IP < talkers[j].IP
+	})
+	if n < len(talkers) {
+		talkers = talkers[:n]
+	}
+	return talkers, total
+}
+
+// RateWindow returns the window TopTalkers and exact request counts cover
+func (tm *TrafficMonitor) RateWindow() time.Duration {
+	return tm.retention.RateWindow
+}
@@ -0,0 +1,162 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+// Trace event kinds
+const (
+	TraceQuery = "query" // a query kept in the client's history
+	// TraceRequests counts requests in one second beyond those kept in the
+	// history, which rate checks see but pattern checks do not
+	TraceRequests      = "requests"
+	TraceNewDomain     = "new_domain" // never-before-seen names queried in one second
+	TraceFailure       = "failure"    // failed forwarded queries in one second
+	TraceProtocolAbuse = "protocol_abuse"
+)
+
+// TraceEvent is one entry of a client's event stream
+type TraceEvent struct {
+	At        time.Time `json:"at"`
+	Kind      string    `json:"kind"`
+	Domain    string    `json:"domain,omitempty"`
+	QueryType string    `json:"qtype,omitempty"`
+	// Fingerprint is the client behind the address a failure is counted
+	// for, empty for failures counted for the address
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Count       int    `json:"count,omitempty"` // events in the second, for counted kinds
+}
+
+// Trace is what the monitor holds about one client as an event stream,
+// oldest first, from which Replay rebuilds the same state
+type Trace struct {
+	IP        string       `json:"ip"`
+	Dumped    time.Time    `json:"dumped"`
+	FirstSeen time.Time    `json:"first_seen"`
+	Requests  int          `json:"requests"` // since first seen
+	Events    []TraceEvent `json:"events"`
+}
+
+// Trace dumps what the monitor holds about ip; false if nothing
+func (tm *TrafficMonitor) Trace(ip string) (Trace, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists {
+		return Trace{}, false
+	}
+	t := Trace{
+		IP:        ip,
+		Dumped:    tm.clock(),
+		FirstSeen: stats.FirstSeen,
+		Requests:  stats.RequestCount,
+	}
+
+	kept := make(map[int64]int)
+	for _, q := range stats.history.since(tm.domains, tm.qtypes, time.Time{}) {
+		t.Events = append(t.Events, TraceEvent{At: q.Timestamp, Kind: TraceQuery, Domain: q.Domain, QueryType: q.QueryType})
+		kept[q.Timestamp.Unix()]++
+	}
+	for i, sec := range stats.secondStamps {
+		if extra := stats.secondCounts[i] - kept[sec]; sec > 0 && extra > 0 {
+			t.Events = append(t.Events, TraceEvent{At: time.Unix(sec, 0), Kind: TraceRequests, Count: extra})
+		}
+	}
+	t.Events = stats.newDomains.trace(t.Events, TraceNewDomain, "")
+	t.Events = stats.failures.trace(t.Events, TraceFailure, "")
+	for fingerprint, ring := range stats.clientFailures {
+		t.Events = ring.trace(t.Events, TraceFailure, fingerprint)
+	}
+	t.Events = stats.protocolAbuse.trace(t.Events, TraceProtocolAbuse, "")
+
+	sort.SliceStable(t.Events, func(i, j int) bool { return t.Events[i].At.Before(t.Events[j].At) })
+	return t, true
+}
+
+// Retention returns how much history the monitor keeps, which replays of
+// its traces need
+func (tm *TrafficMonitor) Retention() Retention {
+	return tm.retention
+}
+
+// trace appends the ring's counts as events of kind
+func (r *secondRing) trace(events []TraceEvent, kind, fingerprint string) []TraceEvent {
+	if r == nil {
+		return events
+	}
+	for i, sec := range r.stamps {
+		if sec > 0 && r.counts[i] > 0 {
+			events = append(events, TraceEvent{At: time.Unix(sec, 0), Kind: kind, Fingerprint: fingerprint, Count: r.counts[i]})
+		}
+	}
+	return events
+}
+
+// Replay builds a monitor holding what trace describes as it stood at at,
+// with its clock stopped there, so that checks run on it decide as they
+// did then. Counted events are resolved to the second, so replays within
+// a second they fall in already see them all. Only the traced client's
+// names count toward the global new-domain rate.
+func Replay(trace Trace, retention Retention, at time.Time) *TrafficMonitor {
+	tm := NewTrafficMonitorWithRetention(retention)
+	tm.clock = func() time.Time { return at }
+	if tm.globalNewDomains == nil {
+		tm.globalNewDomains = newSecondRing(retention.RateWindow)
+	}
+
+	slots := int(retention.RateWindow / time.Second)
+	stats := &IPStats{
+		FirstSeen:    trace.FirstSeen,
+		RequestCount: trace.Requests,
+		secondCounts: make([]int, slots),
+		secondStamps: make([]int64, slots),
+	}
+	ring := func(r **secondRing) *secondRing {
+		if *r == nil {
+			*r = newSecondRing(retention.RateWindow)
+		}
+		return *r
+	}
+	for _, e := range trace.Events {
+		if e.At.After(at) {
+			// Not seen yet at the replayed time
+			switch e.Kind {
+			case TraceQuery:
+				stats.RequestCount--
+			case TraceRequests:
+				stats.RequestCount -= e.Count
+			}
+			continue
+		}
+		switch e.Kind {
+		case TraceQuery:
+			stats.history.push(tm.domains, tm.domains.intern(e.Domain), tm.qtypes.intern(e.QueryType), e.At, retention.HistorySize)
+			stats.countSecond(e.At, 1)
+			stats.LastRequestTime = e.At
+		case TraceRequests:
+			stats.countSecond(e.At, e.Count)
+		case TraceNewDomain:
+			ring(&stats.newDomains).addN(e.At, e.Count)
+			tm.globalNewDomains.addN(e.At, e.Count)
+		case TraceFailure:
+			if e.Fingerprint == "" {
+				ring(&stats.failures).addN(e.At, e.Count)
+				continue
+			}
+			if stats.clientFailures == nil {
+				stats.clientFailures = make(map[string]*secondRing)
+			}
+			r := stats.clientFailures[e.Fingerprint]
+			stats.clientFailures[e.Fingerprint] = ring(&r)
+			r.addN(e.At, e.Count)
+		case TraceProtocolAbuse:
+			ring(&stats.protocolAbuse).addN(e.At, e.Count)
+		}
+	}
+	if !trace.FirstSeen.After(at) {
+		tm.stats[trace.IP] = stats
+	}
+	return tm
+}
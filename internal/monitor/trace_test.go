@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTraceReplay(t *testing.T) {
+	retention := DefaultRetention()
+	retention.HistorySize = 10
+	retention.SeenDomains = 100
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tm := NewTrafficMonitorWithRetention(retention).WithClock(func() time.Time { return now })
+	for i := 0; i < 30; i++ {
+		tm.RecordRequest("192.0.2.1", "a.example.com", "A")
+		if i%10 == 0 {
+			tm.RecordRequest("192.0.2.1", string(rune('b'+i/10))+".example.com", "AAAA")
+			tm.RecordClientFailure("192.0.2.1", "ecs:198.51.100.0/24")
+		}
+		now = now.Add(500 * time.Millisecond)
+	}
+	tm.RecordFailure("192.0.2.1")
+	tm.RecordProtocolAbuse("192.0.2.1")
+
+	trace, ok := tm.Trace("192.0.2.1")
+	if !ok {
+		t.Fatal("Expected a trace of a seen client")
+	}
+	if _, ok := tm.Trace("192.0.2.2"); ok {
+		t.Error("Expected no trace of an unseen client")
+	}
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Trace
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed at the dump time, the monitor answers as the original did
+	replayed := Replay(saved, retention, saved.Dumped)
+	window := retention.RateWindow
+	for name, get := range map[string]func(*TrafficMonitor) interface{}{
+		"requests":     func(m *TrafficMonitor) interface{} { return m.GetRecentRequestCount("192.0.2.1", window) },
+		"10s requests": func(m *TrafficMonitor) interface{} { return m.GetRecentRequestCount("192.0.2.1", 10*time.Second) },
+		"queries":      func(m *TrafficMonitor) interface{} { return m.GetRecentQueries("192.0.2.1", window) },
+		"failures":     func(m *TrafficMonitor) interface{} { return m.GetRecentFailureCount("192.0.2.1", window) },
+		"client failures": func(m *TrafficMonitor) interface{} {
+			return m.GetRecentClientFailureCount("192.0.2.1", "ecs:198.51.100.0/24", window)
+		},
+		"new domains": func(m *TrafficMonitor) interface{} { return m.GetRecentNewDomainCount("192.0.2.1", window) },
+		"global":      func(m *TrafficMonitor) interface{} { return m.GetGlobalNewDomainCount(window) },
+	} {
+		if want, got := get(tm), get(replayed); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: expected %v replayed, got %v", name, want, got)
+		}
+	}
+	firstSeen, requests, _ := replayed.GetClientAge("192.0.2.1")
+	if !firstSeen.Equal(trace.FirstSeen) || requests != 33 {
+		t.Errorf("Expected the client's age replayed, got %v, %d", firstSeen, requests)
+	}
+
+	// Earlier on, later traffic has not happened yet. Requests no longer
+	// in the history are counted per second.
+	early := Replay(saved, retention, trace.FirstSeen.Add(5*time.Second-time.Nanosecond))
+	if got := early.GetRecentRequestCount("192.0.2.1", window); got != 11 {
+		t.Errorf("Expected 11 requests in the first 5 seconds, got %d", got)
+	}
+	if _, requests, _ := early.GetClientAge("192.0.2.1"); requests != 11 {
+		t.Errorf("Expected 11 requests since first seen, got %d", requests)
+	}
+	if _, _, ok := Replay(saved, retention, trace.FirstSeen.Add(-time.Second)).GetClientAge("192.0.2.1"); ok {
+		t.Error("Expected no client before it was first seen")
+	}
+}
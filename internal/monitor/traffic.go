@@ -4,14 +4,48 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"ddd/internal/archive"
+	"ddd/internal/schedule"
 )
 
 // IPStats holds statistics for a single IP address
 type IPStats struct {
 	RequestCount    int
 	LastRequestTime time.Time
-	Queries         []QueryInfo
+	Queries         []QueryInfo // filled in copies only; see history
 	FirstSeen       time.Time
+
+	// history holds recent queries in compressed form
+	history queryHistory
+
+	// Per-second request counters covering the rate window. Unlike
+	// Queries these are not capped, so rate checks see the true volume.
+	secondCounts []int
+	secondStamps []int64
+
+	// newDomains counts this IP's queries for names no client had queried
+	// before; nil until the first one
+	newDomains *secondRing
+
+	// protocolAbuse counts malformed or abusive messages; nil until the
+	// first one
+	protocolAbuse *secondRing
+
+	// failures counts SERVFAIL and REFUSED answers to this IP's forwarded
+	// queries; nil until the first one
+	failures *secondRing
+
+	// clientFailures splits failures by client fingerprint; nil until the
+	// first one
+	clientFailures map[string]*secondRing
+
+	// cost sums the cost charged for this IP's queries, in thousandths of
+	// a unit; nil until the first charge
+	cost *secondRing
+
+	// current accumulates this minute's traffic for the archive
+	current minuteCounts
 }
 
 // QueryInfo holds information about a DNS query
@@ -21,17 +55,76 @@ type QueryInfo struct {
 	Timestamp time.Time
 }
 
+// Retention controls how much per-IP history the monitor keeps
+type Retention struct {
+	HistorySize int           // queries kept per IP
+	MaxAge      time.Duration // idle time after which an IP's stats are dropped
+	RateWindow  time.Duration // longest window served by exact request counts
+	SeenDomains int           // distinct names remembered for new-domain counts; 0 disables them
+}
+
+// DefaultRetention returns the built-in retention settings
+func DefaultRetention() Retention {
+	return Retention{
+		HistorySize: 100,
+		MaxAge:      30 * time.Minute,
+		RateWindow:  time.Minute,
+	}
+}
+
 // TrafficMonitor monitors traffic per IP address
 type TrafficMonitor struct {
-	mu    sync.RWMutex
-	stats map[string]*IPStats
+	mu        sync.RWMutex
+	stats     map[string]*IPStats
+	retention Retention
+
+	seen             *seenDomains
+	globalNewDomains *secondRing
+
+	domains *domainTable
+	qtypes  *qtypeTable
+
+	// archive receives per-minute aggregates (optional)
+	archive      *archive.Store
+	pendingSpill []archive.Aggregate
+
+	// clock is the time source, the system clock outside replays
+	clock func() time.Time
 }
 
-// NewTrafficMonitor creates a new traffic monitor
+// NewTrafficMonitor creates a new traffic monitor with default retention
 func NewTrafficMonitor() *TrafficMonitor {
-	return &TrafficMonitor{
-		stats: make(map[string]*IPStats),
+	return NewTrafficMonitorWithRetention(DefaultRetention())
+}
+
+// NewTrafficMonitorWithRetention creates a traffic monitor that keeps the
+// given amount of history
+func NewTrafficMonitorWithRetention(retention Retention) *TrafficMonitor {
+	tm := &TrafficMonitor{
+		stats:     make(map[string]*IPStats),
+		retention: retention,
+		domains:   newDomainTable(),
+		qtypes:    newQtypeTable(),
+		clock:     time.Now,
+	}
+	if retention.SeenDomains > 0 {
+		tm.seen = newSeenDomains(retention.SeenDomains)
+		tm.globalNewDomains = newSecondRing(retention.RateWindow)
 	}
+	return tm
+}
+
+// WithClock makes the monitor read the time from now instead of the
+// system clock
+func (tm *TrafficMonitor) WithClock(now func() time.Time) *TrafficMonitor {
+	tm.clock = now
+	return tm
+}
+
+// Now returns the monitor's current time, which checks against its
+// counters must use
+func (tm *TrafficMonitor) Now() time.Time {
+	return tm.clock()
 }
 
 // RecordRequest records a DNS request from an IP
@@ -40,26 +133,38 @@ func (tm *TrafficMonitor) RecordRequest(ip, domain, qtype string) {
 	defer tm.mu.Unlock()
 
 	if _, exists := tm.stats[ip]; !exists {
+		slots := int(tm.retention.RateWindow / time.Second)
 		tm.stats[ip] = &IPStats{
-			FirstSeen: time.Now(),
-			Queries:   make([]QueryInfo, 0),
+			FirstSeen:    tm.clock(),
+			secondCounts: make([]int, slots),
+			secondStamps: make([]int64, slots),
 		}
 	}
 
+	now := tm.clock()
 	stats := tm.stats[ip]
 	stats.RequestCount++
-	stats.LastRequestTime = time.Now()
-	
-	// Keep only last 100 queries per IP to avoid memory issues
-	if len(stats.Queries) >= 100 {
-		stats.Queries = stats.Queries[1:]
+	stats.LastRequestTime = now
+
+	stats.countSecond(now, 1)
+	if c := tm.minute(ip, stats, now); c != nil {
+		c.requests++
 	}
+	tm.recordNovelty(ip, stats, domain, now)
 	
-	stats.Queries = append(stats.Queries, QueryInfo{
-		Domain:    domain,
-		QueryType: qtype,
-		Timestamp: time.Now(),
-	})
+	// Keep only the most recent queries per IP to avoid memory issues
+	stats.history.push(tm.domains, tm.domains.intern(domain), tm.qtypes.intern(qtype), now, tm.retention.HistorySize)
+}
+
+// countSecond adds n requests to the per-second counters at now
+func (s *IPStats) countSecond(now time.Time, n int) {
+	sec := now.Unix()
+	slot := sec % int64(len(s.secondCounts))
+	if s.secondStamps[slot] != sec {
+		s.secondStamps[slot] = sec
+		s.secondCounts[slot] = 0
+	}
+	s.secondCounts[slot] += n
 }
 
 // GetIPStats returns statistics for a specific IP
@@ -73,15 +178,29 @@ func (tm *TrafficMonitor) GetIPStats(ip string) *IPStats {
 			RequestCount:    stats.RequestCount,
 			LastRequestTime: stats.LastRequestTime,
 			FirstSeen:       stats.FirstSeen,
-			Queries:         make([]QueryInfo, len(stats.Queries)),
+			Queries:         stats.history.since(tm.domains, tm.qtypes, time.Time{}),
 		}
-		copy(statsCopy.Queries, stats.Queries)
 		return statsCopy
 	}
 	return nil
 }
 
-// GetRecentRequestCount returns the number of requests in the last minute
+// GetClientAge returns when an IP was first seen and how many requests it
+// has made since then
+func (tm *TrafficMonitor) GetClientAge(ip string) (firstSeen time.Time, requests int, ok bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists {
+		return time.Time{}, 0, false
+	}
+	return stats.FirstSeen, stats.RequestCount, true
+}
+
+// GetRecentRequestCount returns the number of requests in the given duration.
+// Durations up to the rate window are served from the per-second counters;
+// longer windows fall back to the (capped) query history.
 func (tm *TrafficMonitor) GetRecentRequestCount(ip string, duration time.Duration) int {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
@@ -91,16 +210,19 @@ func (tm *TrafficMonitor) GetRecentRequestCount(ip string, duration time.Duratio
 		return 0
 	}
 
-	cutoff := time.Now().Add(-duration)
-	count := 0
-	
-	for _, query := range stats.Queries {
-		if query.Timestamp.After(cutoff) {
-			count++
+	if duration <= time.Duration(len(stats.secondCounts))*time.Second {
+		now := tm.clock().Unix()
+		oldest := now - int64(duration/time.Second)
+		count := 0
+		for i, stamp := range stats.secondStamps {
+			if stamp > oldest && stamp <= now {
+				count += stats.secondCounts[i]
+			}
 		}
+		return count
 	}
-	
-	return count
+
+	return stats.history.count(tm.clock().Add(-duration))
 }
 
 // GetRecentQueries returns queries from an IP in the specified duration
@@ -113,45 +235,74 @@ func (tm *TrafficMonitor) GetRecentQueries(ip string, duration time.Duration) []
 		return nil
 	}
 
-	cutoff := time.Now().Add(-duration)
-	recent := make([]QueryInfo, 0)
-	
-	for _, query := range stats.Queries {
-		if query.Timestamp.After(cutoff) {
-			recent = append(recent, query)
-		}
+	return stats.history.since(tm.domains, tm.qtypes, tm.clock().Add(-duration))
+}
+
+// RecordProtocolAbuse counts a malformed or abusive message from ip and
+// returns how many it has sent within the rate window
+func (tm *TrafficMonitor) RecordProtocolAbuse(ip string) int {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	stats, exists := tm.stats[ip]
+	if !exists {
+		return 1
 	}
-	
-	return recent
+	if stats.protocolAbuse == nil {
+		stats.protocolAbuse = newSecondRing(tm.retention.RateWindow)
+	}
+	now := tm.clock()
+	stats.protocolAbuse.add(now)
+	return stats.protocolAbuse.sum(now, tm.retention.RateWindow)
+}
+
+// RecordFailure counts a forwarded query from ip that upstream failed or
+// refused
+func (tm *TrafficMonitor) RecordFailure(ip string) {
+	tm.RecordClientFailure(ip, "")
 }
 
-// StartCleanup periodically cleans up old statistics
-func (tm *TrafficMonitor) StartCleanup(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// GetRecentFailureCount returns how many of ip's forwarded queries failed
+// in the given duration (at most the rate window)
+func (tm *TrafficMonitor) GetRecentFailureCount(ip string, duration time.Duration) int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			tm.cleanup()
-		}
+	stats, exists := tm.stats[ip]
+	if !exists || stats.failures == nil {
+		return 0
 	}
+	return stats.failures.sum(tm.clock(), duration)
+}
+
+// StartCleanup periodically cleans up old statistics on a jittered schedule
+func (tm *TrafficMonitor) StartCleanup(ctx context.Context, interval time.Duration, jitter float64) {
+	schedule.RunCleanup(ctx, "monitor", interval, jitter, tm.cleanup)
 }
 
-// cleanup removes old statistics (older than 1 hour)
-func (tm *TrafficMonitor) cleanup() {
+// cleanup removes statistics for IPs idle longer than the retention age
+func (tm *TrafficMonitor) cleanup() (scanned, removed int, lockHeld time.Duration) {
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
+	start := time.Now()
+	defer func() {
+		lockHeld = time.Since(start)
+		tm.mu.Unlock()
+	}()
+
+	cutoff := tm.clock().Add(-tm.retention.MaxAge)
 
-	cutoff := time.Now().Add(-30 * time. Minute)
-	
 	for ip, stats := range tm.stats {
+		scanned++
 		if stats.LastRequestTime.Before(cutoff) {
+			tm.queueLocked(ip, stats)
+			stats.history.release(tm.domains)
 			delete(tm.stats, ip)
+			removed++
 		}
 	}
+	tm.updateMemoryStats()
+
+	return scanned, removed, lockHeld
 }
 
 // GetAllStats returns all current statistics (for monitoring/debugging)
@@ -0,0 +1,202 @@
+// Package notify tells the operators of attacked zones about attacks seen
+// at this resolver, by webhook or email, at most once per interval per
+// zone.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var sent = metrics.NewCounterVec("ddd_abuse_notifications_total",
+	"Abuse notifications to zone operators, by channel and result", "channel", "result")
+
+// Notification is the report sent to a zone's contact
+type Notification struct {
+	Zone       string    `json:"zone"`
+	Domain     string    `json:"domain"`
+	AttackType string    `json:"attack_type"`
+	ObservedAt time.Time `json:"observed_at"`
+	Reporter   string    `json:"reporter"`
+	Instance   string    `json:"instance"`
+}
+
+// Notifier sends notifications for DomainAttacked events
+type Notifier struct {
+	cfg    config.NotifyConfig
+	client *http.Client
+	log    *logger.Logger
+
+	// sendMail delivers email; smtp.SendMail outside tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	mu   sync.Mutex
+	last map[string]time.Time // zone suffix -> last notification
+}
+
+// New creates a notifier
+func New(cfg config.NotifyConfig, log *logger.Logger) *Notifier {
+	return &Notifier{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		log:      log,
+		sendMail: smtp.SendMail,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// Handle notifies the contact for the zone named in a DomainAttacked
+// event. It is meant to be run by events.Consume, so slow deliveries only
+// delay this subscriber.
+func (n *Notifier) Handle(e events.Event) {
+	if e.Type != events.DomainAttacked {
+		return
+	}
+	domain := normalize(e.Domain)
+	if n.owned(domain) {
+		return
+	}
+	contact, ok := n.contact(domain)
+	if !ok {
+		return
+	}
+	zone := normalize(contact.Suffix)
+	if !n.allow(zone, e.Time) {
+		sent.With("all", "throttled").Inc()
+		return
+	}
+
+	note := Notification{
+		Zone:       zone,
+		Domain:     domain,
+		AttackType: e.Reason,
+		ObservedAt: e.Time,
+		Reporter:   n.cfg.Reporter,
+		Instance:   e.Instance,
+	}
+	if contact.Webhook.IsSet() {
+		n.deliver("webhook", zone, n.postWebhook(contact.Webhook.Value(), note))
+	}
+	if contact.Email != "" {
+		n.deliver("email", zone, n.email(contact.Email, note))
+	}
+}
+
+// deliver records the outcome of one delivery
+func (n *Notifier) deliver(channel, zone string, err error) {
+	if err != nil {
+		sent.With(channel, "failed").Inc()
+		n.log.Warnw("Abuse notification failed", "zone", zone, "channel", channel, "error", err)
+		return
+	}
+	sent.With(channel, "sent").Inc()
+	n.log.Infow("Abuse notification sent", "zone", zone, "channel", channel, "event", "abuse_notification")
+}
+
+// owned reports whether domain is in a zone run by this operator
+func (n *Notifier) owned(domain string) bool {
+	for _, suffix := range n.cfg.Owned {
+		if inZone(domain, normalize(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// contact returns the contact with the longest suffix matching domain
+func (n *Notifier) contact(domain string) (config.ZoneContact, bool) {
+	var best config.ZoneContact
+	found := false
+	for _, z := range n.cfg.Zones {
+		suffix := normalize(z.Suffix)
+		if inZone(domain, suffix) && (!found || len(suffix) > len(normalize(best.Suffix))) {
+			best, found = z, true
+		}
+	}
+	return best, found
+}
+
+// allow reports whether a zone may be notified now, recording it if so
+func (n *Notifier) allow(zone string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if last, ok := n.last[zone]; ok && now.Sub(last) < n.cfg.Interval {
+		return false
+	}
+	n.last[zone] = now
+	return true
+}
+
+// postWebhook sends the notification as JSON. Errors leave out the URL,
+// which may carry a credential.
+func (n *Notifier) postWebhook(webhook string, note Notification) error {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("posting webhook: %w", urlErr.Err)
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// email sends the notification through the configured relay
+func (n *Notifier) email(to string, note Notification) error {
+	var auth smtp.Auth
+	if n.cfg.SMTP.Username != "" {
+		host := n.cfg.SMTP.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.cfg.SMTP.Username, n.cfg.SMTP.Password.Value(), host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.SMTP.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: DNS abuse report for %s\r\n", note.Zone)
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "An attack against a zone you operate was observed at our resolver.\r\n\r\n")
+	fmt.Fprintf(&msg, "Zone:        %s\r\n", note.Zone)
+	fmt.Fprintf(&msg, "Domain:      %s\r\n", note.Domain)
+	fmt.Fprintf(&msg, "Attack type: %s\r\n", note.AttackType)
+	fmt.Fprintf(&msg, "Observed at: %s\r\n", note.ObservedAt.UTC().Format(time.RFC3339))
+	if note.Reporter != "" {
+		fmt.Fprintf(&msg, "Reporter:    %s\r\n", note.Reporter)
+	}
+
+	return n.sendMail(n.cfg.SMTP.Addr, auth, n.cfg.SMTP.From, []string{to}, msg.Bytes())
+}
+
+// normalize lowercases a name and strips surrounding dots
+func normalize(name string) string {
+	return strings.Trim(strings.ToLower(name), ".")
+}
+
+// inZone reports whether domain equals suffix or lies beneath it
+func inZone(domain, suffix string) bool {
+	return domain == suffix || strings.HasSuffix(domain, "."+suffix)
+}
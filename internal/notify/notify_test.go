@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/logger"
+)
+
+func TestWebhookNotificationThrottled(t *testing.T) {
+	var got []Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var note Notification
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			t.Errorf("Bad notification body: %v", err)
+		}
+		got = append(got, note)
+	}))
+	defer srv.Close()
+
+	n := New(config.NotifyConfig{
+		Reporter: "resolver.example.net",
+		Interval: time.Hour,
+		Owned:    []string{"mine.example"},
+		Zones: []config.ZoneContact{
+			{Suffix: "example", Webhook: config.InlineSecret(srv.URL)},
+			{Suffix: "victim.example.", Webhook: config.InlineSecret(srv.URL)},
+		},
+	}, logger.NewNop())
+
+	now := time.Now()
+	n.Handle(events.Event{Type: events.DomainAttacked, Domain: "Victim.example", Reason: "random_subdomain", Time: now})
+	n.Handle(events.Event{Type: events.DomainAttacked, Domain: "victim.example", Reason: "nxdomain_flood", Time: now.Add(time.Minute)})
+	n.Handle(events.Event{Type: events.DomainAttacked, Domain: "mine.example", Reason: "random_subdomain", Time: now})
+	n.Handle(events.Event{Type: events.DomainAttacked, Domain: "victim.org", Reason: "random_subdomain", Time: now})
+	n.Handle(events.Event{Type: events.IPBlocked, Domain: "other.example", Time: now})
+
+	if len(got) != 1 {
+		t.Fatalf("Expected one notification, got %d: %+v", len(got), got)
+	}
+	if got[0].Zone != "victim.example" || got[0].AttackType != "random_subdomain" || got[0].Reporter != "resolver.example.net" {
+		t.Errorf("Unexpected notification %+v", got[0])
+	}
+}
+
+func TestEmailNotification(t *testing.T) {
+	var to []string
+	var body string
+	n := New(config.NotifyConfig{
+		Interval: time.Hour,
+		Zones:    []config.ZoneContact{{Suffix: "victim.example", Email: "abuse@victim.example"}},
+		SMTP:     config.SMTPConfig{Addr: "mail.example.net:25", From: "noc@example.net"},
+	}, logger.NewNop())
+	n.sendMail = func(addr string, a smtp.Auth, from string, rcpt []string, msg []byte) error {
+		to, body = rcpt, string(msg)
+		return nil
+	}
+
+	n.Handle(events.Event{Type: events.DomainAttacked, Domain: "victim.example", Reason: "nxdomain_flood", Time: time.Now()})
+
+	if len(to) != 1 || to[0] != "abuse@victim.example" {
+		t.Fatalf("Expected mail to the zone contact, got %v", to)
+	}
+	if !strings.Contains(body, "Subject: DNS abuse report for victim.example") || !strings.Contains(body, "nxdomain_flood") {
+		t.Errorf("Unexpected message:\n%s", body)
+	}
+}
@@ -0,0 +1,175 @@
+// Package policy delegates borderline mitigation decisions to an external
+// decision service. The service is asked over HTTP with a strict timeout;
+// whenever it is slow, busy, unreachable or answers nonsense, the local
+// decision stands.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddd/internal/metrics"
+	"ddd/internal/severity"
+)
+
+var decisions = metrics.NewCounterVec("ddd_policy_decisions_total",
+	"Borderline mitigation decisions, by decision and source (remote, cache, fallback)",
+	"decision", "source")
+
+// maxCached bounds the per-client decision cache
+const maxCached = 10000
+
+// Decision is the mitigation applied to a client
+type Decision string
+
+const (
+	Allow     Decision = "allow"
+	RateLimit Decision = "rate_limit"
+	Block     Decision = "block"
+)
+
+// valid reports whether d is a known decision
+func (d Decision) valid() bool {
+	return d == Allow || d == RateLimit || d == Block
+}
+
+// Request is the client context sent to the decision service
+type Request struct {
+	ClientIP    string         `json:"client_ip"`
+	AttackType  string         `json:"attack_type"`
+	Severity    severity.Level `json:"severity"`
+	Description string         `json:"description"`
+	Domain      string         `json:"domain"`
+	QueryType   string         `json:"query_type"`
+	// Evidence lists the names a detection judging names was made on
+	Evidence []string `json:"evidence,omitempty"`
+}
+
+// response is the decision service's answer
+type response struct {
+	Decision Decision `json:"decision"`
+}
+
+// cached is a remembered decision for a client
+type cached struct {
+	decision Decision
+	expires  time.Time
+}
+
+// Hook asks the decision service about borderline detections
+type Hook struct {
+	url         string
+	client      *http.Client
+	cacheTTL    time.Duration
+	maxInFlight int64
+
+	inFlight atomic.Int64
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+// New creates a hook posting to url. Each request is abandoned after
+// timeout; answers are reused for a client for cacheTTL; at most
+// maxInFlight requests run at once (0 means unlimited).
+func New(url string, timeout, cacheTTL time.Duration, maxInFlight int) *Hook {
+	return &Hook{
+		url:         url,
+		client:      &http.Client{Timeout: timeout},
+		cacheTTL:    cacheTTL,
+		maxInFlight: int64(maxInFlight),
+		cache:       make(map[string]cached),
+	}
+}
+
+// Decide returns the decision service's verdict for req, or fallback when
+// it cannot be had in time. It is safe to call on a nil hook, which always
+// returns fallback.
+func (h *Hook) Decide(req Request, fallback Decision) Decision {
+	if h == nil {
+		return fallback
+	}
+	now := time.Now()
+
+	if d, ok := h.lookup(req.ClientIP, now); ok {
+		decisions.With(string(d), "cache").Inc()
+		return d
+	}
+
+	if n := h.inFlight.Add(1); h.maxInFlight > 0 && n > h.maxInFlight {
+		h.inFlight.Add(-1)
+		decisions.With(string(fallback), "fallback").Inc()
+		return fallback
+	}
+	d, ok := h.ask(req)
+	h.inFlight.Add(-1)
+
+	if !ok {
+		decisions.With(string(fallback), "fallback").Inc()
+		return fallback
+	}
+	h.store(req.ClientIP, d, now)
+	decisions.With(string(d), "remote").Inc()
+	return d
+}
+
+// ask posts req to the decision service
+func (h *Hook) ask(req Request) (Decision, bool) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", false
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false
+	}
+	var answer response
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil || !answer.Decision.valid() {
+		return "", false
+	}
+	return answer.Decision, true
+}
+
+// lookup returns the cached decision for ip, if still valid
+func (h *Hook) lookup(ip string, now time.Time) (Decision, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.cache[ip]
+	if !ok || !now.Before(c.expires) {
+		return "", false
+	}
+	return c.decision, true
+}
+
+// store caches a decision for ip, dropping expired entries when full
+func (h *Hook) store(ip string, d Decision, now time.Time) {
+	if h.cacheTTL <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.cache) >= maxCached {
+		for k, c := range h.cache {
+			if !now.Before(c.expires) {
+				delete(h.cache, k)
+			}
+		}
+		if len(h.cache) >= maxCached {
+			return
+		}
+	}
+	h.cache[ip] = cached{decision: d, expires: now.Add(h.cacheTTL)}
+}
@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ddd/internal/severity"
+)
+
+func TestDecideUsesServiceAndCache(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Severity != severity.Low {
+			t.Errorf("Unexpected request %+v (%v)", req, err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"decision": "block"})
+	}))
+	defer srv.Close()
+
+	h := New(srv.URL, time.Second, time.Minute, 4)
+	req := Request{ClientIP: "192.0.2.1", AttackType: "query_burst", Severity: severity.Low}
+
+	for i := 0; i < 3; i++ {
+		if d := h.Decide(req, RateLimit); d != Block {
+			t.Fatalf("Expected the service's block decision, got %s", d)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected cached decisions to skip the service, got %d calls", calls.Load())
+	}
+}
+
+func TestDecideFallsBack(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"decision":"allow"}`))
+	}))
+	defer slow.Close()
+	bogus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"decision":"maybe"}`))
+	}))
+	defer bogus.Close()
+
+	req := Request{ClientIP: "192.0.2.2"}
+	if d := New(slow.URL, 20*time.Millisecond, 0, 0).Decide(req, RateLimit); d != RateLimit {
+		t.Errorf("Expected fallback on timeout, got %s", d)
+	}
+	if d := New(bogus.URL, time.Second, 0, 0).Decide(req, RateLimit); d != RateLimit {
+		t.Errorf("Expected fallback on an unknown decision, got %s", d)
+	}
+
+	var h *Hook
+	if d := h.Decide(req, Allow); d != Allow {
+		t.Errorf("Expected a nil hook to return the fallback, got %s", d)
+	}
+}
@@ -0,0 +1,79 @@
+package popularity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Save writes the n most queried names to path as JSON, in the form of
+// Top, so the next start can warm the cache with them. It returns the
+// number of names written.
+func (t *Tracker) Save(path string, n int) (int, error) {
+	domains := t.Top(n)
+	if domains == nil {
+		domains = []Domain{}
+	}
+	data, err := json.Marshal(domains)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".popular-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return len(domains), os.Rename(tmp.Name(), path)
+}
+
+// ReadNames reads a list of names: the JSON written by Save (or served
+// by the top domains API), or one name per line with # comments. A
+// missing file is not an error.
+func ReadNames(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var domains []Domain
+		if err := json.Unmarshal(trimmed, &domains); err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(domains))
+		for _, d := range domains {
+			if d.Name != "" {
+				names = append(names, d.Name)
+			}
+		}
+		return names, nil
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names, scanner.Err()
+}
@@ -0,0 +1,38 @@
+package popularity
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSaveAndReadNames(t *testing.T) {
+	dir := t.TempDir()
+	tr := New(100, 10, time.Hour)
+	for name, queries := range map[string]int{"example.com": 3, "example.org": 2, "example.net": 1} {
+		for i := 0; i < queries; i++ {
+			tr.Record(name)
+		}
+	}
+
+	path := filepath.Join(dir, "popular.json")
+	if saved, err := tr.Save(path, 2); err != nil || saved != 2 {
+		t.Fatalf("Expected 2 names saved, got %d (%v)", saved, err)
+	}
+	names, err := ReadNames(path)
+	if err != nil || !reflect.DeepEqual(names, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected the saved names most popular first, got %v (%v)", names, err)
+	}
+
+	list := filepath.Join(dir, "names.txt")
+	os.WriteFile(list, []byte("# warm these\nexample.com\n\n  example.org  A\n"), 0o644)
+	if names, err := ReadNames(list); err != nil || !reflect.DeepEqual(names, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected names read one per line, got %v (%v)", names, err)
+	}
+
+	if names, err := ReadNames(filepath.Join(dir, "missing")); err != nil || names != nil {
+		t.Errorf("Expected a missing file to list nothing, got %v (%v)", names, err)
+	}
+}
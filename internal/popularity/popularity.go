@@ -0,0 +1,176 @@
+// Package popularity ranks domains by how often they are queried, with
+// older queries counting for less. The ranking decides which names are
+// kept in the cache, refreshed before they expire, and still answered
+// when the server is shedding load.
+package popularity
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// rankInterval is how often the set of popular names is recomputed
+	rankInterval = 10 * time.Second
+
+	// evictSample is how many names are compared to find one to drop
+	evictSample = 16
+
+	// maxWeight triggers rescaling before forward-decay weights overflow
+	maxWeight = 1 << 60
+)
+
+// Domain is a name and its decayed query count
+type Domain struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Tracker keeps decayed query counts for a bounded number of names. Counts
+// halve every half-life. It uses forward decay: each query adds a weight
+// that grows with time, so scores never need updating to stay comparable.
+type Tracker struct {
+	maxDomains int
+	topN       int
+	halfLife   time.Duration
+
+	mu       sync.Mutex
+	landmark time.Time // when a query's weight was 1
+	scores   map[string]float64
+	ranked   time.Time
+
+	top atomic.Pointer[map[string]struct{}]
+}
+
+// New creates a tracker of at most maxDomains names, of which the topN
+// highest ranked are popular
+func New(maxDomains, topN int, halfLife time.Duration) *Tracker {
+	now := time.Now()
+	t := &Tracker{
+		maxDomains: maxDomains,
+		topN:       topN,
+		halfLife:   halfLife,
+		landmark:   now,
+		scores:     make(map[string]float64),
+		ranked:     now,
+	}
+	t.top.Store(&map[string]struct{}{})
+	return t
+}
+
+// normalize returns the form names are tracked under
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// weightLocked returns the weight of a query made at now
+func (t *Tracker) weightLocked(now time.Time) float64 {
+	w := math.Exp2(float64(now.Sub(t.landmark)) / float64(t.halfLife))
+	if w < maxWeight {
+		return w
+	}
+	for name, score := range t.scores {
+		t.scores[name] = score / w
+	}
+	t.landmark = now
+	return 1
+}
+
+// Record counts a query for name. It is safe to call on a nil tracker.
+func (t *Tracker) Record(name string) {
+	if t == nil {
+		return
+	}
+	name = normalize(name)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.scores[name]; !exists && len(t.scores) >= t.maxDomains {
+		t.evictLocked()
+	}
+	t.scores[name] += t.weightLocked(now)
+
+	if now.Sub(t.ranked) >= rankInterval {
+		t.rankLocked(now)
+	}
+}
+
+// evictLocked drops the least popular of a sample of names
+func (t *Tracker) evictLocked() {
+	var victim string
+	lowest := math.Inf(1)
+	sampled := 0
+	for name, score := range t.scores {
+		if score < lowest {
+			victim, lowest = name, score
+		}
+		if sampled++; sampled >= evictSample {
+			break
+		}
+	}
+	delete(t.scores, victim)
+}
+
+// rankLocked recomputes the set of popular names
+func (t *Tracker) rankLocked(now time.Time) {
+	top := make(map[string]struct{}, t.topN)
+	for _, d := range t.sortedLocked(t.topN, 1) {
+		top[d.Name] = struct{}{}
+	}
+	t.top.Store(&top)
+	t.ranked = now
+}
+
+// sortedLocked returns the n highest scores, divided by scale
+func (t *Tracker) sortedLocked(n int, scale float64) []Domain {
+	domains := make([]Domain, 0, len(t.scores))
+	for name, score := range t.scores {
+		domains = append(domains, Domain{Name: name, Score: score / scale})
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Score != domains[j].Score {
+			return domains[i].Score > domains[j].Score
+		}
+		return domains[i].Name < domains[j].Name
+	})
+	if n < len(domains) {
+		domains = domains[:n]
+	}
+	return domains
+}
+
+// Popular reports whether name was among the most queried at the last
+// ranking. It is safe to call on a nil tracker.
+func (t *Tracker) Popular(name string) bool {
+	if t == nil {
+		return false
+	}
+	_, ok := (*t.top.Load())[normalize(name)]
+	return ok
+}
+
+// Top returns the n most queried names with their query counts decayed
+// to now
+func (t *Tracker) Top(n int) []Domain {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sortedLocked(n, t.weightLocked(time.Now()))
+}
+
+// Len returns the number of names tracked
+func (t *Tracker) Len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.scores)
+}
@@ -0,0 +1,76 @@
+package popularity
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRanking(t *testing.T) {
+	tr := New(100, 2, time.Minute)
+	for i := 0; i < 5; i++ {
+		tr.Record("Busy.example.com.")
+	}
+	for i := 0; i < 3; i++ {
+		tr.Record("warm.example.com")
+	}
+	tr.Record("cold.example.com")
+
+	top := tr.Top(2)
+	if len(top) != 2 || top[0].Name != "busy.example.com" || top[1].Name != "warm.example.com" {
+		t.Fatalf("Expected busy then warm, got %+v", top)
+	}
+	if math.Abs(top[0].Score-5) > 0.01 {
+		t.Errorf("Expected a score of about 5 right after recording, got %v", top[0].Score)
+	}
+
+	tr.mu.Lock()
+	tr.rankLocked(time.Now())
+	tr.mu.Unlock()
+	if !tr.Popular("BUSY.example.com.") || !tr.Popular("warm.example.com") || tr.Popular("cold.example.com") {
+		t.Error("Expected the two busiest names to be popular")
+	}
+
+	var none *Tracker
+	none.Record("example.com")
+	if none.Popular("example.com") || none.Top(1) != nil {
+		t.Error("Expected a nil tracker to rank nothing")
+	}
+}
+
+func TestDecay(t *testing.T) {
+	tr := New(100, 10, time.Minute)
+	for i := 0; i < 4; i++ {
+		tr.Record("old.example.com")
+	}
+
+	// Pretend the queries were made two half-lives ago
+	tr.mu.Lock()
+	tr.landmark = tr.landmark.Add(-2 * time.Minute)
+	tr.mu.Unlock()
+	tr.Record("new.example.com")
+	tr.Record("new.example.com")
+
+	top := tr.Top(2)
+	if top[0].Name != "new.example.com" || math.Abs(top[1].Score-1) > 0.01 {
+		t.Errorf("Expected 4 queries two half-lives ago to count as 1, got %+v", top)
+	}
+}
+
+func TestBounded(t *testing.T) {
+	tr := New(10, 5, time.Minute)
+	for i := 0; i < 3; i++ {
+		tr.Record("keep.example.com")
+	}
+	for i := 0; i < 50; i++ {
+		tr.Record(fmt.Sprintf("random%d.example.com", i))
+	}
+
+	if tr.Len() != 10 {
+		t.Errorf("Expected 10 names tracked, got %d", tr.Len())
+	}
+	if top := tr.Top(1); top[0].Name != "keep.example.com" {
+		t.Errorf("Expected the busiest name to survive eviction, got %+v", top)
+	}
+}
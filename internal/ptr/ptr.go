@@ -0,0 +1,144 @@
+// Package ptr resolves client IPs to hostnames for operator-facing logs.
+// Lookups happen in the background, are rate limited, and are cached, so
+// enrichment never delays query handling.
+package ptr
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/metrics"
+)
+
+var lookups = metrics.NewCounterVec("ddd_ptr_lookups_total",
+	"Reverse lookups of client IPs, by result", "result")
+
+// entry is a cached reverse lookup; an empty name caches a failure
+type entry struct {
+	name    string
+	expires time.Time
+}
+
+// Cache maps client IPs to hostnames
+type Cache struct {
+	upstream   string
+	client     *dns.Client
+	ttl        time.Duration
+	negTTL     time.Duration
+	rate       int // lookups started per second
+	maxEntries int
+
+	mu          sync.Mutex
+	entries     map[string]entry
+	pending     map[string]bool
+	windowStart time.Time
+	started     int
+}
+
+// New creates a cache resolving PTR records through upstream. At most rate
+// lookups are started per second; answers are kept for ttl.
+func New(upstream string, ttl time.Duration, rate, maxEntries int) *Cache {
+	return &Cache{
+		upstream:   upstream,
+		client:     &dns.Client{Timeout: 2 * time.Second},
+		ttl:        ttl,
+		negTTL:     ttl / 4,
+		rate:       rate,
+		maxEntries: maxEntries,
+		entries:    make(map[string]entry),
+		pending:    make(map[string]bool),
+	}
+}
+
+// Hostname returns the cached hostname for ip, or "" if it is unknown. An
+// unknown IP is scheduled for lookup when the rate limit allows, so later
+// calls return the name. It is safe to call on a nil cache.
+func (c *Cache) Hostname(ip string) string {
+	if c == nil {
+		return ""
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[ip]; ok && now.Before(e.expires) {
+		return e.name
+	}
+	if c.pending[ip] {
+		return ""
+	}
+
+	if now.Sub(c.windowStart) >= time.Second {
+		c.windowStart, c.started = now, 0
+	}
+	if c.started >= c.rate {
+		lookups.With("rate_limited").Inc()
+		return ""
+	}
+	c.started++
+	c.pending[ip] = true
+
+	go c.resolve(ip)
+	return ""
+}
+
+// resolve looks up the PTR record for ip and caches the result
+func (c *Cache) resolve(ip string) {
+	name := c.lookup(ip)
+
+	ttl := c.ttl
+	if name == "" {
+		ttl = c.negTTL
+		lookups.With("failed").Inc()
+	} else {
+		lookups.With("resolved").Inc()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, ip)
+	if len(c.entries) >= c.maxEntries {
+		c.evictLocked()
+	}
+	c.entries[ip] = entry{name: name, expires: time.Now().Add(ttl)}
+}
+
+// lookup queries upstream for the first PTR name of ip
+func (c *Cache) lookup(ip string) string {
+	arpa, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return ""
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion(arpa, dns.TypePTR)
+
+	resp, _, err := c.client.Exchange(q, c.upstream)
+	if err != nil || resp.Rcode != dns.RcodeSuccess {
+		return ""
+	}
+	for _, rr := range resp.Answer {
+		if p, ok := rr.(*dns.PTR); ok {
+			return strings.TrimSuffix(p.Ptr, ".")
+		}
+	}
+	return ""
+}
+
+// evictLocked drops expired entries, or everything if none have expired
+func (c *Cache) evictLocked() {
+	now := time.Now()
+	for ip, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, ip)
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.entries = make(map[string]entry)
+	}
+}
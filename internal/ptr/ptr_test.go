@@ -0,0 +1,71 @@
+package ptr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startPTRServer serves a fixed PTR answer on a random local UDP port
+func startPTRServer(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN PTR host.example.net.")
+		m.Answer = []dns.RR{rr}
+		w.WriteMsg(m)
+	})}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestHostnameResolvedInBackground(t *testing.T) {
+	c := New(startPTRServer(t), time.Hour, 10, 100)
+
+	if name := c.Hostname("192.0.2.7"); name != "" {
+		t.Fatalf("Expected first call to return immediately without a name, got %q", name)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if name := c.Hostname("192.0.2.7"); name != "" {
+			if name != "host.example.net" {
+				t.Errorf("Expected host.example.net, got %q", name)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected hostname to be resolved")
+}
+
+func TestLookupsRateLimited(t *testing.T) {
+	c := New("127.0.0.1:1", time.Hour, 2, 100)
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		c.Hostname(ip)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending)+len(c.entries) != 2 {
+		t.Errorf("Expected only 2 lookups to start, got %d", len(c.pending)+len(c.entries))
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	if c.Hostname("192.0.2.1") != "" {
+		t.Error("Expected nil cache to return no hostname")
+	}
+}
@@ -0,0 +1,348 @@
+//go:build recursion
+
+// Package recursor is a minimal iterative resolver starting from the root
+// hints. It exists for emergencies, when every configured upstream is
+// down, and resolves a small allowlist of names rather than serving as a
+// general purpose resolver: no DNSSEC validation, IPv4 transport only, and
+// a hard budget of exchanges per lookup. It is built in only with the
+// recursion tag.
+package recursor
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/buildinfo"
+)
+
+// Available reports whether recursion is built in
+const Available = true
+
+func init() {
+	buildinfo.Register("recursion")
+}
+
+// RootHints are the IPv4 addresses of the root servers, a through m
+var RootHints = []string{
+	"198.41.0.4", "170.247.170.2", "192.33.4.12", "199.7.91.13",
+	"192.203.230.10", "192.5.5.241", "192.112.36.4", "198.97.190.53",
+	"192.36.148.17", "192.58.128.30", "193.0.14.129", "199.7.83.42",
+	"202.12.27.33",
+}
+
+const (
+	maxCNAMEs        = 8   // CNAMEs followed per lookup
+	maxDepth         = 4   // nested lookups of glueless name server addresses
+	maxZones         = 512 // cached delegations
+	serversPerAsk    = 3   // name servers tried per delegation step
+	minDelegationTTL = time.Minute
+)
+
+var (
+	// ErrBudget means a lookup needed more exchanges than allowed
+	ErrBudget = errors.New("recursion: exchange budget exhausted")
+	// ErrLame means no name server of a zone gave a usable answer
+	ErrLame = errors.New("recursion: no usable answer from name servers")
+)
+
+// delegation is a cached referral: the addresses of a zone's name servers
+type delegation struct {
+	servers []string
+	expires time.Time
+}
+
+// Resolver resolves names iteratively from the root
+type Resolver struct {
+	roots      []string
+	port       string
+	client     *dns.Client
+	maxQueries int
+
+	// exchange sends one query; replaced in tests
+	exchange func(m *dns.Msg, addr string) (*dns.Msg, error)
+
+	mu    sync.Mutex
+	zones map[string]delegation // zone (FQDN, lower case) -> name servers
+}
+
+// New creates a resolver starting from roots (RootHints when empty),
+// waiting timeout for each exchange and giving up on a lookup after
+// maxQueries exchanges
+func New(roots []string, timeout time.Duration, maxQueries int) *Resolver {
+	if len(roots) == 0 {
+		roots = RootHints
+	}
+	r := &Resolver{
+		roots:      roots,
+		port:       "53",
+		client:     &dns.Client{Timeout: timeout},
+		maxQueries: maxQueries,
+		zones:      make(map[string]delegation),
+	}
+	r.exchange = func(m *dns.Msg, addr string) (*dns.Msg, error) {
+		resp, _, err := r.client.Exchange(m, addr)
+		return resp, err
+	}
+	return r
+}
+
+// lookup is the state of one resolution
+type lookup struct {
+	queries int
+}
+
+// Resolve answers the question of req by iterating from the closest known
+// delegation. The reply has RA set and carries the full CNAME chain.
+func (r *Resolver) Resolve(req *dns.Msg) (*dns.Msg, error) {
+	if len(req.Question) == 0 {
+		return nil, errors.New("recursion: no question")
+	}
+	q := req.Question[0]
+	l := &lookup{}
+
+	var chain []dns.RR
+	name := dns.CanonicalName(q.Name)
+	for i := 0; ; i++ {
+		resp, err := r.resolve(l, name, q.Qtype, 0)
+		if err != nil {
+			return nil, err
+		}
+		answer, target := answerFor(resp, name, q.Qtype)
+		chain = append(chain, answer...)
+		if target == "" || i == maxCNAMEs || resp.Rcode != dns.RcodeSuccess {
+			return reply(req, resp, chain), nil
+		}
+		name = target
+	}
+}
+
+// reply builds the answer to req from the final response and the records
+// collected along the CNAME chain
+func reply(req, resp *dns.Msg, chain []dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = true
+	m.Rcode = resp.Rcode
+	m.Answer = chain
+	if len(chain) == 0 || resp.Rcode != dns.RcodeSuccess {
+		m.Ns = resp.Ns // SOA for negative caching
+	}
+	return m
+}
+
+// answerFor returns the records of resp that answer name, following
+// CNAMEs within resp, and the CNAME target still to be resolved when resp
+// ends the chain without an answer for it
+func answerFor(resp *dns.Msg, name string, qtype uint16) ([]dns.RR, string) {
+	var answer []dns.RR
+	owner := name
+	for i := 0; i <= maxCNAMEs; i++ {
+		var next string
+		matched := false
+		for _, rr := range resp.Answer {
+			if !strings.EqualFold(rr.Header().Name, owner) {
+				continue
+			}
+			if rr.Header().Rrtype == qtype || qtype == dns.TypeANY {
+				answer = append(answer, rr)
+				matched = true
+			} else if cname, ok := rr.(*dns.CNAME); ok {
+				answer = append(answer, rr)
+				next = dns.CanonicalName(cname.Target)
+			}
+		}
+		switch {
+		case matched:
+			return answer, ""
+		case next == "" && owner == name:
+			return answer, "" // NODATA
+		case next == "":
+			return answer, owner
+		}
+		owner = next
+	}
+	return answer, owner
+}
+
+// resolve follows referrals for name until a server answers authoritatively
+func (r *Resolver) resolve(l *lookup, name string, qtype uint16, depth int) (*dns.Msg, error) {
+	zone, servers := r.closest(name)
+	for {
+		resp, err := r.ask(l, servers, name, qtype)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) > 0 {
+			return resp, nil
+		}
+
+		child, names, ttl := referral(resp, zone, name)
+		if child == "" {
+			return resp, nil // NODATA
+		}
+		addrs := glue(resp, names, zone)
+		if len(addrs) == 0 {
+			if depth >= maxDepth {
+				return nil, ErrLame
+			}
+			addrs, err = r.lookupServers(l, names, depth)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.remember(child, addrs, ttl)
+		zone, servers = child, addrs
+	}
+}
+
+// ask sends the question to up to serversPerAsk of servers in random
+// order and returns the first usable response
+func (r *Resolver) ask(l *lookup, servers []string, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(1232, false)
+
+	err := ErrLame
+	for i, n := range rand.Perm(len(servers)) {
+		if i == serversPerAsk {
+			break
+		}
+		if l.queries >= r.maxQueries {
+			return nil, ErrBudget
+		}
+		l.queries++
+
+		resp, xerr := r.exchange(m, net.JoinHostPort(servers[n], r.port))
+		switch {
+		case xerr != nil:
+			err = fmt.Errorf("recursion: %s: %w", servers[n], xerr)
+			continue
+		case resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError:
+			continue // SERVFAIL, REFUSED: try the next server
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+// referral returns the zone a response delegates to, below zone and
+// enclosing name, with its name server names and the NS TTL, or "" when it
+// is not a referral. A server may only delegate the name it was asked
+// about, so that one for com. cannot send a lookup of bank.com. to the
+// servers of evil.com.
+func referral(resp *dns.Msg, zone, name string) (string, []string, uint32) {
+	var child string
+	var names []string
+	var ttl uint32
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		owner := dns.CanonicalName(ns.Hdr.Name)
+		if child == "" {
+			// Only follow delegations that get closer to the name
+			if owner == zone || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, name) {
+				return "", nil, 0
+			}
+			child, ttl = owner, ns.Hdr.Ttl
+		}
+		if owner == child {
+			names = append(names, dns.CanonicalName(ns.Ns))
+			ttl = min(ttl, ns.Hdr.Ttl)
+		}
+	}
+	return child, names, ttl
+}
+
+// glue returns the IPv4 addresses the additional section gives for names
+// within zone, the zone of the server that sent it. Addresses for other
+// names are not the server's to give and are looked up instead.
+func glue(resp *dns.Msg, names []string, zone string) []string {
+	var addrs []string
+	for _, rr := range resp.Extra {
+		a, ok := rr.(*dns.A)
+		if !ok || !dns.IsSubDomain(zone, a.Hdr.Name) {
+			continue
+		}
+		for _, n := range names {
+			if strings.EqualFold(a.Hdr.Name, n) {
+				addrs = append(addrs, a.A.String())
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// lookupServers resolves the addresses of name servers that came without
+// glue, stopping at the first that resolves
+func (r *Resolver) lookupServers(l *lookup, names []string, depth int) ([]string, error) {
+	for _, n := range names {
+		resp, err := r.resolve(l, n, dns.TypeA, depth+1)
+		if errors.Is(err, ErrBudget) {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+		var addrs []string
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, a.A.String())
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+	return nil, ErrLame
+}
+
+// closest returns the deepest cached delegation enclosing name, or the root
+func (r *Resolver) closest(name string) (string, []string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for zone := name; ; {
+		if d, ok := r.zones[zone]; ok && now.Before(d.expires) {
+			return zone, d.servers
+		}
+		i, end := dns.NextLabel(zone, 0)
+		if end {
+			return ".", r.roots
+		}
+		zone = zone[i:]
+	}
+}
+
+// remember caches a delegation for its NS TTL
+func (r *Resolver) remember(zone string, servers []string, ttl uint32) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.zones) >= maxZones {
+		for z, d := range r.zones {
+			if !now.Before(d.expires) {
+				delete(r.zones, z)
+			}
+		}
+		if len(r.zones) >= maxZones {
+			return
+		}
+	}
+	r.zones[zone] = delegation{
+		servers: servers,
+		expires: now.Add(max(time.Duration(ttl)*time.Second, minDelegationTTL)),
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !recursion
+
+package recursor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Available reports whether recursion is built in
+const Available = false
+
+// RootHints is empty without recursion
+var RootHints []string
+
+// ErrDisabled means the binary was built without recursion
+var ErrDisabled = errors.New("recursion: not built in (build with the recursion tag)")
+
+// Resolver stands in for the resolver left out of this build
+type Resolver struct{}
+
+// New returns nil; recursion is not built in
+func New(roots []string, timeout time.Duration, maxQueries int) *Resolver {
+	return nil
+}
+
+// Resolve always fails; recursion is not built in
+func (r *Resolver) Resolve(req *dns.Msg) (*dns.Msg, error) {
+	return nil, ErrDisabled
+}
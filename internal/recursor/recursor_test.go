@@ -0,0 +1,199 @@
+//go:build recursion
+
+package recursor
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zoneServer answers for the zones of one fake name server from records
+// in presentation format
+type zoneServer struct {
+	answers   map[string][]string // "name type" -> answer records
+	referrals map[string][]string // zone -> NS and glue records
+}
+
+func (z zoneServer) handle(t *testing.T, q dns.Question) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.Response = true
+	if rrs, ok := z.answers[q.Name+" "+dns.TypeToString[q.Qtype]]; ok {
+		m.Authoritative = true
+		m.Answer = parse(t, rrs)
+		return m
+	}
+	for zone, rrs := range z.referrals {
+		if dns.IsSubDomain(zone, q.Name) {
+			for _, rr := range parse(t, rrs) {
+				if rr.Header().Rrtype == dns.TypeNS {
+					m.Ns = append(m.Ns, rr)
+				} else {
+					m.Extra = append(m.Extra, rr)
+				}
+			}
+			return m
+		}
+	}
+	m.Authoritative = true
+	m.Rcode = dns.RcodeNameError
+	m.Ns = parse(t, []string{"example. 300 IN SOA ns.example. host.example. 1 2 3 4 300"})
+	return m
+}
+
+func parse(t *testing.T, rrs []string) []dns.RR {
+	var out []dns.RR
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+func newTestResolver(t *testing.T, servers map[string]zoneServer, maxQueries int) (*Resolver, *[]string) {
+	r := New([]string{"10.0.0.1"}, time.Second, maxQueries)
+	var asked []string
+	r.exchange = func(m *dns.Msg, addr string) (*dns.Msg, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		asked = append(asked, host+" "+m.Question[0].Name)
+		if m.RecursionDesired {
+			t.Errorf("Expected iterative queries, got RD set")
+		}
+		z, ok := servers[host]
+		if !ok {
+			return nil, errors.New("timeout")
+		}
+		return z.handle(t, m.Question[0]), nil
+	}
+	return r, &asked
+}
+
+var testServers = map[string]zoneServer{
+	"10.0.0.1": {referrals: map[string][]string{ // root
+		"com.": {"com. 3600 IN NS a.gtld.test.", "a.gtld.test. 3600 IN A 10.0.1.1"},
+		"net.": {"net. 3600 IN NS a.gtld.test.", "a.gtld.test. 3600 IN A 10.0.1.1"},
+	}},
+	"10.0.1.1": {referrals: map[string][]string{ // com and net
+		"example.com.": {"example.com. 3600 IN NS ns1.example.net.", "example.com. 3600 IN NS ns2.example.net."},
+		"example.net.": {"example.net. 3600 IN NS ns1.example.net.", "ns1.example.net. 3600 IN A 10.0.2.1"},
+	}},
+	"10.0.2.1": {answers: map[string][]string{ // example.com and example.net
+		"ns1.example.net. A":  {"ns1.example.net. 3600 IN A 10.0.2.1"},
+		"www.example.com. A":  {"www.example.com. 300 IN CNAME edge.example.net."},
+		"edge.example.net. A": {"edge.example.net. 60 IN A 192.0.2.10"},
+	}},
+}
+
+func TestResolve(t *testing.T) {
+	r, asked := newTestResolver(t, testServers, 32)
+
+	req := new(dns.Msg)
+	req.SetQuestion("WWW.example.com.", dns.TypeA)
+	resp, err := r.Resolve(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.RecursionAvailable || resp.Id != req.Id || resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("Unexpected reply header %+v", resp.MsgHdr)
+	}
+	var got []string
+	for _, rr := range resp.Answer {
+		got = append(got, rr.String())
+	}
+	if len(got) != 2 || !strings.Contains(got[0], "CNAME") || !strings.Contains(got[1], "192.0.2.10") {
+		t.Errorf("Expected the CNAME chain to an address, got %v", got)
+	}
+
+	// Delegations are cached: a second name under example.com skips the
+	// root and com servers
+	*asked = nil
+	req.SetQuestion("missing.example.com.", dns.TypeA)
+	resp, err = r.Resolve(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeNameError || len(resp.Ns) == 0 {
+		t.Errorf("Expected NXDOMAIN with the SOA, got %v", resp)
+	}
+	if len(*asked) != 1 || !strings.HasPrefix((*asked)[0], "10.0.2.1 ") {
+		t.Errorf("Expected only the example.com server asked, got %v", *asked)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	r, _ := newTestResolver(t, testServers, 2)
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	if _, err := r.Resolve(req); !errors.Is(err, ErrBudget) {
+		t.Errorf("Expected the exchange budget to run out, got %v", err)
+	}
+
+	r, _ = newTestResolver(t, map[string]zoneServer{}, 32)
+	if _, err := r.Resolve(req); err == nil {
+		t.Error("Expected an error when the roots are unreachable")
+	}
+}
+
+func TestReferralOutsideName(t *testing.T) {
+	servers := map[string]zoneServer{
+		"10.0.0.1": testServers["10.0.0.1"],
+		"10.0.1.1": {referrals: map[string][]string{ // a hostile com server
+			"bank.com.": {"evil.com. 3600 IN NS ns.evil.com.", "ns.evil.com. 3600 IN A 10.6.6.6"},
+		}},
+		"10.6.6.6": {answers: map[string][]string{
+			"www.bank.com. A": {"www.bank.com. 300 IN A 203.0.113.66"},
+		}},
+	}
+	r, asked := newTestResolver(t, servers, 32)
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.bank.com.", dns.TypeA)
+	resp, err := r.Resolve(req)
+	if err == nil && len(resp.Answer) > 0 {
+		t.Errorf("Expected a referral to a zone not enclosing the name to be refused, got %v", resp.Answer)
+	}
+	for _, q := range *asked {
+		if strings.HasPrefix(q, "10.6.6.6 ") {
+			t.Errorf("Expected the evil.com server never to be asked, got %v", *asked)
+		}
+	}
+}
+
+func TestGlueOutsideZone(t *testing.T) {
+	servers := map[string]zoneServer{
+		"10.0.0.1": testServers["10.0.0.1"],
+		"10.0.1.1": {referrals: map[string][]string{
+			// The com server gives an address for a name under net.
+			"example.com.": {"example.com. 3600 IN NS ns1.example.net.", "ns1.example.net. 3600 IN A 10.6.6.6"},
+			"example.net.": {"example.net. 3600 IN NS ns1.example.net.", "ns1.example.net. 3600 IN A 10.0.2.1"},
+		}},
+		"10.0.2.1": testServers["10.0.2.1"],
+		"10.6.6.6": {answers: map[string][]string{
+			"www.example.com. A": {"www.example.com. 300 IN A 203.0.113.66"},
+		}},
+	}
+	r, asked := newTestResolver(t, servers, 32)
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	resp, err := r.Resolve(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) == 0 || strings.Contains(resp.Answer[len(resp.Answer)-1].String(), "203.0.113.66") {
+		t.Errorf("Expected the answer from the name server's real address, got %v", resp.Answer)
+	}
+	for _, q := range *asked {
+		if strings.HasPrefix(q, "10.6.6.6 ") {
+			t.Errorf("Expected glue outside the com zone to be ignored, got %v", *asked)
+		}
+	}
+}
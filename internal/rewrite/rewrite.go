@@ -0,0 +1,159 @@
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+// rule is a compiled rewrite rule
+type rule struct {
+	name    *regexp.Regexp
+	qtype   uint16
+	rdata   *regexp.Regexp
+	replace string
+	hasRepl bool
+	ttl     uint32
+	drop    bool
+}
+
+// Engine applies rewrite rules to upstream answers
+type Engine struct {
+	rules []rule
+}
+
+// NewEngine compiles the configured rewrite rules
+func NewEngine(rules []config.RewriteRule) (*Engine, error) {
+	e := &Engine{}
+
+	for i, r := range rules {
+		compiled := rule{
+			replace: r.Replace,
+			hasRepl: r.Replace != "",
+			ttl:     r.TTL,
+			drop:    r.Drop,
+		}
+
+		if r.Name != "" {
+			re, err := regexp.Compile(r.Name)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: name: %w", i, err)
+			}
+			compiled.name = re
+		}
+
+		if r.RData != "" {
+			re, err := regexp.Compile(r.RData)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: rdata: %w", i, err)
+			}
+			compiled.rdata = re
+		}
+
+		if r.QType != "" {
+			qtype, ok := dns.StringToType[strings.ToUpper(r.QType)]
+			if !ok {
+				return nil, fmt.Errorf("rewrite rule %d: unknown qtype %q", i, r.QType)
+			}
+			compiled.qtype = qtype
+		}
+
+		if !compiled.drop && !compiled.hasRepl && compiled.ttl == 0 {
+			return nil, fmt.Errorf("rewrite rule %d: no action (set replace, ttl or drop)", i)
+		}
+
+		e.rules = append(e.rules, compiled)
+	}
+
+	return e, nil
+}
+
+// Apply rewrites the answer, authority and additional sections of msg in
+// place and returns the number of records changed or dropped
+func (e *Engine) Apply(msg *dns.Msg) int {
+	if e == nil || len(e.rules) == 0 || msg == nil {
+		return 0
+	}
+
+	changed := 0
+	msg.Answer = e.applySection(msg.Answer, &changed)
+	msg.Ns = e.applySection(msg.Ns, &changed)
+	msg.Extra = e.applySection(msg.Extra, &changed)
+	return changed
+}
+
+// applySection runs every record through the rules; the first matching
+// rule wins
+func (e *Engine) applySection(rrs []dns.RR, changed *int) []dns.RR {
+	out := rrs[:0]
+
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT {
+			out = append(out, rr)
+			continue
+		}
+
+		name := strings.TrimSuffix(hdr.Name, ".")
+		rdata := strings.TrimPrefix(rr.String(), hdr.String())
+
+		for _, r := range e.rules {
+			if !r.matches(name, hdr.Rrtype, rdata) {
+				continue
+			}
+
+			if r.drop {
+				rr = nil
+			} else {
+				rr = r.rewrite(rr, rdata)
+			}
+			*changed++
+			break
+		}
+
+		if rr != nil {
+			out = append(out, rr)
+		}
+	}
+
+	return out
+}
+
+// matches reports whether the rule applies to the record
+func (r rule) matches(name string, rrtype uint16, rdata string) bool {
+	if r.qtype != 0 && r.qtype != rrtype {
+		return false
+	}
+	if r.name != nil && !r.name.MatchString(name) {
+		return false
+	}
+	if r.rdata != nil && !r.rdata.MatchString(rdata) {
+		return false
+	}
+	return true
+}
+
+// rewrite applies the rule's rdata replacement and TTL to a record. If the
+// rewritten rdata does not parse, the original record is kept.
+func (r rule) rewrite(rr dns.RR, rdata string) dns.RR {
+	if r.hasRepl {
+		newRData := r.replace
+		if r.rdata != nil {
+			newRData = r.rdata.ReplaceAllString(rdata, r.replace)
+		}
+
+		if parsed, err := dns.NewRR(rr.Header().String() + newRData); err == nil && parsed != nil {
+			rr = parsed
+		}
+	}
+
+	if r.ttl > 0 {
+		rr.Header().Ttl = r.ttl
+	}
+
+	return rr
+}
@@ -0,0 +1,74 @@
+package rewrite
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"ddd/internal/config"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestReplaceRData(t *testing.T) {
+	engine, err := NewEngine([]config.RewriteRule{{
+		Name:    `^app\.example\.com$`,
+		QType:   "A",
+		RData:   `^203\.0\.113\.10$`,
+		Replace: "10.0.0.10",
+		TTL:     60,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{
+		mustRR(t, "app.example.com. 300 IN A 203.0.113.10"),
+		mustRR(t, "app.example.com. 300 IN A 203.0.113.11"),
+	}
+
+	if changed := engine.Apply(msg); changed != 1 {
+		t.Fatalf("Expected 1 record changed, got %d", changed)
+	}
+
+	a := msg.Answer[0].(*dns.A)
+	if a.A.String() != "10.0.0.10" || a.Hdr.Ttl != 60 {
+		t.Errorf("Expected rewritten record 10.0.0.10 ttl 60, got %s", a)
+	}
+	if msg.Answer[1].(*dns.A).A.String() != "203.0.113.11" {
+		t.Errorf("Expected non-matching record to be untouched, got %s", msg.Answer[1])
+	}
+}
+
+func TestDropRecord(t *testing.T) {
+	engine, err := NewEngine([]config.RewriteRule{{QType: "AAAA", Drop: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{
+		mustRR(t, "example.com. 300 IN A 192.0.2.1"),
+		mustRR(t, "example.com. 300 IN AAAA 2001:db8::1"),
+	}
+
+	engine.Apply(msg)
+
+	if len(msg.Answer) != 1 || msg.Answer[0].Header().Rrtype != dns.TypeA {
+		t.Errorf("Expected only the A record to remain, got %v", msg.Answer)
+	}
+}
+
+func TestRuleWithoutAction(t *testing.T) {
+	if _, err := NewEngine([]config.RewriteRule{{Name: "example"}}); err == nil {
+		t.Error("Expected a rule without an action to be rejected")
+	}
+}
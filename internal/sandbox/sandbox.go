@@ -0,0 +1,189 @@
+// Package sandbox confines the server once it is serving. The server faces
+// hostile traffic by design, so after startup it gives up what it no
+// longer needs: Landlock limits the files it can open and a seccomp filter
+// limits the system calls it can make. Both are Linux only, cannot be
+// undone, and are inherited by processes it starts.
+package sandbox
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ddd/internal/config"
+)
+
+// envApplied lists the restrictions Apply installed. A binary exec'd for
+// an in-place upgrade inherits both the restrictions and the variable.
+const envApplied = "DDD_SANDBOX"
+
+// ErrUnsupported is returned when the platform, kernel or build cannot
+// apply a restriction
+var ErrUnsupported = errors.New("not supported")
+
+// systemFiles are read by the resolver and the TLS client at any time
+var systemFiles = []string{
+	"/etc/resolv.conf",
+	"/etc/hosts",
+	"/etc/nsswitch.conf",
+	"/etc/services",
+	"/proc/net", // socket drop statistics
+}
+
+// Policy is what the confined process may still do
+type Policy struct {
+	Read      []string // files and directories that may be read
+	Write     []string // directories whose files may be created, written and replaced
+	Exec      []string // executables that may be run
+	AllowExec bool     // keep execve for in-place upgrades
+	Audit     bool     // log system calls the filter would deny instead of failing them
+}
+
+// PolicyFor derives the policy for cfg, loaded from configFile. With
+// AllowExec it also covers what a new binary reads at startup, since it
+// inherits the restrictions.
+func PolicyFor(cfg *config.Config, configFile string) Policy {
+	p := Policy{
+		Read:      append(append([]string{}, systemFiles...), cfg.Sandbox.ReadPaths...),
+		Write:     append([]string{}, cfg.Sandbox.WritePaths...),
+		AllowExec: cfg.Sandbox.AllowExec,
+		Audit:     cfg.Sandbox.Audit,
+	}
+	if cfg.API.TLSCert != "" {
+		p.Read = append(p.Read, cfg.API.TLSCert)
+	}
+	for _, l := range cfg.Server.Listeners {
+		if l.TLSCert != "" {
+			p.Read = append(p.Read, l.TLSCert)
+		}
+	}
+	for _, file := range []string{cfg.Cache.SnapshotFile, cfg.Integrity.CheckpointFile} {
+		if file != "" {
+			p.Write = append(p.Write, filepath.Dir(file))
+		}
+	}
+	if cfg.Capture.Dir != "" {
+		p.Write = append(p.Write, cfg.Capture.Dir)
+	}
+	if cfg.Archive.Dir != "" {
+		p.Write = append(p.Write, cfg.Archive.Dir)
+	}
+	if cfg.Dataset.File != "" {
+		p.Write = append(p.Write, filepath.Dir(cfg.Dataset.File))
+	}
+	if cfg.Journal.File != "" {
+		p.Write = append(p.Write, filepath.Dir(cfg.Journal.File))
+	}
+	if cfg.Cache.Warm.Save && cfg.Cache.Warm.File != "" {
+		// The popular names are saved there at shutdown
+		p.Write = append(p.Write, filepath.Dir(cfg.Cache.Warm.File))
+	}
+	for _, backend := range cfg.Storage.Backends() {
+		if backend == config.BackendFile {
+			p.Write = append(p.Write, cfg.Storage.File.Dir)
+			break
+		}
+	}
+
+	if cfg.DataFiles.Watch {
+		// Reloaded block lists are usually replaced rather than rewritten,
+		// so their directories are readable and not just the files
+		if cfg.Blocking.Bootstrap != "" {
+			p.Read = append(p.Read, filepath.Dir(cfg.Blocking.Bootstrap))
+		}
+		for _, g := range cfg.Groups {
+			if g.CIDRFile != "" {
+				p.Read = append(p.Read, filepath.Dir(g.CIDRFile))
+			}
+		}
+	}
+
+	if cfg.Sandbox.AllowExec {
+		if exe, err := os.Executable(); err == nil {
+			p.Exec = append(p.Exec, exe)
+		}
+		for _, file := range []string{cfg.GeoIP.Database, cfg.Blocking.Bootstrap, cfg.Script.File} {
+			if file != "" {
+				p.Read = append(p.Read, file)
+			}
+		}
+		if cfg.DataFiles.PublicKey != "" {
+			// The new binary checks the data files' signatures again
+			for _, file := range []string{cfg.GeoIP.Database, cfg.Blocking.Bootstrap} {
+				if file != "" {
+					p.Read = append(p.Read, file+".sig")
+				}
+			}
+		}
+		if configFile != "" {
+			p.Read = append(p.Read, filepath.Dir(configFile)) // and its includes
+		}
+		p.Write = append(p.Write, filepath.Dir(cfg.Log.File), os.TempDir())
+	}
+	return p
+}
+
+// Apply confines the process to p. Directories in p.Write are created if
+// missing. Landlock is applied before seccomp, which would deny it.
+//
+// Restrictions inherited from the process that exec'd this one for an
+// upgrade are not applied again: its seccomp filter denies the calls
+// needed to, and Landlock only nests a limited number of times.
+func Apply(p Policy, landlock, seccomp bool) error {
+	inherited := inherited()
+	if inherited["seccomp"] && landlock && !inherited["landlock"] {
+		return errors.New("landlock: cannot be added under the seccomp filter inherited from the previous process")
+	}
+
+	for _, dir := range p.Write {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+	}
+	// The system roots are loaded on first use; load them while they can
+	// still be read
+	x509.SystemCertPool()
+
+	if landlock && !inherited["landlock"] {
+		if err := restrictFiles(p); err != nil {
+			return err
+		}
+		inherited["landlock"] = true
+	}
+	if seccomp && !inherited["seccomp"] {
+		if err := restrictSyscalls(p); err != nil {
+			return err
+		}
+		inherited["seccomp"] = true
+	}
+
+	var applied []string
+	for _, name := range []string{"landlock", "seccomp"} {
+		if inherited[name] {
+			applied = append(applied, name)
+		}
+	}
+	if err := os.Setenv(envApplied, strings.Join(applied, ",")); err != nil {
+		return fmt.Errorf("recording restrictions: %w", err)
+	}
+	return nil
+}
+
+// inherited returns the restrictions applied by the process that exec'd
+// this one. A recorded seccomp filter only counts if the kernel reports
+// one, so that a stray variable cannot switch the filter off.
+func inherited() map[string]bool {
+	applied := make(map[string]bool)
+	for _, name := range strings.Split(os.Getenv(envApplied), ",") {
+		if name != "" {
+			applied[name] = true
+		}
+	}
+	if applied["seccomp"] && !filtered() {
+		delete(applied, "seccomp")
+	}
+	return applied
+}
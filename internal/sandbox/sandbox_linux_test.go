@@ -0,0 +1,118 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// childEnv runs a test's confined half in a child process, since the
+// restrictions cannot be lifted
+const childEnv = "SANDBOX_TEST_CHILD"
+
+// runChild reruns test in a child process and fails t if the child fails
+func runChild(t *testing.T, test string, env ...string) {
+	cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$", "-test.v")
+	cmd.Env = append(append(os.Environ(), childEnv+"="+test), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
+
+func TestSeccomp(t *testing.T) {
+	if os.Getenv(childEnv) != t.Name() {
+		runChild(t, t.Name())
+		return
+	}
+
+	if err := Apply(Policy{}, false, true); errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := unix.Getpgid(0); err != unix.EPERM {
+		t.Errorf("Expected getpgid to be denied, got %v", err)
+	}
+	if err := exec.Command(os.Args[0], "-test.run=^$").Run(); err == nil {
+		t.Error("Expected exec to be denied")
+	}
+	if err := os.WriteFile(filepath.Join(t.TempDir(), "file"), []byte("ok"), 0o600); err != nil {
+		t.Errorf("Expected file writes to be allowed, got %v", err)
+	}
+}
+
+func TestLandlock(t *testing.T) {
+	if os.Getenv(childEnv) != t.Name() {
+		allowed, denied := t.TempDir(), t.TempDir()
+		runChild(t, t.Name(), "ALLOWED="+allowed, "DENIED="+denied)
+		return
+	}
+
+	allowed, denied := os.Getenv("ALLOWED"), os.Getenv("DENIED")
+	if err := Apply(Policy{Write: []string{filepath.Join(allowed, "captures")}}, true, false); errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(allowed, "captures", "file"), []byte("ok"), 0o600); err != nil {
+		t.Errorf("Expected writes to a created write directory to be allowed, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(denied, "file"), []byte("no"), 0o600); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expected writes elsewhere to be denied, got %v", err)
+	}
+	if _, err := os.ReadFile("/etc/passwd"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expected reads elsewhere to be denied, got %v", err)
+	}
+}
+
+func TestApplyInherited(t *testing.T) {
+	switch os.Getenv(childEnv) {
+	case t.Name():
+		// Confine the child, then exec a copy as an upgrade would
+		if err := Apply(Policy{AllowExec: true, Exec: []string{os.Args[0]}}, false, true); errors.Is(err, ErrUnsupported) {
+			t.Skip(err)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+		cmd.Env = append(os.Environ(), childEnv+"=exec")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Expected the exec'd process to start confined, got %v\n%s", err, out)
+		}
+	case "exec":
+		if err := Apply(Policy{AllowExec: true}, false, true); err != nil {
+			t.Fatalf("Expected the inherited filter to be kept, got %v", err)
+		}
+		if _, err := unix.Getpgid(0); err != unix.EPERM {
+			t.Errorf("Expected getpgid to stay denied, got %v", err)
+		}
+		if err := Apply(Policy{}, true, true); err == nil {
+			t.Error("Expected Landlock to be refused under an inherited filter")
+		}
+	default:
+		runChild(t, t.Name())
+	}
+}
+
+func TestApplyRecordedFilterMissing(t *testing.T) {
+	if os.Getenv(childEnv) != t.Name() {
+		runChild(t, t.Name(), envApplied+"=seccomp")
+		return
+	}
+
+	// Without a filter in place the recorded one is not trusted
+	if err := Apply(Policy{}, false, true); errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.Getpgid(0); err != unix.EPERM {
+		t.Errorf("Expected getpgid to be denied, got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sandbox
+
+func restrictFiles(p Policy) error {
+	return ErrUnsupported
+}
+
+func restrictSyscalls(p Policy) error {
+	return ErrUnsupported
+}
+
+func filtered() bool {
+	return false
+}
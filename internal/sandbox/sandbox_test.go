@@ -0,0 +1,29 @@
+package sandbox
+
+import (
+	"testing"
+
+	"ddd/internal/config"
+)
+
+func TestPolicyForWarmFile(t *testing.T) {
+	cfg := config.Default()
+	cfg.Cache.Warm.File = "/var/lib/ddd/warm/names.json"
+	if contains(PolicyFor(cfg, "").Write, "/var/lib/ddd/warm") {
+		t.Error("Expected a warm file that is only read to stay read-only")
+	}
+
+	cfg.Cache.Warm.Save = true
+	if !contains(PolicyFor(cfg, "").Write, "/var/lib/ddd/warm") {
+		t.Error("Expected the directory of a saved warm file to be writable")
+	}
+}
+
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
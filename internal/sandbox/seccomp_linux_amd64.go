@@ -0,0 +1,11 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// archSyscalls are the legacy calls the runtime still makes on amd64
+var archSyscalls = []uintptr{
+	unix.SYS_ARCH_PRCTL, unix.SYS_NEWFSTATAT, unix.SYS_EPOLL_WAIT, unix.SYS_OPEN, unix.SYS_STAT, unix.SYS_LSTAT,
+	unix.SYS_READLINK, unix.SYS_RENAME, unix.SYS_MKDIR, unix.SYS_UNLINK, unix.SYS_RMDIR, unix.SYS_DUP2,
+}
@@ -0,0 +1,16 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func restrictSyscalls(p Policy) error {
+	return fmt.Errorf("seccomp: %w on %s", ErrUnsupported, runtime.GOARCH)
+}
+
+func filtered() bool {
+	return false
+}
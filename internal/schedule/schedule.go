@@ -0,0 +1,71 @@
+package schedule
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"ddd/internal/metrics"
+)
+
+var (
+	cleanupRuns = metrics.NewCounterVec("ddd_cleanup_runs_total",
+		"Cleanup passes run", "component")
+	cleanupScanned = metrics.NewCounterVec("ddd_cleanup_entries_scanned_total",
+		"Entries examined by cleanup passes", "component")
+	cleanupRemoved = metrics.NewCounterVec("ddd_cleanup_entries_removed_total",
+		"Entries removed by cleanup passes", "component")
+	cleanupLockHold = metrics.NewHistogramVec("ddd_cleanup_lock_hold_seconds",
+		"Time the component lock was held by a cleanup pass", metrics.DefBuckets, "component")
+)
+
+// CleanupFunc runs one cleanup pass and reports how many entries it
+// examined and removed and how long it held its lock
+type CleanupFunc func() (scanned, removed int, lockHeld time.Duration)
+
+// Jittered returns interval randomly adjusted by up to ±jitter (a fraction
+// of the interval), so that cleanups across components and instances do not
+// all fire at the same moment
+func Jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	delta := (rand.Float64()*2 - 1) * jitter * float64(interval)
+	d := interval + time.Duration(delta)
+	if d <= 0 {
+		return interval
+	}
+	return d
+}
+
+// RunCleanup calls fn on a jittered schedule until ctx is cancelled,
+// recording cleanup metrics under the component label. A non-positive
+// interval disables the cleanup.
+func RunCleanup(ctx context.Context, component string, interval time.Duration, jitter float64, fn CleanupFunc) {
+	if interval <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(Jittered(interval, jitter))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			scanned, removed, held := fn()
+
+			cleanupRuns.With(component).Inc()
+			cleanupScanned.With(component).Add(uint64(scanned))
+			cleanupRemoved.With(component).Add(uint64(removed))
+			cleanupLockHold.With(component).Observe(held.Seconds())
+
+			timer.Reset(Jittered(interval, jitter))
+		}
+	}
+}
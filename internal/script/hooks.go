@@ -0,0 +1,56 @@
+package script
+
+import (
+	"errors"
+	"time"
+)
+
+// Limits bound each hook invocation. Zero values are unlimited.
+type Limits struct {
+	MaxSteps int           // Starlark execution steps
+	Timeout  time.Duration // wall-clock time
+}
+
+// Request is what hooks see of a query
+type Request struct {
+	Client      string // client IP address
+	QName       string // lowercase, without the trailing dot
+	QType       string // e.g. "A", "TXT"
+	Transport   string // "udp", "tcp" or "tls"
+	Fingerprint string // TLS client fingerprint, or empty
+	RD          bool   // recursion desired
+	CD          bool   // checking disabled
+	DO          bool   // DNSSEC OK
+	ECS         string // EDNS client subnet in CIDR notation, or empty
+	EDNSOptions []int  // EDNS option codes in the order sent
+}
+
+// Verdict is what hooks see of a detected attack
+type Verdict struct {
+	AttackType  string
+	Severity    string
+	Description string
+	Domain      string // the attacked domain, if any
+	Block       bool   // the detector would block the client
+	// Evidence lists the names the detection was made on, if it judged
+	// names
+	Evidence []string
+}
+
+// Hook names a script may define
+const (
+	OnRequest = "on_request"
+	OnVerdict = "on_verdict"
+)
+
+// ErrResult is returned when a hook returns something other than None or
+// one of the action names it may return
+var ErrResult = errors.New("invalid hook result")
+
+var (
+	// ErrStepLimit is returned when an invocation runs out of steps
+	ErrStepLimit = errors.New("step limit exceeded")
+
+	// ErrTimeout is returned when an invocation runs out of time
+	ErrTimeout = errors.New("time limit exceeded")
+)
@@ -0,0 +1,268 @@
+//go:build script
+
+// Package script runs operator policy scripts written in Starlark, a small
+// Python-like language, so custom request handling and verdict logic need
+// no fork. A script defines hook functions:
+//
+//	TENANTS = {"10.1.0.0/16": "acme", "10.2.0.0/16": "globex"}
+//
+//	def tenant(ip):
+//	    for network, name in TENANTS.items():
+//	        if cidr(ip, network):
+//	            return name
+//	    return ""
+//
+//	def on_request(req):
+//	    if req.qtype == "ANY" and tenant(req.client) == "":
+//	        return "refuse"
+//
+//	def on_verdict(req, verdict):
+//	    if verdict.attack_type == "high_request_rate" and tenant(req.client) != "":
+//	        return "rate_limit"
+//
+// Scripts run on go.starlark.net with its default dialect: no while
+// loops, recursion or imports, and the script's globals are frozen once
+// it has loaded, so hooks can run concurrently and cannot keep state
+// between queries. Every invocation runs under a step and time budget.
+// The interpreter is built in only with the script tag.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"ddd/internal/buildinfo"
+	"ddd/internal/geoip"
+)
+
+// Available reports whether the script interpreter is built in
+const Available = true
+
+func init() {
+	buildinfo.Register("script")
+}
+
+// loadSteps bounds the top level of a script, which runs once at load
+const loadSteps = 10_000_000
+
+// predeclared are the builtins scripts get besides Starlark's own
+var predeclared = starlark.StringDict{
+	"cidr": starlark.NewBuiltin("cidr", builtinCIDR),
+}
+
+// Program is a loaded script
+type Program struct {
+	name    string
+	globals starlark.StringDict
+	limits  Limits
+	print   func(string)
+	geo     *geoip.DB
+}
+
+// Load runs a script's top level and freezes its globals. name is used in
+// error messages; print receives the output of print calls and may be nil.
+func Load(name string, src []byte, limits Limits, print func(string)) (*Program, error) {
+	p := &Program{name: name, limits: limits, print: print}
+	err := p.exec(loadSteps, 0, func(thread *starlark.Thread) error {
+		globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, name, src, predeclared)
+		p.globals = globals
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadFile loads the script at path
+func LoadFile(path string, limits Limits, print func(string)) (*Program, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(path, src, limits, print)
+}
+
+// WithGeo resolves the country and asn fields of requests from db
+func (p *Program) WithGeo(db *geoip.DB) *Program {
+	p.geo = db
+	return p
+}
+
+// Has reports whether the script defines the function name
+func (p *Program) Has(name string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.globals[name].(*starlark.Function)
+	return ok
+}
+
+// Call calls the script's function name under the program's limits
+func (p *Program) Call(name string, args ...starlark.Value) (starlark.Value, error) {
+	fn, ok := p.globals[name].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("%s: no function %s", p.name, name)
+	}
+	var v starlark.Value
+	err := p.exec(p.limits.MaxSteps, p.limits.Timeout, func(thread *starlark.Thread) error {
+		var err error
+		v, err = starlark.Call(thread, fn, args, nil)
+		return err
+	})
+	return v, err
+}
+
+// exec runs fn on a new thread limited to steps and timeout, each
+// unlimited when zero. A thread cancelled by either fails with
+// ErrStepLimit or ErrTimeout.
+func (p *Program) exec(steps int, timeout time.Duration, fn func(*starlark.Thread) error) error {
+	thread := &starlark.Thread{
+		Name: p.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			if p.print != nil {
+				p.print(msg)
+			}
+		},
+	}
+	outOfSteps := false
+	thread.OnMaxSteps = func(thread *starlark.Thread) {
+		outOfSteps = true
+		thread.Cancel("too many steps")
+	}
+	thread.SetMaxExecutionSteps(uint64(steps))
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			thread.Cancel("timed out")
+		})
+		defer timer.Stop()
+	}
+
+	err := fn(thread)
+	switch {
+	case err == nil:
+		return nil
+	case outOfSteps:
+		return fmt.Errorf("%s: %w", p.name, ErrStepLimit)
+	case timedOut.Load():
+		return fmt.Errorf("%s: %w", p.name, ErrTimeout)
+	}
+	// Runtime errors are prefixed with where they were raised; syntax
+	// errors carry their position already
+	var ee *starlark.EvalError
+	if errors.As(err, &ee) {
+		for i := range ee.CallStack {
+			if pos := ee.CallStack.At(i).Pos; pos.IsValid() {
+				return fmt.Errorf("%s: %w", pos, err)
+			}
+		}
+		return fmt.Errorf("%s: %w", p.name, err)
+	}
+	return err
+}
+
+// builtinCIDR reports whether an address is in a network, as the
+// firewall's cidr function does
+func builtinCIDR(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var ip, network string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &ip, &network); err != nil {
+		return nil, err
+	}
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	addr, err := netip.ParseAddr(ip)
+	return starlark.Bool(err == nil && prefix.Contains(addr.Unmap())), nil
+}
+
+// request returns req as the struct hooks receive
+func (p *Program) request(r Request) starlark.Value {
+	var loc geoip.Location
+	if p.geo != nil {
+		loc, _ = p.geo.Lookup(r.Client)
+	}
+	options := make(starlark.Tuple, len(r.EDNSOptions))
+	for i, o := range r.EDNSOptions {
+		options[i] = starlark.MakeInt(o)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
+		"client":       starlark.String(r.Client),
+		"qname":        starlark.String(r.QName),
+		"qtype":        starlark.String(r.QType),
+		"transport":    starlark.String(r.Transport),
+		"fingerprint":  starlark.String(r.Fingerprint),
+		"rd":           starlark.Bool(r.RD),
+		"cd":           starlark.Bool(r.CD),
+		"do":           starlark.Bool(r.DO),
+		"ecs":          starlark.String(r.ECS),
+		"edns_options": options,
+		"country":      starlark.String(loc.Country),
+		"asn":          starlark.MakeUint(uint(loc.ASN)),
+	})
+}
+
+// value returns the verdict as the struct hooks receive
+func (v Verdict) value() starlark.Value {
+	evidence := make(starlark.Tuple, len(v.Evidence))
+	for i, name := range v.Evidence {
+		evidence[i] = starlark.String(name)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("verdict"), starlark.StringDict{
+		"attack_type": starlark.String(v.AttackType),
+		"severity":    starlark.String(v.Severity),
+		"description": starlark.String(v.Description),
+		"domain":      starlark.String(v.Domain),
+		"block":       starlark.Bool(v.Block),
+		"evidence":    evidence,
+	})
+}
+
+// Request calls the on_request hook. It returns the action the hook asked
+// for, or "" when it returned None or is not defined. It is safe to call
+// on a nil program.
+func (p *Program) Request(req Request, actions map[string]bool) (string, error) {
+	if !p.Has(OnRequest) {
+		return "", nil
+	}
+	v, err := p.Call(OnRequest, p.request(req))
+	if err != nil {
+		return "", err
+	}
+	return p.action(OnRequest, v, actions)
+}
+
+// Verdict calls the on_verdict hook for a detected attack. It returns the
+// decision the hook asked for, or "" to keep the detector's. It is safe to
+// call on a nil program.
+func (p *Program) Verdict(req Request, verdict Verdict, actions map[string]bool) (string, error) {
+	if !p.Has(OnVerdict) {
+		return "", nil
+	}
+	v, err := p.Call(OnVerdict, p.request(req), verdict.value())
+	if err != nil {
+		return "", err
+	}
+	return p.action(OnVerdict, v, actions)
+}
+
+// action checks a hook's result against the actions it may return
+func (p *Program) action(hook string, v starlark.Value, actions map[string]bool) (string, error) {
+	if v == starlark.None {
+		return "", nil
+	}
+	if s, ok := v.(starlark.String); ok && actions[string(s)] {
+		return string(s), nil
+	}
+	return "", fmt.Errorf("%s: %s returned %s: %w", p.name, hook, v, ErrResult)
+}
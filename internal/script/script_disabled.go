@@ -0,0 +1,48 @@
+//go:build !script
+
+package script
+
+import (
+	"errors"
+
+	"ddd/internal/geoip"
+)
+
+// Available reports whether the script interpreter is built in
+const Available = false
+
+// ErrDisabled means the binary was built without the script interpreter
+var ErrDisabled = errors.New("script: not built in (build with the script tag)")
+
+// Program stands in for the interpreter left out of this build
+type Program struct{}
+
+// Load always fails; the script interpreter is not built in
+func Load(name string, src []byte, limits Limits, print func(string)) (*Program, error) {
+	return nil, ErrDisabled
+}
+
+// LoadFile always fails; the script interpreter is not built in
+func LoadFile(path string, limits Limits, print func(string)) (*Program, error) {
+	return nil, ErrDisabled
+}
+
+// WithGeo does nothing
+func (p *Program) WithGeo(db *geoip.DB) *Program {
+	return p
+}
+
+// Has reports false; no script is loaded
+func (p *Program) Has(name string) bool {
+	return false
+}
+
+// Request returns no action
+func (p *Program) Request(req Request, actions map[string]bool) (string, error) {
+	return "", nil
+}
+
+// Verdict keeps the detector's decision
+func (p *Program) Verdict(req Request, verdict Verdict, actions map[string]bool) (string, error) {
+	return "", nil
+}
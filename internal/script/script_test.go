@@ -0,0 +1,217 @@
+//go:build script
+
+package script
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+
+	"ddd/internal/geoip"
+)
+
+func TestEval(t *testing.T) {
+	p, err := Load("test.star", []byte(`
+NETS = ["10.0.0.0/8", "192.168.0.0/16"]
+WEIGHTS = {"a": 1, "b": 2}
+
+def internal(ip):
+    return any([cidr(ip, n) for n in NETS])
+
+def fib(n):
+    a, b = 0, 1
+    for _ in range(n):
+        a, b = b, a + b
+    return a
+
+def words(s, sep=","):
+    return sorted([w.strip().lower() for w in s.split(sep) if w.strip()], reverse=True)
+
+def total():
+    n = 0
+    for k, v in WEIGHTS.items():
+        n += v * len(k)
+    return n
+
+def classify(x):
+    if x < 0:
+        return "negative"
+    elif x == 0:
+        return "zero"
+    else:
+        return "big" if x > 100 else "small"
+
+def misc():
+    l = [3, 1, 2]
+    l.append(-7 // 2)
+    l += (5,)
+    return (l[-1], l[1:3], -7 % 3, 7 / 2, "%s" if "ab" in "cab" else None, "x" * 3, str(1.0))
+`), Limits{MaxSteps: 10000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fn   string
+		args []starlark.Value
+		want string
+	}{
+		{"internal", []starlark.Value{starlark.String("10.1.2.3")}, "True"},
+		{"internal", []starlark.Value{starlark.String("203.0.113.1")}, "False"},
+		{"fib", []starlark.Value{starlark.MakeInt(20)}, "6765"},
+		{"words", []starlark.Value{starlark.String(" B, a,,C ")}, `["c", "b", "a"]`},
+		{"total", nil, "3"},
+		{"classify", []starlark.Value{starlark.MakeInt(-1)}, `"negative"`},
+		{"classify", []starlark.Value{starlark.MakeInt(0)}, `"zero"`},
+		{"classify", []starlark.Value{starlark.MakeInt(7)}, `"small"`},
+		{"classify", []starlark.Value{starlark.Float(500)}, `"big"`},
+		{"misc", nil, `(5, [1, 2], 2, 3.5, "%s", "xxx", "1.0")`},
+	}
+	for _, tt := range tests {
+		v, err := p.Call(tt.fn, tt.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tt.fn, tt.args, err)
+			continue
+		}
+		if got := v.String(); got != tt.want {
+			t.Errorf("%s%v = %s, want %s", tt.fn, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src, want string
+	}{
+		{"x = (1", "want ')'"},
+		{"def f():\nreturn 1", "want indent"},
+		{"return 1", "return statement not within a function"},
+		{"break", "break not in a loop"},
+		{"x = 1 < 2 < 3", "does not associate"},
+		{"x = y", "undefined: y"},
+		{"x = 1 + \"a\"", "unknown binary op"},
+		{"x = [1][5]", "test.star:1:8: list index 5 out of range"},
+		{"while True:\n    pass", "does not support while loops"},
+		{"x = {}[[1]]", "unhashable"},
+		{"def f(n):\n    return f(n)\nf(1)", "called recursively"},
+	} {
+		_, err := Load("test.star", []byte(tt.src), Limits{}, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	src := []byte(`
+def spin(n):
+    x = 0
+    for i in range(n):
+        x += i
+    return x
+`)
+	p, err := Load("test.star", src, Limits{MaxSteps: 1000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Call("spin", starlark.MakeInt(10)); err != nil {
+		t.Errorf("Expected a short loop to finish, got %v", err)
+	}
+	if _, err := p.Call("spin", starlark.MakeInt(1_000_000)); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("Expected the step limit, got %v", err)
+	}
+
+	p, err = Load("test.star", src, Limits{Timeout: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Call("spin", starlark.MakeInt64(1<<40)); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected the timeout, got %v", err)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	p, err := Load("test.star", []byte(`
+SEEN = {}
+LIST = [[1]]
+
+def remember(k):
+    SEEN[k] = True
+
+def grow(x):
+    LIST[0].append(x)
+
+def local():
+    l = list(LIST)
+    l.append(3)
+    return len(l)
+`), Limits{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{"remember", "grow"} {
+		if _, err := p.Call(fn, starlark.String("a")); err == nil || !strings.Contains(err.Error(), "frozen") {
+			t.Errorf("Expected %s to fail on a frozen global, got %v", fn, err)
+		}
+	}
+	if v, err := p.Call("local"); err != nil || v.String() != "2" {
+		t.Errorf("Expected a copy of a global to be mutable, got %v, %v", v, err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var printed []string
+	p, err := Load("test.star", []byte(`
+def on_request(req):
+    if req.qtype == "ANY":
+        return "refuse"
+    if 8 in req.edns_options:
+        print("ecs from", req.client, req.ecs)
+    if req.qname.endswith(".bad"):
+        return "explode"
+
+def on_verdict(req, verdict):
+    if verdict.attack_type == "high_request_rate" and req.country == "XX":
+        return "rate_limit"
+`), Limits{MaxSteps: 1000}, func(s string) { printed = append(printed, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := geoip.Read(strings.NewReader("192.0.2.0/24,XX,64500,EXAMPLE\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.WithGeo(db)
+
+	actions := map[string]bool{"refuse": true, "drop": true}
+	if action, err := p.Request(Request{QName: "example.com", QType: "ANY"}, actions); err != nil || action != "refuse" {
+		t.Errorf("Request(ANY) = %q, %v, want refuse", action, err)
+	}
+	req := Request{Client: "192.0.2.1", QName: "example.com", QType: "A", ECS: "198.51.100.0/24", EDNSOptions: []int{10, 8}}
+	if action, err := p.Request(req, actions); err != nil || action != "" {
+		t.Errorf("Request(A) = %q, %v, want no action", action, err)
+	}
+	if len(printed) != 1 || printed[0] != "ecs from 192.0.2.1 198.51.100.0/24" {
+		t.Errorf("Unexpected print output %q", printed)
+	}
+	if _, err := p.Request(Request{QName: "x.bad", QType: "A"}, actions); !errors.Is(err, ErrResult) {
+		t.Errorf("Expected an invalid result error, got %v", err)
+	}
+
+	decisions := map[string]bool{"allow": true, "rate_limit": true, "block": true}
+	verdict := Verdict{AttackType: "high_request_rate", Block: true}
+	if d, err := p.Verdict(Request{Client: "192.0.2.7"}, verdict, decisions); err != nil || d != "rate_limit" {
+		t.Errorf("Verdict(XX) = %q, %v, want rate_limit", d, err)
+	}
+	if d, err := p.Verdict(Request{Client: "203.0.113.1"}, verdict, decisions); err != nil || d != "" {
+		t.Errorf("Verdict(YY) = %q, %v, want no decision", d, err)
+	}
+
+	var none *Program
+	if action, err := none.Request(req, actions); err != nil || action != "" {
+		t.Errorf("Expected a nil program to do nothing, got %q, %v", action, err)
+	}
+}
@@ -0,0 +1,64 @@
+// Package severity defines the ordered attack severity levels shared by
+// detection, mitigation, logging and metrics
+package severity
+
+import "fmt"
+
+// Level is an attack severity. Levels are ordered, so escalation logic can
+// compare them directly (High > Medium).
+type Level int
+
+const (
+	None Level = iota // no severity recorded, e.g. a manual block
+	Low
+	Medium
+	High
+)
+
+var names = [...]string{
+	None:   "none",
+	Low:    "low",
+	Medium: "medium",
+	High:   "high",
+}
+
+// String returns the level name used in logs, metric labels and JSON
+func (l Level) String() string {
+	if l < None || int(l) >= len(names) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return names[l]
+}
+
+// Parse returns the level with the given name
+func Parse(s string) (Level, error) {
+	for l, name := range names {
+		if s == name {
+			return Level(l), nil
+		}
+	}
+	return None, fmt.Errorf("unknown severity %q (want low, medium or high)", s)
+}
+
+// Max returns the higher of two levels
+func Max(a, b Level) Level {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// MarshalText encodes the level as its name
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a level name
+func (l *Level) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
@@ -0,0 +1,32 @@
+package severity
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOrdering(t *testing.T) {
+	if !(None < Low && Low < Medium && Medium < High) {
+		t.Fatal("Expected levels ordered none < low < medium < high")
+	}
+	if Max(Low, High) != High || Max(Medium, None) != Medium {
+		t.Error("Max did not return the higher level")
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	for _, l := range []Level{None, Low, Medium, High} {
+		data, err := json.Marshal(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Level
+		if err := json.Unmarshal(data, &got); err != nil || got != l {
+			t.Errorf("Round trip of %v gave %v (%s, err %v)", l, got, data, err)
+		}
+	}
+
+	if _, err := Parse("critical"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
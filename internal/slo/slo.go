@@ -0,0 +1,123 @@
+// Package slo tracks a latency objective for answered queries and alerts
+// when its error budget is being consumed abnormally fast, using the
+// multiwindow burn-rate method: an alert fires only while the burn rate
+// exceeds the window's limit over both its long and short spans.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+)
+
+var burnRate = metrics.NewGaugeVec("ddd_slo_burn_rate",
+	"Latency error budget burn rate over each alert window", "window")
+
+// sample is a snapshot of the source histograms
+type sample struct {
+	at          time.Time
+	good, total uint64
+}
+
+// Tracker computes burn rates from latency histograms
+type Tracker struct {
+	cfg     config.SLOConfig
+	sources []*metrics.Histogram
+	bus     *events.Bus
+
+	samples []sample
+	firing  []bool // per window; an alert is published once per episode
+	longest time.Duration
+}
+
+// NewTracker creates a tracker over the given histograms, whose
+// observations are in seconds
+func NewTracker(cfg config.SLOConfig, bus *events.Bus, sources ...*metrics.Histogram) *Tracker {
+	t := &Tracker{
+		cfg:     cfg,
+		sources: sources,
+		bus:     bus,
+		firing:  make([]bool, len(cfg.Windows)),
+	}
+	for _, w := range cfg.Windows {
+		if w.Long > t.longest {
+			t.longest = w.Long
+		}
+	}
+	return t
+}
+
+// Run samples the histograms every interval until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	t.sample(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.sample(now)
+		}
+	}
+}
+
+// sample records the current counts and evaluates every window
+func (t *Tracker) sample(now time.Time) {
+	s := sample{at: now}
+	for _, h := range t.sources {
+		s.good += h.Bucket(t.cfg.Latency.Seconds())
+		s.total += h.Count()
+	}
+	t.samples = append(t.samples, s)
+
+	// Keep one sample at or beyond the longest window
+	drop := 0
+	for drop+1 < len(t.samples) && now.Sub(t.samples[drop+1].at) >= t.longest {
+		drop++
+	}
+	t.samples = t.samples[drop:]
+
+	for i, w := range t.cfg.Windows {
+		long, short := t.burn(now, w.Long), t.burn(now, w.Short)
+		burnRate.With(w.Long.String()).Set(long)
+		burnRate.With(w.Short.String()).Set(short)
+
+		firing := long >= w.BurnRate && short >= w.BurnRate
+		if firing && !t.firing[i] {
+			t.bus.Publish(events.Event{
+				Type: events.SLOBurnRate,
+				Reason: fmt.Sprintf("%.4g%% of queries within %v: budget burning %.1fx over %v and %.1fx over %v (limit %.1fx)",
+					t.cfg.Target*100, t.cfg.Latency, long, w.Long, short, w.Short, w.BurnRate),
+				Duration: w.Long,
+			})
+		}
+		t.firing[i] = firing
+	}
+}
+
+// burn returns how many times faster than sustainable the error budget
+// was consumed over the window ending now. Until a full window of samples
+// exists, the oldest sample is used.
+func (t *Tracker) burn(now time.Time, window time.Duration) float64 {
+	cur := t.samples[len(t.samples)-1]
+	base := t.samples[0]
+	for _, s := range t.samples {
+		if now.Sub(s.at) <= window {
+			break
+		}
+		base = s
+	}
+
+	total := cur.total - base.total
+	if total == 0 {
+		return 0
+	}
+	bad := total - (cur.good - base.good)
+	return float64(bad) / float64(total) / (1 - t.cfg.Target)
+}
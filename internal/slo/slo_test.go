@@ -0,0 +1,75 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+)
+
+func TestBurnRateAlert(t *testing.T) {
+	h := metrics.NewRegistry().Histogram("test_seconds", "test", metrics.DefBuckets)
+	bus := events.NewBus()
+	alerts := bus.Subscribe("test", 8)
+
+	tr := NewTracker(config.SLOConfig{
+		Target:  0.99,
+		Latency: 50 * time.Millisecond,
+		Windows: []config.BurnWindow{{Long: time.Hour, Short: 5 * time.Minute, BurnRate: 10}},
+	}, bus, h)
+
+	now := time.Now()
+	tr.sample(now)
+
+	// 1% slow queries burns the budget at exactly the sustainable rate
+	for i := 0; i < 99; i++ {
+		h.Observe(.01)
+	}
+	h.Observe(.2)
+	now = now.Add(time.Minute)
+	tr.sample(now)
+	if got := tr.burn(now, time.Hour); got < .99 || got > 1.01 {
+		t.Fatalf("burn = %v, want 1", got)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("alert at sustainable burn rate")
+	}
+
+	// A fifth of queries slow burns 20x over both windows
+	for i := 0; i < 400; i++ {
+		h.Observe(.01)
+		if i%4 == 0 {
+			h.Observe(.3)
+			h.Observe(.3)
+			h.Observe(.3)
+		}
+	}
+	now = now.Add(time.Minute)
+	tr.sample(now)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	if e := <-alerts; e.Type != events.SLOBurnRate || e.Duration != time.Hour {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	// Still firing: no repeat
+	h.Observe(.3)
+	now = now.Add(time.Minute)
+	tr.sample(now)
+	if len(alerts) != 0 {
+		t.Fatalf("alert repeated while firing")
+	}
+
+	// Only fast queries for longer than the short window clears it
+	for i := 0; i < 10000; i++ {
+		h.Observe(.001)
+	}
+	now = now.Add(10 * time.Minute)
+	tr.sample(now)
+	if tr.firing[0] {
+		t.Fatalf("still firing after recovery")
+	}
+}
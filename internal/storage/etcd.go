@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+)
+
+const (
+	// maxEtcdResponse bounds the response read from etcd
+	maxEtcdResponse = 64 << 20
+	// etcdShardSize is the most snapshot bytes put in one request. etcd
+	// limits requests to 1.5 MiB by default, and values are base64 in the
+	// gateway's JSON.
+	etcdShardSize = 768 << 10
+)
+
+// Etcd keeps snapshots in etcd through its v3 JSON gateway. Endpoints are
+// tried in order until one answers. A snapshot is split into shards small
+// enough for etcd's request limit, written under a new generation, and
+// then published by a manifest naming that generation, so a reader never
+// sees a partly written snapshot.
+type Etcd struct {
+	cfg  config.EtcdStorageConfig
+	http *http.Client
+
+	mu    sync.Mutex
+	token string // auth token with a username set
+}
+
+// NewEtcd creates an etcd store
+func NewEtcd(cfg config.EtcdStorageConfig) *Etcd {
+	return &Etcd{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// etcdManifest names the shards of the snapshot stored under a key
+type etcdManifest struct {
+	Generation string `json:"generation"`
+	Shards     int    `json:"shards"`
+	Size       int    `json:"size"`
+}
+
+// Get returns the snapshot stored under key
+func (s *Etcd) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.get(ctx, s.manifestKey(key))
+	if errors.Is(err, ErrNotFound) {
+		// Stored in one value, before snapshots were sharded
+		return s.get(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m etcdManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("etcd manifest of %s: %w", key, err)
+	}
+
+	value := make([]byte, 0, m.Size)
+	for i := 0; i < m.Shards; i++ {
+		shard, err := s.get(ctx, s.shardKey(key, m.Generation, i))
+		if err != nil {
+			return nil, fmt.Errorf("etcd shard %d of %s: %w", i, key, err)
+		}
+		value = append(value, shard...)
+	}
+	if len(value) != m.Size {
+		return nil, fmt.Errorf("etcd snapshot of %s is %d bytes, expected %d", key, len(value), m.Size)
+	}
+	return value, nil
+}
+
+// Put stores a snapshot under key. Shards of earlier generations are
+// removed once the manifest names the new one; should that fail, the next
+// Put removes them.
+func (s *Etcd) Put(ctx context.Context, key string, value []byte) error {
+	m := etcdManifest{
+		// Fixed width, so generations sort by age
+		Generation: fmt.Sprintf("%020d", time.Now().UnixNano()),
+		Shards:     (len(value) + etcdShardSize - 1) / etcdShardSize,
+		Size:       len(value),
+	}
+	for i := 0; i < m.Shards; i++ {
+		shard := value[i*etcdShardSize : min(len(value), (i+1)*etcdShardSize)]
+		if err := s.put(ctx, s.shardKey(key, m.Generation, i), shard); err != nil {
+			return fmt.Errorf("etcd shard %d of %s: %w", i, key, err)
+		}
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := s.put(ctx, s.manifestKey(key), manifest); err != nil {
+		return err
+	}
+
+	s.deleteRange(ctx, s.cfg.Prefix+key+"/shards/", s.cfg.Prefix+key+"/shards/"+m.Generation)
+	s.deleteRange(ctx, s.cfg.Prefix+key, "")
+	return nil
+}
+
+// get returns the value of the etcd key prefix+key
+func (s *Etcd) get(ctx context.Context, key string) ([]byte, error) {
+	var resp struct {
+		KVs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := s.call(ctx, "/v3/kv/range", map[string]string{"key": s.key(key)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.KVs) == 0 {
+		return nil, ErrNotFound
+	}
+	return base64.StdEncoding.DecodeString(resp.KVs[0].Value)
+}
+
+// put sets the etcd key prefix+key
+func (s *Etcd) put(ctx context.Context, key string, value []byte) error {
+	return s.call(ctx, "/v3/kv/put", map[string]string{
+		"key":   s.key(key),
+		"value": base64.StdEncoding.EncodeToString(value),
+	}, nil)
+}
+
+// deleteRange removes the etcd keys from start up to end, or only start
+// when end is empty
+func (s *Etcd) deleteRange(ctx context.Context, start, end string) error {
+	body := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(start))}
+	if end != "" {
+		body["range_end"] = base64.StdEncoding.EncodeToString([]byte(end))
+	}
+	return s.call(ctx, "/v3/kv/deleterange", body, nil)
+}
+
+// manifestKey is the key of the manifest of the snapshot under key
+func (s *Etcd) manifestKey(key string) string {
+	return key + "/manifest"
+}
+
+// shardKey is the key of shard i of generation of the snapshot under key
+func (s *Etcd) shardKey(key, generation string, i int) string {
+	return fmt.Sprintf("%s/shards/%s/%06d", key, generation, i)
+}
+
+// Close does nothing
+func (s *Etcd) Close() error { return nil }
+
+// key returns the base64 etcd key of key
+func (s *Etcd) key(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s.cfg.Prefix + key))
+}
+
+// call posts body to path on the first endpoint that answers,
+// authenticating first with a username set, and decodes the response
+// into out
+func (s *Etcd) call(ctx context.Context, path string, body interface{}, out interface{}) error {
+	var err error
+	for _, endpoint := range s.cfg.Endpoints {
+		endpoint = strings.TrimRight(endpoint, "/")
+		var token string
+		if token, err = s.authenticate(ctx, endpoint); err != nil {
+			continue
+		}
+		var status int
+		status, err = s.post(ctx, endpoint+path, token, body, out)
+		if status == http.StatusUnauthorized {
+			// The token expired; fetch a new one next time
+			s.mu.Lock()
+			s.token = ""
+			s.mu.Unlock()
+		}
+		if err == nil || status >= 400 && status < 500 && status != http.StatusUnauthorized {
+			return err
+		}
+	}
+	return err
+}
+
+// authenticate returns an auth token, fetching one if there is a username
+// and none yet
+func (s *Etcd) authenticate(ctx context.Context, endpoint string) (string, error) {
+	if s.cfg.Username == "" {
+		return "", nil
+	}
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if _, err := s.post(ctx, endpoint+"/v3/auth/authenticate", "", map[string]string{
+		"name":     s.cfg.Username,
+		"password": s.cfg.Password.Value(),
+	}, &resp); err != nil {
+		return "", fmt.Errorf("etcd authentication: %w", err)
+	}
+	s.mu.Lock()
+	s.token = resp.Token
+	s.mu.Unlock()
+	return resp.Token, nil
+}
+
+// post sends one request and returns the response status
+func (s *Etcd) post(ctx context.Context, url, token string, body interface{}, out interface{}) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, maxEtcdResponse))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(payload, &e)
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return resp.StatusCode, errors.New("etcd: " + e.Message)
+	}
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.Unmarshal(payload, out)
+}
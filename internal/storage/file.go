@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File keeps each snapshot in a file of its own in a directory. Files are
+// written to a temporary name and renamed, so a crash never leaves a
+// partial snapshot.
+type File struct {
+	dir string
+}
+
+// NewFile creates a file store in dir, creating the directory if needed
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir}, nil
+}
+
+// path returns the file of key
+func (f *File) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key[0] == '.' {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(f.dir, key+".json"), nil
+}
+
+// Get returns the snapshot stored under key
+func (f *File) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Put stores a snapshot under key
+func (f *File) Put(_ context.Context, key string, value []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".storage-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Close does nothing
+func (f *File) Close() error { return nil }
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+)
+
+// maxRedisValue bounds the snapshot read back from Redis
+const maxRedisValue = 512 << 20
+
+// errRedisNil is a nil bulk reply, a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// Redis keeps snapshots in Redis, speaking its protocol over one
+// connection that is redialed after a failure
+type Redis struct {
+	cfg config.RedisStorageConfig
+	tls *tls.Config // nil for plain TCP
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedis creates a Redis store. It connects on first use.
+func NewRedis(cfg config.RedisStorageConfig) (*Redis, error) {
+	s := &Redis{cfg: cfg}
+	if !cfg.TLS {
+		return s, nil
+	}
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	s.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if cfg.CA != "" {
+		pem, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CA)
+		}
+		s.tls.RootCAs = pool
+	}
+	return s, nil
+}
+
+// Get returns the snapshot stored under key
+func (s *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.do(ctx, "GET", s.cfg.Prefix+key)
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// Put stores a snapshot under key
+func (s *Redis) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.do(ctx, "SET", s.cfg.Prefix+key, string(value))
+	return err
+}
+
+// Close closes the connection
+func (s *Redis) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// do sends a command and returns its reply, connecting first if needed
+func (s *Redis) do(ctx context.Context, args ...string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := time.Now().Add(s.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if s.conn == nil {
+		if err := s.dialLocked(ctx, deadline); err != nil {
+			return nil, err
+		}
+	}
+	value, err := s.roundTripLocked(deadline, args)
+	var replyErr redisError
+	if err != nil && !errors.Is(err, errRedisNil) && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state
+		s.conn.Close()
+		s.conn = nil
+	}
+	return value, err
+}
+
+// dialLocked connects, authenticates and selects the database
+func (s *Redis) dialLocked(ctx context.Context, deadline time.Time) error {
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		dialer := tls.Dialer{NetDialer: &net.Dialer{Deadline: deadline}, Config: s.tls}
+		conn, err = dialer.DialContext(ctx, "tcp", s.cfg.Address)
+	} else {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err = dialer.DialContext(ctx, "tcp", s.cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	var setup [][]string
+	if password := s.cfg.Password.Value(); password != "" {
+		if s.cfg.Username != "" {
+			setup = append(setup, []string{"AUTH", s.cfg.Username, password})
+		} else {
+			setup = append(setup, []string{"AUTH", password})
+		}
+	}
+	if s.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.cfg.DB)})
+	}
+	for _, args := range setup {
+		if _, err := s.roundTripLocked(deadline, args); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("redis %s: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// roundTripLocked writes a command and reads its reply
+func (s *Redis) roundTripLocked(deadline time.Time, args []string) ([]byte, error) {
+	s.conn.SetDeadline(deadline)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(s.r)
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+// Error implements the error interface
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readRedisReply reads a simple string, error, integer or bulk string
+// reply
+func readRedisReply(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		switch {
+		case err != nil || n > maxRedisValue:
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		case n < 0:
+			return nil, errRedisNil
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,153 @@
+// Package storage keeps the state of components, such as the block list,
+// abuse reports and answer baselines, in a backend chosen per component:
+// the process's memory, files, Redis or etcd. Components hand over their
+// state as a snapshot through the same Export and Import functions used
+// for in-place upgrades, so every backend shares one code path and they
+// differ only in durability and in whether instances can share state.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var (
+	saves = metrics.NewCounterVec("ddd_storage_saves_total",
+		"State snapshots saved, by component and result", "component", "result")
+	saveBytes = metrics.NewGaugeVec("ddd_storage_snapshot_bytes",
+		"Size of the last saved snapshot, by component", "component")
+)
+
+// ErrNotFound is returned by Get for a key never stored
+var ErrNotFound = errors.New("not found")
+
+// Store is a storage backend holding snapshots by key
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+	Close() error
+}
+
+// Open creates the store of a backend
+func Open(backend string, cfg config.StorageConfig) (Store, error) {
+	switch backend {
+	case config.BackendMemory:
+		return NewMemory(), nil
+	case config.BackendFile:
+		return NewFile(cfg.File.Dir)
+	case config.BackendRedis:
+		return NewRedis(cfg.Redis)
+	case config.BackendEtcd:
+		return NewEtcd(cfg.Etcd), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// Memory keeps snapshots in the process; they do not survive a restart
+type Memory struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+// NewMemory creates an empty memory store
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string][]byte)}
+}
+
+// Get returns the snapshot stored under key
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Put stores a snapshot under key
+func (m *Memory) Put(_ context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Close does nothing
+func (m *Memory) Close() error { return nil }
+
+// Component is one component's state kept in a store
+type Component struct {
+	Name   string
+	Store  Store
+	Export func(io.Writer) (int, error)
+	Import func(io.Reader) (int, error)
+}
+
+// Restore imports the component's saved state and returns how many
+// entries it restored. Nothing saved yet is not an error.
+func (c Component) Restore(ctx context.Context) (int, error) {
+	data, err := c.Store.Get(ctx, c.Name)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.Import(bytes.NewReader(data))
+}
+
+// Save stores the component's current state and returns how many entries
+// it saved
+func (c Component) Save(ctx context.Context) (int, error) {
+	var buf bytes.Buffer
+	n, err := c.Export(&buf)
+	if err == nil {
+		err = c.Store.Put(ctx, c.Name, buf.Bytes())
+	}
+	if err != nil {
+		saves.With(c.Name, "error").Inc()
+		return 0, err
+	}
+	saves.With(c.Name, "ok").Inc()
+	saveBytes.With(c.Name).Set(float64(buf.Len()))
+	return n, nil
+}
+
+// Run saves components every interval until ctx is cancelled, giving each
+// save up to timeout
+func Run(ctx context.Context, interval, timeout time.Duration, components []Component, log *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			SaveAll(timeout, components, log)
+		}
+	}
+}
+
+// SaveAll saves components, giving each up to timeout, and logs failures
+func SaveAll(timeout time.Duration, components []Component, log *logger.Logger) {
+	for _, c := range components {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		saved, err := c.Save(ctx)
+		cancel()
+		if err != nil {
+			log.Errorw("Failed to save state", "component", c.Name, "error", err)
+			continue
+		}
+		log.Debugw("Saved state", "component", c.Name, "entries", saved)
+	}
+}
@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+)
+
+// roundTrip checks that store keeps what it is given, and reports missing
+// keys as such
+func roundTrip(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.Get(ctx, "blocks"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected a missing key to be not found, got %v", err)
+	}
+	for _, value := range []string{`[{"ip":"192.0.2.10"}]`, "[]"} {
+		if err := store.Put(ctx, "blocks", []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(ctx, "blocks")
+		if err != nil || string(got) != value {
+			t.Fatalf("Expected %q back, got %q, %v", value, got, err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryAndFile(t *testing.T) {
+	roundTrip(t, NewMemory())
+
+	dir := t.TempDir()
+	f, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, f)
+	if _, err := f.Get(context.Background(), "../config"); err == nil {
+		t.Error("Expected a key outside the directory to be rejected")
+	}
+}
+
+// fakeRedis serves GET, SET, AUTH and SELECT from a map
+func fakeRedis(t *testing.T, password string, tlsConfig *tls.Config) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		authed := password == ""
+		for {
+			var args []string
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			for i := 0; i < n; i++ {
+				header, _ := r.ReadString('\n')
+				size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+				buf := make([]byte, size+2)
+				io.ReadFull(r, buf)
+				args = append(args, string(buf[:size]))
+			}
+			mu.Lock()
+			switch {
+			case args[0] == "AUTH" && args[len(args)-1] == password:
+				authed = true
+				io.WriteString(conn, "+OK\r\n")
+			case !authed:
+				io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			case args[0] == "SELECT":
+				io.WriteString(conn, "+OK\r\n")
+			case args[0] == "SET":
+				data[args[1]] = args[2]
+				io.WriteString(conn, "+OK\r\n")
+			case args[0] == "GET":
+				if v, ok := data[args[1]]; ok {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+				} else {
+					io.WriteString(conn, "$-1\r\n")
+				}
+			default:
+				io.WriteString(conn, "-ERR unknown command\r\n")
+			}
+			mu.Unlock()
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedis(t *testing.T) {
+	cfg := config.Default().Storage.Redis
+	cfg.Address = fakeRedis(t, "", nil)
+	cfg.DB = 2
+	store, err := NewRedis(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, store)
+}
+
+func TestRedisTLS(t *testing.T) {
+	// Borrow the test server's certificate, issued for 127.0.0.1
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default().Storage.Redis
+	cfg.Address = fakeRedis(t, "", srv.TLS)
+	cfg.TLS = true
+	cfg.CA = ca
+	store, err := NewRedis(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, store)
+
+	// Without the CA the server is not trusted
+	cfg.CA = ""
+	store, err = NewRedis(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "blocks", []byte("[]")); err == nil {
+		t.Error("Expected a server outside the system roots to be refused")
+	}
+}
+
+// fakeEtcd serves the etcd v3 JSON gateway's put, range and deleterange
+// from a map, refusing requests over etcd's default 1.5 MiB limit. keys
+// lists the keys held.
+func fakeEtcd(t *testing.T) (srv *httptest.Server, keys func() []string) {
+	var mu sync.Mutex
+	data := make(map[string]string)
+	decode := func(s string) string {
+		b, _ := base64.StdEncoding.DecodeString(s)
+		return string(b)
+	}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > 3<<19 {
+			http.Error(w, `{"error":"etcdserver: request is too large"}`, http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Key, Value string
+			RangeEnd   string `json:"range_end"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		key, end := decode(req.Key), decode(req.RangeEnd)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v3/kv/put":
+			data[key] = req.Value
+			io.WriteString(w, `{}`)
+		case "/v3/kv/range":
+			var resp struct {
+				KVs []map[string]string `json:"kvs,omitempty"`
+			}
+			if v, ok := data[key]; ok {
+				resp.KVs = append(resp.KVs, map[string]string{"key": req.Key, "value": v})
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/v3/kv/deleterange":
+			for k := range data {
+				if k == key || end != "" && k >= key && k < end {
+					delete(data, k)
+				}
+			}
+			io.WriteString(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	keys = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var held []string
+		for k := range data {
+			held = append(held, k)
+		}
+		sort.Strings(held)
+		return held
+	}
+	return srv, keys
+}
+
+func TestEtcd(t *testing.T) {
+	srv, _ := fakeEtcd(t)
+	cfg := config.Default().Storage.Etcd
+	// The first endpoint is down; the second answers
+	cfg.Endpoints = []string{"http://127.0.0.1:1", srv.URL}
+	roundTrip(t, NewEtcd(cfg))
+}
+
+func TestEtcdShardsLargeSnapshots(t *testing.T) {
+	srv, keys := fakeEtcd(t)
+	cfg := config.Default().Storage.Etcd
+	cfg.Endpoints = []string{srv.URL}
+	cfg.Prefix = "/ddd/"
+	store := NewEtcd(cfg)
+	ctx := context.Background()
+
+	// A snapshot written before sharding is still read
+	legacy := []byte(`[{"ip":"192.0.2.10"}]`)
+	if err := store.put(ctx, "blocks", legacy); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Get(ctx, "blocks"); err != nil || string(got) != string(legacy) {
+		t.Fatalf("Expected the unsharded snapshot back, got %q, %v", got, err)
+	}
+
+	// Well over the request limit, and not a whole number of shards
+	large := []byte(strings.Repeat("0123456789abcdef", 5*etcdShardSize/2/16))
+	for _, value := range [][]byte{large, []byte("[]")} {
+		if err := store.Put(ctx, "blocks", value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(ctx, "blocks")
+		if err != nil || string(got) != string(value) {
+			t.Fatalf("Expected %d bytes back, got %d, %v", len(value), len(got), err)
+		}
+	}
+
+	// Only the manifest and the latest snapshot's one shard are left
+	held := keys()
+	if len(held) != 2 || held[0] != "/ddd/blocks/manifest" || !strings.HasPrefix(held[1], "/ddd/blocks/shards/") {
+		t.Errorf("Expected a manifest and one shard, got %q", held)
+	}
+}
+
+func TestRedisAuthentication(t *testing.T) {
+	cfg := config.Default().Storage.Redis
+	cfg.Address = fakeRedis(t, "secret", nil)
+	cfg.Timeout = time.Second
+	store, err := NewRedis(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "blocks", []byte("[]")); err == nil {
+		t.Error("Expected a store without the password to be refused")
+	}
+}
+
+func TestComponentSaveAndRestore(t *testing.T) {
+	store := NewMemory()
+	var restored string
+	c := Component{
+		Name:  "reputation",
+		Store: store,
+		Export: func(w io.Writer) (int, error) {
+			_, err := io.WriteString(w, `["192.0.2.10"]`)
+			return 1, err
+		},
+		Import: func(r io.Reader) (int, error) {
+			data, err := io.ReadAll(r)
+			restored = string(data)
+			return 1, err
+		},
+	}
+	if n, err := c.Restore(context.Background()); n != 0 || err != nil {
+		t.Fatalf("Expected nothing to restore before a save, got %d, %v", n, err)
+	}
+	if _, err := c.Save(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Restore(context.Background()); n != 1 || err != nil || restored != `["192.0.2.10"]` {
+		t.Errorf("Expected the saved state restored, got %d, %v, %q", n, err, restored)
+	}
+
+	// etcd keys are base64 in the gateway's API
+	if got := NewEtcd(config.EtcdStorageConfig{Prefix: "/ddd/"}).key("blocks"); got != base64.StdEncoding.EncodeToString([]byte("/ddd/blocks")) {
+		t.Errorf("Unexpected etcd key %q", got)
+	}
+}
@@ -0,0 +1,182 @@
+// Package update checks a release feed for releases newer than the
+// running binary. It is opt-in and only ever logs what it finds:
+// upgrading stays with the operator.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/logger"
+	"ddd/internal/metrics"
+)
+
+var checks = metrics.NewCounterVec("ddd_update_checks_total",
+	"Release feed checks, by result", "result")
+
+// maxFeedSize bounds the release feed document read
+const maxFeedSize = 1 << 20
+
+// Release is a published release
+type Release struct {
+	Version   string    `json:"tag_name"`
+	URL       string    `json:"html_url"`
+	Published time.Time `json:"published_at"`
+}
+
+// Checker polls the release feed
+type Checker struct {
+	cfg     config.UpdateConfig
+	current string
+	client  *http.Client
+	log     *logger.Logger
+
+	mu     sync.Mutex
+	latest *Release // newer than current, once found
+}
+
+// New creates a checker for the binary at version current
+func New(cfg config.UpdateConfig, current string, log *logger.Logger) *Checker {
+	return &Checker{
+		cfg:     cfg,
+		current: current,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		log:     log,
+	}
+}
+
+// Run checks the feed now and then every interval until ctx is done.
+// Builds that are not releases have nothing to compare and are not
+// checked.
+func (c *Checker) Run(ctx context.Context) {
+	if _, ok := parseVersion(c.current); !ok {
+		c.log.Infow("Update check skipped: not a release build", "version", c.current)
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		c.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Latest returns the newest release found that is newer than the running
+// binary, or nil. A nil Checker has found none.
+func (c *Checker) Latest() *Release {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latest
+}
+
+// check fetches the feed once and logs a newer release
+func (c *Checker) check(ctx context.Context) {
+	release, err := c.fetch(ctx)
+	if err != nil {
+		checks.With("failed").Inc()
+		c.log.Warnw("Update check failed", "feed", c.cfg.Feed, "error", err)
+		return
+	}
+	if !Newer(release.Version, c.current) {
+		checks.With("current").Inc()
+		return
+	}
+	checks.With("newer").Inc()
+
+	c.mu.Lock()
+	seen := c.latest != nil && c.latest.Version == release.Version
+	c.latest = release
+	c.mu.Unlock()
+	if !seen {
+		c.log.Infow("Newer release available",
+			"current", c.current,
+			"latest", release.Version,
+			"url", release.URL,
+			"event", "update_available",
+		)
+	}
+}
+
+// fetch reads the latest release from the feed
+func (c *Checker) fetch(ctx context.Context) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Feed, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "ddd/"+c.current)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFeedSize)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("decode feed: %w", err)
+	}
+	if _, ok := parseVersion(release.Version); !ok {
+		return nil, fmt.Errorf("feed has no release version, got %q", release.Version)
+	}
+	return &release, nil
+}
+
+// Newer reports whether release version a is newer than b. Both must be
+// release versions (vMAJOR.MINOR.PATCH); anything else is never newer.
+func Newer(a, b string) bool {
+	va, ok := parseVersion(a)
+	if !ok {
+		return false
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses a release version, ignoring build metadata.
+// Prereleases and Go pseudo-versions are not releases.
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	v, _, _ = strings.Cut(v, "+")
+	if !strings.HasPrefix(v, "v") || strings.Contains(v, "-") {
+		return parsed, false
+	}
+	parts := strings.Split(v[1:], ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -0,0 +1,68 @@
+package update
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/logger/loggertest"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v2.0.0", "v1.99.99", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.2.0", "v1.2.0+dirty", false},
+		{"v1.1.0", "v1.2.0", false},
+		{"v1.3.0-rc.1", "v1.2.0", false},
+		{"v1.3.0", "v0.0.0-20261016183748-997991b7ce8f", false},
+		{"v1.3.0", "(devel)", false},
+		{"1.3.0", "v1.2.0", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.a, tt.b); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLogsNewerReleaseOnce(t *testing.T) {
+	tag := "v1.2.0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tag_name": "` + tag + `", "html_url": "https://example.net/releases/` + tag + `"}`))
+	}))
+	defer srv.Close()
+
+	log, rec := loggertest.New(t)
+	c := New(config.UpdateConfig{Check: true, Feed: srv.URL, Interval: time.Hour}, "v1.2.0", log)
+	ctx := context.Background()
+
+	c.check(ctx)
+	if c.Latest() != nil || len(rec.Events("update_available")) != 0 {
+		t.Fatal("Expected nothing reported while current")
+	}
+
+	tag = "v1.3.0"
+	c.check(ctx)
+	c.check(ctx)
+	latest := c.Latest()
+	if latest == nil || latest.Version != "v1.3.0" || latest.URL != "https://example.net/releases/v1.3.0" {
+		t.Fatalf("Expected v1.3.0 as the latest release, got %+v", latest)
+	}
+	if n := len(rec.Events("update_available")); n != 1 {
+		t.Errorf("Expected the newer release logged once, got %d", n)
+	}
+
+	var nilChecker *Checker
+	if nilChecker.Latest() != nil {
+		t.Error("Expected a nil checker to report nothing")
+	}
+}
@@ -0,0 +1,357 @@
+// Package upgrade replaces the running binary without dropping queries.
+// Listening sockets are opened through this package so that, on upgrade,
+// they can be handed to a newly exec'd copy of the binary by file
+// descriptor inheritance. Both processes then share the same sockets:
+// datagrams queued in the kernel are read by whichever process is still
+// serving, so none are lost while the old process shuts down. In-memory
+// state (block list, learned baselines) is streamed to the new process
+// over a private unix socket, and the old process exits once the new one
+// reports that it is serving.
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// envListeners names the inherited sockets, in fd order from 3, as
+	// comma-separated network|address keys
+	envListeners = "DDD_UPGRADE_LISTENERS"
+	// envState is the unix socket the old process serves state on
+	envState = "DDD_UPGRADE_STATE"
+
+	// ready is sent by the new process once it is serving
+	ready = 'k'
+)
+
+// Section is a named piece of in-memory state handed to the new process.
+// Export must write a single JSON value.
+type Section struct {
+	Name   string
+	Export func(w io.Writer) (int, error)
+	Import func(r io.Reader) (int, error)
+}
+
+// frame carries one section over the state socket
+type frame struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data"`
+}
+
+// fileSocket is a listener or packet conn whose descriptor can be passed on
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+// Upgrader tracks listening sockets and performs upgrades
+type Upgrader struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File   // network|address -> socket from the old process
+	sockets   map[string]fileSocket // sockets in use, passed on upgrade
+	statePath string
+	handoff   net.Conn // to the old process, until this one is serving
+}
+
+// std is the process-wide upgrader, set up from the environment left by
+// the old process, if any
+var std = New(os.Getenv(envListeners), os.Getenv(envState))
+
+// New creates an upgrader. listeners and statePath are the values left in
+// the environment by the old process; both are empty on a normal start.
+func New(listeners, statePath string) *Upgrader {
+	u := &Upgrader{
+		inherited: make(map[string]*os.File),
+		sockets:   make(map[string]fileSocket),
+		statePath: statePath,
+	}
+	if listeners != "" {
+		for i, key := range strings.Split(listeners, ",") {
+			u.inherited[key] = os.NewFile(uintptr(3+i), key)
+		}
+	}
+	return u
+}
+
+// Listen returns a stream listener on address, inherited from the old
+// process when it had one open there
+func Listen(network, address string) (net.Listener, error) {
+	return std.Listen(network, address)
+}
+
+// ListenPacket returns a packet conn on address, inherited from the old
+// process when it had one open there. lc applies only to new sockets; an
+// inherited socket keeps the options the old process set.
+func ListenPacket(lc *net.ListenConfig, network, address string) (net.PacketConn, error) {
+	return std.ListenPacket(lc, network, address)
+}
+
+// Release forgets the socket on address once it is closed; see
+// Upgrader.Release
+func Release(network, address string) {
+	std.Release(network, address)
+}
+
+// Upgrade starts the new binary; see Upgrader.Upgrade
+func Upgrade(sections []Section, timeout time.Duration) error {
+	return std.Upgrade(sections, timeout)
+}
+
+// Receive imports state from the old process; see Upgrader.Receive
+func Receive(sections []Section, timeout time.Duration) (map[string]int, error) {
+	return std.Receive(sections, timeout)
+}
+
+// Ready tells the old process this one is serving; see Upgrader.Ready
+func Ready() error {
+	return std.Ready()
+}
+
+// Listen returns a stream listener on address, inherited when possible
+func (u *Upgrader) Listen(network, address string) (net.Listener, error) {
+	key := network + "|" + address
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var l net.Listener
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		l, err = net.FileListener(f)
+		f.Close()
+	} else {
+		l, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := l.(fileSocket); ok {
+		u.sockets[key] = s
+	}
+	return l, nil
+}
+
+// ListenPacket returns a packet conn on address, inherited when possible
+func (u *Upgrader) ListenPacket(lc *net.ListenConfig, network, address string) (net.PacketConn, error) {
+	key := network + "|" + address
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var pc net.PacketConn
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		pc, err = lc.ListenPacket(context.Background(), network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := pc.(fileSocket); ok {
+		u.sockets[key] = s
+	}
+	return pc, nil
+}
+
+// Release forgets the socket on address, so that a listener stopped
+// while the process keeps running is not passed on upgrade
+func (u *Upgrader) Release(network, address string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.sockets, network+"|"+address)
+}
+
+// Upgrade execs the current binary with the same arguments, passing it
+// every socket opened through the upgrader, and streams it the exported
+// sections. It returns once the new process reports that it is serving,
+// waiting up to timeout for each of the handover and the new process's
+// startup; the caller should then shut down. On error, including the new
+// process exiting during startup, it is killed and the caller keeps
+// serving. State changed after the export is not transferred.
+func (u *Upgrader) Upgrade(sections []Section, timeout time.Duration) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// A private directory keeps other local users off the state socket
+	dir, err := os.MkdirTemp("", "ddd-upgrade-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	keys := make([]string, 0, len(u.sockets))
+	files := make([]*os.File, 0, len(u.sockets))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for key, s := range u.sockets {
+		f, err := s.File()
+		if err != nil {
+			return fmt.Errorf("passing %s: %w", key, err)
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(environWithout(envListeners, envState),
+		envListeners+"="+strings.Join(keys, ","),
+		envState+"="+path,
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting new process: %w", err)
+	}
+
+	if err := serveState(l.(*net.UnixListener), sections, timeout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+// serveState sends the sections to the first process to connect and waits
+// for it to report that it is serving
+func serveState(l *net.UnixListener, sections []Section, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	l.SetDeadline(deadline)
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		return fmt.Errorf("new process did not connect: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	enc := json.NewEncoder(conn)
+	for _, s := range sections {
+		var buf bytes.Buffer
+		if _, err := s.Export(&buf); err != nil {
+			return fmt.Errorf("exporting %s: %w", s.Name, err)
+		}
+		if err := enc.Encode(frame{Name: s.Name, Data: buf.Bytes()}); err != nil {
+			return fmt.Errorf("sending %s: %w", s.Name, err)
+		}
+	}
+	if err := conn.CloseWrite(); err != nil {
+		return err
+	}
+
+	// The new process still has to build the server and open its
+	// listeners, and may fail to
+	conn.SetDeadline(time.Now().Add(timeout))
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[0] != ready {
+		return errors.New("new process did not start serving")
+	}
+	return nil
+}
+
+// Receive imports the state sent by the old process into the matching
+// sections, returning the count imported per section. The old process
+// keeps serving until Ready is called, and until this one exits if it
+// never is. Receive does nothing when this process was not started by an
+// upgrade.
+func (u *Upgrader) Receive(sections []Section, timeout time.Duration) (map[string]int, error) {
+	if u.statePath == "" {
+		return nil, nil
+	}
+
+	conn, err := net.DialTimeout("unix", u.statePath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	counts := make(map[string]int)
+	dec := json.NewDecoder(conn)
+	for {
+		var f frame
+		if err := dec.Decode(&f); err == io.EOF {
+			break
+		} else if err != nil {
+			conn.Close()
+			return counts, err
+		}
+		for _, s := range sections {
+			if s.Name != f.Name {
+				continue
+			}
+			n, err := s.Import(bytes.NewReader(f.Data))
+			if err != nil {
+				conn.Close()
+				return counts, fmt.Errorf("importing %s: %w", s.Name, err)
+			}
+			counts[s.Name] = n
+		}
+	}
+
+	u.mu.Lock()
+	u.handoff = conn
+	u.mu.Unlock()
+	return counts, nil
+}
+
+// Ready tells the old process that this one is serving, so that it shuts
+// down. It does nothing when this process was not started by an upgrade.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	conn := u.handoff
+	u.handoff = nil
+	u.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Time{})
+	_, err := conn.Write([]byte{ready})
+	return err
+}
+
+// environWithout returns the environment minus the named variables
+func environWithout(names ...string) []string {
+	env := os.Environ()
+	kept := env[:0]
+	for _, kv := range env {
+		keep := true
+		for _, name := range names {
+			if strings.HasPrefix(kv, name+"=") {
+				keep = false
+			}
+		}
+		if keep {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
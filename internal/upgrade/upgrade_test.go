@@ -0,0 +1,123 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateHandover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	sent := []string{"192.0.2.1", "192.0.2.2"}
+	var received []string
+	sections := func(into *[]string) []Section {
+		return []Section{{
+			Name: "blocks",
+			Export: func(w io.Writer) (int, error) {
+				return len(sent), json.NewEncoder(w).Encode(sent)
+			},
+			Import: func(r io.Reader) (int, error) {
+				err := json.NewDecoder(r).Decode(into)
+				return len(*into), err
+			},
+		}}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveState(l.(*net.UnixListener), sections(nil), 5*time.Second)
+	}()
+
+	u := New("", path)
+	counts, err := u.Receive(sections(&received), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the old process to wait until the new one is serving, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := u.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Expected the old process to see the new one serving: %v", err)
+	}
+	if counts["blocks"] != 2 || len(received) != 2 || received[1] != "192.0.2.2" {
+		t.Errorf("Expected both blocks to be handed over, got %v (%v)", received, counts)
+	}
+}
+
+func TestHandoverStartupFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveState(l.(*net.UnixListener), nil, 5*time.Second)
+	}()
+
+	u := New("", path)
+	if _, err := u.Receive(nil, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// The new process exits before it is serving
+	u.handoff.Close()
+	if err := <-done; err == nil {
+		t.Error("Expected the old process to keep serving when the new one fails to start")
+	}
+}
+
+func TestReceiveWithoutUpgrade(t *testing.T) {
+	counts, err := New("", "").Receive(nil, time.Second)
+	if counts != nil || err != nil {
+		t.Errorf("Expected nothing to receive on a normal start, got %v, %v", counts, err)
+	}
+	if err := New("", "").Ready(); err != nil {
+		t.Errorf("Expected nothing to report on a normal start, got %v", err)
+	}
+}
+
+func TestListenPacketInherits(t *testing.T) {
+	old := New("", "")
+	pc, err := old.ListenPacket(&net.ListenConfig{}, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	f, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for the descriptor a new process would find at fd 3+i
+	u := New("", "")
+	u.inherited["udp|127.0.0.1:0"] = f
+	inherited, err := u.ListenPacket(&net.ListenConfig{}, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+
+	if inherited.LocalAddr().String() != pc.LocalAddr().String() {
+		t.Errorf("Expected the inherited socket on %v, got %v", pc.LocalAddr(), inherited.LocalAddr())
+	}
+	if len(u.inherited) != 0 || u.sockets["udp|127.0.0.1:0"] == nil {
+		t.Error("Expected the inherited socket to be tracked for the next upgrade")
+	}
+}
@@ -0,0 +1,370 @@
+// Package upstream tracks the health of each upstream resolver: recent
+// query rate, error rate and latency, and a circuit breaker that stops
+// sending queries to a resolver after repeated failures until a probe
+// succeeds again. A SERVFAIL answer is told apart from a resolver that
+// does not answer: it usually means the domain is broken, so it only
+// counts against the resolver when it is much of the resolver's traffic
+// and spreads across many registered domains.
+package upstream
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"ddd/internal/metrics"
+)
+
+var (
+	latencyHist = metrics.NewHistogramVec("ddd_upstream_latency_seconds",
+		"Upstream exchange round-trip time, by resolver", metrics.DefBuckets, "upstream")
+	breakerOpen = metrics.NewGaugeVec("ddd_upstream_breaker_open",
+		"1 while a resolver's circuit breaker is open or half open", "upstream")
+	breakerTrips = metrics.NewCounterVec("ddd_upstream_breaker_trips_total",
+		"Times a resolver's circuit breaker opened", "upstream")
+	servfailCount = metrics.NewCounterVec("ddd_upstream_servfails_total",
+		"SERVFAIL answers from upstream, by resolver", "upstream")
+)
+
+// Breaker states
+const (
+	Closed   = "closed"    // queries flow normally
+	Open     = "open"      // the resolver is skipped
+	HalfOpen = "half_open" // one probe query is let through
+)
+
+const (
+	tripAfter   = 5                // consecutive failures that open the breaker
+	openFor     = 30 * time.Second // how long an open breaker skips the resolver
+	rateSpan    = 60               // seconds covered by QPS and error rate
+	latencyKeep = 256              // recent round trips kept for percentiles
+	// servfailKeep is how many recent SERVFAIL domains are kept.
+	// SERVFAIL is a failure of the resolver rather than of the domains
+	// once, over the last minute, servfailSpread distinct registered
+	// domains got it and it was at least servfailShare of the answers,
+	// so a flood of random names under one broken zone does not count.
+	servfailKeep   = 64
+	servfailSpread = 8
+	servfailShare  = 0.5
+)
+
+// Stats describes one resolver
+type Stats struct {
+	Address string `json:"address"`
+	Healthy bool   `json:"healthy"`
+	Breaker string `json:"breaker"`
+
+	// Over the last minute
+	QPS          float64 `json:"qps"`
+	ErrorRate    float64 `json:"error_rate"`    // failed share of exchanges
+	ServfailRate float64 `json:"servfail_rate"` // share answered SERVFAIL
+	// ServfailDomains is how many distinct registered domains were
+	// answered SERVFAIL
+	ServfailDomains int `json:"servfail_domains"`
+
+	// Of the most recent exchanges, in milliseconds
+	LatencyP50 float64 `json:"latency_p50_ms"`
+	LatencyP90 float64 `json:"latency_p90_ms"`
+	LatencyP99 float64 `json:"latency_p99_ms"`
+
+	Exchanges           uint64    `json:"exchanges"`
+	Failures            uint64    `json:"failures"`
+	Servfails           uint64    `json:"servfails"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenUntil           time.Time `json:"open_until,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// resolver is the state kept for one upstream
+type resolver struct {
+	addr      string
+	hist      *metrics.Histogram
+	open      *metrics.Gauge
+	servfails *metrics.Counter
+
+	mu          sync.Mutex
+	exchanges   uint64
+	failures    uint64
+	servfailed  uint64
+	consecutive int
+	openUntil   time.Time
+	probing     bool // a half-open probe is in flight
+	lastError   string
+
+	stamps   [rateSpan]int64
+	counts   [rateSpan]int
+	failed   [rateSpan]int
+	soft     [rateSpan]int // SERVFAIL answers
+	rtts     [latencyKeep]time.Duration
+	rttCount int
+
+	// registered domains recently answered SERVFAIL and when, a ring of
+	// servfailKeep
+	sfNames [servfailKeep]string
+	sfTimes [servfailKeep]int64
+	sfCount int
+}
+
+// Tracker holds the state of every upstream
+type Tracker struct {
+	resolvers map[string]*resolver
+	order     []string
+}
+
+// NewTracker tracks the given resolvers
+func NewTracker(addrs []string) *Tracker {
+	t := &Tracker{resolvers: make(map[string]*resolver, len(addrs))}
+	for _, addr := range addrs {
+		if _, ok := t.resolvers[addr]; ok {
+			continue
+		}
+		t.resolvers[addr] = &resolver{
+			addr:      addr,
+			hist:      latencyHist.With(addr),
+			open:      breakerOpen.With(addr),
+			servfails: servfailCount.With(addr),
+		}
+		t.order = append(t.order, addr)
+	}
+	return t
+}
+
+// Allow reports whether a query may be sent to addr. An open breaker
+// refuses until its time is up, then lets a single probe through. It is
+// true for any resolver on a nil tracker.
+func (t *Tracker) Allow(addr string, now time.Time) bool {
+	if t == nil {
+		return true
+	}
+	r, ok := t.resolvers[addr]
+	if !ok {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.state(now) {
+	case Open:
+		return false
+	case HalfOpen:
+		if r.probing {
+			return false
+		}
+		r.probing = true
+	}
+	return true
+}
+
+// Observe records an exchange with addr for qname that took rtt. err is
+// a failure to get an answer, such as a timeout; servfail is a SERVFAIL
+// answer. An answer shows the resolver is up, so SERVFAIL only counts as
+// a failure, toward the breaker and the error rate, while the resolver
+// is answering SERVFAIL for much of its traffic across many domains.
+func (t *Tracker) Observe(addr, qname string, rtt time.Duration, servfail bool, err error, now time.Time) {
+	if t == nil {
+		return
+	}
+	r, ok := t.resolvers[addr]
+	if !ok {
+		return
+	}
+	if err == nil {
+		r.hist.Observe(rtt.Seconds())
+	}
+	var domain string
+	if servfail && err == nil {
+		domain = registeredDomain(qname)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sec := now.Unix()
+	slot := sec % rateSpan
+	if r.stamps[slot] != sec {
+		r.stamps[slot], r.counts[slot], r.failed[slot], r.soft[slot] = sec, 0, 0, 0
+	}
+	r.counts[slot]++
+	r.exchanges++
+	if err == nil {
+		r.rtts[r.rttCount%latencyKeep] = rtt
+		r.rttCount++
+	}
+
+	failed := err != nil
+	if servfail && err == nil {
+		r.soft[slot]++
+		r.servfailed++
+		r.servfails.Inc()
+		i := r.sfCount % servfailKeep
+		r.sfNames[i], r.sfTimes[i] = domain, sec
+		r.sfCount++
+		failed = r.servfailingLocked(now)
+	}
+
+	wasProbe := r.probing
+	r.probing = false
+	if !failed {
+		r.consecutive = 0
+		if !r.openUntil.IsZero() {
+			r.openUntil = time.Time{}
+			r.open.Set(0)
+		}
+		return
+	}
+
+	r.failed[slot]++
+	r.failures++
+	r.consecutive++
+	if err != nil {
+		r.lastError = err.Error()
+	} else {
+		r.lastError = "SERVFAIL for many domains"
+	}
+	if wasProbe || r.openUntil.IsZero() && r.consecutive >= tripAfter {
+		r.openUntil = now.Add(openFor)
+		r.open.Set(1)
+		breakerTrips.With(r.addr).Inc()
+	}
+}
+
+// Servfailing reports whether addr is answering SERVFAIL for much of its
+// traffic across many domains over the last minute, so that a SERVFAIL from it is worth retrying on
+// another resolver
+func (t *Tracker) Servfailing(addr string, now time.Time) bool {
+	if t == nil {
+		return false
+	}
+	r, ok := t.resolvers[addr]
+	if !ok {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.servfailingLocked(now)
+}
+
+// registeredDomain returns the registered domain (eTLD+1) of qname, or
+// qname itself when it has none
+func registeredDomain(qname string) string {
+	name := strings.ToLower(strings.TrimSuffix(qname, "."))
+	if base, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return base
+	}
+	return name
+}
+
+// servfailingLocked reports whether SERVFAIL over the last minute is a
+// failure of the resolver. r.mu must be held.
+func (r *resolver) servfailingLocked(now time.Time) bool {
+	var count, soft int
+	oldest := now.Unix() - rateSpan
+	for i, stamp := range r.stamps {
+		if stamp > oldest && stamp <= now.Unix() {
+			count += r.counts[i]
+			soft += r.soft[i]
+		}
+	}
+	if count == 0 || float64(soft) < servfailShare*float64(count) {
+		return false
+	}
+	return r.spreadLocked(now) >= servfailSpread
+}
+
+// spreadLocked counts the distinct registered domains answered SERVFAIL
+// over the last minute. The ring is small, so it compares entries rather
+// than allocating a set. r.mu must be held.
+func (r *resolver) spreadLocked(now time.Time) int {
+	oldest := now.Unix() - rateSpan
+	n, distinct := min(r.sfCount, servfailKeep), 0
+next:
+	for i := 0; i < n; i++ {
+		if r.sfTimes[i] <= oldest {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if r.sfTimes[j] > oldest && r.sfNames[j] == r.sfNames[i] {
+				continue next
+			}
+		}
+		distinct++
+	}
+	return distinct
+}
+
+// state returns the breaker state. r.mu must be held.
+func (r *resolver) state(now time.Time) string {
+	switch {
+	case r.openUntil.IsZero():
+		return Closed
+	case now.Before(r.openUntil):
+		return Open
+	}
+	return HalfOpen
+}
+
+// Snapshot returns the state of every resolver in configuration order
+func (t *Tracker) Snapshot(now time.Time) []Stats {
+	if t == nil {
+		return nil
+	}
+	out := make([]Stats, 0, len(t.order))
+	for _, addr := range t.order {
+		out = append(out, t.resolvers[addr].stats(now))
+	}
+	return out
+}
+
+// stats describes the resolver
+func (r *resolver) stats(now time.Time) Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st := Stats{
+		Address:             r.addr,
+		Breaker:             r.state(now),
+		Exchanges:           r.exchanges,
+		Failures:            r.failures,
+		Servfails:           r.servfailed,
+		ConsecutiveFailures: r.consecutive,
+		LastError:           r.lastError,
+	}
+	if st.Breaker != Closed {
+		st.OpenUntil = r.openUntil
+	}
+
+	var count, failed, soft int
+	oldest := now.Unix() - rateSpan
+	for i, stamp := range r.stamps {
+		if stamp > oldest && stamp <= now.Unix() {
+			count += r.counts[i]
+			failed += r.failed[i]
+			soft += r.soft[i]
+		}
+	}
+	st.QPS = float64(count) / rateSpan
+	if count > 0 {
+		st.ErrorRate = float64(failed) / float64(count)
+		st.ServfailRate = float64(soft) / float64(count)
+	}
+	st.ServfailDomains = r.spreadLocked(now)
+	st.Healthy = st.Breaker == Closed && st.ErrorRate < 0.5
+
+	n := min(r.rttCount, latencyKeep)
+	if n > 0 {
+		rtts := append([]time.Duration(nil), r.rtts[:n]...)
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		st.LatencyP50 = millis(rtts[n*50/100])
+		st.LatencyP90 = millis(rtts[n*90/100])
+		st.LatencyP99 = millis(rtts[n*99/100])
+	}
+	return st
+}
+
+// millis converts a duration to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	tr := NewTracker([]string{"192.0.2.1:53", "192.0.2.2:53"})
+	addr := "192.0.2.1:53"
+	now := time.Unix(1700000000, 0)
+	timeout := errors.New("i/o timeout")
+
+	for i := 0; i < tripAfter-1; i++ {
+		tr.Observe(addr, "example.com.", 0, false, timeout, now)
+	}
+	if !tr.Allow(addr, now) {
+		t.Fatal("Expected the breaker to stay closed below the trip threshold")
+	}
+	tr.Observe(addr, "example.com.", 0, false, timeout, now)
+	if tr.Allow(addr, now) {
+		t.Fatal("Expected the breaker to open after repeated failures")
+	}
+	if !tr.Allow("192.0.2.2:53", now) {
+		t.Error("Expected the other resolver to be unaffected")
+	}
+
+	// Once the open period ends a single probe goes through; its failure
+	// opens the breaker again
+	now = now.Add(openFor)
+	if !tr.Allow(addr, now) || tr.Allow(addr, now) {
+		t.Fatal("Expected exactly one half-open probe")
+	}
+	tr.Observe(addr, "example.com.", 0, false, timeout, now)
+	if tr.Allow(addr, now) {
+		t.Fatal("Expected a failed probe to reopen the breaker")
+	}
+
+	// A successful probe closes it
+	now = now.Add(openFor)
+	if !tr.Allow(addr, now) {
+		t.Fatal("Expected a half-open probe")
+	}
+	tr.Observe(addr, "example.com.", 10*time.Millisecond, false, nil, now)
+	st := tr.Snapshot(now)[0]
+	if st.Breaker != Closed || st.ConsecutiveFailures != 0 || !st.OpenUntil.IsZero() {
+		t.Errorf("Expected a closed breaker after a good probe, got %+v", st)
+	}
+	if st.LastError != timeout.Error() || st.Failures != tripAfter+1 {
+		t.Errorf("Expected the failures to be kept, got %+v", st)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	tr := NewTracker([]string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.1:53"})
+	now := time.Unix(1700000000, 0)
+	for i := 1; i <= 100; i++ {
+		tr.Observe("192.0.2.1:53", "broken.example.", time.Duration(i)*time.Millisecond, i%4 == 0, nil, now)
+	}
+
+	stats := tr.Snapshot(now)
+	if len(stats) != 2 || stats[0].Address != "192.0.2.1:53" || stats[1].Address != "192.0.2.2:53" {
+		t.Fatalf("Expected each resolver once in configuration order, got %+v", stats)
+	}
+	st := stats[0]
+	if st.QPS != 100.0/rateSpan || st.ErrorRate != 0 || st.ServfailRate != 0.25 || st.ServfailDomains != 1 || !st.Healthy {
+		t.Errorf("Unexpected rates %+v", st)
+	}
+	if st.LatencyP50 != 51 || st.LatencyP90 != 91 || st.LatencyP99 != 100 {
+		t.Errorf("Unexpected percentiles %v/%v/%v", st.LatencyP50, st.LatencyP90, st.LatencyP99)
+	}
+	if st.Servfails != 25 || st.Failures != 0 || st.LastError != "" {
+		t.Errorf("Expected SERVFAIL for one domain not to count as failures, got %+v", st)
+	}
+
+	// The rate window slides
+	st = tr.Snapshot(now.Add(rateSpan * time.Second))[0]
+	if st.QPS != 0 || st.ErrorRate != 0 {
+		t.Errorf("Expected no recent traffic a minute later, got %+v", st)
+	}
+}
+
+func TestServfailSpread(t *testing.T) {
+	tr := NewTracker([]string{"192.0.2.1:53"})
+	addr := "192.0.2.1:53"
+	now := time.Unix(1700000000, 0)
+
+	// One broken domain, however often it is queried, leaves the
+	// resolver healthy
+	for i := 0; i < 100; i++ {
+		tr.Observe(addr, "broken.example.", time.Millisecond, true, nil, now)
+	}
+	if !tr.Allow(addr, now) || tr.Servfailing(addr, now) {
+		t.Fatal("Expected SERVFAIL for one domain not to count against the resolver")
+	}
+
+	// SERVFAIL across many domains does, and trips the breaker
+	for i := 0; i < servfailSpread+tripAfter; i++ {
+		tr.Observe(addr, fmt.Sprintf("name%d.com.", i), time.Millisecond, true, nil, now)
+	}
+	if !tr.Servfailing(addr, now) || tr.Allow(addr, now) {
+		t.Fatal("Expected SERVFAIL across many domains to open the breaker")
+	}
+	st := tr.Snapshot(now)[0]
+	if st.Failures < tripAfter || st.ServfailDomains != servfailSpread+tripAfter+1 || st.Healthy {
+		t.Errorf("Expected widespread SERVFAIL counted as failures, got %+v", st)
+	}
+
+	// A minute later the spread has aged out
+	if tr.Servfailing(addr, now.Add(rateSpan*time.Second)) {
+		t.Error("Expected old SERVFAIL answers to age out")
+	}
+}
+
+func TestServfailFloodOfOneZone(t *testing.T) {
+	tr := NewTracker([]string{"192.0.2.1:53"})
+	addr := "192.0.2.1:53"
+	now := time.Unix(1700000000, 0)
+
+	// Random names under one lame zone are one registered domain
+	for i := 0; i < 200; i++ {
+		tr.Observe(addr, fmt.Sprintf("x%d.lame.example.com.", i), time.Millisecond, true, nil, now)
+	}
+	if !tr.Allow(addr, now) || tr.Servfailing(addr, now) {
+		t.Fatal("Expected a random-subdomain flood of one zone not to count against the resolver")
+	}
+	if st := tr.Snapshot(now)[0]; st.ServfailDomains != 1 || st.Failures != 0 {
+		t.Errorf("Expected one SERVFAIL domain and no failures, got %+v", st)
+	}
+}
+
+func TestServfailShare(t *testing.T) {
+	tr := NewTracker([]string{"192.0.2.1:53"})
+	addr := "192.0.2.1:53"
+	now := time.Unix(1700000000, 0)
+
+	// Many broken domains among mostly good answers leave it healthy
+	for i := 0; i < 100; i++ {
+		tr.Observe(addr, "good.example.", time.Millisecond, false, nil, now)
+	}
+	for i := 0; i < servfailSpread+tripAfter; i++ {
+		tr.Observe(addr, fmt.Sprintf("name%d.com.", i), time.Millisecond, true, nil, now)
+	}
+	if !tr.Allow(addr, now) || tr.Servfailing(addr, now) {
+		t.Fatal("Expected SERVFAIL for a small share of answers not to count against the resolver")
+	}
+}
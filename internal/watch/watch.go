@@ -0,0 +1,316 @@
+// Package watch tracks the resolution of the domains vital services
+// depend on: each watched domain's success rate and latency are kept on
+// their own, apart from all other traffic, and an event is published when
+// either falls below its objective and when it recovers. Rarely queried
+// domains can be probed, so that the proxy doubles as a resolution health
+// monitor.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+	"ddd/internal/metrics"
+)
+
+var (
+	resolutions = metrics.NewCounterVec("ddd_watch_resolutions_total",
+		"Upstream resolutions of watched domains, by domain and result", "domain", "result")
+	latency = metrics.NewHistogramVec("ddd_watch_latency_seconds",
+		"Upstream resolution time of watched domains", metrics.DefBuckets, "domain")
+	degradedGauge = metrics.NewGaugeVec("ddd_watch_degraded",
+		"1 while a watched domain is below its objectives", "domain")
+)
+
+// maxSamples bounds the resolutions kept per domain; the oldest go first
+const maxSamples = 4096
+
+// Status is the health of one watched domain over the window
+type Status struct {
+	Domain      string  `json:"domain"`
+	Resolutions int     `json:"resolutions"`
+	SuccessRate float64 `json:"success_rate"`
+	// Of successful resolutions, in milliseconds
+	LatencyP50 float64 `json:"latency_p50_ms"`
+	LatencyP95 float64 `json:"latency_p95_ms"`
+	Degraded   bool    `json:"degraded"`
+	Reason     string  `json:"reason,omitempty"`
+	// Since is when the domain became degraded
+	Since       time.Time `json:"since,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+}
+
+// sample is one resolution
+type sample struct {
+	at  time.Time
+	rtt time.Duration
+	ok  bool
+}
+
+// domain is the state of one watched domain
+type domain struct {
+	name           string
+	minSuccess     float64
+	maxLatency     time.Duration
+	minResolutions int
+	probe          time.Duration
+
+	ok, failed *metrics.Counter
+	hist       *metrics.Histogram
+	gauge      *metrics.Gauge
+
+	mu          sync.Mutex
+	samples     []sample
+	degraded    bool
+	reason      string
+	since       time.Time
+	lastSeen    time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+// List is the set of watched domains
+type List struct {
+	interval time.Duration
+	window   time.Duration
+	bus      *events.Bus
+	domains  []*domain // in configuration order
+	byName   map[string]*domain
+}
+
+// New creates the watch list of cfg, publishing degradation on bus. It
+// returns nil when no domain is watched.
+func New(cfg config.WatchConfig, bus *events.Bus) *List {
+	if len(cfg.Domains) == 0 {
+		return nil
+	}
+	l := &List{
+		interval: cfg.Interval,
+		window:   cfg.Window,
+		bus:      bus,
+		byName:   make(map[string]*domain, len(cfg.Domains)),
+	}
+	for _, d := range cfg.Domains {
+		name := normalize(d.Domain)
+		w := &domain{
+			name:           name,
+			minSuccess:     d.MinSuccess,
+			maxLatency:     d.MaxLatency,
+			minResolutions: d.MinResolutions,
+			probe:          d.Probe,
+			ok:             resolutions.With(name, "success"),
+			failed:         resolutions.With(name, "failure"),
+			hist:           latency.With(name),
+			gauge:          degradedGauge.With(name),
+		}
+		if w.minSuccess == 0 {
+			w.minSuccess = cfg.MinSuccess
+		}
+		if w.minResolutions == 0 {
+			w.minResolutions = cfg.MinResolutions
+		}
+		l.domains = append(l.domains, w)
+		l.byName[name] = w
+	}
+	return l
+}
+
+// normalize lowercases a name and drops its trailing dot
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// match returns the watched domain covering name, the closest enclosing
+// one when several do
+func (l *List) match(name string) *domain {
+	name = normalize(name)
+	for {
+		if d, ok := l.byName[name]; ok {
+			return d
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return nil
+		}
+		name = name[i+1:]
+	}
+}
+
+// Record counts an upstream resolution of name that took rtt, if name is
+// watched. ok is false when no answer came or the answer was SERVFAIL or
+// REFUSED. It is safe to call on a nil list.
+func (l *List) Record(name string, rtt time.Duration, ok bool, now time.Time) {
+	if l == nil {
+		return
+	}
+	d := l.match(name)
+	if d == nil {
+		return
+	}
+	if ok {
+		d.ok.Inc()
+		d.hist.Observe(rtt.Seconds())
+	} else {
+		d.failed.Inc()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.samples) >= maxSamples {
+		d.samples = append(d.samples[:0], d.samples[len(d.samples)-maxSamples+1:]...)
+	}
+	d.samples = append(d.samples, sample{at: now, rtt: rtt, ok: ok})
+	d.lastSeen = now
+	if ok {
+		d.lastSuccess = now
+	} else {
+		d.lastFailure = now
+	}
+}
+
+// Run evaluates the watched domains every interval until ctx is
+// cancelled, first resolving through probe those due for a probe
+func (l *List) Run(ctx context.Context, probe func(name string)) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, name := range l.due(now) {
+				probe(name)
+			}
+			l.evaluate(time.Now())
+		}
+	}
+}
+
+// due returns the domains with probing that were not resolved for their
+// probe interval
+func (l *List) due(now time.Time) []string {
+	var names []string
+	for _, d := range l.domains {
+		d.mu.Lock()
+		if d.probe > 0 && now.Sub(d.lastSeen) >= d.probe {
+			names = append(names, d.name)
+		}
+		d.mu.Unlock()
+	}
+	return names
+}
+
+// evaluate judges every domain over the window ending now and publishes
+// changes in their health
+func (l *List) evaluate(now time.Time) {
+	for _, d := range l.domains {
+		st := d.status(now, l.window)
+		degraded, reason := d.judge(st)
+
+		d.mu.Lock()
+		was, since := d.degraded, d.since
+		d.degraded, d.reason = degraded, reason
+		if degraded && !was {
+			d.since = now
+		}
+		d.mu.Unlock()
+
+		switch {
+		case degraded && !was:
+			d.gauge.Set(1)
+			l.bus.Publish(events.Event{
+				Type:     events.WatchDegraded,
+				Domain:   d.name,
+				Reason:   reason,
+				Duration: l.window,
+			})
+		case !degraded && was:
+			d.gauge.Set(0)
+			l.bus.Publish(events.Event{
+				Type:     events.WatchRecovered,
+				Domain:   d.name,
+				Duration: now.Sub(since),
+			})
+		}
+	}
+}
+
+// judge reports whether st falls below the domain's objectives, and how.
+// Too few resolutions to judge leave the domain healthy.
+func (d *domain) judge(st Status) (bool, string) {
+	switch {
+	case st.Resolutions < d.minResolutions:
+		return false, ""
+	case st.SuccessRate < d.minSuccess:
+		return true, fmt.Sprintf("%.1f%% of %d resolutions succeeded, below %.1f%%",
+			st.SuccessRate*100, st.Resolutions, d.minSuccess*100)
+	case d.maxLatency > 0 && st.LatencyP95 > millis(d.maxLatency):
+		return true, fmt.Sprintf("p95 resolution time %.1fms, above %v", st.LatencyP95, d.maxLatency)
+	}
+	return false, ""
+}
+
+// Snapshot returns the health of every watched domain over the window,
+// in configuration order. It is safe to call on a nil list.
+func (l *List) Snapshot(now time.Time) []Status {
+	if l == nil {
+		return nil
+	}
+	out := make([]Status, 0, len(l.domains))
+	for _, d := range l.domains {
+		out = append(out, d.status(now, l.window))
+	}
+	return out
+}
+
+// status summarizes the domain's resolutions over the window ending now,
+// dropping older ones
+func (d *domain) status(now time.Time, window time.Duration) Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	drop := 0
+	for drop < len(d.samples) && now.Sub(d.samples[drop].at) > window {
+		drop++
+	}
+	d.samples = d.samples[drop:]
+
+	st := Status{
+		Domain:      d.name,
+		Resolutions: len(d.samples),
+		Degraded:    d.degraded,
+		Reason:      d.reason,
+		LastSuccess: d.lastSuccess,
+		LastFailure: d.lastFailure,
+	}
+	if d.degraded {
+		st.Since = d.since
+	}
+	rtts := make([]time.Duration, 0, len(d.samples))
+	for _, s := range d.samples {
+		if s.ok {
+			rtts = append(rtts, s.rtt)
+		}
+	}
+	if st.Resolutions > 0 {
+		st.SuccessRate = float64(len(rtts)) / float64(st.Resolutions)
+	}
+	if n := len(rtts); n > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		st.LatencyP50 = millis(rtts[n*50/100])
+		st.LatencyP95 = millis(rtts[n*95/100])
+	}
+	return st
+}
+
+// millis converts a duration to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+	"ddd/internal/events"
+)
+
+func TestDegradeAndRecover(t *testing.T) {
+	bus := events.NewBus()
+	alerts := bus.Subscribe("test", 8)
+	l := New(config.WatchConfig{
+		Interval:       time.Minute,
+		Window:         5 * time.Minute,
+		MinSuccess:     0.9,
+		MinResolutions: 5,
+		Domains: []config.WatchedDomain{
+			{Domain: "Login.Example.com."},
+			{Domain: "api.example.com", MaxLatency: 100 * time.Millisecond},
+		},
+	}, bus)
+
+	now := time.Now()
+	// Subdomains count toward their watched domain
+	for i := 0; i < 10; i++ {
+		l.Record("sso.login.example.com", 10*time.Millisecond, i >= 2, now)
+		l.Record("api.example.com.", 200*time.Millisecond, true, now)
+	}
+	l.Record("example.com", time.Millisecond, false, now)
+
+	l.evaluate(now)
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want 2", len(alerts))
+	}
+	for _, want := range []string{"login.example.com", "api.example.com"} {
+		if e := <-alerts; e.Type != events.WatchDegraded || e.Domain != want {
+			t.Errorf("unexpected event %+v, want %s degraded", e, want)
+		}
+	}
+	st := l.Snapshot(now)
+	if st[0].Resolutions != 10 || st[0].SuccessRate != 0.8 || !st[0].Degraded {
+		t.Errorf("unexpected status %+v", st[0])
+	}
+
+	// Still degraded: no repeat
+	l.evaluate(now.Add(time.Minute))
+	if len(alerts) != 0 {
+		t.Fatalf("repeated alert while degraded")
+	}
+
+	// Once the failures leave the window, too few resolutions remain to
+	// judge and the domain recovers
+	later := now.Add(6 * time.Minute)
+	for i := 0; i < 3; i++ {
+		l.Record("login.example.com", 10*time.Millisecond, true, later)
+	}
+	l.evaluate(later)
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want 2", len(alerts))
+	}
+	if e := <-alerts; e.Type != events.WatchRecovered || e.Domain != "login.example.com" || e.Duration != 6*time.Minute {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestProbeDue(t *testing.T) {
+	l := New(config.WatchConfig{
+		Domains: []config.WatchedDomain{
+			{Domain: "login.example.com", Probe: time.Minute},
+			{Domain: "api.example.com"},
+		},
+	}, events.NewBus())
+
+	now := time.Now()
+	if due := l.due(now); len(due) != 1 || due[0] != "login.example.com" {
+		t.Fatalf("due = %v, want the never resolved probed domain", due)
+	}
+	l.Record("login.example.com", time.Millisecond, true, now)
+	if due := l.due(now.Add(30 * time.Second)); len(due) != 0 {
+		t.Errorf("due = %v, want none within the probe interval", due)
+	}
+	if due := l.due(now.Add(time.Minute)); len(due) != 1 {
+		t.Errorf("due = %v, want a probe after the interval", due)
+	}
+
+	if New(config.WatchConfig{}, nil) != nil {
+		t.Error("expected no list without watched domains")
+	}
+}
@@ -0,0 +1,171 @@
+// Package zones guards an authoritative server the proxy fronts. Names
+// outside the zones it serves are turned away, and each zone's queries
+// are held to a budget across all clients and to a budget per client, so
+// a flood aimed at one zone neither reaches the server nor starves the
+// resolvers asking about the others.
+package zones
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ddd/internal/config"
+)
+
+// Verdict is what the guard decided about a query
+type Verdict int
+
+const (
+	// Allow lets the query through
+	Allow Verdict = iota
+	// OutOfZone is a name in none of the zones
+	OutOfZone
+	// OverZone is a query past its zone's budget
+	OverZone
+	// OverClient is a query past its client's budget in the zone
+	OverClient
+)
+
+const (
+	// clientIdle is how long a client's bucket is kept after its last
+	// query; buckets hold one second of queries, so by then it is full
+	clientIdle = 10 * time.Second
+	// maxClients bounds the clients tracked per zone
+	maxClients = 1 << 16
+)
+
+// bucket is a token bucket holding up to one second of queries
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token for a query at now from a bucket refilled at qps
+func (b *bucket) take(qps float64, now time.Time) bool {
+	burst := max(qps, 1)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*qps)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// zone is one served zone with its budgets; a zero budget is unlimited
+type zone struct {
+	name      string // lower case, without the trailing dot
+	qps       float64
+	clientQPS float64
+
+	mu        sync.Mutex
+	total     bucket
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Guard holds queries to the zones and their budgets
+type Guard struct {
+	zones []*zone // most specific first
+}
+
+// New creates a guard for the configured zones, or returns nil if none
+// are listed
+func New(cfg config.AuthoritativeConfig) *Guard {
+	if len(cfg.Zones) == 0 {
+		return nil
+	}
+	g := &Guard{}
+	for _, z := range cfg.Zones {
+		qps, clientQPS := z.QPS, z.ClientQPS
+		if qps == 0 {
+			qps = cfg.ZoneQPS
+		}
+		if clientQPS == 0 {
+			clientQPS = cfg.ClientQPS
+		}
+		g.zones = append(g.zones, &zone{
+			name:      strings.ToLower(strings.TrimSuffix(z.Name, ".")),
+			qps:       float64(qps),
+			clientQPS: float64(clientQPS),
+			clients:   make(map[string]*bucket),
+		})
+	}
+	sort.Slice(g.zones, func(i, j int) bool {
+		return strings.Count(g.zones[i].name, ".") > strings.Count(g.zones[j].name, ".")
+	})
+	return g
+}
+
+// find returns the most specific zone serving name, or nil
+func (g *Guard) find(name string) *zone {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, z := range g.zones {
+		if name == z.name || strings.HasSuffix(name, "."+z.name) {
+			return z
+		}
+	}
+	return nil
+}
+
+// Zones returns the names of the zones
+func (g *Guard) Zones() []string {
+	if g == nil {
+		return nil
+	}
+	names := make([]string, len(g.zones))
+	for i, z := range g.zones {
+		names[i] = z.name
+	}
+	return names
+}
+
+// Admit decides about a query from client for name at now, and returns
+// the zone serving name ("" if none does). The zone's budget is only
+// charged for queries within the client's.
+func (g *Guard) Admit(client, name string, now time.Time) (string, Verdict) {
+	z := g.find(name)
+	if z == nil {
+		return "", OutOfZone
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.clientQPS > 0 {
+		if now.Sub(z.lastSweep) >= clientIdle {
+			z.sweep(now)
+		}
+		b, ok := z.clients[client]
+		if !ok {
+			if len(z.clients) >= maxClients {
+				z.clients = make(map[string]*bucket)
+			}
+			b = &bucket{}
+			z.clients[client] = b
+		}
+		if !b.take(z.clientQPS, now) {
+			return z.name, OverClient
+		}
+	}
+	if z.qps > 0 && !z.total.take(z.qps, now) {
+		return z.name, OverZone
+	}
+	return z.name, Allow
+}
+
+// sweep forgets the buckets of clients that have gone quiet
+func (z *zone) sweep(now time.Time) {
+	for client, b := range z.clients {
+		if now.Sub(b.last) >= clientIdle {
+			delete(z.clients, client)
+		}
+	}
+	z.lastSweep = now
+}
@@ -0,0 +1,64 @@
+package zones
+
+import (
+	"testing"
+	"time"
+
+	"ddd/internal/config"
+)
+
+func TestGuardMatchesMostSpecificZone(t *testing.T) {
+	g := New(config.AuthoritativeConfig{Zones: []config.AuthZone{
+		{Name: "example.com."},
+		{Name: "eu.example.com", QPS: 1},
+	}})
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, want := range map[string]string{
+		"example.com":        "example.com",
+		"WWW.Example.com.":   "example.com",
+		"ns1.eu.example.com": "eu.example.com",
+		"badexample.com":     "",
+		"example.org":        "",
+	} {
+		zone, verdict := g.Admit("192.0.2.1", name, now)
+		if zone != want || (want == "") != (verdict == OutOfZone) {
+			t.Errorf("%s: expected zone %q, got %q (%v)", name, want, zone, verdict)
+		}
+	}
+}
+
+func TestGuardBudgets(t *testing.T) {
+	g := New(config.AuthoritativeConfig{
+		ZoneQPS:   10,
+		ClientQPS: 4,
+		Zones:     []config.AuthZone{{Name: "example.com"}},
+	})
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// One client runs out of its own budget before the zone's
+	for i := 0; i < 4; i++ {
+		if _, v := g.Admit("192.0.2.1", "a.example.com", now); v != Allow {
+			t.Fatalf("Expected query %d within the client budget, got %v", i+1, v)
+		}
+	}
+	if _, v := g.Admit("192.0.2.1", "a.example.com", now); v != OverClient {
+		t.Fatalf("Expected the 5th query past the client budget, got %v", v)
+	}
+
+	// Others share what is left of the zone's: 10, less the first
+	// client's 4
+	for i, client := range []string{"192.0.2.2", "192.0.2.2", "192.0.2.2", "192.0.2.2", "192.0.2.3", "192.0.2.3"} {
+		if _, v := g.Admit(client, "b.example.com", now); v != Allow {
+			t.Fatalf("Expected query %d of another client admitted, got %v", i+1, v)
+		}
+	}
+	if _, v := g.Admit("192.0.2.3", "c.example.com", now); v != OverZone {
+		t.Errorf("Expected the zone budget spent, got %v", v)
+	}
+
+	// Budgets refill
+	if _, v := g.Admit("192.0.2.1", "a.example.com", now.Add(time.Second)); v != Allow {
+		t.Errorf("Expected the budgets refilled a second later, got %v", v)
+	}
+}
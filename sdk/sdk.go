@@ -0,0 +1,379 @@
+// Package sdk lets trusted internal services coordinate their defenses
+// with ddd through its admin API: check whether a client is blocked,
+// fetch its reputation and report abuse seen elsewhere. Lookups are
+// cached for a short time and failed calls are retried with backoff, so
+// services can check clients on their own request path.
+//
+// Unlike the server's internal packages, it depends only on the standard
+// library and may be imported by other modules.
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a new client
+const (
+	DefaultAttempts = 3
+	DefaultBackoff  = 200 * time.Millisecond
+	DefaultCacheTTL = 30 * time.Second
+	// maxCached bounds the lookups kept in the cache
+	maxCached = 10000
+)
+
+// ReporterTokenHeader carries the token identifying a reporting service
+// to the server, which trusts its reports as configured for it
+const ReporterTokenHeader = "X-Reporter-Token"
+
+// Report actions
+const (
+	ActionBlock     = "block"      // block the client for its severity's block duration
+	ActionRateLimit = "rate_limit" // rate limit the client for the rate limit window
+	ActionNone      = "none"       // only count the report toward its reputation
+)
+
+// Reputation is what the server knows about a client
+type Reputation struct {
+	IP           string       `json:"ip"`
+	Blocked      bool         `json:"blocked"`
+	BlockReason  string       `json:"block_reason,omitempty"`
+	Severity     string       `json:"severity,omitempty"`
+	BlockedUntil *time.Time   `json:"blocked_until,omitempty"`
+	BlockCount   int          `json:"block_count,omitempty"`
+	RateLimited  bool         `json:"rate_limited"`
+	ManualLimit  *ManualLimit `json:"manual_limit,omitempty"`
+	// Window is the span of the traffic counts, the detection window
+	Window     string `json:"window,omitempty"`
+	Requests   int    `json:"requests"`
+	Failures   int    `json:"failures"`
+	NewDomains int    `json:"new_domains"`
+	// Reports counts abuse reports from services within the server's
+	// report window, and RiskScore sums their scores
+	Reports    int        `json:"reports"`
+	RiskScore  float64    `json:"risk_score"`
+	LastReport *time.Time `json:"last_report,omitempty"`
+	// Linked lists the addresses of the other family the server holds to
+	// be the same dual-stack client; their reports count toward Reports
+	// and RiskScore
+	Linked []string `json:"linked,omitempty"`
+}
+
+// ManualLimit is a query rate cap an operator put on a client
+type ManualLimit struct {
+	IP      string    `json:"ip"`
+	QPS     float64   `json:"qps"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+// Report is abuse by a client observed by a service. The server acts on
+// Action as far as it trusts the reporter whose token the client sends.
+type Report struct {
+	IP string `json:"ip"`
+	// Source names the reporting service; the name the server has for the
+	// reporter's token takes its place
+	Source   string `json:"source,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Severity string `json:"severity,omitempty"` // low, medium or high; default low
+	Action   string `json:"action,omitempty"`   // an Action constant; default rate_limit
+	// Evidence is what the service saw, such as log lines, up to 8 KiB;
+	// it is kept in the server's decision journal
+	Evidence string `json:"evidence,omitempty"`
+}
+
+// BulkRequest blocks, unblocks or allowlists many addresses and CIDRs in
+// one call. The fields beside Entries apply to entries that leave them
+// out.
+type BulkRequest struct {
+	Reason    string `json:"reason,omitempty"`
+	Severity  string `json:"severity,omitempty"`  // block: low, medium or high; default none
+	Permanent bool   `json:"permanent,omitempty"` // block: never expire
+	Duration  string `json:"duration,omitempty"`  // allowlist: how long, e.g. "4h"
+	// RequestedBy names the operator or automation asking for allowlist
+	// entries
+	RequestedBy string      `json:"requested_by,omitempty"`
+	Entries     []BulkEntry `json:"entries"`
+}
+
+// BulkEntry is one address or CIDR of a bulk request
+type BulkEntry struct {
+	IP       string `json:"ip"`
+	Reason   string `json:"reason,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// BulkResponse is the outcome of a bulk request entry by entry, in
+// request order
+type BulkResponse struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// BulkResult is the outcome of one entry of a bulk request
+type BulkResult struct {
+	IP    string `json:"ip"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// ExemptionID and ExemptionState describe the exemption an allowlist
+	// entry created
+	ExemptionID    uint64 `json:"exemption_id,omitempty"`
+	ExemptionState string `json:"exemption_state,omitempty"`
+}
+
+// Error is a non-2xx response from the API
+type Error struct {
+	Status  int
+	Message string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.Status, e.Message)
+}
+
+// temporary reports whether the request may succeed if retried
+func (e *Error) temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// Client calls the admin API. It is safe for concurrent use.
+type Client struct {
+	baseURL  string
+	token    string
+	reporter string // reporter token, or empty
+	http     *http.Client
+	attempts int
+	backoff  time.Duration
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+// cached is a reputation and when it stops being fresh
+type cached struct {
+	rep     Reputation
+	expires time.Time
+}
+
+// New creates a client for the API at baseURL (e.g. https://10.0.0.5:8080)
+// authenticating with token (may be empty)
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		token:    token,
+		http:     &http.Client{Timeout: 5 * time.Second},
+		attempts: DefaultAttempts,
+		backoff:  DefaultBackoff,
+		ttl:      DefaultCacheTTL,
+		cache:    make(map[string]cached),
+	}
+}
+
+// WithReporterToken identifies the service to the server as the reporter
+// holding token, whose reports it trusts as configured for that reporter
+func (c *Client) WithReporterToken(token string) *Client {
+	c.reporter = token
+	return c
+}
+
+// WithHTTPClient replaces the underlying HTTP client, e.g. to trust a
+// private CA
+func (c *Client) WithHTTPClient(hc *http.Client) *Client {
+	c.http = hc
+	return c
+}
+
+// WithRetries makes each call up to attempts times, waiting about backoff
+// after the first failure and twice as long after each further one.
+// Network errors, 429 and 5xx responses are retried.
+func (c *Client) WithRetries(attempts int, backoff time.Duration) *Client {
+	c.attempts = max(attempts, 1)
+	c.backoff = backoff
+	return c
+}
+
+// WithCacheTTL keeps reputations for ttl; zero disables the cache
+func (c *Client) WithCacheTTL(ttl time.Duration) *Client {
+	c.ttl = ttl
+	return c
+}
+
+// Reputation returns what the server knows about ip, from the cache while
+// it is fresh
+func (c *Client) Reputation(ctx context.Context, ip string) (*Reputation, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid IP address %q", ip)
+	}
+	ip = addr.String()
+	if rep, ok := c.cached(ip); ok {
+		return rep, nil
+	}
+
+	var rep Reputation
+	if err := c.call(ctx, http.MethodGet, "/api/v1/reputation", url.Values{"ip": {ip}}, nil, &rep); err != nil {
+		return nil, err
+	}
+	c.store(rep)
+	return &rep, nil
+}
+
+// IsBlocked reports whether the server blocks ip
+func (c *Client) IsBlocked(ctx context.Context, ip string) (bool, error) {
+	rep, err := c.Reputation(ctx, ip)
+	if err != nil {
+		return false, err
+	}
+	return rep.Blocked, nil
+}
+
+// Report reports abuse by a client and returns its reputation afterwards,
+// which replaces any cached one. A retried report may be counted twice.
+func (c *Client) Report(ctx context.Context, report Report) (*Reputation, error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	var rep Reputation
+	if err := c.call(ctx, http.MethodPost, "/api/v1/reports", nil, body, &rep); err != nil {
+		return nil, err
+	}
+	c.store(rep)
+	return &rep, nil
+}
+
+// cached returns a fresh cached reputation of ip
+func (c *Client) cached(ip string) (*Reputation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.cache[ip]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	rep := e.rep
+	return &rep, true
+}
+
+// store caches a reputation. A full cache drops its stale entries, or
+// all of them when none are stale.
+func (c *Client) store(rep Reputation) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.cache) >= maxCached {
+		for ip, e := range c.cache {
+			if now.After(e.expires) {
+				delete(c.cache, ip)
+			}
+		}
+		if len(c.cache) >= maxCached {
+			c.cache = make(map[string]cached)
+		}
+	}
+	c.cache[rep.IP] = cached{rep: rep, expires: now.Add(c.ttl)}
+}
+
+// call performs a request, retrying temporary failures, and decodes the
+// JSON response into out
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) error {
+	var err error
+	for attempt := 0; attempt < c.attempts; attempt++ {
+		if attempt > 0 {
+			if werr := c.wait(ctx, attempt); werr != nil {
+				return err
+			}
+		}
+		var data []byte
+		if data, err = c.do(ctx, method, path, query, body); err == nil {
+			return json.Unmarshal(data, out)
+		}
+		var apiErr *Error
+		if errors.As(err, &apiErr) && !apiErr.temporary() || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// wait sleeps before a retry: a random time up to twice the backoff for
+// the attempt, so that services do not retry in step
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	d := c.backoff << (attempt - 1)
+	if d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d)))
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// do performs one request and returns the response body
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte) ([]byte, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var in io.Reader
+	if body != nil {
+		in = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, in)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.reporter != "" {
+		req.Header.Set(ReporterTokenHeader, c.reporter)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var decoded struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &decoded) == nil && decoded.Error != "" {
+			apiErr.Message = decoded.Error
+		}
+		return nil, apiErr
+	}
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReputationRetriesAndCaches(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/reputation" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(Reputation{IP: r.URL.Query().Get("ip"), Blocked: true})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "secret").WithRetries(3, time.Millisecond)
+	blocked, err := c.IsBlocked(context.Background(), "192.0.2.10")
+	if err != nil || !blocked {
+		t.Fatalf("Expected blocked after a retry, got %v, %v", blocked, err)
+	}
+	if _, err := c.Reputation(context.Background(), "192.0.2.10"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected the second lookup from the cache, got %d calls", n)
+	}
+
+	if _, err := New(srv.URL, "secret").Reputation(context.Background(), "not-an-ip"); err == nil {
+		t.Error("Expected an invalid address to be rejected")
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"source must name the reporting service"}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "").WithRetries(3, time.Millisecond).Report(context.Background(), Report{IP: "192.0.2.10"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || apiErr.Message != "source must name the reporting service" {
+		t.Errorf("Expected the bad request error, got %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected one call, got %d", n)
+	}
+}
+
+func TestReportReplacesCachedReputation(t *testing.T) {
+	var reported atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var report Report
+			if err := json.NewDecoder(r.Body).Decode(&report); err != nil || report.Source != "login" {
+				t.Errorf("Unexpected report %+v, %v", report, err)
+			}
+			if got := r.Header.Get(ReporterTokenHeader); got != "login-token" {
+				t.Errorf("Expected the reporter token sent, got %q", got)
+			}
+			reported.Store(true)
+		}
+		json.NewEncoder(w).Encode(Reputation{IP: "192.0.2.10", Blocked: reported.Load()})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "").WithReporterToken("login-token")
+	if blocked, _ := c.IsBlocked(context.Background(), "192.0.2.10"); blocked {
+		t.Fatal("Expected the client not to be blocked before the report")
+	}
+	if _, err := c.Report(context.Background(), Report{IP: "192.0.2.10", Source: "login", Action: ActionBlock}); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := c.IsBlocked(context.Background(), "192.0.2.10"); !blocked {
+		t.Error("Expected the report's reputation to replace the cached one")
+	}
+}
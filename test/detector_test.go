@@ -1,22 +1,24 @@
 package detector
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
-	"ddd/internal/logger"
+	. "ddd/internal/detector"
+	"ddd/internal/logger/loggertest"
 	"ddd/internal/monitor"
 )
 
 func TestHighRequestRate(t *testing.T) {
-	// Create test logger (to /dev/null in tests)
-	log, _ := logger.NewLogger("/tmp/test.log")
+	// Create test logger (entries are kept in memory)
+	log, recorded := loggertest.New(t)
 	detector := NewDDoSDetector(100, log)
 	trafficMonitor := monitor.NewTrafficMonitor()
 
-	// Simulate 150 requests in one minute
+	// Simulate 250 requests in one minute (more than 2x the limit)
 	testIP := "192.168.1.100"
-	for i := 0; i < 150; i++ {
+	for i := 0; i < 250; i++ {
 		trafficMonitor.RecordRequest(testIP, "example.com", "A")
 	}
 
@@ -33,10 +35,14 @@ func TestHighRequestRate(t *testing.T) {
 	if !result.ShouldBlock {
 		t.Error("Expected IP to be blocked for exceeding 2x rate limit")
 	}
+
+	if len(recorded.Events("ddos_detected")) != 1 {
+		t.Error("Expected the detection to be logged")
+	}
 }
 
 func TestRepeatedQueries(t *testing.T) {
-	log, _ := logger.NewLogger("/tmp/test.log")
+	log, _ := loggertest.New(t)
 	detector := NewDDoSDetector(100, log)
 	trafficMonitor := monitor.NewTrafficMonitor()
 
@@ -59,7 +65,7 @@ func TestRepeatedQueries(t *testing.T) {
 }
 
 func TestRandomSubdomainAttack(t *testing.T) {
-	log, _ := logger.NewLogger("/tmp/test.log")
+	log, _ := loggertest.New(t)
 	detector := NewDDoSDetector(100, log)
 	trafficMonitor := monitor.NewTrafficMonitor()
 
@@ -83,7 +89,7 @@ func TestRandomSubdomainAttack(t *testing.T) {
 }
 
 func TestNormalTraffic(t *testing.T) {
-	log, _ := logger.NewLogger("/tmp/test.log")
+	log, _ := loggertest.New(t)
 	detector := NewDDoSDetector(100, log)
 	trafficMonitor := monitor.NewTrafficMonitor()
 
@@ -103,3 +109,183 @@ func TestNormalTraffic(t *testing.T) {
 		t.Errorf("Normal traffic should not be detected as attack, got: %s", result.AttackType)
 	}
 }
+
+func TestNewClientBurst(t *testing.T) {
+	log, _ := loggertest.New(t)
+	detector := NewDDoSDetectorWithThresholds(Thresholds{
+		RateLimit:       100,
+		Window:          time.Minute,
+		NewClientWindow: 10 * time.Second,
+		NewClientLimit:  20,
+	}, log)
+	trafficMonitor := monitor.NewTrafficMonitor()
+
+	testIP := "192.168.1.104"
+
+	// 30 distinct domains straight away: under the steady-state limit
+	// but over the new-client limit
+	domains := []string{"google.com", "github.com", "example.com", "cloudflare.com", "golang.org"}
+	for i := 0; i < 30; i++ {
+		trafficMonitor.RecordRequest(testIP, domains[i%len(domains)], "A")
+	}
+
+	result := detector.AnalyzeTraffic(testIP, trafficMonitor)
+
+	if result.AttackType != "new_client_burst" {
+		t.Errorf("Expected attack type 'new_client_burst', got '%s'", result.AttackType)
+	}
+}
+
+func TestNewDomainRate(t *testing.T) {
+	log, recorded := loggertest.New(t)
+	detector := NewDDoSDetectorWithThresholds(Thresholds{
+		RateLimit:           1000,
+		Window:              time.Minute,
+		NewDomainRate:       10,
+		GlobalNewDomainRate: 10,
+	}, log)
+	retention := monitor.DefaultRetention()
+	retention.SeenDomains = 1000
+	trafficMonitor := monitor.NewTrafficMonitorWithRetention(retention)
+
+	// A name another client already queried is not new
+	trafficMonitor.RecordRequest("192.168.1.110", "popular.example", "A")
+	for i := 0; i < 5; i++ {
+		trafficMonitor.RecordRequest("192.168.1.111", "popular.example", "A")
+	}
+	if result := detector.AnalyzeTraffic("192.168.1.111", trafficMonitor); result.IsAttack {
+		t.Errorf("Known names flagged as %s", result.AttackType)
+	}
+
+	testIP := "192.168.1.112"
+	for i := 0; i < 15; i++ {
+		trafficMonitor.RecordRequest(testIP, fmt.Sprintf("host%d.example", i), "A")
+	}
+
+	result := detector.AnalyzeTraffic(testIP, trafficMonitor)
+	if result.AttackType != "new_domain_rate" {
+		t.Fatalf("Expected attack type 'new_domain_rate', got '%s'", result.AttackType)
+	}
+	if result.ShouldBlock {
+		t.Error("Expected rate limiting below twice the limit")
+	}
+	if len(recorded.Events("new_domain_surge")) != 1 {
+		t.Error("Expected the global new domain surge to be logged once")
+	}
+}
+
+func TestFailurePenalty(t *testing.T) {
+	log, _ := loggertest.New(t)
+	detector := NewDDoSDetectorWithThresholds(Thresholds{
+		RateLimit:      100,
+		Window:         time.Minute,
+		FailurePenalty: 5,
+		FailureFloor:   0.25,
+	}, log)
+	trafficMonitor := monitor.NewTrafficMonitor()
+
+	testIP := "192.168.1.120"
+	for i := 0; i < 40; i++ {
+		trafficMonitor.RecordRequest(testIP, fmt.Sprintf("host%d.example", i%4), "A")
+	}
+	if result := detector.AnalyzeTraffic(testIP, trafficMonitor); result.IsAttack {
+		t.Fatalf("Expected 40 requests to fit the full budget, got %s", result.AttackType)
+	}
+
+	// 15 failures would take 75 off the budget; the floor keeps 25
+	for i := 0; i < 15; i++ {
+		trafficMonitor.RecordFailure(testIP)
+	}
+	result := detector.AnalyzeTraffic(testIP, trafficMonitor)
+	if result.AttackType != "high_request_rate" {
+		t.Fatalf("Expected attack type 'high_request_rate', got '%s'", result.AttackType)
+	}
+	if result.ShouldBlock {
+		t.Error("Expected rate limiting below twice the shaped budget")
+	}
+}
+
+func TestBenignNoise(t *testing.T) {
+	log, _ := loggertest.New(t)
+	detector := NewDDoSDetectorWithThresholds(Thresholds{
+		RateLimit: 100,
+		Window:    time.Minute,
+		Noise: Noise{
+			Names:          []string{"health.example.com"},
+			SearchSuffixes: []string{"cluster.local"},
+		},
+	}, log)
+	trafficMonitor := monitor.NewTrafficMonitor()
+
+	// A pod retrying a name under its search domains, and a monitor
+	// checking its record
+	testIP := "10.244.1.17"
+	for i := 0; i < 20; i++ {
+		trafficMonitor.RecordRequest(testIP, "api.example.com.default.svc.cluster.local", "A")
+		trafficMonitor.RecordRequest(testIP, "HEALTH.example.com.", "A")
+	}
+	if result := detector.AnalyzeTraffic(testIP, trafficMonitor); result.IsAttack {
+		t.Errorf("Expected benign noise to be discounted, got %s", result.AttackType)
+	}
+
+	// Noise does not hide a flood of another name
+	for i := 0; i < 30; i++ {
+		trafficMonitor.RecordRequest(testIP, "victim.example.org", "A")
+	}
+	if result := detector.AnalyzeTraffic(testIP, trafficMonitor); result.AttackType != "repeated_queries" {
+		t.Errorf("Expected repeated queries beside the noise to be detected, got %q", result.AttackType)
+	}
+}
+
+func TestSearchDomainStorm(t *testing.T) {
+	log, _ := loggertest.New(t)
+	detector := NewDDoSDetector(100, log)
+	trafficMonitor := monitor.NewTrafficMonitor()
+
+	// A Kubernetes node resolving external names with ndots:5: every name
+	// is tried under each search domain before itself, twice
+	testIP := "10.0.12.4"
+	search := []string{"default.svc.cluster.local", "svc.cluster.local", "cluster.local"}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 8; i++ {
+			name := fmt.Sprintf("api%d.example.com", i)
+			for _, suffix := range search {
+				trafficMonitor.RecordRequest(testIP, name+"."+suffix, "A")
+			}
+			trafficMonitor.RecordRequest(testIP, name, "A")
+		}
+	}
+
+	result := detector.AnalyzeTraffic(testIP, trafficMonitor)
+	if result.IsAttack {
+		t.Errorf("Expected a search domain storm not to be an attack, got %s", result.AttackType)
+	}
+	if result.Advice == nil || result.Advice.Kind != "search_domain_storm" {
+		t.Fatalf("Expected search domain storm advice, got %+v", result.Advice)
+	}
+	if again := detector.AnalyzeTraffic(testIP, trafficMonitor); again.Advice != nil {
+		t.Error("Expected the advice once per window")
+	}
+}
+
+func TestLoadTightensBudget(t *testing.T) {
+	log, _ := loggertest.New(t)
+	factor := 1.0
+	detector := NewDDoSDetector(100, log).WithLoad(func() float64 { return factor })
+	trafficMonitor := monitor.NewTrafficMonitor()
+
+	testIP := "192.168.1.140"
+	for i := 0; i < 40; i++ {
+		trafficMonitor.RecordRequest(testIP, fmt.Sprintf("host%d.example", i%4), "A")
+	}
+	if result := detector.AnalyzeTraffic(testIP, trafficMonitor); result.IsAttack {
+		t.Fatalf("Expected 40 requests to fit the full budget, got %s", result.AttackType)
+	}
+
+	// At a quarter of the budget, 40 requests exceed 25 but not 50
+	factor = 0.25
+	result := detector.AnalyzeTraffic(testIP, trafficMonitor)
+	if result.AttackType != "high_request_rate" || result.ShouldBlock {
+		t.Errorf("Expected rate limiting under load, got %q, block %v", result.AttackType, result.ShouldBlock)
+	}
+}